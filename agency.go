@@ -2,17 +2,22 @@ package gtfs
 
 import (
 	"encoding/binary"
-	"encoding/csv"
 	"errors"
 	"io"
+
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 // Represents an agency that provides transit services
 type Agency struct {
-	ID       Key
-	Name     string
-	URL      string
-	Timezone string
+	ID       Key    `json:"agency_id"`
+	Name     string `json:"agency_name"`
+	URL      string `json:"agency_url"`
+	Timezone string `json:"agency_timezone"`
+	Lang     string `json:"agency_lang,omitempty"`
+	Phone    string `json:"agency_phone,omitempty"`
+	FareURL  string `json:"agency_fare_url,omitempty"`
+	Email    string `json:"agency_email,omitempty"`
 }
 type AgencyMap map[Key]*Agency
 
@@ -21,15 +26,27 @@ type AgencyMap map[Key]*Agency
 // - Name: 4-byte length + UTF-8 string
 // - URL: 4-byte length + UTF-8 string
 // - Timezone: 4-byte length + UTF-8 string
+// - Lang: 4-byte length + UTF-8 string
+// - Phone: 4-byte length + UTF-8 string
+// - FareURL: 4-byte length + UTF-8 string
+// - Email: 4-byte length + UTF-8 string
 func (a Agency) Encode() []byte {
 	// This assumes ID is handled separately or not part of this particular encoding
 	nameStr := a.Name
 	urlStr := a.URL
 	timezoneStr := a.Timezone
+	langStr := a.Lang
+	phoneStr := a.Phone
+	fareURLStr := a.FareURL
+	emailStr := a.Email
 
 	totalLen := lenBytes + len(nameStr) +
 		lenBytes + len(urlStr) +
-		lenBytes + len(timezoneStr)
+		lenBytes + len(timezoneStr) +
+		lenBytes + len(langStr) +
+		lenBytes + len(phoneStr) +
+		lenBytes + len(fareURLStr) +
+		lenBytes + len(emailStr)
 
 	data := make([]byte, totalLen)
 	offset := 0
@@ -50,7 +67,31 @@ func (a Agency) Encode() []byte {
 	binary.BigEndian.PutUint32(data[offset:], uint32(len(timezoneStr)))
 	offset += lenBytes
 	copy(data[offset:], timezoneStr)
-	// offset += len(timezoneStr) // Not strictly needed for the last field
+	offset += len(timezoneStr)
+
+	// Marshal Lang
+	binary.BigEndian.PutUint32(data[offset:], uint32(len(langStr)))
+	offset += lenBytes
+	copy(data[offset:], langStr)
+	offset += len(langStr)
+
+	// Marshal Phone
+	binary.BigEndian.PutUint32(data[offset:], uint32(len(phoneStr)))
+	offset += lenBytes
+	copy(data[offset:], phoneStr)
+	offset += len(phoneStr)
+
+	// Marshal FareURL
+	binary.BigEndian.PutUint32(data[offset:], uint32(len(fareURLStr)))
+	offset += lenBytes
+	copy(data[offset:], fareURLStr)
+	offset += len(fareURLStr)
+
+	// Marshal Email
+	binary.BigEndian.PutUint32(data[offset:], uint32(len(emailStr)))
+	offset += lenBytes
+	copy(data[offset:], emailStr)
+	// offset += len(emailStr) // Not strictly needed for the last field
 
 	return data
 }
@@ -100,25 +141,124 @@ func (a *Agency) Decode(id Key, data []byte) error {
 	a.Timezone = string(data[offset : offset+int(timezoneLen)])
 	offset += int(timezoneLen)
 
+	// Unmarshal Lang
+	if offset+lenBytes > len(data) {
+		return errors.New("buffer too small for Agency Lang length")
+	}
+	langLen := binary.BigEndian.Uint32(data[offset:])
+	offset += lenBytes
+	if offset+int(langLen) > len(data) {
+		return errors.New("buffer too small for Agency Lang content")
+	}
+	a.Lang = string(data[offset : offset+int(langLen)])
+	offset += int(langLen)
+
+	// Unmarshal Phone
+	if offset+lenBytes > len(data) {
+		return errors.New("buffer too small for Agency Phone length")
+	}
+	phoneLen := binary.BigEndian.Uint32(data[offset:])
+	offset += lenBytes
+	if offset+int(phoneLen) > len(data) {
+		return errors.New("buffer too small for Agency Phone content")
+	}
+	a.Phone = string(data[offset : offset+int(phoneLen)])
+	offset += int(phoneLen)
+
+	// Unmarshal FareURL
+	if offset+lenBytes > len(data) {
+		return errors.New("buffer too small for Agency FareURL length")
+	}
+	fareURLLen := binary.BigEndian.Uint32(data[offset:])
+	offset += lenBytes
+	if offset+int(fareURLLen) > len(data) {
+		return errors.New("buffer too small for Agency FareURL content")
+	}
+	a.FareURL = string(data[offset : offset+int(fareURLLen)])
+	offset += int(fareURLLen)
+
+	// Unmarshal Email
+	if offset+lenBytes > len(data) {
+		return errors.New("buffer too small for Agency Email length")
+	}
+	emailLen := binary.BigEndian.Uint32(data[offset:])
+	offset += lenBytes
+	if offset+int(emailLen) > len(data) {
+		return errors.New("buffer too small for Agency Email content")
+	}
+	a.Email = string(data[offset : offset+int(emailLen)])
+	offset += int(emailLen)
+
 	if offset != len(data) {
 		return errors.New("agency buffer not fully consumed, trailing data exists")
 	}
 	return nil
 }
 
+// EncodeProto serializes the Agency struct (excluding ID) into protobuf wire format.
+// See the Agency message in gtfs.proto.
+func (a Agency) EncodeProto() []byte {
+	var data []byte
+	data = appendProtoString(data, 1, a.Name)
+	data = appendProtoString(data, 2, a.URL)
+	data = appendProtoString(data, 3, a.Timezone)
+	data = appendProtoString(data, 4, a.Lang)
+	data = appendProtoString(data, 5, a.Phone)
+	data = appendProtoString(data, 6, a.FareURL)
+	data = appendProtoString(data, 7, a.Email)
+	return data
+}
+
+// DecodeProto deserializes protobuf wire format data into the Agency struct.
+func (a *Agency) DecodeProto(id Key, data []byte) error {
+	if a == nil {
+		return errors.New("cannot decode into a nil Agency")
+	}
+	*a = Agency{ID: id}
+
+	return decodeProtoFields(data, func(num protowire.Number, v protoValue) error {
+		switch num {
+		case 1:
+			a.Name = v.string()
+		case 2:
+			a.URL = v.string()
+		case 3:
+			a.Timezone = v.string()
+		case 4:
+			a.Lang = v.string()
+		case 5:
+			a.Phone = v.string()
+		case 6:
+			a.FareURL = v.string()
+		case 7:
+			a.Email = v.string()
+		}
+		return nil
+	})
+}
+
 // Load and parse agencies from the GTFS agency.txt file
 func ParseAgencies(file io.Reader) (AgencyMap, error) {
-	// Read file using CSV reader
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	agencies, _, err := ParseAgenciesWithOptions(file, ParseOptions{})
+	return agencies, err
+}
+
+// Load and parse agencies from the GTFS agency.txt file, handling malformed rows according to the given options
+func ParseAgenciesWithOptions(file io.Reader, opts ParseOptions) (AgencyMap, *FileReport, error) {
+	// Read file using CSV parser
+	parser, err := newCSVParser("agency.txt", file, opts)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	agencies := make(AgencyMap)
-	for i, record := range records {
-		if i == 0 {
-			continue // skip header
+	for {
+		record, err := parser.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
 		}
 
 		// Parse record into Agency struct
@@ -127,13 +267,20 @@ func ParseAgencies(file io.Reader) (AgencyMap, error) {
 		url := record[2]
 		timezone := record[3]
 
+		if _, exists := agencies[id]; exists {
+			parser.duplicate(string(id))
+		}
 		agencies[id] = &Agency{
 			ID:       id,
 			Name:     name,
 			URL:      url,
 			Timezone: timezone,
+			Lang:     parser.get(record, "agency_lang"),
+			Phone:    parser.get(record, "agency_phone"),
+			FareURL:  parser.get(record, "agency_fare_url"),
+			Email:    parser.get(record, "agency_email"),
 		}
 	}
 
-	return agencies, nil
+	return agencies, parser.report, nil
 }
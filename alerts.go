@@ -0,0 +1,268 @@
+package gtfs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Suffix of the file holding a database's service alerts
+const alertsFileSuffix = ".alerts"
+
+// Time to wait for the lock on the alerts file before giving up
+const alertsLockTimeout = time.Second
+
+// A period in which an alert is active. A zero start or end leaves the period open on that side.
+type AlertPeriod struct {
+	Start time.Time `json:"start,omitzero"`
+	End   time.Time `json:"end,omitzero"`
+}
+
+// A service alert, e.g. from a GTFS-RT Alert or SIRI-SX situation, affecting routes, stops or trips
+type Alert struct {
+	ID            Key           `json:"alert_id"`
+	Header        string        `json:"header_text"`
+	Description   string        `json:"description_text,omitempty"`
+	URL           string        `json:"url,omitempty"`
+	Cause         string        `json:"cause,omitempty"`  // e.g. "MAINTENANCE", as given by the source
+	Effect        string        `json:"effect,omitempty"` // e.g. "DETOUR", as given by the source
+	RouteIDs      []Key         `json:"route_ids,omitempty"`
+	StopIDs       []Key         `json:"stop_ids,omitempty"`
+	TripIDs       []Key         `json:"trip_ids,omitempty"`
+	ActivePeriods []AlertPeriod `json:"active_periods,omitempty"` // Always active if empty
+}
+
+// Check whether the alert is active at the given time
+func (a *Alert) ActiveAt(t time.Time) bool {
+	if len(a.ActivePeriods) == 0 {
+		return true
+	}
+	for _, period := range a.ActivePeriods {
+		if (period.Start.IsZero() || !t.Before(period.Start)) && (period.End.IsZero() || t.Before(period.End)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check whether every active period of the alert ended before the given time
+func (a *Alert) expiredAt(t time.Time) bool {
+	if len(a.ActivePeriods) == 0 {
+		return false
+	}
+	for _, period := range a.ActivePeriods {
+		if period.End.IsZero() || period.End.After(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a copy of the alert which shares no slices with it
+func (a *Alert) clone() *Alert {
+	c := *a
+	c.RouteIDs = slices.Clone(a.RouteIDs)
+	c.StopIDs = slices.Clone(a.StopIDs)
+	c.TripIDs = slices.Clone(a.TripIDs)
+	c.ActivePeriods = slices.Clone(a.ActivePeriods)
+	return &c
+}
+
+// Service alerts of a database, keyed by ID. Alerts are persisted to a separate file alongside the
+// database, which is only opened while they are loaded or changed.
+type alertLayer struct {
+	mu     sync.RWMutex
+	alerts map[Key]*Alert
+}
+
+// Returns the file holding the alerts of the given database file
+func alertsFile(dbFile string) string {
+	return dbFile + alertsFileSuffix
+}
+
+// Loads the alerts of the given database file, which has none if the file does not exist
+func loadAlerts(dbFile string, opts DBOptions) (*alertLayer, error) {
+	layer := &alertLayer{alerts: make(map[Key]*Alert)}
+
+	path := alertsFile(dbFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return layer, nil
+	}
+
+	db, err := bolt.Open(path, opts.fileMode(), opts.sideFileOptions(true, alertsLockTimeout))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("alerts"))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			alert := &Alert{}
+			err := json.Unmarshal(v, alert)
+			if err != nil {
+				return err
+			}
+			alert.ID = Key(k)
+			layer.alerts[alert.ID] = alert
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return layer, nil
+}
+
+// Persists the alerts and the removal of the alerts with the given IDs in a single transaction, and applies
+// them to the layer. If replace is set, all other alerts are removed.
+func (g *GTFS) writeAlerts(put []*Alert, remove []Key, replace bool) error {
+	if g.filePath == "" {
+		return errors.New("database not open")
+	}
+	if g.alerts == nil {
+		return errors.New("alerts not loaded")
+	}
+	for _, alert := range put {
+		if alert.ID == "" {
+			return errors.New("alert has no ID")
+		}
+	}
+
+	db, err := bolt.Open(alertsFile(g.filePath), g.dbOptions.fileMode(), g.dbOptions.sideFileOptions(false, alertsLockTimeout))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if replace && tx.Bucket([]byte("alerts")) != nil {
+			err := tx.DeleteBucket([]byte("alerts"))
+			if err != nil {
+				return err
+			}
+		}
+		b, err := tx.CreateBucketIfNotExists([]byte("alerts"))
+		if err != nil {
+			return err
+		}
+		for _, id := range remove {
+			err = b.Delete([]byte(id))
+			if err != nil {
+				return err
+			}
+		}
+		for _, alert := range put {
+			data, err := json.Marshal(alert)
+			if err != nil {
+				return err
+			}
+			err = b.Put([]byte(alert.ID), data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	g.alerts.mu.Lock()
+	defer g.alerts.mu.Unlock()
+	if replace {
+		g.alerts.alerts = make(map[Key]*Alert, len(put))
+	}
+	for _, id := range remove {
+		delete(g.alerts.alerts, id)
+	}
+	for _, alert := range put {
+		g.alerts.alerts[alert.ID] = alert.clone()
+	}
+	return nil
+}
+
+// Adds the alerts, replacing any existing alerts with the same IDs
+func (g *GTFS) PutAlerts(alerts ...*Alert) error {
+	return g.writeAlerts(alerts, nil, false)
+}
+
+// Replaces all alerts with the given ones, as when reading a full GTFS-RT alerts feed
+func (g *GTFS) ReplaceAlerts(alerts []*Alert) error {
+	return g.writeAlerts(alerts, nil, true)
+}
+
+// Removes the alert with the given ID
+func (g *GTFS) RemoveAlert(alertID Key) error {
+	return g.writeAlerts(nil, []Key{alertID}, false)
+}
+
+// Removes the alerts whose active periods all ended by the given time, returning the number removed
+func (g *GTFS) PruneAlerts(t time.Time) (int, error) {
+	expired := []Key{}
+	for _, alert := range g.filterAlerts(func(a *Alert) bool { return a.expiredAt(t) }) {
+		expired = append(expired, alert.ID)
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+	return len(expired), g.writeAlerts(nil, expired, false)
+}
+
+// Returns copies of the alerts matching the filter, sorted by ID
+func (g *GTFS) filterAlerts(filter func(a *Alert) bool) []*Alert {
+	alerts := []*Alert{}
+	if g.alerts == nil {
+		return alerts
+	}
+
+	g.alerts.mu.RLock()
+	for _, alert := range g.alerts.alerts {
+		if filter(alert) {
+			alerts = append(alerts, alert.clone())
+		}
+	}
+	g.alerts.mu.RUnlock()
+
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].ID < alerts[j].ID })
+	return alerts
+}
+
+// Returns the alert with the given ID
+func (g *GTFS) GetAlertByID(alertID Key) (*Alert, error) {
+	alerts := g.filterAlerts(func(a *Alert) bool { return a.ID == alertID })
+	if len(alerts) == 0 {
+		return nil, fmt.Errorf("alert %w", ErrNotFound)
+	}
+	return alerts[0], nil
+}
+
+// Returns the alerts active at the given time, sorted by ID
+func (g *GTFS) GetActiveAlerts(t time.Time) []*Alert {
+	return g.filterAlerts(func(a *Alert) bool { return a.ActiveAt(t) })
+}
+
+// Returns the alerts affecting the route which are active at the given time, sorted by ID
+func (g *GTFS) GetAlertsByRouteID(routeID Key, t time.Time) []*Alert {
+	return g.filterAlerts(func(a *Alert) bool { return slices.Contains(a.RouteIDs, routeID) && a.ActiveAt(t) })
+}
+
+// Returns the alerts affecting the stop which are active at the given time, sorted by ID
+func (g *GTFS) GetAlertsByStopID(stopID Key, t time.Time) []*Alert {
+	return g.filterAlerts(func(a *Alert) bool { return slices.Contains(a.StopIDs, stopID) && a.ActiveAt(t) })
+}
+
+// Returns the alerts affecting the trip which are active at the given time, sorted by ID
+func (g *GTFS) GetAlertsByTripID(tripID Key, t time.Time) []*Alert {
+	return g.filterAlerts(func(a *Alert) bool { return slices.Contains(a.TripIDs, tripID) && a.ActiveAt(t) })
+}
@@ -0,0 +1,88 @@
+package gtfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/charmbracelet/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// An alternative name or code for a stop, such as a name used before the stop was renamed
+type StopAlias struct {
+	Alias  string `json:"alias"`
+	StopID Key    `json:"stop_id"`
+}
+
+// Load and parse stop aliases from a CSV file with "alias" and "stop_id" columns
+func ParseStopAliases(file io.Reader) ([]StopAlias, error) {
+	parser, err := newCSVParser("stop aliases", file, ParseOptions{})
+	if err != nil {
+		return nil, err
+	}
+	err = parser.require("alias", "stop_id")
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := []StopAlias{}
+	for {
+		record, err := parser.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		alias := parser.get(record, "alias")
+		stopID := Key(parser.get(record, "stop_id"))
+		if alias == "" || stopID == "" {
+			if err := parser.skip(errors.New("missing alias or stop_id")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		aliases = append(aliases, StopAlias{Alias: alias, StopID: stopID})
+	}
+	return aliases, nil
+}
+
+// Returns the aliases which refer to stops in the given map, logging those which do not
+func filterStopAliases(aliases []StopAlias, stops StopMap) []StopAlias {
+	filtered := make([]StopAlias, 0, len(aliases))
+	for _, alias := range aliases {
+		if _, ok := stops[alias.StopID]; !ok {
+			log.Warnf("Ignoring alias %q for unknown stop %s", alias.Alias, alias.StopID)
+			continue
+		}
+		filtered = append(filtered, alias)
+	}
+	return filtered
+}
+
+// Returns the stop with the given alias
+func (g *GTFS) GetStopByAlias(alias string) (*Stop, error) {
+	var stopID Key
+
+	// Query the database for the stop with the given alias
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("stopAliases"))
+		if b == nil {
+			return fmt.Errorf("stop %w", ErrNotFound)
+		}
+		data := b.Get([]byte(alias))
+		if data == nil {
+			return fmt.Errorf("stop %w", ErrNotFound)
+		}
+		stopID = Key(data)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return g.GetStopByID(stopID)
+}
@@ -0,0 +1,257 @@
+// Package analysis compares observed arrivals with the schedule of a GTFS database, reporting the on-time
+// performance of each route and stop and of each hour of the day.
+//
+//	a, err := analysis.NewAnalyzer(g, analysis.Options{})
+//	...
+//	err = a.ReadCSV(file)
+//	...
+//	err = a.Report().WriteCSV(os.Stdout)
+package analysis
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aaroncutress/gtfs-go"
+)
+
+// Defaults for options which are not set
+const (
+	defaultEarlyThreshold = time.Minute
+	defaultLateThreshold  = 5 * time.Minute
+	defaultMaxDelay       = 2 * time.Hour
+)
+
+// Options controlling how observations are classified
+type Options struct {
+	EarlyThreshold time.Duration // Arrivals more than this early are early (defaults to 1 minute)
+	LateThreshold  time.Duration // Arrivals more than this late are late (defaults to 5 minutes)
+	MaxDelay       time.Duration // Observations further than this from the schedule are unmatched (defaults to 2 hours)
+}
+
+// An arrival of a trip at a stop observed in service
+type Observation struct {
+	TripID      gtfs.Key  `json:"trip_id"`
+	StopID      gtfs.Key  `json:"stop_id"`
+	Time        time.Time `json:"time"`         // Observed arrival time
+	ServiceDate time.Time `json:"service_date"` // Service date of the trip, or zero to take the closest scheduled run
+}
+
+// Joins observed arrivals with the schedule, accumulating a report.
+// An Analyzer is not safe for concurrent use.
+type Analyzer struct {
+	g      *gtfs.GTFS
+	opts   Options
+	loc    *time.Location
+	trips  map[gtfs.Key]*gtfs.Trip // Trips read so far, nil for those not found
+	report *Report
+}
+
+// Create a new Analyzer comparing observations with the schedule of the GTFS database
+func NewAnalyzer(g *gtfs.GTFS, opts Options) (*Analyzer, error) {
+	if opts.EarlyThreshold <= 0 {
+		opts.EarlyThreshold = defaultEarlyThreshold
+	}
+	if opts.LateThreshold <= 0 {
+		opts.LateThreshold = defaultLateThreshold
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = defaultMaxDelay
+	}
+
+	// All agencies in a feed share the same timezone
+	agencies, err := g.GetAllAgencies()
+	if err != nil {
+		return nil, err
+	}
+	var loc *time.Location
+	for _, agency := range agencies {
+		loc, err = gtfs.LoadLocation(agency.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+	if loc == nil {
+		return nil, errors.New("no agencies found")
+	}
+
+	return &Analyzer{
+		g:      g,
+		opts:   opts,
+		loc:    loc,
+		trips:  make(map[gtfs.Key]*gtfs.Trip),
+		report: newReport(),
+	}, nil
+}
+
+// Returns the trip with the given ID, or nil if it does not exist
+func (a *Analyzer) trip(tripID gtfs.Key) (*gtfs.Trip, error) {
+	if trip, ok := a.trips[tripID]; ok {
+		return trip, nil
+	}
+	trip, err := a.g.GetTripByID(tripID)
+	if err != nil {
+		if !errors.Is(err, gtfs.ErrNotFound) {
+			return nil, err
+		}
+		trip = nil
+	}
+	a.trips[tripID] = trip
+	return trip, nil
+}
+
+// Returns the scheduled arrival closest to the observation, and false if there is none within MaxDelay
+func (a *Analyzer) scheduledArrival(trip *gtfs.Trip, o Observation) (time.Time, bool) {
+	dates := []time.Time{o.ServiceDate}
+	if o.ServiceDate.IsZero() {
+		// Trips past midnight belong to the previous service date
+		observed := o.Time.In(a.loc)
+		dates = []time.Time{observed, observed.AddDate(0, 0, -1)}
+	}
+
+	var best time.Time
+	bestDelay := a.opts.MaxDelay
+	found := false
+	for _, date := range dates {
+		for _, stop := range trip.Stops {
+			if stop.StopID != o.StopID {
+				continue
+			}
+			scheduled := gtfs.ResolveServiceTime(date, stop.ArrivalTime, a.loc)
+			delay := o.Time.Sub(scheduled).Abs()
+			if delay <= bestDelay {
+				best, bestDelay, found = scheduled, delay, true
+			}
+		}
+	}
+	return best, found
+}
+
+// Add an observed arrival to the report. Observations of unknown trips, of stops the trip does not call
+// at, or too far from the schedule are counted as unmatched. Errors are only returned for failed queries.
+func (a *Analyzer) Add(o Observation) error {
+	trip, err := a.trip(o.TripID)
+	if err != nil {
+		return err
+	}
+	if trip == nil {
+		a.report.Unmatched++
+		return nil
+	}
+	scheduled, ok := a.scheduledArrival(trip, o)
+	if !ok {
+		a.report.Unmatched++
+		return nil
+	}
+
+	delay := o.Time.Sub(scheduled)
+	class := onTimeClass
+	switch {
+	case delay < -a.opts.EarlyThreshold:
+		class = earlyClass
+	case delay > a.opts.LateThreshold:
+		class = lateClass
+	}
+
+	a.report.Overall.add(delay, class)
+	a.report.stats(a.report.Routes, trip.RouteID).add(delay, class)
+	a.report.stats(a.report.Stops, o.StopID).add(delay, class)
+	hour := scheduled.In(a.loc).Hour()
+	if a.report.Hours[hour] == nil {
+		a.report.Hours[hour] = &Stats{}
+	}
+	a.report.Hours[hour].add(delay, class)
+	return nil
+}
+
+// Read observations from a CSV file with "trip_id", "stop_id" and "time" columns, and an optional
+// "service_date" column, adding each to the report. Times are written in RFC 3339 format or as Unix
+// seconds, and service dates as YYYYMMDD or YYYY-MM-DD in the feed's timezone.
+func (a *Analyzer) ReadCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+	for _, name := range []string{"trip_id", "stop_id", "time"} {
+		if _, ok := columns[name]; !ok {
+			return errors.New("missing column " + name)
+		}
+	}
+	get := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		o := Observation{
+			TripID: gtfs.Key(get(record, "trip_id")),
+			StopID: gtfs.Key(get(record, "stop_id")),
+		}
+		o.Time, err = parseObservedTime(get(record, "time"))
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if date := get(record, "service_date"); date != "" {
+			o.ServiceDate, err = parseServiceDate(date, a.loc)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+
+		err = a.Add(o)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Parse an observed time written in RFC 3339 format or as Unix seconds
+func parseObservedTime(s string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errors.New("invalid time " + strconv.Quote(s))
+	}
+	return t, nil
+}
+
+// Parse a service date written as YYYYMMDD or YYYY-MM-DD
+func parseServiceDate(s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range []string{"20060102", "2006-01-02"} {
+		if date, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, errors.New("invalid service date " + strconv.Quote(s))
+}
+
+// Returns the report of the observations added so far
+func (a *Analyzer) Report() *Report {
+	return a.report
+}
@@ -0,0 +1,147 @@
+package analysis
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aaroncutress/gtfs-go"
+)
+
+// Classification of an arrival against the schedule
+type arrivalClass uint8
+
+const (
+	earlyClass arrivalClass = iota
+	onTimeClass
+	lateClass
+)
+
+// On-time performance of a set of observed arrivals
+type Stats struct {
+	Observations int           `json:"observations"`
+	Early        int           `json:"early"`
+	OnTime       int           `json:"on_time"`
+	Late         int           `json:"late"`
+	TotalDelay   time.Duration `json:"total_delay"` // Sum of the delays, with early arrivals negative
+}
+
+// Count an arrival with the given delay
+func (s *Stats) add(delay time.Duration, class arrivalClass) {
+	s.Observations++
+	s.TotalDelay += delay
+	switch class {
+	case earlyClass:
+		s.Early++
+	case onTimeClass:
+		s.OnTime++
+	case lateClass:
+		s.Late++
+	}
+}
+
+// Returns the fraction of arrivals which were on time, or zero if there were none
+func (s *Stats) OnTimeRate() float64 {
+	if s.Observations == 0 {
+		return 0
+	}
+	return float64(s.OnTime) / float64(s.Observations)
+}
+
+// Returns the mean delay of the arrivals, or zero if there were none
+func (s *Stats) MeanDelay() time.Duration {
+	if s.Observations == 0 {
+		return 0
+	}
+	return s.TotalDelay / time.Duration(s.Observations)
+}
+
+// On-time performance of observed arrivals, overall and by route, stop and hour
+type Report struct {
+	Overall   Stats               `json:"overall"`
+	Routes    map[gtfs.Key]*Stats `json:"routes"`
+	Stops     map[gtfs.Key]*Stats `json:"stops"`
+	Hours     map[int]*Stats      `json:"hours"`     // By hour of the scheduled arrival in the feed's timezone
+	Unmatched int                 `json:"unmatched"` // Observations which could not be joined with the schedule
+}
+
+func newReport() *Report {
+	return &Report{
+		Routes: make(map[gtfs.Key]*Stats),
+		Stops:  make(map[gtfs.Key]*Stats),
+		Hours:  make(map[int]*Stats),
+	}
+}
+
+// Returns the stats with the given ID in the map, adding them if missing
+func (r *Report) stats(m map[gtfs.Key]*Stats, id gtfs.Key) *Stats {
+	s, ok := m[id]
+	if !ok {
+		s = &Stats{}
+		m[id] = s
+	}
+	return s
+}
+
+// Write the report as a CSV file with a row for the overall stats, then each route, stop and hour
+// in order, with delays in seconds
+func (r *Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{"group", "id", "observations", "early", "on_time", "late", "on_time_rate", "mean_delay"})
+	if err != nil {
+		return err
+	}
+	write := func(group, id string, s *Stats) error {
+		return writer.Write([]string{
+			group,
+			id,
+			strconv.Itoa(s.Observations),
+			strconv.Itoa(s.Early),
+			strconv.Itoa(s.OnTime),
+			strconv.Itoa(s.Late),
+			strconv.FormatFloat(s.OnTimeRate(), 'f', 4, 64),
+			strconv.FormatFloat(s.MeanDelay().Seconds(), 'f', 1, 64),
+		})
+	}
+
+	err = write("overall", "", &r.Overall)
+	if err != nil {
+		return err
+	}
+	for _, group := range []struct {
+		name  string
+		stats map[gtfs.Key]*Stats
+	}{
+		{"route", r.Routes},
+		{"stop", r.Stops},
+	} {
+		ids := make([]gtfs.Key, 0, len(group.stats))
+		for id := range group.stats {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids {
+			err = write(group.name, string(id), group.stats[id])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	hours := make([]int, 0, len(r.Hours))
+	for hour := range r.Hours {
+		hours = append(hours, hour)
+	}
+	sort.Ints(hours)
+	for _, hour := range hours {
+		err = write("hour", strconv.Itoa(hour), r.Hours[hour])
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
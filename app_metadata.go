@@ -0,0 +1,109 @@
+package gtfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket holding the metadata stored by applications
+const appMetadataBucket = "appMetadata"
+
+// Stores a value set by the application under the key, such as when it last sent a notification, replacing
+// any existing value. Application metadata is kept in the database itself, and is carried over when the
+// database is refreshed. The database must have been opened with DBOptions.ReadWrite.
+func (g *GTFS) SetAppMetadata(key, value []byte) error {
+	err := g.checkWritable()
+	if err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return errors.New("app metadata key is empty")
+	}
+
+	return g.openDB().Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(appMetadataBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put(key, value)
+	})
+}
+
+// Returns the value stored by the application under the key
+func (g *GTFS) GetAppMetadata(key []byte) ([]byte, error) {
+	var value []byte
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(appMetadataBucket))
+		if b == nil {
+			return fmt.Errorf("app metadata %w", ErrNotFound)
+		}
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("app metadata %w", ErrNotFound)
+		}
+		value = bytes.Clone(data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Removes the value stored by the application under the key, if any.
+// The database must have been opened with DBOptions.ReadWrite.
+func (g *GTFS) DeleteAppMetadata(key []byte) error {
+	err := g.checkWritable()
+	if err != nil {
+		return err
+	}
+
+	return g.openDB().Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(appMetadataBucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete(key)
+	})
+}
+
+// Copies the application metadata of the database into the database file, which must not be open
+func (g *GTFS) copyAppMetadata(dbFile string, opts DBOptions) error {
+	metadata := make(map[string][]byte)
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(appMetadataBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			metadata[string(k)] = bytes.Clone(v)
+			return nil
+		})
+	})
+	if err != nil || len(metadata) == 0 {
+		return err
+	}
+
+	db, err := bolt.Open(dbFile, opts.fileMode(), opts.boltOptions(false))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(appMetadataBucket))
+		if err != nil {
+			return err
+		}
+		for k, v := range metadata {
+			err = b.Put([]byte(k), v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
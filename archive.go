@@ -0,0 +1,175 @@
+package gtfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Suffix of the directory holding the archived versions of a database file
+const archiveDirSuffix = ".archive"
+
+// How long to wait for a lock on a database file being archived
+const archiveLockTimeout = time.Second
+
+// A previous version of a GTFS database retained by archival mode
+type ArchivedVersion struct {
+	Path    string
+	Created time.Time
+}
+
+// Returns the directory holding the archived versions of the given database file
+func archiveDir(dbFile string) string {
+	return dbFile + archiveDirSuffix
+}
+
+// Reads the creation timestamp from the metadata of a database file
+func readCreated(dbFile string) (int64, error) {
+	db, err := bolt.Open(dbFile, 0600, &bolt.Options{ReadOnly: true, Timeout: archiveLockTimeout})
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var created int64
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("metadata"))
+		if b == nil {
+			return errors.New("metadata bucket not found")
+		}
+		data := b.Get([]byte("created"))
+		if data == nil {
+			return errors.New("created timestamp not found in metadata")
+		}
+		created, err = strconv.ParseInt(string(data), 10, 64)
+		return err
+	})
+	return created, err
+}
+
+// Returns the archived versions of the given database file, oldest first
+func listArchivedVersions(dbFile string) ([]ArchivedVersion, error) {
+	dir := archiveDir(dbFile)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []ArchivedVersion{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []ArchivedVersion{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".db")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		created, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, ArchivedVersion{
+			Path:    filepath.Join(dir, entry.Name()),
+			Created: time.Unix(created, 0),
+		})
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Created.Before(versions[j].Created)
+	})
+	return versions, nil
+}
+
+// Moves an existing database file into its archive directory, named by its creation time,
+// then removes the oldest archived versions so that at most keep versions remain
+func archiveDB(dbFile string, keep int) error {
+	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
+		return nil
+	}
+
+	created, err := readCreated(dbFile)
+	if err != nil {
+		return err
+	}
+	return archiveCreatedDB(dbFile, created, keep)
+}
+
+// Moves the database file, created at the given time, into the archive as with archiveDB. The file may be open.
+func archiveCreatedDB(dbFile string, created int64, keep int) error {
+	dir := archiveDir(dbFile)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	archivePath := filepath.Join(dir, strconv.FormatInt(created, 10)+".db")
+	err = os.Rename(dbFile, archivePath)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Archived GTFS database to %s", archivePath)
+
+	versions, err := listArchivedVersions(dbFile)
+	if err != nil {
+		return err
+	}
+	for len(versions) > keep {
+		err = os.Remove(versions[0].Path)
+		if err != nil {
+			return err
+		}
+		log.Debugf("Removed archived GTFS database %s", versions[0].Path)
+		versions = versions[1:]
+	}
+	return nil
+}
+
+// Returns the archived versions of the database, oldest first
+func (g *GTFS) GetArchivedVersions() ([]ArchivedVersion, error) {
+	if g.filePath == "" {
+		return nil, errors.New("database not open")
+	}
+	return listArchivedVersions(g.filePath)
+}
+
+// Opens the version of the database which was current at the given time, i.e. the newest
+// version created at or before it, from the current database and its archived versions.
+// The returned GTFS is opened separately and must be closed after use.
+func (g *GTFS) OpenAsOf(t time.Time) (*GTFS, error) {
+	if g.filePath == "" {
+		return nil, errors.New("database not open")
+	}
+
+	path := ""
+	if g.Created <= t.Unix() {
+		path = g.filePath
+	} else {
+		versions, err := listArchivedVersions(g.filePath)
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range versions {
+			if version.Created.After(t) {
+				break
+			}
+			path = version.Path
+		}
+	}
+	if path == "" {
+		return nil, errors.New("no database version found for " + t.Format(time.RFC3339))
+	}
+
+	historical := &GTFS{}
+	err := historical.FromDB(path)
+	if err != nil {
+		historical.Close()
+		return nil, err
+	}
+	return historical, nil
+}
@@ -0,0 +1,51 @@
+package gtfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Writes a consistent copy of the database to w, which can be restored with Restore.
+// The database remains available for queries while the copy is written.
+func (g *GTFS) Backup(w io.Writer) error {
+	return g.view(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+}
+
+// Restores a database written by Backup to dbFile, replacing any existing file. The backup is
+// written to a temporary file and checked before it replaces dbFile, so a failed restore leaves
+// the existing database untouched. The restored database can then be opened with FromDB.
+func Restore(r io.Reader, dbFile string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dbFile), filepath.Base(dbFile)+".restore-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	// Make sure the backup is a GTFS database before replacing the existing file
+	_, err = readCreated(tmpPath)
+	if err != nil {
+		return errors.New("invalid backup: " + err.Error())
+	}
+
+	return os.Rename(tmpPath, dbFile)
+}
@@ -0,0 +1,56 @@
+// Package bench provides synthetic feeds of fixed sizes for benchmarking ingest and queries, so that changes
+// to encodings and indexes can be compared against a baseline. The feeds are generated deterministically by
+// gtfstest, so results are reproducible across machines and runs.
+//
+//	go test -run '^$' -bench . -benchmem -count 10 ./bench > old.txt
+//	...
+//	go test -run '^$' -bench . -benchmem -count 10 ./bench > new.txt
+//	benchstat old.txt new.txt
+//
+// The feed with a million stop times is only benchmarked with the -large flag.
+package bench
+
+import (
+	"time"
+
+	"github.com/aaroncutress/gtfs-go/gtfstest"
+)
+
+// Number of stops on each route of every feed
+const stopsPerRoute = 20
+
+// Length of the part of the service day in which each route's trips depart
+const serviceSpan = 18 * time.Hour
+
+// A synthetic feed of a fixed size
+type Size struct {
+	Name    string           // Name of the size, used as the name of sub-benchmarks
+	Options gtfstest.Options // Options generating the feed
+}
+
+// Returns the size with the given number of routes and trips per route, with the trips departing
+// evenly through the service day
+func newSize(name string, routes, tripsPerRoute int) Size {
+	return Size{
+		Name: name,
+		Options: gtfstest.Options{
+			Routes:        routes,
+			TripsPerRoute: tripsPerRoute,
+			StopsPerRoute: stopsPerRoute,
+			Headway:       serviceSpan / time.Duration(tripsPerRoute),
+			StopInterval:  2 * time.Minute,
+		},
+	}
+}
+
+// Sizes of the benchmarked feeds, named by their number of stop times, from smallest to largest
+var Sizes = []Size{
+	newSize("10k", 20, 25),
+	newSize("100k", 50, 100),
+	newSize("1M", 200, 250),
+}
+
+// Returns the number of stop times in the feed of the size
+func (s Size) StopTimes() int {
+	return s.Options.Routes * s.Options.TripsPerRoute * s.Options.StopsPerRoute
+}
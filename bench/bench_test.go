@@ -0,0 +1,121 @@
+package bench
+
+import (
+	"flag"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aaroncutress/gtfs-go"
+	"github.com/aaroncutress/gtfs-go/gtfstest"
+)
+
+var large = flag.Bool("large", false, "also benchmark the feed with a million stop times")
+
+// Time at which current trips are found, fixed so that results do not depend on when benchmarks run
+var benchTime = time.Date(2025, 3, 12, 8, 0, 0, 0, time.UTC)
+
+// Returns the sizes to benchmark
+func sizes() []Size {
+	if *large {
+		return Sizes
+	}
+	return Sizes[:len(Sizes)-1]
+}
+
+// Returns the feed of the size loaded into a database, which is closed when the benchmark finishes
+func open(b *testing.B, size Size, opts gtfs.IngestOptions) *gtfs.GTFS {
+	b.Helper()
+
+	g := &gtfs.GTFS{}
+	err := g.FromFeed(gtfstest.NewFeed(size.Options), filepath.Join(b.TempDir(), "gtfs.db"), opts)
+	if err != nil {
+		b.Fatalf("Failed to create database: %v", err)
+	}
+	b.Cleanup(func() {
+		g.Close()
+	})
+	return g
+}
+
+func TestSizes(t *testing.T) {
+	expected := []int{10000, 100000, 1000000}
+	for i, size := range Sizes {
+		if size.StopTimes() != expected[i] || size.Name == "" {
+			t.Fatalf("Expected size %s to have %d stop times, got %d", size.Name, expected[i], size.StopTimes())
+		}
+	}
+}
+
+func BenchmarkIngest(b *testing.B) {
+	for _, size := range sizes() {
+		b.Run(size.Name, func(b *testing.B) {
+			feed := gtfstest.NewFeed(size.Options)
+			dir := b.TempDir()
+			i := 0
+			for b.Loop() {
+				g := &gtfs.GTFS{}
+				err := g.FromFeed(feed, filepath.Join(dir, strconv.Itoa(i)+".db"), gtfs.IngestOptions{})
+				if err != nil {
+					b.Fatalf("Failed to create database: %v", err)
+				}
+				g.Close()
+				i++
+			}
+		})
+	}
+}
+
+func BenchmarkGetAllTrips(b *testing.B) {
+	for _, size := range sizes() {
+		b.Run(size.Name, func(b *testing.B) {
+			g := open(b, size, gtfs.IngestOptions{})
+			for b.Loop() {
+				_, err := g.GetAllTrips()
+				if err != nil {
+					b.Fatalf("Failed to get trips: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetCurrentTrips(b *testing.B) {
+	for _, size := range sizes() {
+		b.Run(size.Name, func(b *testing.B) {
+			g := open(b, size, gtfs.IngestOptions{})
+			trips, err := g.GetAllTrips()
+			if err != nil {
+				b.Fatalf("Failed to get trips: %v", err)
+			}
+			for b.Loop() {
+				_, err := g.GetCurrentTripsAt(trips, benchTime)
+				if err != nil {
+					b.Fatalf("Failed to get current trips: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetNearestStops(b *testing.B) {
+	for _, size := range sizes() {
+		for _, projected := range []bool{false, true} {
+			name := size.Name + "/haversine"
+			if projected {
+				name = size.Name + "/projected"
+			}
+			b.Run(name, func(b *testing.B) {
+				g := open(b, size, gtfs.IngestOptions{ProjectStops: projected})
+				coord := gtfs.Coordinate{Latitude: -31.98, Longitude: 115.9}
+				for b.Loop() {
+					_, err := g.GetNearestStops(coord, 10, 1000)
+					if err != nil {
+						b.Fatalf("Failed to get nearest stops: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package gtfs
+
+import (
+	"slices"
+	"sort"
+)
+
+// Stops served by some of a route's trips in one direction before or after its trunk
+type RouteBranch struct {
+	Name        string   `json:"name"`         // Named after the branch's terminus, and its stop nearest the trunk if the terminus is shared
+	StopIDs     KeyArray `json:"stop_ids"`     // In travel order, excluding the junction
+	JunctionID  Key      `json:"junction_id"`  // Trunk stop at which the branch joins or leaves the trunk
+	BeforeTrunk bool     `json:"before_trunk"` // Whether trips run along the branch before joining the trunk
+	Frequency   int      `json:"frequency"`    // Number of trips serving the branch
+}
+
+// Trunk and branches of a route in one direction, for drawing line diagrams
+type RouteBranches struct {
+	RouteID   Key           `json:"route_id"`
+	Direction TripDirection `json:"direction"`
+	Trunk     KeyArray      `json:"trunk"`    // Stops served by every diverging pattern, in travel order
+	Branches  []RouteBranch `json:"branches"` // Ordered by frequency (most common first), then by name
+}
+
+// Check if the stop sequence appears contiguously within another
+func containsStopRun(sequence, run KeyArray) bool {
+	for i := 0; i+len(run) <= len(sequence); i++ {
+		if slices.Equal(sequence[i:i+len(run)], run) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the patterns which diverge from every other, dropping those which only serve part of
+// another pattern's stops (such as short workings), since they do not branch off
+func divergingPatterns(patterns []*StopPattern) []*StopPattern {
+	diverging := []*StopPattern{}
+	for i, pattern := range patterns {
+		contained := false
+		for j, other := range patterns {
+			if i == j || len(other.StopIDs) < len(pattern.StopIDs) {
+				continue
+			}
+			// Identical sequences cannot occur, as patterns are grouped by sequence
+			if containsStopRun(other.StopIDs, pattern.StopIDs) {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			diverging = append(diverging, pattern)
+		}
+	}
+	return diverging
+}
+
+// Returns the longest run of the primary pattern's stops which every pattern serves
+func findTrunk(primary *StopPattern, patterns []*StopPattern) (start, end int) {
+	runStart := 0
+	for i, stopID := range primary.StopIDs {
+		shared := true
+		for _, pattern := range patterns {
+			if !slices.Contains(pattern.StopIDs, stopID) {
+				shared = false
+				break
+			}
+		}
+		if !shared {
+			runStart = i + 1
+			continue
+		}
+		if i+1-runStart > end-start {
+			start, end = runStart, i+1
+		}
+	}
+	return start, end
+}
+
+// Detect the trunk and branches of the trips' stop patterns. Trips without any stops are ignored.
+func detectBranches(trips []*Trip) (KeyArray, []RouteBranch) {
+	trips = slices.DeleteFunc(slices.Clone(trips), func(trip *Trip) bool {
+		return len(trip.Stops) == 0
+	})
+	patterns := divergingPatterns(groupStopPatterns(trips))
+	if len(patterns) == 0 {
+		return KeyArray{}, []RouteBranch{}
+	}
+
+	primary := patterns[0]
+	start, end := findTrunk(primary, patterns)
+	trunk := slices.Clone(primary.StopIDs[start:end])
+	if len(trunk) == 0 {
+		// Without shared stops each pattern is a branch of its own
+		branches := make([]RouteBranch, len(patterns))
+		for i, pattern := range patterns {
+			branches[i] = RouteBranch{StopIDs: slices.Clone(pattern.StopIDs), Frequency: pattern.Frequency}
+		}
+		return trunk, branches
+	}
+
+	// Split each pattern at the ends of the trunk, merging identical branches
+	merged := make(map[string]*RouteBranch)
+	addBranch := func(stopIDs KeyArray, junctionID Key, beforeTrunk bool, frequency int) {
+		if len(stopIDs) == 0 {
+			return
+		}
+		key := string(stopIDs.Encode())
+		if beforeTrunk {
+			key = "<" + key
+		}
+		branch, ok := merged[key]
+		if !ok {
+			branch = &RouteBranch{StopIDs: slices.Clone(stopIDs), JunctionID: junctionID, BeforeTrunk: beforeTrunk}
+			merged[key] = branch
+		}
+		branch.Frequency += frequency
+	}
+	for _, pattern := range patterns {
+		first := slices.Index(pattern.StopIDs, trunk[0])
+		last := slices.Index(pattern.StopIDs, trunk[len(trunk)-1])
+		if first == -1 || last == -1 || last < first {
+			continue
+		}
+		addBranch(pattern.StopIDs[:first], trunk[0], true, pattern.Frequency)
+		addBranch(pattern.StopIDs[last+1:], trunk[len(trunk)-1], false, pattern.Frequency)
+	}
+
+	branches := make([]RouteBranch, 0, len(merged))
+	for _, branch := range merged {
+		branches = append(branches, *branch)
+	}
+	return trunk, branches
+}
+
+// Returns the trunk and branches of the route in each direction it runs. The trunk is the longest run of stops
+// of the most common pattern which every diverging pattern serves, and each pattern's stops before and after it
+// form branches. Patterns serving only part of another pattern, such as short workings, are not branches.
+// Branches are named after their terminus, adding their stop nearest the trunk if the terminus is shared.
+func (g *GTFS) GetRouteBranches(routeID Key) ([]*RouteBranches, error) {
+	trips, err := g.GetTripsByRouteID(routeID)
+	if err != nil {
+		return nil, err
+	}
+
+	byDirection := make(map[TripDirection][]*Trip)
+	for _, trip := range trips {
+		byDirection[trip.Direction] = append(byDirection[trip.Direction], trip)
+	}
+
+	result := []*RouteBranches{}
+	var stopIDs []Key
+	for _, direction := range []TripDirection{OutboundTripDirection, InboundTripDirection} {
+		if len(byDirection[direction]) == 0 {
+			continue
+		}
+		trunk, branches := detectBranches(byDirection[direction])
+		result = append(result, &RouteBranches{
+			RouteID:   routeID,
+			Direction: direction,
+			Trunk:     trunk,
+			Branches:  branches,
+		})
+		for _, branch := range branches {
+			stopIDs = append(stopIDs, branch.StopIDs...)
+			if branch.JunctionID != "" {
+				stopIDs = append(stopIDs, branch.JunctionID)
+			}
+		}
+	}
+
+	stops, err := g.GetStopsByIDs(stopIDs)
+	if err != nil {
+		return nil, err
+	}
+	stopName := func(stopID Key) string {
+		if stop, ok := stops[stopID]; ok {
+			return stop.Name
+		}
+		return string(stopID)
+	}
+
+	for _, directionBranches := range result {
+		branches := directionBranches.Branches
+
+		// Name each branch after its terminus, which is its first stop if it joins the trunk
+		termini := make(map[string]int)
+		for i := range branches {
+			terminus := branches[i].StopIDs[len(branches[i].StopIDs)-1]
+			if branches[i].BeforeTrunk {
+				terminus = branches[i].StopIDs[0]
+			}
+			branches[i].Name = stopName(terminus)
+			termini[branches[i].Name]++
+		}
+		for i := range branches {
+			if termini[branches[i].Name] > 1 {
+				via := branches[i].StopIDs[0]
+				if branches[i].BeforeTrunk {
+					via = branches[i].StopIDs[len(branches[i].StopIDs)-1]
+				}
+				branches[i].Name += " via " + stopName(via)
+			}
+		}
+
+		sort.Slice(branches, func(i, j int) bool {
+			if branches[i].Frequency != branches[j].Frequency {
+				return branches[i].Frequency > branches[j].Frequency
+			}
+			return branches[i].Name < branches[j].Name
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,208 @@
+package gtfs
+
+import (
+	"errors"
+	"time"
+)
+
+// Builds a GTFS database from entities added programmatically, such as those converted from another
+// format or generated for tests, storing and indexing them as when ingesting a GTFS feed.
+//
+//	g, err := gtfs.NewBuilder(dbFile).
+//		AddAgency(agency).
+//		AddRoute(route).
+//		...
+//		Commit()
+//
+// The first error, such as a duplicate ID, is kept and returned by Commit, so calls can be chained.
+// A Builder is not safe for concurrent use.
+type Builder struct {
+	dbFile string
+	opts   IngestOptions
+	feed   *Feed
+	err    error
+}
+
+// Create a new Builder for a database at the given file
+func NewBuilder(dbFile string) *Builder {
+	return &Builder{
+		dbFile: dbFile,
+		feed: &Feed{
+			Agencies:          make(AgencyMap),
+			Routes:            make(RouteMap),
+			Services:          make(ServiceMap),
+			ServiceExceptions: make(ServiceExceptionMap),
+			Shapes:            make(ShapeMap),
+			Stops:             make(StopMap),
+			Trips:             make(TripMap),
+			Transfers:         TransferArray{},
+			Extensions:        make(map[string]map[Key][]byte),
+			Reports:           make(map[string]*FileReport),
+		},
+	}
+}
+
+// Set the options used to ingest the entities on commit
+func (b *Builder) WithOptions(opts IngestOptions) *Builder {
+	b.opts = opts
+	return b
+}
+
+// Record an error adding the entity with the given ID, if none has been recorded yet
+func (b *Builder) fail(entity string, id Key, reason string) {
+	if b.err == nil {
+		b.err = errors.New(entity + " " + string(id) + ": " + reason)
+	}
+}
+
+// Add an agency
+func (b *Builder) AddAgency(agency *Agency) *Builder {
+	if _, ok := b.feed.Agencies[agency.ID]; ok {
+		b.fail("agency", agency.ID, "duplicate ID")
+		return b
+	}
+	b.feed.Agencies[agency.ID] = agency
+	return b
+}
+
+// Add a route. Its most common shapes and stops are set on commit.
+func (b *Builder) AddRoute(route *Route) *Builder {
+	if _, ok := b.feed.Routes[route.ID]; ok {
+		b.fail("route", route.ID, "duplicate ID")
+		return b
+	}
+	b.feed.Routes[route.ID] = route
+	return b
+}
+
+// Add a service. Its dates are taken as the calendar days of their times.
+func (b *Builder) AddService(service *Service) *Builder {
+	if _, ok := b.feed.Services[service.ID]; ok {
+		b.fail("service", service.ID, "duplicate ID")
+		return b
+	}
+	service.StartDate = calendarDate(service.StartDate)
+	service.EndDate = calendarDate(service.EndDate)
+	b.feed.Services[service.ID] = service
+	return b
+}
+
+// Add an exception to a service, on the calendar day of its date
+func (b *Builder) AddServiceException(exception *ServiceException) *Builder {
+	exception.Date = calendarDate(exception.Date)
+	key := ServiceExceptionKey{ServiceID: exception.ServiceID, Date: exception.Date}
+	if _, ok := b.feed.ServiceExceptions[key]; ok {
+		b.fail("service exception", exception.ServiceID, "duplicate date "+exception.Date.Format("20060102"))
+		return b
+	}
+	b.feed.ServiceExceptions[key] = exception
+	return b
+}
+
+// Add a shape
+func (b *Builder) AddShape(shape *Shape) *Builder {
+	if _, ok := b.feed.Shapes[shape.ID]; ok {
+		b.fail("shape", shape.ID, "duplicate ID")
+		return b
+	}
+	b.feed.Shapes[shape.ID] = shape
+	return b
+}
+
+// Add a stop
+func (b *Builder) AddStop(stop *Stop) *Builder {
+	if _, ok := b.feed.Stops[stop.ID]; ok {
+		b.fail("stop", stop.ID, "duplicate ID")
+		return b
+	}
+	b.feed.Stops[stop.ID] = stop
+	return b
+}
+
+// Add a trip, with its stops in order
+func (b *Builder) AddTrip(trip *Trip) *Builder {
+	if _, ok := b.feed.Trips[trip.ID]; ok {
+		b.fail("trip", trip.ID, "duplicate ID")
+		return b
+	}
+	b.feed.Trips[trip.ID] = trip
+	return b
+}
+
+// Add a transfer between stops
+func (b *Builder) AddTransfer(transfer Transfer) *Builder {
+	b.feed.Transfers = append(b.feed.Transfers, transfer)
+	return b
+}
+
+// Returns the midnight (UTC) of the calendar day of the time, as dates are stored
+func calendarDate(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// Check that every entity referenced by another has been added
+func (b *Builder) checkReferences() error {
+	feed := b.feed
+	if len(feed.Agencies) == 0 {
+		return errors.New("no agencies added")
+	}
+	for _, route := range feed.Routes {
+		if _, ok := feed.Agencies[route.AgencyID]; route.AgencyID != "" && !ok {
+			return errors.New("route " + string(route.ID) + ": unknown agency " + string(route.AgencyID))
+		}
+	}
+	for _, stop := range feed.Stops {
+		if _, ok := feed.Stops[stop.ParentID]; stop.ParentID != "" && !ok {
+			return errors.New("stop " + string(stop.ID) + ": unknown parent station " + string(stop.ParentID))
+		}
+	}
+
+	exceptionServices := make(map[Key]bool)
+	for key := range feed.ServiceExceptions {
+		exceptionServices[key.ServiceID] = true
+	}
+	for _, trip := range feed.Trips {
+		if _, ok := feed.Routes[trip.RouteID]; !ok {
+			return errors.New("trip " + string(trip.ID) + ": unknown route " + string(trip.RouteID))
+		}
+		if _, ok := feed.Services[trip.ServiceID]; !ok && !exceptionServices[trip.ServiceID] {
+			return errors.New("trip " + string(trip.ID) + ": unknown service " + string(trip.ServiceID))
+		}
+		if _, ok := feed.Shapes[trip.ShapeID]; trip.ShapeID != "" && !ok && !b.opts.InferShapes {
+			return errors.New("trip " + string(trip.ID) + ": unknown shape " + string(trip.ShapeID))
+		}
+		for _, stop := range trip.Stops {
+			if _, ok := feed.Stops[stop.StopID]; !ok {
+				return errors.New("trip " + string(trip.ID) + ": unknown stop " + string(stop.StopID))
+			}
+		}
+	}
+	for _, transfer := range feed.Transfers {
+		for _, stopID := range []Key{transfer.FromStopID, transfer.ToStopID} {
+			if _, ok := feed.Stops[stopID]; !ok {
+				return errors.New("transfer: unknown stop " + string(stopID))
+			}
+		}
+	}
+	return nil
+}
+
+// Check the added entities and write them to the database, returning it opened. The Builder must not be
+// used after committing.
+func (b *Builder) Commit() (*GTFS, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	err := b.checkReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	g := &GTFS{}
+	err = g.FromFeed(b.feed, b.dbFile, b.opts)
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
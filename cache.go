@@ -0,0 +1,218 @@
+package gtfs
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Type of entity stored in a GTFS database
+type EntityType uint8
+
+const (
+	AgencyEntityType EntityType = iota
+	RouteEntityType
+	ServiceEntityType
+	ServiceExceptionEntityType
+	ShapeEntityType
+	StopEntityType
+	TripEntityType
+)
+
+// Buckets holding each type of entity
+var entityBuckets = map[EntityType]string{
+	AgencyEntityType:           "agencies",
+	RouteEntityType:            "routes",
+	ServiceEntityType:          "services",
+	ServiceExceptionEntityType: "serviceExceptions",
+	ShapeEntityType:            "shapes",
+	StopEntityType:             "stops",
+	TripEntityType:             "trips",
+}
+
+// Number of entities decoded between checks for context cancellation during warmup
+const warmupCheckInterval = 1000
+
+// In-memory cache of decoded entities, holding entire buckets keyed by their bucket keys
+type entityCache struct {
+	mu         sync.RWMutex
+	entities   map[EntityType]map[Key]any
+	generation uint64 // Incremented on each reset, so that buckets read before it are not stored
+}
+
+// Discard all cached entities, such as after the database is written to
+func (c *entityCache) reset() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.entities = nil
+	c.generation++
+	c.mu.Unlock()
+}
+
+// Returns the name of the entity type's bucket
+func (t EntityType) String() string {
+	return entityBuckets[t]
+}
+
+// Returns a new, empty entity of the given type to decode into
+func newEntity(t EntityType) (any, error) {
+	switch t {
+	case AgencyEntityType:
+		return &Agency{}, nil
+	case RouteEntityType:
+		return &Route{}, nil
+	case ServiceEntityType:
+		return &Service{}, nil
+	case ServiceExceptionEntityType:
+		return &ServiceException{}, nil
+	case ShapeEntityType:
+		return &Shape{}, nil
+	case StopEntityType:
+		return &Stop{}, nil
+	case TripEntityType:
+		return &Trip{}, nil
+	default:
+		return nil, errors.New("unknown entity type")
+	}
+}
+
+// Pre-reads and decodes the buckets of the given entity types (or all types, if none are given) into
+// an in-memory cache, which subsequent lookups by ID and full listings are served from. Entities
+// returned from the cache are shared between callers and must not be modified. Snapshots taken
+// after warmup share the cache.
+func (g *GTFS) Warmup(ctx context.Context, entities ...EntityType) error {
+	if len(entities) == 0 {
+		entities = []EntityType{
+			AgencyEntityType,
+			RouteEntityType,
+			ServiceEntityType,
+			ServiceExceptionEntityType,
+			ShapeEntityType,
+			StopEntityType,
+			TripEntityType,
+		}
+	}
+
+	if g.cache == nil {
+		return errors.New("database not open")
+	}
+
+	for _, entityType := range entities {
+		bucket, ok := entityBuckets[entityType]
+		if !ok {
+			return errors.New("unknown entity type")
+		}
+
+		g.cache.mu.RLock()
+		generation := g.cache.generation
+		g.cache.mu.RUnlock()
+
+		var decoded map[Key]any
+		err := g.view(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(bucket))
+			if b == nil {
+				return errors.New("bucket not found")
+			}
+
+			decoded = make(map[Key]any, b.Stats().KeyN)
+
+			return b.ForEach(func(k, v []byte) error {
+				if len(decoded)%warmupCheckInterval == 0 {
+					if err := ctx.Err(); err != nil {
+						return err
+					}
+				}
+
+				entity, err := newEntity(entityType)
+				if err != nil {
+					return err
+				}
+				key := Key(k)
+				if exception, ok := entity.(*ServiceException); ok {
+					err = decodeServiceException(exception, v, g.Encoding)
+				} else {
+					err = g.decode(tx, entity.(decodable), key, v)
+				}
+				if err != nil {
+					return err
+				}
+				decoded[key] = entity
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		g.cache.mu.Lock()
+		// Drop the bucket if the database was written to while it was being read
+		if g.cache.generation == generation {
+			if g.cache.entities == nil {
+				g.cache.entities = make(map[EntityType]map[Key]any)
+			}
+			g.cache.entities[entityType] = decoded
+		}
+		g.cache.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Returns the cached entities of the given type, if its bucket has been warmed up
+func (g *GTFS) cachedBucket(t EntityType) (map[Key]any, bool) {
+	if g.cache == nil {
+		return nil, false
+	}
+
+	g.cache.mu.RLock()
+	defer g.cache.mu.RUnlock()
+	entities, ok := g.cache.entities[t]
+	return entities, ok
+}
+
+// Returns the cached entity with the given bucket key. The second result is false if the
+// bucket has not been warmed up, or if the entity does not exist.
+func cachedEntity[T any](g *GTFS, t EntityType, key Key) (*T, bool) {
+	entities, ok := g.cachedBucket(t)
+	if !ok {
+		return nil, false
+	}
+	entity, ok := entities[key].(*T)
+	return entity, ok
+}
+
+// Returns the cached entities with the given IDs, skipping any which do not exist.
+// The second result is false if the bucket has not been warmed up.
+func cachedEntitiesByIDs[T any](g *GTFS, t EntityType, ids []Key) (map[Key]*T, bool) {
+	entities, ok := g.cachedBucket(t)
+	if !ok {
+		return nil, false
+	}
+
+	result := make(map[Key]*T, len(ids))
+	for _, id := range ids {
+		if entity, ok := entities[id].(*T); ok {
+			result[id] = entity
+		}
+	}
+	return result, true
+}
+
+// Returns all cached entities of the given type.
+// The second result is false if the bucket has not been warmed up.
+func cachedEntities[T any](g *GTFS, t EntityType) (map[Key]*T, bool) {
+	entities, ok := g.cachedBucket(t)
+	if !ok {
+		return nil, false
+	}
+
+	result := make(map[Key]*T, len(entities))
+	for key, entity := range entities {
+		result[key] = entity.(*T)
+	}
+	return result, true
+}
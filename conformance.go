@@ -0,0 +1,123 @@
+package gtfs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A violation of a rule of the GTFS specification, found while parsing in conformance mode.
+// Codes match the notice codes of the MobilityData canonical GTFS validator, so reports can be
+// combined with its output in feed QA pipelines.
+type SpecError struct {
+	Code string `json:"code"` // Validator notice code, such as "missing_required_field"
+	Rule string `json:"rule"` // Rule violated, such as "stop_times.arrival_time missing for timepoint=1"
+	File string `json:"file"`
+	Line int    `json:"line"` // Line of the file, or zero for the file as a whole
+	Err  error  `json:"-"`    // Underlying parse error, if any
+}
+
+func (e *SpecError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Rule, e.Err)
+	}
+	return e.Code + ": " + e.Rule
+}
+
+func (e *SpecError) Unwrap() error {
+	return e.Err
+}
+
+// Fields which must have a value in every row of each file
+var specRequiredFields = map[string][]string{
+	"agency.txt":         {"agency_name", "agency_url", "agency_timezone"},
+	"stops.txt":          {"stop_id"},
+	"routes.txt":         {"route_id", "route_type"},
+	"trips.txt":          {"route_id", "service_id", "trip_id"},
+	"stop_times.txt":     {"trip_id", "stop_id", "stop_sequence"},
+	"calendar.txt":       {"service_id", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday", "start_date", "end_date"},
+	"calendar_dates.txt": {"service_id", "date", "exception_type"},
+	"shapes.txt":         {"shape_id", "shape_pt_lat", "shape_pt_lon", "shape_pt_sequence"},
+	"transfers.txt":      {"transfer_type"},
+}
+
+// Fields of stops.txt which must have a value for stops, stations and entrances (location types 0 to 2)
+var specRequiredStopFields = []string{"stop_name", "stop_lat", "stop_lon"}
+
+// Returns the name of a file without its extension, as used in rules
+func specFileName(file string) string {
+	return strings.TrimSuffix(file, ".txt")
+}
+
+// Returns the error as a spec error with the given code and rule if conformance mode is enabled,
+// and unchanged otherwise
+func (p *csvParser) spec(code, rule string, err error) error {
+	if !p.opts.Conformance {
+		return err
+	}
+	return &SpecError{Code: code, Rule: rule, File: p.report.File, Line: p.line, Err: err}
+}
+
+// Handle a violation of the specification by a row which can still be parsed. In strict mode the
+// violation is returned; otherwise it is recorded in the report and nil is returned.
+func (p *csvParser) violation(code, rule string) error {
+	err := &SpecError{Code: code, Rule: rule, File: p.report.File, Line: p.line}
+	if p.opts.Mode == StrictParseMode {
+		return fmt.Errorf("line %d: %w", p.line, err)
+	}
+	p.report.violate(err)
+	p.report.warn("line %d: %v", p.line, err)
+	return nil
+}
+
+// Record a violation of the specification in the report
+func (r *FileReport) violate(err *SpecError) {
+	r.ViolationCount++
+	if len(r.Violations) < maxReportWarnings {
+		r.Violations = append(r.Violations, err)
+	}
+}
+
+// Record the error in the report if it is a violation of the specification
+func (r *FileReport) violateIfSpec(err error) {
+	var specErr *SpecError
+	if errors.As(err, &specErr) {
+		r.violate(specErr)
+	}
+}
+
+// Check the row against the rules of the specification which apply to values present in the row
+func (p *csvParser) checkConformance(record []string) error {
+	file := p.report.File
+	required := specRequiredFields[file]
+	if file == "stops.txt" {
+		switch p.get(record, "location_type") {
+		case "", "0", "1", "2":
+			required = append(required[:len(required):len(required)], specRequiredStopFields...)
+		}
+	}
+	for _, field := range required {
+		if _, ok := p.header[field]; !ok {
+			continue // Missing columns are reported by require
+		}
+		if strings.TrimSpace(p.get(record, field)) == "" {
+			err := p.violation("missing_required_field", specFileName(file)+"."+field+" is required")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Timepoints must have both times
+	if file == "stop_times.txt" && p.get(record, "timepoint") == "1" {
+		for _, field := range []string{"arrival_time", "departure_time"} {
+			if strings.TrimSpace(p.get(record, field)) == "" {
+				err := p.violation("stop_time_timepoint_without_times", "stop_times."+field+" missing for timepoint=1")
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
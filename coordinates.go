@@ -0,0 +1,108 @@
+package gtfs
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Distance in metres from the centre of a feed beyond which a coordinate is considered outside its region
+const coordinateRegionRadius = 1000e3
+
+// Maximum number of coordinates sampled to find the centre of a feed
+const maxCoordinateSamples = 10000
+
+// A parsed coordinate and the entity it belongs to, for validation
+type coordinateRef struct {
+	id    Key
+	coord *Coordinate
+}
+
+// Parse a latitude or longitude. If coordinates are being fixed, values written with a decimal
+// comma (e.g. "115,86") are also accepted, and the current row is recorded as repaired.
+func (p *csvParser) parseDegrees(s string) (float64, error) {
+	value, err := strconv.ParseFloat(s, 64)
+	if err == nil || !p.opts.FixCoordinates {
+		return value, err
+	}
+	if strings.Count(s, ",") != 1 || strings.Contains(s, ".") {
+		return value, err
+	}
+
+	value, commaErr := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+	if commaErr != nil {
+		return value, err
+	}
+	p.repair("decimal comma in coordinate %q", s)
+	return value, nil
+}
+
+// Returns the median latitude and longitude of the valid, non-zero coordinates, sampling evenly
+// if there are many. The result is false if there are no such coordinates.
+func coordinateCentre(refs []coordinateRef) (Coordinate, bool) {
+	step := max(len(refs)/maxCoordinateSamples, 1)
+	var lats, lons []float64
+	for i := 0; i < len(refs); i += step {
+		c := *refs[i].coord
+		if c.IsZero() || !c.IsValid() {
+			continue
+		}
+		lats = append(lats, c.Latitude)
+		lons = append(lons, c.Longitude)
+	}
+	if len(lats) == 0 {
+		return Coordinate{}, false
+	}
+
+	sort.Float64s(lats)
+	sort.Float64s(lons)
+	return NewCoordinate(lats[len(lats)/2], lons[len(lons)/2]), true
+}
+
+// Check whether a coordinate appears to have its latitude and longitude swapped, either because it
+// is out of range only as given, or because only the swapped coordinate lies within the feed's region
+func isSwappedCoordinate(c Coordinate, centre Coordinate, hasCentre bool) bool {
+	swapped := NewCoordinate(c.Longitude, c.Latitude)
+	if !c.IsValid() {
+		return swapped.IsValid()
+	}
+	if !hasCentre || !swapped.IsValid() {
+		return false
+	}
+	return c.DistanceTo(centre) > coordinateRegionRadius && swapped.DistanceTo(centre) <= coordinateRegionRadius
+}
+
+// Check the parsed coordinates for out-of-range values and swapped latitudes and longitudes, reporting
+// each affected entity once. Swapped coordinates are corrected if coordinates are being fixed.
+// Zero coordinates are treated as missing and ignored.
+func (p *csvParser) checkCoordinates(refs []coordinateRef) {
+	centre, hasCentre := coordinateCentre(refs)
+
+	reported := make(map[Key]bool)
+	report := func(id Key, format string, args ...any) {
+		if reported[id] {
+			return
+		}
+		reported[id] = true
+		p.report.CoordinateIssues = append(p.report.CoordinateIssues, id)
+		p.report.warn(format, args...)
+	}
+
+	for _, ref := range refs {
+		c := *ref.coord
+		if c.IsZero() {
+			continue
+		}
+
+		if isSwappedCoordinate(c, centre, hasCentre) {
+			if p.opts.FixCoordinates {
+				*ref.coord = NewCoordinate(c.Longitude, c.Latitude)
+				report(ref.id, "%s: swapped latitude and longitude of %s", ref.id, c)
+			} else {
+				report(ref.id, "%s: latitude and longitude of %s appear to be swapped", ref.id, c)
+			}
+		} else if !c.IsValid() {
+			report(ref.id, "%s: coordinate %s is out of range", ref.id, c)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package gtfs
 
 import (
+	"encoding/binary"
+
 	bolt "go.etcd.io/bbolt"
 )
 
@@ -15,6 +17,23 @@ func Populate(
 	stops StopMap,
 	trips TripMap,
 ) error {
+	return PopulateWithOptions(db, IngestOptions{}, agencies, routes, services, serviceExceptions, shapes, stops, trips)
+}
+
+// Populates the GTFS database with data from the provided maps, using the given ingest options.
+func PopulateWithOptions(
+	db *bolt.DB,
+	opts IngestOptions,
+	agencies AgencyMap,
+	routes RouteMap,
+	services ServiceMap,
+	serviceExceptions ServiceExceptionMap,
+	shapes ShapeMap,
+	stops StopMap,
+	trips TripMap,
+) error {
+	enc := opts.Encoding
+
 	// Populate agencies
 	err := db.Batch(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte("agencies"))
@@ -22,7 +41,7 @@ func Populate(
 			return err
 		}
 		for _, agency := range agencies {
-			err := b.Put([]byte(agency.ID), agency.Encode())
+			err := b.Put([]byte(agency.ID), encodeEntity(agency, enc))
 			if err != nil {
 				return err
 			}
@@ -44,8 +63,10 @@ func Populate(
 			return err
 		}
 
+		routesByTypeIndex := make(map[RouteType]*KeyArray)
+		routesByAgencyIndex := make(map[Key]*KeyArray)
 		for _, route := range routes {
-			err := b.Put([]byte(route.ID), route.Encode())
+			err := b.Put([]byte(route.ID), encodeEntity(route, enc))
 			if err != nil {
 				return err
 			}
@@ -57,6 +78,40 @@ func Populate(
 					return err
 				}
 			}
+
+			// Populate routesByTypeIndex
+			if _, exists := routesByTypeIndex[route.Type]; !exists {
+				routesByTypeIndex[route.Type] = &KeyArray{}
+			}
+			routesByTypeIndex[route.Type].Append(route.ID)
+
+			// Populate routesByAgencyIndex
+			if _, exists := routesByAgencyIndex[route.AgencyID]; !exists {
+				routesByAgencyIndex[route.AgencyID] = &KeyArray{}
+			}
+			routesByAgencyIndex[route.AgencyID].Append(route.ID)
+		}
+
+		b3, err := tx.CreateBucketIfNotExists([]byte("routesByTypeIndex"))
+		if err != nil {
+			return err
+		}
+		for routeType, routeIDs := range routesByTypeIndex {
+			err = b3.Put([]byte{byte(routeType)}, routeIDs.Encode())
+			if err != nil {
+				return err
+			}
+		}
+
+		b4, err := tx.CreateBucketIfNotExists([]byte("routesByAgencyIndex"))
+		if err != nil {
+			return err
+		}
+		for agencyID, routeIDs := range routesByAgencyIndex {
+			err = b4.Put([]byte(agencyID), routeIDs.Encode())
+			if err != nil {
+				return err
+			}
 		}
 		return nil
 	})
@@ -68,7 +123,7 @@ func Populate(
 			return err
 		}
 		for _, service := range services {
-			err := b.Put([]byte(service.ID), service.Encode())
+			err := b.Put([]byte(service.ID), encodeEntity(service, enc))
 			if err != nil {
 				return err
 			}
@@ -83,8 +138,7 @@ func Populate(
 			return err
 		}
 		for _, exception := range serviceExceptions {
-			id := string(exception.ServiceID) + exception.Date.Format("20060102")
-			err := b.Put([]byte(id), exception.Encode())
+			err := b.Put(serviceExceptionKey(exception.ServiceID, exception.Date), encodeEntity(exception, enc))
 			if err != nil {
 				return err
 			}
@@ -99,7 +153,19 @@ func Populate(
 			return err
 		}
 		for _, shape := range shapes {
-			err := b.Put([]byte(shape.ID), shape.Encode())
+			err := b.Put([]byte(shape.ID), encodeEntity(shape, enc))
+			if err != nil {
+				return err
+			}
+		}
+
+		// Populate shapeRefCounts
+		b2, err := tx.CreateBucketIfNotExists([]byte("shapeRefCounts"))
+		if err != nil {
+			return err
+		}
+		for shapeID, count := range getShapeRefCounts(shapes, trips) {
+			err = b2.Put([]byte(shapeID), binary.BigEndian.AppendUint32(nil, count))
 			if err != nil {
 				return err
 			}
@@ -118,8 +184,9 @@ func Populate(
 			return err
 		}
 
+		stopsByZoneIndex := make(map[Key]*KeyArray)
 		for _, stop := range stops {
-			err := b.Put([]byte(stop.ID), stop.Encode())
+			err := b.Put([]byte(stop.ID), encodeEntity(stop, enc))
 			if err != nil {
 				return err
 			}
@@ -131,10 +198,46 @@ func Populate(
 					return err
 				}
 			}
+
+			// Populate stopsByZoneIndex
+			if stop.ZoneID != "" {
+				if _, exists := stopsByZoneIndex[stop.ZoneID]; !exists {
+					stopsByZoneIndex[stop.ZoneID] = &KeyArray{}
+				}
+				stopsByZoneIndex[stop.ZoneID].Append(stop.ID)
+			}
+		}
+
+		b3, err := tx.CreateBucketIfNotExists([]byte("stopsByZoneIndex"))
+		if err != nil {
+			return err
+		}
+		for zoneID, stopIDs := range stopsByZoneIndex {
+			err = b3.Put([]byte(zoneID), stopIDs.Encode())
+			if err != nil {
+				return err
+			}
 		}
 		return nil
 	})
 
+	// Populate numeric IDs
+	numericIDs := map[EntityType]map[Key]uint32{
+		AgencyEntityType:  assignNumericIDs(agencies),
+		RouteEntityType:   assignNumericIDs(routes),
+		ServiceEntityType: assignNumericIDs(services),
+		ShapeEntityType:   assignNumericIDs(shapes),
+		StopEntityType:    assignNumericIDs(stops),
+		TripEntityType:    assignNumericIDs(trips),
+	}
+	for _, t := range numericIDEntityTypes {
+		err = populateNumericIDs(db, t, numericIDs[t])
+		if err != nil {
+			return err
+		}
+	}
+	tripNumericIDs := numericIDs[TripEntityType]
+
 	// Populate trips
 	err = db.Batch(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte("trips"))
@@ -142,19 +245,28 @@ func Populate(
 			return err
 		}
 
-		tripsByRouteIndex := make(map[Key]*KeyArray)
+		tripsByRouteIndex := make(map[Key]numericIDArray)
+		tripsByRouteDirectionIndex := make(map[string]numericIDArray)
+		tripsByHeadsignIndex := make(map[string]numericIDArray)
 		for _, trip := range trips {
-			err := b.Put([]byte(trip.ID), trip.Encode())
+			err := b.Put([]byte(trip.ID), encodeEntity(trip, enc))
 			if err != nil {
 				return err
 			}
 
 			// Populate tripsByRouteIndex
+			tripID := tripNumericIDs[trip.ID]
 			if trip.RouteID != "" {
-				if _, exists := tripsByRouteIndex[trip.RouteID]; !exists {
-					tripsByRouteIndex[trip.RouteID] = &KeyArray{}
-				}
-				tripsByRouteIndex[trip.RouteID].Append(trip.ID)
+				tripsByRouteIndex[trip.RouteID] = append(tripsByRouteIndex[trip.RouteID], tripID)
+
+				// Populate tripsByRouteDirectionIndex
+				key := string(routeDirectionKey(trip.RouteID, trip.Direction))
+				tripsByRouteDirectionIndex[key] = append(tripsByRouteDirectionIndex[key], tripID)
+			}
+
+			// Populate tripsByHeadsignIndex
+			if trip.Headsign != "" {
+				tripsByHeadsignIndex[trip.Headsign] = append(tripsByHeadsignIndex[trip.Headsign], tripID)
 			}
 		}
 
@@ -169,8 +281,126 @@ func Populate(
 			}
 		}
 
+		b4, err := tx.CreateBucketIfNotExists([]byte("tripsByRouteDirectionIndex"))
+		if err != nil {
+			return err
+		}
+		for key, tripIDs := range tripsByRouteDirectionIndex {
+			err = b4.Put([]byte(key), tripIDs.Encode())
+			if err != nil {
+				return err
+			}
+		}
+
+		b3, err := tx.CreateBucketIfNotExists([]byte("tripsByHeadsignIndex"))
+		if err != nil {
+			return err
+		}
+		for headsign, tripIDs := range tripsByHeadsignIndex {
+			err = b3.Put([]byte(headsign), tripIDs.Encode())
+			if err != nil {
+				return err
+			}
+		}
+
+		// Populate tripsByStartIndex
+		err = populateTripStartIndex(tx, trips, tripNumericIDs)
+		if err != nil {
+			return err
+		}
+
+		// Populate tripsByStopIndex
+		return populateTripStopIndex(tx, trips, tripNumericIDs)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Populate segmentTravelTimes
+	err = db.Batch(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("segmentTravelTimes"))
+		if err != nil {
+			return err
+		}
+
+		routeTrips := make(map[Key][]*Trip)
+		for _, trip := range trips {
+			routeTrips[trip.RouteID] = append(routeTrips[trip.RouteID], trip)
+		}
+		for routeID, trips := range routeTrips {
+			err = b.Put([]byte(routeID), computeSegmentTravelTimes(trips, stops).Encode())
+			if err != nil {
+				return err
+			}
+		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	// Populate derived data
+	if opts.PrecomputeDerived {
+		err = populateDerived(db, opts, routes, shapes, trips, tripNumericIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Populate stopProjections
+	if opts.ProjectStops {
+		err = db.Batch(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("stopProjections"))
+			if err != nil {
+				return err
+			}
+			for _, stop := range stops {
+				err = b.Put([]byte(stop.ID), encodeProjection(projectCoordinate(stop.Location)))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
 
-	return nil
+	// Populate stopAliases
+	aliases := filterStopAliases(opts.StopAliases, stops)
+	if len(aliases) > 0 {
+		err = db.Batch(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("stopAliases"))
+			if err != nil {
+				return err
+			}
+			for _, alias := range aliases {
+				err = b.Put([]byte(alias.Alias), []byte(alias.StopID))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Populate searchIndex
+	err = db.Batch(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("searchIndex"))
+		if err != nil {
+			return err
+		}
+		for token, refs := range buildSearchIndex(agencies, routes, stops, aliases) {
+			err = b.Put([]byte(token), refs.Encode())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
 }
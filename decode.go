@@ -0,0 +1,131 @@
+package gtfs
+
+import (
+	"fmt"
+	"runtime"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Decode the values in parallel, using at most the given number of workers (or one per CPU if not positive)
+func decodeAll[T any](raw map[Key][]byte, workers int, decode func(id Key, data []byte) (*T, error)) ([]Key, []*T, error) {
+	ids := make([]Key, 0, len(raw))
+	for id := range raw {
+		ids = append(ids, id)
+	}
+	entities := make([]*T, len(ids))
+	if len(ids) == 0 {
+		return ids, entities, nil
+	}
+
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, len(ids))
+
+	// Each worker decodes a contiguous chunk of the IDs into its own part of the results
+	var group errgroup.Group
+	chunk := (len(ids) + workers - 1) / workers
+	for start := 0; start < len(ids); start += chunk {
+		end := min(start+chunk, len(ids))
+		group.Go(func() error {
+			for i := start; i < end; i++ {
+				entity, err := decode(ids[i], raw[ids[i]])
+				if err != nil {
+					return fmt.Errorf("%s: %w", ids[i], err)
+				}
+				entities[i] = entity
+			}
+			return nil
+		})
+	}
+	err := group.Wait()
+	if err != nil {
+		return nil, nil, err
+	}
+	return ids, entities, nil
+}
+
+// Decode raw bucket values keyed by entity ID into a map of entities
+func decodeEntities[T any, PT decodablePtr[T]](raw map[Key][]byte, workers int, enc Encoding) (map[Key]*T, error) {
+	ids, decoded, err := decodeAll(raw, workers, func(id Key, data []byte) (*T, error) {
+		entity := PT(new(T))
+		err := decodeEntity(entity, id, data, enc)
+		if err != nil {
+			return nil, err
+		}
+		return (*T)(entity), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entities := make(map[Key]*T, len(ids))
+	for i, id := range ids {
+		entities[id] = decoded[i]
+	}
+	return entities, nil
+}
+
+// Decodes raw values read from the agencies bucket, keyed by agency ID, using at most the given number of
+// workers (or one per CPU if not positive). The encoding must match the database's (see GTFS.Encoding).
+func DecodeAgencies(raw map[Key][]byte, workers int, enc Encoding) (AgencyMap, error) {
+	return decodeEntities[Agency](raw, workers, enc)
+}
+
+// Decodes raw values read from the routes bucket, keyed by route ID, using at most the given number of
+// workers (or one per CPU if not positive). The encoding must match the database's (see GTFS.Encoding).
+func DecodeRoutes(raw map[Key][]byte, workers int, enc Encoding) (RouteMap, error) {
+	return decodeEntities[Route](raw, workers, enc)
+}
+
+// Decodes raw values read from the services bucket, keyed by service ID, using at most the given number of
+// workers (or one per CPU if not positive). The encoding must match the database's (see GTFS.Encoding).
+func DecodeServices(raw map[Key][]byte, workers int, enc Encoding) (ServiceMap, error) {
+	return decodeEntities[Service](raw, workers, enc)
+}
+
+// Decodes raw values read from the shapes bucket, keyed by shape ID, using at most the given number of
+// workers (or one per CPU if not positive). The encoding must match the database's (see GTFS.Encoding).
+func DecodeShapes(raw map[Key][]byte, workers int, enc Encoding) (ShapeMap, error) {
+	return decodeEntities[Shape](raw, workers, enc)
+}
+
+// Decodes raw values read from the stops bucket, keyed by stop ID, using at most the given number of
+// workers (or one per CPU if not positive). The encoding must match the database's (see GTFS.Encoding).
+func DecodeStops(raw map[Key][]byte, workers int, enc Encoding) (StopMap, error) {
+	return decodeEntities[Stop](raw, workers, enc)
+}
+
+// Decodes raw values read from the trips bucket, keyed by trip ID, using at most the given number of
+// workers (or one per CPU if not positive). The encoding must match the database's (see GTFS.Encoding).
+func DecodeTrips(raw map[Key][]byte, workers int, enc Encoding) (TripMap, error) {
+	return decodeEntities[Trip](raw, workers, enc)
+}
+
+// Decodes raw values read from the service exceptions bucket, using at most the given number of workers
+// (or one per CPU if not positive). Service exceptions carry their own service ID and date, so the keys of
+// the raw values are only used in errors. The encoding must match the database's (see GTFS.Encoding).
+func DecodeServiceExceptions(raw map[Key][]byte, workers int, enc Encoding) (ServiceExceptionMap, error) {
+	_, decoded, err := decodeAll(raw, workers, func(_ Key, data []byte) (*ServiceException, error) {
+		exception := &ServiceException{}
+		err := decodeServiceException(exception, data, enc)
+		if err != nil {
+			return nil, err
+		}
+		return exception, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exceptions := make(ServiceExceptionMap, len(decoded))
+	for _, exception := range decoded {
+		key := ServiceExceptionKey{
+			ServiceID: exception.ServiceID,
+			Date:      exception.Date,
+		}
+		exceptions[key] = exception
+	}
+	return exceptions, nil
+}
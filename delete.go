@@ -0,0 +1,468 @@
+package gtfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// How deleting an entity treats the trips which reference it
+type DeleteMode uint8
+
+const (
+	RestrictDelete DeleteMode = iota // Fail if any trips reference the entity
+	CascadeDelete                    // Delete the trips which reference the entity too
+)
+
+// Runs fn within a write transaction. The database must have been opened with DBOptions.ReadWrite.
+// Warmed up entities are discarded once the write commits, as they may no longer match the database.
+func (g *GTFS) update(fn func(tx *bolt.Tx) error) error {
+	err := g.checkWritable()
+	if err != nil {
+		return err
+	}
+
+	// The cache matches the database until the write commits, and is discarded once it has
+	err = g.openDB().Update(fn)
+	if err != nil {
+		return err
+	}
+	g.cache.reset()
+	return nil
+}
+
+// Check that the database is open for writing
+func (g *GTFS) checkWritable() error {
+	if g.db == nil {
+		return errors.New("database not open")
+	}
+	if g.tx != nil {
+		return errors.New("cannot modify a snapshot")
+	}
+	if !g.dbOptions.ReadWrite {
+		return errors.New("database opened read-only")
+	}
+	return nil
+}
+
+// Remove a numeric ID from the array stored under the key, deleting the key once the array is empty
+func removeIndexedID(b *bolt.Bucket, key []byte, id uint32) error {
+	if b == nil {
+		return nil
+	}
+	data := b.Get(key)
+	if data == nil {
+		return nil
+	}
+	var ids numericIDArray
+	err := ids.Decode(data)
+	if err != nil {
+		return err
+	}
+	ids = slices.DeleteFunc(ids, func(other uint32) bool { return other == id })
+	if len(ids) == 0 {
+		return b.Delete(key)
+	}
+	return b.Put(key, ids.Encode())
+}
+
+// Remove a key from the KeyArray stored under the index key, deleting the index key once the array is empty
+func removeIndexedKey(b *bolt.Bucket, key []byte, value Key) error {
+	if b == nil {
+		return nil
+	}
+	data := b.Get(key)
+	if data == nil {
+		return nil
+	}
+	var keys KeyArray
+	err := keys.Decode(data)
+	if err != nil {
+		return err
+	}
+	keys = slices.DeleteFunc(keys, func(other Key) bool { return other == value })
+	if len(keys) == 0 {
+		return b.Delete(key)
+	}
+	return b.Put(key, keys.Encode())
+}
+
+// Delete the key from each of the buckets which exist
+func deleteFromBuckets(tx *bolt.Tx, key []byte, buckets ...string) error {
+	for _, bucket := range buckets {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			continue
+		}
+		err := b.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the numeric ID of the entity, read from its mapping bucket
+func numericID(tx *bolt.Tx, t EntityType, id Key) (uint32, error) {
+	b := tx.Bucket([]byte(numericIDsBucket(t)))
+	if b == nil {
+		return 0, errors.New("bucket not found")
+	}
+	data := b.Get([]byte(id))
+	if data == nil {
+		return 0, errors.New("numeric ID of " + string(id) + " not found")
+	}
+	return binary.BigEndian.Uint32(data), nil
+}
+
+// Delete the trip and remove it from the indexes, returning it. The numeric ID of the trip is kept, so
+// that the IDs of the remaining trips stay contiguous.
+func (g *GTFS) deleteTrip(tx *bolt.Tx, tripID Key) (*Trip, error) {
+	b := tx.Bucket([]byte("trips"))
+	if b == nil {
+		return nil, errors.New("bucket not found")
+	}
+	data := b.Get([]byte(tripID))
+	if data == nil {
+		return nil, fmt.Errorf("trip %w", ErrNotFound)
+	}
+	trip := &Trip{}
+	err := decodeEntity(trip, tripID, data, g.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	err = b.Delete([]byte(tripID))
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := numericID(tx, TripEntityType, tripID)
+	if err != nil {
+		return nil, err
+	}
+	if trip.RouteID != "" {
+		err = removeIndexedID(tx.Bucket([]byte("tripsByRouteIndex")), []byte(trip.RouteID), id)
+		if err != nil {
+			return nil, err
+		}
+		err = removeIndexedID(tx.Bucket([]byte("tripsByRouteDirectionIndex")), routeDirectionKey(trip.RouteID, trip.Direction), id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if trip.Headsign != "" {
+		err = removeIndexedID(tx.Bucket([]byte("tripsByHeadsignIndex")), []byte(trip.Headsign), id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, stopID := range trip.visitedStopIDs() {
+		err = removeIndexedID(tx.Bucket([]byte("tripsByStopIndex")), []byte(stopID), id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The longest span of the service's trips is left as it is, as an upper bound
+	if len(trip.Stops) > 0 {
+		err = removeIndexedID(tx.Bucket([]byte("tripsByStartIndex")), tripStartKey(trip.ServiceID, tripStartBucket(trip)), id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if counts := tx.Bucket([]byte("shapeRefCounts")); counts != nil && trip.ShapeID != "" {
+		if data := counts.Get([]byte(trip.ShapeID)); len(data) == uint32Bytes {
+			count := binary.BigEndian.Uint32(data)
+			if count > 0 {
+				count--
+			}
+			err = counts.Put([]byte(trip.ShapeID), binary.BigEndian.AppendUint32(nil, count))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return trip, nil
+}
+
+// Update the data derived from the route's trips after some were deleted. The route's shapes and stops and
+// its segment travel times are recomputed, while precomputed stop patterns, headways and geometry are removed
+// to be computed when queried.
+func (g *GTFS) updateRouteTrips(tx *bolt.Tx, routeID Key) error {
+	err := deleteFromBuckets(tx, []byte(routeID), "stopPatterns", "routeHeadways", "routeGeometries")
+	if err != nil {
+		return err
+	}
+
+	var ids numericIDArray
+	if index := tx.Bucket([]byte("tripsByRouteIndex")); index != nil {
+		if data := index.Get([]byte(routeID)); data != nil {
+			err = ids.Decode(data)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if len(ids) == 0 {
+		err = g.updateRouteShapesAndStops(tx, routeID, TripMap{})
+		if err != nil {
+			return err
+		}
+		return deleteFromBuckets(tx, []byte(routeID), "segmentTravelTimes")
+	}
+
+	tripIDs, err := numericKeys(tx, TripEntityType, ids)
+	if err != nil {
+		return err
+	}
+	tripsBucket := tx.Bucket([]byte("trips"))
+	stopsBucket := tx.Bucket([]byte("stops"))
+	if tripsBucket == nil || stopsBucket == nil {
+		return errors.New("bucket not found")
+	}
+	trips := make([]*Trip, 0, len(tripIDs))
+	tripMap := make(TripMap, len(tripIDs))
+	stops := make(StopMap)
+	for _, tripID := range tripIDs {
+		data := tripsBucket.Get([]byte(tripID))
+		if data == nil {
+			return fmt.Errorf("trip %w", ErrNotFound)
+		}
+		trip := &Trip{}
+		err = decodeEntity(trip, tripID, data, g.Encoding)
+		if err != nil {
+			return err
+		}
+		trips = append(trips, trip)
+		tripMap[trip.ID] = trip
+
+		for _, tripStop := range trip.Stops {
+			if _, ok := stops[tripStop.StopID]; ok {
+				continue
+			}
+			data := stopsBucket.Get([]byte(tripStop.StopID))
+			if data == nil {
+				continue
+			}
+			stop := &Stop{}
+			err = decodeEntity(stop, tripStop.StopID, data, g.Encoding)
+			if err != nil {
+				return err
+			}
+			stops[stop.ID] = stop
+		}
+	}
+
+	err = g.updateRouteShapesAndStops(tx, routeID, tripMap)
+	if err != nil {
+		return err
+	}
+
+	b, err := tx.CreateBucketIfNotExists([]byte("segmentTravelTimes"))
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(routeID), computeSegmentTravelTimes(trips, stops).Encode())
+}
+
+// Set the most common shape IDs and the stops of the route from its remaining trips, as at ingest
+func (g *GTFS) updateRouteShapesAndStops(tx *bolt.Tx, routeID Key, trips TripMap) error {
+	b := tx.Bucket([]byte("routes"))
+	if b == nil {
+		return errors.New("bucket not found")
+	}
+	data := b.Get([]byte(routeID))
+	if data == nil {
+		// The trips may reference a route which does not exist
+		return nil
+	}
+	route := &Route{}
+	err := decodeEntity(route, routeID, data, g.Encoding)
+	if err != nil {
+		return err
+	}
+
+	shapeAndStops, err := getRouteShapeAndStops(trips)
+	if err != nil {
+		return err
+	}
+	updated := shapeAndStops[routeID]
+	route.InboundShapeID = updated.inboundShapeID
+	route.OutboundShapeID = updated.outboundShapeID
+	route.Stops = updated.stopIDs
+	route.InboundStops = updated.inboundStopIDs
+	route.OutboundStops = updated.outboundStopIDs
+	return b.Put([]byte(routeID), encodeEntity(route, g.Encoding))
+}
+
+// Deletes the trip, as DeleteTrips does
+func (g *GTFS) DeleteTrip(tripID Key) error {
+	return g.DeleteTrips([]Key{tripID})
+}
+
+// Deletes the trips and removes them from the indexes in a single transaction, so that curated datasets can
+// be pruned after ingest. The database must have been opened with DBOptions.ReadWrite. Nothing is deleted
+// if any of the trips does not exist.
+func (g *GTFS) DeleteTrips(tripIDs []Key) error {
+	return g.update(func(tx *bolt.Tx) error {
+		return g.deleteTrips(tx, tripIDs)
+	})
+}
+
+// Delete the trips in the transaction, updating the data derived from their routes' trips
+func (g *GTFS) deleteTrips(tx *bolt.Tx, tripIDs []Key) error {
+	routeIDs := []Key{}
+	for _, tripID := range tripIDs {
+		trip, err := g.deleteTrip(tx, tripID)
+		if err != nil {
+			return err
+		}
+		if trip.RouteID != "" && !slices.Contains(routeIDs, trip.RouteID) {
+			routeIDs = append(routeIDs, trip.RouteID)
+		}
+	}
+	for _, routeID := range routeIDs {
+		err := g.updateRouteTrips(tx, routeID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deletes the route and removes it from the indexes. If the route has trips, they are deleted too with
+// CascadeDelete, or an error is returned with RestrictDelete. The database must have been opened with
+// DBOptions.ReadWrite.
+func (g *GTFS) DeleteRoute(routeID Key, mode DeleteMode) error {
+	return g.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("routes"))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+		data := b.Get([]byte(routeID))
+		if data == nil {
+			return fmt.Errorf("route %w", ErrNotFound)
+		}
+		route := &Route{}
+		err := decodeEntity(route, routeID, data, g.Encoding)
+		if err != nil {
+			return err
+		}
+
+		var ids numericIDArray
+		if index := tx.Bucket([]byte("tripsByRouteIndex")); index != nil {
+			if data := index.Get([]byte(routeID)); data != nil {
+				err = ids.Decode(data)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if len(ids) > 0 {
+			if mode != CascadeDelete {
+				return errors.New("route has trips")
+			}
+			tripIDs, err := numericKeys(tx, TripEntityType, ids)
+			if err != nil {
+				return err
+			}
+			err = g.deleteTrips(tx, tripIDs)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = b.Delete([]byte(routeID))
+		if err != nil {
+			return err
+		}
+		if names := tx.Bucket([]byte("routesByNameIndex")); names != nil && route.Name != "" && Key(names.Get([]byte(route.Name))) == routeID {
+			err = names.Delete([]byte(route.Name))
+			if err != nil {
+				return err
+			}
+		}
+		err = removeIndexedKey(tx.Bucket([]byte("routesByTypeIndex")), []byte{byte(route.Type)}, routeID)
+		if err != nil {
+			return err
+		}
+		err = removeIndexedKey(tx.Bucket([]byte("routesByAgencyIndex")), []byte(route.AgencyID), routeID)
+		if err != nil {
+			return err
+		}
+		ref := Key(searchRefPrefixes[RouteSearchResultType]) + routeID
+		for _, token := range tokenize(route.Name) {
+			err = removeIndexedKey(tx.Bucket([]byte("searchIndex")), []byte(token), ref)
+			if err != nil {
+				return err
+			}
+		}
+		return deleteFromBuckets(tx, []byte(routeID), "stopPatterns", "routeHeadways", "routeGeometries", "segmentTravelTimes")
+	})
+}
+
+// Deletes the service, its exceptions and its calendar. If the service has trips, such as a school-only
+// service, they are deleted too with CascadeDelete, or an error is returned with RestrictDelete. The
+// database must have been opened with DBOptions.ReadWrite.
+func (g *GTFS) DeleteService(serviceID Key, mode DeleteMode) error {
+	defer g.serviceDays.reset()
+	return g.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("services"))
+		exceptions := tx.Bucket([]byte("serviceExceptions"))
+		trips := tx.Bucket([]byte("trips"))
+		if b == nil || exceptions == nil || trips == nil {
+			return errors.New("bucket not found")
+		}
+
+		// Services defined only by exceptions have no calendar
+		prefix := compositeKey([]byte(serviceID))
+		exceptionKeys := [][]byte{}
+		c := exceptions.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			exceptionKeys = append(exceptionKeys, append([]byte{}, k...))
+		}
+		if b.Get([]byte(serviceID)) == nil && len(exceptionKeys) == 0 {
+			return fmt.Errorf("service %w", ErrNotFound)
+		}
+
+		// Trips are not indexed by service, so each is decoded
+		tripIDs := []Key{}
+		err := trips.ForEach(func(k, v []byte) error {
+			trip := &Trip{}
+			err := decodeEntity(trip, Key(k), v, g.Encoding)
+			if err != nil {
+				return err
+			}
+			if trip.ServiceID == serviceID {
+				tripIDs = append(tripIDs, trip.ID)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(tripIDs) > 0 {
+			if mode != CascadeDelete {
+				return errors.New("service has trips")
+			}
+			err = g.deleteTrips(tx, tripIDs)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, key := range exceptionKeys {
+			err = exceptions.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+		return deleteFromBuckets(tx, []byte(serviceID), "services", "serviceTripSpans")
+	})
+}
@@ -0,0 +1,245 @@
+package gtfs
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/paulmach/orb"
+)
+
+// Metres per degree of latitude
+const metresPerDegree = orb.EarthRadius * math.Pi / 180
+
+// A cell of a stop density grid containing at least one stop
+type DensityCell struct {
+	Row        int        `json:"row"`
+	Column     int        `json:"column"`
+	SouthWest  Coordinate `json:"south_west"`
+	NorthEast  Coordinate `json:"north_east"`
+	Stops      int        `json:"stops"`
+	Departures int        `json:"departures"` // Departures from the cell's stops on the grid's date, if weighted
+}
+
+// Counts of stops in a grid of equally sized cells covering the network
+type DensityGrid struct {
+	CellSize float64       `json:"cell_size"` // Width and height of each cell in metres
+	Origin   Coordinate    `json:"origin"`    // South-west corner of the grid
+	Weighted bool          `json:"weighted"`  // Whether cells are weighted by departures
+	Date     time.Time     `json:"date"`      // Date of the counted departures, if weighted
+	Cells    []DensityCell `json:"cells"`     // Non-empty cells, ordered by row then column
+}
+
+// Returns a grid of the number of stops in each cell, with cells of the given size in metres.
+// Cells are approximately square, with their width in degrees set at the latitude of the centre of the network.
+func (g *GTFS) StopDensityGrid(cellSizeMeters float64) (*DensityGrid, error) {
+	return g.stopDensityGrid(cellSizeMeters, nil)
+}
+
+// Returns a grid of stops as with StopDensityGrid, with each cell also counting the departures
+// from its stops on the given date. The last stop of a trip is not considered a departure.
+func (g *GTFS) StopDepartureDensityGrid(cellSizeMeters float64, date time.Time) (*DensityGrid, error) {
+	departures, err := g.countStopDepartures(date)
+	if err != nil {
+		return nil, err
+	}
+	grid, err := g.stopDensityGrid(cellSizeMeters, departures)
+	if err != nil {
+		return nil, err
+	}
+	grid.Weighted = true
+	grid.Date = date
+	return grid, nil
+}
+
+// Build the density grid, adding the given departure counts of each stop if they are not nil
+func (g *GTFS) stopDensityGrid(cellSizeMeters float64, departures map[Key]int) (*DensityGrid, error) {
+	if cellSizeMeters <= 0 {
+		return nil, errors.New("cell size must be positive")
+	}
+
+	stops, err := g.GetAllStops()
+	if err != nil {
+		return nil, err
+	}
+
+	// Stops without a location cannot be placed in the grid
+	located := make([]*Stop, 0, len(stops))
+	bound := orb.Bound{Min: orb.Point{math.Inf(1), math.Inf(1)}, Max: orb.Point{math.Inf(-1), math.Inf(-1)}}
+	for _, stop := range stops {
+		if stop.Location.IsZero() || !stop.Location.IsValid() {
+			continue
+		}
+		located = append(located, stop)
+		bound = bound.Extend(orb.Point{stop.Location.Longitude, stop.Location.Latitude})
+	}
+
+	grid := &DensityGrid{
+		CellSize: cellSizeMeters,
+		Cells:    []DensityCell{},
+	}
+	if len(located) == 0 {
+		return grid, nil
+	}
+	grid.Origin = NewCoordinate(bound.Min.Lat(), bound.Min.Lon())
+
+	latStep := cellSizeMeters / metresPerDegree
+	lonStep := latStep / math.Cos(bound.Center().Lat()*math.Pi/180)
+
+	type cellIndex struct{ row, column int }
+	cells := make(map[cellIndex]*DensityCell)
+	for _, stop := range located {
+		index := cellIndex{
+			row:    int((stop.Location.Latitude - bound.Min.Lat()) / latStep),
+			column: int((stop.Location.Longitude - bound.Min.Lon()) / lonStep),
+		}
+
+		cell, ok := cells[index]
+		if !ok {
+			south := bound.Min.Lat() + float64(index.row)*latStep
+			west := bound.Min.Lon() + float64(index.column)*lonStep
+			cell = &DensityCell{
+				Row:       index.row,
+				Column:    index.column,
+				SouthWest: NewCoordinate(south, west),
+				NorthEast: NewCoordinate(south+latStep, west+lonStep),
+			}
+			cells[index] = cell
+		}
+		cell.Stops++
+		cell.Departures += departures[stop.ID]
+	}
+
+	for _, cell := range cells {
+		grid.Cells = append(grid.Cells, *cell)
+	}
+	sort.Slice(grid.Cells, func(i, j int) bool {
+		if grid.Cells[i].Row != grid.Cells[j].Row {
+			return grid.Cells[i].Row < grid.Cells[j].Row
+		}
+		return grid.Cells[i].Column < grid.Cells[j].Column
+	})
+	return grid, nil
+}
+
+// Count the departures from each stop on the given date
+func (g *GTFS) countStopDepartures(date time.Time) (map[Key]int, error) {
+	loc, err := g.getFeedTimezone()
+	if err != nil {
+		return nil, err
+	}
+	noon := serviceDayNoons(date, date, loc)[0]
+
+	trips, err := g.GetAllTrips()
+	if err != nil {
+		return nil, err
+	}
+
+	departures := make(map[Key]int)
+	runningCache := make(map[Key]bool)
+	for _, trip := range trips {
+		running, err := g.isTripRunning(trip, noon, runningCache)
+		if err != nil {
+			return nil, err
+		}
+		if !running {
+			continue
+		}
+		for _, stop := range trip.Stops[:max(len(trip.Stops)-1, 0)] {
+			departures[stop.StopID]++
+		}
+	}
+	return departures, nil
+}
+
+// GeoJSON feature of a density grid cell
+type densityFeature struct {
+	Type       string         `json:"type"`
+	Geometry   densityPolygon `json:"geometry"`
+	Properties map[string]int `json:"properties"`
+}
+
+// GeoJSON polygon of a density grid cell
+type densityPolygon struct {
+	Type        string         `json:"type"`
+	Coordinates [][][2]float64 `json:"coordinates"`
+}
+
+// Returns the grid as a GeoJSON feature collection, with a polygon feature for each non-empty cell.
+// Each feature has "row", "column" and "stops" properties, and "departures" if the grid is weighted.
+func (d *DensityGrid) GeoJSON() ([]byte, error) {
+	features := make([]densityFeature, 0, len(d.Cells))
+	for _, cell := range d.Cells {
+		south, west := cell.SouthWest.Latitude, cell.SouthWest.Longitude
+		north, east := cell.NorthEast.Latitude, cell.NorthEast.Longitude
+		properties := map[string]int{
+			"row":    cell.Row,
+			"column": cell.Column,
+			"stops":  cell.Stops,
+		}
+		if d.Weighted {
+			properties["departures"] = cell.Departures
+		}
+
+		features = append(features, densityFeature{
+			Type: "Feature",
+			Geometry: densityPolygon{
+				Type: "Polygon",
+				Coordinates: [][][2]float64{{
+					{west, south}, {east, south}, {east, north}, {west, north}, {west, south},
+				}},
+			},
+			Properties: properties,
+		})
+	}
+
+	return json.Marshal(struct {
+		Type     string           `json:"type"`
+		Features []densityFeature `json:"features"`
+	}{"FeatureCollection", features})
+}
+
+// Write the grid as CSV, with a row for each non-empty cell giving its position, bounds and counts.
+// The departures column is only included if the grid is weighted.
+func (d *DensityGrid) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+
+	header := []string{"row", "column", "min_lat", "min_lon", "max_lat", "max_lon", "stops"}
+	if d.Weighted {
+		header = append(header, "departures")
+	}
+	err := writer.Write(header)
+	if err != nil {
+		return err
+	}
+
+	formatFloat := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	for _, cell := range d.Cells {
+		record := []string{
+			strconv.Itoa(cell.Row),
+			strconv.Itoa(cell.Column),
+			formatFloat(cell.SouthWest.Latitude),
+			formatFloat(cell.SouthWest.Longitude),
+			formatFloat(cell.NorthEast.Latitude),
+			formatFloat(cell.NorthEast.Longitude),
+			strconv.Itoa(cell.Stops),
+		}
+		if d.Weighted {
+			record = append(record, strconv.Itoa(cell.Departures))
+		}
+		err := writer.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,166 @@
+package gtfs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Longest time between recomputations of subscribed departures. They are also recomputed as soon as the
+// next departure leaves, and when the database is refreshed.
+const departureStreamInterval = 30 * time.Second
+
+// Type of a departure event
+type DepartureEventType uint8
+
+const (
+	DepartureAddedEventType   DepartureEventType = iota // The departure entered the window
+	DepartureUpdatedEventType                           // The departure's time changed, e.g. from a realtime delay
+	DepartureRemovedEventType                           // The departure left, or is no longer in the window or running
+)
+
+// Returns the name of the departure event type, as used in logs
+func (t DepartureEventType) String() string {
+	switch t {
+	case DepartureAddedEventType:
+		return "DepartureAdded"
+	case DepartureUpdatedEventType:
+		return "DepartureUpdated"
+	case DepartureRemovedEventType:
+		return "DepartureRemoved"
+	default:
+		return "Unknown"
+	}
+}
+
+// A change to the departures from a subscribed stop. Removed events carry the departure as last emitted.
+type DepartureEvent struct {
+	Type      DepartureEventType `json:"type"`
+	Departure Departure          `json:"departure"`
+}
+
+// Identifies a departure across recomputations. A trip can depart a stop more than once within a window,
+// on loops or in windows longer than a day, so departures are also numbered in order of time.
+type departureKey struct {
+	tripID     Key
+	stopIndex  int
+	occurrence int
+}
+
+// Returns the identities of the departures, in order, and the departures keyed by them
+func keyDepartures(departures []Departure) ([]departureKey, map[departureKey]Departure) {
+	keys := make([]departureKey, len(departures))
+	keyed := make(map[departureKey]Departure, len(departures))
+	for i, departure := range departures {
+		key := departureKey{tripID: departure.TripID, stopIndex: departure.StopIndex}
+		for {
+			if _, ok := keyed[key]; !ok {
+				break
+			}
+			key.occurrence++
+		}
+		keys[i] = key
+		keyed[key] = departure
+	}
+	return keys, keyed
+}
+
+// Returns the events changing the previous departures into the next, with removals first, each in order of time
+func diffDepartures(previous, next []Departure) []DepartureEvent {
+	previousKeys, previousKeyed := keyDepartures(previous)
+	nextKeys, nextKeyed := keyDepartures(next)
+
+	events := []DepartureEvent{}
+	for _, key := range previousKeys {
+		if _, ok := nextKeyed[key]; !ok {
+			events = append(events, DepartureEvent{Type: DepartureRemovedEventType, Departure: previousKeyed[key]})
+		}
+	}
+	for _, key := range nextKeys {
+		departure := nextKeyed[key]
+		old, ok := previousKeyed[key]
+		if !ok {
+			events = append(events, DepartureEvent{Type: DepartureAddedEventType, Departure: departure})
+		} else if !old.Time.Equal(departure.Time) || old.Headsign != departure.Headsign {
+			events = append(events, DepartureEvent{Type: DepartureUpdatedEventType, Departure: departure})
+		}
+	}
+	return events
+}
+
+// Subscribes to the departures from the stop within the window starting at the current time, for long-lived
+// displays such as departure boards. The departures in the window are delivered as added events straight
+// away, followed by events as departures leave, enter the window or change with updates from an attached
+// realtime source or with added service exceptions. Departures are recomputed from the schedule as soon as
+// the next one leaves, and at least every 30 seconds, and the stop's trips are reloaded once each time the
+// database is refreshed. Failed recomputations are logged and leave the departures unchanged. The channel is closed once the returned function is called
+// to unsubscribe, and recomputation waits for events to be received, so subscribers should keep receiving
+// until then.
+func (g *GTFS) SubscribeDepartures(stopID Key, window time.Duration) (<-chan DepartureEvent, func(), error) {
+	prepared, err := g.PrepareDepartures(stopID)
+	if err != nil {
+		return nil, nil, err
+	}
+	changes, unsubscribeChanges := g.Subscribe()
+
+	events := make(chan DepartureEvent, 16)
+	done := make(chan struct{})
+	go func() {
+		defer close(events)
+		defer unsubscribeChanges()
+
+		current := []Departure{}
+		for {
+			wait := departureStreamInterval
+			departures, err := prepared.Departures(time.Now(), window)
+			if err != nil {
+				log.Errorf("Failed to recompute departures from stop %s: %v", stopID, err)
+			} else {
+				for _, event := range diffDepartures(current, departures) {
+					select {
+					case events <- event:
+					case <-done:
+						return
+					}
+				}
+				current = departures
+				if len(current) > 0 {
+					wait = min(wait, max(time.Until(current[0].Time), 0)+time.Millisecond)
+				}
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-done:
+				timer.Stop()
+				return
+			case <-changes:
+				timer.Stop()
+
+				// A refresh publishes several events, which are handled with a single reload
+			drain:
+				for {
+					select {
+					case <-changes:
+					default:
+						break drain
+					}
+				}
+				reloaded, err := g.PrepareDepartures(stopID)
+				if err != nil {
+					log.Errorf("Failed to reload departures from stop %s: %v", stopID, err)
+					continue
+				}
+				prepared = reloaded
+			case <-timer.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return events, func() {
+		once.Do(func() {
+			close(done)
+		})
+	}, nil
+}
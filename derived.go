@@ -0,0 +1,515 @@
+package gtfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Tolerance in degrees of route geometries precomputed at ingest, if not given
+const DefaultGeometryTolerance = 1e-4
+
+// Headway statistics of a route's trips in one direction on one service, from their start times
+type RouteHeadway struct {
+	RouteID        Key           `json:"route_id"`
+	Direction      TripDirection `json:"direction"`
+	ServiceID      Key           `json:"service_id"`
+	Trips          int           `json:"trips"`
+	FirstDeparture uint          `json:"first_departure"` // Seconds since the start of the service day
+	LastDeparture  uint          `json:"last_departure"`  // Seconds since the start of the service day
+	MinHeadway     uint          `json:"min_headway"`     // Seconds, zero if there are fewer than two trips
+	MedianHeadway  uint          `json:"median_headway"`  // Seconds, zero if there are fewer than two trips
+	MaxHeadway     uint          `json:"max_headway"`     // Seconds, zero if there are fewer than two trips
+}
+type RouteHeadwayArray []RouteHeadway
+
+// Encode the RouteHeadwayArray into a byte slice
+// Format:
+// - Count: 4 bytes (number of headways)
+// - Each headway:
+//   - RouteID: 4-byte length + UTF-8 string
+//   - Direction: 1 byte (bool)
+//   - ServiceID: 4-byte length + UTF-8 string
+//   - Trips, FirstDeparture, LastDeparture, MinHeadway, MedianHeadway, MaxHeadway: 4 bytes each (uint32)
+func (ha RouteHeadwayArray) Encode() []byte {
+	totalLen := lenBytes
+	for _, h := range ha {
+		totalLen += lenBytes + len(h.RouteID) + 1 + lenBytes + len(h.ServiceID) + 6*uint32Bytes
+	}
+
+	data := make([]byte, totalLen)
+	offset := 0
+
+	binary.BigEndian.PutUint32(data[offset:], uint32(len(ha)))
+	offset += lenBytes
+
+	for _, h := range ha {
+		binary.BigEndian.PutUint32(data[offset:], uint32(len(h.RouteID)))
+		offset += lenBytes
+		copy(data[offset:], h.RouteID)
+		offset += len(h.RouteID)
+
+		if h.Direction {
+			data[offset] = 1
+		}
+		offset++
+
+		binary.BigEndian.PutUint32(data[offset:], uint32(len(h.ServiceID)))
+		offset += lenBytes
+		copy(data[offset:], h.ServiceID)
+		offset += len(h.ServiceID)
+
+		for _, value := range []uint{uint(h.Trips), h.FirstDeparture, h.LastDeparture, h.MinHeadway, h.MedianHeadway, h.MaxHeadway} {
+			binary.BigEndian.PutUint32(data[offset:], uint32(value))
+			offset += uint32Bytes
+		}
+	}
+	return data
+}
+
+// Decode the byte slice into the RouteHeadwayArray
+func (ha *RouteHeadwayArray) Decode(data []byte) error {
+	if ha == nil {
+		return errors.New("cannot decode into a nil RouteHeadwayArray")
+	}
+	offset := 0
+
+	if offset+lenBytes > len(data) {
+		return errors.New("headway buffer too small for count")
+	}
+	count := binary.BigEndian.Uint32(data[offset:])
+	offset += lenBytes
+
+	// Read a length-prefixed key
+	readKey := func(i uint32, field string) (Key, error) {
+		if offset+lenBytes > len(data) {
+			return "", fmt.Errorf("headway buffer too small for headway %d %s length", i, field)
+		}
+		keyLen := int(binary.BigEndian.Uint32(data[offset:]))
+		offset += lenBytes
+		if offset+keyLen > len(data) {
+			return "", fmt.Errorf("headway buffer too small for headway %d %s content", i, field)
+		}
+		key := Key(data[offset : offset+keyLen])
+		offset += keyLen
+		return key, nil
+	}
+
+	headways := make(RouteHeadwayArray, count)
+	for i := uint32(0); i < count; i++ {
+		routeID, err := readKey(i, "RouteID")
+		if err != nil {
+			return err
+		}
+		if offset+1 > len(data) {
+			return fmt.Errorf("headway buffer too small for headway %d Direction", i)
+		}
+		direction := TripDirection(data[offset] == 1)
+		offset++
+		serviceID, err := readKey(i, "ServiceID")
+		if err != nil {
+			return err
+		}
+
+		if offset+6*uint32Bytes > len(data) {
+			return fmt.Errorf("headway buffer too small for headway %d values", i)
+		}
+		values := make([]uint, 6)
+		for j := range values {
+			values[j] = uint(binary.BigEndian.Uint32(data[offset:]))
+			offset += uint32Bytes
+		}
+		headways[i] = RouteHeadway{
+			RouteID:        routeID,
+			Direction:      direction,
+			ServiceID:      serviceID,
+			Trips:          int(values[0]),
+			FirstDeparture: values[1],
+			LastDeparture:  values[2],
+			MinHeadway:     values[3],
+			MedianHeadway:  values[4],
+			MaxHeadway:     values[5],
+		}
+	}
+
+	*ha = headways
+	return nil
+}
+
+// Compute the headway statistics of the route's trips for each direction and service, ordered by
+// direction (outbound first) and then by service ID
+func computeRouteHeadways(routeID Key, trips []*Trip) RouteHeadwayArray {
+	type headwayKey struct {
+		direction TripDirection
+		serviceID Key
+	}
+
+	starts := make(map[headwayKey][]uint)
+	for _, trip := range trips {
+		if len(trip.Stops) == 0 {
+			continue
+		}
+		key := headwayKey{trip.Direction, trip.ServiceID}
+		starts[key] = append(starts[key], trip.StartTime())
+	}
+
+	headways := make(RouteHeadwayArray, 0, len(starts))
+	for key, times := range starts {
+		sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+		headway := RouteHeadway{
+			RouteID:        routeID,
+			Direction:      key.direction,
+			ServiceID:      key.serviceID,
+			Trips:          len(times),
+			FirstDeparture: times[0],
+			LastDeparture:  times[len(times)-1],
+		}
+		if len(times) > 1 {
+			gaps := make([]uint, len(times)-1)
+			for i := range gaps {
+				gaps[i] = times[i+1] - times[i]
+			}
+			headway.MinHeadway = gaps[0]
+			headway.MaxHeadway = gaps[0]
+			for _, gap := range gaps {
+				headway.MinHeadway = min(headway.MinHeadway, gap)
+				headway.MaxHeadway = max(headway.MaxHeadway, gap)
+			}
+			headway.MedianHeadway = medianUint(gaps)
+		}
+		headways = append(headways, headway)
+	}
+
+	sort.Slice(headways, func(i, j int) bool {
+		if headways[i].Direction != headways[j].Direction {
+			return headways[i].Direction == OutboundTripDirection
+		}
+		return headways[i].ServiceID < headways[j].ServiceID
+	})
+	return headways
+}
+
+// A stop pattern as stored in the stopPatterns bucket, with its trips' numeric IDs
+type storedStopPattern struct {
+	stopIDs KeyArray
+	tripIDs numericIDArray // Sorted by start time
+}
+type storedStopPatternArray []storedStopPattern
+
+// Encode the storedStopPatternArray into a byte slice
+// Format:
+// - Count: 4 bytes (number of patterns)
+// - Each pattern:
+//   - StopIDs: 4-byte length + KeyArray
+//   - TripIDs: 4-byte length + numericIDArray
+func (pa storedStopPatternArray) Encode() []byte {
+	data := binary.BigEndian.AppendUint32(nil, uint32(len(pa)))
+	for _, p := range pa {
+		stopIDsBytes := p.stopIDs.Encode()
+		data = binary.BigEndian.AppendUint32(data, uint32(len(stopIDsBytes)))
+		data = append(data, stopIDsBytes...)
+
+		tripIDsBytes := p.tripIDs.Encode()
+		data = binary.BigEndian.AppendUint32(data, uint32(len(tripIDsBytes)))
+		data = append(data, tripIDsBytes...)
+	}
+	return data
+}
+
+// Decode the byte slice into the storedStopPatternArray
+func (pa *storedStopPatternArray) Decode(data []byte) error {
+	if pa == nil {
+		return errors.New("cannot decode into a nil storedStopPatternArray")
+	}
+	offset := 0
+
+	if offset+lenBytes > len(data) {
+		return errors.New("stop pattern buffer too small for count")
+	}
+	count := binary.BigEndian.Uint32(data[offset:])
+	offset += lenBytes
+
+	// Read a length-prefixed section
+	readSection := func(i uint32, field string) ([]byte, error) {
+		if offset+lenBytes > len(data) {
+			return nil, fmt.Errorf("stop pattern buffer too small for pattern %d %s length", i, field)
+		}
+		sectionLen := int(binary.BigEndian.Uint32(data[offset:]))
+		offset += lenBytes
+		if offset+sectionLen > len(data) {
+			return nil, fmt.Errorf("stop pattern buffer too small for pattern %d %s content", i, field)
+		}
+		section := data[offset : offset+sectionLen]
+		offset += sectionLen
+		return section, nil
+	}
+
+	patterns := make(storedStopPatternArray, count)
+	for i := uint32(0); i < count; i++ {
+		section, err := readSection(i, "StopIDs")
+		if err != nil {
+			return err
+		}
+		err = patterns[i].stopIDs.Decode(section)
+		if err != nil {
+			return err
+		}
+
+		section, err = readSection(i, "TripIDs")
+		if err != nil {
+			return err
+		}
+		err = patterns[i].tripIDs.Decode(section)
+		if err != nil {
+			return err
+		}
+	}
+
+	*pa = patterns
+	return nil
+}
+
+// Encode a route's simplified geometry with the tolerance it was simplified with
+// Format:
+// - Tolerance: 8 bytes (float64)
+// - Inbound: 4-byte length + CoordinateArray
+// - Outbound: 4-byte length + CoordinateArray
+func encodeRouteGeometry(tolerance float64, inbound, outbound CoordinateArray) []byte {
+	data := binary.BigEndian.AppendUint64(nil, math.Float64bits(tolerance))
+	for _, coords := range []CoordinateArray{inbound, outbound} {
+		coordsBytes := coords.Encode()
+		data = binary.BigEndian.AppendUint32(data, uint32(len(coordsBytes)))
+		data = append(data, coordsBytes...)
+	}
+	return data
+}
+
+// Decode a route's simplified geometry and the tolerance it was simplified with
+func decodeRouteGeometry(data []byte) (float64, CoordinateArray, CoordinateArray, error) {
+	if len(data) < float64Bytes {
+		return 0, nil, nil, errors.New("route geometry buffer too small for tolerance")
+	}
+	tolerance := math.Float64frombits(binary.BigEndian.Uint64(data))
+	offset := float64Bytes
+
+	directions := make([]CoordinateArray, 2)
+	for i := range directions {
+		if offset+lenBytes > len(data) {
+			return 0, nil, nil, fmt.Errorf("route geometry buffer too small for direction %d length", i)
+		}
+		coordsLen := int(binary.BigEndian.Uint32(data[offset:]))
+		offset += lenBytes
+		if offset+coordsLen > len(data) {
+			return 0, nil, nil, fmt.Errorf("route geometry buffer too small for direction %d content", i)
+		}
+		err := directions[i].Decode(data[offset : offset+coordsLen])
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		offset += coordsLen
+	}
+	return tolerance, directions[0], directions[1], nil
+}
+
+// Store the stop patterns, headway statistics and simplified geometries of each route in the stopPatterns,
+// routeHeadways and routeGeometries buckets
+func populateDerived(db *bolt.DB, opts IngestOptions, routes RouteMap, shapes ShapeMap, trips TripMap, tripIDs map[Key]uint32) error {
+	tolerance := opts.DerivedGeometryTolerance
+	if tolerance <= 0 {
+		tolerance = DefaultGeometryTolerance
+	}
+
+	routeTrips := make(map[Key][]*Trip)
+	for _, trip := range trips {
+		routeTrips[trip.RouteID] = append(routeTrips[trip.RouteID], trip)
+	}
+
+	return db.Batch(func(tx *bolt.Tx) error {
+		patternsBucket, err := tx.CreateBucketIfNotExists([]byte("stopPatterns"))
+		if err != nil {
+			return err
+		}
+		headwaysBucket, err := tx.CreateBucketIfNotExists([]byte("routeHeadways"))
+		if err != nil {
+			return err
+		}
+		geometriesBucket, err := tx.CreateBucketIfNotExists([]byte("routeGeometries"))
+		if err != nil {
+			return err
+		}
+
+		for routeID, trips := range routeTrips {
+			patterns := groupStopPatterns(trips)
+			stored := make(storedStopPatternArray, len(patterns))
+			for i, pattern := range patterns {
+				stored[i].stopIDs = pattern.StopIDs
+				stored[i].tripIDs = make(numericIDArray, len(pattern.Trips))
+				for j, trip := range pattern.Trips {
+					stored[i].tripIDs[j] = tripIDs[trip.ID]
+				}
+			}
+			err = patternsBucket.Put([]byte(routeID), stored.Encode())
+			if err != nil {
+				return err
+			}
+
+			err = headwaysBucket.Put([]byte(routeID), computeRouteHeadways(routeID, trips).Encode())
+			if err != nil {
+				return err
+			}
+		}
+
+		// Simplify the shape with the given ID, if it exists
+		simplified := func(shapeID *Key) CoordinateArray {
+			if shapeID == nil {
+				return CoordinateArray{}
+			}
+			shape, ok := shapes[*shapeID]
+			if !ok {
+				return CoordinateArray{}
+			}
+			return simplifyCoordinates(shape.Coordinates, tolerance)
+		}
+		for routeID, route := range routes {
+			err = geometriesBucket.Put([]byte(routeID), encodeRouteGeometry(tolerance, simplified(route.InboundShapeID), simplified(route.OutboundShapeID)))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Returns the value stored under the key of a bucket of precomputed data, or nil if it was not precomputed
+func (g *GTFS) getDerived(bucket string, key Key) ([]byte, error) {
+	var value []byte
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		if data := b.Get([]byte(key)); data != nil {
+			value = append([]byte{}, data...)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Returns the stop patterns of the route precomputed at ingest, or nil if they were not precomputed
+// or overrides may have changed them
+func (g *GTFS) getDerivedStopPatterns(routeID Key) ([]*StopPattern, error) {
+	if len(g.overrides.all(TripEntityType)) > 0 {
+		return nil, nil
+	}
+	data, err := g.getDerived("stopPatterns", routeID)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var stored storedStopPatternArray
+	err = stored.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids numericIDArray
+	for _, pattern := range stored {
+		ids = append(ids, pattern.tripIDs...)
+	}
+	var tripIDs KeyArray
+	err = g.view(func(tx *bolt.Tx) error {
+		tripIDs, err = numericKeys(tx, TripEntityType, ids)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	trips, err := g.GetTripsByIDs(tripIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	patterns := make([]*StopPattern, len(stored))
+	offset := 0
+	for i, pattern := range stored {
+		patterns[i] = &StopPattern{
+			StopIDs:   pattern.stopIDs,
+			Trips:     make([]*Trip, len(pattern.tripIDs)),
+			Frequency: len(pattern.tripIDs),
+		}
+		for j := range pattern.tripIDs {
+			trip, ok := trips[tripIDs[offset]]
+			if !ok {
+				return nil, fmt.Errorf("trip %w", ErrNotFound)
+			}
+			patterns[i].Trips[j] = trip
+			offset++
+		}
+	}
+	return patterns, nil
+}
+
+// Returns the headway statistics of the route's trips for each direction and service, ordered by direction
+// (outbound first) and then by service ID. They are read from the database if precomputed at ingest (see
+// IngestOptions.PrecomputeDerived).
+func (g *GTFS) GetRouteHeadways(routeID Key) (RouteHeadwayArray, error) {
+	if len(g.overrides.all(TripEntityType)) == 0 {
+		data, err := g.getDerived("routeHeadways", routeID)
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			var headways RouteHeadwayArray
+			err = headways.Decode(data)
+			if err != nil {
+				return nil, err
+			}
+			return headways, nil
+		}
+	}
+
+	trips, err := g.GetTripsByRouteID(routeID)
+	if err != nil {
+		return nil, err
+	}
+	tripList := make([]*Trip, 0, len(trips))
+	for _, trip := range trips {
+		tripList = append(tripList, trip)
+	}
+	return computeRouteHeadways(routeID, tripList), nil
+}
+
+// Returns the route's geometry precomputed at ingest, or nil if it was not precomputed with the given
+// tolerance or overrides may have changed it
+func (g *GTFS) getDerivedRouteGeometry(routeID Key, tolerance float64) (*RouteGeometry, error) {
+	if len(g.overrides.all(RouteEntityType)) > 0 || len(g.overrides.all(ShapeEntityType)) > 0 {
+		return nil, nil
+	}
+	data, err := g.getDerived("routeGeometries", routeID)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	storedTolerance, inbound, outbound, err := decodeRouteGeometry(data)
+	if err != nil {
+		return nil, err
+	}
+	if storedTolerance != tolerance {
+		return nil, nil
+	}
+
+	return &RouteGeometry{
+		RouteID:          routeID,
+		Inbound:          inbound,
+		Outbound:         outbound,
+		InboundPolyline:  encodePolyline(inbound, defaultPolylinePrecision),
+		OutboundPolyline: encodePolyline(outbound, defaultPolylinePrecision),
+	}, nil
+}
@@ -0,0 +1,92 @@
+package gtfs
+
+import (
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Everything needed to display a trip, fetched together
+type TripDetail struct {
+	Trip    *Trip    `json:"trip"`
+	Route   *Route   `json:"route"`
+	Agency  *Agency  `json:"agency"`
+	Service *Service `json:"service"`
+	Shape   *Shape   `json:"shape,omitempty"` // Nil if the trip has no shape
+	Stops   []*Stop  `json:"stops"`           // Stops of the trip, in the order of its stop times
+}
+
+// Returns the trip with its route, agency, service, shape and stops, read within a single transaction
+// so that they are consistent with each other. Routes without an agency ID are given the feed's agency
+// if it has only one.
+func (g *GTFS) GetTripDetail(tripID Key) (*TripDetail, error) {
+	detail := &TripDetail{}
+	err := g.view(func(tx *bolt.Tx) error {
+		// Run every query in this transaction, as with a snapshot
+		pinned := *g
+		pinned.tx = tx
+
+		var err error
+		detail.Trip, err = pinned.GetTripByID(tripID)
+		if err != nil {
+			return err
+		}
+		detail.Route, err = pinned.GetRouteByID(detail.Trip.RouteID)
+		if err != nil {
+			return err
+		}
+		detail.Service, err = pinned.GetServiceByID(detail.Trip.ServiceID)
+		if err != nil {
+			return err
+		}
+
+		if detail.Route.AgencyID != "" {
+			detail.Agency, err = pinned.GetAgencyByID(detail.Route.AgencyID)
+			if err != nil {
+				return err
+			}
+		} else {
+			agencies, err := pinned.GetAllAgencies()
+			if err != nil {
+				return err
+			}
+			if len(agencies) != 1 {
+				return fmt.Errorf("agency %w", ErrNotFound)
+			}
+			for _, agency := range agencies {
+				detail.Agency = agency
+			}
+		}
+
+		if detail.Trip.ShapeID != "" {
+			shapes, err := pinned.GetShapesByIDs([]Key{detail.Trip.ShapeID})
+			if err != nil {
+				return err
+			}
+			detail.Shape = shapes[detail.Trip.ShapeID]
+		}
+
+		stopIDs := make([]Key, len(detail.Trip.Stops))
+		for i, stop := range detail.Trip.Stops {
+			stopIDs[i] = stop.StopID
+		}
+		stops, err := pinned.GetStopsByIDs(stopIDs)
+		if err != nil {
+			return err
+		}
+		detail.Stops = make([]*Stop, len(stopIDs))
+		for i, stopID := range stopIDs {
+			stop, ok := stops[stopID]
+			if !ok {
+				return fmt.Errorf("stop %s %w", stopID, ErrNotFound)
+			}
+			detail.Stops[i] = stop
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
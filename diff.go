@@ -0,0 +1,209 @@
+package gtfs
+
+import (
+	"bytes"
+	"slices"
+)
+
+// Metres within which a removed stop is matched with a remaining stop, if not given
+const defaultConsolidationRadius = 100
+
+// Options for comparing two versions of a feed
+type DiffOptions struct {
+	// Metres a stop must move to be reported as moved (defaults to reporting any move)
+	MinStopMove float64
+
+	// Metres within which a removed stop is reported as consolidated into the nearest stop of the new
+	// version (defaults to 100)
+	ConsolidationRadius float64
+}
+
+// A stop whose location differs between two versions of a feed
+type StopMove struct {
+	StopID   Key        `json:"stop_id"`
+	From     Coordinate `json:"from"`
+	To       Coordinate `json:"to"`
+	Distance float64    `json:"distance"` // Metres
+}
+
+// A stop removed from a feed which has a nearby stop in the new version, such as when two stops are merged
+type StopConsolidation struct {
+	StopID     Key     `json:"stop_id"`      // Removed stop
+	IntoStopID Key     `json:"into_stop_id"` // Nearest stop of the new version
+	Distance   float64 `json:"distance"`     // Metres between the stops
+}
+
+// A route whose name differs between two versions of a feed
+type RouteRename struct {
+	RouteID Key    `json:"route_id"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+}
+
+// The number of trips of a route in two versions of a feed
+type RouteTripDelta struct {
+	RouteID Key `json:"route_id"`
+	Before  int `json:"before"` // Zero if the route was added
+	After   int `json:"after"`  // Zero if the route was removed
+}
+
+// Returns the change in the number of trips
+func (d RouteTripDelta) Delta() int {
+	return d.After - d.Before
+}
+
+// Changes between two versions of a feed, for reviewing before publishing. Each list is sorted by ID.
+type FeedDiff struct {
+	StopsAdded        []Key               `json:"stops_added"`
+	StopsRemoved      []Key               `json:"stops_removed"`
+	StopsMoved        []StopMove          `json:"stops_moved"`
+	StopsConsolidated []StopConsolidation `json:"stops_consolidated"` // Removed stops with a nearby stop
+	RoutesAdded       []Key               `json:"routes_added"`
+	RoutesRemoved     []Key               `json:"routes_removed"`
+	RoutesRenamed     []RouteRename       `json:"routes_renamed"`
+	TripDeltas        []RouteTripDelta    `json:"trip_deltas"` // Routes whose number of trips changed
+}
+
+// Check if the versions have no differences covered by the diff
+func (d *FeedDiff) Empty() bool {
+	return len(d.StopsAdded) == 0 && len(d.StopsRemoved) == 0 && len(d.StopsMoved) == 0 &&
+		len(d.RoutesAdded) == 0 && len(d.RoutesRemoved) == 0 && len(d.RoutesRenamed) == 0 && len(d.TripDeltas) == 0
+}
+
+// Compares two versions of a feed, reporting the stops added, removed, moved and consolidated, the routes
+// added, removed and renamed, and the change in the number of trips of each route. Entities are matched
+// by ID, so an entity whose ID changed is reported as removed and added.
+func DiffFeeds(oldDB, newDB *GTFS, opts DiffOptions) (*FeedDiff, error) {
+	radius := opts.ConsolidationRadius
+	if radius <= 0 {
+		radius = defaultConsolidationRadius
+	}
+
+	diff := &FeedDiff{
+		StopsAdded:        []Key{},
+		StopsRemoved:      []Key{},
+		StopsMoved:        []StopMove{},
+		StopsConsolidated: []StopConsolidation{},
+		RoutesAdded:       []Key{},
+		RoutesRemoved:     []Key{},
+		RoutesRenamed:     []RouteRename{},
+		TripDeltas:        []RouteTripDelta{},
+	}
+
+	// Stops
+	oldStops, err := oldDB.GetAllStops()
+	if err != nil {
+		return nil, err
+	}
+	newStops, err := newDB.GetAllStops()
+	if err != nil {
+		return nil, err
+	}
+	added, removed, moved := diffEntities(oldStops, newStops, stopMoved)
+	diff.StopsAdded = append(diff.StopsAdded, added...)
+	for _, id := range moved {
+		from, to := oldStops[id].Location, newStops[id].Location
+		if distance := from.DistanceTo(to); distance >= opts.MinStopMove {
+			diff.StopsMoved = append(diff.StopsMoved, StopMove{StopID: id, From: from, To: to, Distance: distance})
+		}
+	}
+	for _, id := range removed {
+		diff.StopsRemoved = append(diff.StopsRemoved, id)
+
+		nearest, err := newDB.GetNearestStops(oldStops[id].Location, 1, radius)
+		if err != nil {
+			return nil, err
+		}
+		if len(nearest) > 0 {
+			diff.StopsConsolidated = append(diff.StopsConsolidated, StopConsolidation{
+				StopID:     id,
+				IntoStopID: nearest[0].Stop.ID,
+				Distance:   nearest[0].Distance,
+			})
+		}
+	}
+
+	// Routes
+	oldRoutes, err := oldDB.GetAllRoutes()
+	if err != nil {
+		return nil, err
+	}
+	newRoutes, err := newDB.GetAllRoutes()
+	if err != nil {
+		return nil, err
+	}
+	added, removed, renamed := diffEntities(oldRoutes, newRoutes, func(before, after *Route) bool {
+		return before.Name != after.Name
+	})
+	diff.RoutesAdded = append(diff.RoutesAdded, added...)
+	diff.RoutesRemoved = append(diff.RoutesRemoved, removed...)
+	for _, id := range renamed {
+		diff.RoutesRenamed = append(diff.RoutesRenamed, RouteRename{RouteID: id, From: oldRoutes[id].Name, To: newRoutes[id].Name})
+	}
+
+	// Trip counts
+	oldCounts, err := tripCountsByRoute(oldDB)
+	if err != nil {
+		return nil, err
+	}
+	newCounts, err := tripCountsByRoute(newDB)
+	if err != nil {
+		return nil, err
+	}
+	routeIDs := sortedIDs(oldCounts)
+	for _, id := range sortedIDs(newCounts) {
+		if _, ok := oldCounts[id]; !ok {
+			routeIDs = append(routeIDs, id)
+		}
+	}
+	slices.Sort(routeIDs)
+	for _, id := range routeIDs {
+		if oldCounts[id] != newCounts[id] {
+			diff.TripDeltas = append(diff.TripDeltas, RouteTripDelta{RouteID: id, Before: oldCounts[id], After: newCounts[id]})
+		}
+	}
+
+	return diff, nil
+}
+
+// Returns the number of trips of each route with any trips
+func tripCountsByRoute(g *GTFS) (map[Key]int, error) {
+	trips, err := g.GetAllTrips()
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[Key]int)
+	for _, trip := range trips {
+		counts[trip.RouteID]++
+	}
+	return counts, nil
+}
+
+// Returns the IDs of entities added to, removed from and changed between the maps, each sorted. Entities in
+// both maps are compared with the changed function.
+func diffEntities[T any](before, after map[Key]T, changed func(before, after T) bool) (added, removed, changedIDs []Key) {
+	for _, id := range sortedIDs(after) {
+		old, ok := before[id]
+		if !ok {
+			added = append(added, id)
+		} else if changed(old, after[id]) {
+			changedIDs = append(changedIDs, id)
+		}
+	}
+	for _, id := range sortedIDs(before) {
+		if _, ok := after[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	return added, removed, changedIDs
+}
+
+// Check whether the entities' encodings differ
+func encodingChanged[T interface{ Encode() []byte }](before, after T) bool {
+	return !bytes.Equal(before.Encode(), after.Encode())
+}
+
+// Check whether the stop's location differs
+func stopMoved(before, after *Stop) bool {
+	return before.Location != after.Location
+}
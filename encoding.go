@@ -0,0 +1,198 @@
+package gtfs
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Serialization format used for the entity values stored in the database
+type Encoding uint8
+
+const (
+	// Compact length-prefixed binary layout (see each entity's Encode method)
+	BinaryEncoding Encoding = iota
+	// Protocol buffers wire format, as described by gtfs.proto
+	ProtobufEncoding
+)
+
+// Return a string representation of the encoding
+func (e Encoding) String() string {
+	switch e {
+	case BinaryEncoding:
+		return "binary"
+	case ProtobufEncoding:
+		return "protobuf"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(e))
+	}
+}
+
+// Parse an encoding from its string representation
+func ParseEncoding(s string) (Encoding, error) {
+	switch s {
+	case "binary":
+		return BinaryEncoding, nil
+	case "protobuf":
+		return ProtobufEncoding, nil
+	default:
+		return 0, errors.New("unknown encoding: " + s)
+	}
+}
+
+// An entity which can be serialized using any supported encoding
+type encodable interface {
+	Encode() []byte
+	EncodeProto() []byte
+}
+
+// An entity which can be deserialized from any supported encoding
+type decodable interface {
+	Decode(id Key, data []byte) error
+	DecodeProto(id Key, data []byte) error
+}
+
+// Encode an entity using the given encoding
+func encodeEntity(e encodable, enc Encoding) []byte {
+	if enc == ProtobufEncoding {
+		return e.EncodeProto()
+	}
+	return e.Encode()
+}
+
+// Decode an entity using the given encoding
+func decodeEntity(e decodable, id Key, data []byte, enc Encoding) error {
+	switch enc {
+	case BinaryEncoding:
+		return e.Decode(id, data)
+	case ProtobufEncoding:
+		return e.DecodeProto(id, data)
+	default:
+		return fmt.Errorf("unsupported encoding: %s", enc)
+	}
+}
+
+// Decode a service exception using the given encoding.
+// Service exceptions carry their own service ID, so they are decoded without a key.
+func decodeServiceException(se *ServiceException, data []byte, enc Encoding) error {
+	switch enc {
+	case BinaryEncoding:
+		return se.Decode(data)
+	case ProtobufEncoding:
+		return se.DecodeProto(data)
+	default:
+		return fmt.Errorf("unsupported encoding: %s", enc)
+	}
+}
+
+// --- Protobuf Helpers ---
+
+// A single decoded protobuf field value
+type protoValue struct {
+	typ    protowire.Type
+	varint uint64
+	fixed  uint64
+	bytes  []byte
+}
+
+// Interpret the value as a double
+func (v protoValue) float64() float64 {
+	return math.Float64frombits(v.fixed)
+}
+
+// Interpret the value as a string
+func (v protoValue) string() string {
+	return string(v.bytes)
+}
+
+// Interpret the value as a bool
+func (v protoValue) bool() bool {
+	return v.varint != 0
+}
+
+// Append a string field, omitting it if empty
+func appendProtoString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// Append an embedded message field
+func appendProtoMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+// Append a varint field, omitting it if zero
+func appendProtoVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// Append a signed varint field (zig-zag encoded), omitting it if zero
+func appendProtoSint(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, protowire.EncodeZigZag(v))
+}
+
+// Append a bool field, omitting it if false
+func appendProtoBool(b []byte, num protowire.Number, v bool) []byte {
+	return appendProtoVarint(b, num, protowire.EncodeBool(v))
+}
+
+// Append a double field, omitting it if zero
+func appendProtoDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+// Iterate over the fields of a protobuf message, calling fn for each one.
+// Unknown fields are passed to fn as well, which is expected to ignore them
+// so that older readers can decode values written by newer schemas.
+func decodeProtoFields(data []byte, fn func(num protowire.Number, v protoValue) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		v := protoValue{typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			v.varint, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			v.fixed, n = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var fixed32 uint32
+			fixed32, n = protowire.ConsumeFixed32(data)
+			v.fixed = uint64(fixed32)
+		case protowire.BytesType:
+			v.bytes, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return fmt.Errorf("field %d: %w", num, protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		if err := fn(num, v); err != nil {
+			return fmt.Errorf("field %d: %w", num, err)
+		}
+	}
+	return nil
+}
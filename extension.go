@@ -0,0 +1,212 @@
+package gtfs
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Prefix for the names of buckets holding extension entities
+const extensionBucketPrefix = "extension:"
+
+// Parses a nonstandard file from a GTFS zip into raw entity values keyed by ID.
+// The values are stored as-is in the extension's bucket.
+type ExtensionParser func(file io.Reader) (map[Key][]byte, error)
+
+var (
+	extensionParsersMu sync.RWMutex
+	extensionParsers   = make(map[string]ExtensionParser)
+)
+
+// Registers a parser for a nonstandard file (e.g. "route_notes.txt") shipped inside GTFS zips.
+// When the file is present during ingest, the parsed entities are stored in their own bucket
+// and can be queried with GetExtensionEntity and GetAllExtensionEntities.
+// Registering a parser for a filename that already has one replaces it.
+func RegisterExtensionParser(filename string, parser ExtensionParser) {
+	extensionParsersMu.Lock()
+	defer extensionParsersMu.Unlock()
+
+	if parser == nil {
+		delete(extensionParsers, filename)
+		return
+	}
+	extensionParsers[filename] = parser
+}
+
+// Returns a copy of the registered extension parsers
+func registeredExtensionParsers() map[string]ExtensionParser {
+	extensionParsersMu.RLock()
+	defer extensionParsersMu.RUnlock()
+
+	parsers := make(map[string]ExtensionParser, len(extensionParsers))
+	for filename, parser := range extensionParsers {
+		parsers[filename] = parser
+	}
+	return parsers
+}
+
+// Returns a parser for CSV extension files which groups rows by the given key column.
+// Each key maps to a JSON array of the rows sharing it, with each row encoded as an
+// object of column name to value. Rows with an empty key are skipped, as they cannot be stored.
+// Use DecodeExtensionRecords to read the values back.
+func NewCSVExtensionParser(keyColumn string) ExtensionParser {
+	return func(file io.Reader) (map[Key][]byte, error) {
+		// Read file using CSV reader
+		reader := csv.NewReader(file)
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return nil, errors.New("extension file is empty")
+		}
+
+		header := records[0]
+		keyIndex := -1
+		for i, name := range header {
+			if name == keyColumn {
+				keyIndex = i
+				break
+			}
+		}
+		if keyIndex == -1 {
+			return nil, errors.New("key column not found: " + keyColumn)
+		}
+
+		rows := make(map[Key][]map[string]string)
+		for _, record := range records[1:] {
+			row := make(map[string]string, len(header))
+			for i, name := range header {
+				if i < len(record) {
+					row[name] = record[i]
+				}
+			}
+			key := Key(row[keyColumn])
+			if key == "" {
+				continue
+			}
+			rows[key] = append(rows[key], row)
+		}
+
+		entities := make(map[Key][]byte, len(rows))
+		for key, keyRows := range rows {
+			data, err := json.Marshal(keyRows)
+			if err != nil {
+				return nil, err
+			}
+			entities[key] = data
+		}
+		return entities, nil
+	}
+}
+
+// Decodes a value produced by a parser from NewCSVExtensionParser into its rows
+func DecodeExtensionRecords(data []byte) ([]map[string]string, error) {
+	var rows []map[string]string
+	err := json.Unmarshal(data, &rows)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Stores the parsed extension entities in their own buckets, skipping any with an empty key
+func populateExtensions(db *bolt.DB, extensions map[string]map[Key][]byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		for filename, entities := range extensions {
+			b, err := tx.CreateBucketIfNotExists([]byte(extensionBucketPrefix + filename))
+			if err != nil {
+				return err
+			}
+			for key, value := range entities {
+				if key == "" {
+					continue
+				}
+				err := b.Put([]byte(key), value)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Returns the names of the extension files stored in the GTFS database
+func (g *GTFS) GetExtensions() ([]string, error) {
+	var filenames []string
+
+	err := g.view(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if filename, ok := strings.CutPrefix(string(name), extensionBucketPrefix); ok {
+				filenames = append(filenames, filename)
+			}
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(filenames)
+	return filenames, nil
+}
+
+// Returns the raw value of the extension entity with the given key
+func (g *GTFS) GetExtensionEntity(filename string, key Key) ([]byte, error) {
+	var value []byte
+
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(extensionBucketPrefix + filename))
+		if b == nil {
+			return fmt.Errorf("extension %w", ErrNotFound)
+		}
+		data := b.Get([]byte(key))
+		if data == nil {
+			return fmt.Errorf("extension entity %w", ErrNotFound)
+		}
+
+		// Copy the data, as it is only valid for the life of the transaction
+		value = make([]byte, len(data))
+		copy(value, data)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Returns the raw values of all entities stored for the given extension file
+func (g *GTFS) GetAllExtensionEntities(filename string) (map[Key][]byte, error) {
+	var entities map[Key][]byte
+
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(extensionBucketPrefix + filename))
+		if b == nil {
+			return fmt.Errorf("extension %w", ErrNotFound)
+		}
+
+		entities = make(map[Key][]byte, b.Stats().KeyN)
+
+		return b.ForEach(func(k, v []byte) error {
+			value := make([]byte, len(v))
+			copy(value, v)
+			entities[Key(k)] = value
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return entities, nil
+}
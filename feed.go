@@ -0,0 +1,301 @@
+package gtfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// Parsed contents of a GTFS feed, prior to being stored in a database
+type Feed struct {
+	Agencies          AgencyMap
+	Routes            RouteMap
+	Services          ServiceMap
+	ServiceExceptions ServiceExceptionMap
+	Shapes            ShapeMap
+	Stops             StopMap
+	Trips             TripMap
+	Transfers         TransferArray
+	Extensions        map[string]map[Key][]byte
+
+	// Shapes inferred for trips without one, stored apart from the feed's own shapes
+	derivedShapes ShapeMap
+
+	// Parse reports for each standard file, keyed by file name
+	Reports map[string]*FileReport
+}
+
+// Error which occurred while parsing a specific GTFS file
+type FileError struct {
+	File string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return e.File + ": " + e.Err.Error()
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// Parse the files of a GTFS feed concurrently, keyed by their names within the feed (e.g. "stops.txt").
+// Optional files which are absent are skipped. If any files fail to parse, the returned error joins a
+// FileError for each of them, ordered by file name, and the returned feed contains the remaining files.
+func ParseFeed(files map[string]io.Reader) (*Feed, error) {
+	return ParseFeedWithOptions(files, ParseOptions{})
+}
+
+// Parse the files of a GTFS feed concurrently as with ParseFeed, handling malformed rows according to
+// the given options. The report for each parsed file is recorded in the feed's Reports.
+func ParseFeedWithOptions(files map[string]io.Reader, opts ParseOptions) (*Feed, error) {
+	feed := &Feed{
+		Agencies:          make(AgencyMap),
+		Routes:            make(RouteMap),
+		Services:          make(ServiceMap),
+		ServiceExceptions: make(ServiceExceptionMap),
+		Shapes:            make(ShapeMap),
+		Stops:             make(StopMap),
+		Trips:             make(TripMap),
+		Transfers:         TransferArray{},
+		Extensions:        make(map[string]map[Key][]byte),
+		Reports:           make(map[string]*FileReport),
+	}
+
+	var reportsMu sync.Mutex
+	addReports := func(reports ...*FileReport) {
+		reportsMu.Lock()
+		defer reportsMu.Unlock()
+		for _, report := range reports {
+			feed.Reports[report.File] = report
+		}
+	}
+
+	var group errgroup.Group
+	var errsMu sync.Mutex
+	var errs []*FileError
+
+	// Run a parse function in the group, recording any failure against the given file
+	parse := func(file string, fn func() error) {
+		group.Go(func() error {
+			err := fn()
+			if err != nil {
+				fileErr := &FileError{File: file, Err: err}
+				errsMu.Lock()
+				errs = append(errs, fileErr)
+				errsMu.Unlock()
+				return fileErr
+			}
+			return nil
+		})
+	}
+
+	// Missing files are skipped, leaving their entities empty, so that minimal feeds can be parsed.
+	// Which files are required is checked when ingesting.
+
+	// Load agencies
+	if _, ok := files["agency.txt"]; ok {
+		parse("agency.txt", func() error {
+			agencies, report, err := ParseAgenciesWithOptions(files["agency.txt"], opts)
+			if err != nil {
+				return err
+			}
+			addReports(report)
+			log.Debugf("Parsed %d agencies", len(agencies))
+			feed.Agencies = agencies
+			return nil
+		})
+	} else {
+		log.Debugf("agency.txt not found, skipping")
+	}
+
+	// Load routes
+	if _, ok := files["routes.txt"]; ok {
+		parse("routes.txt", func() error {
+			routes, report, err := ParseRoutesWithOptions(files["routes.txt"], opts)
+			if err != nil {
+				return err
+			}
+			addReports(report)
+			log.Debugf("Parsed %d routes", len(routes))
+			feed.Routes = routes
+			return nil
+		})
+	} else {
+		log.Debugf("routes.txt not found, skipping")
+	}
+
+	// Load services (calendar.txt)
+	if _, ok := files["calendar.txt"]; ok {
+		parse("calendar.txt", func() error {
+			services, report, err := ParseServicesWithOptions(files["calendar.txt"], opts)
+			if err != nil {
+				return err
+			}
+			addReports(report)
+			log.Debugf("Parsed %d services", len(services))
+			feed.Services = services
+			return nil
+		})
+	} else {
+		log.Debugf("calendar.txt not found, skipping")
+	}
+
+	// Load service exceptions (calendar_dates.txt) - Optional file
+	if reader, ok := files["calendar_dates.txt"]; ok {
+		parse("calendar_dates.txt", func() error {
+			serviceExceptions, report, err := ParseServiceExceptionsWithOptions(reader, opts)
+			if err != nil {
+				return err
+			}
+			addReports(report)
+			log.Debugf("Parsed %d service exceptions", len(serviceExceptions))
+			feed.ServiceExceptions = serviceExceptions
+			return nil
+		})
+	} else {
+		log.Debugf("calendar_dates.txt not found, skipping")
+	}
+
+	// Load shapes (shapes.txt) - Optional file
+	if reader, ok := files["shapes.txt"]; ok {
+		parse("shapes.txt", func() error {
+			shapes, report, err := ParseShapesWithOptions(reader, opts)
+			if err != nil {
+				return err
+			}
+			addReports(report)
+			log.Debugf("Parsed %d shapes", len(shapes))
+			feed.Shapes = shapes
+			return nil
+		})
+	} else {
+		log.Debugf("shapes.txt not found, skipping")
+	}
+
+	// Load stops
+	if _, ok := files["stops.txt"]; ok {
+		parse("stops.txt", func() error {
+			stops, report, err := ParseStopsWithOptions(files["stops.txt"], opts)
+			if err != nil {
+				return err
+			}
+			addReports(report)
+			log.Debugf("Parsed %d stops", len(stops))
+			feed.Stops = stops
+			return nil
+		})
+	} else {
+		log.Debugf("stops.txt not found, skipping")
+	}
+
+	// Load trips (trips.txt and stop_times.txt)
+	if _, ok := files["trips.txt"]; ok {
+		parse("trips.txt", func() error {
+			trips, reports, err := ParseTripsWithOptions(files["trips.txt"], files["stop_times.txt"], opts)
+			if err != nil {
+				return err
+			}
+			addReports(reports...)
+			log.Debugf("Parsed %d trips", len(trips))
+			feed.Trips = trips
+			return nil
+		})
+	} else {
+		log.Debugf("trips.txt not found, skipping")
+	}
+
+	// Load transfers (transfers.txt) - Optional file
+	if reader, ok := files["transfers.txt"]; ok {
+		parse("transfers.txt", func() error {
+			transfers, report, err := ParseTransfersWithOptions(reader, opts)
+			if err != nil {
+				return err
+			}
+			addReports(report)
+			log.Debugf("Parsed %d transfers", len(transfers))
+			feed.Transfers = transfers
+			return nil
+		})
+	} else {
+		log.Debugf("transfers.txt not found, skipping")
+	}
+
+	// Load registered extension files - Optional files
+	var extensionsMu sync.Mutex
+	for filename, parser := range registeredExtensionParsers() {
+		reader, ok := files[filename]
+		if !ok {
+			// Files opened from a zip archive are keyed by lowercase name
+			reader, ok = files[strings.ToLower(filename)]
+		}
+		if !ok {
+			log.Debugf("%s not found, skipping", filename)
+			continue
+		}
+
+		parse(filename, func() error {
+			entities, err := parser(reader)
+			if err != nil {
+				return err
+			}
+			log.Debugf("Parsed %d entities from %s", len(entities), filename)
+			extensionsMu.Lock()
+			feed.Extensions[filename] = entities
+			extensionsMu.Unlock()
+			return nil
+		})
+	}
+
+	err := group.Wait()
+	resolveRouteAgencies(feed)
+	if err == nil {
+		return feed, nil
+	}
+
+	// Join all failures in a deterministic order
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].File < errs[j].File
+	})
+	joined := make([]error, len(errs))
+	for i, err := range errs {
+		joined[i] = err
+	}
+	return feed, errors.Join(joined...)
+}
+
+// Assign routes without an agency_id to the feed's agency. The field may only be omitted when the feed has
+// a single agency, so routes are left as they are if there are several.
+func resolveRouteAgencies(feed *Feed) {
+	if len(feed.Agencies) != 1 {
+		return
+	}
+	for agencyID := range feed.Agencies {
+		for _, route := range feed.Routes {
+			if route.AgencyID == "" {
+				route.AgencyID = agencyID
+			}
+		}
+	}
+}
+
+// Returns the number of entities of each type in the feed, for logging
+func (f *Feed) String() string {
+	return fmt.Sprintf("%d agencies, %d routes, %d services, %d service exceptions, %d shapes, %d stops, %d trips, %d transfers",
+		len(f.Agencies), len(f.Routes), len(f.Services), len(f.ServiceExceptions), len(f.Shapes), len(f.Stops), len(f.Trips), len(f.Transfers))
+}
+
+// Returns the total number of rows skipped across all parsed files
+func (f *Feed) SkippedRows() int {
+	skipped := 0
+	for _, report := range f.Reports {
+		skipped += report.SkippedRows
+	}
+	return skipped
+}
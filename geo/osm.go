@@ -0,0 +1,250 @@
+// Package geo provides utilities for aligning GTFS data with other geographic datasets.
+package geo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/aaroncutress/gtfs-go"
+)
+
+// Maximum distance in metres between a stop and an OSM node for them to be matched
+const MaxMatchDistance = 150.0
+
+// Weights of the distance and name similarity in a match's confidence
+const (
+	distanceWeight = 0.6
+	nameWeight     = 0.4
+)
+
+// Returned by MatchStopsToOSM for OSM PBF data, which must be converted to OSM XML (e.g. with osmium cat)
+var ErrPBFNotSupported = errors.New("OSM PBF data is not supported, convert it to OSM XML")
+
+// Start of the first block header of an OSM PBF file, after its 4-byte length: a type field holding "OSMHeader"
+var pbfHeader = append([]byte{0x0a, 0x09}, "OSMHeader"...)
+
+// Size of the grid cells used to index OSM nodes, in degrees of latitude
+var gridCellSize = MaxMatchDistance / 111320.0
+
+// A GTFS stop matched to an OpenStreetMap node
+type StopMatch struct {
+	StopID         gtfs.Key `json:"stop_id"`
+	NodeID         int64    `json:"node_id"`
+	NodeName       string   `json:"node_name"`
+	Distance       float64  `json:"distance"`        // Metres
+	NameSimilarity float64  `json:"name_similarity"` // 0 (unrelated) to 1 (identical)
+	Confidence     float64  `json:"confidence"`      // 0 (weakest) to 1 (strongest)
+}
+
+// A public transport node read from OSM data
+type osmNode struct {
+	ID       int64
+	Name     string
+	Location gtfs.Coordinate
+}
+
+// Cell of the grid used to index OSM nodes
+type gridCell struct {
+	lat, lon int
+}
+
+// Returns the grid cell containing the coordinate
+func cellOf(c gtfs.Coordinate) gridCell {
+	return gridCell{
+		lat: int(math.Floor(c.Latitude / gridCellSize)),
+		lon: int(math.Floor(c.Longitude / gridCellSize)),
+	}
+}
+
+// Check whether a node's tags mark it as a public transport stop, platform or station
+func isTransitNode(tags map[string]string) bool {
+	switch {
+	case tags["highway"] == "bus_stop":
+		return true
+	case tags["public_transport"] == "platform", tags["public_transport"] == "stop_position", tags["public_transport"] == "station":
+		return true
+	case tags["railway"] == "station", tags["railway"] == "halt", tags["railway"] == "tram_stop", tags["railway"] == "platform":
+		return true
+	case tags["amenity"] == "ferry_terminal", tags["amenity"] == "bus_station":
+		return true
+	}
+	return false
+}
+
+// Read the public transport nodes from OSM XML data
+func readOSMNodes(r io.Reader) ([]osmNode, error) {
+	br := bufio.NewReader(r)
+
+	// PBF files start with the length of their first block header, rather than XML
+	start, err := br.Peek(4 + len(pbfHeader))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(start) == 4+len(pbfHeader) && bytes.Equal(start[4:], pbfHeader) {
+		return nil, ErrPBFNotSupported
+	}
+
+	decoder := xml.NewDecoder(br)
+	var nodes []osmNode
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		element, ok := token.(xml.StartElement)
+		if !ok || element.Name.Local != "node" {
+			continue
+		}
+
+		var node struct {
+			ID   int64   `xml:"id,attr"`
+			Lat  float64 `xml:"lat,attr"`
+			Lon  float64 `xml:"lon,attr"`
+			Tags []struct {
+				Key   string `xml:"k,attr"`
+				Value string `xml:"v,attr"`
+			} `xml:"tag"`
+		}
+		err = decoder.DecodeElement(&node, &element)
+		if err != nil {
+			return nil, err
+		}
+
+		tags := make(map[string]string, len(node.Tags))
+		for _, tag := range node.Tags {
+			tags[tag.Key] = tag.Value
+		}
+		if !isTransitNode(tags) {
+			continue
+		}
+		nodes = append(nodes, osmNode{
+			ID:       node.ID,
+			Name:     tags["name"],
+			Location: gtfs.NewCoordinate(node.Lat, node.Lon),
+		})
+	}
+	return nodes, nil
+}
+
+// Returns the similarity of two names from 0 to 1, based on their edit distance
+func nameSimilarity(a, b string) float64 {
+	ra, rb := []rune(gtfs.NormalizeName(a)), []rune(gtfs.NormalizeName(b))
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+
+	// Levenshtein distance, keeping only the previous row
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return 1 - float64(prev[len(rb)])/float64(max(len(ra), len(rb)))
+}
+
+// Matches GTFS stops to public transport nodes (bus stops, platforms and stations) in OSM XML data,
+// such as an extract or an Overpass API response. Each stop is matched to at most one node within
+// MaxMatchDistance and vice versa, preferring the pairs with the highest confidence, which combines
+// proximity and name similarity. Matches are sorted by stop ID; unmatched stops are omitted.
+// OSM PBF data is rejected with ErrPBFNotSupported.
+func MatchStopsToOSM(stops gtfs.StopMap, osmData io.Reader) ([]StopMatch, error) {
+	nodes, err := readOSMNodes(osmData)
+	if err != nil {
+		return nil, err
+	}
+
+	grid := make(map[gridCell][]int)
+	for i, node := range nodes {
+		cell := cellOf(node.Location)
+		grid[cell] = append(grid[cell], i)
+	}
+
+	// Score every stop and node within range of each other
+	type candidate struct {
+		match StopMatch
+		node  int
+	}
+	var candidates []candidate
+	for _, stop := range stops {
+		if stop.Location.IsZero() {
+			continue
+		}
+
+		// Cells narrow with latitude, so more are needed to cover the distance east and west
+		cell := cellOf(stop.Location)
+		lonCells := int(math.Ceil(1 / math.Max(math.Cos(stop.Location.Latitude*math.Pi/180), 0.01)))
+		for dLat := -1; dLat <= 1; dLat++ {
+			for dLon := -lonCells; dLon <= lonCells; dLon++ {
+				for _, i := range grid[gridCell{cell.lat + dLat, cell.lon + dLon}] {
+					node := nodes[i]
+					distance := stop.Location.DistanceTo(node.Location)
+					if distance > MaxMatchDistance {
+						continue
+					}
+
+					similarity := nameSimilarity(stop.Name, node.Name)
+					candidates = append(candidates, candidate{
+						match: StopMatch{
+							StopID:         stop.ID,
+							NodeID:         node.ID,
+							NodeName:       node.Name,
+							Distance:       distance,
+							NameSimilarity: similarity,
+							Confidence:     distanceWeight*(1-distance/MaxMatchDistance) + nameWeight*similarity,
+						},
+						node: i,
+					})
+				}
+			}
+		}
+	}
+
+	// Assign the most confident pairs first, using each stop and node at most once
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i].match, candidates[j].match
+		if a.Confidence != b.Confidence {
+			return a.Confidence > b.Confidence
+		}
+		if a.StopID != b.StopID {
+			return a.StopID < b.StopID
+		}
+		return a.NodeID < b.NodeID
+	})
+	matchedStops := make(map[gtfs.Key]bool)
+	matchedNodes := make(map[int]bool)
+	matches := []StopMatch{}
+	for _, c := range candidates {
+		if matchedStops[c.match.StopID] || matchedNodes[c.node] {
+			continue
+		}
+		matchedStops[c.match.StopID] = true
+		matchedNodes[c.node] = true
+		matches = append(matches, c.match)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].StopID < matches[j].StopID
+	})
+	return matches, nil
+}
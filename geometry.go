@@ -0,0 +1,173 @@
+package gtfs
+
+import (
+	"errors"
+	"math"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/simplify"
+)
+
+// Precision of Google encoded polylines, in decimal places
+const defaultPolylinePrecision = 5
+
+// Simplified geometry of a route in each direction, ready for display on a map
+type RouteGeometry struct {
+	RouteID          Key
+	Inbound          CoordinateArray
+	Outbound         CoordinateArray
+	InboundPolyline  string // Google encoded polyline with precision 5
+	OutboundPolyline string // Google encoded polyline with precision 5
+}
+
+// Convert the coordinates into an orb line string
+func (ca CoordinateArray) lineString() orb.LineString {
+	ls := make(orb.LineString, len(ca))
+	for i, coord := range ca {
+		ls[i] = orb.Point{coord.Longitude, coord.Latitude}
+	}
+	return ls
+}
+
+// Convert an orb line string into coordinates
+func coordinatesFromLineString(ls orb.LineString) CoordinateArray {
+	coords := make(CoordinateArray, len(ls))
+	for i, point := range ls {
+		coords[i] = NewCoordinate(point.Lat(), point.Lon())
+	}
+	return coords
+}
+
+// Simplify the coordinates using the Douglas-Peucker algorithm with the given tolerance in degrees.
+// A tolerance of zero or less returns a copy of the coordinates unchanged.
+func simplifyCoordinates(coords CoordinateArray, tolerance float64) CoordinateArray {
+	if tolerance <= 0 || len(coords) <= 2 {
+		return append(CoordinateArray{}, coords...)
+	}
+	simplified := simplify.DouglasPeucker(tolerance).Simplify(coords.lineString())
+	return coordinatesFromLineString(simplified.(orb.LineString))
+}
+
+// Encode the coordinates as a Google encoded polyline with the given precision in decimal places
+func encodePolyline(coords CoordinateArray, precision int) string {
+	factor := math.Pow10(precision)
+
+	var sb strings.Builder
+	var prevLat, prevLon int64
+	for _, coord := range coords {
+		lat := int64(math.Round(coord.Latitude * factor))
+		lon := int64(math.Round(coord.Longitude * factor))
+		writePolylineValue(&sb, lat-prevLat)
+		writePolylineValue(&sb, lon-prevLon)
+		prevLat, prevLon = lat, lon
+	}
+	return sb.String()
+}
+
+// Write a single signed value to an encoded polyline
+func writePolylineValue(sb *strings.Builder, value int64) {
+	shifted := value << 1
+	if value < 0 {
+		shifted = ^shifted
+	}
+	for shifted >= 0x20 {
+		sb.WriteByte(byte((0x20 | (shifted & 0x1f)) + 63))
+		shifted >>= 5
+	}
+	sb.WriteByte(byte(shifted + 63))
+}
+
+// Decode a Google encoded polyline with the given precision in decimal places (usually 5 or 6)
+func DecodePolyline(polyline string, precision int) (CoordinateArray, error) {
+	factor := math.Pow10(precision)
+
+	coords := CoordinateArray{}
+	var lat, lon int64
+	for i := 0; i < len(polyline); {
+		latDelta, n, err := readPolylineValue(polyline[i:])
+		if err != nil {
+			return nil, err
+		}
+		i += n
+		lonDelta, n, err := readPolylineValue(polyline[i:])
+		if err != nil {
+			return nil, err
+		}
+		i += n
+
+		lat += latDelta
+		lon += lonDelta
+		coords = append(coords, NewCoordinate(float64(lat)/factor, float64(lon)/factor))
+	}
+	return coords, nil
+}
+
+// Read a single signed value from an encoded polyline, returning it and the number of bytes read
+func readPolylineValue(polyline string) (int64, int, error) {
+	var result int64
+	var shift uint
+	for i := 0; i < len(polyline); i++ {
+		b := int64(polyline[i]) - 63
+		if b < 0 || b > 0x3f {
+			return 0, 0, errors.New("invalid polyline character")
+		}
+		if shift > 60 {
+			return 0, 0, errors.New("polyline value overflows")
+		}
+		result |= (b & 0x1f) << shift
+		shift += 5
+		if b < 0x20 {
+			if result&1 != 0 {
+				return ^(result >> 1), i + 1, nil
+			}
+			return result >> 1, i + 1, nil
+		}
+	}
+	return 0, 0, errors.New("truncated polyline")
+}
+
+// Returns the route's inbound and outbound shapes, simplified with the given tolerance in degrees
+// (zero for no simplification) and also encoded as polylines. Directions without a shape are empty.
+// Geometries precomputed at ingest are used if they were simplified with the same tolerance.
+func (g *GTFS) GetRouteGeometry(routeID Key, tolerance float64) (*RouteGeometry, error) {
+	geometry, err := g.getDerivedRouteGeometry(routeID, tolerance)
+	if err != nil || geometry != nil {
+		return geometry, err
+	}
+
+	route, err := g.GetRouteByID(routeID)
+	if err != nil {
+		return nil, err
+	}
+
+	var shapeIDs []Key
+	if route.InboundShapeID != nil && *route.InboundShapeID != "" {
+		shapeIDs = append(shapeIDs, *route.InboundShapeID)
+	}
+	if route.OutboundShapeID != nil && *route.OutboundShapeID != "" {
+		shapeIDs = append(shapeIDs, *route.OutboundShapeID)
+	}
+	shapes, err := g.GetShapesByIDs(shapeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Simplify and encode the shape with the given ID, if it exists
+	build := func(shapeID *Key) (CoordinateArray, string) {
+		if shapeID == nil {
+			return CoordinateArray{}, ""
+		}
+		shape, ok := shapes[*shapeID]
+		if !ok {
+			return CoordinateArray{}, ""
+		}
+		coords := simplifyCoordinates(shape.Coordinates, tolerance)
+		return coords, encodePolyline(coords, defaultPolylinePrecision)
+	}
+
+	geometry = &RouteGeometry{RouteID: routeID}
+	geometry.Inbound, geometry.InboundPolyline = build(route.InboundShapeID)
+	geometry.Outbound, geometry.OutboundPolyline = build(route.OutboundShapeID)
+	return geometry, nil
+}
@@ -5,8 +5,13 @@ go 1.24.0
 require (
 	github.com/charmbracelet/log v0.4.1
 	github.com/hashicorp/go-set/v3 v3.0.0
+	github.com/mattn/go-sqlite3 v1.14.33
 	github.com/paulmach/orb v0.11.1
 	go.etcd.io/bbolt v1.4.0
+	golang.org/x/sync v0.12.0
+	golang.org/x/text v0.21.0
+	google.golang.org/grpc v1.71.1
+	google.golang.org/protobuf v1.36.9
 	resty.dev/v3 v3.0.0-beta.2
 )
 
@@ -20,6 +25,7 @@ require (
 	github.com/muesli/termenv v0.16.0 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
 	golang.org/x/exp v0.0.0-20250305212735-054e65f0b394 // indirect
-	golang.org/x/net v0.33.0 // indirect
+	golang.org/x/net v0.34.0 // indirect
 	golang.org/x/sys v0.31.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250115164207-1a7da9e5054f // indirect
 )
@@ -1,12 +1,22 @@
 package gtfs
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
 	"time"
 
 	bolt "go.etcd.io/bbolt"
 )
 
+// Returned, wrapped with the kind of entity, when a lookup finds no entity with the given ID or key.
+// Check for it with errors.Is.
+var ErrNotFound = errors.New("not found")
+
 var requiredFiles = []string{
 	"agency.txt",
 	"calendar.txt",
@@ -18,11 +28,26 @@ var requiredFiles = []string{
 
 // Represents a GTFS database connection
 type GTFS struct {
-	Version int
-	Created int64
-
-	filePath string
-	db       *bolt.DB
+	Version  int
+	Created  int64
+	Encoding Encoding
+
+	filePath  string
+	db        *bolt.DB
+	handle    *sync.RWMutex    // Guards swapping db in on refresh
+	tx        *bolt.Tx         // Pinned read transaction, set only for snapshots
+	realtime  RealtimeSource   // Attached realtime source, if any
+	cache     *entityCache     // Warmed up entities, if any
+	overrides *overrideLayer   // Edits layered on top of the ingested data
+	alerts    *alertLayer      // Service alerts stored alongside the data
+	locations *agencyLocations // Timezones of the agencies, resolved on open
+
+	serviceChanges *serviceChangeLayer  // Trip cancellations and additions layered over the schedule
+	notifier       *changeNotifier      // Subscribers to change events on refresh, created on open
+	serviceDays    *serviceDaysCache    // Active days of services, computed on first use
+	numericIDs     *numericIDCache      // Internal numeric IDs of entities, loaded on first use
+	dbOptions      DBOptions            // Options the database was opened with
+	stats          *queryStatsCollector // Collector of query statistics, if enabled
 }
 
 // Closes the GTFS database connection and saves metadata
@@ -34,23 +59,58 @@ func (g *GTFS) Close() error {
 	return g.db.Close()
 }
 
+// Runs fn within a read transaction, reusing the pinned transaction for snapshots
+func (g *GTFS) view(fn func(tx *bolt.Tx) error) error {
+	if g.stats != nil {
+		fn = g.stats.measure(queryName(2), fn)
+	}
+	if g.tx != nil {
+		return fn(g.tx)
+	}
+	db := g.openDB()
+	if db == nil {
+		return errors.New("database not open")
+	}
+	return db.View(fn)
+}
+
+// Returns the database handle, or nil if the database is not open
+func (g *GTFS) openDB() *bolt.DB {
+	if g.handle == nil {
+		return g.db
+	}
+	g.handle.RLock()
+	defer g.handle.RUnlock()
+	return g.db
+}
+
+// Decodes an entity value read from the database in the transaction using the database's encoding
+func (g *GTFS) decode(tx *bolt.Tx, e decodable, id Key, data []byte) error {
+	g.countRead(tx, data)
+	return decodeEntity(e, id, data, g.Encoding)
+}
+
 // --- Individual Query Functions ---
 
 // Returns the agency with the given ID
 func (g *GTFS) GetAgencyByID(agencyID Key) (*Agency, error) {
+	if cached, ok := cachedEntity[Agency](g, AgencyEntityType, agencyID); ok {
+		return cached, nil
+	}
+
 	agency := &Agency{}
 
 	// Query the database for the agency with the given ID
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("agencies"))
 		if b == nil {
 			return errors.New("bucket not found")
 		}
 		data := b.Get([]byte(agencyID))
 		if data == nil {
-			return errors.New("agency not found")
+			return fmt.Errorf("agency %w", ErrNotFound)
 		}
-		return agency.Decode(agencyID, data)
+		return g.decode(tx, agency, agencyID, data)
 	})
 
 	if err != nil {
@@ -61,19 +121,27 @@ func (g *GTFS) GetAgencyByID(agencyID Key) (*Agency, error) {
 
 // Returns the route with the given ID
 func (g *GTFS) GetRouteByID(routeID Key) (*Route, error) {
+	if overridden, ok, err := overriddenEntity[Route](g, RouteEntityType, routeID, "route"); ok {
+		return overridden, err
+	}
+
+	if cached, ok := cachedEntity[Route](g, RouteEntityType, routeID); ok {
+		return cached, nil
+	}
+
 	route := &Route{}
 
 	// Query the database for the route with the given ID
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("routes"))
 		if b == nil {
 			return errors.New("bucket not found")
 		}
 		data := b.Get([]byte(routeID))
 		if data == nil {
-			return errors.New("route not found")
+			return fmt.Errorf("route %w", ErrNotFound)
 		}
-		return route.Decode(routeID, data)
+		return g.decode(tx, route, routeID, data)
 	})
 
 	if err != nil {
@@ -82,19 +150,105 @@ func (g *GTFS) GetRouteByID(routeID Key) (*Route, error) {
 	return route, nil
 }
 
+// Returns all routes of any of the given types
+func (g *GTFS) GetRoutesByType(types ...RouteType) (RouteMap, error) {
+	var routeIDs []Key
+
+	// Query the database for the routes of each type
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("routesByTypeIndex"))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+		for _, routeType := range types {
+			data := b.Get([]byte{byte(routeType)})
+			if data == nil {
+				continue
+			}
+			var typeRouteIDs KeyArray
+			err := typeRouteIDs.Decode(data)
+			if err != nil {
+				return err
+			}
+			routeIDs = append(routeIDs, typeRouteIDs...)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	routes, err := g.GetRoutesByIDs(routeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Overridden routes may have any type
+	err = mergeAllOverrides[Route](g, RouteEntityType, routes)
+	if err != nil {
+		return nil, err
+	}
+	for id, route := range routes {
+		if !slices.Contains(types, route.Type) {
+			delete(routes, id)
+		}
+	}
+	return routes, nil
+}
+
+// Returns all routes operated by the given agency
+func (g *GTFS) GetRoutesByAgencyID(agencyID Key) (RouteMap, error) {
+	var routeIDs KeyArray
+
+	// Query the database for the agency's routes
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("routesByAgencyIndex"))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+		data := b.Get([]byte(agencyID))
+		if data == nil {
+			return nil
+		}
+		return routeIDs.Decode(data)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	routes, err := g.GetRoutesByIDs(routeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Overridden routes may belong to any agency
+	err = mergeAllOverrides[Route](g, RouteEntityType, routes)
+	if err != nil {
+		return nil, err
+	}
+	for id, route := range routes {
+		if route.AgencyID != agencyID {
+			delete(routes, id)
+		}
+	}
+	return routes, nil
+}
+
 // Returns the route with the given name
 func (g *GTFS) GetRouteByName(routeName string) (*Route, error) {
 	var routeID Key
 
 	// Query the database for the route with the given name
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("routesByNameIndex"))
 		if b == nil {
 			return errors.New("bucket not found")
 		}
 		data := b.Get([]byte(routeName))
 		if data == nil {
-			return errors.New("route not found")
+			return fmt.Errorf("route %w", ErrNotFound)
 		}
 		routeID = Key(data)
 		return nil
@@ -109,19 +263,27 @@ func (g *GTFS) GetRouteByName(routeName string) (*Route, error) {
 
 // Returns the stop with the given ID
 func (g *GTFS) GetStopByID(stopID Key) (*Stop, error) {
+	if overridden, ok, err := overriddenEntity[Stop](g, StopEntityType, stopID, "stop"); ok {
+		return overridden, err
+	}
+
+	if cached, ok := cachedEntity[Stop](g, StopEntityType, stopID); ok {
+		return cached, nil
+	}
+
 	stop := &Stop{}
 
 	// Query the database for the stop with the given ID
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("stops"))
 		if b == nil {
 			return errors.New("bucket not found")
 		}
 		data := b.Get([]byte(stopID))
 		if data == nil {
-			return errors.New("stop not found")
+			return fmt.Errorf("stop %w", ErrNotFound)
 		}
-		return stop.Decode(stopID, data)
+		return g.decode(tx, stop, stopID, data)
 	})
 
 	if err != nil {
@@ -130,46 +292,104 @@ func (g *GTFS) GetStopByID(stopID Key) (*Stop, error) {
 	return stop, nil
 }
 
-// Returns the stop with the given name
+// Returns the stop with the given name, or with the given name as an alias
 func (g *GTFS) GetStopByName(stopName string) (*Stop, error) {
 	var stopID Key
 
 	// Query the database for the stop with the given name
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("stopsByNameIndex"))
 		if b == nil {
 			return errors.New("bucket not found")
 		}
 		data := b.Get([]byte(stopName))
 		if data == nil {
-			return errors.New("stop not found")
+			return fmt.Errorf("stop %w", ErrNotFound)
 		}
 		stopID = Key(data)
 		return nil
 	})
 
 	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return g.GetStopByAlias(stopName)
+		}
 		return nil, err
 	}
 
 	return g.GetStopByID(stopID)
 }
 
+// Returns all stops in the given fare zone
+func (g *GTFS) GetStopsByZone(zoneID Key) (StopMap, error) {
+	var stops StopMap
+
+	// Query the database for all stops associated with the zone ID
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("stopsByZoneIndex"))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+		data := b.Get([]byte(zoneID))
+		if data == nil {
+			return fmt.Errorf("stops for zone %w", ErrNotFound)
+		}
+		stopIDs := KeyArray{}
+		err := stopIDs.Decode(data)
+		if err != nil {
+			return err
+		}
+
+		// Load the stop data for each stop ID
+		b = tx.Bucket([]byte("stops"))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+		stops = make(StopMap, len(stopIDs))
+		for _, stopID := range stopIDs {
+			data := b.Get([]byte(stopID))
+			if data == nil {
+				return fmt.Errorf("stop %w", ErrNotFound)
+			}
+			stop := &Stop{}
+			err := g.decode(tx, stop, stopID, data)
+			if err != nil {
+				return err
+			}
+			stops[stopID] = stop
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return stops, nil
+}
+
 // Returns the trip with the given ID
 func (g *GTFS) GetTripByID(tripID Key) (*Trip, error) {
+	if overridden, ok, err := overriddenEntity[Trip](g, TripEntityType, tripID, "trip"); ok {
+		return overridden, err
+	}
+
+	if cached, ok := cachedEntity[Trip](g, TripEntityType, tripID); ok {
+		return cached, nil
+	}
+
 	trip := &Trip{}
 
 	// Query the database for the trip with the given ID
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("trips"))
 		if b == nil {
 			return errors.New("bucket not found")
 		}
 		data := b.Get([]byte(tripID))
 		if data == nil {
-			return errors.New("trip not found")
+			return fmt.Errorf("trip %w", ErrNotFound)
 		}
-		return trip.Decode(tripID, data)
+		return g.decode(tx, trip, tripID, data)
 	})
 
 	if err != nil {
@@ -178,47 +398,145 @@ func (g *GTFS) GetTripByID(tripID Key) (*Trip, error) {
 	return trip, nil
 }
 
+// Returns the position of the given stop within the given trip, along with the stops after it.
+// If the trip visits the stop more than once, the first visit is returned.
+func (g *GTFS) GetStopSequenceInTrip(tripID, stopID Key) (*TripStopPosition, error) {
+	trip, err := g.GetTripByID(tripID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, stop := range trip.Stops {
+		if stop.StopID != stopID {
+			continue
+		}
+		return &TripStopPosition{
+			Index:          i,
+			ArrivalTime:    stop.ArrivalTime,
+			DepartureTime:  stop.DepartureTime,
+			RemainingStops: trip.Stops[i+1:],
+		}, nil
+	}
+	return nil, fmt.Errorf("stop %w in trip", ErrNotFound)
+}
+
 // Returns all trips for a given route ID
 func (g *GTFS) GetTripsByRouteID(routeID Key) (TripMap, error) {
-	var tripIDs *KeyArray
+	trips, err := g.getIndexedTrips("tripsByRouteIndex", []byte(routeID))
+	if err != nil {
+		return nil, err
+	}
 
-	// Query the database for all trips associated with the route ID
-	err := g.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("tripsByRouteIndex"))
-		if b == nil {
-			return errors.New("bucket not found")
+	// Overrides may move trips onto a route with none in the database, or suppress all of its trips
+	err = g.mergeRouteTripOverrides(routeID, trips)
+	if err != nil {
+		return nil, err
+	}
+	if len(trips) == 0 {
+		return nil, fmt.Errorf("trips for route %w", ErrNotFound)
+	}
+	return trips, nil
+}
+
+// Returns all trips on the routes operated by the given agency
+func (g *GTFS) GetTripsByAgencyID(agencyID Key) (TripMap, error) {
+	routes, err := g.GetRoutesByAgencyID(agencyID)
+	if err != nil {
+		return nil, err
+	}
+
+	trips := make(TripMap)
+	for routeID := range routes {
+		routeTrips, err := g.GetTripsByRouteID(routeID)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return nil, err
 		}
-		data := b.Get([]byte(routeID))
-		if data == nil {
-			return errors.New("no trips found for route")
+		maps.Copy(trips, routeTrips)
+	}
+	return trips, nil
+}
+
+// Returns all stops visited by trips on the routes operated by the given agency
+func (g *GTFS) GetStopsByAgencyID(agencyID Key) (StopMap, error) {
+	return g.FindStops(WithAgency(agencyID))
+}
+
+// Returns the stops of the route's canonical pattern in the given direction, in travel order
+func (g *GTFS) GetStopsByRouteID(routeID Key, dir TripDirection) ([]*Stop, error) {
+	route, err := g.GetRouteByID(routeID)
+	if err != nil {
+		return nil, err
+	}
+
+	stopIDs := route.OutboundStops
+	if dir == InboundTripDirection {
+		stopIDs = route.InboundStops
+	}
+	stops, err := g.GetStopsByIDs(stopIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	ordered := make([]*Stop, len(stopIDs))
+	for i, stopID := range stopIDs {
+		stop, ok := stops[stopID]
+		if !ok {
+			return nil, fmt.Errorf("stop %s %w", stopID, ErrNotFound)
 		}
-		tripIDs = &KeyArray{}
-		err := tripIDs.Decode(data)
-		if err != nil {
-			return err
+		ordered[i] = stop
+	}
+	return ordered, nil
+}
+
+// Returns the trips for a given route ID travelling in the given direction
+func (g *GTFS) GetTripsByRouteAndDirection(routeID Key, dir TripDirection) (TripMap, error) {
+	trips, err := g.getIndexedTrips("tripsByRouteDirectionIndex", routeDirectionKey(routeID, dir))
+	if err != nil {
+		return nil, err
+	}
+
+	err = g.mergeRouteTripOverrides(routeID, trips)
+	if err != nil {
+		return nil, err
+	}
+
+	// Overridden trips may travel in either direction
+	for id, trip := range trips {
+		if trip.Direction != dir {
+			delete(trips, id)
 		}
-		return nil
-	})
+	}
+	if len(trips) == 0 {
+		return nil, fmt.Errorf("trips for route in direction %w", ErrNotFound)
+	}
+	return trips, nil
+}
 
+// Returns the trips listed under the given key of an index bucket, which are none if the key is not in the index
+func (g *GTFS) getIndexedTrips(index string, key []byte) (TripMap, error) {
+	tripIDs, err := g.getIndexedTripIDs(index, key)
 	if err != nil {
 		return nil, err
 	}
 
-	trips := make(TripMap, len(*tripIDs))
+	trips := make(TripMap, len(tripIDs))
 
 	// Query the database for each trip ID and load the trip data
-	err = g.db.View(func(tx *bolt.Tx) error {
+	err = g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("trips"))
 		if b == nil {
 			return errors.New("bucket not found")
 		}
-		for _, tripID := range *tripIDs {
+		for _, tripID := range tripIDs {
 			data := b.Get([]byte(tripID))
 			if data == nil {
-				return errors.New("trip not found")
+				return fmt.Errorf("trip %w", ErrNotFound)
 			}
 			trip := &Trip{}
-			err := trip.Decode(tripID, data)
+			err := g.decode(tx, trip, tripID, data)
 			if err != nil {
 				return err
 			}
@@ -229,25 +547,113 @@ func (g *GTFS) GetTripsByRouteID(routeID Key) (TripMap, error) {
 	if err != nil {
 		return nil, err
 	}
-
 	return trips, nil
 }
 
-// Returns the shape with the given ID
+// Returns the IDs of the trips listed under the key of an index bucket of numeric trip IDs, which are none
+// if the key is not in the index
+func (g *GTFS) getIndexedTripIDs(index string, key []byte) (KeyArray, error) {
+	var tripIDs KeyArray
+
+	// Query the database for all trips listed under the key
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(index))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+		data := b.Get(key)
+		if data == nil {
+			return nil
+		}
+		var ids numericIDArray
+		err := ids.Decode(data)
+		if err != nil {
+			return err
+		}
+		tripIDs, err = numericKeys(tx, TripEntityType, ids)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return tripIDs, nil
+}
+
+// Returns all trips with the given headsign
+func (g *GTFS) GetTripsByHeadsign(headsign string) (TripMap, error) {
+	var tripIDs KeyArray
+
+	// Query the database for all trips with the headsign
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("tripsByHeadsignIndex"))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+		data := b.Get([]byte(headsign))
+		if data == nil {
+			return fmt.Errorf("trips for headsign %w", ErrNotFound)
+		}
+		var ids numericIDArray
+		err := ids.Decode(data)
+		if err != nil {
+			return err
+		}
+		tripIDs, err = numericKeys(tx, TripEntityType, ids)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return g.GetTripsByIDs(tripIDs)
+}
+
+// Returns the distinct trip headsigns starting with the given prefix, in sorted order
+func (g *GTFS) SearchHeadsigns(prefix string) ([]string, error) {
+	headsigns := []string{}
+
+	// Scan the headsign index from the first key with the prefix
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("tripsByHeadsignIndex"))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+		c := b.Cursor()
+		for k, _ := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = c.Next() {
+			headsigns = append(headsigns, string(k))
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return headsigns, nil
+}
+
+// Returns the shape with the given ID, falling back to the shapes inferred at ingest
 func (g *GTFS) GetShapeByID(shapeID Key) (*Shape, error) {
+	if cached, ok := cachedEntity[Shape](g, ShapeEntityType, shapeID); ok {
+		return cached, nil
+	}
+
 	shape := &Shape{}
 
 	// Query the database for the shape with the given ID
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("shapes"))
 		if b == nil {
 			return errors.New("bucket not found")
 		}
 		data := b.Get([]byte(shapeID))
 		if data == nil {
-			return errors.New("shape not found")
+			data = derivedShapeData(tx, shapeID)
+		}
+		if data == nil {
+			return fmt.Errorf("shape %w", ErrNotFound)
 		}
-		return shape.Decode(shapeID, data)
+		return g.decode(tx, shape, shapeID, data)
 	})
 
 	if err != nil {
@@ -256,21 +662,52 @@ func (g *GTFS) GetShapeByID(shapeID Key) (*Shape, error) {
 	return shape, nil
 }
 
+// Returns the number of trips referencing the shape with the given ID
+func (g *GTFS) GetShapeRefCount(shapeID Key) (int, error) {
+	var count int
+
+	// Query the database for the shape's reference count
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("shapeRefCounts"))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+		data := b.Get([]byte(shapeID))
+		if data == nil {
+			return fmt.Errorf("shape %w", ErrNotFound)
+		}
+		if len(data) != uint32Bytes {
+			return errors.New("invalid shape reference count")
+		}
+		count = int(binary.BigEndian.Uint32(data))
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
 // Returns the service with the given ID
 func (g *GTFS) GetServiceByID(serviceID Key) (*Service, error) {
+	if cached, ok := cachedEntity[Service](g, ServiceEntityType, serviceID); ok {
+		return cached, nil
+	}
+
 	service := &Service{}
 
 	// Query the database for the service with the given ID
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("services"))
 		if b == nil {
 			return errors.New("bucket not found")
 		}
 		data := b.Get([]byte(serviceID))
 		if data == nil {
-			return errors.New("service not found")
+			return fmt.Errorf("service %w", ErrNotFound)
 		}
-		return service.Decode(serviceID, data)
+		return g.decode(tx, service, serviceID, data)
 	})
 
 	if err != nil {
@@ -284,17 +721,23 @@ func (g *GTFS) GetServiceException(serviceID Key, date time.Time) (*ServiceExcep
 	exception := &ServiceException{}
 
 	// Query the database for the service exception with the given service ID and date
-	key := string(serviceID) + date.Format("20060102")
-	err := g.db.View(func(tx *bolt.Tx) error {
+	if added, ok, err := g.addedServiceException(serviceID, date); ok {
+		return added, err
+	}
+	key := serviceExceptionKey(serviceID, date)
+	if cached, ok := cachedEntity[ServiceException](g, ServiceExceptionEntityType, Key(key)); ok {
+		return cached, nil
+	}
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("serviceExceptions"))
 		if b == nil {
 			return errors.New("bucket not found")
 		}
-		data := b.Get([]byte(key))
+		data := b.Get(key)
 		if data == nil {
-			return errors.New("service exception not found")
+			return fmt.Errorf("service exception %w", ErrNotFound)
 		}
-		return exception.Decode(data)
+		return decodeServiceException(exception, data, g.Encoding)
 	})
 
 	if err != nil {
@@ -307,10 +750,14 @@ func (g *GTFS) GetServiceException(serviceID Key, date time.Time) (*ServiceExcep
 
 // Returns the agencies with the given IDs
 func (g *GTFS) GetAgenciesByIDs(agencyIDs []Key) (AgencyMap, error) {
+	if cached, ok := cachedEntitiesByIDs[Agency](g, AgencyEntityType, agencyIDs); ok {
+		return cached, nil
+	}
+
 	agencies := make(AgencyMap, len(agencyIDs))
 
 	// Query the database for each agency ID and load the agency data
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("agencies"))
 		if b == nil {
 			return errors.New("bucket not found")
@@ -321,7 +768,7 @@ func (g *GTFS) GetAgenciesByIDs(agencyIDs []Key) (AgencyMap, error) {
 				continue
 			}
 			agency := &Agency{}
-			err := agency.Decode(agencyID, data)
+			err := g.decode(tx, agency, agencyID, data)
 			if err != nil {
 				return err
 			}
@@ -339,9 +786,13 @@ func (g *GTFS) GetAgenciesByIDs(agencyIDs []Key) (AgencyMap, error) {
 
 // Returns all agencies in the GTFS database
 func (g *GTFS) GetAllAgencies() (AgencyMap, error) {
+	if cached, ok := cachedEntities[Agency](g, AgencyEntityType); ok {
+		return cached, nil
+	}
+
 	var agencies AgencyMap
 
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("agencies"))
 		if b == nil {
 			return errors.New("bucket not found")
@@ -352,7 +803,7 @@ func (g *GTFS) GetAllAgencies() (AgencyMap, error) {
 		return b.ForEach(func(k, v []byte) error {
 			agency := &Agency{}
 			key := Key(k)
-			err := agency.Decode(key, v)
+			err := g.decode(tx, agency, key, v)
 			if err != nil {
 				return err
 			}
@@ -369,10 +820,18 @@ func (g *GTFS) GetAllAgencies() (AgencyMap, error) {
 
 // Returns the routes with the given IDs
 func (g *GTFS) GetRoutesByIDs(routeIDs []Key) (RouteMap, error) {
+	if cached, ok := cachedEntitiesByIDs[Route](g, RouteEntityType, routeIDs); ok {
+		err := mergeOverrides[Route](g, RouteEntityType, cached, routeIDs)
+		if err != nil {
+			return nil, err
+		}
+		return cached, nil
+	}
+
 	routes := make(RouteMap, len(routeIDs))
 
 	// Query the database for each route ID and load the route data
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("routes"))
 		if b == nil {
 			return errors.New("bucket not found")
@@ -383,7 +842,7 @@ func (g *GTFS) GetRoutesByIDs(routeIDs []Key) (RouteMap, error) {
 				continue
 			}
 			route := &Route{}
-			err := route.Decode(routeID, data)
+			err := g.decode(tx, route, routeID, data)
 			if err != nil {
 				return err
 			}
@@ -396,14 +855,26 @@ func (g *GTFS) GetRoutesByIDs(routeIDs []Key) (RouteMap, error) {
 		return nil, err
 	}
 
+	err = mergeOverrides[Route](g, RouteEntityType, routes, routeIDs)
+	if err != nil {
+		return nil, err
+	}
 	return routes, nil
 }
 
 // Returns all routes in the GTFS database
 func (g *GTFS) GetAllRoutes() (RouteMap, error) {
+	if cached, ok := cachedEntities[Route](g, RouteEntityType); ok {
+		err := mergeAllOverrides[Route](g, RouteEntityType, cached)
+		if err != nil {
+			return nil, err
+		}
+		return cached, nil
+	}
+
 	var routes RouteMap
 
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("routes"))
 		if b == nil {
 			return errors.New("bucket not found")
@@ -414,7 +885,7 @@ func (g *GTFS) GetAllRoutes() (RouteMap, error) {
 		return b.ForEach(func(k, v []byte) error {
 			route := &Route{}
 			key := Key(k)
-			err := route.Decode(key, v)
+			err := g.decode(tx, route, key, v)
 			if err != nil {
 				return err
 			}
@@ -423,6 +894,11 @@ func (g *GTFS) GetAllRoutes() (RouteMap, error) {
 		})
 	})
 
+	if err != nil {
+		return nil, err
+	}
+
+	err = mergeAllOverrides[Route](g, RouteEntityType, routes)
 	if err != nil {
 		return nil, err
 	}
@@ -431,10 +907,18 @@ func (g *GTFS) GetAllRoutes() (RouteMap, error) {
 
 // Returns the stops with the given IDs
 func (g *GTFS) GetStopsByIDs(stopIDs []Key) (StopMap, error) {
+	if cached, ok := cachedEntitiesByIDs[Stop](g, StopEntityType, stopIDs); ok {
+		err := mergeOverrides[Stop](g, StopEntityType, cached, stopIDs)
+		if err != nil {
+			return nil, err
+		}
+		return cached, nil
+	}
+
 	stops := make(StopMap, len(stopIDs))
 
 	// Query the database for each stop ID and load the stop data
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("stops"))
 		if b == nil {
 			return errors.New("bucket not found")
@@ -445,7 +929,7 @@ func (g *GTFS) GetStopsByIDs(stopIDs []Key) (StopMap, error) {
 				continue
 			}
 			stop := &Stop{}
-			err := stop.Decode(stopID, data)
+			err := g.decode(tx, stop, stopID, data)
 			if err != nil {
 				return err
 			}
@@ -458,14 +942,26 @@ func (g *GTFS) GetStopsByIDs(stopIDs []Key) (StopMap, error) {
 		return nil, err
 	}
 
+	err = mergeOverrides[Stop](g, StopEntityType, stops, stopIDs)
+	if err != nil {
+		return nil, err
+	}
 	return stops, nil
 }
 
 // Returns all stops in the GTFS database
 func (g *GTFS) GetAllStops() (StopMap, error) {
+	if cached, ok := cachedEntities[Stop](g, StopEntityType); ok {
+		err := mergeAllOverrides[Stop](g, StopEntityType, cached)
+		if err != nil {
+			return nil, err
+		}
+		return cached, nil
+	}
+
 	var stops StopMap
 
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("stops"))
 		if b == nil {
 			return errors.New("bucket not found")
@@ -476,7 +972,7 @@ func (g *GTFS) GetAllStops() (StopMap, error) {
 		return b.ForEach(func(k, v []byte) error {
 			stop := &Stop{}
 			key := Key(k)
-			err := stop.Decode(key, v)
+			err := g.decode(tx, stop, key, v)
 			if err != nil {
 				return err
 			}
@@ -485,29 +981,42 @@ func (g *GTFS) GetAllStops() (StopMap, error) {
 		})
 	})
 
+	if err != nil {
+		return nil, err
+	}
+
+	err = mergeAllOverrides[Stop](g, StopEntityType, stops)
 	if err != nil {
 		return nil, err
 	}
 	return stops, nil
 }
 
-// Returns the shapes with the given IDs
+// Returns the shapes with the given IDs, including shapes inferred at ingest
 func (g *GTFS) GetShapesByIDs(shapeIDs []Key) (ShapeMap, error) {
+	// Inferred shapes are not cached, so they are read if any shape is missing from the cache
+	if cached, ok := cachedEntitiesByIDs[Shape](g, ShapeEntityType, shapeIDs); ok && len(cached) == len(shapeIDs) {
+		return cached, nil
+	}
+
 	shapes := make(ShapeMap, len(shapeIDs))
 
 	// Query the database for each shape ID and load the shape data
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("shapes"))
 		if b == nil {
 			return errors.New("bucket not found")
 		}
 		for _, shapeID := range shapeIDs {
 			data := b.Get([]byte(shapeID))
+			if data == nil {
+				data = derivedShapeData(tx, shapeID)
+			}
 			if data == nil {
 				continue
 			}
 			shape := &Shape{}
-			err := shape.Decode(shapeID, data)
+			err := g.decode(tx, shape, shapeID, data)
 			if err != nil {
 				return err
 			}
@@ -525,9 +1034,13 @@ func (g *GTFS) GetShapesByIDs(shapeIDs []Key) (ShapeMap, error) {
 
 // Returns all shapes in the GTFS database
 func (g *GTFS) GetAllShapes() (ShapeMap, error) {
+	if cached, ok := cachedEntities[Shape](g, ShapeEntityType); ok {
+		return cached, nil
+	}
+
 	var shapes ShapeMap
 
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("shapes"))
 		if b == nil {
 			return errors.New("bucket not found")
@@ -538,7 +1051,7 @@ func (g *GTFS) GetAllShapes() (ShapeMap, error) {
 		return b.ForEach(func(k, v []byte) error {
 			shape := &Shape{}
 			key := Key(k)
-			err := shape.Decode(key, v)
+			err := g.decode(tx, shape, key, v)
 			if err != nil {
 				return err
 			}
@@ -555,10 +1068,18 @@ func (g *GTFS) GetAllShapes() (ShapeMap, error) {
 
 // Returns the trips with the given IDs
 func (g *GTFS) GetTripsByIDs(tripIDs []Key) (TripMap, error) {
+	if cached, ok := cachedEntitiesByIDs[Trip](g, TripEntityType, tripIDs); ok {
+		err := mergeOverrides[Trip](g, TripEntityType, cached, tripIDs)
+		if err != nil {
+			return nil, err
+		}
+		return cached, nil
+	}
+
 	trips := make(TripMap, len(tripIDs))
 
 	// Query the database for each trip ID and load the trip data
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("trips"))
 		if b == nil {
 			return errors.New("bucket not found")
@@ -569,7 +1090,7 @@ func (g *GTFS) GetTripsByIDs(tripIDs []Key) (TripMap, error) {
 				continue
 			}
 			trip := &Trip{}
-			err := trip.Decode(tripID, data)
+			err := g.decode(tx, trip, tripID, data)
 			if err != nil {
 				return err
 			}
@@ -582,14 +1103,26 @@ func (g *GTFS) GetTripsByIDs(tripIDs []Key) (TripMap, error) {
 		return nil, err
 	}
 
+	err = mergeOverrides[Trip](g, TripEntityType, trips, tripIDs)
+	if err != nil {
+		return nil, err
+	}
 	return trips, nil
 }
 
 // Returns all trips in the GTFS database
 func (g *GTFS) GetAllTrips() (TripMap, error) {
+	if cached, ok := cachedEntities[Trip](g, TripEntityType); ok {
+		err := mergeAllOverrides[Trip](g, TripEntityType, cached)
+		if err != nil {
+			return nil, err
+		}
+		return cached, nil
+	}
+
 	var trips TripMap
 
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("trips"))
 		if b == nil {
 			return errors.New("bucket not found")
@@ -600,7 +1133,7 @@ func (g *GTFS) GetAllTrips() (TripMap, error) {
 		return b.ForEach(func(k, v []byte) error {
 			trip := &Trip{}
 			key := Key(k)
-			err := trip.Decode(key, v)
+			err := g.decode(tx, trip, key, v)
 			if err != nil {
 				return err
 			}
@@ -609,6 +1142,11 @@ func (g *GTFS) GetAllTrips() (TripMap, error) {
 		})
 	})
 
+	if err != nil {
+		return nil, err
+	}
+
+	err = mergeAllOverrides[Trip](g, TripEntityType, trips)
 	if err != nil {
 		return nil, err
 	}
@@ -617,10 +1155,14 @@ func (g *GTFS) GetAllTrips() (TripMap, error) {
 
 // Returns the services with the given IDs
 func (g *GTFS) GetServicesByIDs(serviceIDs []Key) (ServiceMap, error) {
+	if cached, ok := cachedEntitiesByIDs[Service](g, ServiceEntityType, serviceIDs); ok {
+		return cached, nil
+	}
+
 	services := make(ServiceMap, len(serviceIDs))
 
 	// Query the database for each service ID and load the service data
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("services"))
 		if b == nil {
 			return errors.New("bucket not found")
@@ -631,7 +1173,7 @@ func (g *GTFS) GetServicesByIDs(serviceIDs []Key) (ServiceMap, error) {
 				continue
 			}
 			service := &Service{}
-			err := service.Decode(serviceID, data)
+			err := g.decode(tx, service, serviceID, data)
 			if err != nil {
 				return err
 			}
@@ -649,9 +1191,13 @@ func (g *GTFS) GetServicesByIDs(serviceIDs []Key) (ServiceMap, error) {
 
 // Returns all services in the GTFS database
 func (g *GTFS) GetAllServices() (ServiceMap, error) {
+	if cached, ok := cachedEntities[Service](g, ServiceEntityType); ok {
+		return cached, nil
+	}
+
 	var services ServiceMap
 
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("services"))
 		if b == nil {
 			return errors.New("bucket not found")
@@ -662,7 +1208,7 @@ func (g *GTFS) GetAllServices() (ServiceMap, error) {
 		return b.ForEach(func(k, v []byte) error {
 			service := &Service{}
 			key := Key(k)
-			err := service.Decode(key, v)
+			err := g.decode(tx, service, key, v)
 			if err != nil {
 				return err
 			}
@@ -679,9 +1225,21 @@ func (g *GTFS) GetAllServices() (ServiceMap, error) {
 
 // Returns all service exceptions in the GTFS database
 func (g *GTFS) GetAllServiceExceptions() (ServiceExceptionMap, error) {
+	if cached, ok := cachedEntities[ServiceException](g, ServiceExceptionEntityType); ok {
+		exceptions := make(ServiceExceptionMap, len(cached))
+		for _, exception := range cached {
+			key := ServiceExceptionKey{
+				ServiceID: exception.ServiceID,
+				Date:      exception.Date,
+			}
+			exceptions[key] = exception
+		}
+		return exceptions, g.mergeAddedServiceExceptions(exceptions, "")
+	}
+
 	var exceptions ServiceExceptionMap
 
-	err := g.db.View(func(tx *bolt.Tx) error {
+	err := g.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("serviceExceptions"))
 		if b == nil {
 			return errors.New("bucket not found")
@@ -691,7 +1249,7 @@ func (g *GTFS) GetAllServiceExceptions() (ServiceExceptionMap, error) {
 
 		return b.ForEach(func(k, v []byte) error {
 			exception := &ServiceException{}
-			err := exception.Decode(v)
+			err := decodeServiceException(exception, v, g.Encoding)
 			if err != nil {
 				return err
 			}
@@ -707,5 +1265,238 @@ func (g *GTFS) GetAllServiceExceptions() (ServiceExceptionMap, error) {
 	if err != nil {
 		return nil, err
 	}
-	return exceptions, nil
+	return exceptions, g.mergeAddedServiceExceptions(exceptions, "")
+}
+
+// Returns the exceptions of all services on the date of the given time
+func (g *GTFS) GetExceptionsOn(date time.Time) (ServiceExceptionMap, error) {
+	day := date.Format("20060102")
+	exceptions := make(ServiceExceptionMap)
+
+	if cached, ok := cachedEntities[ServiceException](g, ServiceExceptionEntityType); ok {
+		for _, exception := range cached {
+			if exception.Date.Format("20060102") == day {
+				exceptions[ServiceExceptionKey{ServiceID: exception.ServiceID, Date: exception.Date}] = exception
+			}
+		}
+		return exceptions, g.mergeAddedServiceExceptions(exceptions, day)
+	}
+
+	// Exceptions are keyed by service ID and date, so only those on the date are decoded
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("serviceExceptions"))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			parts, err := splitCompositeKey(k)
+			if err != nil {
+				return err
+			}
+			if len(parts) != 2 || string(parts[1]) != day {
+				return nil
+			}
+			exception := &ServiceException{}
+			err = decodeServiceException(exception, v, g.Encoding)
+			if err != nil {
+				return err
+			}
+			exceptions[ServiceExceptionKey{ServiceID: exception.ServiceID, Date: exception.Date}] = exception
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return exceptions, g.mergeAddedServiceExceptions(exceptions, day)
+}
+
+// --- Paginated Query Functions ---
+
+type AgencyList []*Agency
+type RouteList []*Route
+type ServiceList []*Service
+type ServiceExceptionList []*ServiceException
+type ShapeList []*Shape
+type StopList []*Stop
+type TripList []*Trip
+
+// Iterates over up to limit entries of a bucket in key order, starting at the cursor
+// (or the first entry if the cursor is empty). Returns the key of the entry following
+// the page, or an empty key if there are no more entries.
+func (g *GTFS) listBucket(bucket string, cursor Key, limit int, fn func(tx *bolt.Tx, k, v []byte) error) (Key, error) {
+	if limit <= 0 {
+		return "", errors.New("limit must be positive")
+	}
+
+	var nextCursor Key
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+
+		c := b.Cursor()
+		var k, v []byte
+		if cursor == "" {
+			k, v = c.First()
+		} else {
+			k, v = c.Seek([]byte(cursor))
+		}
+
+		for count := 0; k != nil; k, v = c.Next() {
+			if count == limit {
+				nextCursor = Key(k)
+				break
+			}
+			err := fn(tx, k, v)
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+	return nextCursor, nil
+}
+
+// Returns a page of up to limit agencies in ID order, starting at the given cursor.
+// Pass an empty cursor for the first page, and the returned cursor for the next page;
+// an empty returned cursor means there are no more pages.
+func (g *GTFS) ListAgencies(cursor Key, limit int) (AgencyList, Key, error) {
+	agencies := AgencyList{}
+	nextCursor, err := g.listBucket("agencies", cursor, limit, func(tx *bolt.Tx, k, v []byte) error {
+		agency := &Agency{}
+		err := g.decode(tx, agency, Key(k), v)
+		if err != nil {
+			return err
+		}
+		agencies = append(agencies, agency)
+		return nil
+	})
+
+	if err != nil {
+		return nil, "", err
+	}
+	return agencies, nextCursor, nil
+}
+
+// Returns a page of up to limit routes in ID order, starting at the given cursor (see ListAgencies)
+func (g *GTFS) ListRoutes(cursor Key, limit int) (RouteList, Key, error) {
+	routes := RouteList{}
+	nextCursor, err := g.listBucket("routes", cursor, limit, func(tx *bolt.Tx, k, v []byte) error {
+		route := &Route{}
+		err := g.decode(tx, route, Key(k), v)
+		if err != nil {
+			return err
+		}
+		routes = append(routes, route)
+		return nil
+	})
+
+	if err != nil {
+		return nil, "", err
+	}
+	return routes, nextCursor, nil
+}
+
+// Returns a page of up to limit services in ID order, starting at the given cursor (see ListAgencies)
+func (g *GTFS) ListServices(cursor Key, limit int) (ServiceList, Key, error) {
+	services := ServiceList{}
+	nextCursor, err := g.listBucket("services", cursor, limit, func(tx *bolt.Tx, k, v []byte) error {
+		service := &Service{}
+		err := g.decode(tx, service, Key(k), v)
+		if err != nil {
+			return err
+		}
+		services = append(services, service)
+		return nil
+	})
+
+	if err != nil {
+		return nil, "", err
+	}
+	return services, nextCursor, nil
+}
+
+// Returns a page of up to limit service exceptions in storage order, starting at the given cursor (see ListAgencies)
+func (g *GTFS) ListServiceExceptions(cursor Key, limit int) (ServiceExceptionList, Key, error) {
+	exceptions := ServiceExceptionList{}
+	nextCursor, err := g.listBucket("serviceExceptions", cursor, limit, func(tx *bolt.Tx, k, v []byte) error {
+		exception := &ServiceException{}
+		err := decodeServiceException(exception, v, g.Encoding)
+		if err != nil {
+			return err
+		}
+		exceptions = append(exceptions, exception)
+		return nil
+	})
+
+	if err != nil {
+		return nil, "", err
+	}
+	return exceptions, nextCursor, nil
+}
+
+// Returns a page of up to limit shapes in ID order, starting at the given cursor (see ListAgencies)
+func (g *GTFS) ListShapes(cursor Key, limit int) (ShapeList, Key, error) {
+	shapes := ShapeList{}
+	nextCursor, err := g.listBucket("shapes", cursor, limit, func(tx *bolt.Tx, k, v []byte) error {
+		shape := &Shape{}
+		err := g.decode(tx, shape, Key(k), v)
+		if err != nil {
+			return err
+		}
+		shapes = append(shapes, shape)
+		return nil
+	})
+
+	if err != nil {
+		return nil, "", err
+	}
+	return shapes, nextCursor, nil
+}
+
+// Returns a page of up to limit stops in ID order, starting at the given cursor (see ListAgencies)
+func (g *GTFS) ListStops(cursor Key, limit int) (StopList, Key, error) {
+	stops := StopList{}
+	nextCursor, err := g.listBucket("stops", cursor, limit, func(tx *bolt.Tx, k, v []byte) error {
+		stop := &Stop{}
+		err := g.decode(tx, stop, Key(k), v)
+		if err != nil {
+			return err
+		}
+		stops = append(stops, stop)
+		return nil
+	})
+
+	if err != nil {
+		return nil, "", err
+	}
+	return stops, nextCursor, nil
+}
+
+// Returns a page of up to limit trips in ID order, starting at the given cursor (see ListAgencies)
+func (g *GTFS) ListTrips(cursor Key, limit int) (TripList, Key, error) {
+	trips := TripList{}
+	nextCursor, err := g.listBucket("trips", cursor, limit, func(tx *bolt.Tx, k, v []byte) error {
+		trip := &Trip{}
+		err := g.decode(tx, trip, Key(k), v)
+		if err != nil {
+			return err
+		}
+		trips = append(trips, trip)
+		return nil
+	})
+
+	if err != nil {
+		return nil, "", err
+	}
+	return trips, nextCursor, nil
 }
@@ -2,12 +2,16 @@ package gtfs
 
 import (
 	"archive/zip"
+	"bufio"
 	"bytes"
 	"errors"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -23,6 +27,8 @@ type routeShapeAndStops struct {
 	inboundShapeID  *Key
 	outboundShapeID *Key
 	stopIDs         KeyArray
+	inboundStopIDs  KeyArray
+	outboundStopIDs KeyArray
 }
 type routeShapeAndStopsMap map[Key]routeShapeAndStops
 
@@ -40,12 +46,15 @@ func getRouteShapeAndStops(tripMap TripMap) (routeShapeAndStopsMap, error) {
 	for routeID, trips := range routeTrips {
 		inboundShapesCounts := make(map[Key]KeyArray)
 		outboundShapesCounts := make(map[Key]KeyArray)
+		var inboundTrips, outboundTrips []*Trip
 
 		for _, trip := range trips {
 			if trip.Direction == InboundTripDirection {
 				inboundShapesCounts[trip.ShapeID] = append(inboundShapesCounts[trip.ShapeID], trip.ID)
+				inboundTrips = append(inboundTrips, trip)
 			} else {
 				outboundShapesCounts[trip.ShapeID] = append(outboundShapesCounts[trip.ShapeID], trip.ID)
+				outboundTrips = append(outboundTrips, trip)
 			}
 		}
 
@@ -95,26 +104,213 @@ func getRouteShapeAndStops(tripMap TripMap) (routeShapeAndStopsMap, error) {
 			}
 		}
 
+		// Sorted so that the same trips always give the same route
+		uniqueStopIDs := set.From[Key](stopIDs).Slice()
+		slices.Sort(uniqueStopIDs)
+
 		shapeAndStops[routeID] = routeShapeAndStops{
 			inboundShapeID:  &mostCommonInboundShapeID,
 			outboundShapeID: &mostCommonOutboundShapeID,
-			stopIDs:         set.From[Key](stopIDs).Slice(),
+			stopIDs:         uniqueStopIDs,
+			inboundStopIDs:  getCanonicalStopPattern(inboundTrips),
+			outboundStopIDs: getCanonicalStopPattern(outboundTrips),
 		}
 	}
 
 	return shapeAndStops, nil
 }
 
+// Options for the bolt database files of a GTFS database
+type DBOptions struct {
+	// Permissions of database files when they are created (defaults to 0600)
+	FileMode os.FileMode
+	// Time to wait for a lock on the database file before failing (zero waits indefinitely)
+	Timeout time.Duration
+	// Skip syncing the database to disk after each write while ingesting, and sync it once when complete.
+	// Ingesting is much faster, but a crash part way through leaves a corrupt database file.
+	NoSync bool
+	// Type of the database's freelist (defaults to bolt.FreelistArrayType)
+	FreelistType bolt.FreelistType
+	// Page size in bytes of database files when they are created (defaults to the OS page size)
+	PageSize int
+	// Open the database for writing, so that entities can be deleted (see DeleteTrips). Only one process
+	// can have a database open for writing, and no other process can read it meanwhile.
+	ReadWrite bool
+}
+
+// Returns the permissions of database files when they are created
+func (o DBOptions) fileMode() os.FileMode {
+	if o.FileMode == 0 {
+		return 0600
+	}
+	return o.FileMode
+}
+
+// Returns the bolt options for opening a database file
+func (o DBOptions) boltOptions(readOnly bool) *bolt.Options {
+	return &bolt.Options{
+		ReadOnly:     readOnly,
+		Timeout:      o.Timeout,
+		NoSync:       o.NoSync && !readOnly,
+		FreelistType: o.FreelistType,
+		PageSize:     o.PageSize,
+	}
+}
+
+// Returns the bolt options for opening a file kept alongside the database, such as the overrides file.
+// Such files are synced on every write, and wait for their lock for the given time unless a timeout is set.
+func (o DBOptions) sideFileOptions(readOnly bool, lockTimeout time.Duration) *bolt.Options {
+	opts := o.boltOptions(readOnly)
+	opts.NoSync = false
+	if opts.Timeout == 0 {
+		opts.Timeout = lockTimeout
+	}
+	return opts
+}
+
+// Options controlling how a GTFS feed is ingested into a database
+type IngestOptions struct {
+	// Serialization format used for entity values (defaults to BinaryEncoding)
+	Encoding Encoding
+
+	// Options for the database file, which are also used to open it once built
+	DB DBOptions
+
+	// Build the database from the files which parsed successfully, rather than
+	// failing when any file cannot be parsed
+	AllowPartial bool
+
+	// How malformed rows within each file are handled (defaults to StrictParseMode)
+	Parse ParseOptions
+
+	// Merge shapes with identical geometry, rewriting the shape IDs of their trips
+	DeduplicateShapes bool
+	// Tolerance in degrees within which shape coordinates are considered identical
+	// (defaults to DefaultShapeDedupTolerance)
+	ShapeDedupTolerance float64
+
+	// Synthesize shapes for trips without one by connecting their stops, so that every trip can be
+	// drawn. They are stored apart from the feed's shapes, and GetShapeByID falls back to them.
+	InferShapes bool
+	// Network along which the inferred shapes are snapped between stops, if any
+	ShapeSnapper ShapeSnapper
+
+	// Number of previous versions of the database to retain when it is replaced, for querying
+	// with OpenAsOf (zero disables archival, and the existing database is overwritten)
+	ArchiveVersions int
+
+	// Files which must be present in the feed (defaults to agency.txt, calendar.txt, stops.txt,
+	// routes.txt, trips.txt and stop_times.txt). Other standard files are optional, and their
+	// buckets are left empty when they are missing; use an empty slice to require no files.
+	RequiredFiles []string
+
+	// Precompute projected stop coordinates, so GetNearestStops can filter candidates by
+	// comparing them rather than calculating the true distance to every stop
+	ProjectStops bool
+
+	// Alternative names and codes for stops, such as names from before stops were renamed, which
+	// GetStopByName and Search also resolve (see ParseStopAliases to load them from a CSV file)
+	StopAliases []StopAlias
+
+	// Generate walking transfers between stops within this many metres of each other if the feed has no
+	// transfers.txt, so that journeys can change between nearby stops (zero disables generation)
+	TransferDistance float64
+	// Walking speed in metres per second used for the times of generated transfers (defaults to
+	// DefaultWalkingSpeed)
+	WalkingSpeed float64
+
+	// Precompute the stop patterns, headway statistics and simplified geometries of routes, storing them so
+	// GetStopPatterns, GetRouteHeadways and GetRouteGeometry read them rather than computing them per query
+	PrecomputeDerived bool
+	// Tolerance in degrees of the precomputed route geometries (defaults to DefaultGeometryTolerance).
+	// GetRouteGeometry only uses them when called with the same tolerance.
+	DerivedGeometryTolerance float64
+}
+
+// Summary of a feed ingest, for checking the health of a feed without inspecting logs
+type IngestReport struct {
+	Files  map[string]*FileReport // Parse reports for each parsed file, keyed by file name
+	Phases []PhaseTiming          // Time taken by each phase of the ingest, in the order they ran
+	DBSize int64                  // Size of the database file in bytes, once populated
+}
+
+// Time taken by a single phase of an ingest
+type PhaseTiming struct {
+	Phase    string
+	Duration time.Duration
+}
+
+// Record the time taken by a phase which started at the given time
+func (r *IngestReport) timePhase(phase string, start time.Time) {
+	r.Phases = append(r.Phases, PhaseTiming{Phase: phase, Duration: time.Since(start)})
+}
+
+// Returns the total number of rows read across all parsed files
+func (r *IngestReport) Rows() int {
+	rows := 0
+	for _, report := range r.Files {
+		rows += report.Rows
+	}
+	return rows
+}
+
+// Returns the total number of rows skipped across all parsed files
+func (r *IngestReport) SkippedRows() int {
+	skipped := 0
+	for _, report := range r.Files {
+		skipped += report.SkippedRows
+	}
+	return skipped
+}
+
+// Returns the total number of warnings across all parsed files
+func (r *IngestReport) WarningCount() int {
+	warnings := 0
+	for _, report := range r.Files {
+		warnings += report.WarningCount
+	}
+	return warnings
+}
+
+// Returns the total time taken by all phases of the ingest
+func (r *IngestReport) Duration() time.Duration {
+	var total time.Duration
+	for _, phase := range r.Phases {
+		total += phase.Duration
+	}
+	return total
+}
+
+// Get the most common stop sequence among the given trips, in travel order.
+// Ties are broken by preferring the longer sequence, then the lexically smaller one.
+func getCanonicalStopPattern(trips []*Trip) KeyArray {
+	patterns := groupStopPatterns(trips)
+	if len(patterns) == 0 {
+		return KeyArray{}
+	}
+	return patterns[0].StopIDs
+}
+
 // Load GTFS data from a local database file
 func (g *GTFS) FromDB(dbFile string) error {
+	return g.FromDBWithOptions(dbFile, DBOptions{})
+}
+
+// Load GTFS data from a local database file, using the given database options. The options are also
+// used for the overrides and alerts files (see OverrideStop and PutAlerts), except NoSync, and those files
+// wait briefly for their locks unless Timeout is set.
+func (g *GTFS) FromDBWithOptions(dbFile string, opts DBOptions) error {
 	log.Infof("Loading GTFS data from %s", dbFile)
 
-	db, err := bolt.Open(dbFile, 0600, &bolt.Options{ReadOnly: true})
+	db, err := bolt.Open(dbFile, opts.fileMode(), opts.boltOptions(!opts.ReadWrite))
 	if err != nil {
 		return err
 	}
 
 	g.db = db
+	g.handle = &sync.RWMutex{}
+	g.filePath = dbFile
+	g.dbOptions = opts
 
 	err = g.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("metadata"))
@@ -132,7 +328,11 @@ func (g *GTFS) FromDB(dbFile string) error {
 		}
 
 		if versionInt != CurrentVersion {
-			return errors.New("GTFS database version mismatch: expected " + strconv.Itoa(CurrentVersion) + ", got " + strconv.Itoa(versionInt))
+			msg := "GTFS database version mismatch: expected " + strconv.Itoa(CurrentVersion) + ", got " + strconv.Itoa(versionInt)
+			if _, ok := migrations[versionInt]; ok {
+				msg += " (migrate it with MigrateDB)"
+			}
+			return errors.New(msg)
 		}
 
 		created := b.Get([]byte("created"))
@@ -145,305 +345,385 @@ func (g *GTFS) FromDB(dbFile string) error {
 			return err
 		}
 
+		// Databases created before the encoding was recorded always use the binary format
+		encoding := BinaryEncoding
+		if encodingStr := b.Get([]byte("encoding")); encodingStr != nil {
+			encoding, err = ParseEncoding(string(encodingStr))
+			if err != nil {
+				return err
+			}
+		}
+
 		g.Version = versionInt
 		g.Created = createdInt
+		g.Encoding = encoding
 
 		return nil
 	})
 
 	if err != nil {
+		// Release the file so that it can be migrated or rebuilt
+		g.db.Close()
+		g.db = nil
 		return err
 	}
 
+	g.overrides, err = loadOverrides(dbFile, opts)
+	if err != nil {
+		return err
+	}
+	g.alerts, err = loadAlerts(dbFile, opts)
+	if err != nil {
+		return err
+	}
+	g.locations, err = g.loadAgencyLocations()
+	if err != nil {
+		return err
+	}
+	g.serviceChanges = &serviceChangeLayer{}
+	g.notifier = newChangeNotifier()
+	g.serviceDays = &serviceDaysCache{}
+	g.cache = &entityCache{}
+	g.numericIDs = &numericIDCache{}
+
 	log.Debugf("Loaded GTFS data from %s", dbFile)
 	return nil
 }
 
 // Construct a new GTFS database from a hosted GTFS URL
 func (g *GTFS) FromURL(gtfsURL, dbFile string) error {
+	return g.FromURLWithOptions(gtfsURL, dbFile, IngestOptions{})
+}
+
+// Construct a new GTFS database from a hosted GTFS URL, using the given ingest options
+func (g *GTFS) FromURLWithOptions(gtfsURL, dbFile string, opts IngestOptions) error {
+	_, err := g.FromURLReport(gtfsURL, dbFile, opts)
+	return err
+}
+
+// Construct a new GTFS database from a hosted GTFS URL as with FromURLWithOptions, returning a report
+// of the ingest. The report covers the phases completed so far, and is returned even if the ingest fails.
+func (g *GTFS) FromURLReport(gtfsURL, dbFile string, opts IngestOptions) (*IngestReport, error) {
+	report := &IngestReport{
+		Files:  make(map[string]*FileReport),
+		Phases: []PhaseTiming{},
+	}
+
 	// Download the GTFS data from the URL
 	log.Infof("Downloading GTFS data from %s", gtfsURL)
+	start := time.Now()
 
-	client := resty.New()
-	defer client.Close()
+	zipBytes, err := downloadFeed(gtfsURL)
+	if err != nil {
+		return report, err
+	}
+	report.timePhase("download", start)
 
-	resp, err := client.R().Get(gtfsURL)
+	// Open all files in the zip archive
+	log.Debugf("Opening GTFS files from %s", gtfsURL)
+
+	readers, closeFiles, err := openFeedZip(zipBytes)
 	if err != nil {
-		return err
+		return report, err
 	}
-	if resp.IsError() {
-		return errors.New("failed to download GTFS data: " + resp.Status())
+	defer closeFiles()
+
+	// Check for required files
+	err = checkRequiredFiles(readers, opts)
+	if err != nil {
+		return report, err
 	}
 
-	// Read the zip file from the response body
-	log.Debugf("Reading GTFS data from %s", gtfsURL)
+	// Parse each GTFS file concurrently
+	log.Debugf("Parsing GTFS data from %s", gtfsURL)
+	start = time.Now()
 
-	zipBytes, err := io.ReadAll(resp.Body)
-	defer resp.Body.Close()
+	feed, err := ParseFeedWithOptions(readers, opts.Parse)
+	report.Files = feed.Reports
+	report.timePhase("parse", start)
 	if err != nil {
-		return err
+		if !opts.AllowPartial {
+			return report, err
+		}
+		log.Warnf("Continuing with partially parsed GTFS data: %v", err)
 	}
-	zipReader, err := zip.NewReader(bytes.NewReader(zipBytes), int64(len(zipBytes)))
-	if err != nil {
-		return err
+	for _, report := range feed.Reports {
+		if report.SkippedRows > 0 || report.RepairedRows > 0 {
+			log.Warnf("%s: skipped %d and repaired %d of %d rows", report.File, report.SkippedRows, report.RepairedRows, report.Rows)
+		}
 	}
 
-	// Open all files in the zip archive
-	log.Debugf("Opening GTFS files from %s", gtfsURL)
+	log.Debugf("Finished loading GTFS data from %s: %s", gtfsURL, feed)
 
-	readers := make(map[string]io.Reader)
-	openFiles := []io.ReadCloser{}
+	err = prepareFeed(feed, opts, report)
+	if err != nil {
+		return report, err
+	}
 
-	for _, file := range zipReader.File {
-		f, err := file.Open()
+	err = writeFeedDB(dbFile, opts, feed, report)
+	if err != nil {
+		return report, err
+	}
+	return report, g.FromDBWithOptions(dbFile, opts.DB)
+}
+
+// Archive any existing database at dbFile if the options retain versions, then populate it from the prepared
+// feed, recording the phases and the size of the database in the report
+func writeFeedDB(dbFile string, opts IngestOptions, feed *Feed, report *IngestReport) error {
+	// Archive the existing GTFS database before it is replaced
+	if opts.ArchiveVersions > 0 {
+		start := time.Now()
+		err := archiveDB(dbFile, opts.ArchiveVersions)
 		if err != nil {
 			return err
 		}
-		defer f.Close()
+		report.timePhase("archive", start)
+	}
 
-		openFiles = append(openFiles, f)
-		readers[file.Name] = f
+	// Initialize the GTFS database
+	log.Debugf("Initializing GTFS database at %s", dbFile)
+	start := time.Now()
+	err := initDB(dbFile, opts, feed)
+	if err != nil {
+		return err
 	}
+	report.timePhase("populate", start)
 
-	defer func() {
-		for _, f := range openFiles {
-			f.Close()
-		}
-	}()
+	info, err := os.Stat(dbFile)
+	if err != nil {
+		return err
+	}
+	report.DBSize = info.Size()
+	return nil
+}
 
-	// Check for required files
-	for _, file := range requiredFiles {
-		if _, ok := readers[file]; !ok {
-			return errors.New("missing required GTFS file: " + file)
-		}
+// Run the ingest of the source as with FromURLReport, parsing and preparing its feed, but without writing
+// a database, so a feed can be checked quickly, e.g. before it is published. The source's URL is downloaded
+// unless it has a path. The returned feed has its route shapes and stops set, and any inferred shapes and
+// generated transfers, as they would be stored. Data derived while the database is populated, such as
+// segment travel times, the precomputed data of IngestOptions.PrecomputeDerived and the search index, is
+// not computed, so its cost is not included in the report. As with FromURLReport, the report is returned
+// even if the ingest fails.
+func ParseOnly(source Source, opts IngestOptions) (*Feed, *IngestReport, error) {
+	report := &IngestReport{
+		Files:  make(map[string]*FileReport),
+		Phases: []PhaseTiming{},
 	}
 
-	var agencies AgencyMap
-	var routes RouteMap
-	var services ServiceMap
-	var serviceExceptions ServiceExceptionMap
-	var shapes ShapeMap
-	var stops StopMap
-	var trips TripMap
+	log.Infof("Loading GTFS data from %s", source)
+	start := time.Now()
+	zipBytes, err := readSource(source)
+	if err != nil {
+		return nil, report, err
+	}
+	report.timePhase("download", start)
 
-	var maxShapeLength int
+	start = time.Now()
+	feed, err := parseSource(zipBytes, opts)
+	if feed != nil {
+		report.Files = feed.Reports
+	}
+	report.timePhase("parse", start)
+	if err != nil {
+		return feed, report, err
+	}
 
-	var wg sync.WaitGroup
-	errChannel := make(chan error, 1)
-	completion := make(chan any)
+	err = prepareFeed(feed, opts, report)
+	if err != nil {
+		return feed, report, err
+	}
 
-	// Create functions to parse each GTFS file concurrently
-	log.Debugf("Parsing GTFS data from %s", gtfsURL)
+	// Transfers are otherwise generated as the database is populated
+	if len(feed.Transfers) == 0 && opts.TransferDistance > 0 {
+		start = time.Now()
+		feed.Transfers = GenerateTransfers(feed.Stops, opts.TransferDistance, opts.WalkingSpeed)
+		log.Debugf("Generated %d transfers", len(feed.Transfers))
+		report.timePhase("transfers", start)
+	}
+	return feed, report, nil
+}
 
-	go func() {
-		for result := range completion {
-			switch v := result.(type) {
-			case AgencyMap:
-				agencies = v
-			case RouteMap:
-				routes = v
-			case ServiceMap:
-				services = v
-			case ServiceExceptionMap:
-				serviceExceptions = v
-			case ShapeMap:
-				shapes = v
-			case StopMap:
-				stops = v
-			case TripMap:
-				trips = v
-			case int:
-				maxShapeLength = v
-			}
-		}
-	}()
-
-	// Load agencies
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		var loadErr error // Declare err within this scope
-		agencies, loadErr = ParseAgencies(readers["agency.txt"])
-		log.Debugf("Parsed %d agencies", len(agencies))
-		if loadErr != nil {
-			select { // Non-blocking send to avoid deadlock if errChan is full
-			case errChannel <- loadErr:
-			default:
-			}
-			return
-		}
-		completion <- agencies
-	}()
-
-	// Load routes
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		var loadErr error
-		routes, loadErr = ParseRoutes(readers["routes.txt"])
-		log.Debugf("Parsed %d routes", len(routes))
-		if loadErr != nil {
-			select {
-			case errChannel <- loadErr:
-			default:
-			}
-			return
-		}
-		completion <- routes
-	}()
-
-	// Load services (calendar.txt)
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		var loadErr error
-		services, loadErr = ParseServices(readers["calendar.txt"])
-		log.Debugf("Parsed %d services", len(services))
-		if loadErr != nil {
-			select {
-			case errChannel <- loadErr:
-			default:
-			}
-			return
-		}
-		completion <- services
-	}()
-
-	// Load service exceptions (calendar_dates.txt) - Optional file
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		reader, ok := readers["calendar_dates.txt"]
-		if !ok {
-			// File not found, just exit the goroutine. wg.Done() handles the counter.
-			log.Debugf("calendar_dates.txt not found, skipping")
-			return
-		}
-		var loadErr error
-		serviceExceptions, loadErr = ParseServiceExceptions(reader)
-		log.Debugf("Parsed %d service exceptions", len(serviceExceptions))
-		if loadErr != nil {
-			select {
-			case errChannel <- loadErr:
-			default:
-			}
-			return
-		}
-		completion <- serviceExceptions
-	}()
-
-	// Load shapes (shapes.txt) - Optional file
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		reader, ok := readers["shapes.txt"]
+// Deduplicate and infer the feed's shapes as enabled by the options, then set the most common shapes and
+// stops of its routes, recording the time taken by each phase in the report
+func prepareFeed(feed *Feed, opts IngestOptions, report *IngestReport) error {
+	// Merge duplicate shapes before the route shapes are chosen
+	if opts.DeduplicateShapes {
+		start := time.Now()
+		removed := deduplicateShapes(feed.Shapes, feed.Trips, opts.ShapeDedupTolerance)
+		log.Debugf("Removed %d duplicate shapes", removed)
+		report.timePhase("deduplicate shapes", start)
+	}
+
+	// Infer missing shapes before the route shapes are chosen
+	if opts.InferShapes {
+		start := time.Now()
+		inferred := inferShapes(feed, opts.ShapeSnapper)
+		log.Debugf("Inferred %d shapes", inferred)
+		report.timePhase("infer shapes", start)
+	}
+
+	// Get the most common shape ID and stop IDs for each route
+	log.Debugf("Getting route shape and stops")
+	start := time.Now()
+	err := setRouteShapesAndStops(feed)
+	if err != nil {
+		return err
+	}
+	report.timePhase("route shapes", start)
+	return nil
+}
+
+// Construct a new GTFS database from an already parsed feed, using the given ingest options.
+// The feed's routes are updated with their most common shapes and stops, as when ingesting from a URL.
+func (g *GTFS) FromFeed(feed *Feed, dbFile string, opts IngestOptions) error {
+	// No report is returned, so the phase timings are discarded
+	err := prepareFeed(feed, opts, &IngestReport{})
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Initializing GTFS database at %s", dbFile)
+	err = initDB(dbFile, opts, feed)
+	if err != nil {
+		return err
+	}
+	return g.FromDBWithOptions(dbFile, opts.DB)
+}
+
+// Download a GTFS zip archive from the URL
+func downloadFeed(gtfsURL string) ([]byte, error) {
+	client := resty.New()
+	defer client.Close()
+
+	resp, err := client.R().Get(gtfsURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return nil, errors.New("failed to download GTFS data: " + resp.Status())
+	}
+
+	// Read the zip file from the response body
+	log.Debugf("Reading GTFS data from %s", gtfsURL)
+
+	zipBytes, err := io.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	return zipBytes, nil
+}
+
+// UTF-8 byte order mark, which some feeds prefix their files with
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Returns a reader of the file with any leading UTF-8 byte order mark removed
+func stripBOM(r io.Reader) io.Reader {
+	buffered := bufio.NewReader(r)
+	prefix, _ := buffered.Peek(len(utf8BOM))
+	if bytes.Equal(prefix, utf8BOM) {
+		buffered.Discard(len(utf8BOM))
+	}
+	return buffered
+}
+
+// Returns the name a zip entry is looked up by, and its depth within the archive's folders. Files may be nested
+// inside folders and have names in any case, so the name is the lowercase base name. Directories and metadata
+// added by macOS archivers are skipped.
+func feedZipEntryName(file *zip.File) (string, int, bool) {
+	entryPath := strings.ReplaceAll(file.Name, "\\", "/")
+	name := path.Base(entryPath)
+	if file.FileInfo().IsDir() || strings.HasPrefix(entryPath, "__MACOSX/") || strings.HasPrefix(name, ".") {
+		return "", 0, false
+	}
+	return strings.ToLower(name), strings.Count(strings.Trim(entryPath, "/"), "/"), true
+}
+
+// Open all files in a GTFS zip archive, keyed by lowercase name regardless of the folder they are in, with any
+// byte order marks removed. If several files share a name, the least nested is used. The returned function
+// closes the files.
+func openFeedZip(zipBytes []byte) (map[string]io.Reader, func(), error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries := make(map[string]*zip.File)
+	depths := make(map[string]int)
+	for _, file := range zipReader.File {
+		name, depth, ok := feedZipEntryName(file)
 		if !ok {
-			// File not found, just exit the goroutine. wg.Done() handles the counter.
-			log.Debugf("shapes.txt not found, skipping")
-			return
+			continue
 		}
-		var loadErr error
-		shapes, maxShapeLength, loadErr = ParseShapes(reader)
-		log.Debugf("Parsed %d shapes", len(shapes))
-		if loadErr != nil {
-			select {
-			case errChannel <- loadErr:
-			default:
-			}
-			return
+		if existing, ok := depths[name]; ok && existing <= depth {
+			continue
 		}
+		entries[name] = file
+		depths[name] = depth
+	}
 
-		completion <- shapes
-		completion <- maxShapeLength
-	}()
-
-	// Load stops
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		var loadErr error
-		stops, loadErr = ParseStops(readers["stops.txt"])
-		log.Debugf("Parsed %d stops", len(stops))
-		if loadErr != nil {
-			select {
-			case errChannel <- loadErr:
-			default:
-			}
-			return
+	readers := make(map[string]io.Reader)
+	openFiles := []io.ReadCloser{}
+	closeFiles := func() {
+		for _, f := range openFiles {
+			f.Close()
 		}
-		completion <- stops
-	}()
-
-	// Load trips (trips.txt and stop_times.txt)
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		var loadErr error
-		trips, loadErr = ParseTrips(readers["trips.txt"], readers["stop_times.txt"])
-		log.Debugf("Parsed %d trips", len(trips))
-		if loadErr != nil {
-			select {
-			case errChannel <- loadErr:
-			default:
-			}
-			return
+	}
+
+	for name, file := range entries {
+		f, err := file.Open()
+		if err != nil {
+			closeFiles()
+			return nil, nil, err
 		}
-		completion <- trips
-	}()
 
-	wg.Wait()
-	close(completion)
-	defer close(errChannel)
+		openFiles = append(openFiles, f)
+		readers[name] = stripBOM(f)
+	}
+	return readers, closeFiles, nil
+}
 
-	select {
-	case err := <-errChannel:
-		if err != nil {
+// Check that the files required by the ingest options are present
+func checkRequiredFiles(readers map[string]io.Reader, opts IngestOptions) error {
+	required := requiredFiles
+	if opts.RequiredFiles != nil {
+		required = opts.RequiredFiles
+	}
+	for _, file := range required {
+		if _, ok := readers[file]; !ok {
+			err := errors.New("missing required GTFS file: " + file)
+			if opts.Parse.Conformance {
+				return &SpecError{Code: "missing_required_file", Rule: file + " is required", File: file, Err: err}
+			}
 			return err
 		}
-	default:
 	}
+	return nil
+}
 
-	log.Debugf("Finished loading GTFS data from %s", gtfsURL)
-
-	// Get the most common shape ID and stop IDs for each route
-	log.Debugf("Getting route shape and stops")
-
-	shapeAndStops, err := getRouteShapeAndStops(trips)
+// Set the most common shape IDs and stop IDs of each route in the feed from its trips
+func setRouteShapesAndStops(feed *Feed) error {
+	shapeAndStops, err := getRouteShapeAndStops(feed.Trips)
 	if err != nil {
 		return err
 	}
 	for routeID, shapeAndStopsData := range shapeAndStops {
-		route, ok := routes[routeID]
+		route, ok := feed.Routes[routeID]
 		if !ok {
 			continue
 		}
 		route.InboundShapeID = shapeAndStopsData.inboundShapeID
 		route.OutboundShapeID = shapeAndStopsData.outboundShapeID
 		route.Stops = shapeAndStopsData.stopIDs
-		routes[routeID] = route
+		route.InboundStops = shapeAndStopsData.inboundStopIDs
+		route.OutboundStops = shapeAndStopsData.outboundStopIDs
+		feed.Routes[routeID] = route
 	}
-
-	// Initialize the GTFS database
-	log.Debugf("Initializing GTFS database at %s", dbFile)
-	err = initDB(dbFile, agencies, routes, services, serviceExceptions, shapes, stops, trips)
-	if err != nil {
-		return err
-	}
-
-	return g.FromDB(dbFile)
+	return nil
 }
 
 // Initialize a GTFS database from loaded data
-func initDB(
-	dbFile string,
-	agencies AgencyMap,
-	routes RouteMap,
-	services ServiceMap,
-	serviceExceptions ServiceExceptionMap,
-	shapes ShapeMap,
-	stops StopMap,
-	trips TripMap,
-) error {
+func initDB(dbFile string, opts IngestOptions, feed *Feed) error {
 	// Create the database file
 	dirPath := filepath.Dir(dbFile)
 	err := os.MkdirAll(dirPath, 0755)
@@ -452,14 +732,35 @@ func initDB(
 	}
 
 	// Open the database file
-	db, err := bolt.Open(dbFile, 0600, nil)
+	db, err := bolt.Open(dbFile, opts.DB.fileMode(), opts.DB.boltOptions(false))
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
 	// Populate the database with the loaded data
-	err = Populate(db, agencies, routes, services, serviceExceptions, shapes, stops, trips)
+	err = PopulateWithOptions(db, opts, feed.Agencies, feed.Routes, feed.Services, feed.ServiceExceptions, feed.Shapes, feed.Stops, feed.Trips)
+	if err != nil {
+		return err
+	}
+	err = populateDerivedShapes(db, opts.Encoding, feed.derivedShapes, feed.Trips)
+	if err != nil {
+		return err
+	}
+
+	// Populate the database with the feed's transfers, or generated ones if it has none
+	transfers := feed.Transfers
+	if len(transfers) == 0 && opts.TransferDistance > 0 {
+		transfers = GenerateTransfers(feed.Stops, opts.TransferDistance, opts.WalkingSpeed)
+		log.Debugf("Generated %d transfers", len(transfers))
+	}
+	err = populateTransfers(db, transfers)
+	if err != nil {
+		return err
+	}
+
+	// Populate the database with any extension entities
+	err = populateExtensions(db, feed.Extensions)
 	if err != nil {
 		return err
 	}
@@ -478,11 +779,19 @@ func initDB(
 		if err != nil {
 			return err
 		}
+		err = b.Put([]byte("encoding"), []byte(opts.Encoding.String()))
+		if err != nil {
+			return err
+		}
 		return nil
 	})
 	if err != nil {
 		return err
 	}
 
+	// Writes were not synced as they were made
+	if opts.DB.NoSync {
+		return db.Sync()
+	}
 	return nil
 }
@@ -1,6 +1,10 @@
 package gtfs
 
 import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
 	"time"
 
 	"github.com/charmbracelet/log"
@@ -17,6 +21,49 @@ func hasDay(flags WeekdayFlag, day time.Weekday) bool {
 	return (flags & dayFlag) != 0
 }
 
+// Check if the given service is running on the day of the given time, using its active days (see
+// GetServiceDays). Results are stored in the cache, keyed by service ID, to avoid repeated lookups.
+func (g *GTFS) isServiceRunning(serviceID Key, t time.Time, cache map[Key]bool) (bool, error) {
+	if running, ok := cache[serviceID]; ok {
+		return running, nil
+	}
+
+	days, err := g.GetServiceDays(serviceID)
+	if err != nil {
+		return false, err
+	}
+
+	running := days.Contains(t)
+	cache[serviceID] = running
+	return running, nil
+}
+
+// Check if the service runs on the day of the given time, given its exception on that day (or nil if it has none)
+func serviceRunsOn(service *Service, exception *ServiceException, t time.Time) bool {
+	var running bool
+	if exception != nil {
+		running = exception.Type == AddedExceptionType
+	} else {
+		running = hasDay(service.Weekdays, t.Weekday())
+	}
+
+	return running && service.StartDate.Before(t) && service.EndDate.After(t)
+}
+
+// Returns noon on each day from the day of start to the day of end (inclusive) in the given location.
+// Services are checked at noon to avoid ambiguity around DST changes.
+func serviceDayNoons(start, end time.Time, loc *time.Location) []time.Time {
+	startYear, startMonth, startDay := start.In(loc).Date()
+	endYear, endMonth, endDay := end.In(loc).Date()
+	last := time.Date(endYear, endMonth, endDay, 12, 0, 0, 0, loc)
+
+	var noons []time.Time
+	for noon := time.Date(startYear, startMonth, startDay, 12, 0, 0, 0, loc); !noon.After(last); noon = noon.AddDate(0, 0, 1) {
+		noons = append(noons, noon)
+	}
+	return noons
+}
+
 func isTripWithinInterval(tripStartTime, tripEndTime, tSeconds, bufferSeconds int) bool {
 	// Normalize trip times to potentially span beyond secondsInDay if crossing midnight
 	normTripStart := tripStartTime
@@ -62,44 +109,32 @@ func (g *GTFS) GetCurrentTripsWithBuffer(trips TripMap, t time.Time, buffer time
 		return nil, err
 	}
 
-	agency, err := g.GetAgencyByID(route.AgencyID)
-	if err != nil {
-		log.Errorf("Failed to get agency by ID: %v", err)
-		return nil, err
-	}
-
-	timezone, err := time.LoadLocation(agency.Timezone)
+	timezone, err := g.getAgencyTimezone(route.AgencyID)
 	if err != nil {
 		log.Errorf("Failed to load timezone: %v", err)
 		return nil, err
 	}
 
+	// Measure the time from the start of the service day (noon minus 12 hours), as stop times are, so that
+	// times stay correct on days with daylight saving transitions
 	t = t.In(timezone)
-	tSeconds := t.Hour()*3600 + t.Minute()*60 + t.Second()
+	day := serviceDayStart(t, timezone)
+	tSeconds := int(t.Sub(day).Seconds())
 
-	weekday := t.Weekday()
+	// Apply realtime delays and cancellations, if a source is attached
+	trips, err = g.applyRealtime(trips, day)
+	if err != nil {
+		log.Errorf("Failed to apply realtime updates: %v", err)
+		return nil, err
+	}
 
 	runningCache := make(map[Key]bool) // service id -> running
 	for tripID, trip := range trips {
 		// Check if the trip is running on the current day
-		running, ok := runningCache[trip.ServiceID]
-		if !ok {
-			service, err := g.GetServiceByID(trip.ServiceID)
-			if err != nil {
-				log.Errorf("Failed to get service by ID: %v", err)
-				return nil, err
-			}
-			exception, _ := g.GetServiceException(trip.ServiceID, t)
-
-			if exception != nil {
-				running = exception.Type == AddedExceptionType
-			} else {
-				running = hasDay(service.Weekdays, weekday)
-			}
-
-			running = running && service.StartDate.Before(t) && service.EndDate.After(t)
-
-			runningCache[trip.ServiceID] = running
+		running, err := g.isTripRunning(trip, t, runningCache)
+		if err != nil {
+			log.Errorf("Failed to get service by ID: %v", err)
+			return nil, err
 		}
 
 		if !running {
@@ -138,13 +173,257 @@ func (g *GTFS) GetCurrentTrips(trips TripMap) (TripMap, error) {
 	return g.GetCurrentTripsWithBuffer(trips, time.Now(), 0)
 }
 
+// Returns all trips that are running at the given time. Only the trips whose start times could place them
+// within that time are loaded, using the trip start index.
+func (g *GTFS) GetAllCurrentTripsAt(t time.Time) (TripMap, error) {
+	tripIDs, err := g.getCandidateCurrentTripIDs(t, 0)
+	if err != nil {
+		return nil, err
+	}
+	trips, err := g.GetTripsByIDs(tripIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.GetCurrentTripsWithBuffer(trips, t, 0)
+}
+
 // Returns all trips that are currently running
 func (g *GTFS) GetAllCurrentTrips() (TripMap, error) {
-	// Fetch all trips from the GTFS database
-	trips, err := g.GetAllTrips()
+	return g.GetAllCurrentTripsAt(time.Now())
+}
+
+// Returns the trip of the route in the given direction which starts closest to the departure time, within the
+// tolerance either side of it. Trips are matched on the service days before, of and after the departure, taking
+// applied service changes into account, so that trips without a trip ID in external data such as vehicle
+// locations or ticketing can be matched to the schedule.
+func (g *GTFS) FindTrip(routeID Key, dir TripDirection, departure time.Time, tolerance time.Duration) (*Trip, error) {
+	if tolerance < 0 {
+		return nil, errors.New("tolerance must not be negative")
+	}
+
+	trips, err := g.GetTripsByRouteAndDirection(routeID, dir)
+	if err != nil {
+		return nil, err
+	}
+	timezone, err := g.getFeedTimezone()
 	if err != nil {
 		return nil, err
 	}
+	day := serviceDayStart(departure.In(timezone), timezone)
 
-	return g.GetCurrentTripsWithBuffer(trips, time.Now(), 0)
+	var best *Trip
+	var bestDiff time.Duration
+	for _, offset := range []int{-1, 0, 1} {
+		noon := addServiceDays(day, offset).Add(12 * time.Hour)
+		runningCache := make(map[Key]bool)
+
+		for _, trip := range trips {
+			if len(trip.Stops) == 0 {
+				continue
+			}
+			diff := trip.StartTimeOn(noon, timezone).Sub(departure).Abs()
+			if diff > tolerance || (best != nil && (diff > bestDiff || (diff == bestDiff && trip.ID > best.ID))) {
+				continue
+			}
+
+			running, err := g.isTripRunning(trip, noon, runningCache)
+			if err != nil {
+				return nil, err
+			}
+			if running {
+				best, bestDiff = trip, diff
+			}
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("trip %w", ErrNotFound)
+	}
+	return best, nil
+}
+
+// A scheduled departure of a trip from a stop
+type Departure struct {
+	TripID    Key       `json:"trip_id"`
+	RouteID   Key       `json:"route_id"`
+	Headsign  string    `json:"trip_headsign"`
+	StopIndex int       `json:"stop_index"` // Index of the stop in the trip's stops
+	Time      time.Time `json:"departure_time"`
+}
+
+// Returns the departures from the given stop within the window starting at the given time, sorted by time.
+// Trips from the previous service day which run past midnight are included. Updates from an attached
+// realtime source are applied. The last stop of a trip is not considered a departure.
+func (g *GTFS) GetStopDepartures(stopID Key, from time.Time, window time.Duration) ([]Departure, error) {
+	prepared, err := g.PrepareDepartures(stopID)
+	if err != nil {
+		return nil, err
+	}
+	return prepared.Departures(from, window)
+}
+
+// Time either side of the requested time over which headways are estimated
+const headwayWindow = time.Hour
+
+// Estimated wait for the next vehicle of a route at a stop, for displays such as "every 10 minutes"
+type WaitEstimate struct {
+	Headway time.Duration `json:"headway"` // Median time between scheduled departures
+	Wait    time.Duration `json:"wait"`    // Expected wait for a passenger arriving at a random time
+}
+
+// Returns the expected wait for the route at the stop around the given time, based on the scheduled headways
+// between its departures within headwayWindow either side of the time. The expected wait accounts for uneven
+// headways, and is half the headway when they are even. An error is returned if the route has fewer than two
+// departures from the stop in that period.
+func (g *GTFS) EstimateWaitTime(stopID, routeID Key, t time.Time) (*WaitEstimate, error) {
+	_, err := g.GetRouteByID(routeID)
+	if err != nil {
+		return nil, err
+	}
+	departures, err := g.GetStopDepartures(stopID, t.Add(-headwayWindow), 2*headwayWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous time.Time
+	headways := []time.Duration{}
+	for _, departure := range departures {
+		if departure.RouteID != routeID {
+			continue
+		}
+		if !previous.IsZero() {
+			headways = append(headways, departure.Time.Sub(previous))
+		}
+		previous = departure.Time
+	}
+	if len(headways) == 0 {
+		return nil, errors.New("not enough departures to estimate headway")
+	}
+
+	// A passenger is more likely to arrive during a long gap, so the expected wait is the
+	// sum of the squared headways over twice their total
+	var total, squares float64
+	for _, headway := range headways {
+		total += headway.Seconds()
+		squares += headway.Seconds() * headway.Seconds()
+	}
+	var wait time.Duration
+	if total > 0 {
+		wait = time.Duration(squares / (2 * total) * float64(time.Second))
+	}
+
+	slices.Sort(headways)
+	return &WaitEstimate{
+		Headway: headways[len(headways)/2],
+		Wait:    wait.Round(time.Second),
+	}, nil
+}
+
+// Time after the requested time over which departures are listed in route summaries
+const summaryLookahead = 24 * time.Hour
+
+// A route serving a stop with a preview of its next departures, as shown in a stop's route list
+type StopRouteSummary struct {
+	Route      *Route        `json:"route"`
+	Departures []Departure   `json:"departures"` // Next departures from the stop, sorted by time
+	Headway    time.Duration `json:"headway"`    // Median time between departures around the time, zero if unknown
+}
+
+// Returns a summary of each route serving the stop at the given time, with up to count of its next
+// departures within summaryLookahead and its median headway at the stop within headwayWindow either side
+// of the time. Summaries are sorted by next departure, with routes not departing within the lookahead last,
+// and ties broken by route ID. Departures are read once for all routes.
+func (g *GTFS) GetStopRouteSummaries(stopID Key, t time.Time, count int) ([]*StopRouteSummary, error) {
+	prepared, err := g.PrepareDepartures(stopID)
+	if err != nil {
+		return nil, err
+	}
+
+	routeIDs := []Key{}
+	seen := make(map[Key]bool)
+	for _, trip := range prepared.trips {
+		if !seen[trip.RouteID] {
+			seen[trip.RouteID] = true
+			routeIDs = append(routeIDs, trip.RouteID)
+		}
+	}
+	routes, err := g.GetRoutesByIDs(routeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	departures, err := prepared.Departures(t.Add(-headwayWindow), headwayWindow+summaryLookahead)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make(map[Key]*StopRouteSummary, len(routes))
+	previous := make(map[Key]time.Time, len(routes))
+	headways := make(map[Key][]time.Duration, len(routes))
+	for routeID, route := range routes {
+		summaries[routeID] = &StopRouteSummary{Route: route, Departures: []Departure{}}
+	}
+	for _, departure := range departures {
+		summary, ok := summaries[departure.RouteID]
+		if !ok {
+			continue
+		}
+		if !departure.Time.Before(t) && len(summary.Departures) < count {
+			summary.Departures = append(summary.Departures, departure)
+		}
+		if departure.Time.Sub(t) > headwayWindow {
+			continue
+		}
+		if last, ok := previous[departure.RouteID]; ok {
+			headways[departure.RouteID] = append(headways[departure.RouteID], departure.Time.Sub(last))
+		}
+		previous[departure.RouteID] = departure.Time
+	}
+
+	result := make([]*StopRouteSummary, 0, len(summaries))
+	for routeID, summary := range summaries {
+		if routeHeadways := headways[routeID]; len(routeHeadways) > 0 {
+			slices.Sort(routeHeadways)
+			summary.Headway = routeHeadways[len(routeHeadways)/2]
+		}
+		result = append(result, summary)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if len(a.Departures) > 0 && len(b.Departures) > 0 && !a.Departures[0].Time.Equal(b.Departures[0].Time) {
+			return a.Departures[0].Time.Before(b.Departures[0].Time)
+		}
+		if (len(a.Departures) > 0) != (len(b.Departures) > 0) {
+			return len(a.Departures) > 0
+		}
+		return a.Route.ID < b.Route.ID
+	})
+	return result, nil
+}
+
+// Returns the dates between from and to (inclusive) on which the trip operates, combining its
+// service's weekdays and date range with any exceptions. Each date is midnight in the feed's timezone.
+func (g *GTFS) ExpandTrip(tripID Key, from, to time.Time) ([]time.Time, error) {
+	trip, err := g.GetTripByID(tripID)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := g.getFeedTimezone()
+	if err != nil {
+		return nil, err
+	}
+
+	dates := []time.Time{}
+	for _, noon := range serviceDayNoons(from, to, loc) {
+		running, err := g.isTripRunning(trip, noon, make(map[Key]bool))
+		if err != nil {
+			return nil, err
+		}
+		if running {
+			year, month, day := noon.Date()
+			dates = append(dates, time.Date(year, month, day, 0, 0, 0, 0, loc))
+		}
+	}
+	return dates, nil
 }
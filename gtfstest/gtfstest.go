@@ -0,0 +1,256 @@
+// Package gtfstest synthesizes small GTFS feeds for testing code which uses the gtfs package,
+// without downloading a real agency's feed.
+//
+//	func TestSomething(t *testing.T) {
+//		g := gtfstest.New(t, gtfstest.Options{Routes: 3})
+//		route, err := g.GetRouteByID(gtfstest.RouteID(0))
+//		...
+//	}
+//
+// Each route runs along its own straight line of stops, with trips alternating between the
+// outbound and inbound directions at a fixed headway.
+package gtfstest
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aaroncutress/gtfs-go"
+)
+
+// ID of the single agency in a synthesized feed
+const AgencyID gtfs.Key = "A"
+
+// Defaults for options which are not set
+const (
+	defaultRoutes        = 2
+	defaultTripsPerRoute = 4
+	defaultStopsPerRoute = 5
+	defaultTimezone      = "Australia/Perth"
+	defaultFirstTrip     = 6 * time.Hour
+	defaultHeadway       = 30 * time.Minute
+	defaultStopInterval  = 5 * time.Minute
+)
+
+// Location of the first stop of the first route
+var origin = gtfs.NewCoordinate(-31.95, 115.86)
+
+// Distance in degrees between consecutive stops, and between the lines of consecutive routes
+const (
+	stopSpacing  = 0.005
+	routeSpacing = 0.01
+)
+
+// Days on which a service of a synthesized feed runs
+type Calendar struct {
+	Weekdays  gtfs.WeekdayFlag
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+// Options controlling the size and schedule of a synthesized feed
+type Options struct {
+	Routes        int              // Number of routes (defaults to 2)
+	TripsPerRoute int              // Number of trips on each route (defaults to 4)
+	StopsPerRoute int              // Number of stops on each route (defaults to 5)
+	RouteTypes    []gtfs.RouteType // Types of the routes in turn (defaults to buses)
+	Timezone      string           // Timezone of the agency (defaults to Australia/Perth)
+
+	// Services which trips are assigned to in turn (defaults to a single service running every day
+	// from the start of 2000 to the end of 2099)
+	Calendars []Calendar
+
+	FirstTrip    time.Duration // Time after the start of the service day of each route's first trip (defaults to 06:00)
+	Headway      time.Duration // Time between consecutive trips of a route (defaults to 30 minutes)
+	StopInterval time.Duration // Travel time between consecutive stops (defaults to 5 minutes)
+}
+
+// Returns the options with defaults filled in
+func (o Options) withDefaults() Options {
+	if o.Routes <= 0 {
+		o.Routes = defaultRoutes
+	}
+	if o.TripsPerRoute <= 0 {
+		o.TripsPerRoute = defaultTripsPerRoute
+	}
+	if o.StopsPerRoute < 2 {
+		o.StopsPerRoute = defaultStopsPerRoute
+	}
+	if len(o.RouteTypes) == 0 {
+		o.RouteTypes = []gtfs.RouteType{gtfs.BusRouteType}
+	}
+	if o.Timezone == "" {
+		o.Timezone = defaultTimezone
+	}
+	if len(o.Calendars) == 0 {
+		o.Calendars = []Calendar{{
+			Weekdays:  gtfs.MondayWeekdayFlag | gtfs.TuesdayWeekdayFlag | gtfs.WednesdayWeekdayFlag | gtfs.ThursdayWeekdayFlag | gtfs.FridayWeekdayFlag | gtfs.SaturdayWeekdayFlag | gtfs.SundayWeekdayFlag,
+			StartDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2099, 12, 31, 0, 0, 0, 0, time.UTC),
+		}}
+	}
+	if o.FirstTrip <= 0 {
+		o.FirstTrip = defaultFirstTrip
+	}
+	if o.Headway <= 0 {
+		o.Headway = defaultHeadway
+	}
+	if o.StopInterval <= 0 {
+		o.StopInterval = defaultStopInterval
+	}
+	return o
+}
+
+// Returns the ID of the route with the given index
+func RouteID(route int) gtfs.Key {
+	return gtfs.Key(fmt.Sprintf("R%d", route+1))
+}
+
+// Returns the ID of the stop with the given index on the route with the given index
+func StopID(route, stop int) gtfs.Key {
+	return gtfs.Key(fmt.Sprintf("R%d-S%d", route+1, stop+1))
+}
+
+// Returns the ID of the trip with the given index on the route with the given index
+func TripID(route, trip int) gtfs.Key {
+	return gtfs.Key(fmt.Sprintf("R%d-T%d", route+1, trip+1))
+}
+
+// Returns the ID of the service of the calendar with the given index
+func ServiceID(calendar int) gtfs.Key {
+	return gtfs.Key(fmt.Sprintf("C%d", calendar+1))
+}
+
+// Returns the ID of the shape of the route with the given index in the given direction
+func ShapeID(route int, dir gtfs.TripDirection) gtfs.Key {
+	if dir == gtfs.InboundTripDirection {
+		return gtfs.Key(fmt.Sprintf("R%d-in", route+1))
+	}
+	return gtfs.Key(fmt.Sprintf("R%d-out", route+1))
+}
+
+// Synthesizes a feed with the given options.
+// Trip i of each route departs at FirstTrip + i*Headway, runs outbound if i is even and inbound
+// otherwise, and belongs to the service of calendar i modulo the number of calendars.
+func NewFeed(opts Options) *gtfs.Feed {
+	opts = opts.withDefaults()
+
+	feed := &gtfs.Feed{
+		Agencies: gtfs.AgencyMap{
+			AgencyID: {
+				ID:       AgencyID,
+				Name:     "Test Transit",
+				URL:      "https://example.com",
+				Timezone: opts.Timezone,
+			},
+		},
+		Routes:            make(gtfs.RouteMap),
+		Services:          make(gtfs.ServiceMap),
+		ServiceExceptions: make(gtfs.ServiceExceptionMap),
+		Shapes:            make(gtfs.ShapeMap),
+		Stops:             make(gtfs.StopMap),
+		Trips:             make(gtfs.TripMap),
+		Extensions:        make(map[string]map[gtfs.Key][]byte),
+		Reports:           make(map[string]*gtfs.FileReport),
+	}
+
+	for i, calendar := range opts.Calendars {
+		id := ServiceID(i)
+		feed.Services[id] = &gtfs.Service{
+			ID:        id,
+			Weekdays:  calendar.Weekdays,
+			StartDate: calendar.StartDate,
+			EndDate:   calendar.EndDate,
+		}
+	}
+
+	for r := range opts.Routes {
+		routeID := RouteID(r)
+		routeType := opts.RouteTypes[r%len(opts.RouteTypes)]
+		feed.Routes[routeID] = &gtfs.Route{
+			ID:       routeID,
+			AgencyID: AgencyID,
+			Name:     fmt.Sprintf("%d", r+1),
+			Type:     routeType,
+			Colour:   "000000",
+		}
+
+		// Lay the route's stops out along a line of longitude
+		outbound := make(gtfs.CoordinateArray, opts.StopsPerRoute)
+		for s := range opts.StopsPerRoute {
+			stopID := StopID(r, s)
+			location := gtfs.NewCoordinate(
+				origin.Latitude-float64(s)*stopSpacing,
+				origin.Longitude+float64(r)*routeSpacing,
+			)
+			feed.Stops[stopID] = &gtfs.Stop{
+				ID:             stopID,
+				Code:           string(stopID),
+				Name:           fmt.Sprintf("Route %d Stop %d", r+1, s+1),
+				Location:       location,
+				LocationType:   gtfs.StopLocationType,
+				SupportedModes: routeType.Modes(),
+			}
+			outbound[s] = location
+		}
+		inbound := make(gtfs.CoordinateArray, len(outbound))
+		for s, location := range outbound {
+			inbound[len(outbound)-1-s] = location
+		}
+		feed.Shapes[ShapeID(r, gtfs.OutboundTripDirection)] = &gtfs.Shape{ID: ShapeID(r, gtfs.OutboundTripDirection), Coordinates: outbound}
+		feed.Shapes[ShapeID(r, gtfs.InboundTripDirection)] = &gtfs.Shape{ID: ShapeID(r, gtfs.InboundTripDirection), Coordinates: inbound}
+
+		for t := range opts.TripsPerRoute {
+			tripID := TripID(r, t)
+			dir := gtfs.TripDirection(t%2 == 1)
+
+			start := opts.FirstTrip + time.Duration(t)*opts.Headway
+			stops := make(gtfs.TripStopArray, opts.StopsPerRoute)
+			for s := range opts.StopsPerRoute {
+				stopIndex := s
+				if dir == gtfs.InboundTripDirection {
+					stopIndex = opts.StopsPerRoute - 1 - s
+				}
+				at := uint((start + time.Duration(s)*opts.StopInterval).Seconds())
+				stops[s] = &gtfs.TripStop{
+					StopID:        StopID(r, stopIndex),
+					ArrivalTime:   at,
+					DepartureTime: at,
+					Timepoint:     gtfs.ExactTripTimepoint,
+				}
+			}
+
+			headsign := feed.Stops[stops[len(stops)-1].StopID].Name
+			feed.Trips[tripID] = &gtfs.Trip{
+				ID:        tripID,
+				RouteID:   routeID,
+				ServiceID: ServiceID(t % len(opts.Calendars)),
+				ShapeID:   ShapeID(r, dir),
+				Direction: dir,
+				Headsign:  headsign,
+				Stops:     stops,
+			}
+		}
+	}
+
+	return feed
+}
+
+// Synthesizes a feed with the given options and returns it loaded into a database in a temporary
+// directory of the test, which is closed when the test finishes. The test fails if the database
+// cannot be created.
+func New(t testing.TB, opts Options) *gtfs.GTFS {
+	t.Helper()
+
+	g := &gtfs.GTFS{}
+	err := g.FromFeed(NewFeed(opts), filepath.Join(t.TempDir(), "gtfs.db"), gtfs.IngestOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create test GTFS database: %v", err)
+	}
+	t.Cleanup(func() {
+		g.Close()
+	})
+	return g
+}
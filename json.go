@@ -0,0 +1,227 @@
+package gtfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Names of enum values in JSON
+var (
+	routeTypeNames = map[RouteType]string{
+		TramRouteType:       "tram",
+		SubwayRouteType:     "subway",
+		RailRouteType:       "rail",
+		BusRouteType:        "bus",
+		FerryRouteType:      "ferry",
+		CableCarRouteType:   "cable_car",
+		GondolaRouteType:    "gondola",
+		FunicularRouteType:  "funicular",
+		TrolleybusRouteType: "trolleybus",
+		MonorailRouteType:   "monorail",
+	}
+	locationTypeNames = map[LocationType]string{
+		StopLocationType:         "stop",
+		StationLocationType:      "station",
+		EntranceExitLocationType: "entrance_exit",
+		GenericNodeLocationType:  "generic_node",
+		BoardingAreaLocationType: "boarding_area",
+		UnknownLocationType:      "unknown",
+	}
+	wheelchairBoardingNames = map[WheelchairBoarding]string{
+		UnknownWheelchairBoarding:      "unknown",
+		AccessibleWheelchairBoarding:   "accessible",
+		InaccessibleWheelchairBoarding: "inaccessible",
+	}
+	tripDirectionNames = map[TripDirection]string{
+		OutboundTripDirection: "outbound",
+		InboundTripDirection:  "inbound",
+	}
+	tripTimepointNames = map[TripTimepoint]string{
+		ApproximateTripTimepoint: "approximate",
+		ExactTripTimepoint:       "exact",
+	}
+	exceptionTypeNames = map[ExceptionType]string{
+		AddedExceptionType:   "added",
+		RemovedExceptionType: "removed",
+	}
+	searchResultTypeNames = map[SearchResultType]string{
+		RouteSearchResultType:  "route",
+		StopSearchResultType:   "stop",
+		AgencySearchResultType: "agency",
+	}
+)
+
+// Names of flag values in JSON, in the order they are listed
+var (
+	modeFlagNames = []flagName[ModeFlag]{
+		{BusModeFlag, "bus"},
+		{SchoolBusModeFlag, "school_bus"},
+		{RailModeFlag, "rail"},
+		{FerryModeFlag, "ferry"},
+	}
+	weekdayFlagNames = []flagName[WeekdayFlag]{
+		{MondayWeekdayFlag, "monday"},
+		{TuesdayWeekdayFlag, "tuesday"},
+		{WednesdayWeekdayFlag, "wednesday"},
+		{ThursdayWeekdayFlag, "thursday"},
+		{FridayWeekdayFlag, "friday"},
+		{SaturdayWeekdayFlag, "saturday"},
+		{SundayWeekdayFlag, "sunday"},
+	}
+)
+
+// A single flag of a bitmask and its name
+type flagName[T ~uint8] struct {
+	flag T
+	name string
+}
+
+// Returns the name of an enum value, or its number if it has no name
+func marshalEnum[T ~uint8](v T, names map[T]string) ([]byte, error) {
+	if name, ok := names[v]; ok {
+		return []byte(name), nil
+	}
+	return []byte(strconv.Itoa(int(v))), nil
+}
+
+// Sets an enum value from its name or number
+func unmarshalEnum[T ~uint8](v *T, text []byte, names map[T]string) error {
+	for value, name := range names {
+		if name == string(text) {
+			*v = value
+			return nil
+		}
+	}
+	n, err := strconv.ParseUint(string(text), 10, 8)
+	if err != nil {
+		return fmt.Errorf("unknown value: %q", text)
+	}
+	*v = T(n)
+	return nil
+}
+
+// Returns the name of a two-valued enum value
+func marshalBoolEnum[T ~bool](v T, names map[T]string) ([]byte, error) {
+	return []byte(names[v]), nil
+}
+
+// Sets a two-valued enum value from its name
+func unmarshalBoolEnum[T ~bool](v *T, text []byte, names map[T]string) error {
+	for value, name := range names {
+		if name == string(text) {
+			*v = value
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown value: %q", text)
+}
+
+// Returns the names of the flags set in a bitmask as a JSON array
+func marshalFlags[T ~uint8](v T, names []flagName[T]) ([]byte, error) {
+	set := []string{}
+	for _, fn := range names {
+		if v&fn.flag != 0 {
+			set = append(set, fn.name)
+		}
+	}
+	return json.Marshal(set)
+}
+
+// Sets a bitmask from a JSON array of flag names
+func unmarshalFlags[T ~uint8](v *T, data []byte, names []flagName[T]) error {
+	var set []string
+	err := json.Unmarshal(data, &set)
+	if err != nil {
+		return err
+	}
+
+	var flags T
+	for _, name := range set {
+		found := false
+		for _, fn := range names {
+			if fn.name == name {
+				flags |= fn.flag
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown flag: %q", name)
+		}
+	}
+	*v = flags
+	return nil
+}
+
+func (t RouteType) MarshalText() ([]byte, error) {
+	return marshalEnum(t, routeTypeNames)
+}
+
+func (t *RouteType) UnmarshalText(text []byte) error {
+	return unmarshalEnum(t, text, routeTypeNames)
+}
+
+func (t LocationType) MarshalText() ([]byte, error) {
+	return marshalEnum(t, locationTypeNames)
+}
+
+func (t *LocationType) UnmarshalText(text []byte) error {
+	return unmarshalEnum(t, text, locationTypeNames)
+}
+
+func (w WheelchairBoarding) MarshalText() ([]byte, error) {
+	return marshalEnum(w, wheelchairBoardingNames)
+}
+
+func (w *WheelchairBoarding) UnmarshalText(text []byte) error {
+	return unmarshalEnum(w, text, wheelchairBoardingNames)
+}
+
+func (d TripDirection) MarshalText() ([]byte, error) {
+	return marshalBoolEnum(d, tripDirectionNames)
+}
+
+func (d *TripDirection) UnmarshalText(text []byte) error {
+	return unmarshalBoolEnum(d, text, tripDirectionNames)
+}
+
+func (t TripTimepoint) MarshalText() ([]byte, error) {
+	return marshalBoolEnum(t, tripTimepointNames)
+}
+
+func (t *TripTimepoint) UnmarshalText(text []byte) error {
+	return unmarshalBoolEnum(t, text, tripTimepointNames)
+}
+
+func (t ExceptionType) MarshalText() ([]byte, error) {
+	return marshalBoolEnum(t, exceptionTypeNames)
+}
+
+func (t *ExceptionType) UnmarshalText(text []byte) error {
+	return unmarshalBoolEnum(t, text, exceptionTypeNames)
+}
+
+func (t SearchResultType) MarshalText() ([]byte, error) {
+	return marshalEnum(t, searchResultTypeNames)
+}
+
+func (t *SearchResultType) UnmarshalText(text []byte) error {
+	return unmarshalEnum(t, text, searchResultTypeNames)
+}
+
+func (m ModeFlag) MarshalJSON() ([]byte, error) {
+	return marshalFlags(m, modeFlagNames)
+}
+
+func (m *ModeFlag) UnmarshalJSON(data []byte) error {
+	return unmarshalFlags(m, data, modeFlagNames)
+}
+
+func (w WeekdayFlag) MarshalJSON() ([]byte, error) {
+	return marshalFlags(w, weekdayFlagNames)
+}
+
+func (w *WeekdayFlag) UnmarshalJSON(data []byte) error {
+	return unmarshalFlags(w, data, weekdayFlagNames)
+}
@@ -0,0 +1,216 @@
+package gtfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Returns a key joining the parts, each prefixed by its length as 4 bytes (big-endian), so that no two
+// sequences of parts encode to the same key. The key of some leading parts is a prefix of the keys which
+// extend them, so a cursor can seek to every key sharing those parts.
+func compositeKey(parts ...[]byte) []byte {
+	size := 0
+	for _, part := range parts {
+		size += lenBytes + len(part)
+	}
+	key := make([]byte, 0, size)
+	for _, part := range parts {
+		key = binary.BigEndian.AppendUint32(key, uint32(len(part)))
+		key = append(key, part...)
+	}
+	return key
+}
+
+// Split a key built by compositeKey into its parts
+func splitCompositeKey(key []byte) ([][]byte, error) {
+	var parts [][]byte
+	for len(key) > 0 {
+		if len(key) < lenBytes {
+			return nil, errors.New("composite key truncated")
+		}
+		size := binary.BigEndian.Uint32(key)
+		key = key[lenBytes:]
+		if uint32(len(key)) < size {
+			return nil, errors.New("composite key truncated")
+		}
+		parts = append(parts, key[:size])
+		key = key[size:]
+	}
+	return parts, nil
+}
+
+// Returns the date part of a key, formatted as YYYYMMDD
+func dateKeyPart(date time.Time) []byte {
+	return []byte(date.Format("20060102"))
+}
+
+// Returns the key of a service's exception on a date in the serviceExceptions bucket
+func serviceExceptionKey(serviceID Key, date time.Time) []byte {
+	return compositeKey([]byte(serviceID), dateKeyPart(date))
+}
+
+// Migrations of databases to the version following their own, by version
+var migrations = map[int]func(tx *bolt.Tx) error{
+	17: migrateCompositeKeys,
+	18: migrateOptionalTripFields,
+	19: migrateOptionalTripFields,
+	20: migrateTripStopIndex,
+}
+
+// Migrate the database file to the current version in place, running the migration from each version to
+// the next. The database must not be open elsewhere. Databases too old to be migrated must be rebuilt.
+func MigrateDB(dbFile string, opts DBOptions) error {
+	db, err := bolt.Open(dbFile, opts.fileMode(), opts.boltOptions(false))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("metadata"))
+		if b == nil {
+			return errors.New("metadata bucket not found")
+		}
+		version, err := strconv.Atoi(string(b.Get([]byte("version"))))
+		if err != nil {
+			return err
+		}
+		if version > CurrentVersion {
+			return errors.New("GTFS database version " + strconv.Itoa(version) + " is newer than " + strconv.Itoa(CurrentVersion))
+		}
+
+		for ; version < CurrentVersion; version++ {
+			migrate, ok := migrations[version]
+			if !ok {
+				return errors.New("no migration from GTFS database version " + strconv.Itoa(version))
+			}
+			err = migrate(tx)
+			if err != nil {
+				return err
+			}
+		}
+		return b.Put([]byte("version"), []byte(strconv.Itoa(CurrentVersion)))
+	})
+}
+
+// Rewrite the keys of the bucket with the given function, if the bucket exists
+func rewriteBucketKeys(tx *bolt.Tx, bucket string, rewrite func(k []byte) ([]byte, error)) error {
+	b := tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+
+	// Values are only valid within the transaction while the bucket exists, so they are copied
+	var keys, values [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		key, err := rewrite(k)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		values = append(values, append([]byte(nil), v...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tx.DeleteBucket([]byte(bucket))
+	if err != nil {
+		return err
+	}
+	b, err = tx.CreateBucket([]byte(bucket))
+	if err != nil {
+		return err
+	}
+	for i, key := range keys {
+		err = b.Put(key, values[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Migrate from version 17, where the keys of the serviceExceptions, tripsByRouteDirectionIndex and
+// tripsByStartIndex buckets joined their parts by concatenation, to composite keys
+func migrateCompositeKeys(tx *bolt.Tx) error {
+	// Service ID followed by the date as YYYYMMDD
+	err := rewriteBucketKeys(tx, "serviceExceptions", func(k []byte) ([]byte, error) {
+		if len(k) < 8 {
+			return nil, errors.New("invalid service exception key")
+		}
+		return compositeKey(k[:len(k)-8], k[len(k)-8:]), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Route ID followed by a zero byte and the direction
+	err = rewriteBucketKeys(tx, "tripsByRouteDirectionIndex", func(k []byte) ([]byte, error) {
+		if len(k) < 2 {
+			return nil, errors.New("invalid route direction key")
+		}
+		return compositeKey(k[:len(k)-2], k[len(k)-1:]), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Service ID followed by a zero byte and the start time bucket as 2 bytes
+	return rewriteBucketKeys(tx, "tripsByStartIndex", func(k []byte) ([]byte, error) {
+		if len(k) < 3 {
+			return nil, errors.New("invalid trip start key")
+		}
+		return compositeKey(k[:len(k)-3], k[len(k)-2:]), nil
+	})
+}
+
+// Migrate from versions 18 and 19, before trips and their stops gained optional trailing fields (occupancy
+// and capacity in version 19, and stop sequences in version 20). Values without them decode as unknown, so
+// nothing needs rewriting.
+func migrateOptionalTripFields(tx *bolt.Tx) error {
+	return nil
+}
+
+// Migrate from version 20, before the tripsByStopIndex bucket listed the trips visiting each stop
+func migrateTripStopIndex(tx *bolt.Tx) error {
+	// Databases created before the encoding was recorded always use the binary format
+	encoding := BinaryEncoding
+	if data := tx.Bucket([]byte("metadata")).Get([]byte("encoding")); data != nil {
+		var err error
+		encoding, err = ParseEncoding(string(data))
+		if err != nil {
+			return err
+		}
+	}
+
+	b := tx.Bucket([]byte("trips"))
+	if b == nil {
+		return errors.New("bucket not found")
+	}
+	trips := make(TripMap)
+	tripIDs := make(map[Key]uint32)
+	err := b.ForEach(func(k, v []byte) error {
+		trip := &Trip{}
+		err := decodeEntity(trip, Key(k), v, encoding)
+		if err != nil {
+			return err
+		}
+		id, err := numericID(tx, TripEntityType, trip.ID)
+		if err != nil {
+			return err
+		}
+		trips[trip.ID] = trip
+		tripIDs[trip.ID] = id
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return populateTripStopIndex(tx, trips, tripIDs)
+}
@@ -0,0 +1,540 @@
+package gtfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// A GTFS feed to be combined with others by Merge
+type Source struct {
+	URL    string // URL of the feed's zip archive, used if Path is empty
+	Path   string // Path of a local copy of the feed's zip archive
+	Prefix string // Prefix added to every ID in the feed, to keep them distinct from other feeds' IDs
+}
+
+// Returns the location of the source, for logging and errors
+func (s Source) String() string {
+	if s.Path != "" {
+		return s.Path
+	}
+	return s.URL
+}
+
+// Ingests several GTFS feeds into a single database, so they can be queried together.
+// Each feed's IDs are prefixed with its source's prefix. Agencies with the same name, URL and timezone,
+// and stops with the same name, type and location, are merged into the one from the earliest feed, as feeds
+// of neighbouring networks often share interchange stops; services are merged if their calendars are identical.
+// Agencies, stops and services whose IDs are taken by a different entity from an earlier feed are renamed with
+// a numeric suffix. Conflicting route, shape and trip IDs are an error, and should be avoided with prefixes.
+// The IDs of extension entities are prefixed, but references within them are not rewritten.
+func Merge(dbFile string, sources ...Source) error {
+	_, err := mergeSources(sources, dbFile, IngestOptions{})
+	return err
+}
+
+// Construct a new GTFS database from several feeds, such as an agency's separate feeds for each mode,
+// using the given ingest options. The feeds are downloaded and parsed concurrently, then merged in order
+// as with Merge. The report's files are keyed by the source and file name, e.g. "bus.zip/stops.txt",
+// and as with FromURLReport it is returned even if the ingest fails.
+func (g *GTFS) FromSources(sources []Source, dbFile string, opts IngestOptions) (*IngestReport, error) {
+	report, err := mergeSources(sources, dbFile, opts)
+	if err != nil {
+		return report, err
+	}
+	return report, g.FromDBWithOptions(dbFile, opts.DB)
+}
+
+// Load the sources concurrently and merge them into a new database
+func mergeSources(sources []Source, dbFile string, opts IngestOptions) (*IngestReport, error) {
+	report := &IngestReport{
+		Files:  make(map[string]*FileReport),
+		Phases: []PhaseTiming{},
+	}
+	if len(sources) == 0 {
+		return report, errors.New("no sources to merge")
+	}
+
+	// Download or read every source's zip archive
+	start := time.Now()
+	zips := make([][]byte, len(sources))
+	var group errgroup.Group
+	for i, source := range sources {
+		group.Go(func() error {
+			log.Infof("Loading GTFS data from %s", source)
+			zipBytes, err := readSource(source)
+			if err != nil {
+				return fmt.Errorf("%s: %w", source, err)
+			}
+			zips[i] = zipBytes
+			return nil
+		})
+	}
+	err := group.Wait()
+	if err != nil {
+		return report, err
+	}
+	report.timePhase("download", start)
+
+	// Parse every source's feed
+	start = time.Now()
+	feeds := make([]*Feed, len(sources))
+	for i, source := range sources {
+		group.Go(func() error {
+			feed, err := parseSource(zips[i], opts)
+			if feed != nil {
+				feeds[i] = feed
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", source, err)
+			}
+			log.Debugf("Loaded GTFS data from %s: %s", source, feed)
+			return nil
+		})
+	}
+	err = group.Wait()
+	for i, feed := range feeds {
+		if feed == nil {
+			continue
+		}
+		for file, fileReport := range feed.Reports {
+			report.Files[sources[i].String()+"/"+file] = fileReport
+		}
+	}
+	report.timePhase("parse", start)
+	if err != nil {
+		return report, err
+	}
+
+	merged := &Feed{
+		Agencies:          make(AgencyMap),
+		Routes:            make(RouteMap),
+		Services:          make(ServiceMap),
+		ServiceExceptions: make(ServiceExceptionMap),
+		Shapes:            make(ShapeMap),
+		Stops:             make(StopMap),
+		Trips:             make(TripMap),
+		Transfers:         TransferArray{},
+		Extensions:        make(map[string]map[Key][]byte),
+		Reports:           make(map[string]*FileReport),
+	}
+
+	start = time.Now()
+	m := newFeedMerger(merged)
+	for i, source := range sources {
+		prefixFeedIDs(feeds[i], source.Prefix)
+		err = m.add(feeds[i])
+		if err != nil {
+			return report, fmt.Errorf("%s: %w", source, err)
+		}
+	}
+
+	// Queries use a single timezone for the whole database
+	timezones := make(map[string]bool)
+	for _, agency := range merged.Agencies {
+		timezones[agency.Timezone] = true
+	}
+	if len(timezones) > 1 {
+		log.Warnf("Merged agencies have %d different timezones", len(timezones))
+	}
+
+	log.Debugf("Merged GTFS data from %d sources: %s", len(sources), merged)
+	report.timePhase("merge", start)
+
+	err = prepareFeed(merged, opts, report)
+	if err != nil {
+		return report, err
+	}
+	return report, writeFeedDB(dbFile, opts, merged, report)
+}
+
+// Download or read the source's zip archive
+func readSource(source Source) ([]byte, error) {
+	if source.Path != "" {
+		return os.ReadFile(source.Path)
+	}
+	return downloadFeed(source.URL)
+}
+
+// Parse a source's zip archive. If partial feeds are allowed, a feed is returned alongside any parse error.
+func parseSource(zipBytes []byte, opts IngestOptions) (*Feed, error) {
+	readers, closeFiles, err := openFeedZip(zipBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFiles()
+
+	err = checkRequiredFiles(readers, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	feed, err := ParseFeedWithOptions(readers, opts.Parse)
+	if err != nil {
+		if !opts.AllowPartial {
+			return feed, err
+		}
+		log.Warnf("Continuing with partially parsed GTFS data: %v", err)
+	}
+	return feed, nil
+}
+
+// Add the prefix to every ID in the feed, and to every reference to one
+func prefixFeedIDs(feed *Feed, prefix string) {
+	if prefix == "" {
+		return
+	}
+	p := func(id Key) Key {
+		if id == "" {
+			return id
+		}
+		return Key(prefix) + id
+	}
+
+	agencies := make(AgencyMap, len(feed.Agencies))
+	for _, agency := range feed.Agencies {
+		agency.ID = p(agency.ID)
+		agencies[agency.ID] = agency
+	}
+	feed.Agencies = agencies
+
+	routes := make(RouteMap, len(feed.Routes))
+	for _, route := range feed.Routes {
+		route.ID = p(route.ID)
+		route.AgencyID = p(route.AgencyID)
+		routes[route.ID] = route
+	}
+	feed.Routes = routes
+
+	services := make(ServiceMap, len(feed.Services))
+	for _, service := range feed.Services {
+		service.ID = p(service.ID)
+		services[service.ID] = service
+	}
+	feed.Services = services
+
+	exceptions := make(ServiceExceptionMap, len(feed.ServiceExceptions))
+	for _, exception := range feed.ServiceExceptions {
+		exception.ServiceID = p(exception.ServiceID)
+		exceptions[ServiceExceptionKey{ServiceID: exception.ServiceID, Date: exception.Date}] = exception
+	}
+	feed.ServiceExceptions = exceptions
+
+	shapes := make(ShapeMap, len(feed.Shapes))
+	for _, shape := range feed.Shapes {
+		shape.ID = p(shape.ID)
+		shapes[shape.ID] = shape
+	}
+	feed.Shapes = shapes
+
+	stops := make(StopMap, len(feed.Stops))
+	for _, stop := range feed.Stops {
+		stop.ID = p(stop.ID)
+		stop.ParentID = p(stop.ParentID)
+		stop.ZoneID = p(stop.ZoneID)
+		stops[stop.ID] = stop
+	}
+	feed.Stops = stops
+
+	trips := make(TripMap, len(feed.Trips))
+	for _, trip := range feed.Trips {
+		trip.ID = p(trip.ID)
+		trip.RouteID = p(trip.RouteID)
+		trip.ServiceID = p(trip.ServiceID)
+		trip.ShapeID = p(trip.ShapeID)
+		for _, stop := range trip.Stops {
+			stop.StopID = p(stop.StopID)
+		}
+		trips[trip.ID] = trip
+	}
+	feed.Trips = trips
+
+	for i := range feed.Transfers {
+		feed.Transfers[i].FromStopID = p(feed.Transfers[i].FromStopID)
+		feed.Transfers[i].ToStopID = p(feed.Transfers[i].ToStopID)
+	}
+
+	for filename, entities := range feed.Extensions {
+		prefixed := make(map[Key][]byte, len(entities))
+		for id, data := range entities {
+			prefixed[p(id)] = data
+		}
+		feed.Extensions[filename] = prefixed
+	}
+}
+
+// Combines feeds into a single feed, merging identical agencies and stops
+type feedMerger struct {
+	merged     *Feed
+	agencies   map[string]Key              // Merged agency IDs by name, URL and timezone
+	stops      map[string]Key              // Merged stop IDs by name, type and location
+	exceptions map[Key][]*ServiceException // Merged service exceptions by service ID
+}
+
+func newFeedMerger(merged *Feed) *feedMerger {
+	return &feedMerger{
+		merged:     merged,
+		agencies:   make(map[string]Key),
+		stops:      make(map[string]Key),
+		exceptions: make(map[Key][]*ServiceException),
+	}
+}
+
+// Returns the key identifying agencies which are the same across feeds
+func agencyMergeKey(agency *Agency) string {
+	return agency.Name + "\x00" + agency.URL + "\x00" + agency.Timezone
+}
+
+// Returns the key identifying stops which are the same across feeds, with locations rounded to about 10cm
+func stopMergeKey(stop *Stop) string {
+	lat := strconv.FormatFloat(stop.Location.Latitude, 'f', 6, 64)
+	lon := strconv.FormatFloat(stop.Location.Longitude, 'f', 6, 64)
+	return stop.Name + "\x00" + strconv.Itoa(int(stop.LocationType)) + "\x00" + stop.PlatformCode + "\x00" + lat + "\x00" + lon
+}
+
+// Returns the feed's entity IDs in order, so merging is deterministic
+func sortedIDs[T any](entities map[Key]T) []Key {
+	ids := make([]Key, 0, len(entities))
+	for id := range entities {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// Add a feed to the merged feed
+func (m *feedMerger) add(feed *Feed) error {
+	agencyIDs := m.mergeAgencies(feed)
+	stopIDs := m.mergeStops(feed)
+	serviceIDs := m.mergeServices(feed)
+
+	for _, route := range feed.Routes {
+		if id, ok := agencyIDs[route.AgencyID]; ok {
+			route.AgencyID = id
+		}
+		if _, exists := m.merged.Routes[route.ID]; exists {
+			return errors.New("conflicting route ID: " + string(route.ID))
+		}
+		m.merged.Routes[route.ID] = route
+	}
+
+	for _, shape := range feed.Shapes {
+		if _, exists := m.merged.Shapes[shape.ID]; exists {
+			return errors.New("conflicting shape ID: " + string(shape.ID))
+		}
+		m.merged.Shapes[shape.ID] = shape
+	}
+
+	for _, trip := range feed.Trips {
+		if id, ok := serviceIDs[trip.ServiceID]; ok {
+			trip.ServiceID = id
+		}
+		for _, stop := range trip.Stops {
+			if id, ok := stopIDs[stop.StopID]; ok {
+				stop.StopID = id
+			}
+		}
+		if _, exists := m.merged.Trips[trip.ID]; exists {
+			return errors.New("conflicting trip ID: " + string(trip.ID))
+		}
+		m.merged.Trips[trip.ID] = trip
+	}
+
+	for _, transfer := range feed.Transfers {
+		if id, ok := stopIDs[transfer.FromStopID]; ok {
+			transfer.FromStopID = id
+		}
+		if id, ok := stopIDs[transfer.ToStopID]; ok {
+			transfer.ToStopID = id
+		}
+		m.merged.Transfers = append(m.merged.Transfers, transfer)
+	}
+
+	for filename, entities := range feed.Extensions {
+		if _, ok := m.merged.Extensions[filename]; !ok {
+			m.merged.Extensions[filename] = make(map[Key][]byte)
+		}
+		for id, data := range entities {
+			m.merged.Extensions[filename][id] = data
+		}
+	}
+	return nil
+}
+
+// Add the feed's agencies, returning the IDs of those merged into an existing agency or renamed.
+// Agencies are only merged with those of earlier feeds.
+func (m *feedMerger) mergeAgencies(feed *Feed) map[Key]Key {
+	replaced := make(map[Key]Key)
+	added := make(map[string]Key)
+	for _, id := range sortedIDs(feed.Agencies) {
+		agency := feed.Agencies[id]
+		key := agencyMergeKey(agency)
+		if existingID, ok := m.agencies[key]; ok {
+			replaced[id] = existingID
+			continue
+		}
+
+		// Agencies with the same ID but different details are kept apart by renaming
+		newID := m.uniqueID(id, func(id Key) bool {
+			_, exists := m.merged.Agencies[id]
+			return exists
+		})
+		if newID != id {
+			replaced[id] = newID
+			agency.ID = newID
+		}
+		added[key] = newID
+		m.merged.Agencies[newID] = agency
+	}
+
+	for key, id := range added {
+		if _, exists := m.agencies[key]; !exists {
+			m.agencies[key] = id
+		}
+	}
+	return replaced
+}
+
+// Add the feed's stops, returning the IDs of those merged into an existing stop or renamed.
+// Stops are only merged with those of earlier feeds, so distinct stops of one feed are kept apart.
+func (m *feedMerger) mergeStops(feed *Feed) map[Key]Key {
+	replaced := make(map[Key]Key)
+	added := []*Stop{}
+	for _, id := range sortedIDs(feed.Stops) {
+		stop := feed.Stops[id]
+		key := stopMergeKey(stop)
+		if existingID, ok := m.stops[key]; ok {
+			replaced[id] = existingID
+			continue
+		}
+
+		newID := m.uniqueID(id, func(id Key) bool {
+			_, exists := m.merged.Stops[id]
+			return exists
+		})
+		if newID != id {
+			replaced[id] = newID
+			stop.ID = newID
+		}
+		m.merged.Stops[newID] = stop
+		added = append(added, stop)
+	}
+
+	for _, stop := range added {
+		if id, ok := replaced[stop.ParentID]; ok {
+			stop.ParentID = id
+		}
+		key := stopMergeKey(stop)
+		if _, exists := m.stops[key]; !exists {
+			m.stops[key] = stop.ID
+		}
+	}
+	return replaced
+}
+
+// Add the feed's services and their exceptions, returning the IDs of those merged into or renamed
+// from an existing service. Services with the same ID are merged only if their calendars are identical,
+// including services defined only by their exceptions.
+func (m *feedMerger) mergeServices(feed *Feed) map[Key]Key {
+	exceptionsByService := make(map[Key][]*ServiceException)
+	for _, exception := range feed.ServiceExceptions {
+		exceptionsByService[exception.ServiceID] = append(exceptionsByService[exception.ServiceID], exception)
+	}
+
+	// Services may be defined only by their exceptions in calendar_dates.txt
+	ids := sortedIDs(feed.Services)
+	for _, id := range sortedIDs(exceptionsByService) {
+		if _, ok := feed.Services[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+
+	replaced := make(map[Key]Key)
+	for _, id := range ids {
+		service := feed.Services[id] // Nil if the service is only defined by its exceptions
+		exceptions := exceptionsByService[id]
+
+		// Look for an identical service among those with the same ID, and otherwise take a free ID
+		newID := id
+		identical := false
+		for i := 2; ; i++ {
+			existing, exists := m.merged.Services[newID]
+			if !exists && len(m.exceptions[newID]) == 0 {
+				break
+			}
+			if sameCalendar(existing, service, m.exceptions[newID], exceptions) {
+				identical = true
+				break
+			}
+			newID = id + Key("#"+strconv.Itoa(i))
+		}
+		if identical {
+			if newID != id {
+				replaced[id] = newID
+			}
+			continue
+		}
+
+		if newID != id {
+			log.Warnf("Renamed service %s to %s, as its calendar conflicts with another source's", id, newID)
+			replaced[id] = newID
+		}
+		if service != nil {
+			service.ID = newID
+			m.merged.Services[newID] = service
+		}
+		for _, exception := range exceptions {
+			exception.ServiceID = newID
+			m.merged.ServiceExceptions[ServiceExceptionKey{ServiceID: newID, Date: exception.Date}] = exception
+		}
+		m.exceptions[newID] = exceptions
+	}
+	return replaced
+}
+
+// Returns the first of the ID and the ID with numeric suffixes which is not taken
+func (m *feedMerger) uniqueID(id Key, taken func(Key) bool) Key {
+	newID := id
+	for i := 2; taken(newID); i++ {
+		newID = id + Key("#"+strconv.Itoa(i))
+	}
+	return newID
+}
+
+// Check whether two services run on the same days, given their exceptions. Services defined only by their
+// exceptions are nil.
+func sameCalendar(a, b *Service, aExceptions, bExceptions []*ServiceException) bool {
+	if (a == nil) != (b == nil) {
+		return false
+	}
+	if a != nil && (a.Weekdays != b.Weekdays || !a.StartDate.Equal(b.StartDate) || !a.EndDate.Equal(b.EndDate)) {
+		return false
+	}
+	if len(aExceptions) != len(bExceptions) {
+		return false
+	}
+
+	sortExceptions := func(exceptions []*ServiceException) []*ServiceException {
+		sorted := slices.Clone(exceptions)
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i].Date.Before(sorted[j].Date)
+		})
+		return sorted
+	}
+	aSorted := sortExceptions(aExceptions)
+	bSorted := sortExceptions(bExceptions)
+	for i := range aSorted {
+		if !aSorted[i].Date.Equal(bSorted[i].Date) || aSorted[i].Type != bSorted[i].Type {
+			return false
+		}
+	}
+	return true
+}
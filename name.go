@@ -0,0 +1,62 @@
+package gtfs
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Abbreviations expanded to their full word when normalizing names
+var nameAbbreviations = map[string]string{
+	"stn":  "station",
+	"stns": "stations",
+	"sta":  "station",
+	"rly":  "railway",
+	"intg": "interchange",
+}
+
+// Split a name into lowercase alphanumeric words, expanding abbreviations
+func nameWords(name string) []string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		if expanded, ok := nameAbbreviations[word]; ok {
+			words[i] = expanded
+		}
+	}
+	return words
+}
+
+// Normalize a name for comparison, folding case, collapsing punctuation into single spaces and expanding
+// abbreviations, so that "Glendalough Stn" and "glendalough station" normalize to the same name
+func NormalizeName(name string) string {
+	return strings.Join(nameWords(name), " ")
+}
+
+// Returns the stop's name normalized for comparison
+func (s *Stop) NormalizedName() string {
+	return NormalizeName(s.Name)
+}
+
+// Returns all stops grouped by normalized name, with the stops of each group sorted by ID.
+// Stops whose names normalize to an empty string are omitted.
+func (g *GTFS) GetStopsGroupedByName() (map[string][]*Stop, error) {
+	stops, err := g.GetAllStops()
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]*Stop)
+	for _, stop := range stops {
+		name := stop.NormalizedName()
+		if name == "" {
+			continue
+		}
+		groups[name] = append(groups[name], stop)
+	}
+	for _, group := range groups {
+		sort.Slice(group, func(i, j int) bool { return group[i].ID < group[j].ID })
+	}
+	return groups, nil
+}
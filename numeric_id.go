@@ -0,0 +1,225 @@
+package gtfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Entities are assigned internal numeric IDs at ingest, in the order of their keys, and the mapping is stored
+// in a pair of buckets for each type of entity. Indexes store the numeric IDs in place of keys, as they are
+// smaller and faster to compare, and the journey planner uses them to index its arrays. The public API
+// remains keyed by strings.
+
+// Types of entity assigned numeric IDs
+var numericIDEntityTypes = []EntityType{
+	AgencyEntityType,
+	RouteEntityType,
+	ServiceEntityType,
+	ShapeEntityType,
+	StopEntityType,
+	TripEntityType,
+}
+
+// Returns the bucket mapping keys of the entity type to their numeric IDs
+func numericIDsBucket(t EntityType) string {
+	return entityBuckets[t] + "NumericIDs"
+}
+
+// Returns the bucket mapping numeric IDs of the entity type to their keys
+func numericKeysBucket(t EntityType) string {
+	return entityBuckets[t] + "NumericKeys"
+}
+
+// Encode a numeric ID as a bucket key, in big-endian order so that keys sort by ID
+func encodeNumericID(id uint32) []byte {
+	data := make([]byte, uint32Bytes)
+	binary.BigEndian.PutUint32(data, id)
+	return data
+}
+
+// Assign numeric IDs to the entities in the order of their keys
+func assignNumericIDs[T any](entities map[Key]T) map[Key]uint32 {
+	ids := make(map[Key]uint32, len(entities))
+	for i, id := range sortedIDs(entities) {
+		ids[id] = uint32(i)
+	}
+	return ids
+}
+
+// Store the numeric IDs of the entity type in its mapping buckets
+func populateNumericIDs(db *bolt.DB, t EntityType, ids map[Key]uint32) error {
+	return db.Batch(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(numericIDsBucket(t)))
+		if err != nil {
+			return err
+		}
+		b2, err := tx.CreateBucketIfNotExists([]byte(numericKeysBucket(t)))
+		if err != nil {
+			return err
+		}
+		for key, id := range ids {
+			err = b.Put([]byte(key), encodeNumericID(id))
+			if err != nil {
+				return err
+			}
+			err = b2.Put(encodeNumericID(id), []byte(key))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// An array of numeric IDs, as stored in indexes
+type numericIDArray []uint32
+
+// Encode the numericIDArray into a byte slice
+// Format:
+// - Count: 4 bytes (number of IDs)
+// - Each ID: 4 bytes (uint32)
+func (na numericIDArray) Encode() []byte {
+	data := make([]byte, lenBytes+len(na)*uint32Bytes)
+	binary.BigEndian.PutUint32(data, uint32(len(na)))
+	for i, id := range na {
+		binary.BigEndian.PutUint32(data[lenBytes+i*uint32Bytes:], id)
+	}
+	return data
+}
+
+// Decode the byte slice into the numericIDArray
+func (na *numericIDArray) Decode(data []byte) error {
+	if na == nil {
+		return errors.New("cannot decode into a nil numericIDArray")
+	}
+	if len(data) < lenBytes {
+		return errors.New("numeric ID buffer too small for count")
+	}
+	count := int(binary.BigEndian.Uint32(data))
+	if len(data) < lenBytes+count*uint32Bytes {
+		return fmt.Errorf("numeric ID buffer too small for %d IDs", count)
+	}
+
+	ids := make(numericIDArray, count)
+	for i := range ids {
+		ids[i] = binary.BigEndian.Uint32(data[lenBytes+i*uint32Bytes:])
+	}
+	*na = ids
+	return nil
+}
+
+// Returns the keys of the numeric IDs of the entity type, read from its mapping bucket
+func numericKeys(tx *bolt.Tx, t EntityType, ids numericIDArray) (KeyArray, error) {
+	b := tx.Bucket([]byte(numericKeysBucket(t)))
+	if b == nil {
+		return nil, errors.New("bucket not found")
+	}
+
+	keys := make(KeyArray, len(ids))
+	for i, id := range ids {
+		key := b.Get(encodeNumericID(id))
+		if key == nil {
+			return nil, fmt.Errorf("numeric ID %d not found", id)
+		}
+		keys[i] = Key(key)
+	}
+	return keys, nil
+}
+
+// Numeric IDs of every entity of a type, in both directions
+type numericIDTable struct {
+	ids  map[Key]uint32
+	keys []Key // Indexed by numeric ID
+}
+
+// Numeric ID tables of each entity type, loaded on first use
+type numericIDCache struct {
+	mu     sync.Mutex
+	tables map[EntityType]*numericIDTable
+}
+
+// Returns the numeric IDs of every entity of the type, loading them on first use
+func (g *GTFS) getNumericIDTable(t EntityType) (*numericIDTable, error) {
+	if g.numericIDs == nil {
+		return nil, errors.New("database not open")
+	}
+
+	g.numericIDs.mu.Lock()
+	defer g.numericIDs.mu.Unlock()
+	if table, ok := g.numericIDs.tables[t]; ok {
+		return table, nil
+	}
+
+	table := &numericIDTable{}
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(numericKeysBucket(t)))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+
+		n := b.Stats().KeyN
+		table.ids = make(map[Key]uint32, n)
+		table.keys = make([]Key, n)
+		return b.ForEach(func(k, v []byte) error {
+			id := binary.BigEndian.Uint32(k)
+			if int(id) >= len(table.keys) {
+				return fmt.Errorf("numeric ID %d out of range", id)
+			}
+			table.ids[Key(v)] = id
+			table.keys[id] = Key(v)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if g.numericIDs.tables == nil {
+		g.numericIDs.tables = make(map[EntityType]*numericIDTable)
+	}
+	g.numericIDs.tables[t] = table
+	return table, nil
+}
+
+// Numeric IDs used by a single computation, extending a table with IDs for entities it does not
+// contain, such as those added by overrides or service changes
+type localNumericIDs struct {
+	table *numericIDTable
+	extra map[Key]uint32
+	keys  []Key // Keys of the extra IDs, which follow the table's
+}
+
+func newLocalNumericIDs(table *numericIDTable) *localNumericIDs {
+	return &localNumericIDs{table: table, extra: make(map[Key]uint32)}
+}
+
+// Returns the numeric ID of the key, assigning one if it has none
+func (l *localNumericIDs) id(key Key) uint32 {
+	if id, ok := l.table.ids[key]; ok {
+		return id
+	}
+	if id, ok := l.extra[key]; ok {
+		return id
+	}
+	id := uint32(len(l.table.keys) + len(l.keys))
+	l.extra[key] = id
+	l.keys = append(l.keys, key)
+	return id
+}
+
+// Returns the key of the numeric ID
+func (l *localNumericIDs) key(id uint32) Key {
+	if int(id) < len(l.table.keys) {
+		return l.table.keys[id]
+	}
+	return l.keys[int(id)-len(l.table.keys)]
+}
+
+// Returns the number of IDs assigned
+func (l *localNumericIDs) len() int {
+	return len(l.table.keys) + len(l.keys)
+}
@@ -0,0 +1,403 @@
+package gtfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Suffix of the file holding the overrides layer of a database file
+const overridesFileSuffix = ".overrides"
+
+// How long to wait for a lock on the overrides file
+const overridesLockTimeout = time.Second
+
+// Markers prefixing the stored value of each override
+const (
+	suppressedOverride byte = iota
+	replacedOverride
+)
+
+// Entity types which can be overridden. Service exceptions can only be added, keyed by serviceExceptionKey.
+var overridableEntities = []EntityType{RouteEntityType, StopEntityType, TripEntityType, ServiceExceptionEntityType}
+
+// An edit made on top of the ingested data: either a replacement entity, or a suppression
+type override struct {
+	suppressed bool
+	data       []byte // Binary encoding of the replacement entity
+}
+
+// Overrides layered on top of a database, keyed by entity type and ID. Overrides are persisted to a
+// separate file alongside the database, which is only opened while they are loaded or changed.
+type overrideLayer struct {
+	mu      sync.RWMutex
+	entries map[EntityType]map[Key]override
+}
+
+// An entity which can be decoded into from a pointer to it
+type decodablePtr[T any] interface {
+	*T
+	decodable
+}
+
+// Returns the file holding the overrides layer of the given database file
+func overridesFile(dbFile string) string {
+	return dbFile + overridesFileSuffix
+}
+
+// Loads the overrides layer of the given database file, which is empty if it has none
+func loadOverrides(dbFile string, opts DBOptions) (*overrideLayer, error) {
+	layer := &overrideLayer{entries: make(map[EntityType]map[Key]override)}
+
+	path := overridesFile(dbFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return layer, nil
+	}
+
+	db, err := bolt.Open(path, opts.fileMode(), opts.sideFileOptions(true, overridesLockTimeout))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		for _, entityType := range overridableEntities {
+			b := tx.Bucket([]byte(entityBuckets[entityType]))
+			if b == nil {
+				continue
+			}
+			err := b.ForEach(func(k, v []byte) error {
+				if len(v) == 0 {
+					return errors.New("invalid override for " + string(k))
+				}
+				layer.set(entityType, Key(k), override{
+					suppressed: v[0] == suppressedOverride,
+					data:       append([]byte{}, v[1:]...),
+				})
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return layer, nil
+}
+
+// Sets an override in memory
+func (l *overrideLayer) set(t EntityType, id Key, o override) {
+	if l.entries[t] == nil {
+		l.entries[t] = make(map[Key]override)
+	}
+	l.entries[t][id] = o
+}
+
+// Returns the override for the entity, if any
+func (l *overrideLayer) get(t EntityType, id Key) (override, bool) {
+	if l == nil {
+		return override{}, false
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	o, ok := l.entries[t][id]
+	return o, ok
+}
+
+// Returns a copy of the overrides for the entity type
+func (l *overrideLayer) all(t EntityType) map[Key]override {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if len(l.entries[t]) == 0 {
+		return nil
+	}
+	entries := make(map[Key]override, len(l.entries[t]))
+	for id, o := range l.entries[t] {
+		entries[id] = o
+	}
+	return entries
+}
+
+// Persists an override (or its removal, if o is nil) and applies it to the layer
+func (g *GTFS) writeOverride(t EntityType, id Key, o *override) error {
+	return g.writeOverrides(t, map[Key]*override{id: o})
+}
+
+// Persists overrides (or their removal, for nil overrides) in a single transaction and applies them to the layer
+func (g *GTFS) writeOverrides(t EntityType, overrides map[Key]*override) error {
+	if g.filePath == "" {
+		return errors.New("database not open")
+	}
+	if g.overrides == nil {
+		return errors.New("overrides not loaded")
+	}
+
+	db, err := bolt.Open(overridesFile(g.filePath), g.dbOptions.fileMode(), g.dbOptions.sideFileOptions(false, overridesLockTimeout))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(entityBuckets[t]))
+		if err != nil {
+			return err
+		}
+		for id, o := range overrides {
+			if o == nil {
+				err = b.Delete([]byte(id))
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			marker := replacedOverride
+			if o.suppressed {
+				marker = suppressedOverride
+			}
+			err = b.Put([]byte(id), append([]byte{marker}, o.data...))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	g.overrides.mu.Lock()
+	for id, o := range overrides {
+		if o == nil {
+			delete(g.overrides.entries[t], id)
+		} else {
+			g.overrides.set(t, id, *o)
+		}
+	}
+	g.overrides.mu.Unlock()
+
+	// The active days of services depend on their exceptions
+	if t == ServiceExceptionEntityType {
+		g.serviceDays.reset()
+	}
+	return nil
+}
+
+// Adds or replaces a stop, hiding the ingested stop with the same ID
+func (g *GTFS) OverrideStop(stop *Stop) error {
+	return g.writeOverride(StopEntityType, stop.ID, &override{data: stop.Encode()})
+}
+
+// Adds or replaces a route, hiding the ingested route with the same ID
+func (g *GTFS) OverrideRoute(route *Route) error {
+	return g.writeOverride(RouteEntityType, route.ID, &override{data: route.Encode()})
+}
+
+// Adds or replaces a trip, hiding the ingested trip with the same ID
+func (g *GTFS) OverrideTrip(trip *Trip) error {
+	return g.writeOverride(TripEntityType, trip.ID, &override{data: trip.Encode()})
+}
+
+// Hides the stop with the given ID from queries
+func (g *GTFS) SuppressStop(stopID Key) error {
+	return g.writeOverride(StopEntityType, stopID, &override{suppressed: true})
+}
+
+// Hides the route with the given ID from queries
+func (g *GTFS) SuppressRoute(routeID Key) error {
+	return g.writeOverride(RouteEntityType, routeID, &override{suppressed: true})
+}
+
+// Hides the trip with the given ID from queries
+func (g *GTFS) SuppressTrip(tripID Key) error {
+	return g.writeOverride(TripEntityType, tripID, &override{suppressed: true})
+}
+
+// Adds an exception to the service on the date of the given time, such as an unplanned closure, taking
+// precedence over any ingested exception on that date
+func (g *GTFS) AddServiceException(serviceID Key, date time.Time, typ ExceptionType) error {
+	return g.AddServiceExceptions([]ServiceException{{ServiceID: serviceID, Date: date, Type: typ}})
+}
+
+// Adds the exceptions to their services as AddServiceException does, persisting them together
+func (g *GTFS) AddServiceExceptions(exceptions []ServiceException) error {
+	overrides := make(map[Key]*override, len(exceptions))
+	for _, exception := range exceptions {
+		exception.Date = calendarDate(exception.Date)
+		overrides[Key(serviceExceptionKey(exception.ServiceID, exception.Date))] = &override{data: exception.Encode()}
+	}
+	return g.writeOverrides(ServiceExceptionEntityType, overrides)
+}
+
+// Removes an exception added to the service on the date of the given time, restoring its ingested
+// exception (if any)
+func (g *GTFS) RemoveServiceException(serviceID Key, date time.Time) error {
+	return g.writeOverride(ServiceExceptionEntityType, Key(serviceExceptionKey(serviceID, date)), nil)
+}
+
+// Returns the exception added to the service on the date of the given time. The second result is false if
+// none was added.
+func (g *GTFS) addedServiceException(serviceID Key, date time.Time) (*ServiceException, bool, error) {
+	o, ok := g.overrides.get(ServiceExceptionEntityType, Key(serviceExceptionKey(serviceID, date)))
+	if !ok {
+		return nil, false, nil
+	}
+	exception := &ServiceException{}
+	err := exception.Decode(o.data)
+	return exception, true, err
+}
+
+// Returns all exceptions added to services
+func (g *GTFS) addedServiceExceptions() ([]*ServiceException, error) {
+	overrides := g.overrides.all(ServiceExceptionEntityType)
+	exceptions := make([]*ServiceException, 0, len(overrides))
+	for _, o := range overrides {
+		exception := &ServiceException{}
+		err := exception.Decode(o.data)
+		if err != nil {
+			return nil, err
+		}
+		exceptions = append(exceptions, exception)
+	}
+	return exceptions, nil
+}
+
+// Merge the exceptions added to services into the map, replacing those on the same date. If day is set
+// (as YYYYMMDD), only exceptions on that day are merged.
+func (g *GTFS) mergeAddedServiceExceptions(exceptions ServiceExceptionMap, day string) error {
+	added, err := g.addedServiceExceptions()
+	if err != nil {
+		return err
+	}
+	for _, exception := range added {
+		if day != "" && exception.Date.Format("20060102") != day {
+			continue
+		}
+		exceptions[ServiceExceptionKey{ServiceID: exception.ServiceID, Date: exception.Date}] = exception
+	}
+	return nil
+}
+
+// Removes the override for the entity with the given ID, restoring its ingested version (if any)
+func (g *GTFS) RemoveOverride(t EntityType, id Key) error {
+	return g.writeOverride(t, id, nil)
+}
+
+// Removes all overrides, restoring the ingested data
+func (g *GTFS) ResetOverrides() error {
+	if g.filePath == "" {
+		return errors.New("database not open")
+	}
+
+	err := os.Remove(overridesFile(g.filePath))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if g.overrides != nil {
+		g.overrides.mu.Lock()
+		g.overrides.entries = make(map[EntityType]map[Key]override)
+		g.overrides.mu.Unlock()
+	}
+	g.serviceDays.reset()
+	return nil
+}
+
+// Decodes an overriding entity
+func decodeOverride[T any, PT decodablePtr[T]](id Key, o override) (*T, error) {
+	entity := PT(new(T))
+	err := entity.Decode(id, o.data)
+	if err != nil {
+		return nil, err
+	}
+	return (*T)(entity), nil
+}
+
+// Returns the overriding version of the entity with the given ID. The second result is false if the
+// entity is not overridden. Suppressed entities are reported as not found, using the given name.
+func overriddenEntity[T any, PT decodablePtr[T]](g *GTFS, t EntityType, id Key, name string) (*T, bool, error) {
+	o, ok := g.overrides.get(t, id)
+	if !ok {
+		return nil, false, nil
+	}
+	if o.suppressed {
+		return nil, true, fmt.Errorf("%s %w", name, ErrNotFound)
+	}
+	entity, err := decodeOverride[T, PT](id, o)
+	return entity, true, err
+}
+
+// Applies an override to a set of entities, replacing or removing the entity
+func applyOverride[T any, PT decodablePtr[T]](entities map[Key]*T, id Key, o override) error {
+	if o.suppressed {
+		delete(entities, id)
+		return nil
+	}
+	entity, err := decodeOverride[T, PT](id, o)
+	if err != nil {
+		return err
+	}
+	entities[id] = entity
+	return nil
+}
+
+// Applies the overrides for the given IDs to the entities with those IDs loaded from the database
+func mergeOverrides[T any, PT decodablePtr[T]](g *GTFS, t EntityType, entities map[Key]*T, ids []Key) error {
+	overrides := g.overrides.all(t)
+	if len(overrides) == 0 {
+		return nil
+	}
+
+	for _, id := range ids {
+		if o, ok := overrides[id]; ok {
+			err := applyOverride[T, PT](entities, id, o)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Applies every override to a full listing of the entities loaded from the database
+func mergeAllOverrides[T any, PT decodablePtr[T]](g *GTFS, t EntityType, entities map[Key]*T) error {
+	for id, o := range g.overrides.all(t) {
+		err := applyOverride[T, PT](entities, id, o)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Applies the trip overrides to the trips of a route, removing trips moved to other routes
+// and adding trips moved onto it
+func (g *GTFS) mergeRouteTripOverrides(routeID Key, trips TripMap) error {
+	for id, o := range g.overrides.all(TripEntityType) {
+		delete(trips, id)
+		if o.suppressed {
+			continue
+		}
+		trip, err := decodeOverride[Trip](id, o)
+		if err != nil {
+			return err
+		}
+		if trip.RouteID == routeID {
+			trips[id] = trip
+		}
+	}
+	return nil
+}
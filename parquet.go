@@ -0,0 +1,525 @@
+package gtfs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Magic bytes at the start and end of a Parquet file
+const parquetMagic = "PAR1"
+
+// Maximum number of rows in each row group of an exported Parquet file
+const parquetRowGroupSize = 100000
+
+// Parquet physical types
+const (
+	parquetInt32     int32 = 1
+	parquetDouble    int32 = 5
+	parquetByteArray int32 = 6
+)
+
+// Parquet constants used in the file metadata
+const (
+	parquetRequired      int32 = 0 // Field repetition type
+	parquetUTF8          int32 = 0 // Converted type
+	parquetPlainEncoding int32 = 0
+	parquetRLEEncoding   int32 = 3
+	parquetUncompressed  int32 = 0
+	parquetDataPage      int32 = 0
+)
+
+// A required column of a Parquet file
+type parquetField struct {
+	name     string
+	physical int32
+}
+
+// Returns a string column
+func parquetString(name string) parquetField {
+	return parquetField{name, parquetByteArray}
+}
+
+// Returns a 32-bit integer column
+func parquetInt(name string) parquetField {
+	return parquetField{name, parquetInt32}
+}
+
+// Returns a double column
+func parquetFloat(name string) parquetField {
+	return parquetField{name, parquetDouble}
+}
+
+// Location and size of a column chunk written to a Parquet file
+type parquetChunk struct {
+	offset int64
+	size   int64
+	values int64
+}
+
+// Writes rows to a Parquet file with required, plain-encoded and uncompressed columns.
+// Rows are buffered and written in row groups of up to parquetRowGroupSize rows.
+type parquetWriter struct {
+	w         *bufio.Writer
+	offset    int64
+	fields    []parquetField
+	columns   [][]byte // Plain-encoded values of the current row group, by column
+	rows      int64    // Rows in the current row group
+	totalRows int64
+	groups    [][]parquetChunk
+}
+
+// Create a writer for a Parquet file with the given columns, writing its header to w
+func newParquetWriter(w io.Writer, fields ...parquetField) (*parquetWriter, error) {
+	p := &parquetWriter{
+		w:       bufio.NewWriter(w),
+		fields:  fields,
+		columns: make([][]byte, len(fields)),
+	}
+	return p, p.write([]byte(parquetMagic))
+}
+
+// Write bytes to the file, keeping track of the offset
+func (p *parquetWriter) write(data []byte) error {
+	n, err := p.w.Write(data)
+	p.offset += int64(n)
+	return err
+}
+
+// Add a row, with a value for each column. Values must be strings or Keys for string columns,
+// ints for integer columns and float64s for double columns.
+func (p *parquetWriter) writeRow(values ...any) error {
+	if len(values) != len(p.fields) {
+		return fmt.Errorf("expected %d values, got %d", len(p.fields), len(values))
+	}
+
+	for i, value := range values {
+		column := p.columns[i]
+		switch v := value.(type) {
+		case string:
+			column = binary.LittleEndian.AppendUint32(column, uint32(len(v)))
+			column = append(column, v...)
+		case Key:
+			column = binary.LittleEndian.AppendUint32(column, uint32(len(v)))
+			column = append(column, v...)
+		case int:
+			column = binary.LittleEndian.AppendUint32(column, uint32(int32(v)))
+		case float64:
+			column = binary.LittleEndian.AppendUint64(column, math.Float64bits(v))
+		default:
+			return fmt.Errorf("unsupported value type %T for column %s", value, p.fields[i].name)
+		}
+		p.columns[i] = column
+	}
+
+	p.rows++
+	if p.rows == parquetRowGroupSize {
+		return p.flush()
+	}
+	return nil
+}
+
+// Write the buffered rows as a row group, with a single data page for each column
+func (p *parquetWriter) flush() error {
+	if p.rows == 0 {
+		return nil
+	}
+
+	chunks := make([]parquetChunk, len(p.columns))
+	for i, data := range p.columns {
+		var header thriftWriter
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(data)))
+		header.i32(3, int32(len(data)))
+		header.structBegin(5)
+		header.i32(1, int32(p.rows))
+		header.i32(2, parquetPlainEncoding)
+		header.i32(3, parquetRLEEncoding)
+		header.i32(4, parquetRLEEncoding)
+		header.structEnd()
+		header.structEnd()
+
+		chunks[i] = parquetChunk{
+			offset: p.offset,
+			size:   int64(len(header.buf) + len(data)),
+			values: p.rows,
+		}
+		err := p.write(header.buf)
+		if err != nil {
+			return err
+		}
+		err = p.write(data)
+		if err != nil {
+			return err
+		}
+		p.columns[i] = data[:0]
+	}
+
+	p.groups = append(p.groups, chunks)
+	p.totalRows += p.rows
+	p.rows = 0
+	return nil
+}
+
+// Write any buffered rows and the file's footer
+func (p *parquetWriter) close() error {
+	err := p.flush()
+	if err != nil {
+		return err
+	}
+
+	var meta thriftWriter
+	meta.i32(1, 1)
+
+	// The schema is a root element followed by each column
+	meta.listBegin(2, thriftStruct, len(p.fields)+1)
+	meta.elemBegin()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(p.fields)))
+	meta.structEnd()
+	for _, field := range p.fields {
+		meta.elemBegin()
+		meta.i32(1, field.physical)
+		meta.i32(3, parquetRequired)
+		meta.binary(4, field.name)
+		if field.physical == parquetByteArray {
+			meta.i32(6, parquetUTF8)
+		}
+		meta.structEnd()
+	}
+
+	meta.i64(3, p.totalRows)
+
+	meta.listBegin(4, thriftStruct, len(p.groups))
+	for _, chunks := range p.groups {
+		meta.elemBegin()
+		meta.listBegin(1, thriftStruct, len(chunks))
+		var groupSize int64
+		for i, chunk := range chunks {
+			meta.elemBegin()
+			meta.i64(2, chunk.offset)
+			meta.structBegin(3)
+			meta.i32(1, p.fields[i].physical)
+			meta.listBegin(2, thriftI32, 1)
+			meta.elemI32(parquetPlainEncoding)
+			meta.listBegin(3, thriftBinary, 1)
+			meta.elemBinary(p.fields[i].name)
+			meta.i32(4, parquetUncompressed)
+			meta.i64(5, chunk.values)
+			meta.i64(6, chunk.size)
+			meta.i64(7, chunk.size)
+			meta.i64(9, chunk.offset)
+			meta.structEnd()
+			meta.structEnd()
+			groupSize += chunk.size
+		}
+		meta.i64(2, groupSize)
+		meta.i64(3, chunks[0].values)
+		meta.structEnd()
+	}
+	meta.binary(6, "gtfs-go")
+	meta.structEnd()
+
+	err = p.write(meta.buf)
+	if err != nil {
+		return err
+	}
+	err = p.write(binary.LittleEndian.AppendUint32(nil, uint32(len(meta.buf))))
+	if err != nil {
+		return err
+	}
+	err = p.write([]byte(parquetMagic))
+	if err != nil {
+		return err
+	}
+	return p.w.Flush()
+}
+
+// Thrift compact protocol types
+const (
+	thriftI32    byte = 5
+	thriftI64    byte = 6
+	thriftBinary byte = 8
+	thriftList   byte = 9
+	thriftStruct byte = 12
+)
+
+// Encodes structs with the Thrift compact protocol, as used by Parquet metadata.
+// Fields of each struct must be written in increasing order of their IDs.
+type thriftWriter struct {
+	buf    []byte
+	last   int16   // ID of the last field written in the current struct
+	parent []int16 // Last field IDs of the enclosing structs
+}
+
+// Write a field header
+func (t *thriftWriter) field(id int16, typ byte) {
+	delta := id - t.last
+	if delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.buf = binary.AppendVarint(t.buf, int64(id))
+	}
+	t.last = id
+}
+
+// Write a 32-bit integer field
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.buf = binary.AppendVarint(t.buf, int64(v))
+}
+
+// Write a 64-bit integer field
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.buf = binary.AppendVarint(t.buf, v)
+}
+
+// Write a string field
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.elemBinary(s)
+}
+
+// Write the header of a list field with the given element type and size
+func (t *thriftWriter) listBegin(id int16, elemType byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf = append(t.buf, byte(size)<<4|elemType)
+	} else {
+		t.buf = append(t.buf, 0xf0|elemType)
+		t.buf = binary.AppendUvarint(t.buf, uint64(size))
+	}
+}
+
+// Write a 32-bit integer list element
+func (t *thriftWriter) elemI32(v int32) {
+	t.buf = binary.AppendVarint(t.buf, int64(v))
+}
+
+// Write a string list element
+func (t *thriftWriter) elemBinary(s string) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+// Begin a struct field
+func (t *thriftWriter) structBegin(id int16) {
+	t.field(id, thriftStruct)
+	t.elemBegin()
+}
+
+// Begin a struct list element
+func (t *thriftWriter) elemBegin() {
+	t.parent = append(t.parent, t.last)
+	t.last = 0
+}
+
+// End the current struct, or the top-level struct if there is none
+func (t *thriftWriter) structEnd() {
+	t.buf = append(t.buf, 0)
+	if len(t.parent) > 0 {
+		t.last = t.parent[len(t.parent)-1]
+		t.parent = t.parent[:len(t.parent)-1]
+	}
+}
+
+// Writes the feed's stops, routes, trips, stop times and shape points as Parquet files in the directory,
+// which is created if it does not exist, for loading into tools such as DuckDB and Spark.
+// Stop times and shapes are exploded into a row per stop or point, and every table is sorted by ID.
+// Stop times are in seconds since the start of the service day. Columns are never null: missing strings
+// are empty, and missing numbers are zero.
+func (g *GTFS) ExportParquet(dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	exports := []func(dir string) error{
+		g.exportStopsParquet,
+		g.exportRoutesParquet,
+		g.exportTripsParquet,
+		g.exportShapesParquet,
+	}
+	for _, export := range exports {
+		err := export(dir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create the named Parquet file in the directory, write its rows with fn and close it
+func writeParquetFile(dir, name string, fields []parquetField, fn func(p *parquetWriter) error) error {
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+
+	p, err := newParquetWriter(f, fields...)
+	if err == nil {
+		err = fn(p)
+	}
+	if err == nil {
+		err = p.close()
+	}
+	err = errors.Join(err, f.Close())
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+func (g *GTFS) exportStopsParquet(dir string) error {
+	stops, err := g.GetAllStops()
+	if err != nil {
+		return err
+	}
+
+	fields := []parquetField{
+		parquetString("stop_id"),
+		parquetString("stop_code"),
+		parquetString("stop_name"),
+		parquetString("stop_desc"),
+		parquetFloat("stop_lat"),
+		parquetFloat("stop_lon"),
+		parquetString("zone_id"),
+		parquetString("stop_url"),
+		parquetInt("location_type"),
+		parquetString("parent_station"),
+		parquetString("platform_code"),
+		parquetInt("wheelchair_boarding"),
+		parquetInt("supported_modes"),
+	}
+	return writeParquetFile(dir, "stops.parquet", fields, func(p *parquetWriter) error {
+		for _, id := range sortedIDs(stops) {
+			stop := stops[id]
+			err := p.writeRow(
+				stop.ID, stop.Code, stop.Name, stop.Description,
+				stop.Location.Latitude, stop.Location.Longitude,
+				stop.ZoneID, stop.URL, int(stop.LocationType), stop.ParentID,
+				stop.PlatformCode, int(stop.WheelchairBoarding), int(stop.SupportedModes),
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (g *GTFS) exportRoutesParquet(dir string) error {
+	routes, err := g.GetAllRoutes()
+	if err != nil {
+		return err
+	}
+
+	fields := []parquetField{
+		parquetString("route_id"),
+		parquetString("agency_id"),
+		parquetString("route_short_name"),
+		parquetInt("route_type"),
+		parquetString("route_color"),
+	}
+	return writeParquetFile(dir, "routes.parquet", fields, func(p *parquetWriter) error {
+		for _, id := range sortedIDs(routes) {
+			route := routes[id]
+			err := p.writeRow(route.ID, route.AgencyID, route.Name, int(route.Type), route.Colour)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (g *GTFS) exportTripsParquet(dir string) error {
+	trips, err := g.GetAllTrips()
+	if err != nil {
+		return err
+	}
+	ids := sortedIDs(trips)
+
+	fields := []parquetField{
+		parquetString("trip_id"),
+		parquetString("route_id"),
+		parquetString("service_id"),
+		parquetString("trip_headsign"),
+		parquetInt("direction_id"),
+		parquetString("shape_id"),
+	}
+	err = writeParquetFile(dir, "trips.parquet", fields, func(p *parquetWriter) error {
+		for _, id := range ids {
+			trip := trips[id]
+			direction := 0
+			if trip.Direction == InboundTripDirection {
+				direction = 1
+			}
+			err := p.writeRow(trip.ID, trip.RouteID, trip.ServiceID, trip.Headsign, direction, trip.ShapeID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Stop times are exploded from the trips into a file of their own
+	fields = []parquetField{
+		parquetString("trip_id"),
+		parquetInt("arrival_time"),
+		parquetInt("departure_time"),
+		parquetString("stop_id"),
+		parquetInt("stop_sequence"),
+		parquetInt("timepoint"),
+	}
+	return writeParquetFile(dir, "stop_times.parquet", fields, func(p *parquetWriter) error {
+		for _, id := range ids {
+			for i, stop := range trips[id].Stops {
+				timepoint := 0
+				if stop.Timepoint == ExactTripTimepoint {
+					timepoint = 1
+				}
+				err := p.writeRow(id, int(stop.ArrivalTime), int(stop.DepartureTime), stop.StopID, i, timepoint)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (g *GTFS) exportShapesParquet(dir string) error {
+	shapes, err := g.GetAllShapes()
+	if err != nil {
+		return err
+	}
+
+	fields := []parquetField{
+		parquetString("shape_id"),
+		parquetFloat("shape_pt_lat"),
+		parquetFloat("shape_pt_lon"),
+		parquetInt("shape_pt_sequence"),
+	}
+	return writeParquetFile(dir, "shapes.parquet", fields, func(p *parquetWriter) error {
+		for _, id := range sortedIDs(shapes) {
+			for i, coord := range shapes[id].Coordinates {
+				err := p.writeRow(id, coord.Latitude, coord.Longitude, i)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,262 @@
+package gtfs
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Controls how malformed rows are handled while parsing GTFS files
+type ParseMode uint8
+
+const (
+	// Abort parsing on the first malformed row
+	StrictParseMode ParseMode = iota
+	// Skip malformed rows, recording a warning for each
+	LenientParseMode
+	// Repair malformed rows with default values where possible, otherwise skip them
+	BestEffortParseMode
+)
+
+// Maximum number of warnings recorded in a FileReport; further warnings are only counted
+const maxReportWarnings = 100
+
+// Options controlling how GTFS files are parsed
+type ParseOptions struct {
+	Mode ParseMode
+
+	// Trim whitespace from ID columns and normalize them to Unicode NFC, so that IDs
+	// differing only in formatting refer to the same entity
+	NormalizeKeys bool
+
+	// Correct stop and shape coordinates whose latitude and longitude appear to be swapped,
+	// and accept coordinates written with a decimal comma
+	FixCoordinates bool
+
+	// Check rows against the rules of the GTFS specification, reporting violations as SpecErrors with
+	// the notice codes of the canonical GTFS validator. In strict mode the first violation is returned
+	// as an error; otherwise violations are recorded in the FileReport.
+	Conformance bool
+
+	// Keep the raw stop_sequence of each stop time on its TripStop, rather than only using it to order the
+	// trip's stops, so that skipped sequences can be detected and stops matched with realtime feeds
+	KeepStopSequences bool
+
+	// Hooks rewriting the rows of files with nonstandard layouts, keyed by file name (e.g. "stops.txt")
+	FieldMappers map[string]FieldMapper
+}
+
+// Rewrites a row of a file with a nonstandard layout into the layout expected by the parser, such as by
+// moving values between columns. It receives the file's header, mapping column names to their indices,
+// and the row, which has a value for every column of the header. The returned row is parsed in place of
+// the original, and must have at least as many values. Columns appended to the row can be named by adding
+// them to the header, which is shared by every row of the file.
+type FieldMapper func(header map[string]int, record []string) []string
+
+// Summary of the rows parsed from a single GTFS file
+type FileReport struct {
+	File             string
+	Rows             int // Number of data rows read, excluding the header
+	SkippedRows      int
+	RepairedRows     int
+	DuplicateKeys    int      // Rows whose key duplicates an earlier row's
+	CoordinateIssues []Key    // Stops or shapes with out-of-range or apparently swapped coordinates
+	Warnings         []string // Up to maxReportWarnings warnings, in file order
+	WarningCount     int      // Total number of warnings, including those not recorded
+
+	// Up to maxReportWarnings violations of the specification found in conformance mode, in file order
+	Violations     []*SpecError
+	ViolationCount int // Total number of violations, including those not recorded
+}
+
+// Record a warning in the report
+func (r *FileReport) warn(format string, args ...any) {
+	r.WarningCount++
+	if len(r.Warnings) < maxReportWarnings {
+		r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+	}
+}
+
+// Reads the rows of a GTFS CSV file, applying the parse options to malformed rows
+type csvParser struct {
+	opts     ParseOptions
+	report   *FileReport
+	reader   *csv.Reader
+	header   csvHeader
+	columns  int
+	keys     []int // Indices of the ID columns, normalized if NormalizeKeys is set
+	line     int   // Line number of the current row
+	repaired bool  // Whether the current row has been repaired
+}
+
+// Create a new csvParser for the given file, reading its header
+func newCSVParser(file string, r io.Reader, opts ParseOptions) (*csvParser, error) {
+	if r == nil {
+		return nil, errors.New("missing file: " + file)
+	}
+
+	reader := csv.NewReader(r)
+	if opts.Mode != StrictParseMode {
+		// Rows with the wrong number of fields are handled by next
+		reader.FieldsPerRecord = -1
+	}
+
+	// An empty file has no header, and yields no rows
+	header, err := reader.Read()
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	// Some feeds prefix their files with a UTF-8 byte order mark
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+
+	var keys []int
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if strings.HasSuffix(name, "_id") || name == "parent_station" {
+			keys = append(keys, i)
+		}
+	}
+
+	return &csvParser{
+		opts:    opts,
+		report:  &FileReport{File: file},
+		reader:  reader,
+		header:  newCSVHeader(header),
+		columns: len(header),
+		keys:    keys,
+		line:    1,
+	}, nil
+}
+
+// Trim whitespace from a key and normalize it to Unicode NFC
+func normalizeKey(key string) string {
+	return norm.NFC.String(strings.TrimSpace(key))
+}
+
+// Return the next row of the file, or io.EOF when there are no more rows.
+// In non-strict modes, rows which cannot be read are skipped, and short rows
+// are either skipped or padded with empty fields.
+func (p *csvParser) next() ([]string, error) {
+	for {
+		record, err := p.reader.Read()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		p.line++
+		p.report.Rows++
+		p.repaired = false
+
+		if err != nil {
+			if skipErr := p.skip(p.spec("csv_parsing_failed", p.report.File+" must be valid CSV", err)); skipErr != nil {
+				return nil, skipErr
+			}
+			continue
+		}
+
+		if len(record) < p.columns {
+			err := p.spec("invalid_row_length", "rows of "+p.report.File+" must have a value for each column",
+				fmt.Errorf("row has %d fields, expected %d", len(record), p.columns))
+			if p.opts.Mode == BestEffortParseMode {
+				padded := make([]string, p.columns)
+				copy(padded, record)
+				p.repair("row has %d fields, padded to %d", len(record), p.columns)
+				p.report.violateIfSpec(err)
+				record = padded
+			} else {
+				if skipErr := p.skip(err); skipErr != nil {
+					return nil, skipErr
+				}
+				continue
+			}
+		}
+
+		p.normalize(record)
+		if mapper := p.opts.FieldMappers[p.report.File]; mapper != nil {
+			record = mapper(p.header, record)
+		}
+		if p.opts.Conformance {
+			err := p.checkConformance(record)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return record, nil
+	}
+}
+
+// Normalize the ID columns of the record, if enabled
+func (p *csvParser) normalize(record []string) {
+	if !p.opts.NormalizeKeys {
+		return
+	}
+	for _, i := range p.keys {
+		if i < len(record) {
+			record[i] = normalizeKey(record[i])
+		}
+	}
+}
+
+// Handle a malformed row which cannot be repaired. In strict mode this returns
+// the error annotated with the line number; otherwise the row is counted as
+// skipped and nil is returned, and the caller should move on to the next row.
+func (p *csvParser) skip(err error) error {
+	if p.opts.Mode == StrictParseMode {
+		return fmt.Errorf("line %d: %w", p.line, err)
+	}
+	p.report.SkippedRows++
+	p.report.warn("line %d: skipped: %v", p.line, err)
+	p.report.violateIfSpec(err)
+	return nil
+}
+
+// Check whether malformed values may be repaired with defaults
+func (p *csvParser) canRepair() bool {
+	return p.opts.Mode == BestEffortParseMode
+}
+
+// Record that a value in the current row was repaired with a default
+func (p *csvParser) repair(format string, args ...any) {
+	if !p.repaired {
+		p.report.RepairedRows++
+		p.repaired = true
+	}
+	p.report.warn("line %d: repaired: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// Check that the file has the named columns. An empty file, which has no header, has every column.
+func (p *csvParser) require(names ...string) error {
+	if p.columns == 0 {
+		return nil
+	}
+	for _, name := range names {
+		if _, ok := p.header[name]; !ok {
+			err := fmt.Errorf("%s: missing column %s", p.report.File, name)
+			if p.opts.Conformance {
+				return &SpecError{Code: "missing_required_column", Rule: specFileName(p.report.File) + "." + name + " column is required", File: p.report.File, Err: err}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Record that the current row's key duplicates an earlier row's
+func (p *csvParser) duplicate(key string) {
+	p.report.DuplicateKeys++
+	p.report.warn("line %d: duplicate key %q", p.line, key)
+	if p.opts.Conformance {
+		p.report.violate(&SpecError{Code: "duplicate_key", Rule: "keys of " + p.report.File + " must be unique", File: p.report.File, Line: p.line})
+	}
+}
+
+// Return the value of the named column in the record, or an empty string if the column is not present
+func (p *csvParser) get(record []string, name string) string {
+	return p.header.get(record, name)
+}
@@ -0,0 +1,80 @@
+package gtfs
+
+import (
+	"sort"
+)
+
+// A distinct stop sequence served by one or more trips of a route
+type StopPattern struct {
+	StopIDs   KeyArray `json:"stop_ids"`
+	Trips     []*Trip  `json:"trips"`     // Sorted by start time
+	Frequency int      `json:"frequency"` // Number of trips
+}
+
+// Group the trips by identical stop sequence, ordered by frequency (most common first).
+// Ties are broken by preferring the longer sequence, then the lexically smaller one.
+func groupStopPatterns(trips []*Trip) []*StopPattern {
+	patterns := make(map[string]*StopPattern)
+	for _, trip := range trips {
+		stopIDs := make(KeyArray, len(trip.Stops))
+		for i, stop := range trip.Stops {
+			stopIDs[i] = stop.StopID
+		}
+		patternKey := string(stopIDs.Encode())
+
+		pattern, exists := patterns[patternKey]
+		if !exists {
+			pattern = &StopPattern{StopIDs: stopIDs}
+			patterns[patternKey] = pattern
+		}
+		pattern.Trips = append(pattern.Trips, trip)
+		pattern.Frequency++
+	}
+
+	keys := make([]string, 0, len(patterns))
+	for patternKey, pattern := range patterns {
+		keys = append(keys, patternKey)
+		sort.Slice(pattern.Trips, func(i, j int) bool {
+			if pattern.Trips[i].StartTime() != pattern.Trips[j].StartTime() {
+				return pattern.Trips[i].StartTime() < pattern.Trips[j].StartTime()
+			}
+			return pattern.Trips[i].ID < pattern.Trips[j].ID
+		})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := patterns[keys[i]], patterns[keys[j]]
+		if a.Frequency != b.Frequency {
+			return a.Frequency > b.Frequency
+		}
+		if len(a.StopIDs) != len(b.StopIDs) {
+			return len(a.StopIDs) > len(b.StopIDs)
+		}
+		return keys[i] < keys[j]
+	})
+
+	result := make([]*StopPattern, len(keys))
+	for i, patternKey := range keys {
+		result[i] = patterns[patternKey]
+	}
+	return result
+}
+
+// Returns the distinct stop sequences served by the route's trips, each with its trips,
+// ordered by frequency (most common first). They are read from the database if precomputed at ingest.
+func (g *GTFS) GetStopPatterns(routeID Key) ([]*StopPattern, error) {
+	patterns, err := g.getDerivedStopPatterns(routeID)
+	if err != nil || patterns != nil {
+		return patterns, err
+	}
+
+	trips, err := g.GetTripsByRouteID(routeID)
+	if err != nil {
+		return nil, err
+	}
+
+	tripList := make([]*Trip, 0, len(trips))
+	for _, trip := range trips {
+		tripList = append(tripList, trip)
+	}
+	return groupStopPatterns(tripList), nil
+}
@@ -0,0 +1,71 @@
+package gtfs
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Returns the entities in the bucket whose IDs start with the prefix, seeking to the first match
+// rather than scanning the whole bucket. Overrides of matching IDs are applied.
+func getEntitiesWithIDPrefix[T any, PT decodablePtr[T]](g *GTFS, t EntityType, prefix string) (map[Key]*T, error) {
+	entities := make(map[Key]*T)
+	if cached, ok := cachedEntities[T](g, t); ok {
+		for id, entity := range cached {
+			if strings.HasPrefix(string(id), prefix) {
+				entities[id] = entity
+			}
+		}
+	} else {
+		err := g.view(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(entityBuckets[t]))
+			if b == nil {
+				return errors.New("bucket not found")
+			}
+
+			c := b.Cursor()
+			for k, v := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+				entity := PT(new(T))
+				key := Key(k)
+				err := g.decode(tx, entity, key, v)
+				if err != nil {
+					return err
+				}
+				entities[key] = (*T)(entity)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var overridden []Key
+	for id := range g.overrides.all(t) {
+		if strings.HasPrefix(string(id), prefix) {
+			overridden = append(overridden, id)
+		}
+	}
+	err := mergeOverrides[T, PT](g, t, entities, overridden)
+	if err != nil {
+		return nil, err
+	}
+	return entities, nil
+}
+
+// Returns the stops whose IDs start with the given prefix, such as an operator's namespace
+func (g *GTFS) GetStopsWithIDPrefix(prefix string) (StopMap, error) {
+	return getEntitiesWithIDPrefix[Stop](g, StopEntityType, prefix)
+}
+
+// Returns the trips whose IDs start with the given prefix, such as an operator's namespace
+func (g *GTFS) GetTripsWithIDPrefix(prefix string) (TripMap, error) {
+	return getEntitiesWithIDPrefix[Trip](g, TripEntityType, prefix)
+}
+
+// Returns the routes whose IDs start with the given prefix, such as an operator's namespace
+func (g *GTFS) GetRoutesWithIDPrefix(prefix string) (RouteMap, error) {
+	return getEntitiesWithIDPrefix[Route](g, RouteEntityType, prefix)
+}
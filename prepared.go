@@ -0,0 +1,161 @@
+package gtfs
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"time"
+)
+
+// A departures query for a single stop, with the trips, services and service exceptions it depends on
+// loaded once so that it can be evaluated repeatedly for different times without further database lookups.
+// Updates from an attached realtime source, applied service changes and service exceptions added or removed
+// later (see AddServiceException) are still taken into account on each evaluation, but other later overrides
+// are not reflected; prepare the query again to pick them up.
+type PreparedDepartures struct {
+	g          *GTFS
+	stopID     Key
+	timezone   *time.Location
+	trips      TripMap
+	stopIndex  map[Key][]int // Indices of the stop in each trip's stops, excluding the last stop
+	services   ServiceMap
+	exceptions map[string]*ServiceException // Ingested exceptions, keyed by service ID and date
+}
+
+// Prepares a query for the departures from the given stop. See PreparedDepartures.
+func (g *GTFS) PrepareDepartures(stopID Key) (*PreparedDepartures, error) {
+	timezone, err := g.getFeedTimezone()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the trips visiting the stop can depart from it, whichever pattern of their route they follow
+	trips, err := g.getTripsByStopID(stopID)
+	if err != nil {
+		return nil, err
+	}
+
+	stopIndex := make(map[Key][]int)
+	serviceIDs := make(map[Key]bool)
+	for _, trip := range trips {
+		for i, stop := range trip.Stops[:max(len(trip.Stops)-1, 0)] {
+			if stop.StopID == stopID {
+				stopIndex[trip.ID] = append(stopIndex[trip.ID], i)
+			}
+		}
+		if len(stopIndex[trip.ID]) == 0 {
+			delete(trips, trip.ID)
+			continue
+		}
+		serviceIDs[trip.ServiceID] = true
+	}
+
+	services, err := g.GetServicesByIDs(slices.Collect(maps.Keys(serviceIDs)))
+	if err != nil {
+		return nil, err
+	}
+	// Added exceptions are looked up on each evaluation instead, as they can change
+	exceptions := make(map[string]*ServiceException)
+	for serviceID := range serviceIDs {
+		serviceExceptions, err := g.ingested().getServiceExceptions(serviceID)
+		if err != nil {
+			return nil, err
+		}
+		for _, exception := range serviceExceptions {
+			exceptions[string(serviceID)+exception.Date.Format("20060102")] = exception
+		}
+	}
+
+	return &PreparedDepartures{
+		g:          g,
+		stopID:     stopID,
+		timezone:   timezone,
+		trips:      trips,
+		stopIndex:  stopIndex,
+		services:   services,
+		exceptions: exceptions,
+	}, nil
+}
+
+// Returns the ID of the stop the query was prepared for
+func (p *PreparedDepartures) StopID() Key {
+	return p.stopID
+}
+
+// Check whether the trip runs on the service day with the given noon, taking applied service changes
+// into account. Service results are stored in the cache, keyed by service ID.
+func (p *PreparedDepartures) isTripRunning(trip *Trip, noon time.Time, cache map[Key]bool) (bool, error) {
+	if change, ok := p.g.serviceChanges.get(trip.ID, noon); ok {
+		return change == AddedExceptionType, nil
+	}
+	if running, ok := cache[trip.ServiceID]; ok {
+		return running, nil
+	}
+
+	service, ok := p.services[trip.ServiceID]
+	if !ok {
+		return false, fmt.Errorf("service %w", ErrNotFound)
+	}
+	exception, ok, err := p.g.addedServiceException(trip.ServiceID, noon)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		exception = p.exceptions[string(trip.ServiceID)+noon.Format("20060102")]
+	}
+	running := serviceRunsOn(service, exception, noon)
+	cache[trip.ServiceID] = running
+	return running, nil
+}
+
+// Returns the departures from the stop within the window starting at the given time, as with GetStopDepartures
+func (p *PreparedDepartures) Departures(from time.Time, window time.Duration) ([]Departure, error) {
+	from = from.In(p.timezone)
+	until := from.Add(window)
+
+	departures := []Departure{}
+
+	// Check each service day which could have trips departing within the window
+	for day := addServiceDays(serviceDayStart(from, p.timezone), -1); !day.After(until); day = addServiceDays(day, 1) {
+		dayTrips, err := p.g.applyRealtime(p.trips, day)
+		if err != nil {
+			return nil, err
+		}
+
+		noon := day.Add(12 * time.Hour)
+		runningCache := make(map[Key]bool)
+		for _, trip := range dayTrips {
+			for _, i := range p.stopIndex[trip.ID] {
+				departure := day.Add(time.Duration(trip.Stops[i].DepartureTime) * time.Second)
+				if departure.Before(from) || departure.After(until) {
+					continue
+				}
+
+				running, err := p.isTripRunning(trip, noon, runningCache)
+				if err != nil {
+					return nil, err
+				}
+				if !running {
+					break
+				}
+
+				departures = append(departures, Departure{
+					TripID:    trip.ID,
+					RouteID:   trip.RouteID,
+					Headsign:  trip.Headsign,
+					StopIndex: i,
+					Time:      departure,
+				})
+			}
+		}
+	}
+
+	sort.Slice(departures, func(i, j int) bool {
+		if !departures[i].Time.Equal(departures[j].Time) {
+			return departures[i].Time.Before(departures[j].Time)
+		}
+		return departures[i].TripID < departures[j].TripID
+	})
+	return departures, nil
+}
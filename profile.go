@@ -0,0 +1,145 @@
+package gtfs
+
+import (
+	"errors"
+	"slices"
+	"sort"
+)
+
+// Distance in metres within which other stops are listed as transfers in a stop profile
+const transferDistance = 400
+
+// A nearby stop which passengers may walk to, and the routes serving it
+type StopTransfer struct {
+	Stop     *Stop   `json:"stop"`
+	Distance float64 `json:"distance"` // Metres
+	RouteIDs []Key   `json:"route_ids"`
+}
+
+// Summary of a stop for display on a stop-detail page
+type StopProfile struct {
+	Stop   *Stop    `json:"stop"`
+	Parent *Stop    `json:"parent,omitempty"` // Station containing the stop, if any
+	Routes []*Route `json:"routes"`           // Routes serving the stop, sorted by ID
+	Modes  ModeFlag `json:"modes"`            // Modes of the stop and the routes serving it
+
+	// Earliest and latest scheduled departures from the stop on any service day, in seconds
+	// since the start of the service day. Both are zero if no trip departs from the stop.
+	FirstDeparture uint `json:"first_departure"`
+	LastDeparture  uint `json:"last_departure"`
+	Departures     int  `json:"departures"` // Number of scheduled departures from the stop on any service day
+
+	// Wheelchair boarding at the stop, inherited from its parent station if unknown
+	WheelchairBoarding WheelchairBoarding `json:"wheelchair_boarding"`
+
+	Transfers []StopTransfer `json:"transfers"` // Other stops within transferDistance, nearest first
+}
+
+// Returns a profile of the stop, combining the routes serving it, its modes, the span of its departures,
+// its accessibility and the stops it is possible to transfer to. The last stop of a trip is not
+// considered a departure.
+func (g *GTFS) GetStopProfile(stopID Key) (*StopProfile, error) {
+	stop, err := g.GetStopByID(stopID)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &StopProfile{
+		Stop:               stop,
+		Routes:             []*Route{},
+		Modes:              stop.SupportedModes,
+		WheelchairBoarding: stop.WheelchairBoarding,
+		Transfers:          []StopTransfer{},
+	}
+
+	if stop.ParentID != "" {
+		parent, err := g.GetStopByID(stop.ParentID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		profile.Parent = parent
+		if parent != nil && profile.WheelchairBoarding == UnknownWheelchairBoarding {
+			profile.WheelchairBoarding = parent.WheelchairBoarding
+		}
+	}
+
+	// Routes serve the stop through their trips visiting it, whichever pattern they follow
+	trips, err := g.getTripsByStopID(stopID)
+	if err != nil {
+		return nil, err
+	}
+	var routeIDs []Key
+	for _, trip := range trips {
+		if !slices.Contains(routeIDs, trip.RouteID) {
+			routeIDs = append(routeIDs, trip.RouteID)
+		}
+		for _, tripStop := range trip.Stops[:max(len(trip.Stops)-1, 0)] {
+			if tripStop.StopID != stopID {
+				continue
+			}
+			if profile.Departures == 0 || tripStop.DepartureTime < profile.FirstDeparture {
+				profile.FirstDeparture = tripStop.DepartureTime
+			}
+			if tripStop.DepartureTime > profile.LastDeparture {
+				profile.LastDeparture = tripStop.DepartureTime
+			}
+			profile.Departures++
+		}
+	}
+	routes, err := g.GetRoutesByIDs(routeIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, route := range routes {
+		profile.Routes = append(profile.Routes, route)
+		profile.Modes |= route.Type.Modes()
+	}
+	sort.Slice(profile.Routes, func(i, j int) bool {
+		return profile.Routes[i].ID < profile.Routes[j].ID
+	})
+
+	if stop.Location.IsZero() {
+		return profile, nil
+	}
+	nearby, err := g.GetNearestStops(stop.Location, 0, transferDistance)
+	if err != nil {
+		return nil, err
+	}
+	for _, near := range nearby {
+		if near.Stop.ID == stopID {
+			continue
+		}
+
+		// Only stops which are served by a route can be transferred to
+		routeIDs, err := g.getStopRouteIDs(near.Stop.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(routeIDs) == 0 {
+			continue
+		}
+		profile.Transfers = append(profile.Transfers, StopTransfer{
+			Stop:     near.Stop,
+			Distance: near.Distance,
+			RouteIDs: routeIDs,
+		})
+	}
+
+	return profile, nil
+}
+
+// Returns the IDs of the routes with trips visiting the stop, sorted
+func (g *GTFS) getStopRouteIDs(stopID Key) ([]Key, error) {
+	trips, err := g.getTripsByStopID(stopID)
+	if err != nil {
+		return nil, err
+	}
+	routeIDs := []Key{}
+	for _, trip := range trips {
+		if !slices.Contains(routeIDs, trip.RouteID) {
+			routeIDs = append(routeIDs, trip.RouteID)
+		}
+	}
+	slices.Sort(routeIDs)
+	return routeIDs, nil
+}
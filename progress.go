@@ -0,0 +1,194 @@
+package gtfs
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Progress of a vehicle along a trip, estimated from an observed position
+type TripProgress struct {
+	TripID   Key        `json:"trip_id"`
+	Snapped  Coordinate `json:"snapped"`  // Observed position snapped to the trip's path
+	OffPath  float64    `json:"off_path"` // Metres from the observed position to the snapped position
+	Distance float64    `json:"distance"` // Metres travelled along the trip's path
+	Length   float64    `json:"length"`   // Total metres of the trip's path
+
+	// Indices in the trip's stops of the last stop passed and the next stop, or -1 before the
+	// first stop and after the last stop respectively
+	PreviousStopIndex int `json:"previous_stop_index"`
+	NextStopIndex     int `json:"next_stop_index"`
+
+	DistanceToNextStop float64 `json:"distance_to_next_stop"` // Metres along the path, zero after the last stop
+
+	// Scheduled time at the snapped position in seconds since the start of the service day,
+	// interpolated by distance between the previous and next stops
+	ScheduledTime uint `json:"scheduled_time"`
+
+	timezone *time.Location
+}
+
+// Returns the local equirectangular offset in metres of a coordinate from an origin
+func localOffset(origin, c Coordinate) (float64, float64) {
+	x := (c.Longitude - origin.Longitude) * metresPerDegree * math.Cos(origin.Latitude*math.Pi/180)
+	y := (c.Latitude - origin.Latitude) * metresPerDegree
+	return x, y
+}
+
+// A position projected onto a path
+type pathProjection struct {
+	segment  int // Index of the segment's first point
+	snapped  Coordinate
+	offPath  float64 // Metres from the position to the path
+	distance float64 // Metres along the path
+}
+
+// Returns the cumulative distance in metres along the path at each of its points
+func pathDistances(path CoordinateArray) []float64 {
+	distances := make([]float64, len(path))
+	for i := 1; i < len(path); i++ {
+		distances[i] = distances[i-1] + path[i-1].DistanceTo(path[i])
+	}
+	return distances
+}
+
+// Project the position onto the nearest point of the path, considering only segments from the given one onwards
+func projectOntoPath(path CoordinateArray, distances []float64, pos Coordinate, fromSegment int) pathProjection {
+	best := pathProjection{segment: -1, offPath: math.Inf(1)}
+	for i := fromSegment; i < len(path)-1; i++ {
+		a, b := path[i], path[i+1]
+		bx, by := localOffset(a, b)
+		px, py := localOffset(a, pos)
+
+		fraction := 0.0
+		if lengthSquared := bx*bx + by*by; lengthSquared > 0 {
+			fraction = math.Max(0, math.Min(1, (px*bx+py*by)/lengthSquared))
+		}
+		snapped := Coordinate{
+			Latitude:  a.Latitude + fraction*(b.Latitude-a.Latitude),
+			Longitude: a.Longitude + fraction*(b.Longitude-a.Longitude),
+		}
+		offPath := pos.DistanceTo(snapped)
+		if offPath < best.offPath {
+			best = pathProjection{
+				segment:  i,
+				snapped:  snapped,
+				offPath:  offPath,
+				distance: distances[i] + fraction*(distances[i+1]-distances[i]),
+			}
+		}
+	}
+	return best
+}
+
+// Snaps an observed vehicle position to the trip's shape (or the straight lines between its stops if it has
+// no shape) and returns the vehicle's progress: the stops either side of it, the distance travelled, and the
+// scheduled time at that point for estimating the schedule deviation with TripProgress.Deviation. Stops are
+// located along the path in order, so trips which pass the same place more than once are handled, but the
+// observed position is snapped to the nearest point of the whole path.
+func (g *GTFS) LocateTripProgress(tripID Key, pos Coordinate) (*TripProgress, error) {
+	trip, err := g.GetTripByID(tripID)
+	if err != nil {
+		return nil, err
+	}
+	if len(trip.Stops) == 0 {
+		return nil, errors.New("trip has no stops")
+	}
+	timezone, err := g.getFeedTimezone()
+	if err != nil {
+		return nil, err
+	}
+
+	stopIDs := make([]Key, len(trip.Stops))
+	for i, stop := range trip.Stops {
+		stopIDs[i] = stop.StopID
+	}
+	stops, err := g.GetStopsByIDs(stopIDs)
+	if err != nil {
+		return nil, err
+	}
+	stopLocations := make(CoordinateArray, len(trip.Stops))
+	for i, id := range stopIDs {
+		stop, ok := stops[id]
+		if !ok {
+			return nil, fmt.Errorf("stop %w", ErrNotFound)
+		}
+		stopLocations[i] = stop.Location
+	}
+
+	path := stopLocations
+	if trip.ShapeID != "" {
+		shape, err := g.GetShapeByID(trip.ShapeID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		if shape != nil && len(shape.Coordinates) >= 2 {
+			path = shape.Coordinates
+		}
+	}
+	if len(path) < 2 {
+		return nil, errors.New("trip has no path")
+	}
+	distances := pathDistances(path)
+
+	// Locate each stop along the path, after the previous stop
+	stopDistances := make([]float64, len(trip.Stops))
+	segment := 0
+	for i, location := range stopLocations {
+		projection := projectOntoPath(path, distances, location, segment)
+		stopDistances[i] = max(projection.distance, stopDistances[max(i-1, 0)])
+		segment = projection.segment
+	}
+
+	vehicle := projectOntoPath(path, distances, pos, 0)
+	progress := &TripProgress{
+		TripID:            tripID,
+		Snapped:           vehicle.snapped,
+		OffPath:           vehicle.offPath,
+		Distance:          vehicle.distance,
+		Length:            distances[len(distances)-1],
+		PreviousStopIndex: -1,
+		NextStopIndex:     -1,
+		timezone:          timezone,
+	}
+	for i, distance := range stopDistances {
+		if distance <= vehicle.distance {
+			progress.PreviousStopIndex = i
+		} else {
+			progress.NextStopIndex = i
+			break
+		}
+	}
+
+	previous, next := progress.PreviousStopIndex, progress.NextStopIndex
+	switch {
+	case previous == -1:
+		progress.DistanceToNextStop = stopDistances[next] - vehicle.distance
+		progress.ScheduledTime = trip.Stops[next].ArrivalTime
+	case next == -1:
+		progress.ScheduledTime = trip.Stops[previous].DepartureTime
+	default:
+		progress.DistanceToNextStop = stopDistances[next] - vehicle.distance
+		departure, arrival := trip.Stops[previous].DepartureTime, trip.Stops[next].ArrivalTime
+		fraction := (vehicle.distance - stopDistances[previous]) / (stopDistances[next] - stopDistances[previous])
+		progress.ScheduledTime = departure + uint(math.Round(fraction*float64(max(arrival, departure)-departure)))
+	}
+
+	return progress, nil
+}
+
+// Returns how far the vehicle is behind schedule (or ahead of it, if negative) when observed at the given
+// time, compared with the scheduled time at its position. The trip is assumed to run on whichever of the
+// observation's service day and the previous one (for trips running past midnight) gives the smaller deviation.
+func (p *TripProgress) Deviation(observed time.Time) time.Duration {
+	observed = observed.In(p.timezone)
+	day := serviceDayStart(observed, p.timezone)
+
+	deviation := observed.Sub(day.Add(time.Duration(p.ScheduledTime) * time.Second))
+	previousDay := observed.Sub(addServiceDays(day, -1).Add(time.Duration(p.ScheduledTime) * time.Second))
+	if previousDay.Abs() < deviation.Abs() {
+		return previousDay
+	}
+	return deviation
+}
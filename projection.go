@@ -0,0 +1,200 @@
+package gtfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Returned by a protobuf field visitor to stop once the wanted field has been read
+var errProtoFieldFound = errors.New("field found")
+
+// Returns the offset following the length-prefixed field at the offset
+func skipLengthPrefixed(data []byte, offset int) (int, error) {
+	if offset+lenBytes > len(data) {
+		return 0, errors.New("buffer too small for field length")
+	}
+	size := int(binary.BigEndian.Uint32(data[offset:]))
+	offset += lenBytes
+	if offset+size > len(data) {
+		return 0, errors.New("buffer too small for field content")
+	}
+	return offset + size, nil
+}
+
+// Read a single field of a protobuf message with the visitor, which returns errProtoFieldFound once it has
+// read the field
+func decodeProtoField(data []byte, fn func(num protowire.Number, v protoValue) error) error {
+	err := decodeProtoFields(data, fn)
+	if errors.Is(err, errProtoFieldFound) {
+		return nil
+	}
+	return err
+}
+
+// Decode only the location of an encoded stop
+func decodeStopLocation(data []byte, enc Encoding) (Coordinate, error) {
+	var location Coordinate
+	switch enc {
+	case BinaryEncoding:
+		// Skip Code, Name and ParentID
+		offset := 0
+		for range 3 {
+			var err error
+			offset, err = skipLengthPrefixed(data, offset)
+			if err != nil {
+				return location, err
+			}
+		}
+		coordinateSize := float64Bytes * 2
+		if offset+coordinateSize > len(data) {
+			return location, errors.New("stop buffer too small for Location data")
+		}
+		err := location.Decode(data[offset : offset+coordinateSize])
+		return location, err
+	case ProtobufEncoding:
+		err := decodeProtoField(data, func(num protowire.Number, v protoValue) error {
+			if num != 4 {
+				return nil
+			}
+			err := location.DecodeProto(v.bytes)
+			if err != nil {
+				return err
+			}
+			return errProtoFieldFound
+		})
+		return location, err
+	default:
+		return location, fmt.Errorf("unsupported encoding: %s", enc)
+	}
+}
+
+// Decode only the headsign of an encoded trip
+func decodeTripHeadsign(data []byte, enc Encoding) (string, error) {
+	switch enc {
+	case BinaryEncoding:
+		// Skip RouteID, ServiceID, ShapeID and Direction
+		offset := 0
+		for range 3 {
+			var err error
+			offset, err = skipLengthPrefixed(data, offset)
+			if err != nil {
+				return "", err
+			}
+		}
+		offset += boolBytes
+		end, err := skipLengthPrefixed(data, offset)
+		if err != nil {
+			return "", err
+		}
+		return string(data[offset+lenBytes : end]), nil
+	case ProtobufEncoding:
+		var headsign string
+		err := decodeProtoField(data, func(num protowire.Number, v protoValue) error {
+			if num != 5 {
+				return nil
+			}
+			headsign = v.string()
+			return errProtoFieldFound
+		})
+		return headsign, err
+	default:
+		return "", fmt.Errorf("unsupported encoding: %s", enc)
+	}
+}
+
+// Returns the locations of the stops with the given IDs, skipping any which do not exist. Only the
+// location of each stop is decoded.
+func (g *GTFS) GetStopLocations(stopIDs []Key) (map[Key]Coordinate, error) {
+	// Overridden and cached stops are read in full
+	_, cached := g.cachedBucket(StopEntityType)
+	if cached || len(g.overrides.all(StopEntityType)) > 0 {
+		stops, err := g.GetStopsByIDs(stopIDs)
+		if err != nil {
+			return nil, err
+		}
+		locations := make(map[Key]Coordinate, len(stops))
+		for id, stop := range stops {
+			locations[id] = stop.Location
+		}
+		return locations, nil
+	}
+
+	locations := make(map[Key]Coordinate, len(stopIDs))
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("stops"))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+		for _, stopID := range stopIDs {
+			data := b.Get([]byte(stopID))
+			if data == nil {
+				continue
+			}
+			g.countRead(tx, data)
+			location, err := decodeStopLocation(data, g.Encoding)
+			if err != nil {
+				return err
+			}
+			locations[stopID] = location
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return locations, nil
+}
+
+// Returns the headsigns of the route's trips by trip ID. Only the headsign of each trip is decoded.
+func (g *GTFS) GetTripHeadsigns(routeID Key) (map[Key]string, error) {
+	// Overridden and cached trips are read in full
+	_, cached := g.cachedBucket(TripEntityType)
+	if cached || len(g.overrides.all(TripEntityType)) > 0 {
+		trips, err := g.GetTripsByRouteID(routeID)
+		if err != nil {
+			return nil, err
+		}
+		headsigns := make(map[Key]string, len(trips))
+		for id, trip := range trips {
+			headsigns[id] = trip.Headsign
+		}
+		return headsigns, nil
+	}
+
+	tripIDs, err := g.getIndexedTripIDs("tripsByRouteIndex", []byte(routeID))
+	if err != nil {
+		return nil, err
+	}
+	if len(tripIDs) == 0 {
+		return nil, fmt.Errorf("trips for route %w", ErrNotFound)
+	}
+
+	headsigns := make(map[Key]string, len(tripIDs))
+	err = g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("trips"))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+		for _, tripID := range tripIDs {
+			data := b.Get([]byte(tripID))
+			if data == nil {
+				return fmt.Errorf("trip %w", ErrNotFound)
+			}
+			g.countRead(tx, data)
+			headsign, err := decodeTripHeadsign(data, g.Encoding)
+			if err != nil {
+				return err
+			}
+			headsigns[tripID] = headsign
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return headsigns, nil
+}
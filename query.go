@@ -0,0 +1,424 @@
+package gtfs
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Filters applied by FindRoutes, FindTrips and FindStops
+type queryFilter struct {
+	routeID    *Key
+	agencyID   *Key
+	direction  *TripDirection
+	start, end time.Time // Zero if no date range is given
+	modes      ModeFlag
+	routeTypes []RouteType // Nil if no route types are given
+	shapes     bool
+
+	maxOccupancy *uint
+	maxLoad      *float64
+}
+
+// An option filtering the results of FindRoutes, FindTrips and FindStops
+type QueryOption func(*queryFilter)
+
+// Only include trips of the route, or stops served by it
+func WithRoute(routeID Key) QueryOption {
+	return func(f *queryFilter) {
+		f.routeID = &routeID
+	}
+}
+
+// Only include trips of routes operated by the agency, or stops served by them
+func WithAgency(agencyID Key) QueryOption {
+	return func(f *queryFilter) {
+		f.agencyID = &agencyID
+	}
+}
+
+// Only include trips in the direction, or stops served by trips in it
+func WithDirection(direction TripDirection) QueryOption {
+	return func(f *queryFilter) {
+		f.direction = &direction
+	}
+}
+
+// Only include trips running on any service day between start and end (inclusive),
+// or stops served by such trips. If either is zero, the range covers the other's day only.
+func WithDateRange(start, end time.Time) QueryOption {
+	return func(f *queryFilter) {
+		if start.IsZero() {
+			start = end
+		}
+		if end.IsZero() {
+			end = start
+		}
+		f.start = start
+		f.end = end
+	}
+}
+
+// Only include trips of routes with any of the modes, or stops supporting any of them
+func WithModes(modes ModeFlag) QueryOption {
+	return func(f *queryFilter) {
+		f.modes = modes
+	}
+}
+
+// Only include trips of routes with any of the types, or stops served by such routes
+func WithRouteTypes(types ...RouteType) QueryOption {
+	return func(f *queryFilter) {
+		f.routeTypes = append([]RouteType{}, types...)
+	}
+}
+
+// Only include trips whose average occupancy never exceeds the given number of passengers, or stops served
+// by such trips. Trips without occupancy data are included.
+func WithMaxOccupancy(passengers uint) QueryOption {
+	return func(f *queryFilter) {
+		f.maxOccupancy = &passengers
+	}
+}
+
+// Only include trips whose peak occupancy is at most the given fraction of their capacity (see Trip.PeakLoad),
+// or stops served by such trips. Trips without occupancy or capacity data are included.
+func WithMaxLoad(load float64) QueryOption {
+	return func(f *queryFilter) {
+		f.maxLoad = &load
+	}
+}
+
+// Load the geometry of the routes' shapes into the results of FindRoutes, reading all the shapes at once.
+// Ignored by FindTrips and FindStops.
+func WithShapes() QueryOption {
+	return func(f *queryFilter) {
+		f.shapes = true
+	}
+}
+
+// Build the filter from the options
+func newQueryFilter(opts []QueryOption) *queryFilter {
+	f := &queryFilter{}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// Check whether the filter has options which only match some trips
+func (f *queryFilter) filtersTrips() bool {
+	return f.direction != nil || !f.start.IsZero() || f.maxOccupancy != nil || f.maxLoad != nil
+}
+
+// Check whether the trip is within the occupancy and load limits, if known
+func (f *queryFilter) matchesCrowding(trip *Trip) bool {
+	if f.maxOccupancy != nil {
+		if peak, ok := trip.PeakOccupancy(); ok && peak > *f.maxOccupancy {
+			return false
+		}
+	}
+	if f.maxLoad != nil {
+		if load, ok := trip.PeakLoad(); ok && load > *f.maxLoad {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the modes served by routes of the type
+func (t RouteType) Modes() ModeFlag {
+	switch t {
+	case BusRouteType, TrolleybusRouteType:
+		return BusModeFlag
+	case TramRouteType, SubwayRouteType, RailRouteType, MonorailRouteType:
+		return RailModeFlag
+	case FerryRouteType:
+		return FerryModeFlag
+	default:
+		return UnknownModeFlag
+	}
+}
+
+// Check whether the route matches the agency, mode and route type filters
+func (f *queryFilter) matchesRoute(route *Route) bool {
+	if f.agencyID != nil && route.AgencyID != *f.agencyID {
+		return false
+	}
+	if f.modes != 0 && route.Type.Modes()&f.modes == 0 {
+		return false
+	}
+	if f.routeTypes != nil && !slices.Contains(f.routeTypes, route.Type) {
+		return false
+	}
+	return true
+}
+
+// Returns the trips of all routes with any of the given types
+func (g *GTFS) getTripsByRouteTypes(types []RouteType) (TripMap, error) {
+	routes, err := g.GetRoutesByType(types...)
+	if err != nil {
+		return nil, err
+	}
+
+	trips := make(TripMap)
+	for routeID := range routes {
+		routeTrips, err := g.GetTripsByRouteID(routeID)
+		if err != nil {
+			// Routes without any trips contribute none
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		maps.Copy(trips, routeTrips)
+	}
+	return trips, nil
+}
+
+// Returns the routes matching all of the options. Trip filters (direction, date range and crowding) match the
+// routes of the matching trips. With the WithShapes option, each route's shapes are loaded into copies
+// of the routes, so that routes shared with other queries are not modified.
+func (g *GTFS) FindRoutes(opts ...QueryOption) (RouteMap, error) {
+	f := newQueryFilter(opts)
+
+	var routes RouteMap
+	var err error
+	if f.filtersTrips() {
+		trips, err := g.findTrips(f)
+		if err != nil {
+			return nil, err
+		}
+
+		routeIDs := make(map[Key]bool)
+		for _, trip := range trips {
+			routeIDs[trip.RouteID] = true
+		}
+		ids := make([]Key, 0, len(routeIDs))
+		for id := range routeIDs {
+			ids = append(ids, id)
+		}
+		routes, err = g.GetRoutesByIDs(ids)
+		if err != nil {
+			return nil, err
+		}
+	} else if f.routeID != nil {
+		route, err := g.GetRouteByID(*f.routeID)
+		if err != nil {
+			return nil, err
+		}
+		routes = RouteMap{route.ID: route}
+	} else if f.agencyID != nil {
+		routes, err = g.GetRoutesByAgencyID(*f.agencyID)
+	} else if f.routeTypes != nil {
+		routes, err = g.GetRoutesByType(f.routeTypes...)
+	} else {
+		routes, err = g.GetAllRoutes()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for id, route := range routes {
+		if !f.matchesRoute(route) {
+			delete(routes, id)
+		}
+	}
+
+	if f.shapes {
+		var shapeIDs []Key
+		for _, route := range routes {
+			shapeIDs = append(shapeIDs, route.shapeIDs()...)
+		}
+		shapes, err := g.GetShapesByIDs(shapeIDs)
+		if err != nil {
+			return nil, err
+		}
+		for id, route := range routes {
+			loaded := *route
+			loaded.attachShapes(shapes)
+			routes[id] = &loaded
+		}
+	}
+
+	return routes, nil
+}
+
+// Returns the trips matching all of the options. Trips of a single route, or of the routes of
+// the given agency or types, are looked up using the route indexes, rather than reading every trip.
+func (g *GTFS) FindTrips(opts ...QueryOption) (TripMap, error) {
+	return g.findTrips(newQueryFilter(opts))
+}
+
+func (g *GTFS) findTrips(f *queryFilter) (TripMap, error) {
+	var trips TripMap
+	var err error
+	if f.routeID != nil {
+		trips, err = g.GetTripsByRouteID(*f.routeID)
+	} else if f.agencyID != nil {
+		trips, err = g.GetTripsByAgencyID(*f.agencyID)
+	} else if f.routeTypes != nil {
+		trips, err = g.getTripsByRouteTypes(f.routeTypes)
+	} else {
+		trips, err = g.GetAllTrips()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if f.direction != nil {
+		for id, trip := range trips {
+			if trip.Direction != *f.direction {
+				delete(trips, id)
+			}
+		}
+	}
+
+	if f.maxOccupancy != nil || f.maxLoad != nil {
+		for id, trip := range trips {
+			if !f.matchesCrowding(trip) {
+				delete(trips, id)
+			}
+		}
+	}
+
+	if f.modes != 0 || f.routeTypes != nil || f.agencyID != nil {
+		routeIDs := make(map[Key]bool)
+		for _, trip := range trips {
+			routeIDs[trip.RouteID] = true
+		}
+		ids := make([]Key, 0, len(routeIDs))
+		for id := range routeIDs {
+			ids = append(ids, id)
+		}
+		routes, err := g.GetRoutesByIDs(ids)
+		if err != nil {
+			return nil, err
+		}
+
+		for id, trip := range trips {
+			route, ok := routes[trip.RouteID]
+			if !ok || !f.matchesRoute(route) {
+				delete(trips, id)
+			}
+		}
+	}
+
+	if !f.start.IsZero() {
+		err = g.filterTripsByDateRange(trips, f.start, f.end)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return trips, nil
+}
+
+// Remove the trips which do not run on any service day between start and end (inclusive)
+func (g *GTFS) filterTripsByDateRange(trips TripMap, start, end time.Time) error {
+	loc, err := g.getFeedTimezone()
+	if err != nil {
+		return err
+	}
+
+	running := make(map[Key]bool) // trip id -> running on any day
+	for _, noon := range serviceDayNoons(start, end, loc) {
+		cache := make(map[Key]bool)
+		for id, trip := range trips {
+			if running[id] {
+				continue
+			}
+			isRunning, err := g.isTripRunning(trip, noon, cache)
+			if err != nil {
+				return err
+			}
+			if isRunning {
+				running[id] = true
+			}
+		}
+	}
+
+	for id := range trips {
+		if !running[id] {
+			delete(trips, id)
+		}
+	}
+	return nil
+}
+
+// Returns the stops matching all of the options. Stops of a single route, or of the routes of the given types,
+// are those visited by any of their trips, and trip filters (agency, direction, date range and crowding) match
+// the stops served by the matching trips.
+func (g *GTFS) FindStops(opts ...QueryOption) (StopMap, error) {
+	f := newQueryFilter(opts)
+
+	var stops StopMap
+	var err error
+	if f.agencyID != nil || f.filtersTrips() {
+		// Modes are matched against the stops themselves rather than their trips' routes
+		tripFilter := *f
+		tripFilter.modes = 0
+		trips, err := g.findTrips(&tripFilter)
+		if err != nil {
+			return nil, err
+		}
+		stops, err = g.getTripStops(trips)
+		if err != nil {
+			return nil, err
+		}
+	} else if f.routeID != nil {
+		route, err := g.GetRouteByID(*f.routeID)
+		if err != nil {
+			return nil, err
+		}
+		if f.routeTypes != nil && !slices.Contains(f.routeTypes, route.Type) {
+			return StopMap{}, nil
+		}
+
+		// Routes without any trips serve no stops
+		trips, err := g.GetTripsByRouteID(route.ID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		stops, err = g.getTripStops(trips)
+		if err != nil {
+			return nil, err
+		}
+	} else if f.routeTypes != nil {
+		trips, err := g.getTripsByRouteTypes(f.routeTypes)
+		if err != nil {
+			return nil, err
+		}
+		stops, err = g.getTripStops(trips)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		stops, err = g.GetAllStops()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if f.modes != 0 {
+		for id, stop := range stops {
+			if stop.SupportedModes&f.modes == 0 {
+				delete(stops, id)
+			}
+		}
+	}
+
+	return stops, nil
+}
+
+// Returns the stops visited by any of the trips, following every pattern of their routes
+func (g *GTFS) getTripStops(trips TripMap) (StopMap, error) {
+	stopIDs := make(map[Key]bool)
+	for _, trip := range trips {
+		for _, stop := range trip.Stops {
+			stopIDs[stop.StopID] = true
+		}
+	}
+	return g.GetStopsByIDs(slices.Collect(maps.Keys(stopIDs)))
+}
@@ -0,0 +1,116 @@
+package gtfs
+
+import (
+	"time"
+)
+
+// A realtime update to the schedule of a single trip, e.g. from a GTFS-RT TripUpdate or SIRI feed
+type TripUpdate struct {
+	TripID    Key
+	StartDate time.Time // Service day the update applies to, or zero to apply to any day
+	Canceled  bool
+
+	// Delay applied from the first stop of the trip, until overridden by a stop delay
+	Delay time.Duration
+	// Delays at specific stops, keyed by stop ID, which also apply to all following stops
+	// until overridden by another stop delay
+	StopDelays map[Key]time.Duration
+}
+
+// A realtime position of a vehicle serving a trip
+type VehiclePosition struct {
+	TripID    Key
+	VehicleID Key
+	Location  Coordinate
+	Bearing   float64 // Degrees clockwise from north
+	Timestamp time.Time
+}
+
+// A provider of realtime trip updates and vehicle positions, such as a GTFS-RT, SIRI
+// or agency-specific API client. Both methods return the current data keyed by trip ID.
+type RealtimeSource interface {
+	GetTripUpdates() (map[Key]*TripUpdate, error)
+	GetVehiclePositions() (map[Key]*VehiclePosition, error)
+}
+
+// Attaches a realtime source, whose updates are applied to the results of trip time queries
+// such as GetCurrentTrips and Isochrone. Passing nil detaches the current source.
+// This must not be called concurrently with queries.
+func (g *GTFS) AttachRealtimeSource(src RealtimeSource) {
+	g.realtime = src
+}
+
+// Returns the current vehicle positions from the attached realtime source, keyed by trip ID
+func (g *GTFS) GetVehiclePositions() (map[Key]*VehiclePosition, error) {
+	if g.realtime == nil {
+		return map[Key]*VehiclePosition{}, nil
+	}
+	return g.realtime.GetVehiclePositions()
+}
+
+// Applies the updates from the attached realtime source to trips running on the service day starting at the given time.
+// Canceled trips are removed, and delayed trips are replaced with adjusted copies; the given
+// trips are not modified. If no source is attached, the trips are returned unchanged.
+func (g *GTFS) applyRealtime(trips TripMap, day time.Time) (TripMap, error) {
+	if g.realtime == nil {
+		return trips, nil
+	}
+
+	updates, err := g.realtime.GetTripUpdates()
+	if err != nil {
+		return nil, err
+	}
+	if len(updates) == 0 {
+		return trips, nil
+	}
+
+	// The start of a service day falls on the previous calendar day if clocks go forward overnight
+	year, month, date := day.Add(12 * time.Hour).Date()
+	adjusted := make(TripMap, len(trips))
+	for tripID, trip := range trips {
+		update, ok := updates[tripID]
+		if ok && !update.StartDate.IsZero() {
+			updateYear, updateMonth, updateDate := update.StartDate.Date()
+			ok = updateYear == year && updateMonth == month && updateDate == date
+		}
+		if !ok {
+			adjusted[tripID] = trip
+			continue
+		}
+
+		if update.Canceled {
+			continue
+		}
+		adjusted[tripID] = applyTripUpdate(trip, update)
+	}
+
+	return adjusted, nil
+}
+
+// Returns a copy of the trip with the update's delays applied to its stop times
+func applyTripUpdate(trip *Trip, update *TripUpdate) *Trip {
+	adjusted := *trip
+	adjusted.Stops = make(TripStopArray, len(trip.Stops))
+
+	delay := update.Delay
+	for i, stop := range trip.Stops {
+		if stopDelay, ok := update.StopDelays[stop.StopID]; ok {
+			delay = stopDelay
+		}
+
+		adjustedStop := *stop
+		adjustedStop.ArrivalTime = delayTime(stop.ArrivalTime, delay)
+		adjustedStop.DepartureTime = delayTime(stop.DepartureTime, delay)
+		adjusted.Stops[i] = &adjustedStop
+	}
+	return &adjusted
+}
+
+// Shifts a time in seconds since midnight by the given delay, clamping at midnight
+func delayTime(seconds uint, delay time.Duration) uint {
+	shifted := int(seconds) + int(delay.Seconds())
+	if shifted < 0 {
+		return 0
+	}
+	return uint(shifted)
+}
@@ -0,0 +1,326 @@
+package gtfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Type of change reported by a change event
+type ChangeType uint8
+
+const (
+	RoutesAddedChangeType ChangeType = iota
+	RoutesRemovedChangeType
+	RoutesChangedChangeType
+	TripsAddedChangeType
+	TripsRemovedChangeType
+	TripsChangedChangeType
+	StopsAddedChangeType
+	StopsRemovedChangeType
+	StopsChangedChangeType
+	StopMovedChangeType
+)
+
+// Returns the name of the change type, as used in logs
+func (t ChangeType) String() string {
+	switch t {
+	case RoutesAddedChangeType:
+		return "RoutesAdded"
+	case RoutesRemovedChangeType:
+		return "RoutesRemoved"
+	case RoutesChangedChangeType:
+		return "RoutesChanged"
+	case TripsAddedChangeType:
+		return "TripsAdded"
+	case TripsRemovedChangeType:
+		return "TripsRemoved"
+	case TripsChangedChangeType:
+		return "TripsChanged"
+	case StopsAddedChangeType:
+		return "StopsAdded"
+	case StopsRemovedChangeType:
+		return "StopsRemoved"
+	case StopsChangedChangeType:
+		return "StopsChanged"
+	case StopMovedChangeType:
+		return "StopMoved"
+	default:
+		return "Unknown"
+	}
+}
+
+// A change between the database before and after a refresh
+type ChangeEvent struct {
+	Type ChangeType `json:"type"`
+	IDs  []Key      `json:"ids"` // Affected entities, sorted. A StopMoved event has the ID of the moved stop only.
+
+	// Previous and new location of the stop, and the distance between them in metres, for StopMoved events
+	From     Coordinate `json:"from"`
+	To       Coordinate `json:"to"`
+	Distance float64    `json:"distance,omitempty"`
+}
+
+// Subscribers to the change events of a database
+type changeNotifier struct {
+	mu          sync.Mutex
+	subscribers map[*changeSubscriber]bool
+}
+
+type changeSubscriber struct {
+	events chan ChangeEvent
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Returns a notifier without any subscribers
+func newChangeNotifier() *changeNotifier {
+	return &changeNotifier{subscribers: make(map[*changeSubscriber]bool)}
+}
+
+// Subscribes to the change events published when the database is refreshed. Events are delivered in order
+// on the returned channel, and a refresh waits for each subscriber to receive them, so subscribers should keep
+// receiving until they unsubscribe by calling the returned function. The channel is not closed on unsubscribing.
+// Events are only published once the previous database is closed, which waits for any snapshots of it to be
+// closed. The database must be open, and subscribing is safe while it is being refreshed.
+func (g *GTFS) Subscribe() (<-chan ChangeEvent, func()) {
+	notifier := g.notifier
+	subscriber := &changeSubscriber{
+		events: make(chan ChangeEvent, 16),
+		done:   make(chan struct{}),
+	}
+
+	notifier.mu.Lock()
+	notifier.subscribers[subscriber] = true
+	notifier.mu.Unlock()
+
+	return subscriber.events, func() {
+		subscriber.once.Do(func() {
+			close(subscriber.done)
+			notifier.mu.Lock()
+			delete(notifier.subscribers, subscriber)
+			notifier.mu.Unlock()
+		})
+	}
+}
+
+// Delivers the events to each subscriber in turn
+func (n *changeNotifier) publish(events []ChangeEvent) {
+	if n == nil || len(events) == 0 {
+		return
+	}
+
+	n.mu.Lock()
+	subscribers := make([]*changeSubscriber, 0, len(n.subscribers))
+	for subscriber := range n.subscribers {
+		subscribers = append(subscribers, subscriber)
+	}
+	n.mu.Unlock()
+
+	for _, subscriber := range subscribers {
+	deliver:
+		for _, event := range events {
+			select {
+			case subscriber.events <- event:
+			case <-subscriber.done:
+				break deliver
+			}
+		}
+	}
+}
+
+// Downloads the GTFS data from the URL again and replaces the database with it, as with FromURLReport.
+// The new database is built alongside the current one, which remains usable until it is swapped in. The
+// differences between the two are then published to subscribers as change events (see Subscribe).
+// Warmed up entities are discarded, while overrides, applied service changes, application metadata and an
+// attached realtime source are kept. The new database is opened with the current one's options before the
+// current one is closed, so a failed refresh leaves the current database in use. Closing the current database
+// waits for any snapshots of it to be closed, so snapshots should be short-lived. Changes are found between
+// the ingested data of the two, ignoring overrides. Queries must not run concurrently with a refresh.
+func (g *GTFS) Refresh(gtfsURL string, opts IngestOptions) (*IngestReport, error) {
+	return g.refresh(opts, func(next *GTFS, dbFile string, opts IngestOptions) (*IngestReport, error) {
+		return next.FromURLReport(gtfsURL, dbFile, opts)
+	})
+}
+
+// Replaces the database with an already parsed feed as with Refresh, publishing the differences to subscribers
+func (g *GTFS) RefreshFromFeed(feed *Feed, opts IngestOptions) error {
+	_, err := g.refresh(opts, func(next *GTFS, dbFile string, opts IngestOptions) (*IngestReport, error) {
+		return nil, next.FromFeed(feed, dbFile, opts)
+	})
+	return err
+}
+
+// Refreshes the database from the URL every interval until the context is cancelled, as with Refresh.
+// Failed refreshes are logged and leave the current database in place until the next attempt.
+func (g *GTFS) AutoRefresh(ctx context.Context, gtfsURL string, interval time.Duration, opts IngestOptions) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := g.Refresh(gtfsURL, opts)
+			if err != nil {
+				log.Errorf("Failed to refresh GTFS data from %s: %v", gtfsURL, err)
+			}
+		}
+	}
+}
+
+// Builds the new database with the given function, swaps it in place of the current one and publishes the changes
+func (g *GTFS) refresh(opts IngestOptions, build func(next *GTFS, dbFile string, opts IngestOptions) (*IngestReport, error)) (*IngestReport, error) {
+	if g.db == nil {
+		return nil, errors.New("database not open")
+	}
+	if g.tx != nil {
+		return nil, errors.New("cannot refresh a snapshot")
+	}
+
+	// The current database is archived when the new one is swapped in, rather than while building it
+	dbFile := g.filePath
+	nextFile := dbFile + ".next"
+	archiveVersions := opts.ArchiveVersions
+	opts.ArchiveVersions = 0
+
+	os.Remove(nextFile)
+	next := &GTFS{}
+	report, err := build(next, nextFile, opts)
+	if err != nil {
+		next.Close()
+		os.Remove(nextFile)
+		return report, err
+	}
+
+	events, err := diffDatabases(g.ingested(), next)
+	next.Close()
+	if err == nil {
+		err = g.copyAppMetadata(nextFile, g.dbOptions)
+	}
+	if err != nil {
+		os.Remove(nextFile)
+		return report, err
+	}
+
+	// Open the new database before touching the current one, so that a failure leaves it in place
+	opened := &GTFS{}
+	err = opened.FromDBWithOptions(nextFile, g.dbOptions)
+	if err != nil {
+		os.Remove(nextFile)
+		return report, err
+	}
+
+	// Files can be moved while they are open, so the current database keeps working until the swap
+	if archiveVersions > 0 {
+		err = archiveCreatedDB(dbFile, g.Created, archiveVersions)
+		if err != nil {
+			opened.Close()
+			os.Remove(nextFile)
+			return report, err
+		}
+	}
+	err = os.Rename(nextFile, dbFile)
+	if err != nil {
+		opened.Close()
+		os.Remove(nextFile)
+		return report, err
+	}
+
+	// Swap the new database in, keeping the layers which are not stored in it
+	g.handle.Lock()
+	previous := g.db
+	g.db = opened.db
+	g.Version = opened.Version
+	g.Created = opened.Created
+	g.Encoding = opened.Encoding
+	g.locations = opened.locations
+	g.serviceDays = opened.serviceDays
+	g.numericIDs = opened.numericIDs
+	g.cache = opened.cache
+	g.handle.Unlock()
+
+	// Closing waits for any transactions still reading the previous database
+	err = previous.Close()
+	if err != nil {
+		log.Warnf("Failed to close the previous GTFS database: %v", err)
+	}
+
+	log.Infof("Refreshed GTFS data at %s with %d changes", dbFile, len(events))
+	g.notifier.publish(events)
+	return report, nil
+}
+
+// Returns a view of the database's ingested data, without overrides
+func (g *GTFS) ingested() *GTFS {
+	raw := *g
+	raw.overrides = nil
+	return &raw
+}
+
+// Returns the change events between the routes, trips and stops of two databases
+func diffDatabases(before, after *GTFS) ([]ChangeEvent, error) {
+	events := []ChangeEvent{}
+	appendEvents := func(added, removed, changed []Key, addedType, removedType, changedType ChangeType) {
+		for _, event := range []ChangeEvent{{Type: addedType, IDs: added}, {Type: removedType, IDs: removed}, {Type: changedType, IDs: changed}} {
+			if len(event.IDs) > 0 {
+				events = append(events, event)
+			}
+		}
+	}
+
+	beforeRoutes, err := before.GetAllRoutes()
+	if err != nil {
+		return nil, err
+	}
+	afterRoutes, err := after.GetAllRoutes()
+	if err != nil {
+		return nil, err
+	}
+	added, removed, changed := diffEntities(beforeRoutes, afterRoutes, encodingChanged)
+	appendEvents(added, removed, changed, RoutesAddedChangeType, RoutesRemovedChangeType, RoutesChangedChangeType)
+
+	beforeTrips, err := before.GetAllTrips()
+	if err != nil {
+		return nil, err
+	}
+	afterTrips, err := after.GetAllTrips()
+	if err != nil {
+		return nil, err
+	}
+	added, removed, changed = diffEntities(beforeTrips, afterTrips, encodingChanged)
+	appendEvents(added, removed, changed, TripsAddedChangeType, TripsRemovedChangeType, TripsChangedChangeType)
+
+	beforeStops, err := before.GetAllStops()
+	if err != nil {
+		return nil, err
+	}
+	afterStops, err := after.GetAllStops()
+	if err != nil {
+		return nil, err
+	}
+	added, removed, changed = diffEntities(beforeStops, afterStops, encodingChanged)
+	appendEvents(added, removed, changed, StopsAddedChangeType, StopsRemovedChangeType, StopsChangedChangeType)
+
+	// Changed stops are also reported individually if they moved
+	for _, id := range changed {
+		if !stopMoved(beforeStops[id], afterStops[id]) {
+			continue
+		}
+		from, to := beforeStops[id].Location, afterStops[id].Location
+		events = append(events, ChangeEvent{
+			Type:     StopMovedChangeType,
+			IDs:      []Key{id},
+			From:     from,
+			To:       to,
+			Distance: from.DistanceTo(to),
+		})
+	}
+
+	return events, nil
+}
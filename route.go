@@ -2,11 +2,12 @@ package gtfs
 
 import (
 	"encoding/binary"
-	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
 	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 type RouteType uint8
@@ -26,14 +27,21 @@ const (
 
 // Represents a route in a transit system
 type Route struct {
-	ID              Key
-	AgencyID        Key
-	Name            string
-	Type            RouteType
-	Colour          string
-	InboundShapeID  *Key
-	OutboundShapeID *Key
-	Stops           KeyArray
+	ID              Key       `json:"route_id"`
+	AgencyID        Key       `json:"agency_id"`
+	Name            string    `json:"route_name"`
+	Type            RouteType `json:"route_type"`
+	Colour          string    `json:"route_color"`
+	InboundShapeID  *Key      `json:"inbound_shape_id,omitempty"`
+	OutboundShapeID *Key      `json:"outbound_shape_id,omitempty"`
+	Stops           KeyArray  `json:"stops"`
+	InboundStops    KeyArray  `json:"inbound_stops"`  // Stops of the canonical inbound pattern, in travel order
+	OutboundStops   KeyArray  `json:"outbound_stops"` // Stops of the canonical outbound pattern, in travel order
+
+	// Geometry of the inbound and outbound shapes, only set once loaded with LoadShapes or the WithShapes
+	// query option. They are not stored in the database.
+	InboundShape  *Shape `json:"inbound_shape,omitempty"`
+	OutboundShape *Shape `json:"outbound_shape,omitempty"`
 }
 type RouteMap map[Key]*Route
 
@@ -45,7 +53,9 @@ type RouteMap map[Key]*Route
 // - Colour: 4-byte length + UTF-8 string
 // - InboundShapeID: 4-byte length + UTF-8 string
 // - OutboundShapeID: 4-byte length + UTF-8 string
-// - Stops: KeyArray (encoded as a byte slice)
+// - Stops: 4-byte length + KeyArray
+// - InboundStops: 4-byte length + KeyArray
+// - OutboundStops: 4-byte length + KeyArray
 func (r Route) Encode() []byte {
 	agencyIDStr := string(r.AgencyID)
 	nameStr := r.Name
@@ -59,8 +69,10 @@ func (r Route) Encode() []byte {
 		outboundShapeIDStr = string(*r.OutboundShapeID)
 	}
 
-	// Encode Stops field first to get its byte representation and length
+	// Encode stop arrays first to get their byte representations and lengths
 	stopsBytes := r.Stops.Encode()
+	inboundStopsBytes := r.InboundStops.Encode()
+	outboundStopsBytes := r.OutboundStops.Encode()
 
 	// Calculate total length for fixed fields + length of encoded stops
 	totalLen := lenBytes + len(agencyIDStr) + // AgencyID
@@ -69,7 +81,9 @@ func (r Route) Encode() []byte {
 		lenBytes + len(colourStr) + // Colour
 		lenBytes + len(inboundShapeIDStr) + // InboundShapeID
 		lenBytes + len(outboundShapeIDStr) + // OutboundShapeID
-		len(stopsBytes) // Length of encoded Stops data
+		lenBytes + len(stopsBytes) + // Stops
+		lenBytes + len(inboundStopsBytes) + // InboundStops
+		lenBytes + len(outboundStopsBytes) // OutboundStops
 
 	data := make([]byte, totalLen)
 	offset := 0
@@ -108,8 +122,22 @@ func (r Route) Encode() []byte {
 	copy(data[offset:], outboundShapeIDStr)
 	offset += len(outboundShapeIDStr)
 
-	// Append encoded Stops data
+	// Marshal Stops
+	binary.BigEndian.PutUint32(data[offset:], uint32(len(stopsBytes)))
+	offset += lenBytes
 	copy(data[offset:], stopsBytes)
+	offset += len(stopsBytes)
+
+	// Marshal InboundStops
+	binary.BigEndian.PutUint32(data[offset:], uint32(len(inboundStopsBytes)))
+	offset += lenBytes
+	copy(data[offset:], inboundStopsBytes)
+	offset += len(inboundStopsBytes)
+
+	// Marshal OutboundStops
+	binary.BigEndian.PutUint32(data[offset:], uint32(len(outboundStopsBytes)))
+	offset += lenBytes
+	copy(data[offset:], outboundStopsBytes)
 
 	return data
 }
@@ -201,32 +229,155 @@ func (r *Route) Decode(id Key, data []byte) error {
 		r.OutboundShapeID = nil
 	}
 
-	// The rest of the data belongs to Stops
-	if offset > len(data) {
-		return errors.New("offset beyond data length before decoding Stops")
+	// Unmarshal Stops, InboundStops and OutboundStops
+	for _, field := range []struct {
+		name  string
+		stops *KeyArray
+	}{
+		{"Stops", &r.Stops},
+		{"InboundStops", &r.InboundStops},
+		{"OutboundStops", &r.OutboundStops},
+	} {
+		if offset+lenBytes > len(data) {
+			return fmt.Errorf("buffer too small for %s length", field.name)
+		}
+		stopsLen := binary.BigEndian.Uint32(data[offset:])
+		offset += lenBytes
+		if offset+int(stopsLen) > len(data) {
+			return fmt.Errorf("buffer too small for %s content", field.name)
+		}
+		err := field.stops.Decode(data[offset : offset+int(stopsLen)])
+		if err != nil {
+			return fmt.Errorf("failed to decode %s: %w", field.name, err)
+		}
+		offset += int(stopsLen)
 	}
-	stopsData := data[offset:]
-	err := r.Stops.Decode(stopsData)
-	if err != nil {
-		return fmt.Errorf("failed to decode Stops: %w", err)
+
+	if offset != len(data) {
+		return errors.New("route buffer not fully consumed, trailing data exists")
 	}
+	return nil
+}
+
+// EncodeProto serializes the Route struct (excluding ID) into protobuf wire format.
+// See the Route message in gtfs.proto.
+func (r Route) EncodeProto() []byte {
+	var data []byte
+	data = appendProtoString(data, 1, string(r.AgencyID))
+	data = appendProtoString(data, 2, r.Name)
+	data = appendProtoVarint(data, 3, uint64(r.Type))
+	data = appendProtoString(data, 4, r.Colour)
+	if r.InboundShapeID != nil {
+		data = appendProtoString(data, 5, string(*r.InboundShapeID))
+	}
+	if r.OutboundShapeID != nil {
+		data = appendProtoString(data, 6, string(*r.OutboundShapeID))
+	}
+	for _, stopID := range r.Stops {
+		data = protowire.AppendTag(data, 7, protowire.BytesType)
+		data = protowire.AppendString(data, string(stopID))
+	}
+	for _, stopID := range r.InboundStops {
+		data = protowire.AppendTag(data, 8, protowire.BytesType)
+		data = protowire.AppendString(data, string(stopID))
+	}
+	for _, stopID := range r.OutboundStops {
+		data = protowire.AppendTag(data, 9, protowire.BytesType)
+		data = protowire.AppendString(data, string(stopID))
+	}
+	return data
+}
 
+// DecodeProto deserializes protobuf wire format data into the Route struct.
+func (r *Route) DecodeProto(id Key, data []byte) error {
+	if r == nil {
+		return errors.New("cannot decode into a nil Route")
+	}
+	*r = Route{ID: id, Stops: KeyArray{}, InboundStops: KeyArray{}, OutboundStops: KeyArray{}}
+
+	return decodeProtoFields(data, func(num protowire.Number, v protoValue) error {
+		switch num {
+		case 1:
+			r.AgencyID = Key(v.string())
+		case 2:
+			r.Name = v.string()
+		case 3:
+			r.Type = RouteType(v.varint)
+		case 4:
+			r.Colour = v.string()
+		case 5:
+			inboundShapeID := Key(v.string())
+			r.InboundShapeID = &inboundShapeID
+		case 6:
+			outboundShapeID := Key(v.string())
+			r.OutboundShapeID = &outboundShapeID
+		case 7:
+			r.Stops.Append(Key(v.string()))
+		case 8:
+			r.InboundStops.Append(Key(v.string()))
+		case 9:
+			r.OutboundStops.Append(Key(v.string()))
+		}
+		return nil
+	})
+}
+
+// Returns the IDs of the route's inbound and outbound shapes, if any
+func (r *Route) shapeIDs() []Key {
+	ids := []Key{}
+	if r.InboundShapeID != nil {
+		ids = append(ids, *r.InboundShapeID)
+	}
+	if r.OutboundShapeID != nil {
+		ids = append(ids, *r.OutboundShapeID)
+	}
+	return ids
+}
+
+// Set the route's inbound and outbound shapes from the loaded shapes, leaving those not found unset
+func (r *Route) attachShapes(shapes ShapeMap) {
+	if r.InboundShapeID != nil {
+		r.InboundShape = shapes[*r.InboundShapeID]
+	}
+	if r.OutboundShapeID != nil {
+		r.OutboundShape = shapes[*r.OutboundShapeID]
+	}
+}
+
+// Loads the geometry of the route's inbound and outbound shapes into InboundShape and OutboundShape.
+// Shapes missing from the database are left unset. To load the shapes of many routes at once, use
+// FindRoutes with the WithShapes option.
+func (r *Route) LoadShapes(g *GTFS) error {
+	shapes, err := g.GetShapesByIDs(r.shapeIDs())
+	if err != nil {
+		return err
+	}
+	r.attachShapes(shapes)
 	return nil
 }
 
 // Load and parse routes from the GTFS routes.txt file
 func ParseRoutes(file io.Reader) (RouteMap, error) {
-	// Read file using CSV reader
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	routes, _, err := ParseRoutesWithOptions(file, ParseOptions{})
+	return routes, err
+}
+
+// Load and parse routes from the GTFS routes.txt file, handling malformed rows according to the given options
+func ParseRoutesWithOptions(file io.Reader, opts ParseOptions) (RouteMap, *FileReport, error) {
+	// Read file using CSV parser
+	parser, err := newCSVParser("routes.txt", file, opts)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	routes := make(RouteMap)
-	for i, record := range records {
-		if i == 0 {
-			continue // skip header
+	for {
+		record, err := parser.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
 		}
 
 		// Parse record into Route struct
@@ -239,11 +390,21 @@ func ParseRoutes(file io.Reader) (RouteMap, error) {
 
 		typeInt, err := strconv.Atoi(record[5])
 		if err != nil {
-			return nil, err
+			if !parser.canRepair() {
+				if err := parser.skip(parser.spec("invalid_integer", "routes.route_type must be an integer", err)); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			typeInt = int(BusRouteType)
+			parser.repair("invalid route_type %q, defaulted to bus", record[5])
 		}
 		typeRoute := RouteType(typeInt)
 		colour := record[7]
 
+		if _, exists := routes[id]; exists {
+			parser.duplicate(string(id))
+		}
 		routes[id] = &Route{
 			ID:       id,
 			AgencyID: agencyID,
@@ -253,5 +414,5 @@ func ParseRoutes(file io.Reader) (RouteMap, error) {
 		}
 	}
 
-	return routes, nil
+	return routes, parser.report, nil
 }
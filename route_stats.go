@@ -0,0 +1,88 @@
+package gtfs
+
+import (
+	"time"
+)
+
+// Aggregate statistics of a route's scheduled trips, for route profile pages and planning reports
+type RouteStats struct {
+	RouteID Key `json:"route_id"`
+	Trips   int `json:"trips"`
+
+	// Trips scheduled on each day of the week by their service's calendar, indexed by time.Weekday
+	// (Sunday first). Service exceptions are not counted.
+	TripsByWeekday [7]int `json:"trips_by_weekday"`
+
+	Patterns     int           `json:"patterns"`      // Number of distinct stop sequences
+	Stops        int           `json:"stops"`         // Number of distinct stops served
+	MeanDuration time.Duration `json:"mean_duration"` // Mean time from the first departure to the last arrival of a trip
+
+	// Earliest departure and latest arrival of any trip, in seconds since the start of the service day.
+	// The latest arrival may be past midnight. Both are zero if the route has no trips with stops.
+	FirstDeparture uint `json:"first_departure"`
+	LastArrival    uint `json:"last_arrival"`
+}
+
+// Returns aggregate statistics of the route's trips: their number on each weekday, their distinct stop
+// patterns, the stops they serve, their mean duration and the span of service
+func (g *GTFS) GetRouteStats(routeID Key) (*RouteStats, error) {
+	trips, err := g.GetTripsByRouteID(routeID)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &RouteStats{RouteID: routeID, Trips: len(trips)}
+	tripList := make([]*Trip, 0, len(trips))
+	serviceIDs := []Key{}
+	seenServices := make(map[Key]bool)
+	stops := make(map[Key]bool)
+	var totalDuration time.Duration
+	timedTrips := 0
+	for _, trip := range trips {
+		tripList = append(tripList, trip)
+		if !seenServices[trip.ServiceID] {
+			seenServices[trip.ServiceID] = true
+			serviceIDs = append(serviceIDs, trip.ServiceID)
+		}
+		for _, stop := range trip.Stops {
+			stops[stop.StopID] = true
+		}
+		if len(trip.Stops) == 0 {
+			continue
+		}
+
+		departure := trip.Stops[0].DepartureTime
+		arrival := trip.Stops[len(trip.Stops)-1].ArrivalTime
+		if timedTrips == 0 || departure < stats.FirstDeparture {
+			stats.FirstDeparture = departure
+		}
+		stats.LastArrival = max(stats.LastArrival, arrival)
+		if arrival > departure {
+			totalDuration += time.Duration(arrival-departure) * time.Second
+		}
+		timedTrips++
+	}
+	stats.Stops = len(stops)
+	stats.Patterns = len(groupStopPatterns(tripList))
+	if timedTrips > 0 {
+		stats.MeanDuration = totalDuration / time.Duration(timedTrips)
+	}
+
+	// Services only defined by exceptions have no calendar
+	services, err := g.GetServicesByIDs(serviceIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, trip := range tripList {
+		service, ok := services[trip.ServiceID]
+		if !ok {
+			continue
+		}
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			if hasDay(service.Weekdays, day) {
+				stats.TripsByWeekday[day]++
+			}
+		}
+	}
+	return stats, nil
+}
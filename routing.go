@@ -0,0 +1,341 @@
+package gtfs
+
+import (
+	"errors"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"time"
+)
+
+// A scheduled movement of a trip between two consecutive stops, identified by their numeric IDs
+type connection struct {
+	TripID        uint32
+	FromStopID    uint32
+	ToStopID      uint32
+	DepartureTime int // Seconds since the start of the service day being routed
+	ArrivalTime   int // Seconds since the start of the service day being routed
+}
+
+// A stop reachable from an origin, with the earliest time it can be reached
+type ReachableStop struct {
+	StopID     Key
+	Arrival    time.Time
+	TravelTime time.Duration
+}
+
+// Builds the time-sorted connections for all trips running on the service day starting at the given time.
+// Trips from the previous service day which run into this one are included, shifted back by the length
+// of the previous day. Trips and stops are identified by their numeric IDs in the given tables.
+func (g *GTFS) getConnections(day time.Time, tripIDs, stopIDs *localNumericIDs) ([]connection, error) {
+	trips, err := g.GetAllTrips()
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply realtime delays and cancellations for each service day, if a source is attached
+	previousDay := addServiceDays(day, -1)
+	previousDayLength := serviceDayLength(previousDay)
+	dayTrips, err := g.applyRealtime(trips, day)
+	if err != nil {
+		return nil, err
+	}
+	previousDayTrips, err := g.applyRealtime(trips, previousDay)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check services at noon, which is unambiguously within the service day
+	noon := day.Add(12 * time.Hour)
+	previousNoon := noon.AddDate(0, 0, -1)
+	runningCache := make(map[Key]bool)
+	previousRunningCache := make(map[Key]bool)
+
+	var connections []connection
+	for _, trip := range dayTrips {
+		if len(trip.Stops) < 2 {
+			continue
+		}
+
+		running, err := g.isTripRunning(trip, noon, runningCache)
+		if err != nil {
+			return nil, err
+		}
+		if running {
+			connections = appendTripConnections(connections, trip, 0, tripIDs, stopIDs)
+		}
+	}
+	for _, trip := range previousDayTrips {
+		// Only trips running past the end of their service day can contribute to the following day
+		if len(trip.Stops) < 2 || int(trip.EndTime()) < previousDayLength {
+			continue
+		}
+
+		running, err := g.isTripRunning(trip, previousNoon, previousRunningCache)
+		if err != nil {
+			return nil, err
+		}
+		if running {
+			connections = appendTripConnections(connections, trip, -previousDayLength, tripIDs, stopIDs)
+		}
+	}
+
+	sort.Slice(connections, func(i, j int) bool {
+		if connections[i].DepartureTime != connections[j].DepartureTime {
+			return connections[i].DepartureTime < connections[j].DepartureTime
+		}
+		return connections[i].ArrivalTime < connections[j].ArrivalTime
+	})
+
+	return connections, nil
+}
+
+// Appends the connections between consecutive stops of a trip, with times shifted by the given offset
+func appendTripConnections(connections []connection, trip *Trip, offset int, tripIDs, stopIDs *localNumericIDs) []connection {
+	tripID := tripIDs.id(trip.ID)
+	for i := 0; i < len(trip.Stops)-1; i++ {
+		from := trip.Stops[i]
+		to := trip.Stops[i+1]
+
+		departureTime := int(from.DepartureTime) + offset
+		if departureTime < 0 {
+			continue
+		}
+		connections = append(connections, connection{
+			TripID:        tripID,
+			FromStopID:    stopIDs.id(from.StopID),
+			ToStopID:      stopIDs.id(to.StopID),
+			DepartureTime: departureTime,
+			ArrivalTime:   int(to.ArrivalTime) + offset,
+		})
+	}
+	return connections
+}
+
+// Returns the stops sharing a parent station with each stop, which are treated as
+// reachable from one another without any additional travel time
+func getStationSiblings(stops StopMap) map[Key]KeyArray {
+	children := make(map[Key]KeyArray)
+	for _, stop := range stops {
+		if stop.ParentID != "" {
+			children[stop.ParentID] = append(children[stop.ParentID], stop.ID)
+		}
+	}
+
+	siblings := make(map[Key]KeyArray)
+	for parentID, childIDs := range children {
+		for _, childID := range childIDs {
+			siblings[childID] = append(siblings[childID], parentID)
+			for _, otherID := range childIDs {
+				if otherID != childID {
+					siblings[childID] = append(siblings[childID], otherID)
+				}
+			}
+		}
+		siblings[parentID] = append(siblings[parentID], childIDs...)
+	}
+	return siblings
+}
+
+// Returns the stops reachable from the origin stop within the given duration when departing
+// at the given time, along with the earliest arrival time at each, sorted by arrival time.
+// Stops sharing a parent station are considered connected, allowing transfers within stations, and the
+// database's transfers (see IngestOptions.TransferDistance) allow walking between stops.
+func (g *GTFS) Isochrone(originStopID Key, departAt time.Time, maxDuration time.Duration) ([]ReachableStop, error) {
+	if maxDuration < 0 {
+		return nil, errors.New("max duration must not be negative")
+	}
+
+	// Make sure the origin exists
+	_, err := g.GetStopByID(originStopID)
+	if err != nil {
+		return nil, err
+	}
+
+	timezone, err := g.getFeedTimezone()
+	if err != nil {
+		return nil, err
+	}
+	departAt = departAt.In(timezone)
+	day := serviceDayStart(departAt, timezone)
+
+	// Identify trips and stops by their numeric IDs, so arrivals can be tracked in arrays
+	tripTable, err := g.getNumericIDTable(TripEntityType)
+	if err != nil {
+		return nil, err
+	}
+	stopTable, err := g.getNumericIDTable(StopEntityType)
+	if err != nil {
+		return nil, err
+	}
+	tripIDs := newLocalNumericIDs(tripTable)
+	stopIDs := newLocalNumericIDs(stopTable)
+
+	connections, err := g.getConnections(day, tripIDs, stopIDs)
+	if err != nil {
+		return nil, err
+	}
+	stops, err := g.GetAllStops()
+	if err != nil {
+		return nil, err
+	}
+	siblings := make(map[uint32][]uint32)
+	for stopID, siblingIDs := range getStationSiblings(stops) {
+		for _, siblingID := range siblingIDs {
+			siblings[stopIDs.id(stopID)] = append(siblings[stopIDs.id(stopID)], stopIDs.id(siblingID))
+		}
+	}
+	allTransfers, err := g.getAllTransfers()
+	if err != nil {
+		return nil, err
+	}
+	type walk struct {
+		toStopID uint32
+		seconds  int
+	}
+	transfers := make(map[uint32][]walk, len(allTransfers))
+	for stopID, stopTransfers := range allTransfers {
+		for _, transfer := range stopTransfers {
+			if transfer.Type == NotPossibleTransferType {
+				continue
+			}
+			transfers[stopIDs.id(stopID)] = append(transfers[stopIDs.id(stopID)], walk{stopIDs.id(transfer.ToStopID), int(transfer.MinTransferTime)})
+		}
+	}
+	originID := stopIDs.id(originStopID)
+
+	departSeconds := int(departAt.Sub(day).Seconds())
+	limitSeconds := departSeconds + int(maxDuration.Seconds())
+
+	// Scan the connections in departure order, tracking the earliest arrival at each stop
+	const unreached = math.MaxInt
+	earliest := make([]int, stopIDs.len())
+	for i := range earliest {
+		earliest[i] = unreached
+	}
+	reach := func(stopID uint32, arrivalTime int) {
+		if earliest[stopID] <= arrivalTime {
+			return
+		}
+		earliest[stopID] = arrivalTime
+		for _, siblingID := range siblings[stopID] {
+			earliest[siblingID] = min(earliest[siblingID], arrivalTime)
+		}
+		for _, transfer := range transfers[stopID] {
+			transferTime := arrivalTime + transfer.seconds
+			if transferTime > limitSeconds {
+				continue
+			}
+			earliest[transfer.toStopID] = min(earliest[transfer.toStopID], transferTime)
+		}
+	}
+	reach(originID, departSeconds)
+
+	boardedTrips := make([]bool, tripIDs.len())
+	for _, c := range connections {
+		if c.DepartureTime > limitSeconds {
+			break
+		}
+		if c.DepartureTime < departSeconds {
+			continue
+		}
+
+		if !boardedTrips[c.TripID] {
+			if earliest[c.FromStopID] > c.DepartureTime {
+				continue
+			}
+			boardedTrips[c.TripID] = true
+		}
+
+		if c.ArrivalTime <= limitSeconds {
+			reach(c.ToStopID, c.ArrivalTime)
+		}
+	}
+
+	reachable := []ReachableStop{}
+	for stopID, arrivalTime := range earliest {
+		if arrivalTime == unreached {
+			continue
+		}
+		reachable = append(reachable, ReachableStop{
+			StopID:     stopIDs.key(uint32(stopID)),
+			Arrival:    day.Add(time.Duration(arrivalTime) * time.Second),
+			TravelTime: time.Duration(arrivalTime-departSeconds) * time.Second,
+		})
+	}
+	sort.Slice(reachable, func(i, j int) bool {
+		if !reachable[i].Arrival.Equal(reachable[j].Arrival) {
+			return reachable[i].Arrival.Before(reachable[j].Arrival)
+		}
+		return reachable[i].StopID < reachable[j].StopID
+	})
+
+	return reachable, nil
+}
+
+// A route serving two stops in order, with a representative trip
+type DirectRoute struct {
+	Route *Route
+	Trip  *Trip // Trip with the earliest departure from the origin stop
+}
+
+// Returns the index of the first visit to the stop after the given index, or -1 if the trip does not visit it
+func (t *Trip) stopIndexAfter(stopID Key, after int) int {
+	for i := after + 1; i < len(t.Stops); i++ {
+		if t.Stops[i].StopID == stopID {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the routes with a trip stopping at the origin stop and later at the destination stop,
+// sorted by route ID. Each route includes the trip with the earliest departure from the origin.
+func (g *GTFS) GetDirectRoutesBetween(fromStopID, toStopID Key) ([]DirectRoute, error) {
+	// Only the trips visiting the origin can serve the pair, whichever pattern of their route they follow
+	trips, err := g.getTripsByStopID(fromStopID)
+	if err != nil {
+		return nil, err
+	}
+
+	representatives := make(map[Key]*Trip)
+	departures := make(map[Key]uint)
+	for _, trip := range trips {
+		fromIndex := trip.stopIndexAfter(fromStopID, -1)
+		if fromIndex == -1 || trip.stopIndexAfter(toStopID, fromIndex) == -1 {
+			continue
+		}
+
+		departure := trip.Stops[fromIndex].DepartureTime
+		representative, ok := representatives[trip.RouteID]
+		if !ok || departure < departures[trip.RouteID] ||
+			(departure == departures[trip.RouteID] && trip.ID < representative.ID) {
+			representatives[trip.RouteID] = trip
+			departures[trip.RouteID] = departure
+		}
+	}
+
+	routes, err := g.GetRoutesByIDs(slices.Collect(maps.Keys(representatives)))
+	if err != nil {
+		return nil, err
+	}
+
+	var directRoutes []DirectRoute
+	for routeID, representative := range representatives {
+		// Trips may reference a route which does not exist
+		route, ok := routes[routeID]
+		if !ok {
+			continue
+		}
+		directRoutes = append(directRoutes, DirectRoute{
+			Route: route,
+			Trip:  representative,
+		})
+	}
+
+	sort.Slice(directRoutes, func(i, j int) bool {
+		return directRoutes[i].Route.ID < directRoutes[j].Route.ID
+	})
+	return directRoutes, nil
+}
@@ -0,0 +1,63 @@
+package rpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// Client of the GTFS service
+type Client struct {
+	cc grpc.ClientConnInterface
+}
+
+// Create a new Client calling the GTFS service over the given connection
+func NewClient(cc grpc.ClientConnInterface) *Client {
+	return &Client{cc: cc}
+}
+
+// Invoke a unary method of the service using its codec
+func (c *Client) invoke(ctx context.Context, method string, in, out message, opts []grpc.CallOption) error {
+	opts = append([]grpc.CallOption{grpc.ForceCodec(codec{})}, opts...)
+	return c.cc.Invoke(ctx, "/"+serviceName+"/"+method, in, out, opts...)
+}
+
+func (c *Client) GetAgency(ctx context.Context, in *IDRequest, opts ...grpc.CallOption) (*AgencyResponse, error) {
+	out := &AgencyResponse{}
+	return out, c.invoke(ctx, "GetAgency", in, out, opts)
+}
+
+func (c *Client) GetRoute(ctx context.Context, in *IDRequest, opts ...grpc.CallOption) (*RouteResponse, error) {
+	out := &RouteResponse{}
+	return out, c.invoke(ctx, "GetRoute", in, out, opts)
+}
+
+func (c *Client) GetStop(ctx context.Context, in *IDRequest, opts ...grpc.CallOption) (*StopResponse, error) {
+	out := &StopResponse{}
+	return out, c.invoke(ctx, "GetStop", in, out, opts)
+}
+
+func (c *Client) GetTrip(ctx context.Context, in *IDRequest, opts ...grpc.CallOption) (*TripResponse, error) {
+	out := &TripResponse{}
+	return out, c.invoke(ctx, "GetTrip", in, out, opts)
+}
+
+func (c *Client) GetShape(ctx context.Context, in *IDRequest, opts ...grpc.CallOption) (*ShapeResponse, error) {
+	out := &ShapeResponse{}
+	return out, c.invoke(ctx, "GetShape", in, out, opts)
+}
+
+func (c *Client) GetTripsByRoute(ctx context.Context, in *IDRequest, opts ...grpc.CallOption) (*TripsResponse, error) {
+	out := &TripsResponse{}
+	return out, c.invoke(ctx, "GetTripsByRoute", in, out, opts)
+}
+
+func (c *Client) GetStopDepartures(ctx context.Context, in *DeparturesRequest, opts ...grpc.CallOption) (*DeparturesResponse, error) {
+	out := &DeparturesResponse{}
+	return out, c.invoke(ctx, "GetStopDepartures", in, out, opts)
+}
+
+func (c *Client) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	out := &SearchResponse{}
+	return out, c.invoke(ctx, "Search", in, out, opts)
+}
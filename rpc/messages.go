@@ -0,0 +1,421 @@
+package rpc
+
+import (
+	"math"
+	"time"
+
+	"github.com/aaroncutress/gtfs-go"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/mem"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// A message of the GTFS service, encoded in protobuf wire format as described in gtfs_service.proto
+type message interface {
+	marshal() []byte
+	unmarshal(data []byte) error
+}
+
+// An entity which can be encoded as a message from gtfs.proto
+type protoEntity interface {
+	EncodeProto() []byte
+	DecodeProto(id gtfs.Key, data []byte) error
+}
+
+// --- Wire Format Helpers ---
+
+// Append a string field, omitting empty strings
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// Append an embedded message field
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+// Append an unsigned varint field, omitting zero values
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// Append a zigzag-encoded signed varint field, omitting zero values
+func appendSint(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, protowire.EncodeZigZag(v))
+}
+
+// Append a double field, omitting zero values
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+// Call fn for each field in the data with its number and value. Varint and fixed-width values are
+// passed as v, and length-delimited values as b. Fields of other types are skipped.
+func consumeFields(data []byte, fn func(num protowire.Number, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var v uint64
+		var b []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, n = protowire.ConsumeFixed32(data)
+			v = uint64(v32)
+		case protowire.BytesType:
+			b, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		err := fn(num, v, b)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encode an entity response, which pairs the entity's ID with its message
+func marshalEntity(id gtfs.Key, e protoEntity) []byte {
+	b := appendString(nil, 1, string(id))
+	return appendMessage(b, 2, e.EncodeProto())
+}
+
+// Decode an entity response into the given entity
+func unmarshalEntity(data []byte, e protoEntity) error {
+	var id gtfs.Key
+	var body []byte
+	err := consumeFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			id = gtfs.Key(b)
+		case 2:
+			body = b
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return e.DecodeProto(id, body)
+}
+
+// --- Messages ---
+
+// Request for an entity by its ID
+type IDRequest struct {
+	ID gtfs.Key
+}
+
+func (m *IDRequest) marshal() []byte {
+	return appendString(nil, 1, string(m.ID))
+}
+
+func (m *IDRequest) unmarshal(data []byte) error {
+	return consumeFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		if num == 1 {
+			m.ID = gtfs.Key(b)
+		}
+		return nil
+	})
+}
+
+type AgencyResponse struct {
+	Agency *gtfs.Agency
+}
+
+func (m *AgencyResponse) marshal() []byte {
+	return marshalEntity(m.Agency.ID, m.Agency)
+}
+
+func (m *AgencyResponse) unmarshal(data []byte) error {
+	m.Agency = &gtfs.Agency{}
+	return unmarshalEntity(data, m.Agency)
+}
+
+type RouteResponse struct {
+	Route *gtfs.Route
+}
+
+func (m *RouteResponse) marshal() []byte {
+	return marshalEntity(m.Route.ID, m.Route)
+}
+
+func (m *RouteResponse) unmarshal(data []byte) error {
+	m.Route = &gtfs.Route{}
+	return unmarshalEntity(data, m.Route)
+}
+
+type StopResponse struct {
+	Stop *gtfs.Stop
+}
+
+func (m *StopResponse) marshal() []byte {
+	return marshalEntity(m.Stop.ID, m.Stop)
+}
+
+func (m *StopResponse) unmarshal(data []byte) error {
+	m.Stop = &gtfs.Stop{}
+	return unmarshalEntity(data, m.Stop)
+}
+
+type TripResponse struct {
+	Trip *gtfs.Trip
+}
+
+func (m *TripResponse) marshal() []byte {
+	return marshalEntity(m.Trip.ID, m.Trip)
+}
+
+func (m *TripResponse) unmarshal(data []byte) error {
+	m.Trip = &gtfs.Trip{}
+	return unmarshalEntity(data, m.Trip)
+}
+
+type ShapeResponse struct {
+	Shape *gtfs.Shape
+}
+
+func (m *ShapeResponse) marshal() []byte {
+	return marshalEntity(m.Shape.ID, m.Shape)
+}
+
+func (m *ShapeResponse) unmarshal(data []byte) error {
+	m.Shape = &gtfs.Shape{}
+	return unmarshalEntity(data, m.Shape)
+}
+
+type TripsResponse struct {
+	Trips []*gtfs.Trip
+}
+
+func (m *TripsResponse) marshal() []byte {
+	var b []byte
+	for _, trip := range m.Trips {
+		b = appendMessage(b, 1, marshalEntity(trip.ID, trip))
+	}
+	return b
+}
+
+func (m *TripsResponse) unmarshal(data []byte) error {
+	m.Trips = []*gtfs.Trip{}
+	return consumeFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		if num != 1 {
+			return nil
+		}
+		trip := &gtfs.Trip{}
+		err := unmarshalEntity(b, trip)
+		if err != nil {
+			return err
+		}
+		m.Trips = append(m.Trips, trip)
+		return nil
+	})
+}
+
+// Request for the departures from a stop
+type DeparturesRequest struct {
+	StopID gtfs.Key
+	At     time.Time     // Zero for now
+	Window time.Duration // Zero for one hour
+}
+
+func (m *DeparturesRequest) marshal() []byte {
+	b := appendString(nil, 1, string(m.StopID))
+	if !m.At.IsZero() {
+		b = appendSint(b, 2, m.At.Unix())
+	}
+	return appendVarint(b, 3, uint64(m.Window/time.Second))
+}
+
+func (m *DeparturesRequest) unmarshal(data []byte) error {
+	return consumeFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			m.StopID = gtfs.Key(b)
+		case 2:
+			m.At = time.Unix(protowire.DecodeZigZag(v), 0)
+		case 3:
+			m.Window = time.Duration(v) * time.Second
+		}
+		return nil
+	})
+}
+
+type DeparturesResponse struct {
+	Departures []gtfs.Departure
+}
+
+func (m *DeparturesResponse) marshal() []byte {
+	var b []byte
+	for _, departure := range m.Departures {
+		d := appendString(nil, 1, string(departure.TripID))
+		d = appendString(d, 2, string(departure.RouteID))
+		d = appendString(d, 3, departure.Headsign)
+		d = appendVarint(d, 4, uint64(departure.StopIndex))
+		d = appendSint(d, 5, departure.Time.Unix())
+		b = appendMessage(b, 1, d)
+	}
+	return b
+}
+
+func (m *DeparturesResponse) unmarshal(data []byte) error {
+	m.Departures = []gtfs.Departure{}
+	return consumeFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		if num != 1 {
+			return nil
+		}
+		var departure gtfs.Departure
+		err := consumeFields(b, func(num protowire.Number, v uint64, b []byte) error {
+			switch num {
+			case 1:
+				departure.TripID = gtfs.Key(b)
+			case 2:
+				departure.RouteID = gtfs.Key(b)
+			case 3:
+				departure.Headsign = string(b)
+			case 4:
+				departure.StopIndex = int(v)
+			case 5:
+				departure.Time = time.Unix(protowire.DecodeZigZag(v), 0)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		m.Departures = append(m.Departures, departure)
+		return nil
+	})
+}
+
+type SearchRequest struct {
+	Query string
+}
+
+func (m *SearchRequest) marshal() []byte {
+	return appendString(nil, 1, m.Query)
+}
+
+func (m *SearchRequest) unmarshal(data []byte) error {
+	return consumeFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		if num == 1 {
+			m.Query = string(b)
+		}
+		return nil
+	})
+}
+
+type SearchResponse struct {
+	Results []gtfs.SearchResult
+}
+
+func (m *SearchResponse) marshal() []byte {
+	var b []byte
+	for _, result := range m.Results {
+		r := appendVarint(nil, 1, uint64(result.Type))
+		r = appendString(r, 2, string(result.ID))
+		r = appendString(r, 3, result.Name)
+		r = appendDouble(r, 4, result.Score)
+		b = appendMessage(b, 1, r)
+	}
+	return b
+}
+
+func (m *SearchResponse) unmarshal(data []byte) error {
+	m.Results = []gtfs.SearchResult{}
+	return consumeFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		if num != 1 {
+			return nil
+		}
+		var result gtfs.SearchResult
+		err := consumeFields(b, func(num protowire.Number, v uint64, b []byte) error {
+			switch num {
+			case 1:
+				result.Type = gtfs.SearchResultType(v)
+			case 2:
+				result.ID = gtfs.Key(b)
+			case 3:
+				result.Name = string(b)
+			case 4:
+				result.Score = math.Float64frombits(v)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		m.Results = append(m.Results, result)
+		return nil
+	})
+}
+
+// --- Codec ---
+
+// Name of the codec, and the content-subtype of requests made by Client
+const codecName = "gtfs-proto"
+
+// The codec is registered so that servers decode requests made by Client without ServerOptions
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// Codec marshaling the service's messages in protobuf wire format. Messages of other services served
+// alongside it are left to the standard protobuf codec.
+type codec struct{}
+
+func (codec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(message)
+	if !ok {
+		data, err := encoding.GetCodecV2(proto.Name).Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		defer data.Free()
+		return data.Materialize(), nil
+	}
+	return m.marshal(), nil
+}
+
+func (codec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(message)
+	if !ok {
+		return encoding.GetCodecV2(proto.Name).Unmarshal(mem.BufferSlice{mem.SliceBuffer(data)}, v)
+	}
+	return m.unmarshal(data)
+}
+
+func (codec) Name() string {
+	return codecName
+}
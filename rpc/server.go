@@ -0,0 +1,205 @@
+// Package rpc exposes a GTFS database as a gRPC service, as defined in gtfs_service.proto.
+//
+//	g := &gtfs.GTFS{}
+//	err := g.FromDB("gtfs.db")
+//	...
+//	s := grpc.NewServer(rpc.ServerOptions()...)
+//	rpc.RegisterGTFSServer(s, rpc.NewServer(g))
+//	s.Serve(lis)
+//
+// Messages are encoded in protobuf wire format by the package's own codec, reusing the entity
+// encodings from gtfs.proto, so clients generated from gtfs_service.proto are compatible.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/aaroncutress/gtfs-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Full name of the gRPC service
+const serviceName = "gtfs.rpc.GTFS"
+
+// Default window for departure queries when none is given
+const defaultDepartureWindow = time.Hour
+
+// Server API of the GTFS service
+type GTFSServer interface {
+	GetAgency(context.Context, *IDRequest) (*AgencyResponse, error)
+	GetRoute(context.Context, *IDRequest) (*RouteResponse, error)
+	GetStop(context.Context, *IDRequest) (*StopResponse, error)
+	GetTrip(context.Context, *IDRequest) (*TripResponse, error)
+	GetShape(context.Context, *IDRequest) (*ShapeResponse, error)
+	GetTripsByRoute(context.Context, *IDRequest) (*TripsResponse, error)
+	GetStopDepartures(context.Context, *DeparturesRequest) (*DeparturesResponse, error)
+	Search(context.Context, *SearchRequest) (*SearchResponse, error)
+}
+
+// Returns the server options which install the GTFS service's codec for requests in the standard protobuf
+// content-subtype, as made by clients generated from gtfs_service.proto. Requests to other services on the
+// server are still encoded by the standard codec.
+func ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{grpc.ForceServerCodec(codec{})}
+}
+
+// Register the GTFS service with a gRPC server created with ServerOptions
+func RegisterGTFSServer(s grpc.ServiceRegistrar, srv GTFSServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+// Build the method descriptor for a unary method, decoding its request into a new message
+func unaryMethod[Req message, Resp any](name string, newReq func() Req, call func(GTFSServer, context.Context, Req) (Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			in := newReq()
+			err := dec(in)
+			if err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(GTFSServer), ctx, in)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + serviceName + "/" + name,
+			}
+			handler := func(ctx context.Context, req any) (any, error) {
+				return call(srv.(GTFSServer), ctx, req.(Req))
+			}
+			return interceptor(ctx, in, info, handler)
+		},
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*GTFSServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod("GetAgency", func() *IDRequest { return &IDRequest{} }, GTFSServer.GetAgency),
+		unaryMethod("GetRoute", func() *IDRequest { return &IDRequest{} }, GTFSServer.GetRoute),
+		unaryMethod("GetStop", func() *IDRequest { return &IDRequest{} }, GTFSServer.GetStop),
+		unaryMethod("GetTrip", func() *IDRequest { return &IDRequest{} }, GTFSServer.GetTrip),
+		unaryMethod("GetShape", func() *IDRequest { return &IDRequest{} }, GTFSServer.GetShape),
+		unaryMethod("GetTripsByRoute", func() *IDRequest { return &IDRequest{} }, GTFSServer.GetTripsByRoute),
+		unaryMethod("GetStopDepartures", func() *DeparturesRequest { return &DeparturesRequest{} }, GTFSServer.GetStopDepartures),
+		unaryMethod("Search", func() *SearchRequest { return &SearchRequest{} }, GTFSServer.Search),
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "rpc/gtfs_service.proto",
+}
+
+// Serves the GTFS service from a GTFS database
+type Server struct {
+	g *gtfs.GTFS
+}
+
+// Create a new Server serving the given GTFS database
+func NewServer(g *gtfs.GTFS) *Server {
+	return &Server{g: g}
+}
+
+// Convert an error from the database into a gRPC status, using NotFound for entities which were not found
+func toStatus(err error) error {
+	if errors.Is(err, gtfs.ErrNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func (s *Server) GetAgency(ctx context.Context, req *IDRequest) (*AgencyResponse, error) {
+	agency, err := s.g.GetAgencyByID(req.ID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &AgencyResponse{Agency: agency}, nil
+}
+
+func (s *Server) GetRoute(ctx context.Context, req *IDRequest) (*RouteResponse, error) {
+	route, err := s.g.GetRouteByID(req.ID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &RouteResponse{Route: route}, nil
+}
+
+func (s *Server) GetStop(ctx context.Context, req *IDRequest) (*StopResponse, error) {
+	stop, err := s.g.GetStopByID(req.ID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &StopResponse{Stop: stop}, nil
+}
+
+func (s *Server) GetTrip(ctx context.Context, req *IDRequest) (*TripResponse, error) {
+	trip, err := s.g.GetTripByID(req.ID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &TripResponse{Trip: trip}, nil
+}
+
+func (s *Server) GetShape(ctx context.Context, req *IDRequest) (*ShapeResponse, error) {
+	shape, err := s.g.GetShapeByID(req.ID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ShapeResponse{Shape: shape}, nil
+}
+
+func (s *Server) GetTripsByRoute(ctx context.Context, req *IDRequest) (*TripsResponse, error) {
+	trips, err := s.g.GetTripsByRouteID(req.ID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &TripsResponse{Trips: make([]*gtfs.Trip, 0, len(trips))}
+	for _, trip := range trips {
+		resp.Trips = append(resp.Trips, trip)
+	}
+	sort.Slice(resp.Trips, func(i, j int) bool {
+		return resp.Trips[i].ID < resp.Trips[j].ID
+	})
+	return resp, nil
+}
+
+func (s *Server) GetStopDepartures(ctx context.Context, req *DeparturesRequest) (*DeparturesResponse, error) {
+	at := req.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	window := req.Window
+	if window == 0 {
+		window = defaultDepartureWindow
+	}
+
+	// Make sure the stop exists, so unknown stops are not reported as having no departures
+	_, err := s.g.GetStopByID(req.StopID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	departures, err := s.g.GetStopDepartures(req.StopID, at, window)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &DeparturesResponse{Departures: departures}, nil
+}
+
+func (s *Server) Search(ctx context.Context, req *SearchRequest) (*SearchResponse, error) {
+	if req.Query == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing query")
+	}
+
+	results, err := s.g.Search(req.Query)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &SearchResponse{Results: results}, nil
+}
@@ -0,0 +1,254 @@
+package gtfs
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Maximum number of results returned by Search
+const maxSearchResults = 50
+
+// Score awarded to a query token matching an indexed token exactly, or only by prefix
+const (
+	exactTokenScore  = 1.0
+	prefixTokenScore = 0.5
+)
+
+// Type of entity referenced by a search result
+type SearchResultType uint8
+
+const (
+	RouteSearchResultType SearchResultType = iota
+	StopSearchResultType
+	AgencySearchResultType
+)
+
+// Prefixes identifying the entity type of IDs stored in the search index
+var searchRefPrefixes = map[SearchResultType]string{
+	RouteSearchResultType:  "r:",
+	StopSearchResultType:   "s:",
+	AgencySearchResultType: "a:",
+}
+
+// Represents an entity matching a search query
+type SearchResult struct {
+	Type  SearchResultType `json:"type"`
+	ID    Key              `json:"id"`
+	Name  string           `json:"name"`
+	Score float64          `json:"score"`
+}
+
+// Split a name into normalized tokens, without duplicates
+func tokenize(name string) []string {
+	fields := nameWords(name)
+
+	seen := make(map[string]bool, len(fields))
+	tokens := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if !seen[field] {
+			seen[field] = true
+			tokens = append(tokens, field)
+		}
+	}
+	return tokens
+}
+
+// Build the inverted search index, mapping each name token to references to the entities containing it.
+// Stops are also indexed under the tokens of their aliases.
+func buildSearchIndex(agencies AgencyMap, routes RouteMap, stops StopMap, aliases []StopAlias) map[string]*KeyArray {
+	index := make(map[string]*KeyArray)
+	addToken := func(resultType SearchResultType, id Key, token string) {
+		if _, exists := index[token]; !exists {
+			index[token] = &KeyArray{}
+		}
+		index[token].Append(Key(searchRefPrefixes[resultType]) + id)
+	}
+	add := func(resultType SearchResultType, id Key, name string) {
+		for _, token := range tokenize(name) {
+			addToken(resultType, id, token)
+		}
+	}
+
+	for _, agency := range agencies {
+		add(AgencySearchResultType, agency.ID, agency.Name)
+	}
+	for _, route := range routes {
+		add(RouteSearchResultType, route.ID, route.Name)
+	}
+	for _, stop := range stops {
+		add(StopSearchResultType, stop.ID, stop.Name)
+	}
+
+	// Aliases only add the tokens not already indexed for their stop
+	stopTokens := make(map[Key]map[string]bool)
+	for _, alias := range aliases {
+		stop, ok := stops[alias.StopID]
+		if !ok {
+			continue
+		}
+		if _, ok := stopTokens[stop.ID]; !ok {
+			stopTokens[stop.ID] = make(map[string]bool)
+			for _, token := range tokenize(stop.Name) {
+				stopTokens[stop.ID][token] = true
+			}
+		}
+		for _, token := range tokenize(alias.Alias) {
+			if !stopTokens[stop.ID][token] {
+				stopTokens[stop.ID][token] = true
+				addToken(StopSearchResultType, stop.ID, token)
+			}
+		}
+	}
+	return index
+}
+
+// Split a search index reference into its entity type and ID
+func parseSearchRef(ref Key) (SearchResultType, Key, bool) {
+	for resultType, prefix := range searchRefPrefixes {
+		if id, ok := strings.CutPrefix(string(ref), prefix); ok {
+			return resultType, Key(id), true
+		}
+	}
+	return 0, "", false
+}
+
+// Returns the stops, routes and agencies whose names match every token of the query, ranked by relevance.
+// Each query token matches indexed tokens exactly or by prefix, with exact matches ranked higher.
+// At most maxSearchResults results are returned.
+func (g *GTFS) Search(query string) ([]SearchResult, error) {
+	queryTokens := tokenize(query)
+	if len(queryTokens) == 0 {
+		return []SearchResult{}, nil
+	}
+
+	// Score each referenced entity by the best match for each query token
+	scores := make(map[Key]float64)
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("searchIndex"))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+
+		for i, queryToken := range queryTokens {
+			tokenScores := make(map[Key]float64)
+			prefix := []byte(queryToken)
+
+			c := b.Cursor()
+			for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+				score := prefixTokenScore
+				if len(k) == len(prefix) {
+					score = exactTokenScore
+				}
+
+				var refs KeyArray
+				err := refs.Decode(v)
+				if err != nil {
+					return err
+				}
+				for _, ref := range refs {
+					if score > tokenScores[ref] {
+						tokenScores[ref] = score
+					}
+				}
+			}
+
+			// Only keep entities matching every query token so far
+			for ref, score := range tokenScores {
+				if _, ok := scores[ref]; ok || i == 0 {
+					scores[ref] += score
+				}
+			}
+			for ref := range scores {
+				if _, ok := tokenScores[ref]; !ok {
+					delete(scores, ref)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(scores))
+	for ref, score := range scores {
+		resultType, id, ok := parseSearchRef(ref)
+		if !ok {
+			continue
+		}
+		results = append(results, SearchResult{
+			Type:  resultType,
+			ID:    id,
+			Score: score,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].Type != results[j].Type {
+			return results[i].Type < results[j].Type
+		}
+		return results[i].ID < results[j].ID
+	})
+	if len(results) > maxSearchResults {
+		results = results[:maxSearchResults]
+	}
+
+	err = g.fillSearchResultNames(results)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Look up the names of the entities referenced by the search results
+func (g *GTFS) fillSearchResultNames(results []SearchResult) error {
+	var agencyIDs, routeIDs, stopIDs []Key
+	for _, result := range results {
+		switch result.Type {
+		case AgencySearchResultType:
+			agencyIDs = append(agencyIDs, result.ID)
+		case RouteSearchResultType:
+			routeIDs = append(routeIDs, result.ID)
+		case StopSearchResultType:
+			stopIDs = append(stopIDs, result.ID)
+		}
+	}
+
+	agencies, err := g.GetAgenciesByIDs(agencyIDs)
+	if err != nil {
+		return err
+	}
+	routes, err := g.GetRoutesByIDs(routeIDs)
+	if err != nil {
+		return err
+	}
+	stops, err := g.GetStopsByIDs(stopIDs)
+	if err != nil {
+		return err
+	}
+
+	for i := range results {
+		id := results[i].ID
+		switch results[i].Type {
+		case AgencySearchResultType:
+			if agency, ok := agencies[id]; ok {
+				results[i].Name = agency.Name
+			}
+		case RouteSearchResultType:
+			if route, ok := routes[id]; ok {
+				results[i].Name = route.Name
+			}
+		case StopSearchResultType:
+			if stop, ok := stops[id]; ok {
+				results[i].Name = stop.Name
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package gtfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Scheduled travel between two consecutive stops of a route
+type SegmentTravelTime struct {
+	FromStopID Key     `json:"from_stop_id"`
+	ToStopID   Key     `json:"to_stop_id"`
+	TravelTime uint    `json:"travel_time"` // Median seconds from departure to arrival
+	Distance   float64 `json:"distance"`    // Straight-line metres between the stops
+	Samples    int     `json:"samples"`     // Number of trips travelling the segment
+}
+type SegmentTravelTimeArray []SegmentTravelTime
+
+// Encode the SegmentTravelTimeArray into a byte slice
+// Format:
+// - Count: 4 bytes (number of segments)
+// - Each segment:
+//   - FromStopID: 4-byte length + UTF-8 string
+//   - ToStopID: 4-byte length + UTF-8 string
+//   - TravelTime: 4 bytes (uint32)
+//   - Distance: 8 bytes (float64)
+//   - Samples: 4 bytes (uint32)
+func (sa SegmentTravelTimeArray) Encode() []byte {
+	totalLen := lenBytes
+	for _, s := range sa {
+		totalLen += lenBytes + len(s.FromStopID) + lenBytes + len(s.ToStopID) + uint32Bytes + float64Bytes + uint32Bytes
+	}
+
+	data := make([]byte, totalLen)
+	offset := 0
+
+	binary.BigEndian.PutUint32(data[offset:], uint32(len(sa)))
+	offset += lenBytes
+
+	for _, s := range sa {
+		binary.BigEndian.PutUint32(data[offset:], uint32(len(s.FromStopID)))
+		offset += lenBytes
+		copy(data[offset:], s.FromStopID)
+		offset += len(s.FromStopID)
+
+		binary.BigEndian.PutUint32(data[offset:], uint32(len(s.ToStopID)))
+		offset += lenBytes
+		copy(data[offset:], s.ToStopID)
+		offset += len(s.ToStopID)
+
+		binary.BigEndian.PutUint32(data[offset:], uint32(s.TravelTime))
+		offset += uint32Bytes
+		binary.BigEndian.PutUint64(data[offset:], math.Float64bits(s.Distance))
+		offset += float64Bytes
+		binary.BigEndian.PutUint32(data[offset:], uint32(s.Samples))
+		offset += uint32Bytes
+	}
+	return data
+}
+
+// Decode the byte slice into the SegmentTravelTimeArray
+func (sa *SegmentTravelTimeArray) Decode(data []byte) error {
+	if sa == nil {
+		return errors.New("cannot decode into a nil SegmentTravelTimeArray")
+	}
+	offset := 0
+
+	if offset+lenBytes > len(data) {
+		return errors.New("segment buffer too small for count")
+	}
+	count := binary.BigEndian.Uint32(data[offset:])
+	offset += lenBytes
+
+	// Read a length-prefixed key
+	readKey := func(i uint32, field string) (Key, error) {
+		if offset+lenBytes > len(data) {
+			return "", fmt.Errorf("segment buffer too small for segment %d %s length", i, field)
+		}
+		keyLen := int(binary.BigEndian.Uint32(data[offset:]))
+		offset += lenBytes
+		if offset+keyLen > len(data) {
+			return "", fmt.Errorf("segment buffer too small for segment %d %s content", i, field)
+		}
+		key := Key(data[offset : offset+keyLen])
+		offset += keyLen
+		return key, nil
+	}
+
+	segments := make(SegmentTravelTimeArray, count)
+	for i := uint32(0); i < count; i++ {
+		from, err := readKey(i, "FromStopID")
+		if err != nil {
+			return err
+		}
+		to, err := readKey(i, "ToStopID")
+		if err != nil {
+			return err
+		}
+
+		if offset+uint32Bytes+float64Bytes+uint32Bytes > len(data) {
+			return fmt.Errorf("segment buffer too small for segment %d values", i)
+		}
+		segments[i] = SegmentTravelTime{
+			FromStopID: from,
+			ToStopID:   to,
+			TravelTime: uint(binary.BigEndian.Uint32(data[offset:])),
+			Distance:   math.Float64frombits(binary.BigEndian.Uint64(data[offset+uint32Bytes:])),
+			Samples:    int(binary.BigEndian.Uint32(data[offset+uint32Bytes+float64Bytes:])),
+		}
+		offset += uint32Bytes + float64Bytes + uint32Bytes
+	}
+
+	if offset != len(data) {
+		return errors.New("segment buffer not fully consumed, trailing data exists")
+	}
+	*sa = segments
+	return nil
+}
+
+// Returns the median of the values, rounding down between the two middle values
+func medianUint(values []uint) uint {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// Compute the median scheduled travel time of each segment between consecutive stops travelled by
+// the trips, ordered along the route's stop patterns from most to least common
+func computeSegmentTravelTimes(trips []*Trip, stops StopMap) SegmentTravelTimeArray {
+	type segmentKey struct{ from, to Key }
+
+	var order []segmentKey
+	samples := make(map[segmentKey][]uint)
+	for _, pattern := range groupStopPatterns(trips) {
+		for _, trip := range pattern.Trips {
+			for i := 1; i < len(trip.Stops); i++ {
+				prev, next := trip.Stops[i-1], trip.Stops[i]
+				key := segmentKey{prev.StopID, next.StopID}
+				if _, exists := samples[key]; !exists {
+					order = append(order, key)
+				}
+
+				var travelTime uint
+				if next.ArrivalTime > prev.DepartureTime {
+					travelTime = next.ArrivalTime - prev.DepartureTime
+				}
+				samples[key] = append(samples[key], travelTime)
+			}
+		}
+	}
+
+	segments := make(SegmentTravelTimeArray, len(order))
+	for i, key := range order {
+		var distance float64
+		from, fromOk := stops[key.from]
+		to, toOk := stops[key.to]
+		if fromOk && toOk {
+			distance = from.Location.DistanceTo(to.Location)
+		}
+
+		segments[i] = SegmentTravelTime{
+			FromStopID: key.from,
+			ToStopID:   key.to,
+			TravelTime: medianUint(samples[key]),
+			Distance:   distance,
+			Samples:    len(samples[key]),
+		}
+	}
+	return segments
+}
+
+// Returns the median scheduled travel time and distance between each pair of consecutive stops
+// served by the route, precomputed at ingest
+func (g *GTFS) GetSegmentTravelTimes(routeID Key) (SegmentTravelTimeArray, error) {
+	var segments SegmentTravelTimeArray
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("segmentTravelTimes"))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+		data := b.Get([]byte(routeID))
+		if data == nil {
+			return fmt.Errorf("route %w", ErrNotFound)
+		}
+		return segments.Decode(data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
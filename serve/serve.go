@@ -0,0 +1,169 @@
+// Package serve exposes a GTFS database as a read-only REST API.
+//
+//	g := &gtfs.GTFS{}
+//	err := g.FromDB("gtfs.db")
+//	...
+//	http.ListenAndServe(":8080", serve.NewHandler(g))
+package serve
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aaroncutress/gtfs-go"
+	"github.com/charmbracelet/log"
+)
+
+// Default window for departure queries when none is given
+const defaultDepartureWindow = time.Hour
+
+// Serves the REST endpoints for a GTFS database:
+//
+//	GET /stops/{id}                  the stop with the given ID
+//	GET /stops/{id}/departures       departures from the stop; optional "at" (RFC3339) and "window" (e.g. "30m") parameters
+//	GET /routes/{id}                 the route with the given ID
+//	GET /routes/{id}/trips           the trips of the route
+//	GET /trips/{id}                  the trip with the given ID
+//	GET /search?q=...                stops, routes and agencies matching the query
+//
+// All responses are JSON. Errors are returned as {"error": "..."} with an appropriate status code.
+type Handler struct {
+	g   *gtfs.GTFS
+	mux *http.ServeMux
+}
+
+// Create a new Handler serving the given GTFS database
+func NewHandler(g *gtfs.GTFS) *Handler {
+	h := &Handler{
+		g:   g,
+		mux: http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("GET /stops/{id}", h.getStop)
+	h.mux.HandleFunc("GET /stops/{id}/departures", h.getStopDepartures)
+	h.mux.HandleFunc("GET /routes/{id}", h.getRoute)
+	h.mux.HandleFunc("GET /routes/{id}/trips", h.getRouteTrips)
+	h.mux.HandleFunc("GET /trips/{id}", h.getTrip)
+	h.mux.HandleFunc("GET /search", h.search)
+
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Write the value as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Errorf("Failed to write response: %v", err)
+	}
+}
+
+// Write an error response, using 404 for entities which were not found
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, gtfs.ErrNotFound) {
+		status = http.StatusNotFound
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// Write a bad request response
+func writeBadRequest(w http.ResponseWriter, message string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": message})
+}
+
+func (h *Handler) getStop(w http.ResponseWriter, r *http.Request) {
+	stop, err := h.g.GetStopByID(gtfs.Key(r.PathValue("id")))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stop)
+}
+
+func (h *Handler) getStopDepartures(w http.ResponseWriter, r *http.Request) {
+	stopID := gtfs.Key(r.PathValue("id"))
+
+	at := time.Now()
+	if atStr := r.URL.Query().Get("at"); atStr != "" {
+		parsed, err := time.Parse(time.RFC3339, atStr)
+		if err != nil {
+			writeBadRequest(w, "invalid at: "+err.Error())
+			return
+		}
+		at = parsed
+	}
+
+	window := defaultDepartureWindow
+	if windowStr := r.URL.Query().Get("window"); windowStr != "" {
+		parsed, err := time.ParseDuration(windowStr)
+		if err != nil || parsed < 0 {
+			writeBadRequest(w, "invalid window: "+windowStr)
+			return
+		}
+		window = parsed
+	}
+
+	// Make sure the stop exists, so unknown stops are not reported as having no departures
+	_, err := h.g.GetStopByID(stopID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	departures, err := h.g.GetStopDepartures(stopID, at, window)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, departures)
+}
+
+func (h *Handler) getRoute(w http.ResponseWriter, r *http.Request) {
+	route, err := h.g.GetRouteByID(gtfs.Key(r.PathValue("id")))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, route)
+}
+
+func (h *Handler) getRouteTrips(w http.ResponseWriter, r *http.Request) {
+	trips, err := h.g.GetTripsByRouteID(gtfs.Key(r.PathValue("id")))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, trips)
+}
+
+func (h *Handler) getTrip(w http.ResponseWriter, r *http.Request) {
+	trip, err := h.g.GetTripByID(gtfs.Key(r.PathValue("id")))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, trip)
+}
+
+func (h *Handler) search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		writeBadRequest(w, "missing q")
+		return
+	}
+
+	results, err := h.g.Search(query)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
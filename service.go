@@ -2,11 +2,12 @@ package gtfs
 
 import (
 	"encoding/binary"
-	"encoding/csv"
 	"errors"
 	"io"
 	"strconv"
 	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 // Flag for each day of the week
@@ -24,10 +25,10 @@ const (
 
 // Represents the days of the week a service is active
 type Service struct {
-	ID        Key
-	Weekdays  WeekdayFlag
-	StartDate time.Time
-	EndDate   time.Time
+	ID        Key         `json:"service_id"`
+	Weekdays  WeekdayFlag `json:"weekdays"`
+	StartDate time.Time   `json:"start_date"`
+	EndDate   time.Time   `json:"end_date"`
 }
 type ServiceMap map[Key]*Service
 
@@ -99,6 +100,40 @@ func (s *Service) Decode(id Key, data []byte) error {
 	return nil
 }
 
+// EncodeProto serializes the Service struct (excluding ID) into protobuf wire format.
+// See the Service message in gtfs.proto.
+func (s Service) EncodeProto() []byte {
+	var data []byte
+	data = appendProtoVarint(data, 1, uint64(s.Weekdays))
+	data = appendProtoSint(data, 2, s.StartDate.Unix())
+	data = appendProtoSint(data, 3, s.EndDate.Unix())
+	return data
+}
+
+// DecodeProto deserializes protobuf wire format data into the Service struct.
+func (s *Service) DecodeProto(id Key, data []byte) error {
+	if s == nil {
+		return errors.New("cannot decode into a nil Service")
+	}
+	*s = Service{
+		ID:        id,
+		StartDate: time.Unix(0, 0).UTC(),
+		EndDate:   time.Unix(0, 0).UTC(),
+	}
+
+	return decodeProtoFields(data, func(num protowire.Number, v protoValue) error {
+		switch num {
+		case 1:
+			s.Weekdays = WeekdayFlag(v.varint)
+		case 2:
+			s.StartDate = time.Unix(protowire.DecodeZigZag(v.varint), 0).UTC()
+		case 3:
+			s.EndDate = time.Unix(protowire.DecodeZigZag(v.varint), 0).UTC()
+		}
+		return nil
+	})
+}
+
 // Parses a weekday flag from the GTFS calendar.txt file
 func parseWeekdayFlag(day string, flag WeekdayFlag) WeekdayFlag {
 	dayInt, err := strconv.Atoi(day)
@@ -110,28 +145,43 @@ func parseWeekdayFlag(day string, flag WeekdayFlag) WeekdayFlag {
 
 // Load and parse services from the GTFS calendar.txt file
 func ParseServices(file io.Reader) (ServiceMap, error) {
-	// Read file using CSV reader
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	services, _, err := ParseServicesWithOptions(file, ParseOptions{})
+	return services, err
+}
+
+// Load and parse services from the GTFS calendar.txt file, handling malformed rows according to the given options
+func ParseServicesWithOptions(file io.Reader, opts ParseOptions) (ServiceMap, *FileReport, error) {
+	// Read file using CSV parser
+	parser, err := newCSVParser("calendar.txt", file, opts)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	services := make(ServiceMap)
-	for i, record := range records {
-		if i == 0 {
-			continue // skip header
+	for {
+		record, err := parser.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
 		}
 
 		// Parse record into Service struct
 		id := Key(record[0])
 		startDate, err := time.ParseInLocation("20060102", record[8], time.UTC)
 		if err != nil {
-			return nil, err
+			if err := parser.skip(parser.spec("invalid_date", "calendar.start_date must be a date (YYYYMMDD)", err)); err != nil {
+				return nil, nil, err
+			}
+			continue
 		}
 		endDate, err := time.ParseInLocation("20060102", record[9], time.UTC)
 		if err != nil {
-			return nil, err
+			if err := parser.skip(parser.spec("invalid_date", "calendar.end_date must be a date (YYYYMMDD)", err)); err != nil {
+				return nil, nil, err
+			}
+			continue
 		}
 		weekdays := parseWeekdayFlag(record[1], MondayWeekdayFlag) |
 			parseWeekdayFlag(record[2], TuesdayWeekdayFlag) |
@@ -141,6 +191,9 @@ func ParseServices(file io.Reader) (ServiceMap, error) {
 			parseWeekdayFlag(record[6], SaturdayWeekdayFlag) |
 			parseWeekdayFlag(record[7], SundayWeekdayFlag)
 
+		if _, exists := services[id]; exists {
+			parser.duplicate(string(id))
+		}
 		services[id] = &Service{
 			ID:        id,
 			Weekdays:  weekdays,
@@ -149,5 +202,5 @@ func ParseServices(file io.Reader) (ServiceMap, error) {
 		}
 	}
 
-	return services, nil
+	return services, parser.report, nil
 }
@@ -0,0 +1,176 @@
+package gtfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// A change to whether a trip runs on a date, such as a cancellation or an extra trip announced at short notice
+type ServiceChange struct {
+	TripID Key           `json:"trip_id"`
+	Date   time.Time     `json:"date"`
+	Type   ExceptionType `json:"action"` // RemovedExceptionType for cancellations
+}
+
+// Service changes layered over the schedule, keyed by trip ID and date
+type serviceChangeLayer struct {
+	mu      sync.RWMutex
+	changes map[string]ExceptionType
+}
+
+// Returns the key of a trip's service change on the date of the given time
+func serviceChangeKey(tripID Key, t time.Time) string {
+	return string(tripID) + " " + t.Format("20060102")
+}
+
+// Load and parse service changes from a CSV file with "trip_id", "date" and "action" columns.
+// Dates are written as YYYYMMDD or YYYY-MM-DD. Actions are "cancel" (or "2", as in calendar_dates.txt)
+// to cancel the trip on the date, and "add" (or "1") to run it on the date regardless of its service.
+func ParseServiceChanges(file io.Reader) ([]ServiceChange, error) {
+	parser, err := newCSVParser("service changes", file, ParseOptions{})
+	if err != nil {
+		return nil, err
+	}
+	err = parser.require("trip_id", "date", "action")
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []ServiceChange{}
+	for {
+		record, err := parser.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		tripID := Key(parser.get(record, "trip_id"))
+		if tripID == "" {
+			return nil, fmt.Errorf("line %d: missing trip_id", parser.line)
+		}
+
+		dateStr := parser.get(record, "date")
+		date, err := time.ParseInLocation("20060102", dateStr, time.UTC)
+		if err != nil {
+			date, err = time.ParseInLocation("2006-01-02", dateStr, time.UTC)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q", parser.line, dateStr)
+		}
+
+		var changeType ExceptionType
+		switch action := strings.ToLower(parser.get(record, "action")); action {
+		case "cancel", "2":
+			changeType = RemovedExceptionType
+		case "add", "1":
+			changeType = AddedExceptionType
+		default:
+			return nil, fmt.Errorf("line %d: invalid action %q", parser.line, action)
+		}
+
+		changes = append(changes, ServiceChange{TripID: tripID, Date: date, Type: changeType})
+	}
+	return changes, nil
+}
+
+// Sets the layer's change to a trip on a date
+func (l *serviceChangeLayer) set(tripID Key, date time.Time, changeType ExceptionType) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.changes == nil {
+		l.changes = make(map[string]ExceptionType)
+	}
+	l.changes[serviceChangeKey(tripID, date)] = changeType
+}
+
+// Returns the layer's change to the trip on the date of the given time, if any
+func (l *serviceChangeLayer) get(tripID Key, t time.Time) (ExceptionType, bool) {
+	if l == nil {
+		return false, false
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	change, ok := l.changes[serviceChangeKey(tripID, t)]
+	return change, ok
+}
+
+// Returns the IDs of the trips the layer adds on the date of the given time
+func (l *serviceChangeLayer) added(t time.Time) []Key {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	suffix := " " + t.Format("20060102")
+	var tripIDs []Key
+	for key, change := range l.changes {
+		if change == AddedExceptionType && strings.HasSuffix(key, suffix) {
+			tripIDs = append(tripIDs, Key(strings.TrimSuffix(key, suffix)))
+		}
+	}
+	return tripIDs
+}
+
+// Reads service changes from a CSV file as described in ParseServiceChanges, and applies them to the
+// results of trip time queries such as GetStopDepartures and GetCurrentTrips for the affected dates.
+// Changes are held in memory on top of any already applied, with later changes to the same trip and date
+// replacing earlier ones. Changes to unknown trips are logged and ignored. The file is rejected entirely
+// if any row is invalid.
+func (g *GTFS) ApplyServiceChanges(r io.Reader) error {
+	if g.serviceChanges == nil {
+		return errors.New("database not open")
+	}
+
+	changes, err := ParseServiceChanges(r)
+	if err != nil {
+		return err
+	}
+
+	tripIDs := make([]Key, len(changes))
+	for i, change := range changes {
+		tripIDs[i] = change.TripID
+	}
+	trips, err := g.GetTripsByIDs(tripIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		if _, ok := trips[change.TripID]; !ok {
+			log.Warnf("Ignoring service change for unknown trip %s", change.TripID)
+			continue
+		}
+		g.serviceChanges.set(change.TripID, change.Date, change.Type)
+	}
+	return nil
+}
+
+// Removes all applied service changes
+func (g *GTFS) ClearServiceChanges() {
+	if g.serviceChanges == nil {
+		return
+	}
+
+	g.serviceChanges.mu.Lock()
+	defer g.serviceChanges.mu.Unlock()
+	g.serviceChanges.changes = nil
+}
+
+// Check if the trip runs on the day of the given time, taking applied service changes into account.
+// Service results are stored in the cache as with isServiceRunning.
+func (g *GTFS) isTripRunning(trip *Trip, t time.Time, cache map[Key]bool) (bool, error) {
+	if change, ok := g.serviceChanges.get(trip.ID, t); ok {
+		return change == AddedExceptionType, nil
+	}
+	return g.isServiceRunning(trip.ServiceID, t, cache)
+}
@@ -0,0 +1,190 @@
+package gtfs
+
+import (
+	"bytes"
+	"errors"
+	"math/bits"
+	"slices"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// The days on which a service runs over a range of dates, stored as one bit per day so that checking
+// a date is a constant-time lookup
+type ServiceDays struct {
+	first time.Time // First day of the range, at midnight in the location the days were computed in
+	days  int       // Number of days in the range
+	bits  []uint64
+}
+
+// Returns the number of whole days from the epoch to the calendar date of the given time
+func civilDay(t time.Time) int {
+	year, month, day := t.Date()
+	return int(time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+// Returns the days between from and to (inclusive) on which the service runs, given its exceptions. Days are
+// the calendar dates of from and to in from's location (usually the feed's timezone), and each is checked at
+// noon as with the other schedule queries. Exceptions of other services are ignored.
+func (s *Service) ActiveDates(from, to time.Time, exceptions ...*ServiceException) *ServiceDays {
+	loc := from.Location()
+	year, month, day := from.Date()
+	first := time.Date(year, month, day, 0, 0, 0, 0, loc)
+
+	byDate := make(map[string]*ServiceException, len(exceptions))
+	for _, exception := range exceptions {
+		if exception.ServiceID == s.ID {
+			byDate[exception.Date.Format("20060102")] = exception
+		}
+	}
+
+	days := max(civilDay(to.In(loc))-civilDay(first)+1, 0)
+	result := &ServiceDays{
+		first: first,
+		days:  days,
+		bits:  make([]uint64, (days+63)/64),
+	}
+	for i := range days {
+		noon := time.Date(year, month, day+i, 12, 0, 0, 0, loc)
+		if serviceRunsOn(s, byDate[noon.Format("20060102")], noon) {
+			result.bits[i/64] |= 1 << (i % 64)
+		}
+	}
+	return result
+}
+
+// Check if the service runs on the calendar date of the given time, in the location the days were computed in.
+// Dates outside the range are reported as not running.
+func (d *ServiceDays) Contains(date time.Time) bool {
+	i := civilDay(date.In(d.first.Location())) - civilDay(d.first)
+	if i < 0 || i >= d.days {
+		return false
+	}
+	return d.bits[i/64]&(1<<(i%64)) != 0
+}
+
+// Returns the number of days on which the service runs
+func (d *ServiceDays) Count() int {
+	count := 0
+	for _, word := range d.bits {
+		count += bits.OnesCount64(word)
+	}
+	return count
+}
+
+// Returns the dates on which the service runs in order, each at midnight in the location the days were computed in
+func (d *ServiceDays) Dates() []time.Time {
+	year, month, day := d.first.Date()
+	dates := make([]time.Time, 0, d.Count())
+	for i := range d.days {
+		if d.bits[i/64]&(1<<(i%64)) != 0 {
+			dates = append(dates, time.Date(year, month, day+i, 0, 0, 0, 0, d.first.Location()))
+		}
+	}
+	return dates
+}
+
+// Active days of services, computed on first use
+type serviceDaysCache struct {
+	mu   sync.Mutex
+	days map[Key]*ServiceDays
+}
+
+// Discard the computed days of all services, such as after their exceptions change
+func (c *serviceDaysCache) reset() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.days = nil
+	c.mu.Unlock()
+}
+
+// Returns the days on which the service runs between its start and end dates in the feed's timezone, taking
+// its exceptions into account. The days are computed on first use and kept for later calls, so repeated checks
+// of the same service are constant-time lookups.
+func (g *GTFS) GetServiceDays(serviceID Key) (*ServiceDays, error) {
+	if g.serviceDays == nil {
+		return nil, errors.New("database not open")
+	}
+
+	g.serviceDays.mu.Lock()
+	cached, ok := g.serviceDays.days[serviceID]
+	g.serviceDays.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	service, err := g.GetServiceByID(serviceID)
+	if err != nil {
+		return nil, err
+	}
+	timezone, err := g.getFeedTimezone()
+	if err != nil {
+		return nil, err
+	}
+	exceptions, err := g.getServiceExceptions(serviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	startYear, startMonth, startDay := service.StartDate.Date()
+	endYear, endMonth, endDay := service.EndDate.Date()
+	days := service.ActiveDates(
+		time.Date(startYear, startMonth, startDay, 0, 0, 0, 0, timezone),
+		time.Date(endYear, endMonth, endDay, 0, 0, 0, 0, timezone),
+		exceptions...,
+	)
+
+	g.serviceDays.mu.Lock()
+	if g.serviceDays.days == nil {
+		g.serviceDays.days = make(map[Key]*ServiceDays)
+	}
+	g.serviceDays.days[serviceID] = days
+	g.serviceDays.mu.Unlock()
+	return days, nil
+}
+
+// Returns the exceptions of the service, seeking to them since they are keyed by service ID and date
+func (g *GTFS) getServiceExceptions(serviceID Key) ([]*ServiceException, error) {
+	exceptions := []*ServiceException{}
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("serviceExceptions"))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+
+		prefix := compositeKey([]byte(serviceID))
+		c := b.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			exception := &ServiceException{}
+			err := decodeServiceException(exception, v, g.Encoding)
+			if err != nil {
+				return err
+			}
+			exceptions = append(exceptions, exception)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Exceptions added through the overrides layer replace those on the same date
+	added, err := g.addedServiceExceptions()
+	if err != nil {
+		return nil, err
+	}
+	for _, exception := range added {
+		if exception.ServiceID != serviceID {
+			continue
+		}
+		exceptions = slices.DeleteFunc(exceptions, func(e *ServiceException) bool {
+			return e.Date.Equal(exception.Date)
+		})
+		exceptions = append(exceptions, exception)
+	}
+	return exceptions, nil
+}
@@ -2,11 +2,12 @@ package gtfs
 
 import (
 	"encoding/binary"
-	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
 	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 // Enum for the types of service exception
@@ -19,9 +20,9 @@ const (
 
 // Represents an exception for a service on a specific date
 type ServiceException struct {
-	ServiceID Key
-	Date      time.Time
-	Type      ExceptionType
+	ServiceID Key           `json:"service_id"`
+	Date      time.Time     `json:"date"`
+	Type      ExceptionType `json:"exception_type"`
 }
 type ServiceExceptionKey struct {
 	ServiceID Key
@@ -114,26 +115,81 @@ func (se *ServiceException) Decode(data []byte) error {
 	return nil
 }
 
+// EncodeProto serializes the ServiceException struct into protobuf wire format.
+// The type is stored using the GTFS exception_type values (1 = added, 2 = removed).
+// See the ServiceException message in gtfs.proto.
+func (se ServiceException) EncodeProto() []byte {
+	exceptionType := uint64(1)
+	if se.Type == RemovedExceptionType {
+		exceptionType = 2
+	}
+
+	var data []byte
+	data = appendProtoString(data, 1, string(se.ServiceID))
+	data = appendProtoSint(data, 2, se.Date.Unix())
+	data = appendProtoVarint(data, 3, exceptionType)
+	return data
+}
+
+// DecodeProto deserializes protobuf wire format data into the ServiceException struct.
+func (se *ServiceException) DecodeProto(data []byte) error {
+	if se == nil {
+		return errors.New("cannot decode into a nil ServiceException")
+	}
+	*se = ServiceException{Date: time.Unix(0, 0).UTC()}
+
+	return decodeProtoFields(data, func(num protowire.Number, v protoValue) error {
+		switch num {
+		case 1:
+			se.ServiceID = Key(v.string())
+		case 2:
+			se.Date = time.Unix(protowire.DecodeZigZag(v.varint), 0).UTC()
+		case 3:
+			switch v.varint {
+			case 1:
+				se.Type = AddedExceptionType
+			case 2:
+				se.Type = RemovedExceptionType
+			default:
+				return fmt.Errorf("invalid exception type: %d", v.varint)
+			}
+		}
+		return nil
+	})
+}
+
 // Load and parse service exceptions from the GTFS calendar_dates.txt file
 func ParseServiceExceptions(file io.Reader) (ServiceExceptionMap, error) {
-	// Read file using CSV reader
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	exceptions, _, err := ParseServiceExceptionsWithOptions(file, ParseOptions{})
+	return exceptions, err
+}
+
+// Load and parse service exceptions from the GTFS calendar_dates.txt file, handling malformed rows according to the given options
+func ParseServiceExceptionsWithOptions(file io.Reader, opts ParseOptions) (ServiceExceptionMap, *FileReport, error) {
+	// Read file using CSV parser
+	parser, err := newCSVParser("calendar_dates.txt", file, opts)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	exceptions := make(ServiceExceptionMap)
-	for i, record := range records {
-		if i == 0 {
-			continue // skip header
+	for {
+		record, err := parser.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
 		}
 
 		// Parse record into ServiceException struct
 		serviceID := Key(record[0])
 		date, err := time.ParseInLocation("20060102", record[1], time.UTC)
 		if err != nil {
-			return nil, err
+			if err := parser.skip(parser.spec("invalid_date", "calendar_dates.date must be a date (YYYYMMDD)", err)); err != nil {
+				return nil, nil, err
+			}
+			continue
 		}
 		var exceptionType ExceptionType
 		switch record[2] {
@@ -142,7 +198,10 @@ func ParseServiceExceptions(file io.Reader) (ServiceExceptionMap, error) {
 		case "2":
 			exceptionType = RemovedExceptionType
 		default:
-			return nil, errors.New("invalid exception type")
+			if err := parser.skip(parser.spec("unexpected_enum_value", "calendar_dates.exception_type must be 1 or 2", errors.New("invalid exception type"))); err != nil {
+				return nil, nil, err
+			}
+			continue
 		}
 
 		key := ServiceExceptionKey{
@@ -150,6 +209,9 @@ func ParseServiceExceptions(file io.Reader) (ServiceExceptionMap, error) {
 			Date:      date,
 		}
 
+		if _, exists := exceptions[key]; exists {
+			parser.duplicate(string(serviceID) + " " + key.Date.Format("20060102"))
+		}
 		exceptions[key] = &ServiceException{
 			ServiceID: serviceID,
 			Date:      date,
@@ -157,5 +219,5 @@ func ParseServiceExceptions(file io.Reader) (ServiceExceptionMap, error) {
 		}
 	}
 
-	return exceptions, nil
+	return exceptions, parser.report, nil
 }
@@ -1,15 +1,22 @@
 package gtfs
 
 import (
-	"encoding/csv"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"io"
-	"strconv"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 // Represents the shape of a transit route
 type Shape struct {
-	ID          Key
-	Coordinates CoordinateArray
+	ID          Key             `json:"shape_id"`
+	Coordinates CoordinateArray `json:"coordinates"`
 }
 type ShapeMap map[Key]*Shape
 
@@ -27,35 +34,90 @@ func (s *Shape) Decode(id Key, data []byte) error {
 	return s.Coordinates.Decode(data)
 }
 
+// EncodeProto serializes the Shape struct (excluding ID) into protobuf wire format.
+// See the Shape message in gtfs.proto.
+func (s Shape) EncodeProto() []byte {
+	var data []byte
+	for _, coord := range s.Coordinates {
+		data = appendProtoMessage(data, 1, coord.EncodeProto())
+	}
+	return data
+}
+
+// DecodeProto deserializes protobuf wire format data into the Shape struct.
+func (s *Shape) DecodeProto(id Key, data []byte) error {
+	if s == nil {
+		return errors.New("cannot decode into a nil Shape")
+	}
+	*s = Shape{ID: id, Coordinates: CoordinateArray{}}
+
+	return decodeProtoFields(data, func(num protowire.Number, v protoValue) error {
+		switch num {
+		case 1:
+			var coord Coordinate
+			err := coord.DecodeProto(v.bytes)
+			if err != nil {
+				return err
+			}
+			s.Coordinates = append(s.Coordinates, coord)
+		}
+		return nil
+	})
+}
+
 // Load and parse shapes from the GTFS shapes.txt file
 func ParseShapes(file io.Reader) (ShapeMap, int, error) {
-	// Read file using CSV reader
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	shapes, _, err := ParseShapesWithOptions(file, ParseOptions{})
 	if err != nil {
 		return nil, 0, err
 	}
 
+	maxShapeLength := 0
+	for _, shape := range shapes {
+		if len(shape.Coordinates) > maxShapeLength {
+			maxShapeLength = len(shape.Coordinates)
+		}
+	}
+	return shapes, maxShapeLength, nil
+}
+
+// Load and parse shapes from the GTFS shapes.txt file, handling malformed rows according to the given options
+func ParseShapesWithOptions(file io.Reader, opts ParseOptions) (ShapeMap, *FileReport, error) {
+	// Read file using CSV parser
+	parser, err := newCSVParser("shapes.txt", file, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	var currentID Key
 	var currentCoordinates CoordinateArray
 
 	shapes := make(ShapeMap)
-	maxShapeLength := 0
 
-	for i, record := range records {
-		if i == 0 {
-			continue // skip header
+	for {
+		record, err := parser.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
 		}
 
 		// Parse record into Shape struct
 		id := Key(record[0])
-		lat, err := strconv.ParseFloat(record[1], 64)
+		lat, err := parser.parseDegrees(record[1])
 		if err != nil {
-			return nil, 0, err
+			if err := parser.skip(parser.spec("invalid_float", "shapes.shape_pt_lat must be decimal degrees", err)); err != nil {
+				return nil, nil, err
+			}
+			continue
 		}
-		lon, err := strconv.ParseFloat(record[2], 64)
+		lon, err := parser.parseDegrees(record[2])
 		if err != nil {
-			return nil, 0, err
+			if err := parser.skip(parser.spec("invalid_float", "shapes.shape_pt_lon must be decimal degrees", err)); err != nil {
+				return nil, nil, err
+			}
+			continue
 		}
 
 		if id != currentID {
@@ -64,9 +126,10 @@ func ParseShapes(file io.Reader) (ShapeMap, int, error) {
 					ID:          currentID,
 					Coordinates: currentCoordinates,
 				}
-				if len(currentCoordinates) > maxShapeLength {
-					maxShapeLength = len(currentCoordinates)
-				}
+			}
+			// Shapes are read in contiguous blocks, so a block for a shape already read replaces it
+			if _, exists := shapes[id]; exists {
+				parser.duplicate(string(id))
 			}
 			currentID = id
 			currentCoordinates = []Coordinate{}
@@ -84,10 +147,93 @@ func ParseShapes(file io.Reader) (ShapeMap, int, error) {
 			ID:          currentID,
 			Coordinates: currentCoordinates,
 		}
-		if len(currentCoordinates) > maxShapeLength {
-			maxShapeLength = len(currentCoordinates)
+	}
+
+	ids := slices.Sorted(maps.Keys(shapes))
+	refs := []coordinateRef{}
+	for _, id := range ids {
+		for i := range shapes[id].Coordinates {
+			refs = append(refs, coordinateRef{id, &shapes[id].Coordinates[i]})
 		}
 	}
+	parser.checkCoordinates(refs)
 
-	return shapes, maxShapeLength, nil
+	return shapes, parser.report, nil
+}
+
+// Encode the shape as a Google encoded polyline with the given precision in decimal places
+// (5 for the standard polyline format, 6 for polyline6). Use DecodePolyline to read it back.
+func (s Shape) EncodePolyline(precision int) string {
+	return encodePolyline(s.Coordinates, precision)
+}
+
+// Default tolerance, in degrees, within which shape coordinates are considered identical when deduplicating
+const DefaultShapeDedupTolerance = 1e-5
+
+// Returns a hash of the shape's geometry, with coordinates snapped to a grid of the given tolerance
+// so that near-identical shapes share a hash
+func shapeGeometryHash(shape *Shape, tolerance float64) [sha256.Size]byte {
+	h := sha256.New()
+	buf := make([]byte, 16)
+	for _, coord := range shape.Coordinates {
+		binary.BigEndian.PutUint64(buf[0:8], uint64(int64(math.Round(coord.Latitude/tolerance))))
+		binary.BigEndian.PutUint64(buf[8:16], uint64(int64(math.Round(coord.Longitude/tolerance))))
+		h.Write(buf)
+	}
+
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// Merges shapes whose geometry is identical within the given tolerance (in degrees) into the shape with
+// the lowest ID, removing the duplicates and rewriting the shape IDs of trips which referenced them.
+// Returns the number of shapes removed.
+func deduplicateShapes(shapes ShapeMap, trips TripMap, tolerance float64) int {
+	if tolerance <= 0 {
+		tolerance = DefaultShapeDedupTolerance
+	}
+
+	// Visit shapes in ID order so the canonical shape is deterministic
+	shapeIDs := make([]Key, 0, len(shapes))
+	for shapeID := range shapes {
+		shapeIDs = append(shapeIDs, shapeID)
+	}
+	sort.Slice(shapeIDs, func(i, j int) bool {
+		return shapeIDs[i] < shapeIDs[j]
+	})
+
+	canonical := make(map[[sha256.Size]byte]Key)
+	replacements := make(map[Key]Key)
+	for _, shapeID := range shapeIDs {
+		hash := shapeGeometryHash(shapes[shapeID], tolerance)
+		if canonicalID, ok := canonical[hash]; ok {
+			replacements[shapeID] = canonicalID
+			delete(shapes, shapeID)
+			continue
+		}
+		canonical[hash] = shapeID
+	}
+
+	for _, trip := range trips {
+		if canonicalID, ok := replacements[trip.ShapeID]; ok {
+			trip.ShapeID = canonicalID
+		}
+	}
+
+	return len(replacements)
+}
+
+// Returns the number of trips referencing each shape
+func getShapeRefCounts(shapes ShapeMap, trips TripMap) map[Key]uint32 {
+	counts := make(map[Key]uint32, len(shapes))
+	for shapeID := range shapes {
+		counts[shapeID] = 0
+	}
+	for _, trip := range trips {
+		if _, ok := counts[trip.ShapeID]; ok {
+			counts[trip.ShapeID]++
+		}
+	}
+	return counts
 }
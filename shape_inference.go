@@ -0,0 +1,169 @@
+package gtfs
+
+import (
+	"encoding/binary"
+	"sort"
+	"strconv"
+
+	"github.com/charmbracelet/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Prefix of the IDs given to shapes inferred for trips without a shape ID
+const derivedShapePrefix = "derived-"
+
+// Routes paths between consecutive stops along a road or rail network, so that inferred shapes follow the
+// network rather than running straight between stops
+type ShapeSnapper interface {
+	// Returns the path from one stop to the next, including both stops
+	SnapSegment(from, to Coordinate) (CoordinateArray, error)
+}
+
+// Returns the path through the coordinates of the stops in order, snapped to the network if a snapper is
+// given. Segments which cannot be snapped are joined by a straight line.
+func inferShapePath(stops []Coordinate, snapper ShapeSnapper) CoordinateArray {
+	path := CoordinateArray{}
+	for i, stop := range stops {
+		if i == 0 || snapper == nil {
+			if len(path) == 0 || path[len(path)-1] != stop {
+				path = append(path, stop)
+			}
+			continue
+		}
+
+		segment, err := snapper.SnapSegment(stops[i-1], stop)
+		if err != nil || len(segment) == 0 {
+			log.Debugf("Joining stops with a straight line as the segment could not be snapped: %v", err)
+			segment = CoordinateArray{stops[i-1], stop}
+		}
+		for _, coord := range segment {
+			if path[len(path)-1] != coord {
+				path = append(path, coord)
+			}
+		}
+	}
+	return path
+}
+
+// Synthesize shapes for the feed's trips which have no shape by connecting their stops, storing them in
+// the feed's derived shapes. Trips referencing a missing shape are given one under that ID, from the trip
+// with the lowest ID. Trips without a shape ID are given a shape for each distinct stop sequence of their
+// route, with their shape IDs rewritten to it. Returns the number of shapes inferred.
+func inferShapes(feed *Feed, snapper ShapeSnapper) int {
+	tripIDs := make([]Key, 0, len(feed.Trips))
+	for tripID := range feed.Trips {
+		tripIDs = append(tripIDs, tripID)
+	}
+	sort.Slice(tripIDs, func(i, j int) bool { return tripIDs[i] < tripIDs[j] })
+
+	// Returns the shape connecting the trip's stops, or nil if it has fewer than two distinct locations
+	shapeOf := func(id Key, trip *Trip) *Shape {
+		stops := make([]Coordinate, 0, len(trip.Stops))
+		for _, tripStop := range trip.Stops {
+			if stop, ok := feed.Stops[tripStop.StopID]; ok {
+				stops = append(stops, stop.Location)
+			}
+		}
+		path := inferShapePath(stops, snapper)
+		if len(path) < 2 {
+			return nil
+		}
+		return &Shape{ID: id, Coordinates: path}
+	}
+
+	derived := make(ShapeMap)
+	patternShapes := make(map[Key]map[string]Key) // Shape IDs by route, then by encoded stop sequence
+	routePatterns := make(map[Key]int)            // Number of shape IDs assigned to each route
+	for _, tripID := range tripIDs {
+		trip := feed.Trips[tripID]
+		if trip.ShapeID != "" {
+			if _, ok := feed.Shapes[trip.ShapeID]; ok {
+				continue
+			}
+			if _, ok := derived[trip.ShapeID]; ok {
+				continue
+			}
+			if shape := shapeOf(trip.ShapeID, trip); shape != nil {
+				derived[shape.ID] = shape
+			}
+			continue
+		}
+
+		stopIDs := make(KeyArray, len(trip.Stops))
+		for i, stop := range trip.Stops {
+			stopIDs[i] = stop.StopID
+		}
+		pattern := string(stopIDs.Encode())
+		if patternShapes[trip.RouteID] == nil {
+			patternShapes[trip.RouteID] = make(map[string]Key)
+		}
+		if shapeID, ok := patternShapes[trip.RouteID][pattern]; ok {
+			trip.ShapeID = shapeID
+			continue
+		}
+
+		// Pick the next ID for the route which is not already used by a shape
+		var shapeID Key
+		for {
+			routePatterns[trip.RouteID]++
+			shapeID = Key(derivedShapePrefix + string(trip.RouteID) + "-" + strconv.Itoa(routePatterns[trip.RouteID]))
+			_, used := feed.Shapes[shapeID]
+			_, usedDerived := derived[shapeID]
+			if !used && !usedDerived {
+				break
+			}
+		}
+		shape := shapeOf(shapeID, trip)
+		if shape == nil {
+			continue
+		}
+		derived[shapeID] = shape
+		patternShapes[trip.RouteID][pattern] = shapeID
+		trip.ShapeID = shapeID
+	}
+
+	feed.derivedShapes = derived
+	return len(derived)
+}
+
+// Store the derived shapes in the derivedShapes bucket, and their reference counts with those of the
+// feed's shapes
+func populateDerivedShapes(db *bolt.DB, enc Encoding, shapes ShapeMap, trips TripMap) error {
+	if len(shapes) == 0 {
+		return nil
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("derivedShapes"))
+		if err != nil {
+			return err
+		}
+		for _, shape := range shapes {
+			err = b.Put([]byte(shape.ID), encodeEntity(shape, enc))
+			if err != nil {
+				return err
+			}
+		}
+
+		counts, err := tx.CreateBucketIfNotExists([]byte("shapeRefCounts"))
+		if err != nil {
+			return err
+		}
+		for shapeID, count := range getShapeRefCounts(shapes, trips) {
+			err = counts.Put([]byte(shapeID), binary.BigEndian.AppendUint32(nil, count))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Returns the encoded derived shape with the given ID, or nil if there is none
+func derivedShapeData(tx *bolt.Tx, shapeID Key) []byte {
+	b := tx.Bucket([]byte("derivedShapes"))
+	if b == nil {
+		return nil
+	}
+	return b.Get([]byte(shapeID))
+}
@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"strings"
 
 	"github.com/paulmach/orb"
 	"github.com/paulmach/orb/geo"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 type Key string
@@ -89,8 +91,8 @@ func (ka *KeyArray) Decode(data []byte) error {
 
 // Represents a geographical coordinate with latitude and longitude.
 type Coordinate struct {
-	Latitude  float64
-	Longitude float64
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
 }
 
 // Create a new Coordinate instance with the given latitude and longitude.
@@ -172,6 +174,33 @@ func (c *Coordinate) Decode(data []byte) error {
 	return nil
 }
 
+// Encode the Coordinate into protobuf wire format
+// See the Coordinate message in gtfs.proto.
+func (c Coordinate) EncodeProto() []byte {
+	var data []byte
+	data = appendProtoDouble(data, 1, c.Latitude)
+	data = appendProtoDouble(data, 2, c.Longitude)
+	return data
+}
+
+// Decode protobuf wire format data into a Coordinate
+func (c *Coordinate) DecodeProto(data []byte) error {
+	if c == nil {
+		return errors.New("cannot decode into a nil Coordinate")
+	}
+	*c = Coordinate{}
+
+	return decodeProtoFields(data, func(num protowire.Number, v protoValue) error {
+		switch num {
+		case 1:
+			c.Latitude = v.float64()
+		case 2:
+			c.Longitude = v.float64()
+		}
+		return nil
+	})
+}
+
 type CoordinateArray []Coordinate
 
 // Encode the CoordinateArray into a byte slice
@@ -238,3 +267,26 @@ func (ca *CoordinateArray) Decode(data []byte) error {
 	}
 	return nil
 }
+
+// --- CSV Header ---
+
+// Maps the column names in a GTFS file header to their indices
+type csvHeader map[string]int
+
+// Create a new csvHeader from the header record of a GTFS file
+func newCSVHeader(record []string) csvHeader {
+	header := make(csvHeader, len(record))
+	for i, name := range record {
+		header[strings.TrimSpace(name)] = i
+	}
+	return header
+}
+
+// Return the value of the named column in the record, or an empty string if the column is not present
+func (h csvHeader) get(record []string, name string) string {
+	i, ok := h[name]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return record[i]
+}
@@ -0,0 +1,45 @@
+package gtfs
+
+import (
+	"errors"
+)
+
+// A consistent, read-only view of a GTFS database.
+// All queries made through a snapshot share a single pinned read transaction, so a burst
+// of queries (e.g. serving one HTTP request) sees the same data and avoids the overhead
+// of opening a transaction per call. A snapshot is not safe for concurrent use, and must
+// be released with Close once it is no longer needed.
+type Snapshot struct {
+	*GTFS
+}
+
+// Returns a snapshot of the GTFS database, which must be closed after use
+func (g *GTFS) Snapshot() (*Snapshot, error) {
+	if g.db == nil {
+		return nil, errors.New("database not open")
+	}
+	if g.tx != nil {
+		return nil, errors.New("cannot snapshot a snapshot")
+	}
+
+	tx, err := g.openDB().Begin(false)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := *g
+	snapshot.tx = tx
+	return &Snapshot{GTFS: &snapshot}, nil
+}
+
+// Releases the snapshot's read transaction. The database itself remains open.
+func (s *Snapshot) Close() error {
+	if s.tx == nil {
+		return nil
+	}
+
+	err := s.tx.Rollback()
+	s.tx = nil
+	s.db = nil
+	return err
+}
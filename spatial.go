@@ -0,0 +1,327 @@
+package gtfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+	"github.com/paulmach/orb/project"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Factor by which a stop's projected distance may exceed the cutoff and still be re-ranked by
+// its true distance, allowing for the distortion of the projection away from the query point.
+// This covers queries spanning a few hundred kilometres at all but polar latitudes.
+const projectionCandidateMargin = 1.05
+
+// A stop and its distance from a query coordinate
+type NearbyStop struct {
+	Stop     *Stop   `json:"stop"`
+	Distance float64 `json:"distance"` // Metres
+}
+
+// A stop ID and its squared projected distance from a query coordinate
+type projectedCandidate struct {
+	stopID Key
+	distSq float64
+}
+
+// Project the coordinate to web mercator
+func projectCoordinate(c Coordinate) orb.Point {
+	return project.WGS84.ToMercator(orb.Point{c.Longitude, c.Latitude})
+}
+
+// Encode a projected point
+// Format:
+// - X: 8 bytes (float64)
+// - Y: 8 bytes (float64)
+func encodeProjection(p orb.Point) []byte {
+	data := make([]byte, float64Bytes*2)
+	binary.BigEndian.PutUint64(data, math.Float64bits(p.X()))
+	binary.BigEndian.PutUint64(data[float64Bytes:], math.Float64bits(p.Y()))
+	return data
+}
+
+// Decode a projected point
+func decodeProjection(data []byte) (orb.Point, error) {
+	if len(data) != float64Bytes*2 {
+		return orb.Point{}, errors.New("invalid projection length")
+	}
+	return orb.Point{
+		math.Float64frombits(binary.BigEndian.Uint64(data)),
+		math.Float64frombits(binary.BigEndian.Uint64(data[float64Bytes:])),
+	}, nil
+}
+
+// Returns up to limit stops nearest to the coordinate, ordered by distance. A limit of zero or less
+// returns all stops, and a positive maxDistance (in metres) excludes stops further away than it.
+// If the database was ingested with ProjectStops, candidates are found by comparing projected
+// coordinates, and only those are ranked by their true (haversine) distance. Otherwise every stop
+// is ranked by its true distance.
+func (g *GTFS) GetNearestStops(coord Coordinate, limit int, maxDistance float64) ([]NearbyStop, error) {
+	candidates, ok, err := g.getProjectedCandidates(coord, limit, maxDistance)
+	if err != nil {
+		return nil, err
+	}
+
+	var stops StopMap
+	if ok {
+		stopIDs := make([]Key, len(candidates))
+		for i, candidate := range candidates {
+			stopIDs[i] = candidate.stopID
+		}
+		stops, err = g.GetStopsByIDs(stopIDs)
+	} else {
+		stops, err = g.GetAllStops()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nearby := make([]NearbyStop, 0, len(stops))
+	for _, stop := range stops {
+		distance := coord.DistanceTo(stop.Location)
+		if maxDistance > 0 && distance > maxDistance {
+			continue
+		}
+		nearby = append(nearby, NearbyStop{Stop: stop, Distance: distance})
+	}
+
+	slices.SortFunc(nearby, func(a, b NearbyStop) int {
+		if a.Distance != b.Distance {
+			if a.Distance < b.Distance {
+				return -1
+			}
+			return 1
+		}
+		if a.Stop.ID < b.Stop.ID {
+			return -1
+		} else if a.Stop.ID > b.Stop.ID {
+			return 1
+		}
+		return 0
+	})
+	if limit > 0 && len(nearby) > limit {
+		nearby = nearby[:limit]
+	}
+	return nearby, nil
+}
+
+// Returns the stops which may be among the nearest to the coordinate, by comparing squared
+// distances between projected coordinates. The second result is false if the database does
+// not hold projected stop coordinates.
+func (g *GTFS) getProjectedCandidates(coord Coordinate, limit int, maxDistance float64) ([]projectedCandidate, bool, error) {
+	origin := projectCoordinate(coord)
+
+	// Web mercator stretches distances by 1/cos(latitude), so scale them back near the query point
+	scale := math.Cos(coord.Latitude * math.Pi / 180)
+	scaleSq := scale * scale
+	marginSq := projectionCandidateMargin * projectionCandidateMargin
+	maxDistSq := maxDistance * maxDistance * marginSq
+
+	var candidates []projectedCandidate
+	found := false
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("stopProjections"))
+		if b == nil {
+			return nil
+		}
+		found = true
+
+		candidates = make([]projectedCandidate, 0, b.Stats().KeyN)
+		return b.ForEach(func(k, v []byte) error {
+			p, err := decodeProjection(v)
+			if err != nil {
+				return err
+			}
+			dx, dy := p.X()-origin.X(), p.Y()-origin.Y()
+			distSq := (dx*dx + dy*dy) * scaleSq
+			if maxDistance > 0 && distSq > maxDistSq {
+				return nil
+			}
+			candidates = append(candidates, projectedCandidate{stopID: Key(k), distSq: distSq})
+			return nil
+		})
+	})
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	// Keep the nearest candidates, plus any close enough to them to be nearer in reality
+	if limit > 0 && len(candidates) > limit {
+		slices.SortFunc(candidates, func(a, b projectedCandidate) int {
+			if a.distSq < b.distSq {
+				return -1
+			} else if a.distSq > b.distSq {
+				return 1
+			}
+			return 0
+		})
+		cutoff := candidates[limit-1].distSq * marginSq
+		end := limit
+		for end < len(candidates) && candidates[end].distSq <= cutoff {
+			end++
+		}
+		candidates = candidates[:end]
+	}
+	return candidates, true, nil
+}
+
+// A stop near a shape, with its distance from the shape and its position along it
+type CorridorStop struct {
+	Stop     *Stop   `json:"stop"`
+	Distance float64 `json:"distance"` // Metres from the shape
+	Along    float64 `json:"along"`    // Metres along the shape to the point nearest the stop
+}
+
+// Returns the stops which may lie within the bounding box of longitudes and latitudes. If the database was
+// ingested with ProjectStops, only the stops whose projected coordinates fall within the projected box are
+// read. Otherwise, or if any stops are overridden, every stop is returned.
+func (g *GTFS) getStopsInBound(bound orb.Bound) (StopMap, error) {
+	// Overridden stops may have moved, or be missing from the projections
+	if len(g.overrides.all(StopEntityType)) > 0 {
+		return g.GetAllStops()
+	}
+
+	// Web mercator preserves the order of longitudes and latitudes, so the box projects to a box
+	lower := project.WGS84.ToMercator(bound.Min)
+	upper := project.WGS84.ToMercator(bound.Max)
+
+	var stopIDs []Key
+	found := false
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("stopProjections"))
+		if b == nil {
+			return nil
+		}
+		found = true
+
+		return b.ForEach(func(k, v []byte) error {
+			p, err := decodeProjection(v)
+			if err != nil {
+				return err
+			}
+			if p.X() >= lower.X() && p.X() <= upper.X() && p.Y() >= lower.Y() && p.Y() <= upper.Y() {
+				stopIDs = append(stopIDs, Key(k))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return g.GetAllStops()
+	}
+	return g.GetStopsByIDs(stopIDs)
+}
+
+// Returns the stops within the polygon, sorted by ID. The polygon is given by its vertices in order, and
+// need not be closed. Its edges are straight lines between longitudes and latitudes.
+func (g *GTFS) GetStopsInPolygon(poly []Coordinate) ([]*Stop, error) {
+	if len(poly) < 3 {
+		return nil, errors.New("polygon must have at least 3 vertices")
+	}
+	ring := make(orb.Ring, 0, len(poly)+1)
+	for _, c := range poly {
+		ring = append(ring, orb.Point{c.Longitude, c.Latitude})
+	}
+	if !ring[0].Equal(ring[len(ring)-1]) {
+		ring = append(ring, ring[0])
+	}
+
+	candidates, err := g.getStopsInBound(ring.Bound())
+	if err != nil {
+		return nil, err
+	}
+	stops := []*Stop{}
+	for _, stop := range candidates {
+		if planar.RingContains(ring, orb.Point{stop.Location.Longitude, stop.Location.Latitude}) {
+			stops = append(stops, stop)
+		}
+	}
+	slices.SortFunc(stops, func(a, b *Stop) int { return strings.Compare(string(a.ID), string(b.ID)) })
+	return stops, nil
+}
+
+// Returns the stops within the buffer (in metres) of the shape, ordered by their position along it, for
+// corridor studies. Distances are measured in a local flat projection around each stop, which is accurate
+// for buffers and shape segments up to a few kilometres.
+func (g *GTFS) GetStopsAlongShape(shapeID Key, bufferMeters float64) ([]CorridorStop, error) {
+	shape, err := g.GetShapeByID(shapeID)
+	if err != nil {
+		return nil, err
+	}
+	if len(shape.Coordinates) == 0 {
+		return nil, errors.New("shape has no coordinates")
+	}
+
+	// Pad the shape's bounding box by the buffer, at the latitude where degrees of longitude are shortest
+	bound := shape.Coordinates.lineString().Bound()
+	maxLatitude := math.Max(math.Abs(bound.Min.Lat()), math.Abs(bound.Max.Lat()))
+	metresPerDegree := orb.EarthRadius * math.Pi / 180
+	latPad := bufferMeters / metresPerDegree
+	lonPad := math.Min(bufferMeters/(metresPerDegree*math.Cos(maxLatitude*math.Pi/180)), 180)
+	bound.Min = orb.Point{bound.Min.Lon() - lonPad, bound.Min.Lat() - latPad}
+	bound.Max = orb.Point{bound.Max.Lon() + lonPad, bound.Max.Lat() + latPad}
+
+	candidates, err := g.getStopsInBound(bound)
+	if err != nil {
+		return nil, err
+	}
+
+	// Distance along the shape to the start of each segment
+	offsets := make([]float64, len(shape.Coordinates))
+	for i := 1; i < len(shape.Coordinates); i++ {
+		offsets[i] = offsets[i-1] + shape.Coordinates[i-1].DistanceTo(shape.Coordinates[i])
+	}
+
+	corridor := []CorridorStop{}
+	for _, stop := range candidates {
+		// Project the shape to metres around the stop, which is at the origin
+		origin := stop.Location
+		scale := math.Cos(origin.Latitude * math.Pi / 180)
+		local := func(c Coordinate) orb.Point {
+			return orb.Point{(c.Longitude - origin.Longitude) * scale * metresPerDegree, (c.Latitude - origin.Latitude) * metresPerDegree}
+		}
+
+		best := CorridorStop{Stop: stop, Distance: math.Inf(1)}
+		if len(shape.Coordinates) == 1 {
+			best.Distance = origin.DistanceTo(shape.Coordinates[0])
+		}
+		for i := 1; i < len(shape.Coordinates); i++ {
+			a, b := local(shape.Coordinates[i-1]), local(shape.Coordinates[i])
+			distance := planar.DistanceFromSegment(a, b, orb.Point{0, 0})
+			if distance >= best.Distance {
+				continue
+			}
+
+			// Fraction of the segment before the point nearest the stop
+			dx, dy := b.X()-a.X(), b.Y()-a.Y()
+			var t float64
+			if lengthSq := dx*dx + dy*dy; lengthSq > 0 {
+				t = math.Max(0, math.Min(1, -(a.X()*dx+a.Y()*dy)/lengthSq))
+			}
+			best.Distance = distance
+			best.Along = offsets[i-1] + t*(offsets[i]-offsets[i-1])
+		}
+		if best.Distance <= bufferMeters {
+			corridor = append(corridor, best)
+		}
+	}
+
+	slices.SortFunc(corridor, func(a, b CorridorStop) int {
+		if a.Along != b.Along {
+			if a.Along < b.Along {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(string(a.Stop.ID), string(b.Stop.ID))
+	})
+	return corridor, nil
+}
@@ -0,0 +1,285 @@
+package gtfs
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// A table of the relational schema written by ExportSQL
+type sqlTable struct {
+	name       string
+	columns    []string // Column definitions, in insertion order
+	primaryKey string
+	indexes    []string // Columns indexed for lookups
+
+	// Inserts the feed's rows, with a value for each column
+	rows func(g *GTFS, insert func(values ...any) error) error
+}
+
+// Tables written by ExportSQL, in the order they are created
+var sqlTables = []sqlTable{
+	{
+		name:       "agency",
+		columns:    []string{"agency_id TEXT NOT NULL", "agency_name TEXT", "agency_url TEXT", "agency_timezone TEXT", "agency_lang TEXT", "agency_phone TEXT", "agency_fare_url TEXT", "agency_email TEXT"},
+		primaryKey: "agency_id",
+		rows:       sqlAgencyRows,
+	},
+	{
+		name:       "stops",
+		columns:    []string{"stop_id TEXT NOT NULL", "stop_code TEXT", "stop_name TEXT", "stop_desc TEXT", "stop_lat DOUBLE PRECISION", "stop_lon DOUBLE PRECISION", "zone_id TEXT", "stop_url TEXT", "location_type INTEGER", "parent_station TEXT", "platform_code TEXT", "wheelchair_boarding INTEGER"},
+		primaryKey: "stop_id",
+		indexes:    []string{"parent_station", "zone_id"},
+		rows:       sqlStopRows,
+	},
+	{
+		name:       "routes",
+		columns:    []string{"route_id TEXT NOT NULL", "agency_id TEXT", "route_short_name TEXT", "route_type INTEGER", "route_color TEXT"},
+		primaryKey: "route_id",
+		indexes:    []string{"agency_id"},
+		rows:       sqlRouteRows,
+	},
+	{
+		name:       "calendar",
+		columns:    []string{"service_id TEXT NOT NULL", "monday INTEGER", "tuesday INTEGER", "wednesday INTEGER", "thursday INTEGER", "friday INTEGER", "saturday INTEGER", "sunday INTEGER", "start_date TEXT", "end_date TEXT"},
+		primaryKey: "service_id",
+		rows:       sqlServiceRows,
+	},
+	{
+		name:       "calendar_dates",
+		columns:    []string{"service_id TEXT NOT NULL", "date TEXT NOT NULL", "exception_type INTEGER"},
+		primaryKey: "service_id, date",
+		indexes:    []string{"date"},
+		rows:       sqlServiceExceptionRows,
+	},
+	{
+		name:       "trips",
+		columns:    []string{"trip_id TEXT NOT NULL", "route_id TEXT", "service_id TEXT", "trip_headsign TEXT", "direction_id INTEGER", "shape_id TEXT"},
+		primaryKey: "trip_id",
+		indexes:    []string{"route_id", "service_id", "shape_id"},
+		rows:       sqlTripRows,
+	},
+	{
+		name:       "stop_times",
+		columns:    []string{"trip_id TEXT NOT NULL", "stop_sequence INTEGER NOT NULL", "stop_id TEXT", "arrival_time INTEGER", "departure_time INTEGER", "timepoint INTEGER"},
+		primaryKey: "trip_id, stop_sequence",
+		indexes:    []string{"stop_id"},
+		rows:       sqlStopTimeRows,
+	},
+	{
+		name:       "shapes",
+		columns:    []string{"shape_id TEXT NOT NULL", "shape_pt_sequence INTEGER NOT NULL", "shape_pt_lat DOUBLE PRECISION", "shape_pt_lon DOUBLE PRECISION"},
+		primaryKey: "shape_id, shape_pt_sequence",
+		rows:       sqlShapeRows,
+	},
+}
+
+// Returns the statements which drop and recreate the table and its indexes
+func (t sqlTable) createStatements() []string {
+	statements := []string{
+		"DROP TABLE IF EXISTS " + t.name,
+		fmt.Sprintf("CREATE TABLE %s (%s, PRIMARY KEY (%s))", t.name, strings.Join(t.columns, ", "), t.primaryKey),
+	}
+	for _, column := range t.indexes {
+		statements = append(statements, fmt.Sprintf("CREATE INDEX %s_%s_idx ON %s (%s)", t.name, column, t.name, column))
+	}
+	return statements
+}
+
+// Returns the statement which inserts a row into the table, with numbered placeholders
+func (t sqlTable) insertStatement() string {
+	names := make([]string, len(t.columns))
+	placeholders := make([]string, len(t.columns))
+	for i, column := range t.columns {
+		names[i], _, _ = strings.Cut(column, " ")
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", t.name, strings.Join(names, ", "), strings.Join(placeholders, ", "))
+}
+
+// Writes the feed to a relational database as tables modelled on the GTFS files (agency, stops, routes,
+// calendar, calendar_dates, trips, stop_times and shapes), with primary keys and indexes on the columns
+// used to join them. Existing tables with those names are replaced. The database can be opened with any
+// database/sql driver whose SQL accepts $1-style placeholders, such as SQLite and PostgreSQL drivers.
+// Everything is written in a single transaction, so a failed export leaves the database unchanged.
+// Stop times are in seconds since the start of the service day, and dates are written as YYYYMMDD.
+func (g *GTFS) ExportSQL(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range sqlTables {
+		for _, statement := range table.createStatements() {
+			_, err := tx.Exec(statement)
+			if err != nil {
+				return fmt.Errorf("%s: %w", table.name, err)
+			}
+		}
+
+		err = g.insertSQLRows(tx, table)
+		if err != nil {
+			return fmt.Errorf("%s: %w", table.name, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Insert the feed's rows into the table
+func (g *GTFS) insertSQLRows(tx *sql.Tx, table sqlTable) error {
+	stmt, err := tx.Prepare(table.insertStatement())
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	return table.rows(g, func(values ...any) error {
+		_, err := stmt.Exec(values...)
+		return err
+	})
+}
+
+// Returns 1 if the flag is set, and 0 otherwise
+func sqlFlag(set bool) int {
+	if set {
+		return 1
+	}
+	return 0
+}
+
+func sqlAgencyRows(g *GTFS, insert func(values ...any) error) error {
+	agencies, err := g.GetAllAgencies()
+	if err != nil {
+		return err
+	}
+	for _, id := range sortedIDs(agencies) {
+		a := agencies[id]
+		err := insert(string(a.ID), a.Name, a.URL, a.Timezone, a.Lang, a.Phone, a.FareURL, a.Email)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sqlStopRows(g *GTFS, insert func(values ...any) error) error {
+	stops, err := g.GetAllStops()
+	if err != nil {
+		return err
+	}
+	for _, id := range sortedIDs(stops) {
+		s := stops[id]
+		err := insert(
+			string(s.ID), s.Code, s.Name, s.Description, s.Location.Latitude, s.Location.Longitude,
+			string(s.ZoneID), s.URL, int(s.LocationType), string(s.ParentID), s.PlatformCode, int(s.WheelchairBoarding),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sqlRouteRows(g *GTFS, insert func(values ...any) error) error {
+	routes, err := g.GetAllRoutes()
+	if err != nil {
+		return err
+	}
+	for _, id := range sortedIDs(routes) {
+		r := routes[id]
+		err := insert(string(r.ID), string(r.AgencyID), r.Name, int(r.Type), r.Colour)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sqlServiceRows(g *GTFS, insert func(values ...any) error) error {
+	services, err := g.GetAllServices()
+	if err != nil {
+		return err
+	}
+	for _, id := range sortedIDs(services) {
+		s := services[id]
+		err := insert(
+			string(s.ID),
+			sqlFlag(s.Weekdays&MondayWeekdayFlag != 0), sqlFlag(s.Weekdays&TuesdayWeekdayFlag != 0),
+			sqlFlag(s.Weekdays&WednesdayWeekdayFlag != 0), sqlFlag(s.Weekdays&ThursdayWeekdayFlag != 0),
+			sqlFlag(s.Weekdays&FridayWeekdayFlag != 0), sqlFlag(s.Weekdays&SaturdayWeekdayFlag != 0),
+			sqlFlag(s.Weekdays&SundayWeekdayFlag != 0),
+			s.StartDate.Format("20060102"), s.EndDate.Format("20060102"),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sqlServiceExceptionRows(g *GTFS, insert func(values ...any) error) error {
+	exceptions, err := g.GetAllServiceExceptions()
+	if err != nil {
+		return err
+	}
+	for _, e := range exceptions {
+		exceptionType := 1
+		if e.Type == RemovedExceptionType {
+			exceptionType = 2
+		}
+		err := insert(string(e.ServiceID), e.Date.Format("20060102"), exceptionType)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sqlTripRows(g *GTFS, insert func(values ...any) error) error {
+	trips, err := g.GetAllTrips()
+	if err != nil {
+		return err
+	}
+	for _, id := range sortedIDs(trips) {
+		t := trips[id]
+		err := insert(string(t.ID), string(t.RouteID), string(t.ServiceID), t.Headsign, sqlFlag(t.Direction == InboundTripDirection), string(t.ShapeID))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sqlStopTimeRows(g *GTFS, insert func(values ...any) error) error {
+	trips, err := g.GetAllTrips()
+	if err != nil {
+		return err
+	}
+	for _, id := range sortedIDs(trips) {
+		for i, stop := range trips[id].Stops {
+			err := insert(string(id), i, string(stop.StopID), int(stop.ArrivalTime), int(stop.DepartureTime), sqlFlag(stop.Timepoint == ExactTripTimepoint))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func sqlShapeRows(g *GTFS, insert func(values ...any) error) error {
+	shapes, err := g.GetAllShapes()
+	if err != nil {
+		return err
+	}
+	for _, id := range sortedIDs(shapes) {
+		for i, coord := range shapes[id].Coordinates {
+			err := insert(string(id), i, coord.Latitude, coord.Longitude)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package gtfs
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/charmbracelet/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Statistics of the reads made by a single query
+type QueryStats struct {
+	Query        string        `json:"query"`         // Name of the method which made the query
+	KeysRead     int           `json:"keys_read"`     // Number of entity values read and decoded
+	BytesDecoded int           `json:"bytes_decoded"` // Total size of the entity values decoded
+	Duration     time.Duration `json:"duration"`      // Time spent in the query's read transaction
+}
+
+// Options for collecting statistics of queries
+type QueryStatsOptions struct {
+	OnQuery            func(QueryStats) // Called with the statistics of every query, if set
+	SlowQueryThreshold time.Duration    // Queries taking at least this long are logged as warnings, if positive
+}
+
+// Collects statistics of queries, counting the reads made within each read transaction
+type queryStatsCollector struct {
+	opts     QueryStatsOptions
+	counters sync.Map // Counters of the queries in progress, by transaction (shared by a snapshot's queries)
+}
+
+// Reads counted within a transaction
+type queryStatsCounter struct {
+	keys  int
+	bytes int
+}
+
+// Enable collecting statistics of each query, reporting them to the callback and logging slow queries.
+// Queries made within another query's transaction, such as through GetTripDetail, are counted towards it.
+// Reads are counted by transaction, and the queries of a snapshot share one, so statistics are unreliable
+// for queries made concurrently through a snapshot, which are counted towards whichever started first.
+// Passing empty options disables collection. Must not be called while queries are in progress; snapshots
+// keep the options in effect when they were taken.
+func (g *GTFS) SetQueryStats(opts QueryStatsOptions) {
+	if opts.OnQuery == nil && opts.SlowQueryThreshold <= 0 {
+		g.stats = nil
+		return
+	}
+	g.stats = &queryStatsCollector{opts: opts}
+}
+
+// Wrap the query's transaction function to count its reads and report its statistics
+func (c *queryStatsCollector) measure(query string, fn func(tx *bolt.Tx) error) func(tx *bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		counter := &queryStatsCounter{}
+		if _, nested := c.counters.LoadOrStore(tx, counter); nested {
+			return fn(tx)
+		}
+		defer c.counters.Delete(tx)
+
+		start := time.Now()
+		err := fn(tx)
+		c.report(QueryStats{
+			Query:        query,
+			KeysRead:     counter.keys,
+			BytesDecoded: counter.bytes,
+			Duration:     time.Since(start),
+		})
+		return err
+	}
+}
+
+// Count an entity value decoded within the transaction
+func (c *queryStatsCollector) record(tx *bolt.Tx, size int) {
+	value, ok := c.counters.Load(tx)
+	if !ok {
+		return
+	}
+	counter := value.(*queryStatsCounter)
+	counter.keys++
+	counter.bytes += size
+}
+
+// Count an entity value read within the transaction, if statistics are being collected
+func (g *GTFS) countRead(tx *bolt.Tx, data []byte) {
+	if g.stats != nil {
+		g.stats.record(tx, len(data))
+	}
+}
+
+// Pass the statistics to the callback, logging the query if it was slow
+func (c *queryStatsCollector) report(stats QueryStats) {
+	if c.opts.SlowQueryThreshold > 0 && stats.Duration >= c.opts.SlowQueryThreshold {
+		log.Warnf("Slow query %s took %v (%d keys read, %d bytes decoded)", stats.Query, stats.Duration, stats.KeysRead, stats.BytesDecoded)
+	}
+	if c.opts.OnQuery != nil {
+		c.opts.OnQuery(stats)
+	}
+}
+
+// Returns the name of the nearest exported function the given number of frames up the stack, or of the
+// function at that frame if there is none. Names are returned without their package, receiver, type
+// parameters or closure suffixes.
+func queryName(skip int) string {
+	pcs := make([]uintptr, 16)
+	n := runtime.Callers(skip+1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	first := ""
+	for {
+		frame, more := frames.Next()
+		name := functionName(frame.Function)
+		if first == "" {
+			first = name
+		}
+		if name != "" && unicode.IsUpper([]rune(name)[0]) {
+			return name
+		}
+		if !more {
+			break
+		}
+	}
+	if first == "" {
+		return "unknown"
+	}
+	return first
+}
+
+// Returns the bare name of a function from its fully qualified name
+func functionName(name string) string {
+	name = strings.ReplaceAll(name, "[...]", "")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	for {
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			return name
+		}
+		part := name[i+1:]
+		if !strings.HasPrefix(part, "func") && strings.Trim(part, "0123456789") != "" {
+			return part
+		}
+		name = name[:i]
+	}
+}
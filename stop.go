@@ -2,16 +2,20 @@ package gtfs
 
 import (
 	"encoding/binary"
-	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"slices"
 	"strconv"
 	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 type LocationType uint8
 type ModeFlag uint8
+type WheelchairBoarding uint8
 
 const (
 	StopLocationType LocationType = iota
@@ -30,15 +34,27 @@ const (
 	UnknownModeFlag = 0
 )
 
+const (
+	UnknownWheelchairBoarding WheelchairBoarding = iota
+	AccessibleWheelchairBoarding
+	InaccessibleWheelchairBoarding
+)
+
 // Represents a stop, platform, or station in a transit system
 type Stop struct {
-	ID             Key
-	Code           string
-	Name           string
-	ParentID       Key
-	Location       Coordinate
-	LocationType   LocationType
-	SupportedModes ModeFlag
+	ID                 Key                `json:"stop_id"`
+	Code               string             `json:"stop_code"`
+	Name               string             `json:"stop_name"`
+	ParentID           Key                `json:"parent_station"`
+	Location           Coordinate         `json:"location"`
+	LocationType       LocationType       `json:"location_type"`
+	SupportedModes     ModeFlag           `json:"supported_modes"`
+	Description        string             `json:"stop_desc"`
+	ZoneID             Key                `json:"zone_id"`
+	URL                string             `json:"stop_url"`
+	PlatformCode       string             `json:"platform_code"`
+	TTSName            string             `json:"tts_stop_name"`
+	WheelchairBoarding WheelchairBoarding `json:"wheelchair_boarding"`
 }
 type StopMap map[Key]*Stop
 
@@ -50,11 +66,22 @@ type StopMap map[Key]*Stop
 // - Location: 2 * float64 (fixed size)
 // - LocationType: 1 byte (LocationType enum)
 // - SupportedModes: 1 byte (bitmask for each mode)
+// - Description: 4-byte length + UTF-8 string
+// - ZoneID: 4-byte length + UTF-8 string
+// - URL: 4-byte length + UTF-8 string
+// - PlatformCode: 4-byte length + UTF-8 string
+// - TTSName: 4-byte length + UTF-8 string
+// - WheelchairBoarding: 1 byte (WheelchairBoarding enum)
 func (s Stop) Encode() []byte {
 	codeStr := s.Code
 	nameStr := s.Name
 	parentIDStr := string(s.ParentID)
 	locationBytes := s.Location.Encode() // Coordinate.Encode() returns a fixed-size slice
+	descriptionStr := s.Description
+	zoneIDStr := string(s.ZoneID)
+	urlStr := s.URL
+	platformCodeStr := s.PlatformCode
+	ttsNameStr := s.TTSName
 
 	// Calculate total length
 	totalLen := lenBytes + len(codeStr) + // Code
@@ -62,7 +89,13 @@ func (s Stop) Encode() []byte {
 		lenBytes + len(parentIDStr) + // ParentID
 		len(locationBytes) + // Location (fixed size: 2 * float64Bytes)
 		uint8Bytes + // LocationType
-		uint8Bytes // SupportedModes
+		uint8Bytes + // SupportedModes
+		lenBytes + len(descriptionStr) + // Description
+		lenBytes + len(zoneIDStr) + // ZoneID
+		lenBytes + len(urlStr) + // URL
+		lenBytes + len(platformCodeStr) + // PlatformCode
+		lenBytes + len(ttsNameStr) + // TTSName
+		uint8Bytes // WheelchairBoarding
 
 	data := make([]byte, totalLen)
 	offset := 0
@@ -95,6 +128,40 @@ func (s Stop) Encode() []byte {
 
 	// Marshal SupportedModes
 	data[offset] = byte(s.SupportedModes)
+	offset += uint8Bytes
+
+	// Marshal Description
+	binary.BigEndian.PutUint32(data[offset:], uint32(len(descriptionStr)))
+	offset += lenBytes
+	copy(data[offset:], descriptionStr)
+	offset += len(descriptionStr)
+
+	// Marshal ZoneID
+	binary.BigEndian.PutUint32(data[offset:], uint32(len(zoneIDStr)))
+	offset += lenBytes
+	copy(data[offset:], zoneIDStr)
+	offset += len(zoneIDStr)
+
+	// Marshal URL
+	binary.BigEndian.PutUint32(data[offset:], uint32(len(urlStr)))
+	offset += lenBytes
+	copy(data[offset:], urlStr)
+	offset += len(urlStr)
+
+	// Marshal PlatformCode
+	binary.BigEndian.PutUint32(data[offset:], uint32(len(platformCodeStr)))
+	offset += lenBytes
+	copy(data[offset:], platformCodeStr)
+	offset += len(platformCodeStr)
+
+	// Marshal TTSName
+	binary.BigEndian.PutUint32(data[offset:], uint32(len(ttsNameStr)))
+	offset += lenBytes
+	copy(data[offset:], ttsNameStr)
+	offset += len(ttsNameStr)
+
+	// Marshal WheelchairBoarding
+	data[offset] = byte(s.WheelchairBoarding)
 
 	return data
 }
@@ -170,6 +237,73 @@ func (s *Stop) Decode(id Key, data []byte) error {
 	s.SupportedModes = ModeFlag(data[offset])
 	offset += uint8Bytes
 
+	// Unmarshal Description
+	if offset+lenBytes > len(data) {
+		return errors.New("stop buffer too small for Description length")
+	}
+	descriptionLen := binary.BigEndian.Uint32(data[offset:])
+	offset += lenBytes
+	if offset+int(descriptionLen) > len(data) {
+		return errors.New("stop buffer too small for Description content")
+	}
+	s.Description = string(data[offset : offset+int(descriptionLen)])
+	offset += int(descriptionLen)
+
+	// Unmarshal ZoneID
+	if offset+lenBytes > len(data) {
+		return errors.New("stop buffer too small for ZoneID length")
+	}
+	zoneIDLen := binary.BigEndian.Uint32(data[offset:])
+	offset += lenBytes
+	if offset+int(zoneIDLen) > len(data) {
+		return errors.New("stop buffer too small for ZoneID content")
+	}
+	s.ZoneID = Key(data[offset : offset+int(zoneIDLen)])
+	offset += int(zoneIDLen)
+
+	// Unmarshal URL
+	if offset+lenBytes > len(data) {
+		return errors.New("stop buffer too small for URL length")
+	}
+	urlLen := binary.BigEndian.Uint32(data[offset:])
+	offset += lenBytes
+	if offset+int(urlLen) > len(data) {
+		return errors.New("stop buffer too small for URL content")
+	}
+	s.URL = string(data[offset : offset+int(urlLen)])
+	offset += int(urlLen)
+
+	// Unmarshal PlatformCode
+	if offset+lenBytes > len(data) {
+		return errors.New("stop buffer too small for PlatformCode length")
+	}
+	platformCodeLen := binary.BigEndian.Uint32(data[offset:])
+	offset += lenBytes
+	if offset+int(platformCodeLen) > len(data) {
+		return errors.New("stop buffer too small for PlatformCode content")
+	}
+	s.PlatformCode = string(data[offset : offset+int(platformCodeLen)])
+	offset += int(platformCodeLen)
+
+	// Unmarshal TTSName
+	if offset+lenBytes > len(data) {
+		return errors.New("stop buffer too small for TTSName length")
+	}
+	ttsNameLen := binary.BigEndian.Uint32(data[offset:])
+	offset += lenBytes
+	if offset+int(ttsNameLen) > len(data) {
+		return errors.New("stop buffer too small for TTSName content")
+	}
+	s.TTSName = string(data[offset : offset+int(ttsNameLen)])
+	offset += int(ttsNameLen)
+
+	// Unmarshal WheelchairBoarding
+	if offset+uint8Bytes > len(data) {
+		return errors.New("stop buffer too small for WheelchairBoarding")
+	}
+	s.WheelchairBoarding = WheelchairBoarding(data[offset])
+	offset += uint8Bytes
+
 	// Check if all data was consumed
 	if offset != len(data) {
 		return errors.New("stop buffer not fully consumed, trailing data exists")
@@ -178,6 +312,66 @@ func (s *Stop) Decode(id Key, data []byte) error {
 	return nil
 }
 
+// EncodeProto serializes the Stop struct (excluding ID) into protobuf wire format.
+// See the Stop message in gtfs.proto.
+func (s Stop) EncodeProto() []byte {
+	var data []byte
+	data = appendProtoString(data, 1, s.Code)
+	data = appendProtoString(data, 2, s.Name)
+	data = appendProtoString(data, 3, string(s.ParentID))
+	data = appendProtoMessage(data, 4, s.Location.EncodeProto())
+	data = appendProtoVarint(data, 5, uint64(s.LocationType))
+	data = appendProtoVarint(data, 6, uint64(s.SupportedModes))
+	data = appendProtoString(data, 7, s.Description)
+	data = appendProtoString(data, 8, string(s.ZoneID))
+	data = appendProtoString(data, 9, s.URL)
+	data = appendProtoString(data, 10, s.PlatformCode)
+	data = appendProtoString(data, 11, s.TTSName)
+	data = appendProtoVarint(data, 12, uint64(s.WheelchairBoarding))
+	return data
+}
+
+// DecodeProto deserializes protobuf wire format data into the Stop struct.
+func (s *Stop) DecodeProto(id Key, data []byte) error {
+	if s == nil {
+		return errors.New("cannot decode into a nil Stop")
+	}
+	*s = Stop{ID: id}
+
+	return decodeProtoFields(data, func(num protowire.Number, v protoValue) error {
+		switch num {
+		case 1:
+			s.Code = v.string()
+		case 2:
+			s.Name = v.string()
+		case 3:
+			s.ParentID = Key(v.string())
+		case 4:
+			err := s.Location.DecodeProto(v.bytes)
+			if err != nil {
+				return fmt.Errorf("failed to decode Location: %w", err)
+			}
+		case 5:
+			s.LocationType = LocationType(v.varint)
+		case 6:
+			s.SupportedModes = ModeFlag(v.varint)
+		case 7:
+			s.Description = v.string()
+		case 8:
+			s.ZoneID = Key(v.string())
+		case 9:
+			s.URL = v.string()
+		case 10:
+			s.PlatformCode = v.string()
+		case 11:
+			s.TTSName = v.string()
+		case 12:
+			s.WheelchairBoarding = WheelchairBoarding(v.varint)
+		}
+		return nil
+	})
+}
+
 // Parse a string into a ModeFlag
 func parseModeFlag(mode string) ModeFlag {
 	switch mode {
@@ -196,60 +390,105 @@ func parseModeFlag(mode string) ModeFlag {
 
 // Load and parse stops from the GTFS stops.txt file
 func ParseStops(file io.Reader) (StopMap, error) {
-	// Read file using CSV reader
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	stops, _, err := ParseStopsWithOptions(file, ParseOptions{})
+	return stops, err
+}
+
+// Load and parse stops from the GTFS stops.txt file, handling malformed rows according to the given options
+func ParseStopsWithOptions(file io.Reader, opts ParseOptions) (StopMap, *FileReport, error) {
+	// Read file using CSV parser
+	parser, err := newCSVParser("stops.txt", file, opts)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	if parser.columns == 0 {
+		return nil, nil, errors.New("stops file is empty")
+	}
+	err = parser.require("stop_id")
+	if err != nil {
+		return nil, nil, err
 	}
 
 	stops := make(StopMap)
-	for i, record := range records {
-		if i == 0 {
-			continue // skip header
+	for {
+		record, err := parser.next()
+		if err == io.EOF {
+			break
 		}
-
-		// Parse record into Stop struct
-		id := Key(record[2])
-		code := record[3]
-		name := record[4]
-		parentID := Key(record[1])
-
-		lat, err := strconv.ParseFloat(record[6], 64)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		lon, err := strconv.ParseFloat(record[7], 64)
-		if err != nil {
-			return nil, err
+
+		// Parse record into Stop struct
+		id := Key(parser.get(record, "stop_id"))
+		code := parser.get(record, "stop_code")
+		name := parser.get(record, "stop_name")
+		parentID := Key(parser.get(record, "parent_station"))
+
+		latStr := parser.get(record, "stop_lat")
+		lonStr := parser.get(record, "stop_lon")
+		lat, latErr := parser.parseDegrees(latStr)
+		lon, lonErr := parser.parseDegrees(lonStr)
+		if err := errors.Join(latErr, lonErr); err != nil {
+			if !parser.canRepair() {
+				if err := parser.skip(parser.spec("invalid_float", "stops.stop_lat and stops.stop_lon must be decimal degrees", err)); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			lat, lon = 0, 0
+			parser.repair("invalid location (%q, %q), defaulted to 0, 0", latStr, lonStr)
 		}
 		location := Coordinate{
 			Latitude:  lat,
 			Longitude: lon,
 		}
 
-		typeInt, err := strconv.Atoi(record[0])
+		typeInt, err := strconv.Atoi(parser.get(record, "location_type"))
 		if err != nil {
 			typeInt = int(StopLocationType)
 		}
 		locationType := LocationType(typeInt)
 
+		wheelchairInt, err := strconv.Atoi(parser.get(record, "wheelchair_boarding"))
+		if err != nil || wheelchairInt > int(InaccessibleWheelchairBoarding) {
+			wheelchairInt = int(UnknownWheelchairBoarding)
+		}
+
+		// Modes are a Transperth extension; feeds listing them elsewhere can use a FieldMapper
 		modes := ModeFlag(0)
-		modeStrs := strings.SplitSeq(record[9], ",")
+		modeStrs := strings.SplitSeq(parser.get(record, "supported_modes"), ",")
 		for modeStr := range modeStrs {
 			modes |= parseModeFlag(strings.TrimSpace(modeStr))
 		}
 
+		if _, exists := stops[id]; exists {
+			parser.duplicate(string(id))
+		}
 		stops[id] = &Stop{
-			ID:             id,
-			Code:           code,
-			Name:           name,
-			ParentID:       parentID,
-			Location:       location,
-			LocationType:   locationType,
-			SupportedModes: modes,
+			ID:                 id,
+			Code:               code,
+			Name:               name,
+			ParentID:           parentID,
+			Location:           location,
+			LocationType:       locationType,
+			SupportedModes:     modes,
+			Description:        parser.get(record, "stop_desc"),
+			ZoneID:             Key(parser.get(record, "zone_id")),
+			URL:                parser.get(record, "stop_url"),
+			PlatformCode:       parser.get(record, "platform_code"),
+			TTSName:            parser.get(record, "tts_stop_name"),
+			WheelchairBoarding: WheelchairBoarding(wheelchairInt),
 		}
 	}
 
-	return stops, nil
+	ids := slices.Sorted(maps.Keys(stops))
+	refs := make([]coordinateRef, len(ids))
+	for i, id := range ids {
+		refs[i] = coordinateRef{id, &stops[id].Location}
+	}
+	parser.checkCoordinates(refs)
+
+	return stops, parser.report, nil
 }
@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aaroncutress/gtfs-go/analysis"
+	"github.com/aaroncutress/gtfs-go/gtfstest"
+)
+
+func TestPunctualityAnalysis(t *testing.T) {
+	fixture := gtfstest.New(t, gtfstest.Options{})
+	analyzer, err := analysis.NewAnalyzer(fixture, analysis.Options{})
+	if err != nil {
+		t.Fatalf("Failed to create analyzer: %v", err)
+	}
+
+	// The first outbound trip of route 1 calls at its stops every 5 minutes from 06:00
+	observations := `trip_id,stop_id,time,service_date
+R1-T1,R1-S1,2025-06-02T06:00:30+08:00,
+R1-T1,R1-S2,2025-06-02T06:12:00+08:00,20250602
+R1-T1,R1-S3,2025-06-02T06:08:00+08:00,
+R1-T1,R2-S1,2025-06-02T06:00:00+08:00,
+UNKNOWN,R1-S1,2025-06-02T06:00:00+08:00,
+`
+	err = analyzer.ReadCSV(strings.NewReader(observations))
+	if err != nil {
+		t.Fatalf("Failed to read observations: %v", err)
+	}
+
+	// Observations can also be added directly
+	loc, err := time.LoadLocation("Australia/Perth")
+	if err != nil {
+		t.Fatalf("Failed to load timezone: %v", err)
+	}
+	err = analyzer.Add(analysis.Observation{
+		TripID: gtfstest.TripID(1, 0),
+		StopID: gtfstest.StopID(1, 0),
+		Time:   time.Date(2025, 6, 2, 6, 1, 0, 0, loc),
+	})
+	if err != nil {
+		t.Fatalf("Failed to add observation: %v", err)
+	}
+
+	report := analyzer.Report()
+	overall := report.Overall
+	if overall.Observations != 4 || overall.Early != 1 || overall.OnTime != 2 || overall.Late != 1 {
+		t.Fatalf("Unexpected overall stats: %+v", overall)
+	}
+	if report.Unmatched != 2 {
+		t.Fatalf("Expected 2 unmatched observations, got %d", report.Unmatched)
+	}
+	route := report.Routes[gtfstest.RouteID(0)]
+	if route == nil || route.Observations != 3 || route.MeanDelay() != 110*time.Second {
+		t.Fatalf("Unexpected stats for route 1: %+v", route)
+	}
+	if report.Stops[gtfstest.StopID(0, 1)].Late != 1 {
+		t.Fatalf("Expected a late arrival at the second stop")
+	}
+	if hour := report.Hours[6]; hour == nil || hour.Observations != 4 || hour.OnTimeRate() != 0.5 {
+		t.Fatalf("Unexpected stats for 06:00: %+v", hour)
+	}
+
+	var buf bytes.Buffer
+	err = report.WriteCSV(&buf)
+	if err != nil {
+		t.Fatalf("Failed to write report: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1+1+2+4+1 || lines[1] != "overall,,4,1,2,1,0.5000,97.5" {
+		t.Fatalf("Unexpected report:\n%s", buf.String())
+	}
+}
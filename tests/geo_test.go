@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aaroncutress/gtfs-go"
+	"github.com/aaroncutress/gtfs-go/geo"
+)
+
+const osmData = `<?xml version="1.0" encoding="UTF-8"?>
+<osm version="0.6">
+  <node id="100" lat="-31.9505" lon="115.8605">
+    <tag k="highway" v="bus_stop"/>
+    <tag k="name" v="Hay St Stand 1"/>
+  </node>
+  <node id="101" lat="-31.9506" lon="115.8606">
+    <tag k="highway" v="bus_stop"/>
+    <tag k="name" v="Murray St"/>
+  </node>
+  <node id="102" lat="-31.9505" lon="115.8605">
+    <tag k="amenity" v="bench"/>
+  </node>
+  <node id="103" lat="-32.5" lon="115.5">
+    <tag k="railway" v="station"/>
+    <tag k="name" v="Far Away"/>
+  </node>
+</osm>`
+
+func TestMatchStopsToOSM(t *testing.T) {
+	stops := gtfs.StopMap{
+		"A": {ID: "A", Name: "Hay St Stand 1", Location: gtfs.NewCoordinate(-31.9504, 115.8604)},
+		"B": {ID: "B", Name: "Murray Street", Location: gtfs.NewCoordinate(-31.9505, 115.8606)},
+		"C": {ID: "C", Name: "Nowhere", Location: gtfs.NewCoordinate(-33, 116)},
+	}
+
+	matches, err := geo.MatchStopsToOSM(stops, strings.NewReader(osmData))
+	if err != nil {
+		t.Fatalf("Failed to match stops: %v", err)
+	}
+
+	// Check that the nearby stops are matched to the nodes with similar names
+	expected := map[gtfs.Key]int64{"A": 100, "B": 101}
+	if len(matches) != len(expected) {
+		t.Fatalf("Expected %d matches, got %d: %+v", len(expected), len(matches), matches)
+	}
+	for _, match := range matches {
+		if match.NodeID != expected[match.StopID] {
+			t.Fatalf("Expected stop %s to match node %d, got %d", match.StopID, expected[match.StopID], match.NodeID)
+		}
+		if match.Confidence <= 0 || match.Confidence > 1 {
+			t.Fatalf("Confidence out of range: %f", match.Confidence)
+		}
+	}
+}
+
+func TestMatchStopsToOSMRejectsPBF(t *testing.T) {
+	// The start of a PBF file: the length of the first block header, then its "OSMHeader" type field
+	pbf := append([]byte{0, 0, 0, 13, 0x0a, 0x09}, "OSMHeader\x18\x7b"...)
+	_, err := geo.MatchStopsToOSM(gtfs.StopMap{}, bytes.NewReader(pbf))
+	if !errors.Is(err, geo.ErrPBFNotSupported) {
+		t.Fatalf("Expected PBF data to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,464 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aaroncutress/gtfs-go"
+	"github.com/aaroncutress/gtfs-go/gtfstest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestGTFSTestFixture(t *testing.T) {
+	weekdays := gtfstest.Calendar{
+		Weekdays:  gtfs.MondayWeekdayFlag | gtfs.TuesdayWeekdayFlag | gtfs.WednesdayWeekdayFlag | gtfs.ThursdayWeekdayFlag | gtfs.FridayWeekdayFlag,
+		StartDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC),
+	}
+	weekends := weekdays
+	weekends.Weekdays = gtfs.SaturdayWeekdayFlag | gtfs.SundayWeekdayFlag
+
+	fixture := gtfstest.New(t, gtfstest.Options{
+		Routes:        3,
+		TripsPerRoute: 6,
+		StopsPerRoute: 4,
+		RouteTypes:    []gtfs.RouteType{gtfs.RailRouteType, gtfs.BusRouteType},
+		Calendars:     []gtfstest.Calendar{weekdays, weekends},
+	})
+
+	routes, err := fixture.GetAllRoutes()
+	if err != nil {
+		t.Fatalf("Failed to get routes: %v", err)
+	}
+	if len(routes) != 3 {
+		t.Fatalf("Expected 3 routes, got %d", len(routes))
+	}
+	if routes[gtfstest.RouteID(0)].Type != gtfs.RailRouteType || routes[gtfstest.RouteID(1)].Type != gtfs.BusRouteType {
+		t.Fatal("Expected route types to alternate")
+	}
+
+	trips, err := fixture.GetTripsByRouteID(gtfstest.RouteID(2))
+	if err != nil {
+		t.Fatalf("Failed to get trips: %v", err)
+	}
+	if len(trips) != 6 {
+		t.Fatalf("Expected 6 trips, got %d", len(trips))
+	}
+
+	// Check that the second trip runs inbound on weekends
+	trip := trips[gtfstest.TripID(2, 1)]
+	if trip.Direction != gtfs.InboundTripDirection || trip.ServiceID != gtfstest.ServiceID(1) {
+		t.Fatalf("Unexpected second trip: %v", trip)
+	}
+	if trip.Stops[0].StopID != gtfstest.StopID(2, 3) {
+		t.Fatalf("Expected inbound trip to start at the last stop, got %s", trip.Stops[0].StopID)
+	}
+
+	// Check that departures follow the schedule
+	loc, err := time.LoadLocation("Australia/Perth")
+	if err != nil {
+		t.Fatalf("Failed to load timezone: %v", err)
+	}
+	monday := time.Date(2025, 6, 2, 6, 0, 0, 0, loc)
+	departures, err := fixture.GetStopDepartures(gtfstest.StopID(0, 0), monday, 2*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to get departures: %v", err)
+	}
+	// Outbound trips run on weekdays every hour, and inbound trips end at the first stop
+	if len(departures) != 3 || !departures[0].Time.Equal(monday) || !departures[2].Time.Equal(monday.Add(2*time.Hour)) {
+		t.Fatalf("Expected hourly weekday departures from 06:00 to 08:00, got %v", departures)
+	}
+}
+
+// Tests ingesting with custom database options
+func TestDBOptions(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "gtfs.db")
+	opts := gtfs.IngestOptions{DB: gtfs.DBOptions{
+		FileMode:     0644,
+		Timeout:      time.Second,
+		NoSync:       true,
+		FreelistType: bolt.FreelistMapType,
+		PageSize:     8192,
+	}}
+	fixture := &gtfs.GTFS{}
+	err := fixture.FromFeed(gtfstest.NewFeed(gtfstest.Options{}), dbFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer fixture.Close()
+
+	info, err := os.Stat(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to stat database: %v", err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Fatalf("Expected database permissions 0644, got %v", info.Mode().Perm())
+	}
+
+	_, err = fixture.GetTripByID(gtfstest.TripID(0, 0))
+	if err != nil {
+		t.Fatalf("Failed to get trip: %v", err)
+	}
+
+	// Check that the overrides file is created with the same options
+	err = fixture.SuppressStop(gtfstest.StopID(0, 0))
+	if err != nil {
+		t.Fatalf("Failed to suppress stop: %v", err)
+	}
+	info, err = os.Stat(dbFile + ".overrides")
+	if err != nil {
+		t.Fatalf("Failed to stat overrides file: %v", err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Fatalf("Expected overrides file permissions 0644, got %v", info.Mode().Perm())
+	}
+	overrides, err := bolt.Open(dbFile+".overrides", 0644, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Failed to open overrides file: %v", err)
+	}
+	pageSize := overrides.Info().PageSize
+	overrides.Close()
+	if pageSize != 8192 {
+		t.Fatalf("Expected overrides file page size 8192, got %d", pageSize)
+	}
+
+	// The database can be reopened with the same options
+	reopened := &gtfs.GTFS{}
+	err = reopened.FromDBWithOptions(dbFile, opts.DB)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer reopened.Close()
+	_, err = reopened.GetTripByID(gtfstest.TripID(0, 0))
+	if err != nil {
+		t.Fatalf("Failed to get trip: %v", err)
+	}
+}
+
+// Tests migrating a database from version 17, whose keys concatenated their parts and which did not index
+// the trips visiting each stop
+func TestMigrateDB(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "gtfs.db")
+	feed := gtfstest.NewFeed(gtfstest.Options{})
+	date := time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)
+	exceptionKey := gtfs.ServiceExceptionKey{ServiceID: gtfstest.ServiceID(0), Date: date}
+	feed.ServiceExceptions[exceptionKey] = &gtfs.ServiceException{ServiceID: gtfstest.ServiceID(0), Date: date, Type: gtfs.RemovedExceptionType}
+	fixture := &gtfs.GTFS{}
+	err := fixture.FromFeed(feed, dbFile, gtfs.IngestOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	fixture.Close()
+
+	// Rewrite the keys in the format of version 17
+	db, err := bolt.Open(dbFile, 0600, nil)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for bucket, separator := range map[string][]byte{
+			"serviceExceptions":          nil,
+			"tripsByRouteDirectionIndex": {0},
+			"tripsByStartIndex":          {0},
+		} {
+			b := tx.Bucket([]byte(bucket))
+			entries := make(map[string][]byte)
+			err := b.ForEach(func(k, v []byte) error {
+				var parts [][]byte
+				for len(k) > 0 {
+					size := binary.BigEndian.Uint32(k)
+					parts = append(parts, k[4:4+size])
+					k = k[4+size:]
+				}
+				entries[string(bytes.Join(parts, separator))] = append([]byte(nil), v...)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			err = tx.DeleteBucket([]byte(bucket))
+			if err != nil {
+				return err
+			}
+			b, err = tx.CreateBucket([]byte(bucket))
+			if err != nil {
+				return err
+			}
+			for k, v := range entries {
+				err = b.Put([]byte(k), v)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		err := tx.DeleteBucket([]byte("tripsByStopIndex"))
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("metadata")).Put([]byte("version"), []byte("17"))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatalf("Failed to downgrade database: %v", err)
+	}
+
+	// The old database is rejected until it is migrated
+	migrated := &gtfs.GTFS{}
+	err = migrated.FromDB(dbFile)
+	if err == nil {
+		t.Fatalf("Expected version mismatch before migration")
+	}
+	err = gtfs.MigrateDB(dbFile, gtfs.DBOptions{})
+	if err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	err = migrated.FromDB(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to load migrated database: %v", err)
+	}
+	defer migrated.Close()
+
+	exception, err := migrated.GetServiceException(gtfstest.ServiceID(0), date)
+	if err != nil || exception.Type != gtfs.RemovedExceptionType {
+		t.Fatalf("Expected removed exception after migration, got %v (%v)", exception, err)
+	}
+	trips, err := migrated.GetTripsByRouteAndDirection(gtfstest.RouteID(0), gtfs.InboundTripDirection)
+	if err != nil || len(trips) != 2 {
+		t.Fatalf("Expected 2 inbound trips after migration, got %d (%v)", len(trips), err)
+	}
+	loc, err := time.LoadLocation("Australia/Perth")
+	if err != nil {
+		t.Fatalf("Failed to load timezone: %v", err)
+	}
+	current, err := migrated.GetAllCurrentTripsAt(time.Date(2025, 6, 3, 6, 10, 0, 0, loc))
+	if err != nil || len(current) == 0 {
+		t.Fatalf("Expected current trips after migration, got %d (%v)", len(current), err)
+	}
+	departures, err := migrated.GetStopDepartures(gtfstest.StopID(0, 0), time.Date(2025, 6, 3, 6, 0, 0, 0, loc), 2*time.Hour)
+	if err != nil || len(departures) != 2 {
+		t.Fatalf("Expected 2 departures after migration, got %d (%v)", len(departures), err)
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	feed := gtfstest.NewFeed(gtfstest.Options{})
+
+	builder := gtfs.NewBuilder(filepath.Join(t.TempDir(), "gtfs.db"))
+	for _, agency := range feed.Agencies {
+		builder.AddAgency(agency)
+	}
+	for _, route := range feed.Routes {
+		builder.AddRoute(route)
+	}
+	for _, service := range feed.Services {
+		builder.AddService(service)
+	}
+	for _, shape := range feed.Shapes {
+		builder.AddShape(shape)
+	}
+	for _, stop := range feed.Stops {
+		builder.AddStop(stop)
+	}
+	for _, trip := range feed.Trips {
+		builder.AddTrip(trip)
+	}
+	builder.AddServiceException(&gtfs.ServiceException{
+		ServiceID: gtfstest.ServiceID(0),
+		Date:      time.Date(2030, 12, 25, 9, 0, 0, 0, time.Local),
+		Type:      gtfs.RemovedExceptionType,
+	})
+	built, err := builder.Commit()
+	if err != nil {
+		t.Fatalf("Failed to commit builder: %v", err)
+	}
+	defer built.Close()
+
+	trips, err := built.GetTripsByRouteID(gtfstest.RouteID(0))
+	if err != nil || len(trips) != 4 {
+		t.Fatalf("Expected 4 trips on the route, got %d (%v)", len(trips), err)
+	}
+	route, err := built.GetRouteByID(gtfstest.RouteID(0))
+	if err != nil || route.OutboundShapeID == nil {
+		t.Fatalf("Expected route with its outbound shape set, got %v (%v)", route, err)
+	}
+	exception, err := built.GetServiceException(gtfstest.ServiceID(0), time.Date(2030, 12, 25, 0, 0, 0, 0, time.UTC))
+	if err != nil || exception.Type != gtfs.RemovedExceptionType {
+		t.Fatalf("Expected service exception on the date, got %v (%v)", exception, err)
+	}
+
+	// Duplicate IDs and unknown references are rejected
+	agency := &gtfs.Agency{ID: "A", Name: "Agency", Timezone: "Australia/Perth"}
+	_, err = gtfs.NewBuilder(filepath.Join(t.TempDir(), "gtfs.db")).AddAgency(agency).AddAgency(agency).Commit()
+	if err == nil {
+		t.Fatal("Expected an error for a duplicate agency")
+	}
+	_, err = gtfs.NewBuilder(filepath.Join(t.TempDir(), "gtfs.db")).
+		AddAgency(agency).
+		AddRoute(&gtfs.Route{ID: "R", AgencyID: "A"}).
+		AddTrip(&gtfs.Trip{ID: "T", RouteID: "R", ServiceID: "MISSING"}).
+		Commit()
+	if err == nil || !strings.Contains(err.Error(), "unknown service") {
+		t.Fatalf("Expected an error for an unknown service, got %v", err)
+	}
+}
+
+func TestDeleteEntities(t *testing.T) {
+	weekdays := gtfstest.Calendar{
+		Weekdays:  gtfs.MondayWeekdayFlag | gtfs.TuesdayWeekdayFlag | gtfs.WednesdayWeekdayFlag | gtfs.ThursdayWeekdayFlag | gtfs.FridayWeekdayFlag,
+		StartDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2099, 12, 31, 0, 0, 0, 0, time.UTC),
+	}
+	school := weekdays
+	feed := gtfstest.NewFeed(gtfstest.Options{Routes: 3, Calendars: []gtfstest.Calendar{weekdays, school}})
+
+	// Databases are opened read-only by default
+	readOnly := gtfstest.New(t, gtfstest.Options{})
+	if err := readOnly.DeleteTrip(gtfstest.TripID(0, 0)); err == nil {
+		t.Fatal("Expected an error deleting from a read-only database")
+	}
+
+	fixture := &gtfs.GTFS{}
+	err := fixture.FromFeed(feed, filepath.Join(t.TempDir(), "gtfs.db"), gtfs.IngestOptions{DB: gtfs.DBOptions{ReadWrite: true}})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer fixture.Close()
+	err = fixture.Warmup(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to warm up: %v", err)
+	}
+
+	// Delete a trip, which is no longer served from the cache
+	err = fixture.DeleteTrip(gtfstest.TripID(0, 0))
+	if err != nil {
+		t.Fatalf("Failed to delete trip: %v", err)
+	}
+	if _, err := fixture.GetTripByID(gtfstest.TripID(0, 0)); err == nil {
+		t.Fatal("Expected deleted trip to not be found")
+	}
+	trips, err := fixture.GetTripsByRouteID(gtfstest.RouteID(0))
+	if err != nil || len(trips) != 3 {
+		t.Fatalf("Expected 3 remaining trips on the route, got %d (%v)", len(trips), err)
+	}
+	segments, err := fixture.GetSegmentTravelTimes(gtfstest.RouteID(0))
+	if err != nil || len(segments) == 0 {
+		t.Fatalf("Expected segment travel times of the remaining trips, got %v (%v)", segments, err)
+	}
+	at := time.Date(2030, 6, 3, 6, 5, 0, 0, time.FixedZone("AWST", 8*3600))
+	current, err := fixture.GetAllCurrentTripsAt(at)
+	if err != nil {
+		t.Fatalf("Failed to get current trips: %v", err)
+	}
+	if _, ok := current[gtfstest.TripID(0, 0)]; ok {
+		t.Fatal("Expected deleted trip to not be running")
+	}
+	if err := fixture.DeleteTrip(gtfstest.TripID(0, 0)); err == nil {
+		t.Fatal("Expected an error deleting a missing trip")
+	}
+
+	// Routes with trips are only deleted with their trips when cascading
+	if err := fixture.DeleteRoute(gtfstest.RouteID(1), gtfs.RestrictDelete); err == nil {
+		t.Fatal("Expected an error deleting a route with trips")
+	}
+	err = fixture.DeleteRoute(gtfstest.RouteID(1), gtfs.CascadeDelete)
+	if err != nil {
+		t.Fatalf("Failed to delete route: %v", err)
+	}
+	if _, err := fixture.GetRouteByID(gtfstest.RouteID(1)); err == nil {
+		t.Fatal("Expected deleted route to not be found")
+	}
+	if _, err := fixture.GetTripByID(gtfstest.TripID(1, 1)); err == nil {
+		t.Fatal("Expected trips of the deleted route to be deleted")
+	}
+	routes, err := fixture.GetRoutesByType(gtfs.BusRouteType)
+	if err != nil || len(routes) != 2 {
+		t.Fatalf("Expected 2 remaining bus routes, got %d (%v)", len(routes), err)
+	}
+
+	// Deleting a service removes its trips
+	err = fixture.DeleteService(gtfstest.ServiceID(1), gtfs.CascadeDelete)
+	if err != nil {
+		t.Fatalf("Failed to delete service: %v", err)
+	}
+	if _, err := fixture.GetServiceByID(gtfstest.ServiceID(1)); err == nil {
+		t.Fatal("Expected deleted service to not be found")
+	}
+	trips, err = fixture.GetTripsByRouteID(gtfstest.RouteID(2))
+	if err != nil || len(trips) != 2 {
+		t.Fatalf("Expected 2 remaining trips on the route, got %d (%v)", len(trips), err)
+	}
+	for _, trip := range trips {
+		if trip.ServiceID != gtfstest.ServiceID(0) {
+			t.Fatalf("Expected trips of the deleted service to be deleted, got %s", trip.ID)
+		}
+	}
+
+	// Routes without any remaining trips have no shapes or stops
+	err = fixture.DeleteTrips(slices.Collect(maps.Keys(trips)))
+	if err != nil {
+		t.Fatalf("Failed to delete trips: %v", err)
+	}
+	route, err := fixture.GetRouteByID(gtfstest.RouteID(2))
+	if err != nil {
+		t.Fatalf("Failed to get route: %v", err)
+	}
+	if len(route.Stops) != 0 || len(route.InboundStops) != 0 || len(route.OutboundStops) != 0 {
+		t.Fatalf("Expected the route to have no stops, got %v", route.Stops)
+	}
+	if route.OutboundShapeID != nil && *route.OutboundShapeID != "" {
+		t.Fatalf("Expected the route to have no outbound shape, got %s", *route.OutboundShapeID)
+	}
+}
+
+func TestAppMetadata(t *testing.T) {
+	// Databases are opened read-only by default
+	readOnly := gtfstest.New(t, gtfstest.Options{})
+	if err := readOnly.SetAppMetadata([]byte("feed_url"), []byte("https://example.com/gtfs.zip")); err == nil {
+		t.Fatal("Expected an error setting metadata in a read-only database")
+	}
+
+	opts := gtfs.IngestOptions{DB: gtfs.DBOptions{ReadWrite: true}}
+	fixture := &gtfs.GTFS{}
+	err := fixture.FromFeed(gtfstest.NewFeed(gtfstest.Options{}), filepath.Join(t.TempDir(), "gtfs.db"), opts)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer fixture.Close()
+
+	if _, err := fixture.GetAppMetadata([]byte("feed_url")); err == nil {
+		t.Fatal("Expected an error getting unset metadata")
+	}
+	err = fixture.SetAppMetadata([]byte("feed_url"), []byte("https://example.com/gtfs.zip"))
+	if err != nil {
+		t.Fatalf("Failed to set metadata: %v", err)
+	}
+
+	// Metadata is kept when the database is refreshed
+	err = fixture.RefreshFromFeed(gtfstest.NewFeed(gtfstest.Options{Routes: 3}), opts)
+	if err != nil {
+		t.Fatalf("Failed to refresh database: %v", err)
+	}
+	value, err := fixture.GetAppMetadata([]byte("feed_url"))
+	if err != nil {
+		t.Fatalf("Failed to get metadata: %v", err)
+	}
+	if string(value) != "https://example.com/gtfs.zip" {
+		t.Fatalf("Expected the feed URL, got %q", value)
+	}
+
+	err = fixture.DeleteAppMetadata([]byte("feed_url"))
+	if err != nil {
+		t.Fatalf("Failed to delete metadata: %v", err)
+	}
+	if _, err := fixture.GetAppMetadata([]byte("feed_url")); err == nil {
+		t.Fatal("Expected an error getting deleted metadata")
+	}
+}
@@ -9,13 +9,18 @@ import (
 )
 
 var g *gtfs.GTFS
+var ingestReport *gtfs.IngestReport
 
 func TestMain(m *testing.M) {
 	log.Info("Starting GTFS tests")
 
 	// Download sample GTFS data
 	g = &gtfs.GTFS{}
-	err := g.FromURL(gtfsURL, dbFile)
+	var err error
+	ingestReport, err = g.FromURLReport(gtfsURL, dbFile, gtfs.IngestOptions{
+		ProjectStops: true,
+		StopAliases:  []gtfs.StopAlias{{Alias: stopAlias, StopID: stopID}},
+	})
 	if err != nil {
 		log.Errorf("Failed to create GTFS from URL: %v", err)
 		os.Exit(1)
@@ -0,0 +1,285 @@
+package tests
+
+import (
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aaroncutress/gtfs-go"
+)
+
+// Write a GTFS zip archive with the given files
+func writeFeedZip(t *testing.T, path string, files map[string]string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create zip: %v", err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for name, content := range files {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to add %s to zip: %v", name, err)
+		}
+		_, err = fw.Write([]byte(content))
+		if err != nil {
+			t.Fatalf("Failed to write %s to zip: %v", name, err)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("Failed to close zip: %v", err)
+	}
+}
+
+// Returns the files of a single-trip feed, with a stop named Central shared between feeds
+func mergeFeedFiles(agencyID, stopID, routeID, tripID, weekdays string) map[string]string {
+	return map[string]string{
+		"agency.txt": "agency_id,agency_name,agency_url,agency_timezone\n" +
+			agencyID + ",Transit,https://example.com,Australia/Perth\n",
+		"calendar.txt": "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\n" +
+			"C1," + weekdays + ",20250101,20251231\n",
+		"stops.txt": "location_type,parent_station,stop_id,stop_code,stop_name,stop_desc,stop_lat,stop_lon,zone_id,supported_modes\n" +
+			"0,," + stopID + ",,Central,,-31.95,115.86,,Bus\n" +
+			"0,," + routeID + "-end,,End of " + routeID + ",,-31.96,115.87,,Bus\n",
+		"routes.txt": "route_id,agency_id,route_short_name,route_long_name,route_desc,route_type,route_url,route_color\n" +
+			routeID + "," + agencyID + "," + routeID + ",,,3,,FF0000\n",
+		"trips.txt": "route_id,service_id,trip_id,direction_id\n" +
+			routeID + ",C1," + tripID + ",0\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			tripID + ",08:00:00,08:00:00," + stopID + ",1\n" +
+			tripID + ",08:10:00,08:10:00," + routeID + "-end,2\n",
+	}
+}
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	pathA := filepath.Join(dir, "a.zip")
+	pathB := filepath.Join(dir, "b.zip")
+	writeFeedZip(t, pathA, mergeFeedFiles("A", "S1", "R1", "T1", "1,1,1,1,1,0,0"))
+	writeFeedZip(t, pathB, mergeFeedFiles("B", "S9", "R2", "T2", "0,0,0,0,0,1,1"))
+
+	mergedFile := filepath.Join(dir, "merged.db")
+	err := gtfs.Merge(mergedFile, gtfs.Source{Path: pathA}, gtfs.Source{Path: pathB})
+	if err != nil {
+		t.Fatalf("Failed to merge feeds: %v", err)
+	}
+
+	merged := &gtfs.GTFS{}
+	err = merged.FromDB(mergedFile)
+	if err != nil {
+		t.Fatalf("Failed to load merged database: %v", err)
+	}
+	defer merged.Close()
+
+	// Check that the identical agencies and Central stops were merged
+	agencies, err := merged.GetAllAgencies()
+	if err != nil {
+		t.Fatalf("Failed to get agencies: %v", err)
+	}
+	if len(agencies) != 1 {
+		t.Fatalf("Expected 1 merged agency, got %d", len(agencies))
+	}
+	stops, err := merged.GetAllStops()
+	if err != nil {
+		t.Fatalf("Failed to get stops: %v", err)
+	}
+	if len(stops) != 3 {
+		t.Fatalf("Expected 3 stops, got %d", len(stops))
+	}
+	route, err := merged.GetRouteByID("R2")
+	if err != nil {
+		t.Fatalf("Failed to get route: %v", err)
+	}
+	if route.AgencyID != "A" {
+		t.Fatalf("Expected route R2 to belong to agency A, got %s", route.AgencyID)
+	}
+
+	// Check that the conflicting calendars were kept apart
+	trip, err := merged.GetTripByID("T2")
+	if err != nil {
+		t.Fatalf("Failed to get trip: %v", err)
+	}
+	if trip.Stops[0].StopID != "S1" {
+		t.Fatalf("Expected trip T2 to start at the merged stop S1, got %s", trip.Stops[0].StopID)
+	}
+	if trip.ServiceID == "C1" {
+		t.Fatal("Expected trip T2's service to be renamed")
+	}
+	service, err := merged.GetServiceByID(trip.ServiceID)
+	if err != nil {
+		t.Fatalf("Failed to get renamed service: %v", err)
+	}
+	if service.Weekdays != gtfs.SaturdayWeekdayFlag|gtfs.SundayWeekdayFlag {
+		t.Fatalf("Expected the renamed service to run on weekends, got %v", service.Weekdays)
+	}
+
+	// Check that prefixes keep otherwise conflicting IDs apart
+	prefixedFile := filepath.Join(dir, "prefixed.db")
+	err = gtfs.Merge(prefixedFile, gtfs.Source{Path: pathA, Prefix: "a:"}, gtfs.Source{Path: pathA, Prefix: "b:"})
+	if err != nil {
+		t.Fatalf("Failed to merge prefixed feeds: %v", err)
+	}
+	err = gtfs.Merge(filepath.Join(dir, "conflict.db"), gtfs.Source{Path: pathA}, gtfs.Source{Path: pathA})
+	if err == nil {
+		t.Fatal("Expected conflicting route IDs to fail")
+	}
+}
+
+func TestMergeExceptionOnlyServices(t *testing.T) {
+	dir := t.TempDir()
+	pathA := filepath.Join(dir, "a.zip")
+	pathB := filepath.Join(dir, "b.zip")
+
+	// Both feeds define service C1 only in calendar_dates.txt, on different days
+	filesA := mergeFeedFiles("A", "S1", "R1", "T1", "1,1,1,1,1,0,0")
+	filesA["calendar.txt"] = "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\n"
+	filesA["calendar_dates.txt"] = "service_id,date,exception_type\nC1,20250106,1\n"
+	filesB := mergeFeedFiles("A", "S1", "R2", "T2", "1,1,1,1,1,0,0")
+	filesB["calendar.txt"] = "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\n"
+	filesB["calendar_dates.txt"] = "service_id,date,exception_type\nC1,20250107,1\n"
+	writeFeedZip(t, pathA, filesA)
+	writeFeedZip(t, pathB, filesB)
+
+	mergedFile := filepath.Join(dir, "merged.db")
+	err := gtfs.Merge(mergedFile, gtfs.Source{Path: pathA}, gtfs.Source{Path: pathB})
+	if err != nil {
+		t.Fatalf("Failed to merge feeds: %v", err)
+	}
+
+	merged := &gtfs.GTFS{}
+	err = merged.FromDB(mergedFile)
+	if err != nil {
+		t.Fatalf("Failed to load merged database: %v", err)
+	}
+	defer merged.Close()
+
+	trip, err := merged.GetTripByID("T2")
+	if err != nil {
+		t.Fatalf("Failed to get trip: %v", err)
+	}
+	if trip.ServiceID != "C1#2" {
+		t.Fatalf("Expected trip T2's service to be renamed to C1#2, got %s", trip.ServiceID)
+	}
+
+	loc, _ := time.LoadLocation("Australia/Perth")
+	monday := time.Date(2025, 1, 6, 12, 0, 0, 0, loc)
+	tuesday := monday.AddDate(0, 0, 1)
+	for serviceID, date := range map[gtfs.Key]time.Time{"C1": monday, "C1#2": tuesday} {
+		_, err := merged.GetServiceException(serviceID, date)
+		if err != nil {
+			t.Fatalf("Expected service %s to run on %s: %v", serviceID, date.Format("2006-01-02"), err)
+		}
+	}
+	_, err = merged.GetServiceException("C1", tuesday)
+	if err == nil {
+		t.Fatal("Expected service C1 not to take the other source's exception")
+	}
+}
+
+func TestFromSources(t *testing.T) {
+	dir := t.TempDir()
+	busPath := filepath.Join(dir, "bus.zip")
+	railPath := filepath.Join(dir, "rail.zip")
+	writeFeedZip(t, busPath, mergeFeedFiles("A", "S1", "R1", "T1", "1,1,1,1,1,1,1"))
+	writeFeedZip(t, railPath, mergeFeedFiles("A", "S1", "R1", "T1", "1,1,1,1,1,1,1"))
+
+	sources := []gtfs.Source{{Path: busPath, Prefix: "bus:"}, {Path: railPath, Prefix: "rail:"}}
+	fromSources := &gtfs.GTFS{}
+	report, err := fromSources.FromSources(sources, filepath.Join(dir, "sources.db"), gtfs.IngestOptions{})
+	if err != nil {
+		t.Fatalf("Failed to ingest sources: %v", err)
+	}
+	defer fromSources.Close()
+
+	// Check that each source's routes are kept in their own namespace
+	for _, id := range []gtfs.Key{"bus:R1", "rail:R1"} {
+		_, err := fromSources.GetRouteByID(id)
+		if err != nil {
+			t.Fatalf("Failed to get route %s: %v", id, err)
+		}
+	}
+
+	// Check that the report covers the files of both sources
+	for _, path := range []string{busPath, railPath} {
+		if report.Files[path+"/stops.txt"] == nil {
+			t.Fatalf("Expected report for %s/stops.txt, got %v", path, report.Files)
+		}
+	}
+	if report.Rows() == 0 || report.DBSize == 0 {
+		t.Fatalf("Expected rows and database size in report, got %d and %d", report.Rows(), report.DBSize)
+	}
+}
+
+// Tests ingesting a zip whose files are nested in a folder, in mixed case and prefixed with byte order marks
+func TestFromSourcesNestedZip(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "nested.zip")
+	files := map[string]string{"__MACOSX/GTFS/._stops.txt": "junk"}
+	for name, content := range mergeFeedFiles("A", "S1", "R1", "T1", "1,1,1,1,1,1,1") {
+		files["GTFS/"+strings.ToUpper(name[:1])+name[1:]] = "\ufeff" + content
+	}
+	writeFeedZip(t, zipPath, files)
+
+	nested := &gtfs.GTFS{}
+	_, err := nested.FromSources([]gtfs.Source{{Path: zipPath}}, filepath.Join(dir, "nested.db"), gtfs.IngestOptions{})
+	if err != nil {
+		t.Fatalf("Failed to ingest nested zip: %v", err)
+	}
+	defer nested.Close()
+
+	agency, err := nested.GetAgencyByID("A")
+	if err != nil {
+		t.Fatalf("Failed to get agency: %v", err)
+	}
+	if agency.Name != "Transit" {
+		t.Fatalf("Expected agency name Transit, got %q", agency.Name)
+	}
+}
+
+func TestParseOnly(t *testing.T) {
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "feed.zip")
+	writeFeedZip(t, zipPath, mergeFeedFiles("A", "S1", "R1", "T1", "1,1,1,1,1,1,1"))
+
+	feed, report, err := gtfs.ParseOnly(gtfs.Source{Path: zipPath}, gtfs.IngestOptions{InferShapes: true, TransferDistance: 2000})
+	if err != nil {
+		t.Fatalf("Failed to parse feed: %v", err)
+	}
+	if len(feed.Trips) != 1 || report.Files["stop_times.txt"].Rows != 2 {
+		t.Fatalf("Expected 1 trip from 2 stop times, got %s", feed)
+	}
+
+	// Derived data is prepared as it would be stored
+	route := feed.Routes["R1"]
+	if len(route.Stops) != 2 || route.OutboundShapeID == nil || len(feed.Transfers) == 0 {
+		t.Fatalf("Expected route stops, an inferred shape and generated transfers, got %+v and %d transfers", route, len(feed.Transfers))
+	}
+
+	// No database is written
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected only the feed in the directory, got %d entries", len(entries))
+	}
+
+	// Malformed rows fail a strict parse, with the report still returned
+	files := mergeFeedFiles("A", "S1", "R1", "T1", "1,1,1,1,1,1,1")
+	files["stop_times.txt"] += "T1,late,late,S1,3\n"
+	writeFeedZip(t, zipPath, files)
+	_, report, err = gtfs.ParseOnly(gtfs.Source{Path: zipPath}, gtfs.IngestOptions{})
+	var fileErr *gtfs.FileError
+	if !errors.As(err, &fileErr) {
+		t.Fatalf("Expected malformed stop times to fail, got %v", err)
+	}
+	if report.Files["stops.txt"] == nil {
+		t.Fatal("Expected reports for the files which parsed")
+	}
+}
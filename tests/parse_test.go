@@ -0,0 +1,587 @@
+package tests
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aaroncutress/gtfs-go"
+	"github.com/aaroncutress/gtfs-go/gtfstest"
+)
+
+const malformedRoutes = `route_id,agency_id,route_short_name,route_long_name,route_desc,route_type,route_url,route_color
+1,A,1,Route One,,3,,FF0000
+2,A,2,Route Two,,bus,,00FF00
+3,A
+`
+
+func TestParseModes(t *testing.T) {
+	// Strict parsing aborts on the first malformed row
+	_, _, err := gtfs.ParseRoutesWithOptions(strings.NewReader(malformedRoutes), gtfs.ParseOptions{Mode: gtfs.StrictParseMode})
+	if err == nil {
+		t.Fatalf("Expected strict parsing to fail")
+	}
+
+	// Lenient parsing skips both malformed rows
+	routes, report, err := gtfs.ParseRoutesWithOptions(strings.NewReader(malformedRoutes), gtfs.ParseOptions{Mode: gtfs.LenientParseMode})
+	if err != nil {
+		t.Fatalf("Failed to parse routes leniently: %v", err)
+	}
+	if len(routes) != 1 || report.SkippedRows != 2 {
+		t.Fatalf("Expected 1 route and 2 skipped rows, got %d and %d", len(routes), report.SkippedRows)
+	}
+
+	// Best effort parsing repairs both malformed rows
+	routes, report, err = gtfs.ParseRoutesWithOptions(strings.NewReader(malformedRoutes), gtfs.ParseOptions{Mode: gtfs.BestEffortParseMode})
+	if err != nil {
+		t.Fatalf("Failed to parse routes with best effort: %v", err)
+	}
+	if len(routes) != 3 || report.RepairedRows != 2 {
+		t.Fatalf("Expected 3 routes and 2 repaired rows, got %d and %d", len(routes), report.RepairedRows)
+	}
+	if routes["2"].Type != gtfs.BusRouteType {
+		t.Fatalf("Expected repaired route type %d, got %d", gtfs.BusRouteType, routes["2"].Type)
+	}
+
+	t.Logf("Warnings: %v", report.Warnings)
+}
+
+// Columns in a non-standard order, with non-contiguous stop sequences listed out of order
+const reorderedTrips = `trip_headsign,trip_id,service_id,route_id,direction_id,shape_id
+Downtown,T1,S1,R1,0,SH1
+`
+
+const reorderedStopTimes = `stop_sequence,stop_id,departure_time,arrival_time,trip_id
+30,C,08:10:00,08:10:00,T1
+5,A,08:00:00,08:00:00,T1
+12,B,08:05:30,08:05:00,T1
+`
+
+func TestParseTripsStopSequence(t *testing.T) {
+	trips, err := gtfs.ParseTrips(strings.NewReader(reorderedTrips), strings.NewReader(reorderedStopTimes))
+	if err != nil {
+		t.Fatalf("Failed to parse trips: %v", err)
+	}
+
+	trip, ok := trips["T1"]
+	if !ok {
+		t.Fatal("Expected trip T1")
+	}
+	if trip.RouteID != "R1" || trip.ServiceID != "S1" || trip.ShapeID != "SH1" || trip.Headsign != "Downtown" {
+		t.Fatalf("Trip fields parsed from the wrong columns: %+v", trip)
+	}
+
+	// Check that the stops are ordered by stop_sequence
+	expected := []gtfs.Key{"A", "B", "C"}
+	if len(trip.Stops) != len(expected) {
+		t.Fatalf("Expected %d stops, got %d", len(expected), len(trip.Stops))
+	}
+	for i, stopID := range expected {
+		if trip.Stops[i].StopID != stopID {
+			t.Fatalf("Expected stop %d to be %s, got %s", i, stopID, trip.Stops[i].StopID)
+		}
+	}
+	if trip.Stops[1].ArrivalTime != 8*3600+5*60 || trip.Stops[1].DepartureTime != 8*3600+5*60+30 {
+		t.Fatalf("Times parsed from the wrong columns: %+v", trip.Stops[1])
+	}
+}
+
+func TestParseTripsMissingColumn(t *testing.T) {
+	stopTimes := "trip_id,stop_id,arrival_time,departure_time\nT1,A,08:00:00,08:00:00\n"
+	_, err := gtfs.ParseTrips(strings.NewReader(reorderedTrips), strings.NewReader(stopTimes))
+	if err == nil {
+		t.Fatal("Expected missing stop_sequence column to fail")
+	}
+}
+
+func TestParseConformance(t *testing.T) {
+	stopTimes := "trip_id,stop_id,stop_sequence,arrival_time,departure_time,timepoint\n" +
+		"T1,A,1,08:00:00,08:00:00,1\n" +
+		"T1,B,2,,08:05:00,1\n" +
+		"T1,,3,08:10:00,08:10:00,0\n"
+
+	// Check that strict conformance mode fails with the rule violated
+	_, _, err := gtfs.ParseTripsWithOptions(strings.NewReader(reorderedTrips), strings.NewReader(stopTimes),
+		gtfs.ParseOptions{Mode: gtfs.StrictParseMode, Conformance: true})
+	var specErr *gtfs.SpecError
+	if !errors.As(err, &specErr) {
+		t.Fatalf("Expected spec error, got %v", err)
+	}
+	if specErr.Code != "stop_time_timepoint_without_times" || specErr.Rule != "stop_times.arrival_time missing for timepoint=1" || specErr.Line != 3 {
+		t.Fatalf("Expected timepoint violation on line 3, got %+v", specErr)
+	}
+
+	// Check that lenient conformance mode records every violation
+	_, reports, err := gtfs.ParseTripsWithOptions(strings.NewReader(reorderedTrips), strings.NewReader(stopTimes),
+		gtfs.ParseOptions{Mode: gtfs.LenientParseMode, Conformance: true})
+	if err != nil {
+		t.Fatalf("Failed to parse trips: %v", err)
+	}
+	codes := []string{}
+	for _, report := range reports {
+		for _, violation := range report.Violations {
+			codes = append(codes, violation.Code)
+		}
+	}
+	expected := []string{"stop_time_timepoint_without_times", "invalid_time", "missing_required_field"}
+	if strings.Join(codes, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected violations %v, got %v", expected, codes)
+	}
+
+	// Check that missing columns are reported with their code
+	missingColumn := "trip_id,stop_id,arrival_time,departure_time\nT1,A,08:00:00,08:00:00\n"
+	_, _, err = gtfs.ParseTripsWithOptions(strings.NewReader(reorderedTrips), strings.NewReader(missingColumn), gtfs.ParseOptions{Conformance: true})
+	if !errors.As(err, &specErr) || specErr.Code != "missing_required_column" {
+		t.Fatalf("Expected missing_required_column error, got %v", err)
+	}
+}
+
+func TestParseTripDirection(t *testing.T) {
+	trips := "trip_id,route_id,service_id,direction_id\nT1,R1,S1,\nT2,R1,S1,0\nT3,R1,S1,1\n"
+	stopTimes := "trip_id,stop_id,stop_sequence,arrival_time,departure_time\n" +
+		"T1,A,1,08:00:00,08:00:00\nT2,A,1,08:00:00,08:00:00\nT3,A,1,08:00:00,08:00:00\n"
+
+	// Check that an empty direction is outbound, without a violation
+	parsed, _, err := gtfs.ParseTripsWithOptions(strings.NewReader(trips), strings.NewReader(stopTimes),
+		gtfs.ParseOptions{Mode: gtfs.StrictParseMode, Conformance: true})
+	if err != nil {
+		t.Fatalf("Failed to parse trips: %v", err)
+	}
+	expected := map[gtfs.Key]gtfs.TripDirection{
+		"T1": gtfs.OutboundTripDirection,
+		"T2": gtfs.OutboundTripDirection,
+		"T3": gtfs.InboundTripDirection,
+	}
+	for id, direction := range expected {
+		if parsed[id].Direction != direction {
+			t.Fatalf("Expected trip %s to be inbound: %t, got %t", id, direction, parsed[id].Direction)
+		}
+	}
+
+	// Check that other values are violations
+	invalid := trips + "T4,R1,S1,2\n"
+	_, _, err = gtfs.ParseTripsWithOptions(strings.NewReader(invalid), strings.NewReader(stopTimes),
+		gtfs.ParseOptions{Mode: gtfs.StrictParseMode, Conformance: true})
+	var specErr *gtfs.SpecError
+	if !errors.As(err, &specErr) || specErr.Code != "unexpected_enum_value" || specErr.Line != 5 {
+		t.Fatalf("Expected direction violation on line 5, got %v", err)
+	}
+}
+
+func TestParseAgencyContact(t *testing.T) {
+	agencyFile := `agency_id,agency_name,agency_url,agency_timezone,agency_lang,agency_phone,agency_fare_url,agency_email
+A,Agency,https://example.com,Australia/Perth,en,13 62 13,https://example.com/fares,info@example.com
+`
+	agencies, err := gtfs.ParseAgencies(strings.NewReader(agencyFile))
+	if err != nil {
+		t.Fatalf("Failed to parse agencies: %v", err)
+	}
+
+	agency := agencies["A"]
+	if agency == nil {
+		t.Fatal("Expected agency A")
+	}
+	if agency.Lang != "en" || agency.Phone != "13 62 13" || agency.FareURL != "https://example.com/fares" || agency.Email != "info@example.com" {
+		t.Fatalf("Unexpected agency contact fields: %+v", agency)
+	}
+}
+
+func TestParseMinimalFeed(t *testing.T) {
+	// An informational feed with routes and trips, but no stops, calendar or stop times
+	files := map[string]io.Reader{
+		"agency.txt": strings.NewReader("agency_id,agency_name,agency_url,agency_timezone\nA,Agency,https://example.com,Australia/Perth\n"),
+		"routes.txt": strings.NewReader("route_id,agency_id,route_short_name,route_long_name,route_desc,route_type,route_url,route_color\nR1,A,1,Route One,,3,,FF0000\n"),
+		"trips.txt":  strings.NewReader(reorderedTrips),
+	}
+
+	feed, err := gtfs.ParseFeed(files)
+	if err != nil {
+		t.Fatalf("Failed to parse minimal feed: %v", err)
+	}
+	if len(feed.Routes) != 1 || len(feed.Stops) != 0 || len(feed.Services) != 0 {
+		t.Fatalf("Unexpected entities in minimal feed: %s", feed)
+	}
+
+	// Check that trips are kept without stops
+	trip, ok := feed.Trips["T1"]
+	if !ok {
+		t.Fatal("Expected trip T1 without stop times")
+	}
+	if len(trip.Stops) != 0 {
+		t.Fatalf("Expected no stops, got %d", len(trip.Stops))
+	}
+}
+
+func TestParseRouteAgency(t *testing.T) {
+	// agency_id may be omitted from routes.txt when the feed has a single agency
+	files := map[string]io.Reader{
+		"agency.txt": strings.NewReader("agency_id,agency_name,agency_url,agency_timezone\nA,Agency,https://example.com,Australia/Perth\n"),
+		"routes.txt": strings.NewReader("route_id,agency_id,route_short_name,route_long_name,route_desc,route_type,route_url,route_color\nR1,,1,Route One,,3,,FF0000\n"),
+	}
+	feed, err := gtfs.ParseFeed(files)
+	if err != nil {
+		t.Fatalf("Failed to parse feed: %v", err)
+	}
+	if feed.Routes["R1"].AgencyID != "A" {
+		t.Fatalf("Expected route R1 to belong to agency A, got %q", feed.Routes["R1"].AgencyID)
+	}
+
+	// Check that routes are left unassigned when there are several agencies
+	files = map[string]io.Reader{
+		"agency.txt": strings.NewReader("agency_id,agency_name,agency_url,agency_timezone\nA,Agency,https://example.com,Australia/Perth\nB,Other,https://example.org,Australia/Perth\n"),
+		"routes.txt": strings.NewReader("route_id,agency_id,route_short_name,route_long_name,route_desc,route_type,route_url,route_color\nR1,,1,Route One,,3,,FF0000\n"),
+	}
+	feed, err = gtfs.ParseFeed(files)
+	if err != nil {
+		t.Fatalf("Failed to parse feed: %v", err)
+	}
+	if feed.Routes["R1"].AgencyID != "" {
+		t.Fatalf("Expected route R1 to have no agency, got %q", feed.Routes["R1"].AgencyID)
+	}
+}
+
+// Routes whose IDs differ only in surrounding whitespace and Unicode normalization form
+const unnormalizedRoutes = "route_id,agency_id,route_short_name,route_long_name,route_desc,route_type,route_url,route_color\n" +
+	"1,A,1,Route One,,3,,FF0000\n" +
+	" 1 ,A,1,Route One Again,,3,,0000FF\n" +
+	"Café,A,2,Route Two,,3,,00FF00\n" +
+	"Café,A,2,Route Two Again,,3,,00FF00\n"
+
+func TestParseNormalizeKeys(t *testing.T) {
+	// Without normalization, every ID is distinct
+	routes, report, err := gtfs.ParseRoutesWithOptions(strings.NewReader(unnormalizedRoutes), gtfs.ParseOptions{})
+	if err != nil {
+		t.Fatalf("Failed to parse routes: %v", err)
+	}
+	if len(routes) != 4 || report.DuplicateKeys != 0 {
+		t.Fatalf("Expected 4 routes and no duplicate keys, got %d and %d", len(routes), report.DuplicateKeys)
+	}
+
+	// With normalization, equivalent IDs collide and later rows overwrite earlier ones
+	routes, report, err = gtfs.ParseRoutesWithOptions(strings.NewReader(unnormalizedRoutes), gtfs.ParseOptions{NormalizeKeys: true})
+	if err != nil {
+		t.Fatalf("Failed to parse routes with normalized keys: %v", err)
+	}
+	if len(routes) != 2 || report.DuplicateKeys != 2 {
+		t.Fatalf("Expected 2 routes and 2 duplicate keys, got %d and %d", len(routes), report.DuplicateKeys)
+	}
+	if routes["1"] == nil || routes["1"].Colour != "0000FF" {
+		t.Fatalf("Expected route 1 to be overwritten by the later row, got %v", routes["1"])
+	}
+	if routes["Café"] == nil {
+		t.Fatal("Expected route ID in NFC form")
+	}
+}
+
+func TestParseStopAliases(t *testing.T) {
+	aliases, err := gtfs.ParseStopAliases(strings.NewReader("stop_id,alias\n12667,Glendalough Interchange\n12667,GLS\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse stop aliases: %v", err)
+	}
+	if len(aliases) != 2 || aliases[1].Alias != "GLS" || aliases[1].StopID != "12667" {
+		t.Fatalf("Unexpected stop aliases: %v", aliases)
+	}
+
+	_, err = gtfs.ParseStopAliases(strings.NewReader("stop_id,name\n12667,GLS\n"))
+	if err == nil {
+		t.Fatal("Expected missing alias column to fail")
+	}
+}
+
+// Standard columns in a different order, without the optional location_type and parent_station
+const reorderedStops = `stop_id,stop_name,stop_lat,stop_lon,stop_code
+1,Perth Stn,-31.9510,115.8599,P1
+2,Claremont Stn,-31.9815,115.7817,C2
+`
+
+func TestParseStopsReordered(t *testing.T) {
+	stops, err := gtfs.ParseStops(strings.NewReader(reorderedStops))
+	if err != nil {
+		t.Fatalf("Failed to parse stops: %v", err)
+	}
+	stop, ok := stops["2"]
+	if !ok {
+		t.Fatalf("Expected stop 2, got %v", stops)
+	}
+	if stop.Name != "Claremont Stn" || stop.Code != "C2" || stop.ParentID != "" || stop.LocationType != gtfs.StopLocationType {
+		t.Fatalf("Stop fields parsed from the wrong columns: %+v", stop)
+	}
+	if stop.Location != gtfs.NewCoordinate(-31.9815, 115.7817) {
+		t.Fatalf("Expected stop 2 at -31.9815, 115.7817, got %v", stop.Location)
+	}
+
+	// Check that stops without IDs cannot be parsed
+	_, err = gtfs.ParseStops(strings.NewReader("stop_name,stop_lat,stop_lon\nPerth Stn,-31.9510,115.8599\n"))
+	if err == nil {
+		t.Fatal("Expected missing stop_id column to fail")
+	}
+}
+
+const swappedStops = `location_type,parent_station,stop_id,stop_code,stop_name,stop_desc,stop_lat,stop_lon,zone_id,supported_modes
+0,,1,1,Perth Stn,,-31.9510,115.8599,1,Rail
+0,,2,2,Claremont Stn,,-31.9815,115.7817,2,Rail
+0,,3,3,Glendalough Stn,,115.8286,-31.9214,1,Rail
+0,,4,4,Fremantle Stn,,"-32,0531","115,7453",2,Rail
+`
+
+func TestParseSwappedCoordinates(t *testing.T) {
+	// Without fixing, the swap is only reported and the decimal comma row is skipped
+	stops, report, err := gtfs.ParseStopsWithOptions(strings.NewReader(swappedStops), gtfs.ParseOptions{Mode: gtfs.LenientParseMode})
+	if err != nil {
+		t.Fatalf("Failed to parse stops: %v", err)
+	}
+	if len(stops) != 3 || len(report.CoordinateIssues) != 1 || report.CoordinateIssues[0] != "3" {
+		t.Fatalf("Expected 3 stops with an issue for stop 3, got %d and %v", len(stops), report.CoordinateIssues)
+	}
+	if stops["3"].Location.Latitude != 115.8286 {
+		t.Fatalf("Expected stop 3 to be left unchanged, got %v", stops["3"].Location)
+	}
+
+	// With fixing, the swap is corrected and the decimal commas are accepted
+	stops, report, err = gtfs.ParseStopsWithOptions(strings.NewReader(swappedStops), gtfs.ParseOptions{FixCoordinates: true})
+	if err != nil {
+		t.Fatalf("Failed to parse stops with fixed coordinates: %v", err)
+	}
+	if len(stops) != 4 || len(report.CoordinateIssues) != 1 || report.RepairedRows != 1 {
+		t.Fatalf("Expected 4 stops, 1 coordinate issue and 1 repaired row, got %d, %v and %d", len(stops), report.CoordinateIssues, report.RepairedRows)
+	}
+	if stops["3"].Location != gtfs.NewCoordinate(-31.9214, 115.8286) {
+		t.Fatalf("Expected stop 3 to be swapped, got %v", stops["3"].Location)
+	}
+	if stops["4"].Location != gtfs.NewCoordinate(-32.0531, 115.7453) {
+		t.Fatalf("Expected stop 4 to be parsed with decimal commas, got %v", stops["4"].Location)
+	}
+}
+
+// Stops with their modes in a column named vehicle_types, and abbreviated names
+const quirkyStops = `location_type,parent_station,stop_id,stop_code,stop_name,stop_desc,stop_lat,stop_lon,zone_id,vehicle_types
+0,,1,1,PERTH STN,,-31.9510,115.8599,1,"Rail,Bus"
+0,,2,2,CLAREMONT STN,,-31.9815,115.7817,2,Rail
+`
+
+func TestParseFieldMappers(t *testing.T) {
+	// Without a mapper the modes are not found
+	stops, err := gtfs.ParseStops(strings.NewReader(quirkyStops))
+	if err != nil {
+		t.Fatalf("Failed to parse stops: %v", err)
+	}
+	if stops["1"].SupportedModes != 0 {
+		t.Fatalf("Expected no modes without a mapper, got %v", stops["1"].SupportedModes)
+	}
+
+	// The mapper names the modes column and expands the names
+	opts := gtfs.ParseOptions{FieldMappers: map[string]gtfs.FieldMapper{
+		"stops.txt": func(header map[string]int, record []string) []string {
+			header["supported_modes"] = header["vehicle_types"]
+			record[header["stop_name"]] = strings.Replace(record[header["stop_name"]], " STN", " Station", 1)
+			return record
+		},
+	}}
+	stops, _, err = gtfs.ParseStopsWithOptions(strings.NewReader(quirkyStops), opts)
+	if err != nil {
+		t.Fatalf("Failed to parse stops with a mapper: %v", err)
+	}
+	if stops["1"].SupportedModes != gtfs.RailModeFlag|gtfs.BusModeFlag || stops["2"].SupportedModes != gtfs.RailModeFlag {
+		t.Fatalf("Expected modes from the mapped column, got %v and %v", stops["1"].SupportedModes, stops["2"].SupportedModes)
+	}
+	if stops["1"].Name != "PERTH Station" {
+		t.Fatalf("Expected mapped name PERTH Station, got %q", stops["1"].Name)
+	}
+}
+
+func TestParseTransfers(t *testing.T) {
+	const transfers = "from_stop_id,to_stop_id,transfer_type,min_transfer_time\nA,B,2,120\nA,C,x,\n,,1,\n"
+
+	// Rows with an invalid type are skipped, and rows without stops are ignored
+	parsed, report, err := gtfs.ParseTransfersWithOptions(strings.NewReader(transfers), gtfs.ParseOptions{Mode: gtfs.LenientParseMode})
+	if err != nil {
+		t.Fatalf("Failed to parse transfers: %v", err)
+	}
+	if len(parsed) != 1 || report.SkippedRows != 1 {
+		t.Fatalf("Expected 1 transfer and 1 skipped row, got %d and %d", len(parsed), report.SkippedRows)
+	}
+	if parsed[0].Type != gtfs.MinimumTimeTransferType || parsed[0].MinTransferTime != 120 {
+		t.Fatalf("Expected a minimum time transfer of 120 seconds, got %+v", parsed[0])
+	}
+}
+
+const occupancyTrips = `trip_id,route_id,service_id,direction_id,capacity
+T1,R1,S1,0,60
+T2,R1,S1,0,
+`
+
+const occupancyStopTimes = `trip_id,stop_id,stop_sequence,arrival_time,departure_time,avg_occupancy
+T1,A,1,08:00:00,08:00:00,12
+T1,B,2,08:05:00,08:05:00,0
+T2,A,1,09:00:00,09:00:00,
+T2,B,2,09:05:00,09:05:00,full
+`
+
+func TestParseTripOccupancy(t *testing.T) {
+	trips, err := gtfs.ParseTrips(strings.NewReader(occupancyTrips), strings.NewReader(occupancyStopTimes))
+	if err != nil {
+		t.Fatalf("Failed to parse trips: %v", err)
+	}
+
+	// An empty vehicle is distinct from an unknown occupancy
+	known := trips["T1"]
+	if known.Capacity != 60 || known.Stops[0].Occupancy == nil || *known.Stops[0].Occupancy != 12 ||
+		known.Stops[1].Occupancy == nil || *known.Stops[1].Occupancy != 0 {
+		t.Fatalf("Expected capacity 60 and occupancies 12 and 0, got %+v", known)
+	}
+
+	// Missing and invalid values are left unknown
+	unknown := trips["T2"]
+	if unknown.Capacity != 0 || unknown.Stops[0].Occupancy != nil || unknown.Stops[1].Occupancy != nil {
+		t.Fatalf("Expected unknown capacity and occupancies, got %+v", unknown)
+	}
+}
+
+// An express run numbered in steps of 10, which skips the stop with sequence 30
+const expressStopTimes = `trip_id,stop_id,stop_sequence,arrival_time,departure_time,avg_occupancy
+T1,A,10,08:00:00,08:00:00,
+T1,B,20,08:05:00,08:05:00,15
+T1,D,40,08:12:00,08:12:00,
+`
+
+func TestParseKeepStopSequences(t *testing.T) {
+	// Sequences are only used for ordering by default
+	trips, err := gtfs.ParseTrips(strings.NewReader(reorderedTrips), strings.NewReader(expressStopTimes))
+	if err != nil {
+		t.Fatalf("Failed to parse trips: %v", err)
+	}
+	if _, ok := trips["T1"].SequenceGaps(); ok || trips["T1"].Stops[0].Sequence != nil {
+		t.Fatal("Expected sequences to be discarded")
+	}
+
+	trips, _, err = gtfs.ParseTripsWithOptions(strings.NewReader(reorderedTrips), strings.NewReader(expressStopTimes), gtfs.ParseOptions{KeepStopSequences: true})
+	if err != nil {
+		t.Fatalf("Failed to parse trips: %v", err)
+	}
+	gaps, ok := trips["T1"].SequenceGaps()
+	if !ok || len(gaps) != 1 || gaps[0] != 1 {
+		t.Fatalf("Expected a gap after stop 1, got %v (%t)", gaps, ok)
+	}
+
+	// Sequences are stored alongside known and unknown occupancies
+	feed := gtfstest.NewFeed(gtfstest.Options{})
+	stored := feed.Trips[gtfstest.TripID(0, 0)]
+	for i, stop := range stored.Stops {
+		sequence := uint(10 * (i + 1))
+		stop.Sequence = &sequence
+	}
+	occupancy := uint(3)
+	stored.Stops[1].Occupancy = &occupancy
+	for _, enc := range []gtfs.Encoding{gtfs.BinaryEncoding, gtfs.ProtobufEncoding} {
+		fixture := &gtfs.GTFS{}
+		err := fixture.FromFeed(feed, filepath.Join(t.TempDir(), "gtfs.db"), gtfs.IngestOptions{Encoding: enc})
+		if err != nil {
+			t.Fatalf("Failed to create %s database: %v", enc, err)
+		}
+		defer fixture.Close()
+
+		trip, err := fixture.GetTripByID(stored.ID)
+		if err != nil {
+			t.Fatalf("Failed to get trip: %v", err)
+		}
+		if i, ok := trip.StopIndexBySequence(30); !ok || i != 2 {
+			t.Fatalf("Expected %s sequence 30 at index 2, got %d (%t)", enc, i, ok)
+		}
+		if trip.Stops[0].Occupancy != nil || trip.Stops[1].Occupancy == nil || *trip.Stops[1].Occupancy != occupancy {
+			t.Fatalf("Expected %s occupancy only on the second stop", enc)
+		}
+	}
+}
+
+func TestExtensionParsers(t *testing.T) {
+	gtfs.RegisterExtensionParser("route_notes.txt", gtfs.NewCSVExtensionParser("route_id"))
+	t.Cleanup(func() {
+		gtfs.RegisterExtensionParser("route_notes.txt", nil)
+	})
+
+	files := make(map[string]io.Reader)
+	for name, content := range mergeFeedFiles("A", "S1", "R1", "T1", "1,1,1,1,1,1,1") {
+		files[name] = strings.NewReader(content)
+	}
+	// Rows without a route are skipped rather than failing the ingest
+	files["route_notes.txt"] = strings.NewReader("route_id,note\nR1,Detour\n,Unassigned\nR1,Express\nR2,Closed\n")
+	feed, err := gtfs.ParseFeed(files)
+	if err != nil {
+		t.Fatalf("Failed to parse feed: %v", err)
+	}
+
+	fixture := &gtfs.GTFS{}
+	err = fixture.FromFeed(feed, filepath.Join(t.TempDir(), "gtfs.db"), gtfs.IngestOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer fixture.Close()
+
+	extensions, err := fixture.GetExtensions()
+	if err != nil {
+		t.Fatalf("Failed to get extensions: %v", err)
+	}
+	if len(extensions) != 1 || extensions[0] != "route_notes.txt" {
+		t.Fatalf("Expected the route notes extension, got %v", extensions)
+	}
+
+	// Check that rows are grouped by their key
+	data, err := fixture.GetExtensionEntity("route_notes.txt", "R1")
+	if err != nil {
+		t.Fatalf("Failed to get extension entity: %v", err)
+	}
+	rows, err := gtfs.DecodeExtensionRecords(data)
+	if err != nil {
+		t.Fatalf("Failed to decode extension records: %v", err)
+	}
+	if len(rows) != 2 || rows[0]["note"] != "Detour" || rows[1]["note"] != "Express" {
+		t.Fatalf("Expected both notes of route R1 in order, got %v", rows)
+	}
+	entities, err := fixture.GetAllExtensionEntities("route_notes.txt")
+	if err != nil || len(entities) != 2 {
+		t.Fatalf("Expected notes for 2 routes, got %d (%v)", len(entities), err)
+	}
+
+	_, err = fixture.GetExtensionEntity("route_notes.txt", "R9")
+	if !errors.Is(err, gtfs.ErrNotFound) {
+		t.Fatalf("Expected a missing extension entity to be not found, got %v", err)
+	}
+	_, err = fixture.GetAllExtensionEntities("missing.txt")
+	if !errors.Is(err, gtfs.ErrNotFound) {
+		t.Fatalf("Expected a missing extension to be not found, got %v", err)
+	}
+}
+
+func TestParseFeedErrors(t *testing.T) {
+	files := make(map[string]io.Reader)
+	for name, content := range mergeFeedFiles("A", "S1", "R1", "T1", "1,1,1,1,1,1,1") {
+		files[name] = strings.NewReader(content)
+	}
+	files["routes.txt"] = strings.NewReader(malformedRoutes)
+	files["stop_times.txt"] = strings.NewReader("trip_id,arrival_time,departure_time,stop_id,stop_sequence\nT1,late,late,S1,1\n")
+
+	// Check that every failing file is reported, in order of file name
+	feed, err := gtfs.ParseFeed(files)
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("Expected joined errors, got %v", err)
+	}
+	var failed []string
+	for _, err := range joined.Unwrap() {
+		var fileErr *gtfs.FileError
+		if !errors.As(err, &fileErr) {
+			t.Fatalf("Expected a FileError, got %v", err)
+		}
+		failed = append(failed, fileErr.File)
+	}
+	if strings.Join(failed, ",") != "routes.txt,trips.txt" {
+		t.Fatalf("Expected routes.txt and trips.txt to fail, got %v", failed)
+	}
+
+	// Check that the files which parsed are kept
+	if len(feed.Agencies) != 1 || len(feed.Stops) != 2 || len(feed.Services) != 1 {
+		t.Fatalf("Expected the files which parsed in the feed, got %s", feed)
+	}
+}
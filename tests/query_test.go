@@ -1,8 +1,18 @@
 package tests
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/aaroncutress/gtfs-go"
+	"github.com/aaroncutress/gtfs-go/gtfstest"
 )
 
 func TestGetAgencyByID(t *testing.T) {
@@ -65,6 +75,36 @@ func TestGetTripByID(t *testing.T) {
 	t.Logf("Trip Headsign: %s", trip.Headsign)
 }
 
+func TestGetTripDetail(t *testing.T) {
+	detail, err := g.GetTripDetail(tripID)
+	if err != nil {
+		t.Fatalf("Failed to get trip detail: %v", err)
+	}
+
+	if detail.Trip.ID != tripID || detail.Route.ID != detail.Trip.RouteID || detail.Service.ID != detail.Trip.ServiceID {
+		t.Fatal("Expected the trip's route and service")
+	}
+	if detail.Agency == nil {
+		t.Fatal("Expected the route's agency")
+	}
+	if detail.Trip.ShapeID != "" && (detail.Shape == nil || detail.Shape.ID != detail.Trip.ShapeID) {
+		t.Fatal("Expected the trip's shape")
+	}
+	if len(detail.Stops) != len(detail.Trip.Stops) {
+		t.Fatalf("Expected %d stops, got %d", len(detail.Trip.Stops), len(detail.Stops))
+	}
+	for i, stop := range detail.Stops {
+		if stop.ID != detail.Trip.Stops[i].StopID {
+			t.Fatalf("Expected stop %s at index %d, got %s", detail.Trip.Stops[i].StopID, i, stop.ID)
+		}
+	}
+
+	_, err = g.GetTripDetail("nonexistent")
+	if err == nil {
+		t.Fatal("Expected an error for a missing trip")
+	}
+}
+
 func TestGetTripsByRouteID(t *testing.T) {
 	// Get the trips by route ID
 	trips, err := g.GetTripsByRouteID(routeID)
@@ -80,6 +120,152 @@ func TestGetTripsByRouteID(t *testing.T) {
 	t.Logf("Number of trips: %d", len(trips))
 }
 
+func TestGetTripsByRouteAndDirection(t *testing.T) {
+	trips, err := g.GetTripsByRouteID(routeID)
+	if err != nil {
+		t.Fatalf("Failed to get trips by route ID: %v", err)
+	}
+
+	// Check that the trips in each direction partition the route's trips
+	total := 0
+	for _, dir := range []gtfs.TripDirection{gtfs.OutboundTripDirection, gtfs.InboundTripDirection} {
+		dirTrips, err := g.GetTripsByRouteAndDirection(routeID, dir)
+		if err != nil {
+			t.Fatalf("Failed to get trips by route and direction: %v", err)
+		}
+		for id, trip := range dirTrips {
+			if trip.Direction != dir {
+				t.Fatalf("Expected trip %s to travel in direction %v", id, dir)
+			}
+			if _, ok := trips[id]; !ok {
+				t.Fatalf("Trip %s is not a trip of route %s", id, routeID)
+			}
+		}
+		total += len(dirTrips)
+	}
+	if total != len(trips) {
+		t.Fatalf("Expected %d trips across both directions, got %d", len(trips), total)
+	}
+}
+
+// Tests choosing the most common stop sequence of each direction of a route when ingesting
+func TestRouteDirectionStops(t *testing.T) {
+	for _, enc := range []gtfs.Encoding{gtfs.BinaryEncoding, gtfs.ProtobufEncoding} {
+		// One of the three outbound trips skips the middle stop
+		feed := gtfstest.NewFeed(gtfstest.Options{TripsPerRoute: 6})
+		short := feed.Trips[gtfstest.TripID(0, 2)]
+		short.Stops = slices.Delete(short.Stops, 2, 3)
+
+		fixture := &gtfs.GTFS{}
+		err := fixture.FromFeed(feed, filepath.Join(t.TempDir(), "gtfs.db"), gtfs.IngestOptions{Encoding: enc})
+		if err != nil {
+			t.Fatalf("Failed to create database: %v", err)
+		}
+		defer fixture.Close()
+
+		route, err := fixture.GetRouteByID(gtfstest.RouteID(0))
+		if err != nil {
+			t.Fatalf("Failed to get route: %v", err)
+		}
+		outbound := make([]gtfs.Key, 5)
+		inbound := make([]gtfs.Key, 5)
+		for i := range 5 {
+			outbound[i] = gtfstest.StopID(0, i)
+			inbound[i] = gtfstest.StopID(0, 4-i)
+		}
+		if !slices.Equal(route.OutboundStops, outbound) {
+			t.Fatalf("Expected the full outbound pattern (%s), got %v", enc, route.OutboundStops)
+		}
+		if !slices.Equal(route.InboundStops, inbound) {
+			t.Fatalf("Expected the inbound pattern in travel order (%s), got %v", enc, route.InboundStops)
+		}
+	}
+}
+
+// Tests getting the stops of a route's canonical pattern in travel order
+func TestGetStopsByRouteID(t *testing.T) {
+	fixture := gtfstest.New(t, gtfstest.Options{})
+
+	outbound, err := fixture.GetStopsByRouteID(gtfstest.RouteID(0), gtfs.OutboundTripDirection)
+	if err != nil {
+		t.Fatalf("Failed to get stops by route ID: %v", err)
+	}
+	inbound, err := fixture.GetStopsByRouteID(gtfstest.RouteID(0), gtfs.InboundTripDirection)
+	if err != nil {
+		t.Fatalf("Failed to get stops by route ID: %v", err)
+	}
+	if len(outbound) != 5 || len(inbound) != 5 {
+		t.Fatalf("Expected 5 stops in each direction, got %d and %d", len(outbound), len(inbound))
+	}
+	for i := range 5 {
+		if outbound[i].ID != gtfstest.StopID(0, i) || inbound[i].ID != gtfstest.StopID(0, 4-i) {
+			t.Fatalf("Expected stops in travel order, got %s outbound and %s inbound at %d", outbound[i].ID, inbound[i].ID, i)
+		}
+		if outbound[i].Name == "" {
+			t.Fatalf("Expected stop %s to be decoded", outbound[i].ID)
+		}
+	}
+
+	_, err = fixture.GetStopsByRouteID("nonexistent", gtfs.OutboundTripDirection)
+	if err == nil {
+		t.Fatal("Expected an error for a missing route")
+	}
+}
+
+// Tests that the trip indexes, which store numeric IDs, resolve to the trips' keys
+func TestNumericTripIndexes(t *testing.T) {
+	fixture := gtfstest.New(t, gtfstest.Options{TripsPerRoute: 12})
+
+	trips, err := fixture.GetTripsByRouteID(gtfstest.RouteID(1))
+	if err != nil {
+		t.Fatalf("Failed to get trips by route ID: %v", err)
+	}
+	if len(trips) != 12 {
+		t.Fatalf("Expected 12 trips, got %d", len(trips))
+	}
+	for i := range 12 {
+		trip, ok := trips[gtfstest.TripID(1, i)]
+		if !ok || trip.RouteID != gtfstest.RouteID(1) {
+			t.Fatalf("Expected trip %s of route %s, got %v", gtfstest.TripID(1, i), gtfstest.RouteID(1), trip)
+		}
+	}
+
+	inbound, err := fixture.GetTripsByRouteAndDirection(gtfstest.RouteID(1), gtfs.InboundTripDirection)
+	if err != nil {
+		t.Fatalf("Failed to get trips by route and direction: %v", err)
+	}
+	if len(inbound) != 6 {
+		t.Fatalf("Expected 6 inbound trips, got %d", len(inbound))
+	}
+	if _, ok := inbound[gtfstest.TripID(1, 11)]; !ok {
+		t.Fatalf("Expected inbound trip %s", gtfstest.TripID(1, 11))
+	}
+
+	headsign := trips[gtfstest.TripID(1, 0)].Headsign
+	byHeadsign, err := fixture.GetTripsByHeadsign(headsign)
+	if err != nil {
+		t.Fatalf("Failed to get trips by headsign: %v", err)
+	}
+	if len(byHeadsign) != 6 {
+		t.Fatalf("Expected 6 trips with headsign %s, got %d", headsign, len(byHeadsign))
+	}
+	for id, trip := range byHeadsign {
+		if trip.ID != id || trip.Headsign != headsign {
+			t.Fatalf("Expected trip %s to have headsign %s, got %v", id, headsign, trip)
+		}
+	}
+
+	// The planner tracks stops by numeric ID, and should still report them by key
+	perth, _ := time.LoadLocation("Australia/Perth")
+	reachable, err := fixture.Isochrone(gtfstest.StopID(0, 0), time.Date(2025, 6, 2, 5, 55, 0, 0, perth), 30*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to compute isochrone: %v", err)
+	}
+	if len(reachable) != 5 || reachable[0].StopID != gtfstest.StopID(0, 0) || reachable[4].StopID != gtfstest.StopID(0, 4) {
+		t.Fatalf("Expected the stops of route %s in order, got %v", gtfstest.RouteID(0), reachable)
+	}
+}
+
 func TestGetServiceByID(t *testing.T) {
 	// Get the service by ID
 	service, err := g.GetServiceByID(serviceID)
@@ -114,6 +300,32 @@ func TestGetServiceException(t *testing.T) {
 	t.Logf("Service ID: %s", exception.ServiceID)
 }
 
+func TestGetExceptionsOn(t *testing.T) {
+	serviceDateParsed, err := time.Parse("2006-01-02", serviceDate)
+	if err != nil {
+		t.Fatalf("Failed to parse service date: %v", err)
+	}
+
+	exceptions, err := g.GetExceptionsOn(serviceDateParsed)
+	if err != nil {
+		t.Fatalf("Failed to get exceptions on %s: %v", serviceDate, err)
+	}
+
+	// Check that the known exception is included, and that every exception is on the date
+	found := false
+	for key, exception := range exceptions {
+		if exception.Date.Format("2006-01-02") != serviceDate {
+			t.Fatalf("Expected exceptions on %s, got %s for service %s", serviceDate, exception.Date.Format("2006-01-02"), key.ServiceID)
+		}
+		if exception.ServiceID == serviceID {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Expected exception for service %s on %s", serviceID, serviceDate)
+	}
+}
+
 func TestGetRouteByName(t *testing.T) {
 	// Get the route by name
 	route, err := g.GetRouteByName(routeName)
@@ -143,3 +355,1572 @@ func TestGetStopByName(t *testing.T) {
 
 	t.Logf("Stop ID: %s", stop.ID)
 }
+
+func TestGetStopByAlias(t *testing.T) {
+	// Check that the alias resolves by name
+	stop, err := g.GetStopByName(stopAlias)
+	if err != nil {
+		t.Fatalf("Failed to get stop by alias: %v", err)
+	}
+	if stop.ID != stopID {
+		t.Fatalf("Expected stop ID %s, got %s", stopID, stop.ID)
+	}
+
+	// Check that the alias is searchable, and the stop is reported by its current name
+	results, err := g.Search("interchange glendalough")
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	for _, result := range results {
+		if result.Type == gtfs.StopSearchResultType && result.ID == stopID {
+			if result.Name != stop.Name {
+				t.Fatalf("Expected result name %q, got %q", stop.Name, result.Name)
+			}
+			return
+		}
+	}
+	t.Fatalf("Expected stop %s in search results", stopID)
+}
+
+func TestGetStopsByZone(t *testing.T) {
+	// Get the zone of a known stop
+	stop, err := g.GetStopByID(stopID)
+	if err != nil {
+		t.Fatalf("Failed to get stop by ID: %v", err)
+	}
+	if stop.ZoneID == "" {
+		t.Skip("Stop has no zone")
+	}
+
+	// Get the stops in the same zone
+	stops, err := g.GetStopsByZone(stop.ZoneID)
+	if err != nil {
+		t.Fatalf("Failed to get stops by zone: %v", err)
+	}
+
+	// Check if the original stop is in the zone
+	if _, ok := stops[stopID]; !ok {
+		t.Fatalf("Expected stop %s in zone %s", stopID, stop.ZoneID)
+	}
+
+	t.Logf("Number of stops in zone %s: %d", stop.ZoneID, len(stops))
+}
+
+func TestSnapshot(t *testing.T) {
+	// Open a snapshot of the database
+	snapshot, err := g.Snapshot()
+	if err != nil {
+		t.Fatalf("Failed to open snapshot: %v", err)
+	}
+	defer snapshot.Close()
+
+	// Query the snapshot
+	stop, err := snapshot.GetStopByID(stopID)
+	if err != nil {
+		t.Fatalf("Failed to get stop by ID from snapshot: %v", err)
+	}
+
+	// Check if the stop ID matches the expected value
+	if stop.ID != stopID {
+		t.Fatalf("Expected stop ID %s, got %s", stopID, stop.ID)
+	}
+}
+
+func TestListStops(t *testing.T) {
+	// Page through the first few pages of stops
+	seen := make(map[gtfs.Key]bool)
+	var cursor gtfs.Key
+	for page := 0; page < 3; page++ {
+		stops, nextCursor, err := g.ListStops(cursor, 10)
+		if err != nil {
+			t.Fatalf("Failed to list stops: %v", err)
+		}
+
+		// Check that pages do not overlap
+		for _, stop := range stops {
+			if seen[stop.ID] {
+				t.Fatalf("Stop %s returned on multiple pages", stop.ID)
+			}
+			seen[stop.ID] = true
+		}
+
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+
+	t.Logf("Number of stops listed: %d", len(seen))
+}
+
+func TestGetTripsByHeadsign(t *testing.T) {
+	// Get the headsign of a known trip
+	trip, err := g.GetTripByID(tripID)
+	if err != nil {
+		t.Fatalf("Failed to get trip by ID: %v", err)
+	}
+	if trip.Headsign == "" {
+		t.Skip("Trip has no headsign")
+	}
+
+	// Get the trips with the same headsign
+	trips, err := g.GetTripsByHeadsign(trip.Headsign)
+	if err != nil {
+		t.Fatalf("Failed to get trips by headsign: %v", err)
+	}
+
+	// Check if the original trip is included
+	if _, ok := trips[tripID]; !ok {
+		t.Fatalf("Expected trip %s with headsign %s", tripID, trip.Headsign)
+	}
+
+	// Search for the headsign by prefix
+	headsigns, err := g.SearchHeadsigns(trip.Headsign[:1])
+	if err != nil {
+		t.Fatalf("Failed to search headsigns: %v", err)
+	}
+	if len(headsigns) == 0 {
+		t.Fatal("Expected non-empty headsign search results")
+	}
+
+	t.Logf("Number of trips with headsign %s: %d", trip.Headsign, len(trips))
+}
+
+func TestSearch(t *testing.T) {
+	stop, err := g.GetStopByID(stopID)
+	if err != nil {
+		t.Fatalf("Failed to get stop by ID: %v", err)
+	}
+
+	// Search for the stop by its name
+	results, err := g.Search(stop.Name)
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+
+	found := false
+	for _, result := range results {
+		if result.Type == gtfs.StopSearchResultType && result.ID == stopID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Fatalf("Expected stop %s in results for %q", stopID, stop.Name)
+	}
+
+	t.Logf("Found %d results for %q", len(results), stop.Name)
+}
+
+func TestGetStopsGroupedByName(t *testing.T) {
+	if gtfs.NormalizeName("Glendalough Stn.") != gtfs.NormalizeName("GLENDALOUGH  Station") {
+		t.Fatalf("Expected station abbreviations to normalize to the same name, got %q", gtfs.NormalizeName("Glendalough Stn."))
+	}
+
+	feed := gtfstest.NewFeed(gtfstest.Options{})
+	feed.Stops[gtfstest.StopID(0, 0)].Name = "Glendalough Stn"
+	feed.Stops[gtfstest.StopID(1, 0)].Name = "Glendalough Station"
+	fixture := &gtfs.GTFS{}
+	err := fixture.FromFeed(feed, filepath.Join(t.TempDir(), "gtfs.db"), gtfs.IngestOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer fixture.Close()
+
+	groups, err := fixture.GetStopsGroupedByName()
+	if err != nil {
+		t.Fatalf("Failed to group stops: %v", err)
+	}
+	group := groups["glendalough station"]
+	if len(group) != 2 || group[0].ID != gtfstest.StopID(0, 0) || group[1].ID != gtfstest.StopID(1, 0) {
+		t.Fatalf("Expected both Glendalough stops in one group, got %v", group)
+	}
+
+	// Searching for either variant finds both stops
+	results, err := fixture.Search("glendalough stn")
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	found := 0
+	for _, result := range results {
+		if result.Type == gtfs.StopSearchResultType {
+			found++
+		}
+	}
+	if found != 2 {
+		t.Fatalf("Expected 2 stops in results, got %v", results)
+	}
+}
+
+func TestGetShapeRefCount(t *testing.T) {
+	trip, err := g.GetTripByID(tripID)
+	if err != nil {
+		t.Fatalf("Failed to get trip by ID: %v", err)
+	}
+
+	// Get the reference count of the trip's shape
+	count, err := g.GetShapeRefCount(trip.ShapeID)
+	if err != nil {
+		t.Fatalf("Failed to get shape reference count: %v", err)
+	}
+
+	// Check that the shape is referenced by at least the trip itself
+	if count == 0 {
+		t.Fatalf("Expected shape %s to be referenced", trip.ShapeID)
+	}
+
+	t.Logf("Shape %s is referenced by %d trips", trip.ShapeID, count)
+}
+
+func TestGetRouteGeometry(t *testing.T) {
+	// Get the route geometry with and without simplification
+	full, err := g.GetRouteGeometry(routeID, 0)
+	if err != nil {
+		t.Fatalf("Failed to get route geometry: %v", err)
+	}
+	simplified, err := g.GetRouteGeometry(routeID, 0.001)
+	if err != nil {
+		t.Fatalf("Failed to get simplified route geometry: %v", err)
+	}
+
+	// Check that simplification does not add points
+	if len(simplified.Outbound) > len(full.Outbound) {
+		t.Fatalf("Expected at most %d simplified points, got %d", len(full.Outbound), len(simplified.Outbound))
+	}
+	if len(full.Outbound) > 0 && simplified.OutboundPolyline == "" {
+		t.Fatal("Expected an encoded outbound polyline")
+	}
+
+	t.Logf("Outbound points: %d full, %d simplified", len(full.Outbound), len(simplified.Outbound))
+}
+
+func TestShapePolyline(t *testing.T) {
+	trip, err := g.GetTripByID(tripID)
+	if err != nil {
+		t.Fatalf("Failed to get trip by ID: %v", err)
+	}
+	shape, err := g.GetShapeByID(trip.ShapeID)
+	if err != nil {
+		t.Fatalf("Failed to get shape by ID: %v", err)
+	}
+
+	// Encode and decode the shape as a polyline6
+	coords, err := gtfs.DecodePolyline(shape.EncodePolyline(6), 6)
+	if err != nil {
+		t.Fatalf("Failed to decode polyline: %v", err)
+	}
+
+	// Check that the coordinates survive the round trip within the precision
+	if len(coords) != len(shape.Coordinates) {
+		t.Fatalf("Expected %d coordinates, got %d", len(shape.Coordinates), len(coords))
+	}
+	for i, coord := range coords {
+		if coord.DistanceTo(shape.Coordinates[i]) > 1 {
+			t.Fatalf("Coordinate %d moved from %v to %v", i, shape.Coordinates[i], coord)
+		}
+	}
+}
+
+func TestGetStopSequenceInTrip(t *testing.T) {
+	trip, err := g.GetTripByID(tripID)
+	if err != nil {
+		t.Fatalf("Failed to get trip by ID: %v", err)
+	}
+	if len(trip.Stops) == 0 {
+		t.Skip("Trip has no stops")
+	}
+
+	// Get the position of the trip's first stop
+	firstStop := trip.Stops[0]
+	position, err := g.GetStopSequenceInTrip(tripID, firstStop.StopID)
+	if err != nil {
+		t.Fatalf("Failed to get stop sequence in trip: %v", err)
+	}
+
+	// Check that every other stop remains
+	if position.Index != 0 {
+		t.Fatalf("Expected index 0, got %d", position.Index)
+	}
+	if len(position.RemainingStops) != len(trip.Stops)-1 {
+		t.Fatalf("Expected %d remaining stops, got %d", len(trip.Stops)-1, len(position.RemainingStops))
+	}
+
+	t.Logf("Stops remaining after %s: %d", firstStop.StopID, len(position.RemainingStops))
+}
+
+func TestWarmup(t *testing.T) {
+	// Use a snapshot so the cache is not attached to the shared instance
+	snapshot, err := g.Snapshot()
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	defer snapshot.Close()
+
+	// Warm up the stops bucket
+	err = snapshot.Warmup(context.Background(), gtfs.StopEntityType)
+	if err != nil {
+		t.Fatalf("Failed to warm up cache: %v", err)
+	}
+
+	// Check that the stop is served from the cache
+	stop, err := snapshot.GetStopByID(stopID)
+	if err != nil {
+		t.Fatalf("Failed to get stop by ID: %v", err)
+	}
+	if stop.ID != stopID {
+		t.Fatalf("Expected stop ID %s, got %s", stopID, stop.ID)
+	}
+}
+
+func TestOpenAsOf(t *testing.T) {
+	// Open the version of the database current now
+	historical, err := g.OpenAsOf(time.Now())
+	if err != nil {
+		t.Fatalf("Failed to open database as of now: %v", err)
+	}
+	defer historical.Close()
+
+	// Check that it is the current version
+	if historical.Created != g.Created {
+		t.Fatalf("Expected database created at %d, got %d", g.Created, historical.Created)
+	}
+}
+
+func TestStopJSON(t *testing.T) {
+	stop, err := g.GetStopByID(stopID)
+	if err != nil {
+		t.Fatalf("Failed to get stop by ID: %v", err)
+	}
+
+	// Marshal the stop to JSON and back
+	data, err := json.Marshal(stop)
+	if err != nil {
+		t.Fatalf("Failed to marshal stop: %v", err)
+	}
+	var decoded gtfs.Stop
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal stop: %v", err)
+	}
+
+	// Check that the stop survives the round trip
+	if decoded != *stop {
+		t.Fatalf("Expected %+v, got %+v", *stop, decoded)
+	}
+
+	t.Logf("Stop JSON: %s", data)
+}
+
+func TestGetNearestStops(t *testing.T) {
+	stop, err := g.GetStopByID(stopID)
+	if err != nil {
+		t.Fatalf("Failed to get stop by ID: %v", err)
+	}
+
+	nearby, err := g.GetNearestStops(stop.Location, 10, 2000)
+	if err != nil {
+		t.Fatalf("Failed to get nearest stops: %v", err)
+	}
+	if len(nearby) == 0 || nearby[0].Distance != 0 {
+		t.Fatalf("Expected a stop at the query location first, got %v", nearby)
+	}
+
+	// Check that the projected candidates give the same result as ranking every stop
+	stops, err := g.GetAllStops()
+	if err != nil {
+		t.Fatalf("Failed to get all stops: %v", err)
+	}
+	within := 0
+	for _, s := range stops {
+		if stop.Location.DistanceTo(s.Location) <= 2000 {
+			within++
+		}
+	}
+	if expected := min(within, 10); len(nearby) != expected {
+		t.Fatalf("Expected %d nearby stops, got %d", expected, len(nearby))
+	}
+	last := nearby[len(nearby)-1].Distance
+	closer := 0
+	for _, s := range stops {
+		if stop.Location.DistanceTo(s.Location) < last {
+			closer++
+		}
+	}
+	if closer >= len(nearby) {
+		t.Fatalf("Expected at most %d stops closer than %fm, got %d", len(nearby)-1, last, closer)
+	}
+}
+
+func TestGetStopPatterns(t *testing.T) {
+	patterns, err := g.GetStopPatterns(routeID)
+	if err != nil {
+		t.Fatalf("Failed to get stop patterns: %v", err)
+	}
+	trips, err := g.GetTripsByRouteID(routeID)
+	if err != nil {
+		t.Fatalf("Failed to get trips by route ID: %v", err)
+	}
+
+	// Check that every trip belongs to exactly one pattern matching its stops
+	total := 0
+	for i, pattern := range patterns {
+		if pattern.Frequency != len(pattern.Trips) {
+			t.Fatalf("Pattern %d has frequency %d but %d trips", i, pattern.Frequency, len(pattern.Trips))
+		}
+		if i > 0 && pattern.Frequency > patterns[i-1].Frequency {
+			t.Fatalf("Pattern %d is more frequent than pattern %d", i, i-1)
+		}
+		for _, trip := range pattern.Trips {
+			if len(trip.Stops) != len(pattern.StopIDs) {
+				t.Fatalf("Trip %s has %d stops, expected %d", trip.ID, len(trip.Stops), len(pattern.StopIDs))
+			}
+			for j, stop := range trip.Stops {
+				if stop.StopID != pattern.StopIDs[j] {
+					t.Fatalf("Trip %s stop %d is %s, expected %s", trip.ID, j, stop.StopID, pattern.StopIDs[j])
+				}
+			}
+		}
+		total += len(pattern.Trips)
+	}
+	if total != len(trips) {
+		t.Fatalf("Expected %d trips across patterns, got %d", len(trips), total)
+	}
+
+	t.Logf("Route %s has %d stop patterns", routeID, len(patterns))
+}
+
+// Tests that stop patterns, headways and route geometries precomputed at ingest match those computed per query
+func TestPrecomputeDerived(t *testing.T) {
+	computed := gtfstest.New(t, gtfstest.Options{TripsPerRoute: 6})
+	precomputed := &gtfs.GTFS{}
+	err := precomputed.FromFeed(gtfstest.NewFeed(gtfstest.Options{TripsPerRoute: 6}), filepath.Join(t.TempDir(), "gtfs.db"), gtfs.IngestOptions{PrecomputeDerived: true})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer precomputed.Close()
+
+	routeID := gtfstest.RouteID(1)
+	for _, db := range []*gtfs.GTFS{computed, precomputed} {
+		headways, err := db.GetRouteHeadways(routeID)
+		if err != nil {
+			t.Fatalf("Failed to get route headways: %v", err)
+		}
+		// Trips alternate direction every 30 minutes, so each direction departs hourly
+		if len(headways) != 2 || headways[0].Direction != gtfs.OutboundTripDirection || headways[0].Trips != 3 || headways[0].MedianHeadway != 3600 || headways[1].MinHeadway != 3600 {
+			t.Fatalf("Expected hourly headways in each direction, got %+v", headways)
+		}
+	}
+
+	expectedPatterns, err := computed.GetStopPatterns(routeID)
+	if err != nil {
+		t.Fatalf("Failed to get stop patterns: %v", err)
+	}
+	patterns, err := precomputed.GetStopPatterns(routeID)
+	if err != nil {
+		t.Fatalf("Failed to get precomputed stop patterns: %v", err)
+	}
+	if len(patterns) != len(expectedPatterns) {
+		t.Fatalf("Expected %d stop patterns, got %d", len(expectedPatterns), len(patterns))
+	}
+	for i, pattern := range patterns {
+		if !slices.Equal(pattern.StopIDs, expectedPatterns[i].StopIDs) || pattern.Frequency != expectedPatterns[i].Frequency {
+			t.Fatalf("Expected stop pattern %d to be %v, got %v", i, expectedPatterns[i].StopIDs, pattern.StopIDs)
+		}
+		for j, trip := range pattern.Trips {
+			if trip.ID != expectedPatterns[i].Trips[j].ID {
+				t.Fatalf("Expected trip %s in stop pattern %d, got %s", expectedPatterns[i].Trips[j].ID, i, trip.ID)
+			}
+		}
+	}
+
+	expectedGeometry, err := computed.GetRouteGeometry(routeID, gtfs.DefaultGeometryTolerance)
+	if err != nil {
+		t.Fatalf("Failed to get route geometry: %v", err)
+	}
+	geometry, err := precomputed.GetRouteGeometry(routeID, gtfs.DefaultGeometryTolerance)
+	if err != nil {
+		t.Fatalf("Failed to get precomputed route geometry: %v", err)
+	}
+	if geometry.InboundPolyline == "" || geometry.InboundPolyline != expectedGeometry.InboundPolyline || geometry.OutboundPolyline != expectedGeometry.OutboundPolyline {
+		t.Fatalf("Expected route geometry %+v, got %+v", expectedGeometry, geometry)
+	}
+}
+
+func TestGetRouteBranches(t *testing.T) {
+	feed := gtfstest.NewFeed(gtfstest.Options{TripsPerRoute: 8})
+
+	// One outbound trip ends at a new stop instead of the last stop, and another is a short working
+	branchStop := *feed.Stops[gtfstest.StopID(0, 4)]
+	branchStop.ID = "BRANCH"
+	branchStop.Name = "Branch Terminus"
+	branchStop.Location.Latitude += 0.01
+	feed.Stops[branchStop.ID] = &branchStop
+	branchTrip := feed.Trips[gtfstest.TripID(0, 4)]
+	branchTrip.Stops[len(branchTrip.Stops)-1].StopID = branchStop.ID
+	shortTrip := feed.Trips[gtfstest.TripID(0, 6)]
+	shortTrip.Stops = shortTrip.Stops[:3]
+
+	// The inbound trips of the second route have no stops
+	for i := 1; i < 8; i += 2 {
+		feed.Trips[gtfstest.TripID(1, i)].Stops = gtfs.TripStopArray{}
+	}
+
+	fixture := &gtfs.GTFS{}
+	err := fixture.FromFeed(feed, filepath.Join(t.TempDir(), "gtfs.db"), gtfs.IngestOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer fixture.Close()
+
+	directions, err := fixture.GetRouteBranches(gtfstest.RouteID(0))
+	if err != nil {
+		t.Fatalf("Failed to get route branches: %v", err)
+	}
+	if len(directions) != 2 {
+		t.Fatalf("Expected branches in 2 directions, got %d", len(directions))
+	}
+
+	outbound := directions[0]
+	if outbound.Direction != gtfs.OutboundTripDirection || len(outbound.Trunk) != 4 || outbound.Trunk[3] != gtfstest.StopID(0, 3) {
+		t.Fatalf("Expected an outbound trunk of the first 4 stops, got %v", outbound.Trunk)
+	}
+	if len(outbound.Branches) != 2 {
+		t.Fatalf("Expected 2 outbound branches, got %v", outbound.Branches)
+	}
+	primary, branch := outbound.Branches[0], outbound.Branches[1]
+	if primary.Frequency != 2 || len(primary.StopIDs) != 1 || primary.StopIDs[0] != gtfstest.StopID(0, 4) || primary.JunctionID != gtfstest.StopID(0, 3) {
+		t.Fatalf("Expected the main branch to the last stop, got %+v", primary)
+	}
+	if branch.Frequency != 1 || branch.Name != "Branch Terminus" || branch.BeforeTrunk {
+		t.Fatalf("Expected a branch to Branch Terminus, got %+v", branch)
+	}
+
+	// Inbound trips all share one pattern, so there is no branching
+	inbound := directions[1]
+	if len(inbound.Trunk) != 5 || len(inbound.Branches) != 0 {
+		t.Fatalf("Expected an inbound trunk of 5 stops without branches, got %v and %v", inbound.Trunk, inbound.Branches)
+	}
+
+	// Trips without stops have neither a trunk nor branches
+	directions, err = fixture.GetRouteBranches(gtfstest.RouteID(1))
+	if err != nil {
+		t.Fatalf("Failed to get route branches: %v", err)
+	}
+	if len(directions) != 2 || len(directions[1].Trunk) != 0 || len(directions[1].Branches) != 0 {
+		t.Fatalf("Expected no inbound trunk or branches, got %+v", directions)
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	t.Cleanup(func() {
+		err := g.ResetOverrides()
+		if err != nil {
+			t.Fatalf("Failed to reset overrides: %v", err)
+		}
+	})
+
+	// Suppress the stop
+	err := g.SuppressStop(stopID)
+	if err != nil {
+		t.Fatalf("Failed to suppress stop: %v", err)
+	}
+	if _, err := g.GetStopByID(stopID); err == nil {
+		t.Fatal("Expected suppressed stop to not be found")
+	}
+
+	// Replace the stop with a renamed version
+	stop := &gtfs.Stop{ID: stopID, Name: "Detour Stop"}
+	err = g.OverrideStop(stop)
+	if err != nil {
+		t.Fatalf("Failed to override stop: %v", err)
+	}
+	stops, err := g.GetStopsByIDs([]gtfs.Key{stopID})
+	if err != nil {
+		t.Fatalf("Failed to get stops by IDs: %v", err)
+	}
+	if stops[stopID] == nil || stops[stopID].Name != "Detour Stop" {
+		t.Fatalf("Expected overridden stop, got %v", stops[stopID])
+	}
+
+	// Check that resetting restores the ingested stop
+	err = g.ResetOverrides()
+	if err != nil {
+		t.Fatalf("Failed to reset overrides: %v", err)
+	}
+	restored, err := g.GetStopByID(stopID)
+	if err != nil {
+		t.Fatalf("Failed to get stop after reset: %v", err)
+	}
+	if restored.Name == "Detour Stop" {
+		t.Fatal("Expected ingested stop after reset")
+	}
+}
+
+func TestOverrideRouteTrips(t *testing.T) {
+	fixture := gtfstest.New(t, gtfstest.Options{})
+
+	// Move a trip onto a route with no trips in the database
+	trip, err := fixture.GetTripByID(gtfstest.TripID(1, 0))
+	if err != nil {
+		t.Fatalf("Failed to get trip: %v", err)
+	}
+	err = fixture.OverrideRoute(&gtfs.Route{ID: "R9", AgencyID: gtfstest.AgencyID, Name: "9", Type: gtfs.BusRouteType})
+	if err != nil {
+		t.Fatalf("Failed to override route: %v", err)
+	}
+	moved := *trip
+	moved.RouteID = "R9"
+	err = fixture.OverrideTrip(&moved)
+	if err != nil {
+		t.Fatalf("Failed to override trip: %v", err)
+	}
+	trips, err := fixture.GetTripsByRouteID("R9")
+	if err != nil {
+		t.Fatalf("Failed to get trips of the added route: %v", err)
+	}
+	if len(trips) != 1 || trips[moved.ID] == nil {
+		t.Fatalf("Expected the moved trip on the added route, got %d trips", len(trips))
+	}
+	trips, err = fixture.GetTripsByRouteAndDirection("R9", gtfs.OutboundTripDirection)
+	if err != nil {
+		t.Fatalf("Failed to get trips of the added route in its direction: %v", err)
+	}
+	if len(trips) != 1 {
+		t.Fatalf("Expected the moved trip outbound on the added route, got %d trips", len(trips))
+	}
+	_, err = fixture.GetTripsByRouteAndDirection("R9", gtfs.InboundTripDirection)
+	if err == nil {
+		t.Fatal("Expected no inbound trips on the added route")
+	}
+
+	// Check that a route whose trips are all suppressed has none
+	for i := range 4 {
+		err = fixture.SuppressTrip(gtfstest.TripID(0, i))
+		if err != nil {
+			t.Fatalf("Failed to suppress trip: %v", err)
+		}
+	}
+	_, err = fixture.GetTripsByRouteID(gtfstest.RouteID(0))
+	if !errors.Is(err, gtfs.ErrNotFound) {
+		t.Fatalf("Expected no trips on a route with all of its trips suppressed, got %v", err)
+	}
+
+	// Check that the agency's other routes still have their trips
+	trips, err = fixture.GetTripsByAgencyID(gtfstest.AgencyID)
+	if err != nil {
+		t.Fatalf("Failed to get trips of the agency: %v", err)
+	}
+	for _, trip := range trips {
+		if trip.RouteID == gtfstest.RouteID(0) {
+			t.Fatalf("Expected no trips of the route with its trips suppressed, got %s", trip.ID)
+		}
+	}
+	if len(trips) == 0 {
+		t.Fatal("Expected trips of the agency's other routes")
+	}
+	trips, err = fixture.FindTrips(gtfs.WithRouteTypes(gtfs.BusRouteType))
+	if err != nil {
+		t.Fatalf("Failed to find trips by route type: %v", err)
+	}
+	if len(trips) == 0 {
+		t.Fatal("Expected trips of the other bus routes")
+	}
+}
+
+func TestErrNotFound(t *testing.T) {
+	fixture := gtfstest.New(t, gtfstest.Options{})
+
+	lookups := map[string]func() error{
+		"agency": func() error { _, err := fixture.GetAgencyByID("missing"); return err },
+		"route":  func() error { _, err := fixture.GetRouteByID("missing"); return err },
+		"stop":   func() error { _, err := fixture.GetStopByID("missing"); return err },
+		"trip":   func() error { _, err := fixture.GetTripByID("missing"); return err },
+		"shape":  func() error { _, err := fixture.GetShapeByID("missing"); return err },
+	}
+	for name, lookup := range lookups {
+		err := lookup()
+		if !errors.Is(err, gtfs.ErrNotFound) {
+			t.Fatalf("Expected a missing %s to be reported as not found, got %v", name, err)
+		}
+	}
+
+	// Check that suppressed entities are not found either
+	err := fixture.SuppressStop(gtfstest.StopID(0, 0))
+	if err != nil {
+		t.Fatalf("Failed to suppress stop: %v", err)
+	}
+	_, err = fixture.GetStopByID(gtfstest.StopID(0, 0))
+	if !errors.Is(err, gtfs.ErrNotFound) {
+		t.Fatalf("Expected a suppressed stop to be reported as not found, got %v", err)
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	var buf bytes.Buffer
+	err := g.Backup(&buf)
+	if err != nil {
+		t.Fatalf("Failed to back up database: %v", err)
+	}
+
+	// Restore the backup to a new file and check that it holds the same data
+	restoreFile := filepath.Join(t.TempDir(), "restored.db")
+	err = gtfs.Restore(&buf, restoreFile)
+	if err != nil {
+		t.Fatalf("Failed to restore database: %v", err)
+	}
+
+	restored := &gtfs.GTFS{}
+	err = restored.FromDB(restoreFile)
+	if err != nil {
+		t.Fatalf("Failed to open restored database: %v", err)
+	}
+	defer restored.Close()
+
+	if restored.Created != g.Created {
+		t.Fatalf("Expected created timestamp %d, got %d", g.Created, restored.Created)
+	}
+	if _, err := restored.GetStopByID(stopID); err != nil {
+		t.Fatalf("Failed to get stop from restored database: %v", err)
+	}
+
+	// Check that an invalid backup is rejected
+	err = gtfs.Restore(strings.NewReader("not a database"), restoreFile)
+	if err == nil {
+		t.Fatal("Expected invalid backup to be rejected")
+	}
+}
+
+func TestFindTrips(t *testing.T) {
+	agency, err := g.GetAgencyByID(agencyID)
+	if err != nil {
+		t.Fatalf("Failed to get agency by ID: %v", err)
+	}
+	loc, err := time.LoadLocation(agency.Timezone)
+	if err != nil {
+		t.Fatalf("Failed to load timezone: %v", err)
+	}
+	date, err := time.ParseInLocation("2006-01-02", serviceDate, loc)
+	if err != nil {
+		t.Fatalf("Failed to parse service date: %v", err)
+	}
+
+	trips, err := g.FindTrips(
+		gtfs.WithRoute(routeID),
+		gtfs.WithDirection(gtfs.OutboundTripDirection),
+		gtfs.WithDateRange(date, date),
+		gtfs.WithModes(gtfs.RailModeFlag),
+	)
+	if err != nil {
+		t.Fatalf("Failed to find trips: %v", err)
+	}
+	if len(trips) == 0 {
+		t.Fatal("Expected outbound trips on the route")
+	}
+	for _, trip := range trips {
+		if trip.RouteID != routeID || trip.Direction != gtfs.OutboundTripDirection {
+			t.Fatalf("Trip %s does not match the filters", trip.ID)
+		}
+	}
+
+	// Check that no bus trips are found on a rail route
+	trips, err = g.FindTrips(gtfs.WithRoute(routeID), gtfs.WithModes(gtfs.BusModeFlag))
+	if err != nil {
+		t.Fatalf("Failed to find trips: %v", err)
+	}
+	if len(trips) != 0 {
+		t.Fatalf("Expected no bus trips on rail route, got %d", len(trips))
+	}
+}
+
+func TestFindStops(t *testing.T) {
+	stops, err := g.FindStops(gtfs.WithRoute(routeID), gtfs.WithDirection(gtfs.InboundTripDirection))
+	if err != nil {
+		t.Fatalf("Failed to find stops: %v", err)
+	}
+	if len(stops) == 0 {
+		t.Fatal("Expected stops served by inbound trips of the route")
+	}
+
+	route, err := g.GetRouteByID(routeID)
+	if err != nil {
+		t.Fatalf("Failed to get route by ID: %v", err)
+	}
+	for id := range stops {
+		if !slices.Contains(route.Stops, id) {
+			t.Fatalf("Stop %s is not served by the route", id)
+		}
+	}
+}
+
+// Tests finding the stops of routes on a feed without shapes, including a stop served by a route only on one
+// of its trips
+func TestFindStopsWithoutShapes(t *testing.T) {
+	// The third trip of the first route branches to the middle stop of the second route
+	feed := gtfstest.NewFeed(gtfstest.Options{RouteTypes: []gtfs.RouteType{gtfs.RailRouteType, gtfs.BusRouteType}})
+	feed.Trips[gtfstest.TripID(0, 2)].Stops[2].StopID = gtfstest.StopID(1, 2)
+	fixture := newShapelessFixture(t, feed)
+
+	tests := []struct {
+		opts     []gtfs.QueryOption
+		expected int
+	}{
+		{[]gtfs.QueryOption{gtfs.WithRoute(gtfstest.RouteID(0))}, 6},
+		{[]gtfs.QueryOption{gtfs.WithRoute(gtfstest.RouteID(1))}, 5},
+		{[]gtfs.QueryOption{gtfs.WithRouteTypes(gtfs.RailRouteType)}, 6},
+	}
+	for i, test := range tests {
+		stops, err := fixture.FindStops(test.opts...)
+		if err != nil {
+			t.Fatalf("Failed to find stops: %v", err)
+		}
+		if len(stops) != test.expected {
+			t.Fatalf("Expected %d stops for query %d, got %d", test.expected, i, len(stops))
+		}
+		if i != 1 {
+			if _, ok := stops[gtfstest.StopID(1, 2)]; !ok {
+				t.Fatalf("Expected the branch stop for query %d", i)
+			}
+		}
+	}
+}
+
+func TestFindRoutesWithShapes(t *testing.T) {
+	fixture := gtfstest.New(t, gtfstest.Options{})
+
+	routes, err := fixture.FindRoutes(gtfs.WithShapes())
+	if err != nil {
+		t.Fatalf("Failed to find routes: %v", err)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("Expected 2 routes, got %d", len(routes))
+	}
+	for id, route := range routes {
+		if route.InboundShape == nil || route.OutboundShape == nil {
+			t.Fatalf("Expected shapes loaded for route %s", id)
+		}
+		if route.OutboundShape.ID != gtfstest.ShapeID(0, gtfs.OutboundTripDirection) && route.OutboundShape.ID != gtfstest.ShapeID(1, gtfs.OutboundTripDirection) {
+			t.Fatalf("Unexpected outbound shape %s for route %s", route.OutboundShape.ID, id)
+		}
+	}
+
+	// Routes from other queries are left without shapes until loaded
+	route, err := fixture.GetRouteByID(gtfstest.RouteID(0))
+	if err != nil {
+		t.Fatalf("Failed to get route by ID: %v", err)
+	}
+	if route.InboundShape != nil {
+		t.Fatal("Expected route without shapes")
+	}
+	err = route.LoadShapes(fixture)
+	if err != nil {
+		t.Fatalf("Failed to load shapes: %v", err)
+	}
+	if route.InboundShape == nil || route.InboundShape.ID != *route.InboundShapeID {
+		t.Fatal("Expected inbound shape loaded")
+	}
+}
+
+func TestGetSegmentTravelTimes(t *testing.T) {
+	segments, err := g.GetSegmentTravelTimes(routeID)
+	if err != nil {
+		t.Fatalf("Failed to get segment travel times: %v", err)
+	}
+	if len(segments) == 0 {
+		t.Fatal("Expected segments for the route")
+	}
+
+	for _, segment := range segments {
+		if segment.Samples == 0 {
+			t.Fatalf("Segment %s -> %s has no samples", segment.FromStopID, segment.ToStopID)
+		}
+		if segment.Distance < 0 {
+			t.Fatalf("Segment %s -> %s has negative distance", segment.FromStopID, segment.ToStopID)
+		}
+	}
+
+	t.Logf("First segment: %+v", segments[0])
+}
+
+func TestGetRoutesByAgencyID(t *testing.T) {
+	routes, err := g.GetRoutesByAgencyID(agencyID)
+	if err != nil {
+		t.Fatalf("Failed to get routes by agency: %v", err)
+	}
+	if _, ok := routes[routeID]; !ok {
+		t.Fatalf("Expected route %s of agency %s", routeID, agencyID)
+	}
+	for id, route := range routes {
+		if route.AgencyID != agencyID {
+			t.Fatalf("Expected route %s to be operated by %s, got %s", id, agencyID, route.AgencyID)
+		}
+	}
+
+	// Check that the agency filter matches trips and stops of the agency's routes only
+	trips, err := g.FindTrips(gtfs.WithAgency(agencyID))
+	if err != nil {
+		t.Fatalf("Failed to find trips: %v", err)
+	}
+	if _, ok := trips[tripID]; !ok {
+		t.Fatalf("Expected trip %s of agency %s", tripID, agencyID)
+	}
+	for id, trip := range trips {
+		if _, ok := routes[trip.RouteID]; !ok {
+			t.Fatalf("Expected trip %s to be on a route of %s, got %s", id, agencyID, trip.RouteID)
+		}
+	}
+
+	stops, err := g.GetStopsByAgencyID(agencyID)
+	if err != nil {
+		t.Fatalf("Failed to get stops by agency: %v", err)
+	}
+	if _, ok := stops[stopID]; !ok {
+		t.Fatalf("Expected stop %s served by agency %s", stopID, agencyID)
+	}
+
+	routes, err = g.GetRoutesByAgencyID("unknown")
+	if err != nil {
+		t.Fatalf("Failed to get routes by unknown agency: %v", err)
+	}
+	if len(routes) != 0 {
+		t.Fatalf("Expected no routes for unknown agency, got %d", len(routes))
+	}
+}
+
+func TestGetRoutesByType(t *testing.T) {
+	routes, err := g.GetRoutesByType(gtfs.RailRouteType)
+	if err != nil {
+		t.Fatalf("Failed to get routes by type: %v", err)
+	}
+	if _, ok := routes[routeID]; !ok {
+		t.Fatalf("Expected rail route %s", routeID)
+	}
+	for id, route := range routes {
+		if route.Type != gtfs.RailRouteType {
+			t.Fatalf("Expected route %s to be rail, got %v", id, route.Type)
+		}
+	}
+
+	// Check that the type filter matches trips and stops of rail routes only
+	trips, err := g.FindTrips(gtfs.WithRouteTypes(gtfs.RailRouteType), gtfs.WithDirection(gtfs.OutboundTripDirection))
+	if err != nil {
+		t.Fatalf("Failed to find trips: %v", err)
+	}
+	if len(trips) == 0 {
+		t.Fatal("Expected outbound rail trips")
+	}
+	for id, trip := range trips {
+		if _, ok := routes[trip.RouteID]; !ok {
+			t.Fatalf("Trip %s is not on a rail route", id)
+		}
+	}
+
+	stops, err := g.FindStops(gtfs.WithRouteTypes(gtfs.RailRouteType))
+	if err != nil {
+		t.Fatalf("Failed to find stops: %v", err)
+	}
+	if _, ok := stops[stopID]; !ok {
+		t.Fatalf("Expected stop %s to be served by a rail route", stopID)
+	}
+
+	t.Logf("%d rail routes, %d outbound rail trips, %d rail stops", len(routes), len(trips), len(stops))
+}
+
+func TestGetStopProfile(t *testing.T) {
+	profile, err := g.GetStopProfile(stopID)
+	if err != nil {
+		t.Fatalf("Failed to get stop profile: %v", err)
+	}
+
+	// The stop is served by the route, so the profile includes it and its mode
+	found := false
+	for _, route := range profile.Routes {
+		if route.ID == routeID {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Expected route %s in stop profile", routeID)
+	}
+	if profile.Modes&gtfs.RailModeFlag == 0 {
+		t.Fatalf("Expected rail mode in stop profile, got %v", profile.Modes)
+	}
+	if profile.Departures == 0 || profile.FirstDeparture > profile.LastDeparture {
+		t.Fatalf("Expected departures from %d to %d", profile.FirstDeparture, profile.LastDeparture)
+	}
+	for _, transfer := range profile.Transfers {
+		if transfer.Stop.ID == stopID || transfer.Distance > 400 || len(transfer.RouteIDs) == 0 {
+			t.Fatalf("Unexpected transfer: %+v", transfer)
+		}
+	}
+
+	t.Logf("Stop %s: %d routes, %d departures, %d transfers", stopID, len(profile.Routes), profile.Departures, len(profile.Transfers))
+}
+
+// Tests profiling a stop of a feed without shapes, served by one route only on one of its trips
+func TestGetStopProfileWithoutShapes(t *testing.T) {
+	// The third trip of the first route branches to the middle stop of the second route, next to the
+	// first route's own middle stop
+	feed := gtfstest.NewFeed(gtfstest.Options{RouteTypes: []gtfs.RouteType{gtfs.RailRouteType, gtfs.BusRouteType}})
+	feed.Trips[gtfstest.TripID(0, 2)].Stops[2].StopID = gtfstest.StopID(1, 2)
+	feed.Stops[gtfstest.StopID(0, 2)].Location = feed.Stops[gtfstest.StopID(1, 2)].Location
+	fixture := newShapelessFixture(t, feed)
+
+	profile, err := fixture.GetStopProfile(gtfstest.StopID(1, 2))
+	if err != nil {
+		t.Fatalf("Failed to get stop profile: %v", err)
+	}
+	var routeIDs []gtfs.Key
+	for _, route := range profile.Routes {
+		routeIDs = append(routeIDs, route.ID)
+	}
+	if !slices.Equal(routeIDs, []gtfs.Key{gtfstest.RouteID(0), gtfstest.RouteID(1)}) {
+		t.Fatalf("Expected both routes in stop profile, got %v", routeIDs)
+	}
+	if profile.Modes&gtfs.RailModeFlag == 0 || profile.Modes&gtfs.BusModeFlag == 0 {
+		t.Fatalf("Expected rail and bus modes in stop profile, got %v", profile.Modes)
+	}
+	if profile.Departures != 5 || profile.FirstDeparture != 6*3600+10*60 || profile.LastDeparture != 7*3600+40*60 {
+		t.Fatalf("Expected 5 departures from 06:10 to 07:40, got %d from %d to %d", profile.Departures, profile.FirstDeparture, profile.LastDeparture)
+	}
+	if len(profile.Transfers) != 1 || profile.Transfers[0].Stop.ID != gtfstest.StopID(0, 2) ||
+		!slices.Equal(profile.Transfers[0].RouteIDs, []gtfs.Key{gtfstest.RouteID(0)}) {
+		t.Fatalf("Expected a transfer to the first route's middle stop, got %+v", profile.Transfers)
+	}
+}
+
+// Tests getting stops, trips and routes by ID prefix
+func TestGetEntitiesWithIDPrefix(t *testing.T) {
+	fixture := gtfstest.New(t, gtfstest.Options{Routes: 3})
+
+	stops, err := fixture.GetStopsWithIDPrefix(string(gtfstest.RouteID(1)))
+	if err != nil {
+		t.Fatalf("Failed to get stops by prefix: %v", err)
+	}
+	if len(stops) != 5 {
+		t.Fatalf("Expected 5 stops with prefix %s, got %d", gtfstest.RouteID(1), len(stops))
+	}
+
+	trips, err := fixture.GetTripsWithIDPrefix(string(gtfstest.RouteID(0)))
+	if err != nil {
+		t.Fatalf("Failed to get trips by prefix: %v", err)
+	}
+	for id := range trips {
+		if !strings.HasPrefix(string(id), string(gtfstest.RouteID(0))) {
+			t.Fatalf("Expected trips with prefix %s, got %s", gtfstest.RouteID(0), id)
+		}
+	}
+	if len(trips) != 4 {
+		t.Fatalf("Expected 4 trips with prefix %s, got %d", gtfstest.RouteID(0), len(trips))
+	}
+
+	routes, err := fixture.GetRoutesWithIDPrefix("unknown")
+	if err != nil {
+		t.Fatalf("Failed to get routes by prefix: %v", err)
+	}
+	if len(routes) != 0 {
+		t.Fatalf("Expected no routes with unknown prefix, got %d", len(routes))
+	}
+}
+
+func TestQueryStats(t *testing.T) {
+	fixture := gtfstest.New(t, gtfstest.Options{})
+
+	var stats []gtfs.QueryStats
+	fixture.SetQueryStats(gtfs.QueryStatsOptions{
+		OnQuery:            func(s gtfs.QueryStats) { stats = append(stats, s) },
+		SlowQueryThreshold: time.Hour,
+	})
+
+	_, err := fixture.GetTripByID(gtfstest.TripID(0, 0))
+	if err != nil {
+		t.Fatalf("Failed to get trip: %v", err)
+	}
+	if len(stats) != 1 || stats[0].Query != "GetTripByID" || stats[0].KeysRead != 1 || stats[0].BytesDecoded == 0 {
+		t.Fatalf("Unexpected stats for GetTripByID: %+v", stats)
+	}
+
+	// Queries within another query's transaction are counted towards it
+	stats = nil
+	_, err = fixture.GetTripDetail(gtfstest.TripID(0, 0))
+	if err != nil {
+		t.Fatalf("Failed to get trip detail: %v", err)
+	}
+	if len(stats) != 1 || stats[0].Query != "GetTripDetail" || stats[0].KeysRead < 4 {
+		t.Fatalf("Unexpected stats for GetTripDetail: %+v", stats)
+	}
+
+	// Collection can be disabled again
+	stats = nil
+	fixture.SetQueryStats(gtfs.QueryStatsOptions{})
+	_, err = fixture.GetTripByID(gtfstest.TripID(0, 0))
+	if err != nil {
+		t.Fatalf("Failed to get trip: %v", err)
+	}
+	if len(stats) != 0 {
+		t.Fatalf("Expected no stats once disabled, got %+v", stats)
+	}
+}
+
+func TestProjectionQueries(t *testing.T) {
+	for _, enc := range []gtfs.Encoding{gtfs.BinaryEncoding, gtfs.ProtobufEncoding} {
+		fixture := &gtfs.GTFS{}
+		err := fixture.FromFeed(gtfstest.NewFeed(gtfstest.Options{}), filepath.Join(t.TempDir(), "gtfs.db"), gtfs.IngestOptions{Encoding: enc})
+		if err != nil {
+			t.Fatalf("Failed to create %s database: %v", enc, err)
+		}
+		defer fixture.Close()
+
+		// Locations match the fully decoded stops, and unknown stops are skipped
+		stopIDs := []gtfs.Key{gtfstest.StopID(0, 0), gtfstest.StopID(1, 2), "unknown"}
+		locations, err := fixture.GetStopLocations(stopIDs)
+		if err != nil {
+			t.Fatalf("Failed to get %s stop locations: %v", enc, err)
+		}
+		stops, err := fixture.GetStopsByIDs(stopIDs)
+		if err != nil {
+			t.Fatalf("Failed to get stops: %v", err)
+		}
+		if len(locations) != 2 {
+			t.Fatalf("Expected 2 %s stop locations, got %d", enc, len(locations))
+		}
+		for id, stop := range stops {
+			if locations[id] != stop.Location {
+				t.Fatalf("Expected %s location %v for stop %s, got %v", enc, stop.Location, id, locations[id])
+			}
+		}
+
+		// Headsigns match the fully decoded trips
+		headsigns, err := fixture.GetTripHeadsigns(gtfstest.RouteID(0))
+		if err != nil {
+			t.Fatalf("Failed to get %s trip headsigns: %v", enc, err)
+		}
+		trips, err := fixture.GetTripsByRouteID(gtfstest.RouteID(0))
+		if err != nil {
+			t.Fatalf("Failed to get trips: %v", err)
+		}
+		if len(headsigns) != len(trips) {
+			t.Fatalf("Expected %d %s headsigns, got %d", len(trips), enc, len(headsigns))
+		}
+		for id, trip := range trips {
+			if headsigns[id] != trip.Headsign || trip.Headsign == "" {
+				t.Fatalf("Expected %s headsign %q for trip %s, got %q", enc, trip.Headsign, id, headsigns[id])
+			}
+		}
+	}
+}
+
+// Snaps segments by passing through their midpoint
+type midpointSnapper struct{}
+
+func (midpointSnapper) SnapSegment(from, to gtfs.Coordinate) (gtfs.CoordinateArray, error) {
+	mid := gtfs.NewCoordinate((from.Latitude+to.Latitude)/2, (from.Longitude+to.Longitude)/2)
+	return gtfs.CoordinateArray{from, mid, to}, nil
+}
+
+func TestInferShapes(t *testing.T) {
+	feed := gtfstest.NewFeed(gtfstest.Options{})
+	feed.Shapes = gtfs.ShapeMap{}
+	for _, trip := range feed.Trips {
+		trip.ShapeID = ""
+	}
+	feed.Trips[gtfstest.TripID(1, 0)].ShapeID = "MISSING"
+
+	fixture := &gtfs.GTFS{}
+	err := fixture.FromFeed(feed, filepath.Join(t.TempDir(), "gtfs.db"), gtfs.IngestOptions{InferShapes: true, ShapeSnapper: midpointSnapper{}})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer fixture.Close()
+
+	// Outbound trips of the first route share an inferred shape through their stops and the midpoints
+	trip, err := fixture.GetTripByID(gtfstest.TripID(0, 0))
+	if err != nil {
+		t.Fatalf("Failed to get trip: %v", err)
+	}
+	if !strings.HasPrefix(string(trip.ShapeID), "derived-") {
+		t.Fatalf("Expected an inferred shape ID, got %q", trip.ShapeID)
+	}
+	shape, err := fixture.GetShapeByID(trip.ShapeID)
+	if err != nil {
+		t.Fatalf("Failed to get inferred shape: %v", err)
+	}
+	if len(shape.Coordinates) != 2*len(trip.Stops)-1 {
+		t.Fatalf("Expected %d coordinates, got %d", 2*len(trip.Stops)-1, len(shape.Coordinates))
+	}
+	count, err := fixture.GetShapeRefCount(trip.ShapeID)
+	if err != nil || count != 2 {
+		t.Fatalf("Expected inferred shape to be used by 2 trips, got %d (%v)", count, err)
+	}
+	route, err := fixture.GetRouteByID(gtfstest.RouteID(0))
+	if err != nil {
+		t.Fatalf("Failed to get route: %v", err)
+	}
+	if route.OutboundShapeID == nil || *route.OutboundShapeID != trip.ShapeID {
+		t.Fatalf("Expected route to use the inferred outbound shape, got %v", route.OutboundShapeID)
+	}
+
+	// A missing shape is inferred under its own ID
+	shapes, err := fixture.GetShapesByIDs([]gtfs.Key{"MISSING", trip.ShapeID})
+	if err != nil {
+		t.Fatalf("Failed to get shapes: %v", err)
+	}
+	if len(shapes) != 2 {
+		t.Fatalf("Expected 2 shapes, got %d", len(shapes))
+	}
+}
+
+func TestAddServiceException(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "gtfs.db")
+	fixture := &gtfs.GTFS{}
+	err := fixture.FromFeed(gtfstest.NewFeed(gtfstest.Options{}), dbFile, gtfs.IngestOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	perth, err := time.LoadLocation("Australia/Perth")
+	if err != nil {
+		t.Fatalf("Failed to load timezone: %v", err)
+	}
+	date := time.Date(2030, 6, 3, 5, 0, 0, 0, perth)
+	serviceID := gtfstest.ServiceID(0)
+	stop := gtfstest.StopID(0, 0)
+
+	departures, err := fixture.GetStopDepartures(stop, date, 4*time.Hour)
+	if err != nil || len(departures) == 0 {
+		t.Fatalf("Expected departures before the closure, got %d (%v)", len(departures), err)
+	}
+
+	// Close the service for the day
+	err = fixture.AddServiceException(serviceID, date, gtfs.RemovedExceptionType)
+	if err != nil {
+		t.Fatalf("Failed to add service exception: %v", err)
+	}
+	exception, err := fixture.GetServiceException(serviceID, date)
+	if err != nil || exception.Type != gtfs.RemovedExceptionType {
+		t.Fatalf("Expected added service exception, got %v (%v)", exception, err)
+	}
+	exceptions, err := fixture.GetExceptionsOn(date)
+	if err != nil || len(exceptions) != 1 {
+		t.Fatalf("Expected 1 exception on the date, got %d (%v)", len(exceptions), err)
+	}
+	departures, err = fixture.GetStopDepartures(stop, date, 4*time.Hour)
+	if err != nil || len(departures) != 0 {
+		t.Fatalf("Expected no departures on the closed day, got %d (%v)", len(departures), err)
+	}
+	days, err := fixture.GetServiceDays(serviceID)
+	if err != nil || days.Contains(date) {
+		t.Fatalf("Expected service days to exclude the closed day (%v)", err)
+	}
+
+	// Check that the exception persists when the database is reopened
+	fixture.Close()
+	fixture = &gtfs.GTFS{}
+	err = fixture.FromDB(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer fixture.Close()
+	all, err := fixture.GetAllServiceExceptions()
+	if err != nil {
+		t.Fatalf("Failed to get service exceptions: %v", err)
+	}
+	if len(all) != 1 {
+		t.Fatalf("Expected 1 service exception after reopening, got %d", len(all))
+	}
+
+	// Check that removing the exception restores service
+	err = fixture.RemoveServiceException(serviceID, date)
+	if err != nil {
+		t.Fatalf("Failed to remove service exception: %v", err)
+	}
+	departures, err = fixture.GetStopDepartures(stop, date, 4*time.Hour)
+	if err != nil || len(departures) == 0 {
+		t.Fatalf("Expected departures after removing the closure, got %d (%v)", len(departures), err)
+	}
+}
+
+func TestGetStopRouteSummaries(t *testing.T) {
+	feed := gtfstest.NewFeed(gtfstest.Options{TripsPerRoute: 8})
+
+	// Outbound trips of the second route start from the first route's first stop too
+	stop := gtfstest.StopID(0, 0)
+	for i := 0; i < 8; i += 2 {
+		feed.Trips[gtfstest.TripID(1, i)].Stops[0].StopID = stop
+	}
+	fixture := &gtfs.GTFS{}
+	err := fixture.FromFeed(feed, filepath.Join(t.TempDir(), "gtfs.db"), gtfs.IngestOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer fixture.Close()
+
+	perth, err := time.LoadLocation("Australia/Perth")
+	if err != nil {
+		t.Fatalf("Failed to load timezone: %v", err)
+	}
+	at := time.Date(2030, 6, 3, 6, 30, 0, 0, perth)
+	summaries, err := fixture.GetStopRouteSummaries(stop, at, 2)
+	if err != nil {
+		t.Fatalf("Failed to get stop route summaries: %v", err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("Expected 2 routes serving the stop, got %d", len(summaries))
+	}
+	for i, summary := range summaries {
+		if summary.Route.ID != gtfstest.RouteID(i) {
+			t.Errorf("Expected route %s at %d, got %s", gtfstest.RouteID(i), i, summary.Route.ID)
+		}
+		if len(summary.Departures) != 2 {
+			t.Fatalf("Expected 2 departures for route %s, got %d", summary.Route.ID, len(summary.Departures))
+		}
+		if want := time.Date(2030, 6, 3, 7, 0, 0, 0, perth); !summary.Departures[0].Time.Equal(want) {
+			t.Errorf("Expected next departure at %v, got %v", want, summary.Departures[0].Time)
+		}
+		if summary.Headway != time.Hour {
+			t.Errorf("Expected hourly headway for route %s, got %v", summary.Route.ID, summary.Headway)
+		}
+	}
+
+	// Late at night the next departures are the following morning's
+	summaries, err = fixture.GetStopRouteSummaries(stop, time.Date(2030, 6, 3, 23, 0, 0, 0, perth), 1)
+	if err != nil {
+		t.Fatalf("Failed to get stop route summaries: %v", err)
+	}
+	if want := time.Date(2030, 6, 4, 6, 0, 0, 0, perth); len(summaries[0].Departures) != 1 || !summaries[0].Departures[0].Time.Equal(want) {
+		t.Fatalf("Expected next departure at %v, got %v", want, summaries[0].Departures)
+	}
+	if summaries[0].Headway != 0 {
+		t.Errorf("Expected unknown headway with no nearby departures, got %v", summaries[0].Headway)
+	}
+}
+
+func TestAlerts(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "gtfs.db")
+	fixture := &gtfs.GTFS{}
+	err := fixture.FromFeed(gtfstest.NewFeed(gtfstest.Options{}), dbFile, gtfs.IngestOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+
+	start := time.Date(2030, 6, 3, 6, 0, 0, 0, time.UTC)
+	route := gtfstest.RouteID(0)
+	stop := gtfstest.StopID(0, 0)
+	err = fixture.PutAlerts(
+		&gtfs.Alert{
+			ID:            "works",
+			Header:        "Track works",
+			RouteIDs:      []gtfs.Key{route},
+			ActivePeriods: []gtfs.AlertPeriod{{Start: start, End: start.Add(2 * time.Hour)}},
+		},
+		&gtfs.Alert{
+			ID:      "lift",
+			Header:  "Lift out of service",
+			StopIDs: []gtfs.Key{stop},
+		},
+	)
+	if err != nil {
+		t.Fatalf("Failed to put alerts: %v", err)
+	}
+
+	if alerts := fixture.GetAlertsByRouteID(route, start.Add(time.Hour)); len(alerts) != 1 || alerts[0].ID != "works" {
+		t.Fatalf("Expected the route's alert during its active period, got %v", alerts)
+	}
+	if alerts := fixture.GetAlertsByRouteID(route, start.Add(3*time.Hour)); len(alerts) != 0 {
+		t.Fatalf("Expected no route alerts after the active period, got %v", alerts)
+	}
+	if alerts := fixture.GetAlertsByStopID(stop, start.Add(3*time.Hour)); len(alerts) != 1 || alerts[0].ID != "lift" {
+		t.Fatalf("Expected the stop's alert, got %v", alerts)
+	}
+	if alerts := fixture.GetActiveAlerts(start); len(alerts) != 2 {
+		t.Fatalf("Expected 2 active alerts, got %d", len(alerts))
+	}
+
+	// Check that alerts persist when the database is reopened, and expired ones can be pruned
+	fixture.Close()
+	fixture = &gtfs.GTFS{}
+	err = fixture.FromDB(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer fixture.Close()
+	alert, err := fixture.GetAlertByID("works")
+	if err != nil || alert.Header != "Track works" || len(alert.ActivePeriods) != 1 {
+		t.Fatalf("Expected alert after reopening, got %v (%v)", alert, err)
+	}
+	pruned, err := fixture.PruneAlerts(start.Add(3 * time.Hour))
+	if err != nil || pruned != 1 {
+		t.Fatalf("Expected 1 pruned alert, got %d (%v)", pruned, err)
+	}
+
+	// Replacing the alerts removes those not given
+	err = fixture.ReplaceAlerts([]*gtfs.Alert{{ID: "strike", Header: "Industrial action", RouteIDs: []gtfs.Key{route}}})
+	if err != nil {
+		t.Fatalf("Failed to replace alerts: %v", err)
+	}
+	if _, err := fixture.GetAlertByID("lift"); err == nil {
+		t.Fatal("Expected replaced alert to be removed")
+	}
+	if alerts := fixture.GetActiveAlerts(start); len(alerts) != 1 || alerts[0].ID != "strike" {
+		t.Fatalf("Expected only the new alert, got %v", alerts)
+	}
+}
+
+func TestGetRouteStats(t *testing.T) {
+	weekdays := gtfstest.Calendar{
+		Weekdays:  gtfs.MondayWeekdayFlag | gtfs.TuesdayWeekdayFlag | gtfs.WednesdayWeekdayFlag | gtfs.ThursdayWeekdayFlag | gtfs.FridayWeekdayFlag,
+		StartDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC),
+	}
+	weekends := weekdays
+	weekends.Weekdays = gtfs.SaturdayWeekdayFlag | gtfs.SundayWeekdayFlag
+	fixture := gtfstest.New(t, gtfstest.Options{Calendars: []gtfstest.Calendar{weekdays, weekends}})
+
+	stats, err := fixture.GetRouteStats(gtfstest.RouteID(0))
+	if err != nil {
+		t.Fatalf("Failed to get route stats: %v", err)
+	}
+	if stats.Trips != 4 || stats.Patterns != 2 || stats.Stops != 5 {
+		t.Fatalf("Expected 4 trips, 2 patterns and 5 stops, got %d, %d and %d", stats.Trips, stats.Patterns, stats.Stops)
+	}
+	if stats.TripsByWeekday[time.Monday] != 2 || stats.TripsByWeekday[time.Saturday] != 2 {
+		t.Fatalf("Expected 2 trips on Mondays and Saturdays, got %v", stats.TripsByWeekday)
+	}
+	if stats.MeanDuration != 20*time.Minute {
+		t.Errorf("Expected mean duration of 20 minutes, got %v", stats.MeanDuration)
+	}
+
+	// Trips start every 30 minutes from 06:00, taking 20 minutes each
+	if stats.FirstDeparture != 6*3600 || stats.LastArrival != 7*3600+50*60 {
+		t.Errorf("Expected service from 06:00 to 07:50, got %d to %d", stats.FirstDeparture, stats.LastArrival)
+	}
+
+	if _, err := fixture.GetRouteStats("MISSING"); err == nil {
+		t.Error("Expected an error for a missing route")
+	}
+}
+
+func TestSpatialStopQueries(t *testing.T) {
+	for _, project := range []bool{false, true} {
+		fixture := &gtfs.GTFS{}
+		err := fixture.FromFeed(gtfstest.NewFeed(gtfstest.Options{}), filepath.Join(t.TempDir(), "gtfs.db"), gtfs.IngestOptions{ProjectStops: project})
+		if err != nil {
+			t.Fatalf("Failed to create database: %v", err)
+		}
+		defer fixture.Close()
+
+		// The first route's stops run south from its first stop, with the second route's stops to the east
+		polygon := []gtfs.Coordinate{
+			gtfs.NewCoordinate(-31.945, 115.855),
+			gtfs.NewCoordinate(-31.945, 115.865),
+			gtfs.NewCoordinate(-31.961, 115.865),
+			gtfs.NewCoordinate(-31.961, 115.855),
+		}
+		stops, err := fixture.GetStopsInPolygon(polygon)
+		if err != nil {
+			t.Fatalf("Failed to get stops in polygon: %v", err)
+		}
+		if len(stops) != 3 || stops[0].ID != gtfstest.StopID(0, 0) || stops[2].ID != gtfstest.StopID(0, 2) {
+			t.Fatalf("Expected the first 3 stops of the first route (projected: %v), got %v", project, stops)
+		}
+
+		// Check that stops added by overrides are found, although they have no projected coordinates
+		err = fixture.OverrideStop(&gtfs.Stop{ID: "X1", Name: "Added", Location: gtfs.NewCoordinate(-31.95, 115.86)})
+		if err != nil {
+			t.Fatalf("Failed to override stop: %v", err)
+		}
+		stops, err = fixture.GetStopsInPolygon(polygon)
+		if err != nil || len(stops) != 4 {
+			t.Fatalf("Expected the added stop in the polygon (projected: %v), got %v (%v)", project, stops, err)
+		}
+		err = fixture.ResetOverrides()
+		if err != nil {
+			t.Fatalf("Failed to reset overrides: %v", err)
+		}
+
+		corridor, err := fixture.GetStopsAlongShape(gtfstest.ShapeID(0, gtfs.OutboundTripDirection), 100)
+		if err != nil {
+			t.Fatalf("Failed to get stops along shape: %v", err)
+		}
+		if len(corridor) != 5 {
+			t.Fatalf("Expected the route's 5 stops along its shape (projected: %v), got %d", project, len(corridor))
+		}
+		for i, stop := range corridor {
+			if stop.Stop.ID != gtfstest.StopID(0, i) || stop.Distance > 1 {
+				t.Fatalf("Expected stop %s on the shape at %d, got %s %.1fm away", gtfstest.StopID(0, i), i, stop.Stop.ID, stop.Distance)
+			}
+		}
+		if corridor[4].Along < 2000 || corridor[4].Along > 2500 {
+			t.Errorf("Expected the last stop about 2.2km along the shape, got %.0fm", corridor[4].Along)
+		}
+
+		// A wider buffer takes in the second route's stops
+		corridor, err = fixture.GetStopsAlongShape(gtfstest.ShapeID(0, gtfs.OutboundTripDirection), 1000)
+		if err != nil || len(corridor) != 10 {
+			t.Fatalf("Expected 10 stops within 1km of the shape, got %d (%v)", len(corridor), err)
+		}
+	}
+}
+
+func TestTripCrowding(t *testing.T) {
+	feed := gtfstest.NewFeed(gtfstest.Options{})
+	crowded := feed.Trips[gtfstest.TripID(0, 0)]
+	crowded.Capacity = 50
+	for i, stop := range crowded.Stops {
+		occupancy := uint(10 * (i + 1))
+		stop.Occupancy = &occupancy
+	}
+	quiet := feed.Trips[gtfstest.TripID(0, 1)]
+	quiet.Capacity = 50
+	for _, stop := range quiet.Stops {
+		occupancy := uint(0)
+		stop.Occupancy = &occupancy
+	}
+
+	for _, enc := range []gtfs.Encoding{gtfs.BinaryEncoding, gtfs.ProtobufEncoding} {
+		fixture := &gtfs.GTFS{}
+		err := fixture.FromFeed(feed, filepath.Join(t.TempDir(), "gtfs.db"), gtfs.IngestOptions{Encoding: enc})
+		if err != nil {
+			t.Fatalf("Failed to create %s database: %v", enc, err)
+		}
+		defer fixture.Close()
+
+		// Occupancy and capacity are stored, including an empty vehicle
+		trip, err := fixture.GetTripByID(crowded.ID)
+		if err != nil {
+			t.Fatalf("Failed to get trip: %v", err)
+		}
+		if load, ok := trip.PeakLoad(); !ok || load != 1 {
+			t.Fatalf("Expected %s peak load 1, got %v (%t)", enc, load, ok)
+		}
+		trip, err = fixture.GetTripByID(quiet.ID)
+		if err != nil {
+			t.Fatalf("Failed to get trip: %v", err)
+		}
+		if peak, ok := trip.PeakOccupancy(); !ok || peak != 0 {
+			t.Fatalf("Expected %s peak occupancy 0, got %d (%t)", enc, peak, ok)
+		}
+		trip, err = fixture.GetTripByID(gtfstest.TripID(0, 2))
+		if err != nil {
+			t.Fatalf("Failed to get trip: %v", err)
+		}
+		if _, ok := trip.PeakOccupancy(); ok || trip.Capacity != 0 {
+			t.Fatalf("Expected %s trip without occupancy data, got %+v", enc, trip)
+		}
+
+		// Only the crowded trip is excluded, as trips without data are kept
+		trips, err := fixture.FindTrips(gtfs.WithRoute(gtfstest.RouteID(0)), gtfs.WithMaxLoad(0.8))
+		if err != nil {
+			t.Fatalf("Failed to find trips: %v", err)
+		}
+		if _, ok := trips[crowded.ID]; ok || len(trips) != 3 {
+			t.Fatalf("Expected 3 %s trips below the load, got %d", enc, len(trips))
+		}
+		trips, err = fixture.FindTrips(gtfs.WithMaxOccupancy(30))
+		if err != nil {
+			t.Fatalf("Failed to find trips: %v", err)
+		}
+		if _, ok := trips[crowded.ID]; ok || len(trips) != len(feed.Trips)-1 {
+			t.Fatalf("Expected %d %s trips below the occupancy, got %d", len(feed.Trips)-1, enc, len(trips))
+		}
+	}
+}
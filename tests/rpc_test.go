@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/aaroncutress/gtfs-go"
+	"github.com/aaroncutress/gtfs-go/gtfstest"
+	"github.com/aaroncutress/gtfs-go/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Start an in-process gRPC server for the database and return a client connected to it
+func newRPCClient(t *testing.T) *rpc.Client {
+	s := grpc.NewServer(rpc.ServerOptions()...)
+	rpc.RegisterGTFSServer(s, rpc.NewServer(g))
+	return rpc.NewClient(serveRPC(t, s))
+}
+
+// Start serving the gRPC server in process and return a connection to it
+func serveRPC(t *testing.T, s *grpc.Server) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	cc, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return cc
+}
+
+func TestRPCGetTrip(t *testing.T) {
+	client := newRPCClient(t)
+
+	resp, err := client.GetTrip(context.Background(), &rpc.IDRequest{ID: tripID})
+	if err != nil {
+		t.Fatalf("Failed to get trip: %v", err)
+	}
+
+	// Check that the trip matches the one in the database
+	expected, err := g.GetTripByID(tripID)
+	if err != nil {
+		t.Fatalf("Failed to get trip from database: %v", err)
+	}
+	if resp.Trip.ID != expected.ID || resp.Trip.RouteID != expected.RouteID {
+		t.Fatalf("Expected trip %s on route %s, got %s on route %s", expected.ID, expected.RouteID, resp.Trip.ID, resp.Trip.RouteID)
+	}
+	if len(resp.Trip.Stops) != len(expected.Stops) {
+		t.Fatalf("Expected %d stops, got %d", len(expected.Stops), len(resp.Trip.Stops))
+	}
+}
+
+func TestRPCNotFound(t *testing.T) {
+	client := newRPCClient(t)
+
+	_, err := client.GetStop(context.Background(), &rpc.IDRequest{ID: "does-not-exist"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected NotFound, got %v", err)
+	}
+}
+
+func TestRPCSearch(t *testing.T) {
+	client := newRPCClient(t)
+
+	stop, err := g.GetStopByID(stopID)
+	if err != nil {
+		t.Fatalf("Failed to get stop: %v", err)
+	}
+
+	// Search for the stop by name
+	resp, err := client.Search(context.Background(), &rpc.SearchRequest{Query: stop.Name})
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	for _, result := range resp.Results {
+		if result.Type == gtfs.StopSearchResultType && result.ID == stopID {
+			return
+		}
+	}
+	t.Fatalf("Expected stop %s in search results", stopID)
+}
+
+func TestRPCSharedServer(t *testing.T) {
+	fixture := gtfstest.New(t, gtfstest.Options{})
+
+	// Other services on a server with the GTFS service's options use the standard codec
+	s := grpc.NewServer(rpc.ServerOptions()...)
+	rpc.RegisterGTFSServer(s, rpc.NewServer(fixture))
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
+	cc := serveRPC(t, s)
+
+	check, err := healthpb.NewHealthClient(cc).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Failed to check health: %v", err)
+	}
+	if check.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("Expected the server to be serving, got %s", check.Status)
+	}
+	resp, err := rpc.NewClient(cc).GetRoute(context.Background(), &rpc.IDRequest{ID: gtfstest.RouteID(0)})
+	if err != nil || resp.Route.ID != gtfstest.RouteID(0) {
+		t.Fatalf("Expected route %s, got %v (%v)", gtfstest.RouteID(0), resp, err)
+	}
+
+	// The client's requests are understood by servers without the options too
+	plain := grpc.NewServer()
+	rpc.RegisterGTFSServer(plain, rpc.NewServer(fixture))
+	resp, err = rpc.NewClient(serveRPC(t, plain)).GetRoute(context.Background(), &rpc.IDRequest{ID: gtfstest.RouteID(0)})
+	if err != nil || resp.Route.ID != gtfstest.RouteID(0) {
+		t.Fatalf("Expected route %s from a server without the options, got %v (%v)", gtfstest.RouteID(0), resp, err)
+	}
+}
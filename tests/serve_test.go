@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aaroncutress/gtfs-go"
+	"github.com/aaroncutress/gtfs-go/serve"
+)
+
+func TestServeStop(t *testing.T) {
+	handler := serve.NewHandler(g)
+
+	// Request the stop
+	req := httptest.NewRequest(http.MethodGet, "/stops/"+stopID, nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	// Check that the response is the stop
+	var stop gtfs.Stop
+	err := json.Unmarshal(rec.Body.Bytes(), &stop)
+	if err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if stop.ID != stopID {
+		t.Fatalf("Expected stop ID %s, got %s", stopID, stop.ID)
+	}
+}
+
+func TestServeNotFound(t *testing.T) {
+	handler := serve.NewHandler(g)
+
+	// Request a stop which does not exist
+	req := httptest.NewRequest(http.MethodGet, "/stops/does-not-exist", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestServeDepartures(t *testing.T) {
+	handler := serve.NewHandler(g)
+
+	// Request the departures from the stop over the next day
+	req := httptest.NewRequest(http.MethodGet, "/stops/"+stopID+"/departures?window=24h", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	var departures []gtfs.Departure
+	err := json.Unmarshal(rec.Body.Bytes(), &departures)
+	if err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	t.Logf("Number of departures: %d", len(departures))
+}
@@ -15,4 +15,5 @@ const (
 
 	routeName = "Yanchep Line"
 	stopName  = "Glendalough Stn"
+	stopAlias = "Glendalough Interchange"
 )
@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aaroncutress/gtfs-go/gtfstest"
+	"github.com/aaroncutress/gtfs-go/tiles"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Returns the number of features in each layer of an encoded vector tile
+func countTileFeatures(t *testing.T, data []byte) map[string]int {
+	t.Helper()
+
+	// Call fn with each field of the message
+	fields := func(data []byte, fn func(num protowire.Number, value []byte)) {
+		for len(data) > 0 {
+			num, typ, n := protowire.ConsumeTag(data)
+			if n < 0 {
+				t.Fatalf("Invalid tag in tile: %v", protowire.ParseError(n))
+			}
+			data = data[n:]
+			var value []byte
+			if typ == protowire.BytesType {
+				value, n = protowire.ConsumeBytes(data)
+			} else {
+				n = protowire.ConsumeFieldValue(num, typ, data)
+			}
+			if n < 0 {
+				t.Fatalf("Invalid field %d in tile: %v", num, protowire.ParseError(n))
+			}
+			data = data[n:]
+			fn(num, value)
+		}
+	}
+
+	counts := make(map[string]int)
+	fields(data, func(num protowire.Number, layer []byte) {
+		if num != 3 {
+			return
+		}
+		var name string
+		features := 0
+		fields(layer, func(num protowire.Number, value []byte) {
+			switch num {
+			case 1:
+				name = string(value)
+			case 2:
+				features++
+			}
+		})
+		counts[name] = features
+	})
+	return counts
+}
+
+func TestVectorTiles(t *testing.T) {
+	fixture := gtfstest.New(t, gtfstest.Options{})
+	gen, err := tiles.NewGenerator(fixture, tiles.Options{})
+	if err != nil {
+		t.Fatalf("Failed to create tile generator: %v", err)
+	}
+
+	// The tile containing the first stop of the first route, which spans about 2 kilometres at zoom 14
+	const z = 14
+	n := math.Exp2(z)
+	lat := -31.95 * math.Pi / 180
+	x := uint32((115.86 + 180) / 360 * n)
+	y := uint32((1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * n)
+
+	tile, err := gen.Tile(z, x, y)
+	if err != nil {
+		t.Fatalf("Failed to generate tile: %v", err)
+	}
+	counts := countTileFeatures(t, tile)
+	if counts[tiles.StopsLayer] == 0 || counts[tiles.RoutesLayer] == 0 {
+		t.Fatalf("Expected stops and routes in tile %d/%d/%d, got %v", z, x, y, counts)
+	}
+
+	// Tiles spanning many cells of the generator's index include every stop within them
+	const wide = 6
+	data, err := gen.Tile(wide, x>>(z-wide), y>>(z-wide))
+	if err != nil {
+		t.Fatalf("Failed to generate tile: %v", err)
+	}
+	counts = countTileFeatures(t, data)
+	if counts[tiles.StopsLayer] != 10 {
+		t.Fatalf("Expected all stops at zoom %d, got %v", wide, counts)
+	}
+
+	// Routes shorter than a pixel are left out at low zoom levels
+	data, err = gen.Tile(0, 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to generate tile: %v", err)
+	}
+	counts = countTileFeatures(t, data)
+	if counts[tiles.StopsLayer] != 10 || counts[tiles.RoutesLayer] != 0 {
+		t.Fatalf("Expected only the stops at zoom 0, got %v", counts)
+	}
+
+	// Tiles far from the feed are empty
+	data, err = gen.Tile(z, 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to generate tile: %v", err)
+	}
+	if len(data) != 0 {
+		t.Fatalf("Expected an empty tile, got %v", countTileFeatures(t, data))
+	}
+
+	_, err = gen.Tile(1, 2, 0)
+	if err == nil {
+		t.Fatal("Expected an error for a tile out of range")
+	}
+
+	// Tiles are served at /{z}/{x}/{y}.mvt
+	rec := httptest.NewRecorder()
+	gen.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/%d/%d/%d.mvt", z, x, y), nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/vnd.mapbox-vector-tile" {
+		t.Fatalf("Expected a vector tile response, got status %d", rec.Code)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), tile) {
+		t.Fatal("Expected the served tile to match the generated tile")
+	}
+
+	rec = httptest.NewRecorder()
+	gen.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/0/0", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected status 404 for an invalid path, got %d", rec.Code)
+	}
+}
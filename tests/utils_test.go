@@ -1,9 +1,23 @@
 package tests
 
 import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/aaroncutress/gtfs-go"
+	"github.com/aaroncutress/gtfs-go/gtfstest"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 // Tests getting all current trips from the GTFS database
@@ -28,3 +42,1448 @@ func TestGetCurrentTrips(t *testing.T) {
 
 	t.Logf("Number of current trips: %d", len(trips))
 }
+
+// Tests that current trips found through the trip start index match those found by checking every trip
+func TestGetAllCurrentTripsAt(t *testing.T) {
+	// Trips every 30 minutes from 06:00 run until after midnight
+	fixture := gtfstest.New(t, gtfstest.Options{TripsPerRoute: 40})
+	trips, err := fixture.GetAllTrips()
+	if err != nil {
+		t.Fatalf("Failed to get all trips: %v", err)
+	}
+
+	perth, _ := time.LoadLocation("Australia/Perth")
+	found := 0
+	for at := time.Date(2025, 6, 2, 0, 0, 0, 0, perth); at.Before(time.Date(2025, 6, 3, 0, 0, 0, 0, perth)); at = at.Add(7 * time.Minute) {
+		expected, err := fixture.GetCurrentTripsAt(trips, at)
+		if err != nil {
+			t.Fatalf("Failed to get current trips: %v", err)
+		}
+		current, err := fixture.GetAllCurrentTripsAt(at)
+		if err != nil {
+			t.Fatalf("Failed to get all current trips: %v", err)
+		}
+		if len(current) != len(expected) {
+			t.Fatalf("Expected %d current trips at %s, got %d", len(expected), at.Format("15:04"), len(current))
+		}
+		for id := range expected {
+			if _, ok := current[id]; !ok {
+				t.Fatalf("Expected trip %s to be current at %s", id, at.Format("15:04"))
+			}
+		}
+		found += len(current)
+	}
+	if found == 0 {
+		t.Fatal("Expected current trips during the day")
+	}
+}
+
+// Tests matching a route, direction and departure time to a scheduled trip
+func TestFindTrip(t *testing.T) {
+	// Trips every 30 minutes from 06:00 alternate between outbound and inbound, and run past midnight
+	fixture := gtfstest.New(t, gtfstest.Options{TripsPerRoute: 40})
+	perth, _ := time.LoadLocation("Australia/Perth")
+	routeID := gtfstest.RouteID(0)
+
+	trip, err := fixture.FindTrip(routeID, gtfs.OutboundTripDirection, time.Date(2025, 6, 2, 7, 4, 0, 0, perth), 5*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to find trip: %v", err)
+	}
+	if trip.ID != gtfstest.TripID(0, 2) {
+		t.Fatalf("Expected trip %s, got %s", gtfstest.TripID(0, 2), trip.ID)
+	}
+
+	trip, err = fixture.FindTrip(routeID, gtfs.InboundTripDirection, time.Date(2025, 6, 2, 6, 28, 0, 0, perth), 5*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to find trip: %v", err)
+	}
+	if trip.ID != gtfstest.TripID(0, 1) {
+		t.Fatalf("Expected trip %s, got %s", gtfstest.TripID(0, 1), trip.ID)
+	}
+
+	// The last trip starts at 25:30 on the previous service day
+	trip, err = fixture.FindTrip(routeID, gtfs.InboundTripDirection, time.Date(2025, 6, 3, 1, 31, 0, 0, perth), 5*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to find trip: %v", err)
+	}
+	if trip.ID != gtfstest.TripID(0, 39) {
+		t.Fatalf("Expected trip %s, got %s", gtfstest.TripID(0, 39), trip.ID)
+	}
+
+	// Outbound trips start on the hour, so none is within a minute of a quarter past
+	_, err = fixture.FindTrip(routeID, gtfs.OutboundTripDirection, time.Date(2025, 6, 2, 7, 15, 0, 0, perth), time.Minute)
+	if err == nil {
+		t.Fatal("Expected no trip to be found")
+	}
+}
+
+// Tests computing the stops reachable from a stop within a duration
+func TestIsochrone(t *testing.T) {
+	// Get the stops reachable within 30 minutes from now
+	reachable, err := g.Isochrone(stopID, time.Now(), 30*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to compute isochrone: %v", err)
+	}
+
+	// Check if the origin stop is reachable immediately
+	found := false
+	for _, stop := range reachable {
+		if stop.StopID == stopID {
+			found = stop.TravelTime == 0
+			break
+		}
+	}
+	if !found {
+		t.Fatalf("Expected origin stop %s to be reachable immediately", stopID)
+	}
+
+	t.Logf("Number of reachable stops: %d", len(reachable))
+}
+
+// Realtime source which cancels every trip
+type cancelAllSource struct {
+	trips gtfs.TripMap
+}
+
+func (s *cancelAllSource) GetTripUpdates() (map[gtfs.Key]*gtfs.TripUpdate, error) {
+	updates := make(map[gtfs.Key]*gtfs.TripUpdate, len(s.trips))
+	for tripID := range s.trips {
+		updates[tripID] = &gtfs.TripUpdate{TripID: tripID, Canceled: true}
+	}
+	return updates, nil
+}
+
+func (s *cancelAllSource) GetVehiclePositions() (map[gtfs.Key]*gtfs.VehiclePosition, error) {
+	return map[gtfs.Key]*gtfs.VehiclePosition{}, nil
+}
+
+// Tests that cancellations from a realtime source are applied to current trips
+func TestRealtimeCancellations(t *testing.T) {
+	// Use a snapshot so the source is not attached to the shared instance
+	snapshot, err := g.Snapshot()
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	defer snapshot.Close()
+
+	trips, err := snapshot.GetTripsByRouteID(routeID)
+	if err != nil {
+		t.Fatalf("Failed to get trips by route ID: %v", err)
+	}
+	snapshot.AttachRealtimeSource(&cancelAllSource{trips: trips})
+
+	// Check that no trips are running at any time of day
+	currentTrips, err := snapshot.GetCurrentTripsWithBuffer(trips, time.Now(), 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to get current trips: %v", err)
+	}
+	if len(currentTrips) != 0 {
+		t.Fatalf("Expected no current trips, got %d", len(currentTrips))
+	}
+}
+
+// Tests converting a trip's stop times to concrete times on a service date
+func TestStopTimesOn(t *testing.T) {
+	trip, err := g.GetTripByID(tripID)
+	if err != nil {
+		t.Fatalf("Failed to get trip by ID: %v", err)
+	}
+	agency, err := g.GetAgencyByID(agencyID)
+	if err != nil {
+		t.Fatalf("Failed to get agency by ID: %v", err)
+	}
+	loc, err := time.LoadLocation(agency.Timezone)
+	if err != nil {
+		t.Fatalf("Failed to load timezone: %v", err)
+	}
+	date, err := time.ParseInLocation("2006-01-02", serviceDate, loc)
+	if err != nil {
+		t.Fatalf("Failed to parse service date: %v", err)
+	}
+
+	// Check that each time is the stop's offset from the start of the service day
+	times := trip.StopTimesOn(date, loc)
+	if len(times) != len(trip.Stops) {
+		t.Fatalf("Expected %d stop times, got %d", len(trip.Stops), len(times))
+	}
+	for i, stop := range trip.Stops {
+		offset := times[i].Sub(date)
+		if offset != time.Duration(stop.ArrivalTime)*time.Second {
+			t.Fatalf("Expected stop %d at offset %ds, got %v", i, stop.ArrivalTime, offset)
+		}
+	}
+
+	t.Logf("Trip starts at %v", times[0])
+}
+
+// Tests resolving the start and end instants of a trip running past midnight
+func TestTripStartTimeOn(t *testing.T) {
+	fixture := gtfstest.New(t, gtfstest.Options{FirstTrip: 23*time.Hour + 50*time.Minute})
+	trip, err := fixture.GetTripByID(gtfstest.TripID(0, 0))
+	if err != nil {
+		t.Fatalf("Failed to get trip by ID: %v", err)
+	}
+	perth, _ := time.LoadLocation("Australia/Perth")
+	date := time.Date(2025, 6, 2, 0, 0, 0, 0, perth)
+
+	start := trip.StartTimeOn(date, perth)
+	if !start.Equal(time.Date(2025, 6, 2, 23, 50, 0, 0, perth)) {
+		t.Fatalf("Expected trip to start at 23:50 on 2 June, got %v", start)
+	}
+	end := trip.EndTimeOn(date, perth)
+	if !end.Equal(time.Date(2025, 6, 3, 0, 10, 0, 0, perth)) {
+		t.Fatalf("Expected trip to end at 00:10 on 3 June, got %v", end)
+	}
+}
+
+func TestZonesTraversed(t *testing.T) {
+	trip, err := g.GetTripByID(tripID)
+	if err != nil {
+		t.Fatalf("Failed to get trip by ID: %v", err)
+	}
+
+	zones, err := trip.ZonesTraversed(g)
+	if err != nil {
+		t.Fatalf("Failed to get zones traversed: %v", err)
+	}
+	if len(zones) == 0 {
+		t.Fatal("Expected trip to traverse at least one zone")
+	}
+
+	// Check that consecutive zones are distinct
+	for i := 1; i < len(zones); i++ {
+		if zones[i] == zones[i-1] {
+			t.Fatalf("Zone %s repeated consecutively at index %d", zones[i], i)
+		}
+	}
+
+	t.Logf("Trip %s traverses zones %v", tripID, zones)
+}
+
+// Tests finding the routes serving two stops in order
+func TestGetDirectRoutesBetween(t *testing.T) {
+	trip, err := g.GetTripByID(tripID)
+	if err != nil {
+		t.Fatalf("Failed to get trip by ID: %v", err)
+	}
+	if len(trip.Stops) < 2 {
+		t.Skip("Trip has fewer than two stops")
+	}
+
+	// Get the routes between the first and last stops of the trip
+	fromStopID := trip.Stops[0].StopID
+	toStopID := trip.Stops[len(trip.Stops)-1].StopID
+	directRoutes, err := g.GetDirectRoutesBetween(fromStopID, toStopID)
+	if err != nil {
+		t.Fatalf("Failed to get direct routes: %v", err)
+	}
+
+	// Check that the trip's route is included
+	found := false
+	for _, directRoute := range directRoutes {
+		if directRoute.Route.ID == trip.RouteID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Fatalf("Expected route %s between %s and %s", trip.RouteID, fromStopID, toStopID)
+	}
+
+	t.Logf("Number of direct routes: %d", len(directRoutes))
+}
+
+// Tests finding direct routes on a feed without shapes, including a pair served by a route only on one of its trips
+func TestGetDirectRoutesWithoutShapes(t *testing.T) {
+	// The third trip of the first route branches to the middle stop of the second route
+	feed := gtfstest.NewFeed(gtfstest.Options{})
+	feed.Trips[gtfstest.TripID(0, 2)].Stops[2].StopID = gtfstest.StopID(1, 2)
+	fixture := newShapelessFixture(t, feed)
+
+	tests := []struct {
+		from, to gtfs.Key
+		routeIDs []gtfs.Key
+		tripID   gtfs.Key
+	}{
+		{gtfstest.StopID(0, 0), gtfstest.StopID(0, 4), []gtfs.Key{gtfstest.RouteID(0)}, gtfstest.TripID(0, 0)},
+		{gtfstest.StopID(0, 0), gtfstest.StopID(1, 2), []gtfs.Key{gtfstest.RouteID(0)}, gtfstest.TripID(0, 2)},
+		{gtfstest.StopID(1, 2), gtfstest.StopID(0, 4), []gtfs.Key{gtfstest.RouteID(0)}, gtfstest.TripID(0, 2)},
+		{gtfstest.StopID(0, 4), gtfstest.StopID(1, 2), nil, ""},
+	}
+	for _, test := range tests {
+		directRoutes, err := fixture.GetDirectRoutesBetween(test.from, test.to)
+		if err != nil {
+			t.Fatalf("Failed to get direct routes: %v", err)
+		}
+		var routeIDs []gtfs.Key
+		for _, directRoute := range directRoutes {
+			routeIDs = append(routeIDs, directRoute.Route.ID)
+		}
+		if !slices.Equal(routeIDs, test.routeIDs) {
+			t.Fatalf("Expected routes %v between %s and %s, got %v", test.routeIDs, test.from, test.to, routeIDs)
+		}
+		if len(directRoutes) > 0 && directRoutes[0].Trip.ID != test.tripID {
+			t.Fatalf("Expected trip %s between %s and %s, got %s", test.tripID, test.from, test.to, directRoutes[0].Trip.ID)
+		}
+	}
+}
+
+func TestExpandTrip(t *testing.T) {
+	trip, err := g.GetTripByID(tripID)
+	if err != nil {
+		t.Fatalf("Failed to get trip by ID: %v", err)
+	}
+	service, err := g.GetServiceByID(trip.ServiceID)
+	if err != nil {
+		t.Fatalf("Failed to get service by ID: %v", err)
+	}
+
+	// Expand the trip over the first two weeks of its service
+	from := service.StartDate
+	to := from.AddDate(0, 0, 13)
+	dates, err := g.ExpandTrip(tripID, from, to)
+	if err != nil {
+		t.Fatalf("Failed to expand trip: %v", err)
+	}
+	if len(dates) == 0 {
+		t.Fatal("Expected the trip to operate within two weeks of its service starting")
+	}
+
+	// Check that the dates are in order and within the range
+	for i, date := range dates {
+		if date.Before(from.AddDate(0, 0, -1)) || date.After(to) {
+			t.Fatalf("Date %v is outside the range", date)
+		}
+		if i > 0 && !date.After(dates[i-1]) {
+			t.Fatalf("Dates are not in order: %v then %v", dates[i-1], date)
+		}
+	}
+
+	t.Logf("Trip %s operates on %d dates", tripID, len(dates))
+}
+
+func TestIngestReport(t *testing.T) {
+	// Check that the core files were parsed and counted
+	for _, file := range []string{"agency.txt", "routes.txt", "stops.txt", "trips.txt", "stop_times.txt"} {
+		report, ok := ingestReport.Files[file]
+		if !ok {
+			t.Fatalf("Expected a report for %s", file)
+		}
+		if report.Rows == 0 {
+			t.Fatalf("Expected rows in %s", file)
+		}
+	}
+
+	// Check that the download, parse and populate phases were timed, in order
+	phases := []string{}
+	for _, phase := range ingestReport.Phases {
+		phases = append(phases, phase.Phase)
+	}
+	if len(phases) < 3 || phases[0] != "download" || phases[1] != "parse" || phases[len(phases)-1] != "populate" {
+		t.Fatalf("Unexpected ingest phases: %v", phases)
+	}
+
+	if ingestReport.DBSize <= 0 {
+		t.Fatalf("Expected a positive database size, got %d", ingestReport.DBSize)
+	}
+
+	t.Logf("Ingested %d rows (%d skipped, %d warnings) in %v, database is %d bytes",
+		ingestReport.Rows(), ingestReport.SkippedRows(), ingestReport.WarningCount(), ingestReport.Duration(), ingestReport.DBSize)
+}
+
+func TestStopDensityGrid(t *testing.T) {
+	grid, err := g.StopDensityGrid(1000)
+	if err != nil {
+		t.Fatalf("Failed to get stop density grid: %v", err)
+	}
+	if len(grid.Cells) == 0 {
+		t.Fatal("Expected non-empty cells")
+	}
+
+	// Check that every located stop is counted in exactly one cell
+	stops, err := g.GetAllStops()
+	if err != nil {
+		t.Fatalf("Failed to get all stops: %v", err)
+	}
+	located := 0
+	for _, stop := range stops {
+		if !stop.Location.IsZero() && stop.Location.IsValid() {
+			located++
+		}
+	}
+	counted := 0
+	for _, cell := range grid.Cells {
+		counted += cell.Stops
+	}
+	if counted != located {
+		t.Fatalf("Expected %d stops in the grid, got %d", located, counted)
+	}
+
+	// Check that weighting by today's departures keeps the same cells
+	weighted, err := g.StopDepartureDensityGrid(1000, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to get weighted stop density grid: %v", err)
+	}
+	if len(weighted.Cells) != len(grid.Cells) {
+		t.Fatalf("Expected %d weighted cells, got %d", len(grid.Cells), len(weighted.Cells))
+	}
+	departures := 0
+	for _, cell := range weighted.Cells {
+		departures += cell.Departures
+	}
+	if departures == 0 {
+		t.Fatal("Expected departures today")
+	}
+
+	// Check both export formats
+	data, err := weighted.GeoJSON()
+	if err != nil {
+		t.Fatalf("Failed to export GeoJSON: %v", err)
+	}
+	var fc struct {
+		Features []json.RawMessage `json:"features"`
+	}
+	err = json.Unmarshal(data, &fc)
+	if err != nil {
+		t.Fatalf("Failed to decode GeoJSON: %v", err)
+	}
+	if len(fc.Features) != len(weighted.Cells) {
+		t.Fatalf("Expected %d features, got %d", len(weighted.Cells), len(fc.Features))
+	}
+
+	var buf bytes.Buffer
+	err = weighted.WriteCSV(&buf)
+	if err != nil {
+		t.Fatalf("Failed to write CSV: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read CSV: %v", err)
+	}
+	if len(records) != len(weighted.Cells)+1 {
+		t.Fatalf("Expected %d CSV rows, got %d", len(weighted.Cells)+1, len(records))
+	}
+
+	t.Logf("%d stops in %d cells, with %d departures today", counted, len(grid.Cells), departures)
+}
+
+func TestResolveServiceTime(t *testing.T) {
+	loc, err := time.LoadLocation("Australia/Sydney")
+	if err != nil {
+		t.Fatalf("Failed to load timezone: %v", err)
+	}
+
+	// Clocks go forward from 02:00 to 03:00 on 5 October 2025, and back from 03:00 to 02:00 on 6 April 2025
+	cases := []struct {
+		date    time.Time
+		seconds uint
+		want    time.Time
+	}{
+		{time.Date(2025, 10, 5, 0, 0, 0, 0, loc), 8 * 3600, time.Date(2025, 10, 5, 8, 0, 0, 0, loc)},
+		{time.Date(2025, 4, 6, 0, 0, 0, 0, loc), 8 * 3600, time.Date(2025, 4, 6, 8, 0, 0, 0, loc)},
+		{time.Date(2025, 10, 4, 0, 0, 0, 0, loc), 25 * 3600, time.Date(2025, 10, 5, 1, 0, 0, 0, loc)},
+		{time.Date(2025, 6, 2, 0, 0, 0, 0, loc), 26 * 3600, time.Date(2025, 6, 3, 2, 0, 0, 0, loc)},
+	}
+	for _, c := range cases {
+		got := gtfs.ResolveServiceTime(c.date, c.seconds, loc)
+		if !got.Equal(c.want) {
+			t.Errorf("Expected %ds on %s to resolve to %v, got %v", c.seconds, c.date.Format("2006-01-02"), c.want, got.In(loc))
+		}
+	}
+}
+
+func TestPrepareDepartures(t *testing.T) {
+	fixture := gtfstest.New(t, gtfstest.Options{
+		Calendars: []gtfstest.Calendar{{
+			Weekdays:  gtfs.MondayWeekdayFlag | gtfs.TuesdayWeekdayFlag | gtfs.WednesdayWeekdayFlag | gtfs.ThursdayWeekdayFlag | gtfs.FridayWeekdayFlag,
+			StartDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC),
+		}},
+	})
+	prepared, err := fixture.PrepareDepartures(gtfstest.StopID(0, 0))
+	if err != nil {
+		t.Fatalf("Failed to prepare departures: %v", err)
+	}
+
+	// Evaluate the prepared query on a weekday and on a weekend
+	loc, err := time.LoadLocation("Australia/Perth")
+	if err != nil {
+		t.Fatalf("Failed to load timezone: %v", err)
+	}
+	tests := []struct {
+		from     time.Time
+		expected int
+	}{
+		{time.Date(2025, 6, 2, 6, 0, 0, 0, loc), 2}, // Monday
+		{time.Date(2025, 6, 3, 7, 0, 0, 0, loc), 1}, // Tuesday
+		{time.Date(2025, 6, 7, 6, 0, 0, 0, loc), 0}, // Saturday
+	}
+	for _, test := range tests {
+		departures, err := prepared.Departures(test.from, 2*time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to evaluate prepared departures: %v", err)
+		}
+		if len(departures) != test.expected {
+			t.Fatalf("Expected %d departures from %s, got %d", test.expected, test.from, len(departures))
+		}
+		if len(departures) > 0 && !departures[0].Time.Equal(test.from) {
+			t.Fatalf("Expected first departure at %s, got %s", test.from, departures[0].Time)
+		}
+	}
+
+	// Exceptions added after preparing are taken into account, until they are removed
+	monday := time.Date(2025, 6, 2, 6, 0, 0, 0, loc)
+	err = fixture.AddServiceException(gtfstest.ServiceID(0), monday, gtfs.RemovedExceptionType)
+	if err != nil {
+		t.Fatalf("Failed to add service exception: %v", err)
+	}
+	departures, err := prepared.Departures(monday, 2*time.Hour)
+	if err != nil || len(departures) != 0 {
+		t.Fatalf("Expected no departures with the service removed, got %d (%v)", len(departures), err)
+	}
+	err = fixture.RemoveServiceException(gtfstest.ServiceID(0), monday)
+	if err != nil {
+		t.Fatalf("Failed to remove service exception: %v", err)
+	}
+	departures, err = prepared.Departures(monday, 2*time.Hour)
+	if err != nil || len(departures) != 2 {
+		t.Fatalf("Expected 2 departures with the exception removed, got %d (%v)", len(departures), err)
+	}
+}
+
+// Creates a database from the feed with its shapes removed, so that none of its routes have stops
+// derived from their shapes
+func newShapelessFixture(t *testing.T, feed *gtfs.Feed) *gtfs.GTFS {
+	feed.Shapes = gtfs.ShapeMap{}
+	for _, trip := range feed.Trips {
+		trip.ShapeID = ""
+	}
+	fixture := &gtfs.GTFS{}
+	err := fixture.FromFeed(feed, filepath.Join(t.TempDir(), "gtfs.db"), gtfs.IngestOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	t.Cleanup(func() {
+		fixture.Close()
+	})
+	return fixture
+}
+
+// Tests getting departures from stops of a feed without shapes, including a stop served by a route only on
+// one of its trips
+func TestGetStopDeparturesWithoutShapes(t *testing.T) {
+	// The third trip of the first route branches to the middle stop of the second route
+	feed := gtfstest.NewFeed(gtfstest.Options{})
+	feed.Trips[gtfstest.TripID(0, 2)].Stops[2].StopID = gtfstest.StopID(1, 2)
+	fixture := newShapelessFixture(t, feed)
+
+	loc, err := time.LoadLocation("Australia/Perth")
+	if err != nil {
+		t.Fatalf("Failed to load timezone: %v", err)
+	}
+	monday := time.Date(2025, 6, 2, 6, 0, 0, 0, loc)
+	departures, err := fixture.GetStopDepartures(gtfstest.StopID(0, 0), monday, 2*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to get departures: %v", err)
+	}
+	if len(departures) != 2 {
+		t.Fatalf("Expected 2 departures without shapes, got %d", len(departures))
+	}
+
+	departures, err = fixture.GetStopDepartures(gtfstest.StopID(1, 2), monday, 2*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to get departures: %v", err)
+	}
+	if len(departures) != 5 || !slices.ContainsFunc(departures, func(d gtfs.Departure) bool {
+		return d.TripID == gtfstest.TripID(0, 2) && d.Time.Equal(monday.Add(70*time.Minute))
+	}) {
+		t.Fatalf("Expected 5 departures including the branching trip, got %v", departures)
+	}
+}
+
+func TestPrepareDeparturesExceptions(t *testing.T) {
+	weekdays := gtfstest.Calendar{
+		Weekdays:  gtfs.MondayWeekdayFlag | gtfs.TuesdayWeekdayFlag | gtfs.WednesdayWeekdayFlag | gtfs.ThursdayWeekdayFlag | gtfs.FridayWeekdayFlag,
+		StartDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC),
+	}
+	feed := gtfstest.NewFeed(gtfstest.Options{Calendars: []gtfstest.Calendar{weekdays, weekdays, weekdays}})
+
+	// The first service does not run on Monday 2 June, while the third runs on Saturday 7 June
+	monday := time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)
+	saturday := time.Date(2025, 6, 7, 0, 0, 0, 0, time.UTC)
+	feed.ServiceExceptions[gtfs.ServiceExceptionKey{ServiceID: gtfstest.ServiceID(0), Date: monday}] = &gtfs.ServiceException{
+		ServiceID: gtfstest.ServiceID(0),
+		Date:      monday,
+		Type:      gtfs.RemovedExceptionType,
+	}
+	feed.ServiceExceptions[gtfs.ServiceExceptionKey{ServiceID: gtfstest.ServiceID(2), Date: saturday}] = &gtfs.ServiceException{
+		ServiceID: gtfstest.ServiceID(2),
+		Date:      saturday,
+		Type:      gtfs.AddedExceptionType,
+	}
+	fixture := &gtfs.GTFS{}
+	err := fixture.FromFeed(feed, filepath.Join(t.TempDir(), "gtfs.db"), gtfs.IngestOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer fixture.Close()
+
+	prepared, err := fixture.PrepareDepartures(gtfstest.StopID(0, 0))
+	if err != nil {
+		t.Fatalf("Failed to prepare departures: %v", err)
+	}
+
+	// Trips take the services in turn, and those of the first and third services depart the stop at 06:00 and 07:00
+	loc, err := time.LoadLocation("Australia/Perth")
+	if err != nil {
+		t.Fatalf("Failed to load timezone: %v", err)
+	}
+	tests := []struct {
+		from     time.Time
+		expected int
+	}{
+		{time.Date(2025, 6, 2, 6, 0, 0, 0, loc), 1}, // Monday, third service only
+		{time.Date(2025, 6, 3, 6, 0, 0, 0, loc), 2}, // Tuesday
+		{time.Date(2025, 6, 7, 6, 0, 0, 0, loc), 1}, // Saturday, third service only
+	}
+	for _, test := range tests {
+		departures, err := prepared.Departures(test.from, 2*time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to evaluate prepared departures: %v", err)
+		}
+		if len(departures) != test.expected {
+			t.Fatalf("Expected %d departures from %s, got %d", test.expected, test.from, len(departures))
+		}
+		for _, departure := range departures {
+			trip, err := fixture.GetTripByID(departure.TripID)
+			if err != nil {
+				t.Fatalf("Failed to get trip: %v", err)
+			}
+			if test.expected == 1 && trip.ServiceID != gtfstest.ServiceID(2) {
+				t.Fatalf("Expected only trips of service %s on %s, got %s", gtfstest.ServiceID(2), test.from, trip.ID)
+			}
+		}
+	}
+}
+
+// Tests preparing departures from stops of a feed without shapes, including a stop served by a route only
+// on one of its trips
+func TestPrepareDeparturesWithoutShapes(t *testing.T) {
+	// The third trip of the first route branches to the middle stop of the second route
+	feed := gtfstest.NewFeed(gtfstest.Options{})
+	feed.Trips[gtfstest.TripID(0, 2)].Stops[2].StopID = gtfstest.StopID(1, 2)
+	fixture := newShapelessFixture(t, feed)
+
+	loc, err := time.LoadLocation("Australia/Perth")
+	if err != nil {
+		t.Fatalf("Failed to load timezone: %v", err)
+	}
+	monday := time.Date(2025, 6, 2, 6, 0, 0, 0, loc)
+	tests := []struct {
+		stopID   gtfs.Key
+		expected int
+	}{
+		{gtfstest.StopID(0, 0), 2},
+		{gtfstest.StopID(0, 2), 3}, // Skipped by the branching trip
+		{gtfstest.StopID(1, 2), 5},
+	}
+	for _, test := range tests {
+		prepared, err := fixture.PrepareDepartures(test.stopID)
+		if err != nil {
+			t.Fatalf("Failed to prepare departures: %v", err)
+		}
+		departures, err := prepared.Departures(monday, 2*time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to evaluate prepared departures: %v", err)
+		}
+		if len(departures) != test.expected {
+			t.Fatalf("Expected %d departures from %s, got %d", test.expected, test.stopID, len(departures))
+		}
+	}
+}
+
+func TestSubscribeDepartures(t *testing.T) {
+	// The first trip departs a few seconds from now, and the next is outside the window
+	loc, err := time.LoadLocation("Australia/Perth")
+	if err != nil {
+		t.Fatalf("Failed to load timezone: %v", err)
+	}
+	now := time.Now().In(loc)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	fixture := gtfstest.New(t, gtfstest.Options{FirstTrip: now.Sub(midnight).Truncate(time.Second) + 2*time.Second})
+
+	events, unsubscribe, err := fixture.SubscribeDepartures(gtfstest.StopID(0, 0), 10*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to subscribe to departures: %v", err)
+	}
+	defer unsubscribe()
+
+	// The departure is added straight away, then removed once it leaves
+	expected := []gtfs.DepartureEventType{gtfs.DepartureAddedEventType, gtfs.DepartureRemovedEventType}
+	for _, eventType := range expected {
+		select {
+		case event := <-events:
+			if event.Type != eventType || event.Departure.TripID != gtfstest.TripID(0, 0) {
+				t.Fatalf("Expected %s event for trip %s, got %s for %s", eventType, gtfstest.TripID(0, 0), event.Type, event.Departure.TripID)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for %s event", eventType)
+		}
+	}
+
+	// The channel is closed once unsubscribed
+	unsubscribe()
+	select {
+	case event, ok := <-events:
+		if ok {
+			t.Fatalf("Expected no more events, got %s", event.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the channel to be closed")
+	}
+}
+
+func TestApplyServiceChanges(t *testing.T) {
+	fixture := gtfstest.New(t, gtfstest.Options{
+		Calendars: []gtfstest.Calendar{{
+			Weekdays:  gtfs.MondayWeekdayFlag | gtfs.TuesdayWeekdayFlag | gtfs.WednesdayWeekdayFlag | gtfs.ThursdayWeekdayFlag | gtfs.FridayWeekdayFlag,
+			StartDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC),
+		}},
+	})
+
+	// Cancel the first trip on Monday and run it on Saturday
+	changes := "trip_id,date,action\n" +
+		string(gtfstest.TripID(0, 0)) + ",2025-06-02,cancel\n" +
+		string(gtfstest.TripID(0, 0)) + ",20250607,add\n"
+	err := fixture.ApplyServiceChanges(strings.NewReader(changes))
+	if err != nil {
+		t.Fatalf("Failed to apply service changes: %v", err)
+	}
+
+	loc, err := time.LoadLocation("Australia/Perth")
+	if err != nil {
+		t.Fatalf("Failed to load timezone: %v", err)
+	}
+	tests := []struct {
+		from     time.Time
+		expected int
+	}{
+		{time.Date(2025, 6, 2, 6, 0, 0, 0, loc), 1}, // Monday, with the first trip cancelled
+		{time.Date(2025, 6, 3, 6, 0, 0, 0, loc), 2}, // Tuesday, unchanged
+		{time.Date(2025, 6, 7, 6, 0, 0, 0, loc), 1}, // Saturday, with the first trip added
+	}
+	for _, test := range tests {
+		departures, err := fixture.GetStopDepartures(gtfstest.StopID(0, 0), test.from, 2*time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to get departures: %v", err)
+		}
+		if len(departures) != test.expected {
+			t.Fatalf("Expected %d departures from %s, got %d", test.expected, test.from, len(departures))
+		}
+	}
+
+	// Clearing the changes restores the schedule
+	fixture.ClearServiceChanges()
+	departures, err := fixture.GetStopDepartures(gtfstest.StopID(0, 0), tests[0].from, 2*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to get departures: %v", err)
+	}
+	if len(departures) != 2 {
+		t.Fatalf("Expected 2 departures after clearing changes, got %d", len(departures))
+	}
+
+	err = fixture.ApplyServiceChanges(strings.NewReader("trip_id,date,action\nR1-T1,2025-06-02,delay\n"))
+	if err == nil {
+		t.Fatal("Expected invalid action to fail")
+	}
+}
+
+// Decodes a struct encoded with the Thrift compact protocol, as used by Parquet metadata, into its fields
+// keyed by ID. Integers are decoded as int64s, strings as strings, lists as slices and structs as maps.
+func decodeThriftStruct(t *testing.T, r *bytes.Reader) map[int16]any {
+	fields := make(map[int16]any)
+	var id int16
+	for {
+		header, err := r.ReadByte()
+		if err != nil {
+			t.Fatalf("Failed to read Thrift field header: %v", err)
+		}
+		if header == 0 {
+			return fields
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := binary.ReadVarint(r)
+			if err != nil {
+				t.Fatalf("Failed to read Thrift field ID: %v", err)
+			}
+			id = int16(v)
+		}
+		fields[id] = decodeThriftValue(t, r, header&0x0f)
+	}
+}
+
+// Decodes a value of the given Thrift compact protocol type
+func decodeThriftValue(t *testing.T, r *bytes.Reader, typ byte) any {
+	switch typ {
+	case 1, 2: // Boolean fields hold their value in the type
+		return typ == 1
+	case 5, 6:
+		v, err := binary.ReadVarint(r)
+		if err != nil {
+			t.Fatalf("Failed to read Thrift integer: %v", err)
+		}
+		return v
+	case 8:
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			t.Fatalf("Failed to read Thrift string length: %v", err)
+		}
+		buf := make([]byte, n)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			t.Fatalf("Failed to read Thrift string: %v", err)
+		}
+		return string(buf)
+	case 9:
+		header, err := r.ReadByte()
+		if err != nil {
+			t.Fatalf("Failed to read Thrift list header: %v", err)
+		}
+		size := uint64(header >> 4)
+		if size == 15 {
+			size, err = binary.ReadUvarint(r)
+			if err != nil {
+				t.Fatalf("Failed to read Thrift list size: %v", err)
+			}
+		}
+		list := make([]any, size)
+		for i := range list {
+			list[i] = decodeThriftValue(t, r, header&0x0f)
+		}
+		return list
+	case 12:
+		return decodeThriftStruct(t, r)
+	default:
+		t.Fatalf("Unexpected Thrift type %d", typ)
+		return nil
+	}
+}
+
+// Returns the column names and the number of rows in each row group of a Parquet file, read from its footer
+func readParquetFooter(t *testing.T, path string) ([]string, []int64) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	if len(data) < 12 || string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		t.Fatalf("Expected %s to be framed by the Parquet magic bytes", path)
+	}
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if size > len(data)-12 {
+		t.Fatalf("Footer of %s is larger than the file", path)
+	}
+	meta := decodeThriftStruct(t, bytes.NewReader(data[len(data)-8-size:len(data)-8]))
+
+	// The schema is a root element followed by each column
+	schema := meta[2].([]any)
+	root := schema[0].(map[int16]any)
+	if root[5].(int64) != int64(len(schema)-1) {
+		t.Fatalf("Expected the schema root of %s to have %d children, got %d", path, len(schema)-1, root[5])
+	}
+	columns := make([]string, len(schema)-1)
+	for i, element := range schema[1:] {
+		columns[i] = element.(map[int16]any)[4].(string)
+	}
+
+	var total int64
+	var groups []int64
+	for _, group := range meta[4].([]any) {
+		rows := group.(map[int16]any)[3].(int64)
+		groups = append(groups, rows)
+		total += rows
+	}
+	if meta[3].(int64) != total {
+		t.Fatalf("Expected %s to have %d rows across its row groups, got %d", path, total, meta[3])
+	}
+	return columns, groups
+}
+
+func TestExportParquet(t *testing.T) {
+	dir := t.TempDir()
+	err := g.ExportParquet(dir)
+	if err != nil {
+		t.Fatalf("Failed to export Parquet files: %v", err)
+	}
+
+	stops, err := g.GetAllStops()
+	if err != nil {
+		t.Fatalf("Failed to get stops: %v", err)
+	}
+	routes, err := g.GetAllRoutes()
+	if err != nil {
+		t.Fatalf("Failed to get routes: %v", err)
+	}
+	trips, err := g.GetAllTrips()
+	if err != nil {
+		t.Fatalf("Failed to get trips: %v", err)
+	}
+	shapes, err := g.GetAllShapes()
+	if err != nil {
+		t.Fatalf("Failed to get shapes: %v", err)
+	}
+	stopTimes := 0
+	for _, trip := range trips {
+		stopTimes += len(trip.Stops)
+	}
+	points := 0
+	for _, shape := range shapes {
+		points += len(shape.Coordinates)
+	}
+
+	// Check each file's schema, and that its rows are split into row groups of at most 100000
+	tests := []struct {
+		name    string
+		columns []string
+		rows    int
+	}{
+		{"stops", []string{"stop_id", "stop_code", "stop_name", "stop_desc", "stop_lat", "stop_lon", "zone_id", "stop_url", "location_type", "parent_station", "platform_code", "wheelchair_boarding", "supported_modes"}, len(stops)},
+		{"routes", []string{"route_id", "agency_id", "route_short_name", "route_type", "route_color"}, len(routes)},
+		{"trips", []string{"trip_id", "route_id", "service_id", "trip_headsign", "direction_id", "shape_id"}, len(trips)},
+		{"stop_times", []string{"trip_id", "arrival_time", "departure_time", "stop_id", "stop_sequence", "timepoint"}, stopTimes},
+		{"shapes", []string{"shape_id", "shape_pt_lat", "shape_pt_lon", "shape_pt_sequence"}, points},
+	}
+	for _, test := range tests {
+		columns, groups := readParquetFooter(t, filepath.Join(dir, test.name+".parquet"))
+		if !slices.Equal(columns, test.columns) {
+			t.Fatalf("Expected %s.parquet to have columns %v, got %v", test.name, test.columns, columns)
+		}
+		total := 0
+		for i, rows := range groups {
+			if rows > 100000 || (i < len(groups)-1 && rows != 100000) {
+				t.Fatalf("Unexpected row group sizes in %s.parquet: %v", test.name, groups)
+			}
+			total += int(rows)
+		}
+		if total != test.rows {
+			t.Fatalf("Expected %s.parquet to have %d rows, got %d", test.name, test.rows, total)
+		}
+	}
+}
+
+func TestExportSQL(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "gtfs.sqlite"))
+	if err != nil {
+		t.Fatalf("Failed to open SQLite database: %v", err)
+	}
+	defer db.Close()
+
+	err = g.ExportSQL(db)
+	if err != nil {
+		t.Fatalf("Failed to export to SQL: %v", err)
+	}
+
+	// Check that the tables can be joined
+	var count int
+	err = db.QueryRow(`SELECT COUNT(*) FROM stop_times
+		JOIN trips ON trips.trip_id = stop_times.trip_id
+		WHERE stop_times.stop_id = $1 AND trips.route_id = $2`, stopID, routeID).Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to query exported stop times: %v", err)
+	}
+	if count == 0 {
+		t.Fatalf("Expected stop times for stop %s on route %s", stopID, routeID)
+	}
+
+	// Exporting again replaces the tables
+	err = g.ExportSQL(db)
+	if err != nil {
+		t.Fatalf("Failed to export to SQL again: %v", err)
+	}
+}
+
+// Tests subscribing from several goroutines at once, including while the database is refreshed
+func TestSubscribeConcurrently(t *testing.T) {
+	fixture := gtfstest.New(t, gtfstest.Options{})
+
+	// Subscribers added concurrently before the refresh all receive its changes
+	const count = 8
+	channels := make([]<-chan gtfs.ChangeEvent, 2*count)
+	unsubscribes := make([]func(), 2*count)
+	var wg sync.WaitGroup
+	subscribe := func(i int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			channels[i], unsubscribes[i] = fixture.Subscribe()
+		}()
+	}
+	for i := range count {
+		subscribe(i)
+	}
+	wg.Wait()
+
+	// Others subscribe while the refresh is in progress
+	for i := count; i < 2*count; i++ {
+		subscribe(i)
+	}
+	err := fixture.RefreshFromFeed(gtfstest.NewFeed(gtfstest.Options{Routes: 3}), gtfs.IngestOptions{})
+	wg.Wait()
+	for _, unsubscribe := range unsubscribes {
+		defer unsubscribe()
+	}
+	if err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+
+	for i, events := range channels[:count] {
+		if len(events) == 0 {
+			t.Fatalf("Expected subscriber %d to receive the changes", i)
+		}
+	}
+}
+
+// Tests refreshing a database and receiving the change events
+func TestRefreshChangeEvents(t *testing.T) {
+	fixture := gtfstest.New(t, gtfstest.Options{})
+	events, unsubscribe := fixture.Subscribe()
+	defer unsubscribe()
+
+	// Add a route, remove a trip and move a stop by about 111 metres
+	feed := gtfstest.NewFeed(gtfstest.Options{Routes: 3})
+	delete(feed.Trips, gtfstest.TripID(0, 0))
+	feed.Stops[gtfstest.StopID(0, 0)].Location.Latitude += 0.001
+
+	err := fixture.RefreshFromFeed(feed, gtfs.IngestOptions{})
+	if err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+
+	received := make(map[gtfs.ChangeType]gtfs.ChangeEvent)
+	for len(events) > 0 {
+		event := <-events
+		received[event.Type] = event
+	}
+
+	if ids := received[gtfs.RoutesAddedChangeType].IDs; len(ids) != 1 || ids[0] != gtfstest.RouteID(2) {
+		t.Fatalf("Expected route %s to be added, got %v", gtfstest.RouteID(2), ids)
+	}
+	if ids := received[gtfs.TripsRemovedChangeType].IDs; len(ids) != 1 || ids[0] != gtfstest.TripID(0, 0) {
+		t.Fatalf("Expected trip %s to be removed, got %v", gtfstest.TripID(0, 0), ids)
+	}
+	moved := received[gtfs.StopMovedChangeType]
+	if len(moved.IDs) != 1 || moved.IDs[0] != gtfstest.StopID(0, 0) || moved.Distance < 100 || moved.Distance > 120 {
+		t.Fatalf("Expected stop %s to move about 111 metres, got %v", gtfstest.StopID(0, 0), moved)
+	}
+
+	// Check that queries see the new database
+	_, err = fixture.GetRouteByID(gtfstest.RouteID(2))
+	if err != nil {
+		t.Fatalf("Failed to get added route: %v", err)
+	}
+
+	// Overrides are not reported as changes to the ingested data
+	stop, err := fixture.GetStopByID(gtfstest.StopID(0, 1))
+	if err != nil {
+		t.Fatalf("Failed to get stop: %v", err)
+	}
+	renamed := *stop
+	renamed.Name = "Renamed"
+	err = fixture.OverrideStop(&renamed)
+	if err != nil {
+		t.Fatalf("Failed to override stop: %v", err)
+	}
+	err = fixture.RefreshFromFeed(feed, gtfs.IngestOptions{})
+	if err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+	if len(events) > 0 {
+		t.Fatalf("Expected no changes refreshing the same feed, got %v", <-events)
+	}
+
+	// A failed refresh leaves the current database in use. The new database cannot be built where a
+	// directory is in the way.
+	dbFile := filepath.Join(t.TempDir(), "gtfs.db")
+	current := &gtfs.GTFS{}
+	err = current.FromFeed(gtfstest.NewFeed(gtfstest.Options{}), dbFile, gtfs.IngestOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer current.Close()
+	err = os.MkdirAll(filepath.Join(dbFile+".next", "blocked"), 0755)
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := current.RefreshFromFeed(feed, gtfs.IngestOptions{}); err == nil {
+		t.Fatal("Expected an error refreshing into a directory")
+	}
+	if _, err := current.GetRouteByID(gtfstest.RouteID(0)); err != nil {
+		t.Fatalf("Expected the current database to be usable after a failed refresh: %v", err)
+	}
+
+	// The current database is archived while it is still open
+	os.RemoveAll(dbFile + ".next")
+	err = current.RefreshFromFeed(feed, gtfs.IngestOptions{ArchiveVersions: 1})
+	if err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+	versions, err := current.GetArchivedVersions()
+	if err != nil || len(versions) != 1 {
+		t.Fatalf("Expected 1 archived version, got %v (%v)", versions, err)
+	}
+	if _, err := current.GetRouteByID(gtfstest.RouteID(2)); err != nil {
+		t.Fatalf("Failed to get added route: %v", err)
+	}
+}
+
+// Tests estimating the wait for a route from its headways
+func TestEstimateWaitTime(t *testing.T) {
+	fixture := gtfstest.New(t, gtfstest.Options{})
+	perth, _ := time.LoadLocation("Australia/Perth")
+
+	// The middle stop of the first route has a departure every 30 minutes from 06:10 to 07:40
+	estimate, err := fixture.EstimateWaitTime(gtfstest.StopID(0, 2), gtfstest.RouteID(0), time.Date(2025, 6, 2, 6, 55, 0, 0, perth))
+	if err != nil {
+		t.Fatalf("Failed to estimate wait time: %v", err)
+	}
+	if estimate.Headway != 30*time.Minute || estimate.Wait != 15*time.Minute {
+		t.Fatalf("Expected a 30 minute headway and 15 minute wait, got %v and %v", estimate.Headway, estimate.Wait)
+	}
+
+	// Check that there is no estimate late at night
+	_, err = fixture.EstimateWaitTime(gtfstest.StopID(0, 2), gtfstest.RouteID(0), time.Date(2025, 6, 2, 23, 0, 0, 0, perth))
+	if err == nil {
+		t.Fatal("Expected error estimating wait time without departures")
+	}
+}
+
+// Tests rendering the weekly timetable of a stop
+func TestGetStopTimetable(t *testing.T) {
+	fixture := gtfstest.New(t, gtfstest.Options{
+		Calendars: []gtfstest.Calendar{{
+			Weekdays:  gtfs.MondayWeekdayFlag | gtfs.TuesdayWeekdayFlag | gtfs.WednesdayWeekdayFlag | gtfs.ThursdayWeekdayFlag | gtfs.FridayWeekdayFlag,
+			StartDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC),
+		}},
+	})
+	perth, _ := time.LoadLocation("Australia/Perth")
+
+	timetable, err := fixture.GetStopTimetable(gtfstest.StopID(0, 2), time.Date(2025, 6, 2, 0, 0, 0, 0, perth))
+	if err != nil {
+		t.Fatalf("Failed to get stop timetable: %v", err)
+	}
+
+	// The weekdays share a single row, with departures at 10 and 40 minutes past 06:00 and 07:00
+	if len(timetable.Rows) != 1 {
+		t.Fatalf("Expected 1 timetable row, got %d", len(timetable.Rows))
+	}
+	row := timetable.Rows[0]
+	if row.DaysLabel() != "Mon-Fri" || timetable.FirstHour != 6 || timetable.LastHour != 7 {
+		t.Fatalf("Expected Mon-Fri departures from 06:00 to 07:00, got %s from %d to %d", row.DaysLabel(), timetable.FirstHour, timetable.LastHour)
+	}
+
+	var buf bytes.Buffer
+	err = timetable.WriteCSV(&buf)
+	if err != nil {
+		t.Fatalf("Failed to write timetable CSV: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read timetable CSV: %v", err)
+	}
+	if len(records) != 2 || records[1][5] != "10 40" || records[1][6] != "10 40" {
+		t.Fatalf("Expected departures at 10 and 40 minutes past each hour, got %v", records)
+	}
+
+	buf.Reset()
+	err = timetable.WriteHTML(&buf)
+	if err != nil {
+		t.Fatalf("Failed to write timetable HTML: %v", err)
+	}
+	if !strings.Contains(buf.String(), "<td>Mon-Fri</td>") {
+		t.Fatalf("Expected Mon-Fri row in timetable HTML, got %s", buf.String())
+	}
+}
+
+// Tests locating a vehicle's progress along a trip from its position
+func TestLocateTripProgress(t *testing.T) {
+	fixture := gtfstest.New(t, gtfstest.Options{})
+	perth, _ := time.LoadLocation("Australia/Perth")
+
+	// Observe the first trip of the first route halfway between its second and third stops, slightly off its path
+	from, err := fixture.GetStopByID(gtfstest.StopID(0, 1))
+	if err != nil {
+		t.Fatalf("Failed to get stop: %v", err)
+	}
+	to, err := fixture.GetStopByID(gtfstest.StopID(0, 2))
+	if err != nil {
+		t.Fatalf("Failed to get stop: %v", err)
+	}
+	pos := gtfs.Coordinate{
+		Latitude:  (from.Location.Latitude + to.Location.Latitude) / 2,
+		Longitude: (from.Location.Longitude+to.Location.Longitude)/2 + 0.0001,
+	}
+
+	progress, err := fixture.LocateTripProgress(gtfstest.TripID(0, 0), pos)
+	if err != nil {
+		t.Fatalf("Failed to locate trip progress: %v", err)
+	}
+	if progress.PreviousStopIndex != 1 || progress.NextStopIndex != 2 {
+		t.Fatalf("Expected vehicle between stops 1 and 2, got %d and %d", progress.PreviousStopIndex, progress.NextStopIndex)
+	}
+	if progress.OffPath < 5 || progress.OffPath > 15 {
+		t.Fatalf("Expected vehicle about 10 metres off its path, got %f", progress.OffPath)
+	}
+
+	// The trip departs the second stop at 06:05 and reaches the third at 06:10
+	if progress.ScheduledTime != 6*3600+7*60+30 {
+		t.Fatalf("Expected scheduled time of 06:07:30, got %d", progress.ScheduledTime)
+	}
+	deviation := progress.Deviation(time.Date(2025, 6, 2, 6, 9, 30, 0, perth))
+	if deviation != 2*time.Minute {
+		t.Fatalf("Expected vehicle 2 minutes late, got %v", deviation)
+	}
+}
+
+// Tests materialising the days on which a service runs
+func TestServiceActiveDates(t *testing.T) {
+	perth, _ := time.LoadLocation("Australia/Perth")
+	service := &gtfs.Service{
+		ID:        "C1",
+		Weekdays:  gtfs.MondayWeekdayFlag | gtfs.TuesdayWeekdayFlag | gtfs.WednesdayWeekdayFlag | gtfs.ThursdayWeekdayFlag | gtfs.FridayWeekdayFlag,
+		StartDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC),
+	}
+
+	// Remove Monday 2 June and add Saturday 7 June
+	exceptions := []*gtfs.ServiceException{
+		{ServiceID: "C1", Date: time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC), Type: gtfs.RemovedExceptionType},
+		{ServiceID: "C1", Date: time.Date(2025, 6, 7, 0, 0, 0, 0, time.UTC), Type: gtfs.AddedExceptionType},
+	}
+	days := service.ActiveDates(time.Date(2025, 6, 1, 0, 0, 0, 0, perth), time.Date(2025, 6, 8, 0, 0, 0, 0, perth), exceptions...)
+
+	dates := []string{}
+	for _, date := range days.Dates() {
+		dates = append(dates, date.Format("2006-01-02"))
+	}
+	expected := []string{"2025-06-03", "2025-06-04", "2025-06-05", "2025-06-06", "2025-06-07"}
+	if !slices.Equal(dates, expected) {
+		t.Fatalf("Expected active dates %v, got %v", expected, dates)
+	}
+	if days.Contains(time.Date(2025, 6, 2, 9, 0, 0, 0, perth)) || !days.Contains(time.Date(2025, 6, 3, 9, 0, 0, 0, perth)) {
+		t.Fatal("Expected service removed on 2 June and running on 3 June")
+	}
+	if days.Contains(time.Date(2025, 7, 1, 9, 0, 0, 0, perth)) {
+		t.Fatal("Expected dates outside the range not to be active")
+	}
+
+	// Check that the cached days of a database's service match its schedule
+	fixture := gtfstest.New(t, gtfstest.Options{Calendars: []gtfstest.Calendar{{
+		Weekdays:  service.Weekdays,
+		StartDate: service.StartDate,
+		EndDate:   service.EndDate,
+	}}})
+	serviceDays, err := fixture.GetServiceDays(gtfstest.ServiceID(0))
+	if err != nil {
+		t.Fatalf("Failed to get service days: %v", err)
+	}
+	if !serviceDays.Contains(time.Date(2025, 6, 2, 9, 0, 0, 0, perth)) || serviceDays.Contains(time.Date(2025, 6, 7, 9, 0, 0, 0, perth)) {
+		t.Fatal("Expected service running on Monday 2 June and not on Saturday 7 June")
+	}
+}
+
+// Tests decoding raw bucket values in parallel with each encoding
+func TestDecodeTrips(t *testing.T) {
+	feed := gtfstest.NewFeed(gtfstest.Options{Routes: 3, TripsPerRoute: 5})
+
+	for _, enc := range []gtfs.Encoding{gtfs.BinaryEncoding, gtfs.ProtobufEncoding} {
+		raw := make(map[gtfs.Key][]byte, len(feed.Trips))
+		for id, trip := range feed.Trips {
+			if enc == gtfs.ProtobufEncoding {
+				raw[id] = trip.EncodeProto()
+			} else {
+				raw[id] = trip.Encode()
+			}
+		}
+
+		trips, err := gtfs.DecodeTrips(raw, 4, enc)
+		if err != nil {
+			t.Fatalf("Failed to decode %s trips: %v", enc, err)
+		}
+		if len(trips) != len(feed.Trips) {
+			t.Fatalf("Expected %d %s trips, got %d", len(feed.Trips), enc, len(trips))
+		}
+		for id, trip := range trips {
+			if trip.ID != id || !bytes.Equal(trip.Encode(), feed.Trips[id].Encode()) {
+				t.Fatalf("Expected %s trip %s to round trip", enc, id)
+			}
+		}
+	}
+
+	_, err := gtfs.DecodeTrips(map[gtfs.Key][]byte{"bad": {0xff}}, 0, gtfs.BinaryEncoding)
+	if err == nil {
+		t.Fatal("Expected an error decoding an invalid trip")
+	}
+}
+
+// Tests the validity window of a feed and its expiry warnings
+func TestFeedValidity(t *testing.T) {
+	fixture := gtfstest.New(t, gtfstest.Options{Calendars: []gtfstest.Calendar{
+		{Weekdays: gtfs.MondayWeekdayFlag, StartDate: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC)},
+		{Weekdays: gtfs.SundayWeekdayFlag, StartDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2025, 5, 31, 0, 0, 0, 0, time.UTC)},
+	}})
+
+	validity, err := fixture.FeedValidity()
+	if err != nil {
+		t.Fatalf("Failed to get feed validity: %v", err)
+	}
+	if validity.Start.Format("2006-01-02") != "2025-01-01" || validity.End.Format("2006-01-02") != "2025-06-30" {
+		t.Fatalf("Expected validity from 2025-01-01 to 2025-06-30, got %v to %v", validity.Start, validity.End)
+	}
+	if validity.Services != 2 {
+		t.Fatalf("Expected 2 services, got %d", validity.Services)
+	}
+
+	perth, _ := time.LoadLocation("Australia/Perth")
+	if warnings := validity.Warnings(time.Date(2025, 6, 1, 9, 0, 0, 0, perth), 14); len(warnings) != 0 {
+		t.Fatalf("Expected no warnings a month before expiry, got %v", warnings)
+	}
+	if warnings := validity.Warnings(time.Date(2025, 6, 25, 9, 0, 0, 0, perth), 14); len(warnings) != 1 || validity.DaysRemaining(time.Date(2025, 6, 25, 9, 0, 0, 0, perth)) != 5 {
+		t.Fatalf("Expected a warning 5 days before expiry, got %v", warnings)
+	}
+	if validity.Expired(time.Date(2025, 6, 30, 23, 0, 0, 0, perth)) || !validity.Expired(time.Date(2025, 7, 1, 0, 30, 0, 0, perth)) {
+		t.Fatal("Expected feed to expire at the end of its last date")
+	}
+}
+
+// Tests comparing two versions of a feed
+func TestDiffFeeds(t *testing.T) {
+	// The old version has an extra stop 20 metres from the first stop, which the new version drops
+	oldFeed := gtfstest.NewFeed(gtfstest.Options{})
+	extra := *oldFeed.Stops[gtfstest.StopID(0, 0)]
+	extra.ID = "EXTRA"
+	extra.Location.Latitude += 0.00018
+	oldFeed.Stops[extra.ID] = &extra
+
+	// The new version adds a route, removes a trip, renames a route and moves a stop by about 111 metres
+	newFeed := gtfstest.NewFeed(gtfstest.Options{Routes: 3})
+	delete(newFeed.Trips, gtfstest.TripID(0, 0))
+	newFeed.Routes[gtfstest.RouteID(1)].Name = "Express"
+	newFeed.Stops[gtfstest.StopID(1, 2)].Location.Latitude += 0.001
+
+	oldDB, newDB := &gtfs.GTFS{}, &gtfs.GTFS{}
+	for db, feed := range map[*gtfs.GTFS]*gtfs.Feed{oldDB: oldFeed, newDB: newFeed} {
+		err := db.FromFeed(feed, filepath.Join(t.TempDir(), "gtfs.db"), gtfs.IngestOptions{})
+		if err != nil {
+			t.Fatalf("Failed to create database: %v", err)
+		}
+		defer db.Close()
+	}
+
+	diff, err := gtfs.DiffFeeds(oldDB, newDB, gtfs.DiffOptions{MinStopMove: 50})
+	if err != nil {
+		t.Fatalf("Failed to diff feeds: %v", err)
+	}
+
+	if len(diff.StopsMoved) != 1 || diff.StopsMoved[0].StopID != gtfstest.StopID(1, 2) || diff.StopsMoved[0].Distance < 100 || diff.StopsMoved[0].Distance > 120 {
+		t.Fatalf("Expected stop %s to move about 111 metres, got %v", gtfstest.StopID(1, 2), diff.StopsMoved)
+	}
+	if len(diff.StopsRemoved) != 1 || len(diff.StopsConsolidated) != 1 || diff.StopsConsolidated[0].IntoStopID != gtfstest.StopID(0, 0) {
+		t.Fatalf("Expected stop EXTRA to be consolidated into %s, got %v", gtfstest.StopID(0, 0), diff.StopsConsolidated)
+	}
+	if len(diff.RoutesAdded) != 1 || diff.RoutesAdded[0] != gtfstest.RouteID(2) {
+		t.Fatalf("Expected route %s to be added, got %v", gtfstest.RouteID(2), diff.RoutesAdded)
+	}
+	if len(diff.RoutesRenamed) != 1 || diff.RoutesRenamed[0].To != "Express" {
+		t.Fatalf("Expected route %s to be renamed, got %v", gtfstest.RouteID(1), diff.RoutesRenamed)
+	}
+
+	deltas := make(map[gtfs.Key]int)
+	for _, delta := range diff.TripDeltas {
+		deltas[delta.RouteID] = delta.Delta()
+	}
+	if len(deltas) != 2 || deltas[gtfstest.RouteID(0)] != -1 || deltas[gtfstest.RouteID(2)] != 4 {
+		t.Fatalf("Expected trip deltas of -1 and +4, got %v", diff.TripDeltas)
+	}
+}
+
+// Tests generating walking transfers between nearby stops at ingest
+func TestGenerateTransfers(t *testing.T) {
+	feed := gtfstest.NewFeed(gtfstest.Options{})
+	fixture := &gtfs.GTFS{}
+	err := fixture.FromFeed(feed, filepath.Join(t.TempDir(), "gtfs.db"), gtfs.IngestOptions{TransferDistance: 1000, WalkingSpeed: 1})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer fixture.Close()
+
+	// The first stop is 556 metres from the next stop of its route and 944 metres from the first stop of the other
+	transfers, err := fixture.GetTransfersFromStop(gtfstest.StopID(0, 0))
+	if err != nil {
+		t.Fatalf("Failed to get transfers: %v", err)
+	}
+	times := make(map[gtfs.Key]uint)
+	for _, transfer := range transfers {
+		if !transfer.Generated || transfer.Type != gtfs.MinimumTimeTransferType {
+			t.Fatalf("Expected a generated minimum time transfer, got %+v", transfer)
+		}
+		times[transfer.ToStopID] = transfer.MinTransferTime
+	}
+	if len(times) != 2 || times[gtfstest.StopID(0, 1)] < 550 || times[gtfstest.StopID(0, 1)] > 560 || times[gtfstest.StopID(1, 0)] < 940 || times[gtfstest.StopID(1, 0)] > 950 {
+		t.Fatalf("Expected transfers to %s and %s, got %v", gtfstest.StopID(0, 1), gtfstest.StopID(1, 0), times)
+	}
+
+	// Before the first trip, the other route's first stop can only be reached on foot
+	perth, _ := time.LoadLocation("Australia/Perth")
+	reachable, err := fixture.Isochrone(gtfstest.StopID(0, 0), time.Date(2025, 6, 2, 5, 30, 0, 0, perth), 20*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to compute isochrone: %v", err)
+	}
+	walked := false
+	for _, stop := range reachable {
+		if stop.StopID == gtfstest.StopID(1, 0) {
+			walked = stop.TravelTime > 15*time.Minute && stop.TravelTime < 16*time.Minute
+		}
+	}
+	if !walked {
+		t.Fatalf("Expected %s reachable by walking in about 16 minutes, got %v", gtfstest.StopID(1, 0), reachable)
+	}
+
+	// Transfers listed in the feed are used instead of generated ones
+	feed = gtfstest.NewFeed(gtfstest.Options{})
+	feed.Transfers = gtfs.TransferArray{{FromStopID: gtfstest.StopID(0, 0), ToStopID: gtfstest.StopID(1, 4), Type: gtfs.MinimumTimeTransferType, MinTransferTime: 60}}
+	listed := &gtfs.GTFS{}
+	err = listed.FromFeed(feed, filepath.Join(t.TempDir(), "gtfs.db"), gtfs.IngestOptions{TransferDistance: 1000})
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer listed.Close()
+	transfers, err = listed.GetTransfersFromStop(gtfstest.StopID(0, 0))
+	if err != nil {
+		t.Fatalf("Failed to get transfers: %v", err)
+	}
+	if len(transfers) != 1 || transfers[0].ToStopID != gtfstest.StopID(1, 4) || transfers[0].Generated {
+		t.Fatalf("Expected only the listed transfer, got %v", transfers)
+	}
+}
+
+// Tests that timezones are loaded once and shared
+func TestLoadLocation(t *testing.T) {
+	first, err := gtfs.LoadLocation("Australia/Perth")
+	if err != nil {
+		t.Fatalf("Failed to load location: %v", err)
+	}
+	second, err := gtfs.LoadLocation("Australia/Perth")
+	if err != nil {
+		t.Fatalf("Failed to load location: %v", err)
+	}
+	if first != second {
+		t.Error("Expected the cached location to be returned")
+	}
+	if _, err := gtfs.LoadLocation("Not/AZone"); err == nil {
+		t.Error("Expected an error for an invalid timezone")
+	}
+}
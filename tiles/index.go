@@ -0,0 +1,79 @@
+package tiles
+
+import (
+	"math"
+	"slices"
+
+	"github.com/paulmach/orb"
+)
+
+// Size of the cells of the grid indexing features, in degrees. Cells are as wide as tiles at zoom 12,
+// so tiles at higher zoom levels only look up a few cells.
+const gridCellSize = 360.0 / (1 << 12)
+
+// Cell of the grid indexing features
+type gridCell struct {
+	lon int
+	lat int
+}
+
+// Returns the grid cell containing the point
+func cellOf(p orb.Point) gridCell {
+	return gridCell{
+		lon: int(math.Floor(p.Lon() / gridCellSize)),
+		lat: int(math.Floor(p.Lat() / gridCellSize)),
+	}
+}
+
+// Grid of features by the cells their bounds overlap, so that a tile only visits the features near it
+type gridIndex struct {
+	cells map[gridCell][]int // Indexes of the features overlapping each cell, in increasing order
+	bound orb.Bound          // Bound of all the indexed features
+}
+
+func newGridIndex() *gridIndex {
+	return &gridIndex{cells: make(map[gridCell][]int)}
+}
+
+// Add the feature with the given index and bound. Features must be added in increasing order of index.
+func (idx *gridIndex) add(i int, bound orb.Bound) {
+	if len(idx.cells) == 0 {
+		idx.bound = bound
+	} else {
+		idx.bound = idx.bound.Union(bound)
+	}
+
+	first, last := cellOf(bound.Min), cellOf(bound.Max)
+	for lon := first.lon; lon <= last.lon; lon++ {
+		for lat := first.lat; lat <= last.lat; lat++ {
+			cell := gridCell{lon, lat}
+			idx.cells[cell] = append(idx.cells[cell], i)
+		}
+	}
+}
+
+// Returns the indexes of the features in cells overlapping the bound, in increasing order. Features near
+// the bound may be returned even if they do not intersect it.
+func (idx *gridIndex) query(bound orb.Bound) []int {
+	if len(idx.cells) == 0 || !bound.Intersects(idx.bound) {
+		return nil
+	}
+
+	// Only the cells within the indexed features' bound can hold any, which keeps low zoom levels cheap
+	bound = orb.Bound{
+		Min: orb.Point{math.Max(bound.Min.Lon(), idx.bound.Min.Lon()), math.Max(bound.Min.Lat(), idx.bound.Min.Lat())},
+		Max: orb.Point{math.Min(bound.Max.Lon(), idx.bound.Max.Lon()), math.Min(bound.Max.Lat(), idx.bound.Max.Lat())},
+	}
+	first, last := cellOf(bound.Min), cellOf(bound.Max)
+
+	var found []int
+	for lon := first.lon; lon <= last.lon; lon++ {
+		for lat := first.lat; lat <= last.lat; lat++ {
+			found = append(found, idx.cells[gridCell{lon, lat}]...)
+		}
+	}
+
+	// Features spanning several cells are found once for each
+	slices.Sort(found)
+	return slices.Compact(found)
+}
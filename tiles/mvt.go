@@ -0,0 +1,174 @@
+package tiles
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the vector tile messages
+const (
+	tileLayersField protowire.Number = 3
+
+	layerNameField     protowire.Number = 1
+	layerFeaturesField protowire.Number = 2
+	layerKeysField     protowire.Number = 3
+	layerValuesField   protowire.Number = 4
+	layerExtentField   protowire.Number = 5
+	layerVersionField  protowire.Number = 15
+
+	featureIDField       protowire.Number = 1
+	featureTagsField     protowire.Number = 2
+	featureTypeField     protowire.Number = 3
+	featureGeometryField protowire.Number = 4
+
+	valueStringField protowire.Number = 1
+	valueIntField    protowire.Number = 4
+)
+
+// Version of the vector tile specification followed
+const layerVersion = 2
+
+// Geometry types of features
+const (
+	pointGeometryType      = 1
+	lineStringGeometryType = 2
+)
+
+// Geometry commands
+const (
+	moveToCommand = 1
+	lineToCommand = 2
+)
+
+// A layer of a tile being built, with its keys and values shared between features
+type layer struct {
+	name     string
+	extent   uint32
+	keys     []string
+	keyIndex map[string]uint32
+	values   [][]byte
+	valIndex map[property]uint32
+	features [][]byte
+}
+
+func newLayer(name string, extent uint32) *layer {
+	return &layer{
+		name:     name,
+		extent:   extent,
+		keyIndex: make(map[string]uint32),
+		valIndex: make(map[property]uint32),
+	}
+}
+
+// Add a feature with the encoded geometry and properties to the layer. Empty string properties are omitted.
+func (l *layer) addFeature(id uint64, geometryType uint64, geometry []uint32, props []property) {
+	var tags []uint32
+	for _, prop := range props {
+		if !prop.isInt && prop.stringVal == "" {
+			continue
+		}
+
+		key, ok := l.keyIndex[prop.key]
+		if !ok {
+			key = uint32(len(l.keys))
+			l.keys = append(l.keys, prop.key)
+			l.keyIndex[prop.key] = key
+		}
+
+		value := property{stringVal: prop.stringVal, intVal: prop.intVal, isInt: prop.isInt}
+		valueIndex, ok := l.valIndex[value]
+		if !ok {
+			valueIndex = uint32(len(l.values))
+			l.values = append(l.values, value.encode())
+			l.valIndex[value] = valueIndex
+		}
+		tags = append(tags, key, valueIndex)
+	}
+
+	var data []byte
+	data = protowire.AppendTag(data, featureIDField, protowire.VarintType)
+	data = protowire.AppendVarint(data, id)
+	if len(tags) > 0 {
+		data = protowire.AppendTag(data, featureTagsField, protowire.BytesType)
+		data = protowire.AppendBytes(data, packUint32s(tags))
+	}
+	data = protowire.AppendTag(data, featureTypeField, protowire.VarintType)
+	data = protowire.AppendVarint(data, geometryType)
+	data = protowire.AppendTag(data, featureGeometryField, protowire.BytesType)
+	data = protowire.AppendBytes(data, packUint32s(geometry))
+	l.features = append(l.features, data)
+}
+
+// Encode the layer as a Layer message
+func (l *layer) encode() []byte {
+	var data []byte
+	data = protowire.AppendTag(data, layerVersionField, protowire.VarintType)
+	data = protowire.AppendVarint(data, layerVersion)
+	data = protowire.AppendTag(data, layerNameField, protowire.BytesType)
+	data = protowire.AppendString(data, l.name)
+	for _, feature := range l.features {
+		data = protowire.AppendTag(data, layerFeaturesField, protowire.BytesType)
+		data = protowire.AppendBytes(data, feature)
+	}
+	for _, key := range l.keys {
+		data = protowire.AppendTag(data, layerKeysField, protowire.BytesType)
+		data = protowire.AppendString(data, key)
+	}
+	for _, value := range l.values {
+		data = protowire.AppendTag(data, layerValuesField, protowire.BytesType)
+		data = protowire.AppendBytes(data, value)
+	}
+	data = protowire.AppendTag(data, layerExtentField, protowire.VarintType)
+	data = protowire.AppendVarint(data, uint64(l.extent))
+	return data
+}
+
+// Encode the property's value as a Value message
+func (p property) encode() []byte {
+	var data []byte
+	if p.isInt {
+		data = protowire.AppendTag(data, valueIntField, protowire.VarintType)
+		return protowire.AppendVarint(data, uint64(p.intVal))
+	}
+	data = protowire.AppendTag(data, valueStringField, protowire.BytesType)
+	return protowire.AppendString(data, p.stringVal)
+}
+
+// Pack the values as a repeated uint32 field
+func packUint32s(values []uint32) []byte {
+	var data []byte
+	for _, value := range values {
+		data = protowire.AppendVarint(data, uint64(value))
+	}
+	return data
+}
+
+// Returns the command integer of a geometry command repeated count times
+func command(id, count uint32) uint32 {
+	return id&0x7 | count<<3
+}
+
+// Returns the zigzag encoding of a geometry parameter
+func zigzag(n int64) uint32 {
+	return uint32((n << 1) ^ (n >> 63))
+}
+
+// Encode the geometry of a point
+func encodePoint(x, y int64) []uint32 {
+	return []uint32{command(moveToCommand, 1), zigzag(x), zigzag(y)}
+}
+
+// Append the geometry of a line string to a feature's geometry, moving the cursor from its position at the end
+// of the previous line. Lines with fewer than two points are skipped.
+func appendLineString(geometry []uint32, points [][2]int64, cursor *[2]int64) []uint32 {
+	if len(points) < 2 {
+		return geometry
+	}
+
+	geometry = append(geometry, command(moveToCommand, 1), zigzag(points[0][0]-cursor[0]), zigzag(points[0][1]-cursor[1]))
+	geometry = append(geometry, command(lineToCommand, uint32(len(points)-1)))
+	for i := 1; i < len(points); i++ {
+		geometry = append(geometry, zigzag(points[i][0]-points[i-1][0]), zigzag(points[i][1]-points[i-1][1]))
+	}
+	*cursor = points[len(points)-1]
+	return geometry
+}
@@ -0,0 +1,310 @@
+// Package tiles generates Mapbox Vector Tiles of the stops and route shapes in a GTFS database, so that maps
+// can be rendered from it without a separate spatial database.
+//
+//	gen, err := tiles.NewGenerator(g, tiles.Options{})
+//	...
+//	http.Handle("/tiles/", http.StripPrefix("/tiles", gen))
+//
+// Tiles contain a "stops" layer of points and a "routes" layer of lines, one for each direction of each route
+// with a shape. Tiles are encoded directly with protowire, following version 2.1 of the specification.
+package tiles
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aaroncutress/gtfs-go"
+	"github.com/charmbracelet/log"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/clip"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Names of the layers of each tile
+const (
+	StopsLayer  = "stops"
+	RoutesLayer = "routes"
+)
+
+// Defaults for options which are not set
+const (
+	defaultExtent = 4096
+	defaultBuffer = 64
+)
+
+// Highest zoom level a tile can be requested at
+const maxZoom = 24
+
+// Latitude beyond which the Web Mercator projection is cut off
+const maxLatitude = 85.0511287798
+
+// Options controlling the contents of generated tiles
+type Options struct {
+	Extent      uint32 // Size of a tile in its own coordinates (defaults to 4096)
+	Buffer      uint32 // Distance beyond the edge of a tile to include, in tile coordinates (defaults to 64)
+	MinStopZoom int    // Zoom level below which the stops layer is empty (zero includes stops at every zoom)
+}
+
+// A stop and its properties, ready to be drawn
+type stopFeature struct {
+	id       uint64
+	location orb.Point
+	props    []property
+}
+
+// A route shape in one direction and its properties, ready to be drawn
+type routeFeature struct {
+	id    uint64
+	line  orb.LineString
+	bound orb.Bound
+	props []property
+}
+
+// A property of a feature, with either a string or an integer value
+type property struct {
+	key       string
+	stringVal string
+	intVal    int64
+	isInt     bool
+}
+
+// Generates vector tiles from the stops and route shapes of a GTFS database
+type Generator struct {
+	opts       Options
+	stops      []stopFeature
+	routes     []routeFeature
+	stopIndex  *gridIndex // Stops by the grid cells containing them
+	routeIndex *gridIndex // Routes by the grid cells their bounds overlap
+}
+
+// Create a new Generator for the GTFS database, loading its stops and route shapes and indexing them by location
+func NewGenerator(g *gtfs.GTFS, opts Options) (*Generator, error) {
+	if opts.Extent == 0 {
+		opts.Extent = defaultExtent
+	}
+	if opts.Buffer == 0 {
+		opts.Buffer = defaultBuffer
+	}
+	gen := &Generator{
+		opts:       opts,
+		stopIndex:  newGridIndex(),
+		routeIndex: newGridIndex(),
+	}
+
+	stops, err := g.GetAllStops()
+	if err != nil {
+		return nil, err
+	}
+	stopIDs := make([]gtfs.Key, 0, len(stops))
+	for id := range stops {
+		stopIDs = append(stopIDs, id)
+	}
+	sort.Slice(stopIDs, func(i, j int) bool { return stopIDs[i] < stopIDs[j] })
+	for i, id := range stopIDs {
+		stop := stops[id]
+		location := orb.Point{stop.Location.Longitude, stop.Location.Latitude}
+		gen.stopIndex.add(len(gen.stops), location.Bound())
+		gen.stops = append(gen.stops, stopFeature{
+			id:       uint64(i + 1),
+			location: location,
+			props: []property{
+				{key: "id", stringVal: string(stop.ID)},
+				{key: "name", stringVal: stop.Name},
+				{key: "code", stringVal: stop.Code},
+				{key: "parent_id", stringVal: string(stop.ParentID)},
+				{key: "location_type", intVal: int64(stop.LocationType), isInt: true},
+				{key: "wheelchair_boarding", intVal: int64(stop.WheelchairBoarding), isInt: true},
+			},
+		})
+	}
+
+	routes, err := g.GetAllRoutes()
+	if err != nil {
+		return nil, err
+	}
+	routeIDs := make([]gtfs.Key, 0, len(routes))
+	var shapeIDs []gtfs.Key
+	for id, route := range routes {
+		routeIDs = append(routeIDs, id)
+		for _, shapeID := range []*gtfs.Key{route.InboundShapeID, route.OutboundShapeID} {
+			if shapeID != nil && *shapeID != "" {
+				shapeIDs = append(shapeIDs, *shapeID)
+			}
+		}
+	}
+	sort.Slice(routeIDs, func(i, j int) bool { return routeIDs[i] < routeIDs[j] })
+	shapes, err := g.GetShapesByIDs(shapeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range routeIDs {
+		route := routes[id]
+		for _, direction := range []struct {
+			name    string
+			shapeID *gtfs.Key
+		}{
+			{"outbound", route.OutboundShapeID},
+			{"inbound", route.InboundShapeID},
+		} {
+			if direction.shapeID == nil {
+				continue
+			}
+			shape, ok := shapes[*direction.shapeID]
+			if !ok || len(shape.Coordinates) < 2 {
+				continue
+			}
+
+			line := make(orb.LineString, len(shape.Coordinates))
+			for i, coord := range shape.Coordinates {
+				line[i] = orb.Point{coord.Longitude, coord.Latitude}
+			}
+			colour := route.Colour
+			if colour != "" && !strings.HasPrefix(colour, "#") {
+				colour = "#" + colour
+			}
+			bound := line.Bound()
+			gen.routeIndex.add(len(gen.routes), bound)
+			gen.routes = append(gen.routes, routeFeature{
+				id:    uint64(len(gen.routes) + 1),
+				line:  line,
+				bound: bound,
+				props: []property{
+					{key: "id", stringVal: string(route.ID)},
+					{key: "name", stringVal: route.Name},
+					{key: "type", intVal: int64(route.Type), isInt: true},
+					{key: "colour", stringVal: colour},
+					{key: "direction", stringVal: direction.name},
+					{key: "shape_id", stringVal: string(shape.ID)},
+				},
+			})
+		}
+	}
+
+	return gen, nil
+}
+
+// Returns the encoded tile at the given zoom level and coordinates
+func (gen *Generator) Tile(z, x, y uint32) ([]byte, error) {
+	if z > maxZoom {
+		return nil, errors.New("zoom level out of range")
+	}
+	tiles := float64(uint32(1) << z)
+	if float64(x) >= tiles || float64(y) >= tiles {
+		return nil, errors.New("tile coordinates out of range")
+	}
+
+	// Bound of the tile and its buffer
+	buffer := float64(gen.opts.Buffer) / float64(gen.opts.Extent)
+	minLon, maxLat := tileToCoordinate(float64(x)-buffer, float64(y)-buffer, tiles)
+	maxLon, minLat := tileToCoordinate(float64(x)+1+buffer, float64(y)+1+buffer, tiles)
+	bound := orb.Bound{Min: orb.Point{minLon, minLat}, Max: orb.Point{maxLon, maxLat}}
+
+	// Project a coordinate into the tile's own coordinates
+	extent := float64(gen.opts.Extent)
+	project := func(p orb.Point) (int64, int64) {
+		tileX, tileY := coordinateToTile(p, tiles)
+		return int64(math.Round((tileX - float64(x)) * extent)), int64(math.Round((tileY - float64(y)) * extent))
+	}
+
+	stops := newLayer(StopsLayer, gen.opts.Extent)
+	if int(z) >= gen.opts.MinStopZoom {
+		for _, i := range gen.stopIndex.query(bound) {
+			stop := gen.stops[i]
+			if !bound.Contains(stop.location) {
+				continue
+			}
+			px, py := project(stop.location)
+			stops.addFeature(stop.id, pointGeometryType, encodePoint(px, py), stop.props)
+		}
+	}
+
+	routes := newLayer(RoutesLayer, gen.opts.Extent)
+	for _, i := range gen.routeIndex.query(bound) {
+		route := gen.routes[i]
+		if !bound.Intersects(route.bound) {
+			continue
+		}
+
+		var geometry []uint32
+		var cursor [2]int64
+		for _, line := range clip.LineString(bound, route.line) {
+			points := make([][2]int64, 0, len(line))
+			for _, p := range line {
+				px, py := project(p)
+				if len(points) > 0 && points[len(points)-1] == [2]int64{px, py} {
+					continue
+				}
+				points = append(points, [2]int64{px, py})
+			}
+			geometry = appendLineString(geometry, points, &cursor)
+		}
+		if len(geometry) > 0 {
+			routes.addFeature(route.id, lineStringGeometryType, geometry, route.props)
+		}
+	}
+
+	var data []byte
+	for _, layer := range []*layer{stops, routes} {
+		if len(layer.features) > 0 {
+			data = protowire.AppendTag(data, tileLayersField, protowire.BytesType)
+			data = protowire.AppendBytes(data, layer.encode())
+		}
+	}
+	return data, nil
+}
+
+// Returns the position of the coordinate in tiles from the top left of the Web Mercator projection, at the zoom
+// level with the given number of tiles across
+func coordinateToTile(p orb.Point, tiles float64) (float64, float64) {
+	lat := math.Max(math.Min(p.Lat(), maxLatitude), -maxLatitude) * math.Pi / 180
+	x := (p.Lon() + 180) / 360 * tiles
+	y := (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * tiles
+	return x, y
+}
+
+// Returns the longitude and latitude of a position in tiles, the inverse of coordinateToTile
+func tileToCoordinate(x, y, tiles float64) (float64, float64) {
+	lon := x/tiles*360 - 180
+	lat := math.Atan(math.Sinh(math.Pi*(1-2*y/tiles))) * 180 / math.Pi
+	return lon, lat
+}
+
+// Serves tiles at paths of the form /{z}/{x}/{y}.mvt (or .pbf)
+func (gen *Generator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+	last := strings.TrimSuffix(strings.TrimSuffix(parts[2], ".mvt"), ".pbf")
+	coords := make([]uint32, 3)
+	for i, part := range []string{parts[0], parts[1], last} {
+		value, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		coords[i] = uint32(value)
+	}
+
+	data, err := gen.Tile(coords[0], coords[1], coords[2])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+	_, err = w.Write(data)
+	if err != nil {
+		log.Errorf("Failed to write tile: %v", err)
+	}
+}
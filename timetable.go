@@ -0,0 +1,229 @@
+package gtfs
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Departures of a route from a stop on one or more consecutive days with the same times
+type StopTimetableRow struct {
+	RouteID   Key         `json:"route_id"`
+	RouteName string      `json:"route_name"`
+	Days      []time.Time `json:"days"`    // Dates the row covers, at midnight in the feed's timezone
+	Minutes   [24][]int   `json:"minutes"` // Minutes past each hour of the departures, sorted
+}
+
+// Returns the label of the days the row covers, such as "Mon" or "Mon-Fri"
+func (r StopTimetableRow) DaysLabel() string {
+	if len(r.Days) == 0 {
+		return ""
+	}
+	first := r.Days[0].Weekday().String()[:3]
+	if len(r.Days) == 1 {
+		return first
+	}
+	return first + "-" + r.Days[len(r.Days)-1].Weekday().String()[:3]
+}
+
+// Weekly timetable of the departures from a stop, as a grid of routes and days by hour
+type StopTimetable struct {
+	Stop      *Stop              `json:"stop"`
+	WeekStart time.Time          `json:"week_start"` // First day of the week, at midnight in the feed's timezone
+	Rows      []StopTimetableRow `json:"rows"`       // Sorted by route ID, then by day
+	FirstHour int                `json:"first_hour"` // Earliest hour with a departure, on any day
+	LastHour  int                `json:"last_hour"`  // Latest hour with a departure, on any day
+}
+
+// Returns a weekly timetable of the departures from the stop for the seven days starting on the day of the
+// given time. Departures are resolved for each day as with GetStopDepartures, so service exceptions and
+// applied service changes are taken into account, and are grouped by the hour of the local clock time.
+// Consecutive days on which a route departs at the same times share a row.
+func (g *GTFS) GetStopTimetable(stopID Key, weekStart time.Time) (*StopTimetable, error) {
+	stop, err := g.GetStopByID(stopID)
+	if err != nil {
+		return nil, err
+	}
+	timezone, err := g.getFeedTimezone()
+	if err != nil {
+		return nil, err
+	}
+	prepared, err := g.PrepareDepartures(stopID)
+	if err != nil {
+		return nil, err
+	}
+	routes, err := g.GetAllRoutes()
+	if err != nil {
+		return nil, err
+	}
+
+	year, month, day := weekStart.In(timezone).Date()
+	timetable := &StopTimetable{
+		Stop:      stop,
+		WeekStart: time.Date(year, month, day, 0, 0, 0, 0, timezone),
+		Rows:      []StopTimetableRow{},
+		FirstHour: 23,
+	}
+
+	routeRows := make(map[Key][]StopTimetableRow)
+	for i := range 7 {
+		date := time.Date(year, month, day+i, 0, 0, 0, 0, timezone)
+		departures, err := prepared.Departures(date, date.AddDate(0, 0, 1).Sub(date)-time.Nanosecond)
+		if err != nil {
+			return nil, err
+		}
+
+		dayMinutes := make(map[Key]*[24][]int)
+		for _, departure := range departures {
+			minutes, ok := dayMinutes[departure.RouteID]
+			if !ok {
+				minutes = &[24][]int{}
+				dayMinutes[departure.RouteID] = minutes
+			}
+			hour := departure.Time.Hour()
+			minutes[hour] = append(minutes[hour], departure.Time.Minute())
+			timetable.FirstHour = min(timetable.FirstHour, hour)
+			timetable.LastHour = max(timetable.LastHour, hour)
+		}
+
+		for routeID, minutes := range dayMinutes {
+			rows := routeRows[routeID]
+
+			// Extend the route's row for the previous day if the times are the same
+			if len(rows) > 0 {
+				last := &rows[len(rows)-1]
+				if last.Days[len(last.Days)-1].Equal(date.AddDate(0, 0, -1)) && sameTimetableMinutes(last.Minutes, *minutes) {
+					last.Days = append(last.Days, date)
+					continue
+				}
+			}
+
+			row := StopTimetableRow{RouteID: routeID, Days: []time.Time{date}, Minutes: *minutes}
+			if route, ok := routes[routeID]; ok {
+				row.RouteName = route.Name
+			}
+			routeRows[routeID] = append(rows, row)
+		}
+	}
+
+	for _, routeID := range sortedIDs(routeRows) {
+		timetable.Rows = append(timetable.Rows, routeRows[routeID]...)
+	}
+	if len(timetable.Rows) == 0 {
+		timetable.FirstHour = 0
+	}
+	return timetable, nil
+}
+
+// Check if two days have departures at the same times
+func sameTimetableMinutes(a, b [24][]int) bool {
+	for hour := range a {
+		if !slices.Equal(a[hour], b[hour]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the minutes past the hour of a timetable cell, separated by spaces
+func formatTimetableMinutes(minutes []int) string {
+	formatted := make([]string, len(minutes))
+	for i, minute := range minutes {
+		formatted[i] = fmt.Sprintf("%02d", minute)
+	}
+	return strings.Join(formatted, " ")
+}
+
+// Returns the hours spanned by the timetable's departures
+func (t *StopTimetable) hours() []int {
+	hours := []int{}
+	if len(t.Rows) == 0 {
+		return hours
+	}
+	for hour := t.FirstHour; hour <= t.LastHour; hour++ {
+		hours = append(hours, hour)
+	}
+	return hours
+}
+
+// Write the timetable as CSV, with a row for each route and group of days giving the minutes past each hour
+// of its departures. Hours are only included from the earliest to the latest with a departure.
+func (t *StopTimetable) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+
+	hours := t.hours()
+	header := []string{"route_id", "route_name", "days", "start_date", "end_date"}
+	for _, hour := range hours {
+		header = append(header, fmt.Sprintf("%02d", hour))
+	}
+	err := writer.Write(header)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range t.Rows {
+		record := []string{
+			string(row.RouteID),
+			row.RouteName,
+			row.DaysLabel(),
+			row.Days[0].Format("20060102"),
+			row.Days[len(row.Days)-1].Format("20060102"),
+		}
+		for _, hour := range hours {
+			record = append(record, formatTimetableMinutes(row.Minutes[hour]))
+		}
+		err := writer.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// Write the timetable as a standalone HTML page suitable for printing, with a table of the minutes past
+// each hour of the departures of each route and group of days, as with WriteCSV
+func (t *StopTimetable) WriteHTML(w io.Writer) error {
+	var b strings.Builder
+	title := html.EscapeString(t.Stop.Name)
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n", title)
+	b.WriteString("<style>\nbody { font-family: sans-serif; }\n" +
+		"table { border-collapse: collapse; }\n" +
+		"th, td { border: 1px solid #999; padding: 2px 6px; text-align: left; vertical-align: top; }\n" +
+		"</style>\n</head>\n<body>\n")
+	fmt.Fprintf(&b, "<h1>%s</h1>\n", title)
+	fmt.Fprintf(&b, "<p>Stop %s, week starting %s</p>\n", html.EscapeString(string(t.Stop.ID)), t.WeekStart.Format("Monday 2 January 2006"))
+
+	if len(t.Rows) == 0 {
+		b.WriteString("<p>No departures.</p>\n")
+	} else {
+		hours := t.hours()
+		b.WriteString("<table>\n<tr><th>Route</th><th>Days</th>")
+		for _, hour := range hours {
+			fmt.Fprintf(&b, "<th>%02d</th>", hour)
+		}
+		b.WriteString("</tr>\n")
+
+		for _, row := range t.Rows {
+			name := row.RouteName
+			if name == "" {
+				name = string(row.RouteID)
+			}
+			fmt.Fprintf(&b, "<tr><td>%s</td><td>%s</td>", html.EscapeString(name), row.DaysLabel())
+			for _, hour := range hours {
+				fmt.Fprintf(&b, "<td>%s</td>", formatTimetableMinutes(row.Minutes[hour]))
+			}
+			b.WriteString("</tr>\n")
+		}
+		b.WriteString("</table>\n")
+	}
+	b.WriteString("</body>\n</html>\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
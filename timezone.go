@@ -0,0 +1,82 @@
+package gtfs
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Locations loaded by LoadLocation, keyed by name
+var locationCache sync.Map
+
+// Returns the location with the given IANA name as time.LoadLocation does, caching it for the lifetime of
+// the process so that repeated lookups do not read the timezone database
+func LoadLocation(name string) (*time.Location, error) {
+	if loc, ok := locationCache.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locationCache.Store(name, loc)
+	return loc, nil
+}
+
+// Timezones of a database's agencies, resolved when it is opened
+type agencyLocations struct {
+	byAgency map[Key]*time.Location
+	feed     *time.Location // Timezone of the agency with the lowest ID, shared by all agencies of a valid feed
+}
+
+// Resolves the timezones of the database's agencies. Agencies with invalid timezones are left out, so that
+// the error is returned when they are queried.
+func (g *GTFS) loadAgencyLocations() (*agencyLocations, error) {
+	agencies, err := g.GetAllAgencies()
+	if err != nil {
+		return nil, err
+	}
+
+	locations := &agencyLocations{byAgency: make(map[Key]*time.Location, len(agencies))}
+	for _, id := range sortedIDs(agencies) {
+		loc, err := LoadLocation(agencies[id].Timezone)
+		if err != nil {
+			continue
+		}
+		locations.byAgency[id] = loc
+		if locations.feed == nil {
+			locations.feed = loc
+		}
+	}
+	return locations, nil
+}
+
+// Returns the timezone of the agency with the given ID
+func (g *GTFS) getAgencyTimezone(agencyID Key) (*time.Location, error) {
+	if g.locations != nil {
+		if loc, ok := g.locations.byAgency[agencyID]; ok {
+			return loc, nil
+		}
+	}
+	agency, err := g.GetAgencyByID(agencyID)
+	if err != nil {
+		return nil, err
+	}
+	return LoadLocation(agency.Timezone)
+}
+
+// Returns the timezone of the feed, taken from its agencies.
+// The GTFS specification requires all agencies in a feed to share the same timezone.
+func (g *GTFS) getFeedTimezone() (*time.Location, error) {
+	if g.locations != nil && g.locations.feed != nil {
+		return g.locations.feed, nil
+	}
+	agencies, err := g.GetAllAgencies()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range sortedIDs(agencies) {
+		return LoadLocation(agencies[id].Timezone)
+	}
+	return nil, errors.New("no agencies found")
+}
@@ -0,0 +1,345 @@
+package gtfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Walking speed in metres per second used for generated transfers, if not given
+const DefaultWalkingSpeed = 1.2
+
+// Enum for the types of transfer between stops
+type TransferType uint8
+
+const (
+	RecommendedTransferType TransferType = iota
+	TimedTransferType
+	MinimumTimeTransferType
+	NotPossibleTransferType
+)
+
+// Represents a transfer between two stops, from transfers.txt or generated from the distance between them
+type Transfer struct {
+	FromStopID      Key          `json:"from_stop_id"`
+	ToStopID        Key          `json:"to_stop_id"`
+	Type            TransferType `json:"transfer_type"`
+	MinTransferTime uint         `json:"min_transfer_time"` // Seconds, zero if not given
+	Generated       bool         `json:"generated"`         // Whether the transfer was generated rather than listed in the feed
+}
+type TransferArray []Transfer
+
+// Encode the TransferArray into a byte slice
+// Format:
+// - Count: 4 bytes (number of transfers)
+// - Each transfer:
+//   - FromStopID: 4-byte length + UTF-8 string
+//   - ToStopID: 4-byte length + UTF-8 string
+//   - Type: 1 byte (TransferType)
+//   - MinTransferTime: 4 bytes (uint32)
+//   - Generated: 1 byte (bool)
+func (ta TransferArray) Encode() []byte {
+	totalLen := lenBytes
+	for _, t := range ta {
+		totalLen += lenBytes + len(t.FromStopID) + lenBytes + len(t.ToStopID) + 1 + uint32Bytes + 1
+	}
+
+	data := make([]byte, totalLen)
+	offset := 0
+
+	binary.BigEndian.PutUint32(data[offset:], uint32(len(ta)))
+	offset += lenBytes
+
+	for _, t := range ta {
+		binary.BigEndian.PutUint32(data[offset:], uint32(len(t.FromStopID)))
+		offset += lenBytes
+		copy(data[offset:], t.FromStopID)
+		offset += len(t.FromStopID)
+
+		binary.BigEndian.PutUint32(data[offset:], uint32(len(t.ToStopID)))
+		offset += lenBytes
+		copy(data[offset:], t.ToStopID)
+		offset += len(t.ToStopID)
+
+		data[offset] = byte(t.Type)
+		offset++
+		binary.BigEndian.PutUint32(data[offset:], uint32(t.MinTransferTime))
+		offset += uint32Bytes
+		if t.Generated {
+			data[offset] = 1
+		}
+		offset++
+	}
+	return data
+}
+
+// Decode the byte slice into the TransferArray
+func (ta *TransferArray) Decode(data []byte) error {
+	if ta == nil {
+		return errors.New("cannot decode into a nil TransferArray")
+	}
+	offset := 0
+
+	if offset+lenBytes > len(data) {
+		return errors.New("transfer buffer too small for count")
+	}
+	count := binary.BigEndian.Uint32(data[offset:])
+	offset += lenBytes
+
+	// Read a length-prefixed key
+	readKey := func(i uint32, field string) (Key, error) {
+		if offset+lenBytes > len(data) {
+			return "", fmt.Errorf("transfer buffer too small for transfer %d %s length", i, field)
+		}
+		keyLen := int(binary.BigEndian.Uint32(data[offset:]))
+		offset += lenBytes
+		if offset+keyLen > len(data) {
+			return "", fmt.Errorf("transfer buffer too small for transfer %d %s content", i, field)
+		}
+		key := Key(data[offset : offset+keyLen])
+		offset += keyLen
+		return key, nil
+	}
+
+	transfers := make(TransferArray, count)
+	for i := uint32(0); i < count; i++ {
+		from, err := readKey(i, "FromStopID")
+		if err != nil {
+			return err
+		}
+		to, err := readKey(i, "ToStopID")
+		if err != nil {
+			return err
+		}
+
+		if offset+1+uint32Bytes+1 > len(data) {
+			return fmt.Errorf("transfer buffer too small for transfer %d values", i)
+		}
+		transfers[i] = Transfer{
+			FromStopID:      from,
+			ToStopID:        to,
+			Type:            TransferType(data[offset]),
+			MinTransferTime: uint(binary.BigEndian.Uint32(data[offset+1:])),
+			Generated:       data[offset+1+uint32Bytes] == 1,
+		}
+		offset += 1 + uint32Bytes + 1
+	}
+
+	*ta = transfers
+	return nil
+}
+
+// Load and parse transfers between stops from the GTFS transfers.txt file. Transfers between trips or routes
+// rather than stops are ignored.
+func ParseTransfers(file io.Reader) (TransferArray, error) {
+	transfers, _, err := ParseTransfersWithOptions(file, ParseOptions{})
+	return transfers, err
+}
+
+// Load and parse transfers between stops from the GTFS transfers.txt file, handling malformed rows according
+// to the given options
+func ParseTransfersWithOptions(file io.Reader, opts ParseOptions) (TransferArray, *FileReport, error) {
+	parser, err := newCSVParser("transfers.txt", file, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = parser.require("transfer_type")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	transfers := TransferArray{}
+	for {
+		record, err := parser.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		fromStopID := Key(parser.get(record, "from_stop_id"))
+		toStopID := Key(parser.get(record, "to_stop_id"))
+		if fromStopID == "" || toStopID == "" {
+			continue
+		}
+
+		transferType, err := strconv.Atoi(parser.get(record, "transfer_type"))
+		if err != nil || transferType < 0 || transferType > int(math.MaxUint8) {
+			if !parser.canRepair() {
+				if err := parser.skip(parser.spec("unexpected_enum_value", "transfers.transfer_type must be a valid transfer type", fmt.Errorf("invalid transfer type %q", parser.get(record, "transfer_type")))); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			transferType = int(RecommendedTransferType)
+			parser.repair("invalid transfer type %q, defaulted to %d", parser.get(record, "transfer_type"), transferType)
+		}
+
+		minTransferTime := 0
+		if value := parser.get(record, "min_transfer_time"); value != "" {
+			minTransferTime, err = strconv.Atoi(value)
+			if err != nil || minTransferTime < 0 {
+				if !parser.canRepair() {
+					if err := parser.skip(parser.spec("invalid_integer", "transfers.min_transfer_time must be a non-negative integer", fmt.Errorf("invalid min transfer time %q", value))); err != nil {
+						return nil, nil, err
+					}
+					continue
+				}
+				minTransferTime = 0
+				parser.repair("invalid min transfer time %q, defaulted to 0", value)
+			}
+		}
+
+		transfers = append(transfers, Transfer{
+			FromStopID:      fromStopID,
+			ToStopID:        toStopID,
+			Type:            TransferType(transferType),
+			MinTransferTime: uint(minTransferTime),
+		})
+	}
+
+	return transfers, parser.report, nil
+}
+
+// Generates walking transfers in both directions between each pair of stops within the given distance in
+// metres of each other, with a minimum transfer time of the time taken to walk between them at the given
+// speed in metres per second (or DefaultWalkingSpeed if not positive). Stations, entrances and other
+// locations which are not boarded from are ignored.
+func GenerateTransfers(stops StopMap, maxDistanceMeters, walkingSpeed float64) TransferArray {
+	transfers := TransferArray{}
+	if maxDistanceMeters <= 0 {
+		return transfers
+	}
+	if walkingSpeed <= 0 {
+		walkingSpeed = DefaultWalkingSpeed
+	}
+
+	// Bucket the stops into cells at least the distance wide, so only neighbouring cells are compared
+	maxLatitude := 0.0
+	for _, stop := range stops {
+		maxLatitude = max(maxLatitude, math.Abs(stop.Location.Latitude))
+	}
+	latitudeCell := maxDistanceMeters / metresPerDegree
+	longitudeCell := latitudeCell / math.Max(math.Cos(math.Min(maxLatitude, 89)*math.Pi/180), 0.01)
+	type cell struct{ x, y int }
+	cellOf := func(c Coordinate) cell {
+		return cell{int(math.Floor(c.Longitude / longitudeCell)), int(math.Floor(c.Latitude / latitudeCell))}
+	}
+
+	cells := make(map[cell][]*Stop)
+	ids := sortedIDs(stops)
+	for _, id := range ids {
+		stop := stops[id]
+		if stop.LocationType != StopLocationType && stop.LocationType != BoardingAreaLocationType {
+			continue
+		}
+		c := cellOf(stop.Location)
+		cells[c] = append(cells[c], stop)
+	}
+
+	for _, id := range ids {
+		stop := stops[id]
+		if stop.LocationType != StopLocationType && stop.LocationType != BoardingAreaLocationType {
+			continue
+		}
+		c := cellOf(stop.Location)
+		for dx := -1; dx <= 1; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				for _, other := range cells[cell{c.x + dx, c.y + dy}] {
+					if other.ID == stop.ID {
+						continue
+					}
+					distance := stop.Location.DistanceTo(other.Location)
+					if distance > maxDistanceMeters {
+						continue
+					}
+					transfers = append(transfers, Transfer{
+						FromStopID:      stop.ID,
+						ToStopID:        other.ID,
+						Type:            MinimumTimeTransferType,
+						MinTransferTime: uint(math.Ceil(distance / walkingSpeed)),
+						Generated:       true,
+					})
+				}
+			}
+		}
+	}
+	return transfers
+}
+
+// Store the transfers in the transfers bucket, grouped by the stop they are from
+func populateTransfers(db *bolt.DB, transfers TransferArray) error {
+	if len(transfers) == 0 {
+		return nil
+	}
+
+	byStop := make(map[Key]TransferArray)
+	for _, transfer := range transfers {
+		byStop[transfer.FromStopID] = append(byStop[transfer.FromStopID], transfer)
+	}
+	return db.Batch(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("transfers"))
+		if err != nil {
+			return err
+		}
+		for stopID, stopTransfers := range byStop {
+			err = b.Put([]byte(stopID), stopTransfers.Encode())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Returns the transfers from the stop, which are empty if the feed has none and none were generated
+func (g *GTFS) GetTransfersFromStop(stopID Key) (TransferArray, error) {
+	transfers := TransferArray{}
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("transfers"))
+		if b == nil {
+			return nil
+		}
+		data := b.Get([]byte(stopID))
+		if data == nil {
+			return nil
+		}
+		return transfers.Decode(data)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
+
+// Returns all transfers, keyed by the stop they are from
+func (g *GTFS) getAllTransfers() (map[Key]TransferArray, error) {
+	transfers := make(map[Key]TransferArray)
+	err := g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("transfers"))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var stopTransfers TransferArray
+			err := stopTransfers.Decode(v)
+			if err != nil {
+				return err
+			}
+			transfers[Key(k)] = stopTransfers
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
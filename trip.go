@@ -2,12 +2,16 @@ package gtfs
 
 import (
 	"encoding/binary"
-	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 type TripDirection bool
@@ -28,14 +32,22 @@ type TripStop struct {
 	ArrivalTime   uint          `json:"arrival_time"`
 	DepartureTime uint          `json:"departure_time"`
 	Timepoint     TripTimepoint `json:"timepoint"`
+	Occupancy     *uint         `json:"avg_occupancy,omitempty"` // Average passengers on board when departing, if known
+	Sequence      *uint         `json:"stop_sequence,omitempty"` // Raw stop_sequence, if kept (see ParseOptions.KeepStopSequences)
 }
 
+// Value of the encoded occupancy of a TripStop whose occupancy is unknown but whose sequence follows it
+const unknownOccupancy = math.MaxUint32
+
 // Encodes the TripStop struct into a byte slice
 // Format:
 // - StopID: 4-byte length + UTF-8 string
 // - ArrivalTime: 4 bytes (uint32)
 // - DepartureTime: 4 bytes (uint32)
 // - Timepoint: 1 byte (bool as uint8)
+// - Occupancy: 4 bytes (uint32), only present if known or followed by Sequence, in which case an unknown
+// occupancy is written as unknownOccupancy
+// - Sequence: 4 bytes (uint32), only present if kept
 func (ts *TripStop) Encode() []byte {
 	stopIDStr := string(ts.StopID)
 
@@ -44,6 +56,12 @@ func (ts *TripStop) Encode() []byte {
 		uint32Bytes + // ArrivalTime
 		uint32Bytes + // DepartureTime
 		boolBytes // Timepoint
+	if ts.Occupancy != nil || ts.Sequence != nil {
+		totalLen += uint32Bytes // Occupancy
+	}
+	if ts.Sequence != nil {
+		totalLen += uint32Bytes // Sequence
+	}
 
 	data := make([]byte, totalLen)
 	offset := 0
@@ -68,6 +86,21 @@ func (ts *TripStop) Encode() []byte {
 	} else {
 		data[offset] = 0
 	}
+	offset += boolBytes
+
+	// Marshal Occupancy (as uint32)
+	if ts.Occupancy != nil {
+		binary.BigEndian.PutUint32(data[offset:], uint32(*ts.Occupancy))
+		offset += uint32Bytes
+	} else if ts.Sequence != nil {
+		binary.BigEndian.PutUint32(data[offset:], unknownOccupancy)
+		offset += uint32Bytes
+	}
+
+	// Marshal Sequence (as uint32)
+	if ts.Sequence != nil {
+		binary.BigEndian.PutUint32(data[offset:], uint32(*ts.Sequence))
+	}
 
 	return data
 }
@@ -118,6 +151,24 @@ func (ts *TripStop) Decode(data []byte) error {
 	}
 	offset += boolBytes
 
+	// Unmarshal Occupancy, which is absent if unknown unless Sequence follows it
+	ts.Occupancy = nil
+	if offset+uint32Bytes <= len(data) {
+		if encoded := binary.BigEndian.Uint32(data[offset:]); encoded != unknownOccupancy {
+			occupancy := uint(encoded)
+			ts.Occupancy = &occupancy
+		}
+		offset += uint32Bytes
+	}
+
+	// Unmarshal Sequence, which is absent unless kept
+	ts.Sequence = nil
+	if offset+uint32Bytes <= len(data) {
+		sequence := uint(binary.BigEndian.Uint32(data[offset:]))
+		ts.Sequence = &sequence
+		offset += uint32Bytes
+	}
+
 	// Check if all data was consumed
 	if offset != len(data) {
 		return errors.New("tripstop buffer not fully consumed, trailing data exists")
@@ -125,6 +176,54 @@ func (ts *TripStop) Decode(data []byte) error {
 	return nil
 }
 
+// Encodes the TripStop struct into protobuf wire format
+// See the TripStop message in gtfs.proto.
+func (ts *TripStop) EncodeProto() []byte {
+	var data []byte
+	data = appendProtoString(data, 1, string(ts.StopID))
+	data = appendProtoVarint(data, 2, uint64(ts.ArrivalTime))
+	data = appendProtoVarint(data, 3, uint64(ts.DepartureTime))
+	data = appendProtoBool(data, 4, bool(ts.Timepoint))
+	if ts.Occupancy != nil {
+		// Written even if zero, as an empty vehicle differs from an unknown occupancy
+		data = protowire.AppendTag(data, 5, protowire.VarintType)
+		data = protowire.AppendVarint(data, uint64(*ts.Occupancy))
+	}
+	if ts.Sequence != nil {
+		data = protowire.AppendTag(data, 6, protowire.VarintType)
+		data = protowire.AppendVarint(data, uint64(*ts.Sequence))
+	}
+	return data
+}
+
+// Decodes protobuf wire format data into the TripStop struct
+func (ts *TripStop) DecodeProto(data []byte) error {
+	if ts == nil {
+		return errors.New("cannot decode into a nil TripStop")
+	}
+	*ts = TripStop{}
+
+	return decodeProtoFields(data, func(num protowire.Number, v protoValue) error {
+		switch num {
+		case 1:
+			ts.StopID = Key(v.string())
+		case 2:
+			ts.ArrivalTime = uint(v.varint)
+		case 3:
+			ts.DepartureTime = uint(v.varint)
+		case 4:
+			ts.Timepoint = TripTimepoint(v.bool())
+		case 5:
+			occupancy := uint(v.varint)
+			ts.Occupancy = &occupancy
+		case 6:
+			sequence := uint(v.varint)
+			ts.Sequence = &sequence
+		}
+		return nil
+	})
+}
+
 type TripStopArray []*TripStop
 
 // Encode the TripStopArray into a byte slice
@@ -163,11 +262,25 @@ func (tsa *TripStopArray) Decode(data []byte) error {
 	if tsa == nil {
 		return errors.New("cannot decode into a nil TripStopArray")
 	}
+	offset, err := tsa.decodePrefix(data)
+	if err != nil {
+		return err
+	}
+
+	// Check if all data was consumed
+	if offset != len(data) {
+		return errors.New("tripstoparray buffer not fully consumed, trailing data exists")
+	}
+	return nil
+}
+
+// Decode the TripStopArray at the start of the byte slice, returning the number of bytes consumed
+func (tsa *TripStopArray) decodePrefix(data []byte) (int, error) {
 	offset := 0
 
 	// Unmarshal count
 	if offset+lenBytes > len(data) {
-		return errors.New("tripstoparray buffer too small for count")
+		return 0, errors.New("tripstoparray buffer too small for count")
 	}
 	count := binary.BigEndian.Uint32(data[offset:])
 	offset += lenBytes
@@ -177,14 +290,14 @@ func (tsa *TripStopArray) Decode(data []byte) error {
 	for i := uint32(0); i < count; i++ {
 		// Unmarshal length of the current TripStop's data
 		if offset+lenBytes > len(data) {
-			return fmt.Errorf("tripstoparray buffer too small for TripStop %d data length", i)
+			return 0, fmt.Errorf("tripstoparray buffer too small for TripStop %d data length", i)
 		}
 		tripStopDataLen := binary.BigEndian.Uint32(data[offset:])
 		offset += lenBytes
 
 		// Unmarshal the TripStop's data
 		if offset+int(tripStopDataLen) > len(data) {
-			return fmt.Errorf("tripstoparray buffer too small for TripStop %d content (expected %d bytes)", i, tripStopDataLen)
+			return 0, fmt.Errorf("tripstoparray buffer too small for TripStop %d content (expected %d bytes)", i, tripStopDataLen)
 		}
 
 		currentTripStopData := data[offset : offset+int(tripStopDataLen)]
@@ -192,18 +305,22 @@ func (tsa *TripStopArray) Decode(data []byte) error {
 		var tripStop TripStop                       // Create a value
 		err := tripStop.Decode(currentTripStopData) // Decode into the value
 		if err != nil {
-			return fmt.Errorf("failed to decode TripStop %d: %w", i, err)
+			return 0, fmt.Errorf("failed to decode TripStop %d: %w", i, err)
 		}
 		tempTsa[i] = &tripStop // Store pointer to the decoded value
 		offset += int(tripStopDataLen)
 	}
 	*tsa = tempTsa
+	return offset, nil
+}
 
-	// Check if all data was consumed
-	if offset != len(data) {
-		return errors.New("tripstoparray buffer not fully consumed, trailing data exists")
+// Returns the key of a route and direction in the tripsByRouteDirectionIndex bucket, joining the route ID
+// and the direction as "0" (outbound) or "1" (inbound)
+func routeDirectionKey(routeID Key, dir TripDirection) []byte {
+	if dir == InboundTripDirection {
+		return compositeKey([]byte(routeID), []byte("1"))
 	}
-	return nil
+	return compositeKey([]byte(routeID), []byte("0"))
 }
 
 // Intermediate structure to hold trip stop sequences
@@ -212,15 +329,22 @@ type tripStopSequence struct {
 	Sequence uint
 }
 
+// Identifies a row of stop_times.txt, used to detect duplicate rows
+type stopTimeKey struct {
+	TripID   Key
+	Sequence uint
+}
+
 // Represents a trip on a particular route in a transit system
 type Trip struct {
-	ID        Key
-	RouteID   Key
-	ServiceID Key
-	ShapeID   Key
-	Direction TripDirection
-	Headsign  string
-	Stops     TripStopArray
+	ID        Key           `json:"trip_id"`
+	RouteID   Key           `json:"route_id"`
+	ServiceID Key           `json:"service_id"`
+	ShapeID   Key           `json:"shape_id"`
+	Direction TripDirection `json:"direction"`
+	Headsign  string        `json:"trip_headsign"`
+	Stops     TripStopArray `json:"stops"`
+	Capacity  uint          `json:"capacity,omitempty"` // Passengers the vehicle can carry, or 0 if unknown
 }
 type TripMap map[Key]*Trip
 
@@ -232,6 +356,7 @@ type TripMap map[Key]*Trip
 // - Direction: 1 byte (bool as uint8)
 // - Headsign: 4-byte length + UTF-8 string
 // - Stops: TripStopArray (see TripStopArray.Encode)
+// - Capacity: 4 bytes (uint32), only present if known
 func (t Trip) Encode() []byte {
 	routeIDStr := string(t.RouteID)
 	serviceIDStr := string(t.ServiceID)
@@ -247,6 +372,9 @@ func (t Trip) Encode() []byte {
 		boolBytes + // Direction
 		lenBytes + len(headsignStr) + // Headsign
 		len(stopsBytes) // Encoded Stops data
+	if t.Capacity > 0 {
+		totalLen += uint32Bytes // Capacity
+	}
 
 	data := make([]byte, totalLen)
 	offset := 0
@@ -285,7 +413,12 @@ func (t Trip) Encode() []byte {
 
 	// Append encoded Stops data
 	copy(data[offset:], stopsBytes)
-	// offset += len(stopsBytes) // Not strictly needed as it's the last part
+	offset += len(stopsBytes)
+
+	// Marshal Capacity (as uint32)
+	if t.Capacity > 0 {
+		binary.BigEndian.PutUint32(data[offset:], uint32(t.Capacity))
+	}
 
 	return data
 }
@@ -361,20 +494,90 @@ func (t *Trip) Decode(id Key, data []byte) error {
 	t.Headsign = string(data[offset : offset+int(headsignLen)])
 	offset += int(headsignLen)
 
-	// The rest of the data belongs to Stops
+	// Unmarshal Stops
 	if offset > len(data) {
 		return errors.New("offset beyond data length before decoding Stops")
 	}
-	stopsData := data[offset:]
-	err := t.Stops.Decode(stopsData)
+	stopsLen, err := t.Stops.decodePrefix(data[offset:])
 	if err != nil {
 		return fmt.Errorf("failed to decode Stops for Trip: %w", err)
 	}
-	// Trip.Decode has processed all parts. TripStopArray.Decode ensures its data is consumed.
+	offset += stopsLen
+
+	// Unmarshal Capacity, which is absent if unknown
+	t.Capacity = 0
+	if offset+uint32Bytes <= len(data) {
+		t.Capacity = uint(binary.BigEndian.Uint32(data[offset:]))
+		offset += uint32Bytes
+	}
+
+	// Check if all data was consumed
+	if offset != len(data) {
+		return errors.New("trip buffer not fully consumed, trailing data exists")
+	}
 	return nil
 }
 
-// Get the time that a trip starts at the first stop
+// EncodeProto serializes the Trip struct (excluding ID) into protobuf wire format.
+// See the Trip message in gtfs.proto.
+func (t Trip) EncodeProto() []byte {
+	var data []byte
+	data = appendProtoString(data, 1, string(t.RouteID))
+	data = appendProtoString(data, 2, string(t.ServiceID))
+	data = appendProtoString(data, 3, string(t.ShapeID))
+	data = appendProtoBool(data, 4, bool(t.Direction))
+	data = appendProtoString(data, 5, t.Headsign)
+	for _, tripStop := range t.Stops {
+		data = appendProtoMessage(data, 6, tripStop.EncodeProto())
+	}
+	data = appendProtoVarint(data, 7, uint64(t.Capacity))
+	return data
+}
+
+// DecodeProto deserializes protobuf wire format data into the Trip struct.
+func (t *Trip) DecodeProto(id Key, data []byte) error {
+	if t == nil {
+		return errors.New("cannot decode into a nil Trip")
+	}
+	*t = Trip{ID: id, Stops: TripStopArray{}}
+
+	return decodeProtoFields(data, func(num protowire.Number, v protoValue) error {
+		switch num {
+		case 1:
+			t.RouteID = Key(v.string())
+		case 2:
+			t.ServiceID = Key(v.string())
+		case 3:
+			t.ShapeID = Key(v.string())
+		case 4:
+			t.Direction = TripDirection(v.bool())
+		case 5:
+			t.Headsign = v.string()
+		case 6:
+			tripStop := &TripStop{}
+			err := tripStop.DecodeProto(v.bytes)
+			if err != nil {
+				return fmt.Errorf("failed to decode TripStop %d: %w", len(t.Stops), err)
+			}
+			t.Stops = append(t.Stops, tripStop)
+		case 7:
+			t.Capacity = uint(v.varint)
+		}
+		return nil
+	})
+}
+
+// Position of a stop within a trip
+type TripStopPosition struct {
+	Index          int  // Index of the stop in the trip's stops
+	ArrivalTime    uint // Seconds since midnight of the service day
+	DepartureTime  uint // Seconds since midnight of the service day
+	RemainingStops TripStopArray
+}
+
+// Get the time that a trip starts at the first stop, in seconds since the start of the service day
+//
+// Deprecated: Use StartTimeOn for the instant the trip starts on a given service date.
 func (t *Trip) StartTime() uint {
 	if len(t.Stops) == 0 {
 		return 0
@@ -382,7 +585,9 @@ func (t *Trip) StartTime() uint {
 	return t.Stops[0].ArrivalTime
 }
 
-// Get the time that a trip ends at the last stop
+// Get the time that a trip ends at the last stop, in seconds since the start of the service day
+//
+// Deprecated: Use EndTimeOn for the instant the trip ends on a given service date.
 func (t *Trip) EndTime() uint {
 	if len(t.Stops) == 0 {
 		return 0
@@ -390,6 +595,153 @@ func (t *Trip) EndTime() uint {
 	return t.Stops[len(t.Stops)-1].DepartureTime
 }
 
+// Get the start of the given service day in the given timezone, from which stop times are measured.
+// As in the GTFS specification, this is noon minus 12 hours, so stop times remain correct on days
+// with daylight saving transitions.
+func serviceDayStart(date time.Time, loc *time.Location) time.Time {
+	year, month, day := date.Date()
+	return time.Date(year, month, day, 12, 0, 0, 0, loc).Add(-12 * time.Hour)
+}
+
+// Get the start of the service day the given number of days after the one starting at day.
+// Days are stepped from noon, since stepping from the start itself would carry the offset of a
+// daylight saving transition into the following days.
+func addServiceDays(day time.Time, days int) time.Time {
+	return day.Add(12*time.Hour).AddDate(0, 0, days).Add(-12 * time.Hour)
+}
+
+// Get the length in seconds of the service day starting at day, which is 23 or 25 hours
+// on days with daylight saving transitions
+func serviceDayLength(day time.Time) int {
+	return int(addServiceDays(day, 1).Sub(day).Seconds())
+}
+
+// Returns the instant of a stop time given in seconds since the start of the given service date, in the given
+// timezone. Stop times are measured from noon minus 12 hours, so on days with daylight saving transitions
+// the wall-clock time differs from the stop time by the change in offset.
+func ResolveServiceTime(date time.Time, seconds uint, loc *time.Location) time.Time {
+	return serviceDayStart(date, loc).Add(time.Duration(seconds) * time.Second)
+}
+
+// Get the arrival time at each stop of the trip when run on the given service date, in the given timezone
+// (usually the agency's). Stop times past 24:00:00 fall on the following calendar day.
+func (t *Trip) StopTimesOn(date time.Time, loc *time.Location) []time.Time {
+	times := make([]time.Time, len(t.Stops))
+	for i, stop := range t.Stops {
+		times[i] = ResolveServiceTime(date, stop.ArrivalTime, loc)
+	}
+	return times
+}
+
+// Get the instant the trip starts at its first stop when run on the given service date, in the given timezone
+// (usually the agency's). Start times past 24:00:00 fall on the following calendar day.
+func (t *Trip) StartTimeOn(date time.Time, loc *time.Location) time.Time {
+	return ResolveServiceTime(date, t.StartTime(), loc)
+}
+
+// Get the instant the trip ends at its last stop when run on the given service date, in the given timezone
+// (usually the agency's). End times past 24:00:00 fall on the following calendar day.
+func (t *Trip) EndTimeOn(date time.Time, loc *time.Location) time.Time {
+	return ResolveServiceTime(date, t.EndTime(), loc)
+}
+
+// Returns the fare zones crossed by the trip, in the order they are entered. Consecutive stops in the
+// same zone are collapsed, so a zone appears again only if the trip leaves and re-enters it.
+// Stops without a zone are ignored.
+func (t *Trip) ZonesTraversed(g *GTFS) ([]Key, error) {
+	stopIDs := make([]Key, len(t.Stops))
+	for i, stop := range t.Stops {
+		stopIDs[i] = stop.StopID
+	}
+	stops, err := g.GetStopsByIDs(stopIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	zones := []Key{}
+	for _, stopID := range stopIDs {
+		stop, ok := stops[stopID]
+		if !ok {
+			return nil, fmt.Errorf("stop %s %w", stopID, ErrNotFound)
+		}
+		if stop.ZoneID == "" {
+			continue
+		}
+		if len(zones) == 0 || zones[len(zones)-1] != stop.ZoneID {
+			zones = append(zones, stop.ZoneID)
+		}
+	}
+	return zones, nil
+}
+
+// Returns the highest average occupancy of the trip when departing any of its stops, and false if
+// the occupancy of none of them is known
+func (t *Trip) PeakOccupancy() (uint, bool) {
+	var peak uint
+	known := false
+	for _, stop := range t.Stops {
+		if stop.Occupancy != nil && (!known || *stop.Occupancy > peak) {
+			peak = *stop.Occupancy
+			known = true
+		}
+	}
+	return peak, known
+}
+
+// Returns the peak occupancy of the trip as a fraction of its capacity, which exceeds 1 for trips with
+// standing passengers beyond it, and false if either is unknown
+func (t *Trip) PeakLoad() (float64, bool) {
+	peak, ok := t.PeakOccupancy()
+	if !ok || t.Capacity == 0 {
+		return 0, false
+	}
+	return float64(peak) / float64(t.Capacity), true
+}
+
+// Returns the index in the trip's stops of the stop with the given raw stop_sequence, as used by realtime feeds
+// to identify stops, and false if there is none or the trip's sequences were not kept
+func (t *Trip) StopIndexBySequence(sequence uint) (int, bool) {
+	for i, stop := range t.Stops {
+		if stop.Sequence != nil && *stop.Sequence == sequence {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Returns the indices in the trip's stops after which stop_sequence values were skipped, such as the stops of
+// the full pattern an express run does not call at, and false if the trip's sequences were not kept. Feeds
+// which number stops in steps larger than one, e.g. 10, 20, 30, only have gaps where a step is larger.
+func (t *Trip) SequenceGaps() ([]int, bool) {
+	if len(t.Stops) == 0 {
+		return nil, false
+	}
+	steps := make([]uint, 0, len(t.Stops)-1)
+	for i, stop := range t.Stops {
+		if stop.Sequence == nil {
+			return nil, false
+		}
+		if i > 0 {
+			steps = append(steps, *stop.Sequence-*t.Stops[i-1].Sequence)
+		}
+	}
+
+	// The smallest step is taken as the feed's regular numbering
+	var regular uint
+	for _, step := range steps {
+		if step > 0 && (regular == 0 || step < regular) {
+			regular = step
+		}
+	}
+	gaps := []int{}
+	for i, step := range steps {
+		if step > regular {
+			gaps = append(gaps, i)
+		}
+	}
+	return gaps, true
+}
+
 // Parse time in HH:MM:SS format into seconds since midnight
 func parseTime(timeStr string) (uint, error) {
 	var hours, minutes, seconds uint
@@ -402,36 +754,69 @@ func parseTime(timeStr string) (uint, error) {
 
 // Load and parse trips from the GTFS trips.txt and stop_times.txt files
 func ParseTrips(tripsFile io.Reader, stopTimesFile io.Reader) (TripMap, error) {
-	// Read stop_times file using CSV reader
-	reader := csv.NewReader(stopTimesFile)
-	records, err := reader.ReadAll()
+	trips, _, err := ParseTripsWithOptions(tripsFile, stopTimesFile, ParseOptions{})
+	return trips, err
+}
+
+// Load and parse trips from the GTFS trips.txt and stop_times.txt files, handling malformed rows according
+// to the given options. The returned reports are for trips.txt and stop_times.txt, in that order.
+// If stopTimesFile is nil, every trip is returned without stops.
+func ParseTripsWithOptions(tripsFile io.Reader, stopTimesFile io.Reader, opts ParseOptions) (TripMap, []*FileReport, error) {
+	// Minimal feeds may omit stop_times.txt, in which case trips are kept without stops
+	requireStops := stopTimesFile != nil
+	if stopTimesFile == nil {
+		stopTimesFile = strings.NewReader("")
+	}
+
+	// Read stop_times file using CSV parser
+	parser, err := newCSVParser("stop_times.txt", stopTimesFile, opts)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	err = parser.require("trip_id", "stop_id", "stop_sequence", "arrival_time", "departure_time")
+	if err != nil {
+		return nil, nil, err
 	}
 
 	tripStops := make(map[Key][]*tripStopSequence)
-	for i, record := range records {
-		if i == 0 {
-			continue // skip header
+	seenStopTimes := make(map[stopTimeKey]bool)
+	for {
+		record, err := parser.next()
+		if err == io.EOF {
+			break
 		}
-
-		// Parse record into TripStop struct
-		tripID := Key(record[0])
-		stopID := Key(record[3])
-		arrivalTime, err := parseTime(record[1])
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		departureTime, err := parseTime(record[2])
-		if err != nil {
-			return nil, err
+
+		// Parse record into TripStop struct
+		tripID := Key(parser.get(record, "trip_id"))
+		stopID := Key(parser.get(record, "stop_id"))
+		arrivalStr := parser.get(record, "arrival_time")
+		departureStr := parser.get(record, "departure_time")
+		arrivalTime, arrivalErr := parseTime(arrivalStr)
+		departureTime, departureErr := parseTime(departureStr)
+		if err := errors.Join(arrivalErr, departureErr); err != nil {
+			// A missing time can only be repaired from the other time of the same stop
+			if !parser.canRepair() || (arrivalErr != nil && departureErr != nil) {
+				if err := parser.skip(parser.spec("invalid_time", "stop_times.arrival_time and stop_times.departure_time must be times (HH:MM:SS)", err)); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			if arrivalErr != nil {
+				arrivalTime = departureTime
+				parser.repair("invalid arrival_time %q, defaulted to departure_time", arrivalStr)
+			} else {
+				departureTime = arrivalTime
+				parser.repair("invalid departure_time %q, defaulted to arrival_time", departureStr)
+			}
 		}
 
-		timepointInt, err := strconv.Atoi(record[7])
+		timepointInt, err := strconv.Atoi(parser.get(record, "timepoint"))
 		if err != nil {
 			timepointInt = 0 // Default to 0 if conversion fails
 		}
-		// timepoint := TripTimepoint(timepointInt)
 		var timepoint TripTimepoint
 		if timepointInt == 0 {
 			timepoint = ApproximateTripTimepoint
@@ -439,54 +824,96 @@ func ParseTrips(tripsFile io.Reader, stopTimesFile io.Reader) (TripMap, error) {
 			timepoint = ExactTripTimepoint
 		}
 
-		sequenceInt, err := strconv.Atoi(record[0])
+		// Occupancy is an extension column, left unknown if missing or invalid
+		var occupancy *uint
+		if value, err := strconv.ParseUint(parser.get(record, "avg_occupancy"), 10, 32); err == nil && value != unknownOccupancy {
+			occupancyValue := uint(value)
+			occupancy = &occupancyValue
+		}
+
+		sequence, err := strconv.ParseUint(parser.get(record, "stop_sequence"), 10, 0)
 		if err != nil {
-			return nil, err
+			if err := parser.skip(parser.spec("invalid_integer", "stop_times.stop_sequence must be a non-negative integer", fmt.Errorf("invalid stop_sequence: %w", err))); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		seqKey := stopTimeKey{tripID, uint(sequence)}
+		if seenStopTimes[seqKey] {
+			parser.duplicate(fmt.Sprintf("%s %d", tripID, sequence))
 		}
+		seenStopTimes[seqKey] = true
 
 		if _, ok := tripStops[tripID]; !ok {
 			tripStops[tripID] = make([]*tripStopSequence, 0)
 		}
+		tripStop := &TripStop{
+			StopID:        stopID,
+			ArrivalTime:   arrivalTime,
+			DepartureTime: departureTime,
+			Timepoint:     timepoint,
+			Occupancy:     occupancy,
+		}
+		if opts.KeepStopSequences {
+			sequenceValue := uint(sequence)
+			tripStop.Sequence = &sequenceValue
+		}
 		tripStops[tripID] = append(tripStops[tripID], &tripStopSequence{
-			TripStop: &TripStop{
-				StopID:        stopID,
-				ArrivalTime:   arrivalTime,
-				DepartureTime: departureTime,
-				Timepoint:     timepoint,
-			},
-			Sequence: uint(sequenceInt),
+			TripStop: tripStop,
+			Sequence: uint(sequence),
 		})
 	}
+	stopTimesReport := parser.report
 
-	// Read trips file using CSV reader
-	reader = csv.NewReader(tripsFile)
-	records, err = reader.ReadAll()
+	// Read trips file using CSV parser
+	parser, err = newCSVParser("trips.txt", tripsFile, opts)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	err = parser.require("route_id", "service_id", "trip_id")
+	if err != nil {
+		return nil, nil, err
 	}
 
 	trips := make(TripMap)
-	for i, record := range records {
-		if i == 0 {
-			continue // skip header
+	for {
+		record, err := parser.next()
+		if err == io.EOF {
+			break
 		}
-
-		// Parse record into Trip struct
-		id := Key(record[2])
-		routeID := Key(record[0])
-		serviceID := Key(record[1])
-		shapeID := Key(record[5])
-		directionInt, err := strconv.Atoi(record[3])
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		var direction TripDirection
-		if directionInt == 0 {
-			direction = OutboundTripDirection
-		} else {
+
+		// Parse record into Trip struct
+		id := Key(parser.get(record, "trip_id"))
+		routeID := Key(parser.get(record, "route_id"))
+		serviceID := Key(parser.get(record, "service_id"))
+		shapeID := Key(parser.get(record, "shape_id"))
+		// The direction is optional, and trips without one are outbound
+		direction := OutboundTripDirection
+		switch directionStr := parser.get(record, "direction_id"); directionStr {
+		case "", "0":
+		case "1":
 			direction = InboundTripDirection
+		default:
+			if !parser.canRepair() {
+				err := fmt.Errorf("invalid direction_id %q", directionStr)
+				if err := parser.skip(parser.spec("unexpected_enum_value", "trips.direction_id must be 0 or 1", err)); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			parser.repair("invalid direction_id %q, defaulted to outbound", directionStr)
+		}
+		headSign := parser.get(record, "trip_headsign")
+
+		// Capacity is an extension column, left unknown if missing or invalid
+		capacity, err := strconv.ParseUint(parser.get(record, "capacity"), 10, 32)
+		if err != nil {
+			capacity = 0
 		}
-		headSign := record[4]
 
 		trip := &Trip{
 			ID:        id,
@@ -496,9 +923,10 @@ func ParseTrips(tripsFile io.Reader, stopTimesFile io.Reader) (TripMap, error) {
 			Direction: direction,
 			Headsign:  headSign,
 			Stops:     make([]*TripStop, 0),
+			Capacity:  uint(capacity),
 		}
 
-		if _, ok := tripStops[id]; !ok {
+		if _, ok := tripStops[id]; !ok && requireStops {
 			continue // skip if no stops found for this trip
 		}
 		tripStopSeqs := tripStops[id]
@@ -509,8 +937,11 @@ func ParseTrips(tripsFile io.Reader, stopTimesFile io.Reader) (TripMap, error) {
 			trip.Stops = append(trip.Stops, tripStopSeq.TripStop)
 		}
 
+		if _, exists := trips[id]; exists {
+			parser.duplicate(string(id))
+		}
 		trips[id] = trip
 	}
 
-	return trips, nil
+	return trips, []*FileReport{parser.report, stopTimesReport}, nil
 }
@@ -0,0 +1,178 @@
+package gtfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Width of the start time buckets of the tripsByStartIndex bucket
+const tripStartBucketSeconds = 15 * 60
+
+// Returns the key of a service and start time bucket in the tripsByStartIndex bucket, joining the service ID
+// and the bucket as 2 bytes (big-endian)
+func tripStartKey(serviceID Key, bucket int) []byte {
+	return compositeKey([]byte(serviceID), binary.BigEndian.AppendUint16(nil, uint16(bucket)))
+}
+
+// Returns the start time bucket of the trip, taking its start time within the day as trips are
+// matched to the current time of day
+func tripStartBucket(trip *Trip) int {
+	return int(trip.StartTime()%secondsInDay) / tripStartBucketSeconds
+}
+
+// Returns the longest time within the day spanned by the trip, as used to match it to the current time
+func tripDaySpan(trip *Trip) int {
+	start := int(trip.StartTime() % secondsInDay)
+	end := int(trip.EndTime() % secondsInDay)
+	if end < start {
+		end += secondsInDay
+	}
+	return end - start
+}
+
+// Store the tripsByStartIndex bucket, listing the numeric IDs of each service's trips by the bucket of
+// their start time, and the serviceTripSpans bucket, holding the longest span of each service's trips
+func populateTripStartIndex(tx *bolt.Tx, trips TripMap, tripIDs map[Key]uint32) error {
+	index := make(map[string]numericIDArray)
+	spans := make(map[Key]int)
+	for _, trip := range trips {
+		if len(trip.Stops) == 0 {
+			continue
+		}
+		key := string(tripStartKey(trip.ServiceID, tripStartBucket(trip)))
+		index[key] = append(index[key], tripIDs[trip.ID])
+		spans[trip.ServiceID] = max(spans[trip.ServiceID], tripDaySpan(trip))
+	}
+
+	b, err := tx.CreateBucketIfNotExists([]byte("tripsByStartIndex"))
+	if err != nil {
+		return err
+	}
+	for key, ids := range index {
+		err = b.Put([]byte(key), ids.Encode())
+		if err != nil {
+			return err
+		}
+	}
+
+	b, err = tx.CreateBucketIfNotExists([]byte("serviceTripSpans"))
+	if err != nil {
+		return err
+	}
+	for serviceID, span := range spans {
+		err = b.Put([]byte(serviceID), binary.BigEndian.AppendUint32(nil, uint32(span)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the IDs of the trips of the services running on the day of the given time which could be running
+// within the buffer of it, read from the tripsByStartIndex bucket. Trips which may run at that time due to
+// overrides, service changes or realtime updates are included, whether or not they do.
+func (g *GTFS) getCandidateCurrentTripIDs(t time.Time, buffer time.Duration) ([]Key, error) {
+	timezone, err := g.getFeedTimezone()
+	if err != nil {
+		return nil, err
+	}
+	t = t.In(timezone)
+	tSeconds := int(t.Sub(serviceDayStart(t, timezone)).Seconds())
+	bufferSeconds := int(buffer.Seconds())
+
+	services, err := g.GetAllServices()
+	if err != nil {
+		return nil, err
+	}
+	runningCache := make(map[Key]bool)
+	var running []Key
+	for serviceID := range services {
+		ok, err := g.isServiceRunning(serviceID, t, runningCache)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			running = append(running, serviceID)
+		}
+	}
+
+	var tripIDs []Key
+	err = g.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("tripsByStartIndex"))
+		if b == nil {
+			return errors.New("bucket not found")
+		}
+		spans := tx.Bucket([]byte("serviceTripSpans"))
+		if spans == nil {
+			return errors.New("bucket not found")
+		}
+
+		var ids numericIDArray
+		for _, serviceID := range running {
+			data := spans.Get([]byte(serviceID))
+			if data == nil {
+				continue
+			}
+			span := int(binary.BigEndian.Uint32(data))
+
+			// Trips starting up to their longest span before the interval could overlap it
+			first := floorDiv(tSeconds-bufferSeconds-span, tripStartBucketSeconds)
+			last := floorDiv(tSeconds+bufferSeconds, tripStartBucketSeconds)
+			bucketsInDay := secondsInDay / tripStartBucketSeconds
+			if last-first >= bucketsInDay {
+				first, last = 0, bucketsInDay-1
+			}
+
+			for bucket := first; bucket <= last; bucket++ {
+				data := b.Get(tripStartKey(serviceID, (bucket%bucketsInDay+bucketsInDay)%bucketsInDay))
+				if data == nil {
+					continue
+				}
+				var bucketIDs numericIDArray
+				err := bucketIDs.Decode(data)
+				if err != nil {
+					return err
+				}
+				ids = append(ids, bucketIDs...)
+			}
+		}
+
+		keys, err := numericKeys(tx, TripEntityType, ids)
+		if err != nil {
+			return err
+		}
+		tripIDs = keys
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Trips whose times or services may differ from those indexed
+	for tripID := range g.overrides.all(TripEntityType) {
+		tripIDs = append(tripIDs, tripID)
+	}
+	tripIDs = append(tripIDs, g.serviceChanges.added(t)...)
+	if g.realtime != nil {
+		updates, err := g.realtime.GetTripUpdates()
+		if err != nil {
+			return nil, err
+		}
+		for tripID := range updates {
+			tripIDs = append(tripIDs, tripID)
+		}
+	}
+	return tripIDs, nil
+}
+
+// Returns the quotient rounded towards negative infinity
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
@@ -0,0 +1,73 @@
+package gtfs
+
+import (
+	"slices"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Returns the IDs of the stops visited by the trip, in order of their first visit
+func (t *Trip) visitedStopIDs() []Key {
+	stopIDs := make([]Key, 0, len(t.Stops))
+	for _, stop := range t.Stops {
+		if !slices.Contains(stopIDs, stop.StopID) {
+			stopIDs = append(stopIDs, stop.StopID)
+		}
+	}
+	return stopIDs
+}
+
+// Check whether the trip visits the stop
+func (t *Trip) visitsStop(stopID Key) bool {
+	return slices.ContainsFunc(t.Stops, func(stop *TripStop) bool {
+		return stop.StopID == stopID
+	})
+}
+
+// Store the tripsByStopIndex bucket, listing the numeric IDs of the trips visiting each stop. Every trip is
+// indexed, whichever pattern of its route it follows, so that the trips serving a stop do not depend on the
+// stops derived for each route.
+func populateTripStopIndex(tx *bolt.Tx, trips TripMap, tripIDs map[Key]uint32) error {
+	index := make(map[Key]numericIDArray)
+	for _, trip := range trips {
+		for _, stopID := range trip.visitedStopIDs() {
+			index[stopID] = append(index[stopID], tripIDs[trip.ID])
+		}
+	}
+
+	b, err := tx.CreateBucketIfNotExists([]byte("tripsByStopIndex"))
+	if err != nil {
+		return err
+	}
+	for stopID, ids := range index {
+		err = b.Put([]byte(stopID), ids.Encode())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the trips visiting the stop, read from the tripsByStopIndex bucket, which are none if no trip
+// visits it. Overridden trips are matched by their overridden stops.
+func (g *GTFS) getTripsByStopID(stopID Key) (TripMap, error) {
+	tripIDs, err := g.getIndexedTripIDs("tripsByStopIndex", []byte(stopID))
+	if err != nil {
+		return nil, err
+	}
+
+	// Overrides may add the stop to trips which do not visit it in the database, or remove it
+	for tripID := range g.overrides.all(TripEntityType) {
+		tripIDs = append(tripIDs, tripID)
+	}
+	trips, err := g.GetTripsByIDs(tripIDs)
+	if err != nil {
+		return nil, err
+	}
+	for id, trip := range trips {
+		if !trip.visitsStop(stopID) {
+			delete(trips, id)
+		}
+	}
+	return trips, nil
+}
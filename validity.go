@@ -0,0 +1,88 @@
+package gtfs
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Period covered by a feed's services, from the first date any service runs to the last
+type FeedValidity struct {
+	Start      time.Time `json:"start"`      // First date, at midnight in the feed's timezone
+	End        time.Time `json:"end"`        // Last date (inclusive), at midnight in the feed's timezone
+	Services   int       `json:"services"`   // Number of services
+	Exceptions int       `json:"exceptions"` // Number of service exceptions
+}
+
+// Returns the period covered by the feed: the earliest start date and latest end date of its services,
+// extended by any exceptions adding service outside them. Exceptions removing service do not extend it.
+func (g *GTFS) FeedValidity() (*FeedValidity, error) {
+	services, err := g.GetAllServices()
+	if err != nil {
+		return nil, err
+	}
+	exceptions, err := g.GetAllServiceExceptions()
+	if err != nil {
+		return nil, err
+	}
+	timezone, err := g.getFeedTimezone()
+	if err != nil {
+		return nil, err
+	}
+
+	validity := &FeedValidity{Services: len(services), Exceptions: len(exceptions)}
+	extend := func(date time.Time) {
+		year, month, day := date.Date()
+		date = time.Date(year, month, day, 0, 0, 0, 0, timezone)
+		if validity.Start.IsZero() || date.Before(validity.Start) {
+			validity.Start = date
+		}
+		if validity.End.IsZero() || date.After(validity.End) {
+			validity.End = date
+		}
+	}
+	for _, service := range services {
+		extend(service.StartDate)
+		extend(service.EndDate)
+	}
+	for _, exception := range exceptions {
+		if exception.Type == AddedExceptionType {
+			extend(exception.Date)
+		}
+	}
+
+	if validity.Start.IsZero() {
+		return nil, errors.New("feed has no services")
+	}
+	return validity, nil
+}
+
+// Returns the number of days from the date of the given time to the last date of the feed, in the feed's
+// timezone. This is zero on the last date and negative once the feed has expired.
+func (v *FeedValidity) DaysRemaining(now time.Time) int {
+	return civilDay(v.End) - civilDay(now.In(v.End.Location()))
+}
+
+// Check if the feed has no service on the date of the given time or any later date
+func (v *FeedValidity) Expired(now time.Time) bool {
+	return v.DaysRemaining(now) < 0
+}
+
+// Returns warnings if, at the given time, the feed has expired, expires within the given number of days,
+// or has not yet started. There are no warnings for a feed valid for longer.
+func (v *FeedValidity) Warnings(now time.Time, withinDays int) []string {
+	warnings := []string{}
+	remaining := v.DaysRemaining(now)
+	switch {
+	case remaining < 0:
+		warnings = append(warnings, fmt.Sprintf("feed expired on %s, %d days ago", v.End.Format("2006-01-02"), -remaining))
+	case remaining == 0:
+		warnings = append(warnings, fmt.Sprintf("feed expires today (%s)", v.End.Format("2006-01-02")))
+	case remaining <= withinDays:
+		warnings = append(warnings, fmt.Sprintf("feed expires on %s, in %d days", v.End.Format("2006-01-02"), remaining))
+	}
+	if civilDay(now.In(v.Start.Location())) < civilDay(v.Start) {
+		warnings = append(warnings, fmt.Sprintf("feed is not valid until %s", v.Start.Format("2006-01-02")))
+	}
+	return warnings
+}